@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+const (
+	// admissionSoftLoad is the share of a depth limit past which producers
+	// are asked to slow down
+	admissionSoftLoad = 0.8
+	// admissionMaxDelay caps the delay suggested to producers
+	admissionMaxDelay = time.Minute
+	// admissionCacheTTL is how long the counts behind admission states
+	// are reused, so checking them on every submission stays cheap
+	admissionCacheTTL = time.Second
+	// drainRateWindow is how far back the rate workers finish tasks at is
+	// measured
+	drainRateWindow = 5 * time.Minute
+)
+
+// AdmissionState is how a submission of some priority would be received
+type AdmissionState string
+
+const (
+	// AdmissionOpen accepts submissions freely
+	AdmissionOpen AdmissionState = "open"
+	// AdmissionThrottle still accepts submissions, but the queue is close
+	// to a depth limit and producers should slow down
+	AdmissionThrottle AdmissionState = "throttle"
+	// AdmissionShed accepts submissions by failing lower priority tasks
+	AdmissionShed AdmissionState = "shed"
+	// AdmissionSpill accepts submissions into overflow storage
+	AdmissionSpill AdmissionState = "spill"
+	// AdmissionReject fails submissions with errs.ErrQueueFull
+	AdmissionReject AdmissionState = "reject"
+)
+
+// PriorityAdmission is how a submission of one priority would be received
+type PriorityAdmission struct {
+	Priority task.Priority  `json:"priority"`
+	State    AdmissionState `json:"state"`
+	// Pending and Limit describe the depth limit closest to being
+	// reached, if any, and Load is their ratio
+	Pending int64   `json:"pending"`
+	Limit   int64   `json:"limit,omitempty"`
+	Load    float64 `json:"load"`
+	// SuggestedDelayMs is how long producers should hold off, roughly the
+	// time workers need to bring the queue back under the throttle mark
+	SuggestedDelayMs int64 `json:"suggested_delay_ms"`
+}
+
+// SuggestedDelay returns SuggestedDelayMs as a duration
+func (a PriorityAdmission) SuggestedDelay() time.Duration {
+	return time.Duration(a.SuggestedDelayMs) * time.Millisecond
+}
+
+// Admission is the load shedding state producers can throttle themselves
+// by, before their submissions are rejected
+type Admission struct {
+	Policy OverflowPolicy `json:"policy,omitempty"`
+	// DrainRate is how many tasks per second workers finished lately
+	DrainRate float64 `json:"drain_rate"`
+	// Priorities holds the state of each priority class, most urgent
+	// first
+	Priorities []PriorityAdmission `json:"priorities"`
+}
+
+// admissionCache holds the counts admission states are worked out from
+type admissionCache struct {
+	mu     sync.Mutex
+	at     time.Time
+	counts map[task.Priority]int64
+	rate   float64
+}
+
+// Admission reports how submissions of each priority class would be
+// received under the depth limits. Per-queue limits, which depend on the
+// task's type, are not reflected.
+func (q *Queue) Admission(ctx context.Context) (*Admission, error) {
+	counts, rate, err := q.admissionCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a := &Admission{Policy: q.depth.Policy, DrainRate: rate}
+	for _, class := range task.Classes {
+		a.Priorities = append(a.Priorities, q.admissionFor(class, counts, rate))
+	}
+	return a, nil
+}
+
+// AdmissionFor reports how a submission of priority p would be received
+func (q *Queue) AdmissionFor(ctx context.Context, p task.Priority) (PriorityAdmission, error) {
+	counts, rate, err := q.admissionCounts(ctx)
+	if err != nil {
+		return PriorityAdmission{}, err
+	}
+	return q.admissionFor(p, counts, rate), nil
+}
+
+// admissionCounts returns the pending counts by priority and the drain
+// rate, reusing them for admissionCacheTTL. Without depth limits nothing
+// is counted.
+func (q *Queue) admissionCounts(ctx context.Context) (map[task.Priority]int64, float64, error) {
+	if !q.depth.enabled() || q.inProcess {
+		return nil, 0, nil
+	}
+
+	c := &q.admission
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := q.clock.Now()
+	if c.counts != nil && now.Sub(c.at) < admissionCacheTTL {
+		return c.counts, c.rate, nil
+	}
+
+	counts, err := q.storage.CountTasksByPriority(ctx, task.StatusPending)
+	if err != nil {
+		return nil, 0, err
+	}
+	from := now.Add(-drainRateWindow).Truncate(time.Minute)
+	minutes, err := q.storage.GetMinuteStats(ctx, from, now)
+	if err != nil {
+		return nil, 0, err
+	}
+	var finished int64
+	for _, m := range minutes {
+		finished += m.Completed + m.Failed
+	}
+
+	c.at, c.counts = now, counts
+	c.rate = float64(finished) / now.Sub(from).Seconds()
+	return c.counts, c.rate, nil
+}
+
+// admissionFor works out the state of priority p from the pending counts,
+// following the checks admit makes
+func (q *Queue) admissionFor(p task.Priority, counts map[task.Priority]int64, rate float64) PriorityAdmission {
+	a := PriorityAdmission{Priority: p, State: AdmissionOpen}
+	if counts == nil {
+		return a
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	priorityFull := false
+	atTotal := false
+	consider := func(pending, limit int64, isTotal bool) {
+		if limit <= 0 {
+			return
+		}
+		load := float64(pending) / float64(limit)
+		if !isTotal && pending >= limit {
+			priorityFull = true
+		}
+		if a.Limit == 0 || load > a.Load {
+			a.Pending, a.Limit, a.Load = pending, limit, load
+			atTotal = isTotal
+		}
+	}
+	consider(counts[p], q.depth.PerPriority[p], false)
+	consider(total, q.depth.Total, true)
+
+	switch {
+	case a.Load < admissionSoftLoad:
+		return a
+	case a.Load < 1:
+		a.State = AdmissionThrottle
+	case q.depth.Policy == OverflowSpill:
+		a.State = AdmissionSpill
+	case q.depth.Policy == OverflowShed && atTotal && !priorityFull && hasLower(counts, p):
+		a.State = AdmissionShed
+	default:
+		a.State = AdmissionReject
+	}
+
+	delay := admissionMaxDelay
+	if excess := float64(a.Pending) - admissionSoftLoad*float64(a.Limit); rate > 0 {
+		delay = time.Duration(math.Max(excess, 1) / rate * float64(time.Second))
+	}
+	if delay > admissionMaxDelay {
+		delay = admissionMaxDelay
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+	a.SuggestedDelayMs = delay.Milliseconds()
+	return a
+}
+
+// hasLower reports whether any tasks below priority p are pending, which
+// the shed policy could fail to make room
+func hasLower(counts map[task.Priority]int64, p task.Priority) bool {
+	for priority, n := range counts {
+		if priority < p && n > 0 {
+			return true
+		}
+	}
+	return false
+}
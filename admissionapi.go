@@ -0,0 +1,53 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+const (
+	// admissionStateHeader tells producers how a submission of their
+	// task's priority is received; see GET /api/v1/admission
+	admissionStateHeader = "X-Admission-State"
+	// suggestedDelayHeader is how many seconds producers should hold off
+	// before submitting more, when the queue is past its throttle mark
+	suggestedDelayHeader = "X-Suggested-Delay"
+)
+
+// handleAdmission reports the load shedding state and suggested delay of
+// each priority class, so producers can slow down before they are
+// rejected
+func (s *Server) handleAdmission(w http.ResponseWriter, r *http.Request) {
+	admission, err := s.queue.Admission(r.Context())
+	if err != nil {
+		s.logger.Error("failed to get admission state", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, admission)
+}
+
+// setAdmissionHeaders adds the admission state of priority p to a submit
+// response, and a Retry-After for the delay when the submission failed
+// with the queue full. They are left out if the state cannot be read, as
+// the submission's own outcome matters more.
+func (s *Server) setAdmissionHeaders(w http.ResponseWriter, r *http.Request, p task.Priority, full bool) {
+	a, err := s.queue.AdmissionFor(r.Context(), p)
+	if err != nil {
+		s.logger.Warn("failed to get admission state", zap.Error(err))
+		return
+	}
+	w.Header().Set(admissionStateHeader, string(a.State))
+	if a.SuggestedDelayMs == 0 {
+		return
+	}
+	seconds := strconv.Itoa(int(math.Ceil(a.SuggestedDelay().Seconds())))
+	w.Header().Set(suggestedDelayHeader, seconds)
+	if full {
+		w.Header().Set("Retry-After", seconds)
+	}
+}
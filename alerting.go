@@ -0,0 +1,387 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/signing"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Rule names used in alerts and as deduplication keys
+const (
+	RuleFailureRate      = "failure_rate"
+	RuleDeadLetterGrowth = "dead_letter_growth"
+	RuleOldestPending    = "oldest_pending_age"
+	RulePoisonTasks      = "poison_tasks"
+)
+
+// Thresholds configures when alerts fire. A zero value disables that rule.
+type Thresholds struct {
+	// FailureRate is the fraction of finished tasks that failed over
+	// FailureWindow, e.g. 0.2 for 20%
+	FailureRate float64
+	// FailureWindow defaults to 5 minutes
+	FailureWindow time.Duration
+	// MinFinished is how many tasks must finish in the window before the
+	// failure rate is judged, so a single failure does not page anyone
+	MinFinished int64
+	// DeadLetterGrowth is how many tasks may reach a terminal failure state
+	// (failed or expired) between two checks
+	DeadLetterGrowth int64
+	// OldestPending is how long a task may wait in pending
+	OldestPending time.Duration
+	// PoisonTasks alerts while any task was quarantined as poison over
+	// FailureWindow
+	PoisonTasks bool
+}
+
+// Config holds alert monitor configuration
+type Config struct {
+	Storage    storage.Storage
+	Logger     *zap.Logger
+	Thresholds Thresholds
+	Notifiers  []Notifier
+	// Interval between checks, defaults to 30 seconds
+	Interval time.Duration
+	// RepeatInterval re-sends a still firing alert, defaults to 1 hour
+	RepeatInterval time.Duration
+}
+
+// Alert describes a breached or resolved threshold
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Message   string    `json:"message"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Resolved  bool      `json:"resolved"`
+	Time      time.Time `json:"time"`
+}
+
+// Notifier delivers alerts to an external system
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Monitor periodically checks queue health against thresholds
+type Monitor struct {
+	config Config
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu         sync.Mutex
+	firing     map[string]time.Time // rule -> last notification
+	lastFailed int64
+	primed     bool
+}
+
+// NewMonitor creates a new alert monitor
+func NewMonitor(cfg Config) *Monitor {
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.RepeatInterval <= 0 {
+		cfg.RepeatInterval = time.Hour
+	}
+	if cfg.Thresholds.FailureWindow <= 0 {
+		cfg.Thresholds.FailureWindow = 5 * time.Minute
+	}
+
+	return &Monitor{
+		config: cfg,
+		logger: cfg.Logger,
+		now:    time.Now,
+		firing: make(map[string]time.Time),
+	}
+}
+
+// Run checks thresholds every interval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil {
+			m.logger.Error("alert check failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check evaluates every rule once and sends notifications for alerts that
+// started firing, are due a reminder, or resolved
+func (m *Monitor) Check(ctx context.Context) error {
+	breaches, err := m.evaluate(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	now := m.now()
+	var send []Alert
+	for _, rule := range []string{RuleFailureRate, RuleDeadLetterGrowth, RuleOldestPending, RulePoisonTasks} {
+		alert, breached := breaches[rule]
+		last, firing := m.firing[rule]
+		switch {
+		case breached && (!firing || now.Sub(last) >= m.config.RepeatInterval):
+			m.firing[rule] = now
+			send = append(send, alert)
+		case !breached && firing:
+			delete(m.firing, rule)
+			send = append(send, Alert{
+				Rule:     rule,
+				Message:  fmt.Sprintf("%s recovered", rule),
+				Resolved: true,
+				Time:     now,
+			})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, alert := range send {
+		m.notify(ctx, alert)
+	}
+	return nil
+}
+
+// evaluate returns the alerts for every rule currently over its threshold
+func (m *Monitor) evaluate(ctx context.Context) (map[string]Alert, error) {
+	th := m.config.Thresholds
+	now := m.now()
+	breaches := make(map[string]Alert)
+
+	if th.FailureRate > 0 {
+		minutes, err := m.config.Storage.GetMinuteStats(ctx, now.Add(-th.FailureWindow), now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get minute stats: %w", err)
+		}
+		var total storage.MinuteStats
+		for _, s := range minutes {
+			total.Merge(s)
+		}
+		finished := total.Completed + total.Failed
+		if finished > 0 && finished >= th.MinFinished {
+			rate := float64(total.Failed) / float64(finished)
+			if rate > th.FailureRate {
+				breaches[RuleFailureRate] = Alert{
+					Rule:      RuleFailureRate,
+					Message:   fmt.Sprintf("%.0f%% of tasks failed in the last %s (%d of %d)", rate*100, th.FailureWindow, total.Failed, finished),
+					Value:     rate,
+					Threshold: th.FailureRate,
+					Time:      now,
+				}
+			}
+		}
+	}
+
+	if th.DeadLetterGrowth > 0 {
+		counts, err := m.config.Storage.CountTasksByStatus(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count tasks: %w", err)
+		}
+		dead := counts[task.StatusFailed] + counts[task.StatusExpired]
+
+		m.mu.Lock()
+		growth := dead - m.lastFailed
+		primed := m.primed
+		m.lastFailed, m.primed = dead, true
+		m.mu.Unlock()
+
+		if primed && growth > th.DeadLetterGrowth {
+			breaches[RuleDeadLetterGrowth] = Alert{
+				Rule:      RuleDeadLetterGrowth,
+				Message:   fmt.Sprintf("%d tasks failed permanently since the last check (%d total)", growth, dead),
+				Value:     float64(growth),
+				Threshold: float64(th.DeadLetterGrowth),
+				Time:      now,
+			}
+		}
+	}
+
+	if th.OldestPending > 0 {
+		oldest, err := m.config.Storage.OldestTask(ctx, task.StatusPending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get oldest pending task: %w", err)
+		}
+		if oldest != nil {
+			if age := now.Sub(oldest.CreatedAt); age > th.OldestPending {
+				breaches[RuleOldestPending] = Alert{
+					Rule:      RuleOldestPending,
+					Message:   fmt.Sprintf("task %s (%s) has been pending for %s", oldest.ID, oldest.Type, age.Round(time.Second)),
+					Value:     age.Seconds(),
+					Threshold: th.OldestPending.Seconds(),
+					Time:      now,
+				}
+			}
+		}
+	}
+
+	if th.PoisonTasks {
+		minutes, err := m.config.Storage.GetMinuteStats(ctx, now.Add(-th.FailureWindow), now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get minute stats: %w", err)
+		}
+		var poisoned int64
+		for _, s := range minutes {
+			poisoned += s.Poisoned
+		}
+		if poisoned > 0 {
+			breaches[RulePoisonTasks] = Alert{
+				Rule:    RulePoisonTasks,
+				Message: fmt.Sprintf("%d tasks were quarantined as poison in the last %s", poisoned, th.FailureWindow),
+				Value:   float64(poisoned),
+				Time:    now,
+			}
+		}
+	}
+
+	return breaches, nil
+}
+
+// notify sends alert to every notifier, logging failures
+func (m *Monitor) notify(ctx context.Context, alert Alert) {
+	m.logger.Warn("alert",
+		zap.String("rule", alert.Rule),
+		zap.String("message", alert.Message),
+		zap.Bool("resolved", alert.Resolved),
+	)
+
+	for _, n := range m.config.Notifiers {
+		if err := n.Notify(ctx, alert); err != nil {
+			m.logger.Error("failed to send alert",
+				zap.String("rule", alert.Rule),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// WebhookNotifier posts alerts as JSON to a URL
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+	// Signer, if set, signs each request in the signing.Header so the
+	// receiver can check it came from this queue
+	Signer *signing.Keys
+}
+
+// Notify posts the alert as JSON
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, n.Client, n.URL, n.Headers, n.Signer, alert)
+}
+
+// SlackNotifier posts alerts to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// Notify posts the alert as a Slack message
+func (n *SlackNotifier) Notify(ctx context.Context, alert Alert) error {
+	prefix := ":rotating_light: *Task queue alert*"
+	if alert.Resolved {
+		prefix = ":white_check_mark: *Task queue alert resolved*"
+	}
+	msg := map[string]string{
+		"text": fmt.Sprintf("%s `%s`: %s", prefix, alert.Rule, alert.Message),
+	}
+	return postJSON(ctx, n.Client, n.WebhookURL, nil, nil, msg)
+}
+
+// pagerDutyURL is the PagerDuty Events API v2 endpoint
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier triggers and resolves PagerDuty incidents through the
+// Events API v2, one incident per rule
+type PagerDutyNotifier struct {
+	RoutingKey string
+	// Source identifies this deployment, defaults to "distributed-task-queue"
+	Source string
+	// URL overrides the Events API endpoint
+	URL    string
+	Client *http.Client
+}
+
+// Notify triggers or resolves the incident for the alert's rule
+func (n *PagerDutyNotifier) Notify(ctx context.Context, alert Alert) error {
+	url := n.URL
+	if url == "" {
+		url = pagerDutyURL
+	}
+	source := n.Source
+	if source == "" {
+		source = "distributed-task-queue"
+	}
+
+	event := map[string]interface{}{
+		"routing_key":  n.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    source + "/" + alert.Rule,
+	}
+	if alert.Resolved {
+		event["event_action"] = "resolve"
+	} else {
+		event["payload"] = map[string]interface{}{
+			"summary":   alert.Message,
+			"source":    source,
+			"severity":  "error",
+			"timestamp": alert.Time.Format(time.RFC3339),
+			"custom_details": map[string]float64{
+				"value":     alert.Value,
+				"threshold": alert.Threshold,
+			},
+		}
+	}
+	return postJSON(ctx, n.Client, url, nil, nil, event)
+}
+
+// postJSON posts body as JSON and fails on a non-2xx response
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, signer *signing.Keys, body interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if signer != nil {
+		signer.SignRequest(req, data)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func finish(t *testing.T, store storage.Storage, ok bool) {
+	ctx := context.Background()
+	tk := task.NewTask("alert_task", task.PriorityMedium, nil)
+	require.NoError(t, store.SaveTask(ctx, tk))
+	tk.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, tk))
+	if ok {
+		tk.MarkCompleted()
+	} else {
+		tk.MarkFailed(assert.AnError)
+	}
+	require.NoError(t, store.UpdateTask(ctx, tk))
+}
+
+func TestMonitor_FailureRate(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	notifier := &recordingNotifier{}
+	m := NewMonitor(Config{
+		Storage:    store,
+		Thresholds: Thresholds{FailureRate: 0.5, MinFinished: 3},
+		Notifiers:  []Notifier{notifier},
+	})
+	ctx := context.Background()
+
+	// Too few finished tasks to judge
+	finish(t, store, false)
+	finish(t, store, false)
+	require.NoError(t, m.Check(ctx))
+	assert.Empty(t, notifier.alerts)
+
+	finish(t, store, true)
+	require.NoError(t, m.Check(ctx))
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, RuleFailureRate, notifier.alerts[0].Rule)
+	assert.InDelta(t, 2.0/3.0, notifier.alerts[0].Value, 0.001)
+	assert.False(t, notifier.alerts[0].Resolved)
+
+	// Still firing, not repeated before the repeat interval
+	require.NoError(t, m.Check(ctx))
+	assert.Len(t, notifier.alerts, 1)
+
+	// Recovers once enough tasks succeed
+	finish(t, store, true)
+	finish(t, store, true)
+	require.NoError(t, m.Check(ctx))
+	require.Len(t, notifier.alerts, 2)
+	assert.True(t, notifier.alerts[1].Resolved)
+}
+
+func TestMonitor_DeadLetterGrowth(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	notifier := &recordingNotifier{}
+	m := NewMonitor(Config{
+		Storage:    store,
+		Thresholds: Thresholds{DeadLetterGrowth: 1},
+		Notifiers:  []Notifier{notifier},
+	})
+	ctx := context.Background()
+
+	// Failures from before the first check do not count as growth
+	finish(t, store, false)
+	finish(t, store, false)
+	require.NoError(t, m.Check(ctx))
+	assert.Empty(t, notifier.alerts)
+
+	finish(t, store, false)
+	require.NoError(t, m.Check(ctx))
+	assert.Empty(t, notifier.alerts)
+
+	finish(t, store, false)
+	finish(t, store, false)
+	require.NoError(t, m.Check(ctx))
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, RuleDeadLetterGrowth, notifier.alerts[0].Rule)
+	assert.Equal(t, 2.0, notifier.alerts[0].Value)
+}
+
+func TestMonitor_OldestPendingRepeats(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	notifier := &recordingNotifier{}
+	m := NewMonitor(Config{
+		Storage:        store,
+		Thresholds:     Thresholds{OldestPending: time.Minute},
+		Notifiers:      []Notifier{notifier},
+		RepeatInterval: 10 * time.Minute,
+	})
+	ctx := context.Background()
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	old := task.NewTask("stuck", task.PriorityLow, nil)
+	old.CreatedAt = now.Add(-2 * time.Minute)
+	require.NoError(t, store.SaveTask(ctx, old))
+	require.NoError(t, store.SaveTask(ctx, task.NewTask("fresh", task.PriorityHigh, nil)))
+
+	require.NoError(t, m.Check(ctx))
+	require.Len(t, notifier.alerts, 1)
+	assert.Contains(t, notifier.alerts[0].Message, old.ID)
+
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, m.Check(ctx))
+	assert.Len(t, notifier.alerts, 1)
+
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, m.Check(ctx))
+	assert.Len(t, notifier.alerts, 2)
+}
+
+func TestNotifiers(t *testing.T) {
+	var got []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		got = append(got, body)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	alert := Alert{Rule: RuleOldestPending, Message: "task t1 has been pending for 5m0s", Time: time.Now()}
+
+	require.NoError(t, (&SlackNotifier{WebhookURL: srv.URL}).Notify(ctx, alert))
+	assert.Contains(t, got[0]["text"], "task t1 has been pending")
+
+	pd := &PagerDutyNotifier{RoutingKey: "key", URL: srv.URL}
+	require.NoError(t, pd.Notify(ctx, alert))
+	assert.Equal(t, "trigger", got[1]["event_action"])
+	assert.Equal(t, "distributed-task-queue/oldest_pending_age", got[1]["dedup_key"])
+
+	alert.Resolved = true
+	require.NoError(t, pd.Notify(ctx, alert))
+	assert.Equal(t, "resolve", got[2]["event_action"])
+	assert.Nil(t, got[2]["payload"])
+
+	require.NoError(t, (&WebhookNotifier{URL: srv.URL}).Notify(ctx, alert))
+	assert.Equal(t, RuleOldestPending, got[3]["rule"])
+	assert.Equal(t, true, got[3]["resolved"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.Error(t, (&WebhookNotifier{URL: failing.URL}).Notify(ctx, alert))
+}
+
+func TestWebhookNotifier_Signed(t *testing.T) {
+	keys, err := signing.NewKeys("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = keys.Verify(r.Header.Get(signing.Header), body, time.Now(), signing.DefaultMaxSkew)
+	}))
+	defer srv.Close()
+
+	alert := Alert{Rule: RuleFailureRate, Message: "failure rate 50%", Time: time.Now()}
+	require.NoError(t, (&WebhookNotifier{URL: srv.URL, Signer: keys}).Notify(context.Background(), alert))
+	assert.NoError(t, verifyErr)
+
+	require.NoError(t, (&WebhookNotifier{URL: srv.URL}).Notify(context.Background(), alert))
+	assert.ErrorIs(t, verifyErr, signing.ErrMissing)
+}
+
+func TestMonitor_PoisonTasks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	notifier := &recordingNotifier{}
+	m := NewMonitor(Config{
+		Storage:    store,
+		Thresholds: Thresholds{PoisonTasks: true},
+		Notifiers:  []Notifier{notifier},
+	})
+	ctx := context.Background()
+
+	finish(t, store, false)
+	require.NoError(t, m.Check(ctx))
+	assert.Empty(t, notifier.alerts, "ordinary failures are not poison")
+
+	tk := task.NewTask("alert_task", task.PriorityMedium, nil)
+	require.NoError(t, store.SaveTask(ctx, tk))
+	tk.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, tk))
+	tk.Labels = map[string]string{task.PoisonLabel: "true"}
+	tk.MarkFailed(assert.AnError)
+	require.NoError(t, store.UpdateTask(ctx, tk))
+
+	require.NoError(t, m.Check(ctx))
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, RulePoisonTasks, notifier.alerts[0].Rule)
+	assert.Equal(t, 1.0, notifier.alerts[0].Value)
+}
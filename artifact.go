@@ -0,0 +1,140 @@
+// Package artifact stores files that task handlers produce, such as
+// exports, outside the task record, and hands out download URLs for them.
+package artifact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// ErrNoStore is returned by Attach when the queue has no artifact store
+var ErrNoStore = errors.New("no artifact store is configured")
+
+// Store keeps artifact contents
+type Store interface {
+	// Put saves size bytes read from body under key
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// URL returns a link that downloads key without credentials until
+	// expires has passed
+	URL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+type storeKey struct{}
+
+// WithStore returns a context carrying the store Attach uploads to
+func WithStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, store)
+}
+
+// StoreFromContext returns the store set by the queue, if any
+func StoreFromContext(ctx context.Context) (Store, bool) {
+	store, ok := ctx.Value(storeKey{}).(Store)
+	return store, ok
+}
+
+// Key returns where the artifact name of task id is stored
+func Key(id, name string) string {
+	return fmt.Sprintf("tasks/%s/%s", id, name)
+}
+
+// Attach uploads an artifact of t from inside its handler and records it
+// on t, to be saved with the task's outcome. Attaching a name again
+// replaces the earlier file.
+func Attach(ctx context.Context, t *task.Task, name string, body io.Reader, size int64, contentType string) error {
+	store, ok := StoreFromContext(ctx)
+	if !ok {
+		return ErrNoStore
+	}
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	key := Key(t.ID, name)
+	if err := store.Put(ctx, key, body, size, contentType); err != nil {
+		return fmt.Errorf("failed to store artifact %s: %w", name, err)
+	}
+	t.AddArtifact(task.Artifact{
+		Name:        name,
+		Key:         key,
+		Size:        size,
+		ContentType: contentType,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+// validName checks name is usable as the last part of a storage key
+func validName(name string) error {
+	switch {
+	case name == "" || len(name) > 200:
+		return fmt.Errorf("artifact name must be 1 to 200 characters")
+	case name == "." || name == ".." || strings.ContainsAny(name, "/\\"):
+		return fmt.Errorf("invalid artifact name %q", name)
+	}
+	return nil
+}
+
+// DirStore keeps artifacts in a local directory, for development and
+// single-host setups. Its URLs are file:// URLs, only useful on that host.
+type DirStore struct {
+	root string
+}
+
+// NewDirStore creates a store under root, creating the directory if needed
+func NewDirStore(root string) (*DirStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact directory: %w", err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	return &DirStore{root: root}, nil
+}
+
+// Put writes the artifact to a file
+func (d *DirStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.LimitReader(body, size)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// URL returns the file's URL; it does not expire
+func (d *DirStore) URL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+// path maps key to a file under the root
+func (d *DirStore) path(key string) (string, error) {
+	path := filepath.Join(d.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, d.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid artifact key %q", key)
+	}
+	return path, nil
+}
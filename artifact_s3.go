@@ -0,0 +1,119 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/sigv4"
+)
+
+// S3Config holds S3Store configuration
+type S3Config struct {
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Region defaults to us-east-1. Use "auto" for Google Cloud Storage.
+	Region string
+
+	// Endpoint defaults to https://s3.<Region>.amazonaws.com. Point it at
+	// https://storage.googleapis.com, with HMAC keys, for Google Cloud
+	// Storage, or at any other S3-compatible service.
+	Endpoint string
+
+	// PathStyle puts the bucket in the URL path rather than the host name,
+	// as MinIO and most self-hosted services need
+	PathStyle bool
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// S3Store keeps artifacts in an S3 bucket, or a bucket of any service
+// speaking the S3 API, and hands out presigned download URLs
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	signer   sigv4.Signer
+}
+
+// NewS3Store creates a store for cfg.Bucket
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 artifact store needs a bucket and credentials")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	return &S3Store{
+		config:   cfg,
+		endpoint: endpoint,
+		signer: sigv4.Signer{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Region:          cfg.Region,
+			Service:         "s3",
+		},
+	}, nil
+}
+
+// Put uploads the artifact
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.signer.Sign(req, sigv4.UnsignedPayload)
+
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 put %s: %s: %s", key, resp.Status, msg)
+	}
+	return nil
+}
+
+// URL presigns a download of key, valid for expires, at most 7 days
+func (s *S3Store) URL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("presigned URLs must expire within 7 days")
+	}
+	return s.signer.Presign(http.MethodGet, s.objectURL(key), expires).String(), nil
+}
+
+// objectURL returns the URL of key in the bucket
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.config.PathStyle {
+		path += "/" + s.config.Bucket
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	u.RawPath = path + "/" + sigv4.EscapePath(key)
+	return &u
+}
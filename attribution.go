@@ -0,0 +1,34 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// serviceHeader names the service submitting a request, for callers that
+// share an API key or have none
+const serviceHeader = "X-Service-Name"
+
+// maxServiceNameLength bounds client supplied service names
+const maxServiceNameLength = 64
+
+// requestSource identifies who sent a request, for a task's source and
+// duplicate stats: a fingerprint of its API key, so keys are never stored
+// or exported, else the service it names in X-Service-Name, else its IP
+func requestSource(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:4])
+	}
+	if name := strings.TrimSpace(r.Header.Get(serviceHeader)); name != "" && len(name) <= maxServiceNameLength {
+		return "service:" + name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,56 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// RunLabel is the label recording, on backfilled tasks, the missed run each
+// one stands in for, in UTC as 20060102T150405Z
+const RunLabel = "scheduled_for"
+
+const runLabelLayout = "20060102T150405Z"
+
+// Runs returns the times s comes round after from, up to and including to,
+// oldest first. It stops at limit runs, if limit is positive, and reports
+// whether there were more.
+func (s *Schedule) Runs(from, to time.Time, limit int) (runs []time.Time, more bool, err error) {
+	if !to.After(from) {
+		return nil, false, errs.Invalidf("the end of the range must be after its start")
+	}
+	next := from
+	for {
+		next, err = s.Next(next)
+		if err != nil || next.After(to) {
+			// A schedule that never runs again has no runs in the range
+			return runs, false, nil
+		}
+		if limit > 0 && len(runs) == limit {
+			return runs, true, nil
+		}
+		runs = append(runs, next.UTC())
+	}
+}
+
+// Backfill submits a task from sched's template for each of runs, labelled
+// with the run it stands in for. It returns the tasks submitted before any
+// error.
+func Backfill(ctx context.Context, q Queue, sched *Schedule, runs []time.Time) ([]*task.Task, error) {
+	submitted := make([]*task.Task, 0, len(runs))
+	for _, run := range runs {
+		t := newTask(q, sched)
+		t.Labels[RunLabel] = run.UTC().Format(runLabelLayout)
+		if err := q.Submit(ctx, t); err != nil {
+			metrics.ScheduledRuns.WithLabelValues(sched.ID, "failed").Inc()
+			return submitted, fmt.Errorf("failed to backfill the run at %s: %w", run.Format(time.RFC3339), err)
+		}
+		metrics.ScheduledRuns.WithLabelValues(sched.ID, "backfilled").Inc()
+		submitted = append(submitted, t)
+	}
+	return submitted, nil
+}
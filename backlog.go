@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"sort"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// Backlog is the work waiting for and held by workers, for autoscalers to
+// size the worker pool by
+type Backlog struct {
+	// Types lists the task types counted
+	Types []string `json:"types"`
+	// Pending tasks are ready for a worker, Processing ones running
+	Pending    int64 `json:"pending"`
+	Processing int64 `json:"processing"`
+}
+
+// Backlog counts the pending and processing tasks of taskType or, for an
+// empty taskType, of the types declared in queueName with
+// TypeConfig.Queue, DefaultQueue holding the types that name none. With
+// neither, every type is counted. Tasks whose producer gave them a queue
+// label of their own count with their type.
+func (q *Queue) Backlog(ctx context.Context, queueName, taskType string) (Backlog, error) {
+	pending, err := q.storage.CountTasksByType(ctx, task.StatusPending)
+	if err != nil {
+		return Backlog{}, err
+	}
+	processing, err := q.storage.CountTasksByType(ctx, task.StatusProcessing)
+	if err != nil {
+		return Backlog{}, err
+	}
+
+	var types []string
+	switch {
+	case taskType != "":
+		types = []string{taskType}
+	case queueName != "":
+		seen := make(map[string]bool)
+		for t := range q.TypeConfigs() {
+			seen[t] = true
+		}
+		for _, counts := range []map[string]int64{pending, processing} {
+			for t := range counts {
+				seen[t] = true
+			}
+		}
+		for t := range seen {
+			if q.queueOf(t) == queueName {
+				types = append(types, t)
+			}
+		}
+	default:
+		seen := make(map[string]bool)
+		for _, counts := range []map[string]int64{pending, processing} {
+			for t := range counts {
+				if !seen[t] {
+					seen[t] = true
+					types = append(types, t)
+				}
+			}
+		}
+	}
+	sort.Strings(types)
+
+	b := Backlog{Types: types}
+	if b.Types == nil {
+		b.Types = []string{}
+	}
+	for _, t := range types {
+		b.Pending += pending[t]
+		b.Processing += processing[t]
+	}
+	return b, nil
+}
@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// BatchHandler processes several tasks of one type in a single call. It
+// returns one error per task, in order; a nil slice means every task
+// succeeded.
+type BatchHandler func(ctx context.Context, tasks []*task.Task) []error
+
+// BatchOptions controls how tasks are gathered for a batch handler
+type BatchOptions struct {
+	// MaxSize is the most tasks handed to one call, defaults to 100
+	MaxSize int
+
+	// MaxWait is how long the first task of a batch waits for others
+	// before the batch runs anyway, defaults to 1s
+	MaxWait time.Duration
+}
+
+// RegisterBatchHandler registers handler for taskType. Tasks of the type
+// are gathered as workers pick them up and handed over together once
+// MaxSize have gathered or the oldest has waited MaxWait, so handlers
+// calling bulk APIs pay the per-call cost once per batch. Each task still
+// retries, fails or completes on its own, according to its error.
+func (q *Queue) RegisterBatchHandler(taskType string, handler BatchHandler, opts BatchOptions) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 100
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = time.Second
+	}
+
+	b := &batcher{taskType: taskType, handler: handler, opts: opts, wg: &q.wg}
+	b.run = func(ctx context.Context, batch []batchItem) {
+		q.runBatch(ctx, b, batch)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.batchers[taskType] = b
+	// The single-task form lets the type show up in Types and Versions
+	q.handlers[taskType] = func(ctx context.Context, t *task.Task) error {
+		return batchErrors(handler(ctx, []*task.Task{t}), 1)[0]
+	}
+	q.logger.Info("registered batch handler",
+		zap.String("type", taskType),
+		zap.Int("max_size", opts.MaxSize),
+		zap.Duration("max_wait", opts.MaxWait),
+	)
+}
+
+// batchItem is a task waiting in a batch
+type batchItem struct {
+	task    *task.Task
+	logger  *zap.Logger
+	started time.Time
+}
+
+// batcher gathers tasks for one batch handler
+type batcher struct {
+	taskType string
+	handler  BatchHandler
+	opts     BatchOptions
+	run      func(ctx context.Context, batch []batchItem)
+	wg       *sync.WaitGroup
+
+	mu      sync.Mutex
+	pending []batchItem
+	timer   *time.Timer
+	// gen counts batches taken, so a MaxWait timer that fires after its
+	// batch was taken leaves the next one alone
+	gen int
+}
+
+// add queues item, returning the batch to run if it is now full. The first
+// item of a batch starts the MaxWait timer, which runs the batch on its own
+// goroutine if it is still waiting then.
+func (b *batcher) add(ctx context.Context, item batchItem) []batchItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, item)
+	if len(b.pending) >= b.opts.MaxSize {
+		return b.take()
+	}
+	if len(b.pending) == 1 {
+		gen := b.gen
+		b.wg.Add(1)
+		b.timer = time.AfterFunc(b.opts.MaxWait, func() {
+			defer b.wg.Done()
+			b.mu.Lock()
+			if b.gen != gen {
+				b.mu.Unlock()
+				return
+			}
+			batch := b.take()
+			b.mu.Unlock()
+			b.run(ctx, batch)
+		})
+	}
+	return nil
+}
+
+// take empties the batch and returns it. Must be called with b.mu held.
+func (b *batcher) take() []batchItem {
+	if b.timer != nil && b.timer.Stop() {
+		b.wg.Done()
+	}
+	b.timer = nil
+	b.gen++
+
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+// flushBatches runs every partly filled batch straight away
+func (q *Queue) flushBatches(ctx context.Context) {
+	q.mu.RLock()
+	batchers := make([]*batcher, 0, len(q.batchers))
+	for _, b := range q.batchers {
+		batchers = append(batchers, b)
+	}
+	q.mu.RUnlock()
+
+	for _, b := range batchers {
+		b.mu.Lock()
+		batch := b.take()
+		b.mu.Unlock()
+		if len(batch) > 0 {
+			q.runBatch(ctx, b, batch)
+		}
+	}
+}
+
+// runBatch hands batch to its handler and records each task's outcome. The
+// call is cut short by the type's timeout from the oldest task's start, or the
+// earliest deadline in the batch if sooner.
+func (q *Queue) runBatch(ctx context.Context, b *batcher, batch []batchItem) {
+	tasks := make([]*task.Task, len(batch))
+	deadline := batch[0].started.Add(q.timeout(batch[0].task))
+	for i, item := range batch {
+		tasks[i] = item.task
+		if d := item.task.Deadline; d != nil && d.Before(deadline) {
+			deadline = *d
+		}
+	}
+
+	logger := q.logger.With(zap.String("type", b.taskType), zap.Int("batch_size", len(batch)))
+	logger.Info("processing batch")
+
+	batchCtx, cancel := context.WithTimeout(ctx, deadline.Sub(q.clock.Now()))
+	defer cancel()
+	batchCtx = task.WithLogger(batchCtx, logger)
+	batchCtx = q.withStores(batchCtx)
+
+	results := runBatchHandler(batchCtx, b.handler, tasks, logger)
+	now := q.clock.Now()
+	for i, item := range batch {
+		q.finish(ctx, item.task, results[i], now.Sub(item.started), item.logger)
+	}
+}
+
+// runBatchHandler calls handler, turning a panic into an error for every
+// task in the batch
+func runBatchHandler(ctx context.Context, handler BatchHandler, tasks []*task.Task, logger *zap.Logger) (results []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("batch handler panicked", zap.Any("panic", r), zap.Stack("stack"))
+			results = batchErrors([]error{fmt.Errorf("batch %w: %v", errHandlerPanicked, r)}, len(tasks))
+		}
+	}()
+	return batchErrors(handler(ctx, tasks), len(tasks))
+}
+
+// batchErrors normalizes a batch handler's results to n errors. A nil
+// slice means success for all; one error for a larger batch applies to
+// every task; any other length is a handler bug, reported on every task.
+func batchErrors(results []error, n int) []error {
+	switch len(results) {
+	case n:
+		return results
+	case 0:
+		return make([]error, n)
+	case 1:
+		all := make([]error, n)
+		for i := range all {
+			all[i] = results[0]
+		}
+		return all
+	default:
+		err := fmt.Errorf("batch handler returned %d results for %d tasks", len(results), n)
+		all := make([]error, n)
+		for i := range all {
+			all[i] = err
+		}
+		return all
+	}
+}
@@ -0,0 +1,220 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// enqueue hands t to the workers. With storage a full channel is fine, as
+// the poller finds the task later; in process mode the channel is the only
+// copy, so enqueue waits for room.
+func (q *Queue) enqueue(ctx context.Context, t *task.Task) error {
+	if !q.inProcess {
+		// Prefetch full, will be picked up by polling
+		q.offer(t)
+		return nil
+	}
+
+	select {
+	case q.taskChannels[t.Priority.Class()] <- t:
+		q.observeChannel(t.Priority.Class())
+		return nil
+	case <-q.stopChan:
+		return errs.ErrQueueStopped
+	case <-ctx.Done():
+		return fmt.Errorf("failed to enqueue task: %w", ctx.Err())
+	}
+}
+
+// schedule holds t back until at. With storage the poller releases it; in
+// process mode a timer puts it back on its channel.
+func (q *Queue) schedule(ctx context.Context, t *task.Task, at time.Time, logger *zap.Logger) error {
+	t.MarkScheduled(at)
+	if !q.inProcess {
+		return q.storage.UpdateTask(ctx, t)
+	}
+
+	time.AfterFunc(at.Sub(q.clock.Now()), func() {
+		t.Status = task.StatusPending
+		if err := q.enqueue(context.Background(), t); err != nil {
+			logger.Warn("dropping scheduled task", zap.Error(err))
+		}
+	})
+	return nil
+}
+
+// ready returns the highest priority task waiting on a channel, if any
+func (q *Queue) ready() (*task.Task, bool) {
+	for _, class := range task.Classes {
+		select {
+		case t := <-q.taskChannels[class]:
+			q.taken(t)
+			return t, true
+		default:
+		}
+	}
+	return nil, false
+}
+
+// offer puts a task from storage on its channel unless it is already there,
+// needs resource tags this process lacks, its handler is not ready or
+// prefetch is full, reporting whether there was room
+func (q *Queue) offer(t *task.Task) bool {
+	if !q.CanRun(t) || !q.handlerReady(t.Type) {
+		// Left for a worker that can run it
+		return true
+	}
+	q.bufferedMu.Lock()
+	defer q.bufferedMu.Unlock()
+
+	if _, ok := q.buffered[t.ID]; ok {
+		// Polled again while waiting for a worker
+		return true
+	}
+	if _, ok := q.claimed[t.ID]; ok {
+		// Polled again before its worker marked it started
+		return true
+	}
+	if len(q.buffered) >= q.prefetch || closed(q.stopChan) {
+		// Full, or stopping and handing tasks back
+		return false
+	}
+	select {
+	case q.taskChannels[t.Priority.Class()] <- t:
+		q.buffered[t.ID] = q.clock.Now()
+		metrics.TasksPrefetched.Set(float64(len(q.buffered)))
+		q.observeChannel(t.Priority.Class())
+		return true
+	default:
+		return false
+	}
+}
+
+// taken releases the prefetch slot of a task a worker took off its channel
+// and asks the poller to refill it. The task stays claimed until release,
+// so the poller does not offer it again while it still looks pending.
+func (q *Queue) taken(t *task.Task) {
+	q.observeChannel(t.Priority.Class())
+	if q.inProcess {
+		return
+	}
+	q.bufferedMu.Lock()
+	if at, ok := q.buffered[t.ID]; ok {
+		metrics.DispatchLag.Observe(q.clock.Now().Sub(at).Seconds())
+	}
+	delete(q.buffered, t.ID)
+	q.claimed[t.ID] = struct{}{}
+	metrics.TasksPrefetched.Set(float64(len(q.buffered)))
+	q.bufferedMu.Unlock()
+	q.refill()
+}
+
+// release forgets a task its worker has finished with
+func (q *Queue) release(t *task.Task) {
+	if q.inProcess {
+		return
+	}
+	q.bufferedMu.Lock()
+	delete(q.claimed, t.ID)
+	q.bufferedMu.Unlock()
+}
+
+// refill wakes the poller to top up prefetched tasks
+func (q *Queue) refill() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// discardStorage backs process mode: it keeps nothing, so lookups find
+// nothing and counts are empty
+type discardStorage struct{}
+
+func (discardStorage) SaveTask(ctx context.Context, t *task.Task) error   { return nil }
+func (discardStorage) UpdateTask(ctx context.Context, t *task.Task) error { return nil }
+func (discardStorage) DeleteTask(ctx context.Context, id string) error    { return nil }
+func (discardStorage) Close() error                                       { return nil }
+
+func (discardStorage) GetTask(ctx context.Context, id string) (*task.Task, error) {
+	return nil, errs.ErrTaskNotFound
+}
+
+func (discardStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	return nil, nil
+}
+
+func (discardStorage) GetTasksByLabels(ctx context.Context, status task.Status, labels map[string]string, limit int) ([]*task.Task, error) {
+	return nil, nil
+}
+
+func (discardStorage) GetTasksPage(ctx context.Context, status task.Status, labels map[string]string, cursor string, limit int) ([]*task.Task, string, error) {
+	return nil, "", nil
+}
+
+func (discardStorage) ScanTasks(ctx context.Context, filter storage.TaskFilter, cursor string, limit int) ([]*task.Task, string, error) {
+	return nil, "", nil
+}
+
+func (discardStorage) GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	return nil, nil
+}
+
+func (discardStorage) GetOverdueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	return nil, nil
+}
+
+func (discardStorage) OldestTask(ctx context.Context, status task.Status) (*task.Task, error) {
+	return nil, nil
+}
+
+func (discardStorage) RankTask(ctx context.Context, status task.Status, id string) (int64, error) {
+	return 0, errs.ErrTaskNotFound
+}
+
+func (discardStorage) CountTasksByStatus(ctx context.Context) (map[task.Status]int64, error) {
+	return map[task.Status]int64{}, nil
+}
+
+func (discardStorage) CountTasksByType(ctx context.Context, status task.Status) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (discardStorage) GetTasksByPriority(ctx context.Context, status task.Status, priority task.Priority, limit int) ([]*task.Task, error) {
+	return nil, nil
+}
+
+func (discardStorage) CountTasksByPriority(ctx context.Context, status task.Status) (map[task.Priority]int64, error) {
+	return map[task.Priority]int64{}, nil
+}
+
+func (discardStorage) GetMinuteStats(ctx context.Context, from, to time.Time) ([]storage.MinuteStats, error) {
+	return nil, nil
+}
+
+func (discardStorage) GetErrorStats(ctx context.Context, from, to time.Time) ([]storage.ErrorCount, error) {
+	return nil, nil
+}
+
+func (discardStorage) RecordDuplicate(ctx context.Context, taskType, producer string) error {
+	return nil
+}
+
+func (discardStorage) GetDuplicateStats(ctx context.Context, from, to time.Time) ([]storage.DuplicateCount, error) {
+	return nil, nil
+}
+
+func (discardStorage) AppendTaskLogs(ctx context.Context, id string, logs []byte, limit int) error {
+	return nil
+}
+
+func (discardStorage) GetTaskLogs(ctx context.Context, id string) ([]byte, error) {
+	return nil, nil
+}
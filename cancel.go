@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Cancel finishes a task for good without running it further. A waiting
+// task never runs. A running task's handler has its context cancelled
+// within CancelCheckInterval, and whatever it returns is discarded.
+// Finished tasks cannot be cancelled, and return ErrInvalidTransition.
+func (q *Queue) Cancel(ctx context.Context, id string) (*task.Task, error) {
+	t, err := q.storage.GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status.Terminal() {
+		return nil, fmt.Errorf("%w: task %s is %s", errs.ErrInvalidTransition, id, t.Status)
+	}
+
+	t.MarkCancelledAt(q.clock.Now())
+	if err := q.storage.UpdateTask(ctx, t); err != nil {
+		// A worker finished it meanwhile
+		return nil, err
+	}
+	metrics.TasksProcessed.WithLabelValues(t.Type, "cancelled").Inc()
+	q.observeLabels(t, "cancelled")
+	q.logger.Info("task cancelled", zap.String("id", t.ID), zap.String("type", t.Type))
+	q.notify(ctx, onFinish, t, q.logger)
+	return t, nil
+}
+
+// watchCancel checks storage every cancelCheckInterval while t's handler
+// runs, cancelling ctx if t was cancelled. The returned function stops
+// watching and reports whether it was.
+func (q *Queue) watchCancel(ctx context.Context, t *task.Task) (context.Context, func() bool) {
+	if q.cancelCheckInterval <= 0 {
+		return ctx, func() bool { return false }
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var cancelled atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(q.cancelCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current, err := q.storage.GetTask(ctx, t.ID)
+				if err == nil && current.Status == task.StatusCancelled {
+					cancelled.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, func() bool {
+		cancel()
+		<-done
+		return cancelled.Load()
+	}
+}
@@ -0,0 +1,193 @@
+// Package chaos injects faults into storage and task handlers so
+// integration tests can check that handlers are idempotent and the queue
+// recovers. Nothing is injected unless Config.Enabled is set.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// Config controls which faults are injected. Rates are probabilities
+// between 0 and 1.
+type Config struct {
+	// Enabled turns fault injection on; when false the wrappers return
+	// what they were given
+	Enabled bool
+	// StorageLatency is added to every storage call
+	StorageLatency time.Duration
+	// StorageJitter adds up to this much extra random latency
+	StorageJitter time.Duration
+	// DropUpdateRate is how often UpdateTask reports success without
+	// writing anything, as if the write was lost
+	DropUpdateRate float64
+	// PanicRate is how often a handler panics instead of running
+	PanicRate float64
+	// DuplicateRate is how often a handler runs a second time for the same
+	// delivery, as happens when a task is redelivered
+	DuplicateRate float64
+	// Seed makes injected faults repeatable, defaults to the current time
+	Seed int64
+}
+
+// Injector decides when to inject faults. It is safe for concurrent use.
+type Injector struct {
+	config Config
+
+	mu   sync.Mutex
+	rand *rand.Rand
+
+	// outage makes every storage call fail while set
+	outage atomic.Bool
+}
+
+// New creates an injector from cfg
+func New(cfg Config) *Injector {
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return &Injector{
+		config: cfg,
+		rand:   rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// Enabled reports whether faults are injected
+func (i *Injector) Enabled() bool {
+	return i.config.Enabled
+}
+
+// SetOutage makes every storage call fail, as if the backend went away,
+// until it is called again with false
+func (i *Injector) SetOutage(down bool) {
+	i.outage.Store(down)
+}
+
+// roll returns true with probability rate
+func (i *Injector) roll(rate float64) bool {
+	if !i.config.Enabled || rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rand.Float64() < rate
+}
+
+// delay sleeps for the configured storage latency, or until ctx is done.
+// During an outage it fails straight away.
+func (i *Injector) delay(ctx context.Context) error {
+	if i.outage.Load() {
+		return fmt.Errorf("chaos: injected outage: %w", errs.ErrStorageUnavailable)
+	}
+	d := i.config.StorageLatency
+	if i.config.StorageJitter > 0 {
+		i.mu.Lock()
+		d += time.Duration(i.rand.Int63n(int64(i.config.StorageJitter)))
+		i.mu.Unlock()
+	}
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Storage wraps store with injected latency and dropped updates
+func (i *Injector) Storage(store storage.Storage) storage.Storage {
+	if !i.config.Enabled {
+		return store
+	}
+	return &faultyStorage{Storage: store, injector: i}
+}
+
+// Handler wraps handler with injected panics and duplicate deliveries
+func (i *Injector) Handler(handler queue.TaskHandler) queue.TaskHandler {
+	if !i.config.Enabled {
+		return handler
+	}
+	return func(ctx context.Context, t *task.Task) error {
+		if i.roll(i.config.PanicRate) {
+			panic(fmt.Sprintf("chaos: injected panic in task %s", t.ID))
+		}
+		if i.roll(i.config.DuplicateRate) {
+			// Run a copy first so the handler sees the same task twice
+			dup := *t
+			if err := handler(ctx, &dup); err != nil {
+				return err
+			}
+		}
+		return handler(ctx, t)
+	}
+}
+
+// faultyStorage delays every call and loses some updates
+type faultyStorage struct {
+	storage.Storage
+	injector *Injector
+}
+
+// Unwrap returns the storage faults are injected in front of
+func (s *faultyStorage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+func (s *faultyStorage) SaveTask(ctx context.Context, t *task.Task) error {
+	if err := s.injector.delay(ctx); err != nil {
+		return err
+	}
+	return s.Storage.SaveTask(ctx, t)
+}
+
+func (s *faultyStorage) GetTask(ctx context.Context, id string) (*task.Task, error) {
+	if err := s.injector.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTask(ctx, id)
+}
+
+func (s *faultyStorage) UpdateTask(ctx context.Context, t *task.Task) error {
+	if err := s.injector.delay(ctx); err != nil {
+		return err
+	}
+	if s.injector.roll(s.injector.config.DropUpdateRate) {
+		return nil
+	}
+	return s.Storage.UpdateTask(ctx, t)
+}
+
+func (s *faultyStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	if err := s.injector.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTasksByStatus(ctx, status, limit)
+}
+
+func (s *faultyStorage) ScanTasks(ctx context.Context, filter storage.TaskFilter, cursor string, limit int) ([]*task.Task, string, error) {
+	if err := s.injector.delay(ctx); err != nil {
+		return nil, "", err
+	}
+	return s.Storage.ScanTasks(ctx, filter, cursor, limit)
+}
+
+func (s *faultyStorage) GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	if err := s.injector.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetDueTasks(ctx, now, limit)
+}
@@ -0,0 +1,167 @@
+package chaos
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+func TestDisabledInjectsNothing(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	inj := New(Config{PanicRate: 1, DropUpdateRate: 1})
+
+	assert.Same(t, store, inj.Storage(store))
+
+	handler := inj.Handler(func(ctx context.Context, t *task.Task) error { return nil })
+	assert.NotPanics(t, func() { _ = handler(context.Background(), task.NewTask("t", task.PriorityLow, nil)) })
+}
+
+func TestStorage_LatencyAndDroppedUpdates(t *testing.T) {
+	inj := New(Config{Enabled: true, StorageLatency: 20 * time.Millisecond, DropUpdateRate: 1})
+	store := inj.Storage(storage.NewMemoryStorage())
+	ctx := context.Background()
+
+	tk := task.NewTask("t", task.PriorityLow, nil)
+	start := time.Now()
+	require.NoError(t, store.SaveTask(ctx, tk))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// The update reports success but is lost
+	tk.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, tk))
+
+	retrieved, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, retrieved.Status)
+}
+
+func TestHandler_Duplicates(t *testing.T) {
+	inj := New(Config{Enabled: true, DuplicateRate: 1})
+
+	calls := 0
+	handler := inj.Handler(func(ctx context.Context, t *task.Task) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, handler(context.Background(), task.NewTask("t", task.PriorityLow, nil)))
+	assert.Equal(t, 2, calls)
+}
+
+func TestQueueRecovers(t *testing.T) {
+	inj := New(Config{
+		Enabled:        true,
+		StorageLatency: time.Millisecond,
+		StorageJitter:  2 * time.Millisecond,
+		PanicRate:      0.3,
+		DuplicateRate:  0.3,
+		Seed:           42,
+	})
+	store := inj.Storage(storage.NewMemoryStorage())
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+
+	// An idempotent handler: processing a task twice has no extra effect
+	var mu sync.Mutex
+	processed := make(map[string]int)
+	q.RegisterHandler("idempotent", inj.Handler(func(ctx context.Context, t *task.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed[t.ID] = 1
+		return nil
+	}))
+
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 20; i++ {
+		tk := task.NewTask("idempotent", task.PriorityMedium, nil)
+		tk.MaxRetries = 0
+		require.NoError(t, q.Submit(ctx, tk))
+		ids = append(ids, tk.ID)
+	}
+
+	q.Start(ctx, 4)
+	defer q.Stop()
+
+	// Every task ends up completed or, if its only attempt panicked, failed
+	var completed, failed int
+	require.Eventually(t, func() bool {
+		completed, failed = 0, 0
+		for _, id := range ids {
+			tk, err := store.GetTask(ctx, id)
+			require.NoError(t, err)
+			switch tk.Status {
+			case task.StatusCompleted:
+				completed++
+			case task.StatusFailed:
+				assert.Contains(t, tk.Error, "injected panic")
+				failed++
+			}
+		}
+		return completed+failed == len(ids)
+	}, 5*time.Second, 20*time.Millisecond)
+
+	assert.Positive(t, completed)
+	assert.Positive(t, failed)
+	mu.Lock()
+	assert.Len(t, processed, completed)
+	mu.Unlock()
+}
+
+func TestQueuePausesDuringOutage(t *testing.T) {
+	inj := New(Config{Enabled: true})
+	store := inj.Storage(storage.NewMemoryStorage())
+	q := queue.NewQueue(queue.Config{
+		Storage:          store,
+		Logger:           zap.NewNop(),
+		PollInterval:     5 * time.Millisecond,
+		OutageMaxBackoff: 20 * time.Millisecond,
+	})
+
+	var mu sync.Mutex
+	ran := 0
+	q.RegisterHandler("work", func(ctx context.Context, t *task.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran++
+		return nil
+	})
+
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		tk := task.NewTask("work", task.PriorityMedium, nil)
+		require.NoError(t, q.Submit(ctx, tk))
+		ids = append(ids, tk.ID)
+	}
+
+	// Storage goes away before the workers get to anything
+	inj.SetOutage(true)
+	q.Start(ctx, 2)
+	defer q.Stop()
+
+	require.Eventually(t, q.DispatchPaused, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Zero(t, ran)
+	mu.Unlock()
+
+	// Once it is back, dispatch resumes on its own and everything runs
+	inj.SetOutage(false)
+	require.Eventually(t, func() bool {
+		for _, id := range ids {
+			tk, err := store.GetTask(ctx, id)
+			if err != nil || tk.Status != task.StatusCompleted {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, q.DispatchPaused())
+}
@@ -21,7 +21,7 @@ submit_task() {
         -H "Content-Type: application/json" \
         -d "{
             \"type\": \"$task_type\",
-            \"priority\": $priority,
+            \"priority\": \"$priority\",
             \"payload\": $payload,
             \"max_retries\": 3
         }")
@@ -58,14 +58,14 @@ check_health() {
 
 # Main execution
 echo "1️⃣  Submitting Email Task (High Priority)"
-email_task=$(submit_task "send_email" 2 '{
+email_task=$(submit_task "send_email" high '{
     "recipient": "user@example.com",
     "subject": "Test Email",
     "body": "This is a test email from the task queue"
 }')
 
 echo "2️⃣  Submitting Image Processing Task (Medium Priority)"
-image_task=$(submit_task "process_image" 1 '{
+image_task=$(submit_task "process_image" medium '{
     "image_url": "https://example.com/image.jpg",
     "operation": "resize",
     "width": 800,
@@ -73,7 +73,7 @@ image_task=$(submit_task "process_image" 1 '{
 }')
 
 echo "3️⃣  Submitting Data Export Task (Low Priority)"
-export_task=$(submit_task "export_data" 0 '{
+export_task=$(submit_task "export_data" low '{
     "format": "csv",
     "date_range": {
         "start": "2024-01-01",
@@ -82,7 +82,7 @@ export_task=$(submit_task "export_data" 0 '{
 }')
 
 echo "4️⃣  Submitting Webhook Task (Critical Priority)"
-webhook_task=$(submit_task "call_webhook" 3 '{
+webhook_task=$(submit_task "call_webhook" critical '{
     "url": "https://example.com/webhook",
     "method": "POST",
     "headers": {
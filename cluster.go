@@ -0,0 +1,465 @@
+// Package cluster keeps a registry of the API servers and workers that make
+// up a deployment. Each instance heartbeats its own record; records that
+// stop being refreshed drop out, so the registry shows who is alive.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Version is the build version instances report, set at build time with
+// -ldflags "-X github.com/yourusername/distributed-task-queue/internal/cluster.Version=..."
+var Version = "dev"
+
+// Kind is the type of process a member runs
+type Kind string
+
+const (
+	KindAPI       Kind = "api"
+	KindWorker    Kind = "worker"
+	KindScheduler Kind = "scheduler"
+)
+
+// Member describes one running instance
+type Member struct {
+	ID        string    `json:"id"`
+	Kind      Kind      `json:"kind"`
+	Version   string    `json:"version"`
+	Host      string    `json:"host,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Types lists the task types a worker handles
+	Types []string `json:"types,omitempty"`
+	// Leads lists the election roles the member currently leads
+	Leads []string `json:"leads,omitempty"`
+	// Versions maps each task type a worker handles to the newest payload
+	// version it understands
+	Versions map[string]int `json:"versions,omitempty"`
+	// Tags are the resource tags a worker advertises, such as gpu=true
+	Tags map[string]string `json:"tags,omitempty"`
+	// Draining is set while the member finishes its work before stopping
+	Draining bool `json:"draining,omitempty"`
+}
+
+// Registry stores member records. Records expire unless re-registered
+// within their TTL.
+type Registry interface {
+	// Register creates or refreshes m's record for ttl
+	Register(ctx context.Context, m Member, ttl time.Duration) error
+	// Deregister removes a member's record
+	Deregister(ctx context.Context, id string) error
+	// Members returns every live member, ordered by kind then ID
+	Members(ctx context.Context) ([]Member, error)
+
+	// SetTypeDisabled disables or re-enables a task type across the
+	// cluster. The setting persists until changed.
+	SetTypeDisabled(ctx context.Context, taskType string, disabled bool) error
+	// DisabledTypes returns the task types disabled across the cluster,
+	// sorted
+	DisabledTypes(ctx context.Context) ([]string, error)
+}
+
+// sortMembers orders members by kind, then ID
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Kind != members[j].Kind {
+			return members[i].Kind < members[j].Kind
+		}
+		return members[i].ID < members[j].ID
+	})
+}
+
+// SupportedVersions returns, for each task type, the newest payload version
+// every active worker handling it understands. Producers that submit at or
+// below it can be sure any worker that picks the task up can run it, even
+// while a rolling deploy leaves old and new workers side by side. Draining
+// workers are left out, since they take no new tasks.
+func SupportedVersions(members []Member) map[string]int {
+	supported := make(map[string]int)
+	for _, m := range members {
+		if m.Kind != KindWorker || m.Draining {
+			continue
+		}
+		for taskType, v := range m.Versions {
+			if current, ok := supported[taskType]; !ok || v < current {
+				supported[taskType] = v
+			}
+		}
+	}
+	return supported
+}
+
+// RedisRegistry keeps member records in Redis. Each record is a key with a
+// TTL; a set indexes the IDs so members can be listed without a scan.
+type RedisRegistry struct {
+	client *redis.Client
+}
+
+// NewRedisRegistry creates a registry backed by client
+func NewRedisRegistry(client *redis.Client) *RedisRegistry {
+	return &RedisRegistry{client: client}
+}
+
+const membersKey = "cluster:members"
+
+// disabledTypesKey is the Redis set of disabled task types
+const disabledTypesKey = "cluster:types:disabled"
+
+// memberKey returns the key holding the record of member id
+func memberKey(id string) string {
+	return "cluster:member:" + id
+}
+
+func (r *RedisRegistry) Register(ctx context.Context, m Member, ttl time.Duration) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal member: %w", err)
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, memberKey(m.ID), data, ttl)
+	pipe.SAdd(ctx, membersKey, m.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to register member: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Deregister(ctx context.Context, id string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, memberKey(id))
+	pipe.SRem(ctx, membersKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to deregister member: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Members(ctx context.Context) ([]Member, error) {
+	ids, err := r.client.SMembers(ctx, membersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	if len(ids) == 0 {
+		return []Member{}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = memberKey(id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+
+	members := make([]Member, 0, len(ids))
+	var expired []interface{}
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			// The record expired; drop the ID from the index too
+			expired = append(expired, ids[i])
+			continue
+		}
+		var m Member
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return nil, fmt.Errorf("failed to parse member %s: %w", ids[i], err)
+		}
+		members = append(members, m)
+	}
+	if len(expired) > 0 {
+		r.client.SRem(ctx, membersKey, expired...)
+	}
+
+	sortMembers(members)
+	return members, nil
+}
+
+func (r *RedisRegistry) SetTypeDisabled(ctx context.Context, taskType string, disabled bool) error {
+	var err error
+	if disabled {
+		err = r.client.SAdd(ctx, disabledTypesKey, taskType).Err()
+	} else {
+		err = r.client.SRem(ctx, disabledTypesKey, taskType).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update disabled types: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisRegistry) DisabledTypes(ctx context.Context) ([]string, error) {
+	types, err := r.client.SMembers(ctx, disabledTypesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disabled types: %w", err)
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+// MemoryRegistry keeps member records in memory, for tests and
+// single-process deployments
+type MemoryRegistry struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	members  map[string]memoryMember
+	disabled map[string]bool
+}
+
+type memoryMember struct {
+	member  Member
+	expires time.Time
+}
+
+// NewMemoryRegistry creates an in-memory registry
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{now: time.Now, members: make(map[string]memoryMember), disabled: make(map[string]bool)}
+}
+
+func (r *MemoryRegistry) Register(ctx context.Context, m Member, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members[m.ID] = memoryMember{member: m, expires: r.now().Add(ttl)}
+	return nil
+}
+
+func (r *MemoryRegistry) Deregister(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.members, id)
+	return nil
+}
+
+func (r *MemoryRegistry) Members(ctx context.Context) ([]Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	members := make([]Member, 0, len(r.members))
+	for id, m := range r.members {
+		if !now.Before(m.expires) {
+			delete(r.members, id)
+			continue
+		}
+		members = append(members, m.member)
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+func (r *MemoryRegistry) SetTypeDisabled(ctx context.Context, taskType string, disabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if disabled {
+		r.disabled[taskType] = true
+	} else {
+		delete(r.disabled, taskType)
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) DisabledTypes(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	types := make([]string, 0, len(r.disabled))
+	for t := range r.disabled {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+// Leadership is an election role this instance campaigns for
+type Leadership interface {
+	Role() string
+	IsLeader() bool
+}
+
+// HeartbeatConfig holds heartbeat configuration
+type HeartbeatConfig struct {
+	Registry Registry
+	Logger   *zap.Logger
+
+	// ID identifies this instance, defaults to hostname-pid
+	ID   string
+	Kind Kind
+
+	// Version defaults to the build Version
+	Version string
+
+	// Types, if set, returns the task types this instance handles
+	Types func() []string
+
+	// Versions, if set, returns the payload version this instance
+	// understands for each task type it handles
+	Versions func() map[string]int
+
+	// Tags, if set, returns the resource tags this instance advertises
+	Tags func() map[string]string
+
+	// Leaders are the election roles this instance campaigns for; the
+	// ones it currently leads are reported with each heartbeat
+	Leaders []Leadership
+
+	// Disabled, if set, is passed the task types disabled across the
+	// cluster after each heartbeat, so a change reaches every instance,
+	// including ones started after it, within Interval
+	Disabled func(types []string)
+
+	// Partition, if set, is passed this instance's position among the
+	// live workers that are not draining, ordered by ID, and their number
+	// after each heartbeat, so pollers can split the shards between them.
+	// A draining instance is passed 0 of 0.
+	Partition func(index, count int)
+
+	// Interval is how often the record is refreshed, defaults to 5s.
+	// Records expire after three missed heartbeats.
+	Interval time.Duration
+}
+
+// Heartbeat keeps this instance's record in the registry fresh
+type Heartbeat struct {
+	registry Registry
+	logger   *zap.Logger
+	config   HeartbeatConfig
+	started  time.Time
+	draining atomic.Bool
+}
+
+// NewHeartbeat creates a heartbeat. Call Run to start it.
+func NewHeartbeat(cfg HeartbeatConfig) *Heartbeat {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.ID == "" {
+		host, _ := os.Hostname()
+		cfg.ID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.Version == "" {
+		cfg.Version = Version
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 5 * time.Second
+	}
+
+	return &Heartbeat{
+		registry: cfg.Registry,
+		logger:   cfg.Logger,
+		config:   cfg,
+		started:  time.Now(),
+	}
+}
+
+// Member returns the record this instance currently reports
+func (h *Heartbeat) Member() Member {
+	host, _ := os.Hostname()
+	m := Member{
+		ID:        h.config.ID,
+		Kind:      h.config.Kind,
+		Version:   h.config.Version,
+		Host:      host,
+		StartedAt: h.started,
+		LastSeen:  time.Now(),
+	}
+	if h.config.Types != nil {
+		m.Types = h.config.Types()
+	}
+	if h.config.Versions != nil {
+		m.Versions = h.config.Versions()
+	}
+	if h.config.Tags != nil {
+		m.Tags = h.config.Tags()
+	}
+	m.Draining = h.draining.Load()
+	for _, l := range h.config.Leaders {
+		if l.IsLeader() {
+			m.Leads = append(m.Leads, l.Role())
+		}
+	}
+	return m
+}
+
+// Beat registers this instance's record once, then passes the disabled
+// task types and its partition on
+func (h *Heartbeat) Beat(ctx context.Context) error {
+	if err := h.registry.Register(ctx, h.Member(), 3*h.config.Interval); err != nil {
+		return err
+	}
+	if h.config.Disabled != nil {
+		types, err := h.registry.DisabledTypes(ctx)
+		if err != nil {
+			return err
+		}
+		h.config.Disabled(types)
+	}
+	if h.config.Partition != nil {
+		members, err := h.registry.Members(ctx)
+		if err != nil {
+			return err
+		}
+		h.config.Partition(partition(members, h.config.ID))
+	}
+	return nil
+}
+
+// partition returns the position of id among the workers in members that
+// are not draining, and their number, or 0 of 0 if id is not one of them
+func partition(members []Member, id string) (index, count int) {
+	index = -1
+	for _, m := range members {
+		if m.Kind != KindWorker || m.Draining {
+			continue
+		}
+		if m.ID == id {
+			index = count
+		}
+		count++
+	}
+	if index < 0 {
+		return 0, 0
+	}
+	return index, count
+}
+
+// SetDraining marks this instance as draining and reports it straight
+// away, so it drops out of SupportedVersions before it stops. Later
+// heartbeats keep reporting it until the instance leaves.
+func (h *Heartbeat) SetDraining(ctx context.Context) error {
+	h.draining.Store(true)
+	return h.Beat(ctx)
+}
+
+// Run heartbeats until ctx is done, then deregisters
+func (h *Heartbeat) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.Beat(ctx); err != nil && ctx.Err() == nil {
+			h.logger.Warn("cluster heartbeat failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			deregisterCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := h.registry.Deregister(deregisterCtx, h.config.ID); err != nil {
+				h.logger.Warn("failed to leave cluster", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeLeadership struct {
+	role   string
+	leader bool
+}
+
+func (f fakeLeadership) Role() string   { return f.role }
+func (f fakeLeadership) IsLeader() bool { return f.leader }
+
+func TestHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	registry := NewMemoryRegistry()
+	registry.now = func() time.Time { return now }
+
+	worker := NewHeartbeat(HeartbeatConfig{
+		Registry: registry,
+		Logger:   zap.NewNop(),
+		ID:       "worker-1",
+		Kind:     KindWorker,
+		Types:    func() []string { return []string{"send_email"} },
+		Leaders: []Leadership{
+			fakeLeadership{role: "scheduler", leader: true},
+			fakeLeadership{role: "janitor"},
+		},
+	})
+	api := NewHeartbeat(HeartbeatConfig{Registry: registry, Logger: zap.NewNop(), ID: "api-1", Kind: KindAPI})
+	require.NoError(t, worker.Beat(ctx))
+	require.NoError(t, api.Beat(ctx))
+
+	members, err := registry.Members(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "api-1", members[0].ID)
+	assert.Equal(t, Version, members[0].Version)
+	assert.Equal(t, []string{"send_email"}, members[1].Types)
+	assert.Equal(t, []string{"scheduler"}, members[1].Leads)
+
+	// A member that misses three heartbeats drops out
+	now = now.Add(10 * time.Second)
+	require.NoError(t, api.Beat(ctx))
+	now = now.Add(10 * time.Second)
+	members, err = registry.Members(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "api-1", members[0].ID)
+}
+
+func TestHeartbeat_RunDeregisters(t *testing.T) {
+	registry := NewMemoryRegistry()
+	h := NewHeartbeat(HeartbeatConfig{Registry: registry, Logger: zap.NewNop(), ID: "w", Kind: KindWorker})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		members, _ := registry.Members(context.Background())
+		return len(members) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	members, err := registry.Members(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestSupportedVersions(t *testing.T) {
+	members := []Member{
+		{ID: "api-1", Kind: KindAPI},
+		{ID: "old", Kind: KindWorker, Versions: map[string]int{"send_email": 1, "export_data": 3}},
+		{ID: "new", Kind: KindWorker, Versions: map[string]int{"send_email": 2, "export_data": 3, "resize": 1}},
+	}
+	assert.Equal(t, map[string]int{"send_email": 1, "export_data": 3, "resize": 1}, SupportedVersions(members))
+
+	// Once the old worker drains, producers can move to the new version
+	members[1].Draining = true
+	assert.Equal(t, map[string]int{"send_email": 2, "export_data": 3, "resize": 1}, SupportedVersions(members))
+}
+
+func TestHeartbeat_SetDraining(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry()
+	h := NewHeartbeat(HeartbeatConfig{
+		Registry: registry,
+		Logger:   zap.NewNop(),
+		ID:       "w",
+		Kind:     KindWorker,
+		Versions: func() map[string]int { return map[string]int{"send_email": 2} },
+	})
+	require.NoError(t, h.Beat(ctx))
+	require.NoError(t, h.SetDraining(ctx))
+
+	members, err := registry.Members(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.True(t, members[0].Draining)
+	assert.Equal(t, map[string]int{"send_email": 2}, members[0].Versions)
+}
+
+func TestHeartbeat_DisabledTypes(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry()
+	require.NoError(t, registry.SetTypeDisabled(ctx, "send_email", true))
+	require.NoError(t, registry.SetTypeDisabled(ctx, "export_data", true))
+
+	var got []string
+	h := NewHeartbeat(HeartbeatConfig{
+		Registry: registry,
+		Logger:   zap.NewNop(),
+		ID:       "worker-1",
+		Kind:     KindWorker,
+		Disabled: func(types []string) { got = types },
+	})
+	require.NoError(t, h.Beat(ctx))
+	assert.Equal(t, []string{"export_data", "send_email"}, got)
+
+	require.NoError(t, registry.SetTypeDisabled(ctx, "send_email", false))
+	require.NoError(t, h.Beat(ctx))
+	assert.Equal(t, []string{"export_data"}, got)
+}
+
+func TestHeartbeat_Partition(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry()
+
+	type position struct{ index, count int }
+	positions := make(map[string]position)
+	beat := func(id string, kind Kind) *Heartbeat {
+		h := NewHeartbeat(HeartbeatConfig{
+			Registry: registry,
+			Logger:   zap.NewNop(),
+			ID:       id,
+			Kind:     kind,
+			Partition: func(index, count int) {
+				positions[id] = position{index, count}
+			},
+		})
+		require.NoError(t, h.Beat(ctx))
+		return h
+	}
+
+	beat("worker-b", KindWorker)
+	beat("scheduler-1", KindScheduler)
+	a := beat("worker-a", KindWorker)
+	b := beat("worker-b", KindWorker)
+	assert.Equal(t, position{0, 2}, positions["worker-a"])
+	assert.Equal(t, position{1, 2}, positions["worker-b"])
+	assert.Equal(t, position{0, 0}, positions["scheduler-1"])
+
+	// A draining worker hands its shards to the rest
+	require.NoError(t, a.SetDraining(ctx))
+	require.NoError(t, b.Beat(ctx))
+	assert.Equal(t, position{0, 0}, positions["worker-a"])
+	assert.Equal(t, position{0, 1}, positions["worker-b"])
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type correlationKey struct{}
+
+// correlationHeader carries the ID tying a request to the work it causes
+const correlationHeader = "X-Correlation-ID"
+
+// maxCorrelationIDLength bounds client supplied correlation IDs
+const maxCorrelationIDLength = 128
+
+// correlationID picks up the caller's correlation ID, from X-Correlation-ID
+// or else the trace ID of a W3C traceparent header, and echoes it back
+func correlationID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(correlationHeader))
+		if id == "" {
+			id = traceID(r.Header.Get("traceparent"))
+		}
+		if id != "" && len(id) <= maxCorrelationIDLength {
+			w.Header().Set(correlationHeader, id)
+			r = r.WithContext(context.WithValue(r.Context(), correlationKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// traceID extracts the trace ID from a traceparent header such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func traceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	for _, c := range parts[1] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ""
+		}
+	}
+	return parts[1]
+}
+
+// requestCorrelationID returns the correlation ID the caller sent, if any
+func requestCorrelationID(r *http.Request) string {
+	id, _ := r.Context().Value(correlationKey{}).(string)
+	return id
+}
+
+// newCorrelationID creates a correlation ID for work submitted without one
+func newCorrelationID() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// submitKey is the labels of the metrics Submit updates
+type submitKey struct {
+	taskType string
+	source   string
+	priority task.Priority
+}
+
+// submitSeries is the metrics Submit updates for one submitKey
+type submitSeries struct {
+	submitted prometheus.Counter
+	bySource  prometheus.Counter
+	queued    prometheus.Gauge
+}
+
+// submitCounters caches the series Submit updates, since looking one up
+// by its label values allocates and Submit runs for every task
+type submitCounters struct {
+	mu     sync.RWMutex
+	series map[submitKey]submitSeries
+}
+
+// get returns the series of t
+func (c *submitCounters) get(t *task.Task) submitSeries {
+	key := submitKey{taskType: t.Type, source: t.Source, priority: t.Priority}
+	c.mu.RLock()
+	s, ok := c.series[key]
+	c.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[key]; ok {
+		return s
+	}
+	s = submitSeries{
+		submitted: metrics.TasksSubmitted.WithLabelValues(t.Type, t.Priority.Label()),
+		bySource:  metrics.TasksSubmittedBySource.WithLabelValues(t.Type, t.Source),
+		queued:    metrics.QueueSize.WithLabelValues(t.Priority.Label()),
+	}
+	if c.series == nil {
+		c.series = make(map[submitKey]submitSeries)
+	}
+	c.series[key] = s
+	return s
+}
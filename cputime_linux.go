@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicks is the unit of CPU times in /proc, USER_HZ, which Linux fixes
+// at 100 per second
+const clockTicks = 100
+
+// osThread is an OS thread a goroutine is locked to
+type osThread struct {
+	stat string
+}
+
+// lockThread locks the calling goroutine to its OS thread
+func lockThread() (*osThread, bool) {
+	runtime.LockOSThread()
+	th := &osThread{stat: fmt.Sprintf("/proc/self/task/%d/stat", syscall.Gettid())}
+	if _, err := os.Stat(th.stat); err != nil {
+		runtime.UnlockOSThread()
+		return nil, false
+	}
+	return th, true
+}
+
+// unlock unlocks the goroutine that called lockThread; it must be called
+// from that goroutine
+func (th *osThread) unlock() {
+	runtime.UnlockOSThread()
+}
+
+// cpuTime returns the user and system CPU time the thread has used, or 0
+// if it cannot be read
+func (th *osThread) cpuTime() time.Duration {
+	data, err := os.ReadFile(th.stat)
+	if err != nil {
+		return 0
+	}
+	// The command name in parentheses may hold spaces; utime and stime
+	// are the 12th and 13th fields after it
+	_, rest, ok := strings.Cut(string(data), ") ")
+	fields := strings.Fields(rest)
+	if !ok || len(fields) < 13 {
+		return 0
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	return time.Duration(utime+stime) * time.Second / clockTicks
+}
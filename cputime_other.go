@@ -0,0 +1,19 @@
+//go:build !linux
+
+package queue
+
+import "time"
+
+// osThread is an OS thread a goroutine is locked to
+type osThread struct{}
+
+// lockThread reports false, as per-thread CPU times are only read on Linux
+func lockThread() (*osThread, bool) {
+	return nil, false
+}
+
+func (th *osThread) unlock() {}
+
+func (th *osThread) cpuTime() time.Duration {
+	return 0
+}
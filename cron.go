@@ -0,0 +1,217 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is a parsed cron expression: five fields, minute hour day-of-month
+// month day-of-week, or one of the descriptors @yearly, @monthly,
+// @weekly, @daily, @hourly and @every <duration>
+type Spec struct {
+	minute, hour, dom, month, dow bits
+	// domAny and dowAny are set for day fields starting with *, since a
+	// day matches either day field when both are restricted
+	domAny, dowAny bool
+	// hourAny is set for an hour field of *, whose runs repeat with the
+	// hour the clocks go back
+	hourAny bool
+	// every is set for @every, which runs at a fixed interval instead
+	every time.Duration
+}
+
+// bits has bit n set for each value n a field matches
+type bits uint64
+
+func (b bits) has(n int) bool {
+	return b&(1<<uint(n)) != 0
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week 7 is Sunday too, and folded onto 0
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSpec parses a cron expression. Fields take *, single values,
+// ranges such as 1-5, steps such as */15 or 0-30/10, and lists of those
+// separated by commas; months and days of the week also take names such
+// as jan and mon.
+func ParseSpec(expr string) (*Spec, error) {
+	expr = strings.TrimSpace(expr)
+	if rest, ok := strings.CutPrefix(expr, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid cron spec %q: @every needs a duration of at least 1s", expr)
+		}
+		return &Spec{every: every}, nil
+	}
+	if d, ok := descriptors[expr]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron spec %q: want 5 fields, got %d", expr, len(fields))
+	}
+	s := &Spec{
+		domAny:  strings.HasPrefix(fields[2], "*"),
+		dowAny:  strings.HasPrefix(fields[4], "*"),
+		hourAny: fields[1] == "*",
+	}
+	var err error
+	for i, f := range []struct {
+		field
+		out *bits
+	}{
+		{minuteField, &s.minute},
+		{hourField, &s.hour},
+		{domField, &s.dom},
+		{monthField, &s.month},
+		{dowField, &s.dow},
+	} {
+		if *f.out, err = f.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron spec %q: %w", expr, err)
+		}
+	}
+	if s.dow.has(7) {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+// parse parses one comma-separated field
+func (f field) parse(expr string) (bits, error) {
+	var b bits
+	for _, part := range strings.Split(expr, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepStr, f.name)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			case !hasStep:
+				// A single value; with a step, a value runs to the maximum
+				hi = lo
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q in %s", rng, f.name)
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			b |= 1 << uint(n)
+		}
+	}
+	return b, nil
+}
+
+// value parses a number or name in the field
+func (f field) value(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s must be between %d and %d, got %q", f.name, f.min, f.max, s)
+	}
+	return n, nil
+}
+
+// Next returns the first time after after that the spec matches, in
+// after's location, or the zero time if it never does within five years.
+// Across daylight saving changes, a time the clocks skip over does not
+// match, and a time they repeat matches once, unless the hour field is *.
+func (s *Spec) Next(after time.Time) time.Time {
+	if s.every > 0 {
+		return after.Add(s.every)
+	}
+
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.month.has(int(t.Month())):
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !s.dayMatches(t):
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		case !s.hour.has(t.Hour()):
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+		case !s.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		case !s.hourAny && !wallClock(t).After(wallClock(after)):
+			// The clocks went back and this time already came round
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// forward returns next, the start of the next month, day or hour after t.
+// When the clocks skip over that time, time.Date may put next at or before
+// t, and the hour after it is the first time that exists.
+func forward(t, next time.Time) time.Time {
+	if !next.After(t) {
+		return next.Add(time.Hour)
+	}
+	return next
+}
+
+// wallClock returns the time t's clock shows, without its zone offset
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// dayMatches applies the day-of-month and day-of-week fields to t's day.
+// When both are restricted, as in cron, a day matching either will do.
+func (s *Spec) dayMatches(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
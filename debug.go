@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// DebugState is a snapshot of a queue's internals, for diagnosing workers
+// that have stalled
+type DebugState struct {
+	// Goroutines counts every goroutine in the process
+	Goroutines int                     `json:"goroutines"`
+	Workers    WorkerPoolState         `json:"workers"`
+	Channels   map[string]ChannelState `json:"channels"`
+	Dispatcher DispatcherState         `json:"dispatcher"`
+	// Batches counts the tasks waiting in each batch handler's batch, by
+	// task type
+	Batches map[string]int `json:"batches,omitempty"`
+}
+
+// WorkerPoolState describes the worker goroutines
+type WorkerPoolState struct {
+	Goroutines int `json:"goroutines"`
+	Busy       int `json:"busy"`
+	// Leaked counts handlers still running well after their context was
+	// cancelled; see Config.LeakGrace
+	Leaked  int           `json:"leaked"`
+	Running []RunningTask `json:"running,omitempty"`
+	// Types holds the tasks dispatched to the workers, by task type
+	Types map[string]TypeQueueState `json:"types,omitempty"`
+}
+
+// RunningTask is the task a worker is running
+type RunningTask struct {
+	Worker  string    `json:"worker"`
+	TaskID  string    `json:"task_id"`
+	Type    string    `json:"type"`
+	Started time.Time `json:"started"`
+	// Running is how long it has run, such as "1m30s"
+	Running string `json:"running"`
+}
+
+// ChannelState is how full one priority class's channel is
+type ChannelState struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+// DispatcherState describes the poller feeding the channels
+type DispatcherState struct {
+	Started bool `json:"started"`
+	// Dispatchers is how many goroutines take tasks off the channels
+	Dispatchers int  `json:"dispatchers"`
+	Stopped     bool `json:"stopped"`
+	InProcess   bool `json:"in_process"`
+	// Paused is set while storage is unreachable
+	Paused bool `json:"paused"`
+	// UnhealthyHandlers holds the error of each task type whose handler
+	// fails its health check, and whose tasks are paused
+	UnhealthyHandlers map[string]string `json:"unhealthy_handlers,omitempty"`
+	// Leader reports whether this instance releases due tasks
+	Leader bool `json:"leader"`
+	// Alive reports whether the poller is still going round; see Alive
+	Alive        bool       `json:"alive"`
+	PollInterval string     `json:"poll_interval"`
+	LastPoll     *time.Time `json:"last_poll,omitempty"`
+	// Prefetch caps the tasks buffered on the channels; Buffered are on
+	// them, Claimed taken by workers but not yet started
+	Prefetch int `json:"prefetch"`
+	Buffered int `json:"buffered"`
+	Claimed  int `json:"claimed"`
+}
+
+// classNames names the priority classes in DebugState
+var classNames = map[task.Priority]string{
+	task.PriorityCritical: "critical",
+	task.PriorityHigh:     "high",
+	task.PriorityMedium:   "medium",
+	task.PriorityLow:      "low",
+}
+
+// activity records which task each worker is running and how many
+// handlers have leaked, for DebugState
+type activity struct {
+	mu      sync.Mutex
+	running map[string]RunningTask
+	leaked  int
+}
+
+func (a *activity) start(worker string, t *task.Task, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running == nil {
+		a.running = make(map[string]RunningTask)
+	}
+	a.running[worker] = RunningTask{Worker: worker, TaskID: t.ID, Type: t.Type, Started: now}
+}
+
+func (a *activity) done(worker string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.running, worker)
+}
+
+func (a *activity) leak(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.leaked += n
+}
+
+// DebugState returns a snapshot of the queue's workers, channels and
+// poller. The parts are read one after another, so they may disagree
+// slightly on a busy queue.
+func (q *Queue) DebugState() DebugState {
+	now := q.clock.Now()
+	state := DebugState{
+		Goroutines: runtime.NumGoroutine(),
+		Channels:   make(map[string]ChannelState, len(q.taskChannels)),
+	}
+
+	q.workersMu.Lock()
+	state.Workers.Goroutines = len(q.workers)
+	state.Dispatcher.Started = q.workerCtx != nil
+	q.workersMu.Unlock()
+
+	q.activity.mu.Lock()
+	state.Workers.Busy = len(q.activity.running)
+	state.Workers.Leaked = q.activity.leaked
+	for _, r := range q.activity.running {
+		r.Running = now.Sub(r.Started).String()
+		state.Workers.Running = append(state.Workers.Running, r)
+	}
+	q.activity.mu.Unlock()
+	sort.Slice(state.Workers.Running, func(i, j int) bool {
+		return state.Workers.Running[i].Started.Before(state.Workers.Running[j].Started)
+	})
+	state.Workers.Types = q.exec.state()
+
+	for class, ch := range q.taskChannels {
+		state.Channels[classNames[class]] = ChannelState{Len: len(ch), Cap: cap(ch)}
+	}
+
+	d := &state.Dispatcher
+	d.Dispatchers = q.dispatchers
+	d.Stopped = closed(q.stopChan)
+	d.InProcess = q.inProcess
+	d.Paused = q.health.isPaused()
+	if unhealthy := q.UnhealthyHandlers(); len(unhealthy) > 0 {
+		d.UnhealthyHandlers = unhealthy
+	}
+	d.Leader = q.scheduler == nil || q.scheduler.IsLeader()
+	d.Alive = q.Alive()
+	d.PollInterval = q.pollInterval.String()
+	q.liveness.mu.Lock()
+	if !q.liveness.last.IsZero() {
+		last := q.liveness.last
+		d.LastPoll = &last
+		d.PollInterval = q.liveness.interval.String()
+	}
+	q.liveness.mu.Unlock()
+	q.bufferedMu.Lock()
+	d.Prefetch = q.prefetch
+	d.Buffered = len(q.buffered)
+	d.Claimed = len(q.claimed)
+	q.bufferedMu.Unlock()
+
+	q.mu.RLock()
+	batchers := make(map[string]*batcher, len(q.batchers))
+	for taskType, b := range q.batchers {
+		batchers[taskType] = b
+	}
+	q.mu.RUnlock()
+	for taskType, b := range batchers {
+		b.mu.Lock()
+		if n := len(b.pending); n > 0 {
+			if state.Batches == nil {
+				state.Batches = make(map[string]int)
+			}
+			state.Batches[taskType] = n
+		}
+		b.mu.Unlock()
+	}
+	return state
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+)
+
+// DebugHandler serves net/http/pprof under /debug/pprof/ and the state of
+// q's workers, channels and poller at /debug/queue. Neither has any
+// authentication, so serve it on an address only operators can reach,
+// such as from a worker that runs no API.
+func DebugHandler(q *queue.Queue) http.Handler {
+	r := chi.NewRouter()
+	debugRoutes(r, q)
+	return r
+}
+
+// debugRoutes registers the debug endpoints on r
+func debugRoutes(r chi.Router, q *queue.Queue) {
+	r.Get("/debug/queue", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(q.DebugState())
+	})
+
+	r.Get("/debug/pprof/", pprof.Index)
+	r.Get("/debug/pprof/cmdline", pprof.Cmdline)
+	r.Get("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.Get("/debug/pprof/trace", pprof.Trace)
+	// Named profiles, such as goroutine and heap. pprof.Index would serve
+	// them too, but only without a BasePath.
+	r.Get("/debug/pprof/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(chi.URLParam(r, "profile")).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,376 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// defaultDispatchers is how many goroutines move tasks from the priority
+// channels to the workers, unless Config.Dispatchers says otherwise
+const defaultDispatchers = 2
+
+// overLimitDelay is how long a task is held back when its type already has
+// as many tasks waiting for a worker as its concurrency limit lets run
+const overLimitDelay = time.Second
+
+// stagedTask is a dispatched task waiting for a worker since at
+type stagedTask struct {
+	task *task.Task
+	seq  uint64
+	at   time.Time
+}
+
+// typeQueue holds the dispatched tasks of one type
+type typeQueue struct {
+	waiting []stagedTask
+	running int
+}
+
+// TypeQueueState is how many tasks of one type wait for a worker and run
+type TypeQueueState struct {
+	Waiting int `json:"waiting"`
+	Running int `json:"running"`
+	// Limit is the type's concurrency limit, zero for none
+	Limit int `json:"limit,omitempty"`
+}
+
+// executor stands between the dispatchers, which take tasks off the
+// priority channels, and the pool of workers that run them. Each type's
+// tasks wait in their own queue, so a type at its concurrency limit holds
+// back only its own tasks. Dispatchers take a task only once a worker is
+// idle with nothing it could run, so tasks stay on the channels, in
+// priority order, until they can start.
+type executor struct {
+	mu     sync.Mutex
+	queues map[string]*typeQueue
+	seq    uint64
+	// idle counts the workers waiting for a task, reserved the tasks
+	// dispatchers are fetching for them and dispatchers the dispatchers
+	// still running
+	idle        int
+	reserved    int
+	dispatchers int
+	// closed is set once the queue hands staged tasks back at shutdown
+	closed bool
+	// changed closes, and is replaced, whenever a task is staged or
+	// finishes, or a worker or dispatcher comes or goes
+	changed chan struct{}
+	// limit returns the concurrency limit of a type, zero for none
+	limit func(taskType string) int
+	now   func() time.Time
+}
+
+func newExecutor(limit func(taskType string) int, now func() time.Time) *executor {
+	return &executor{
+		queues:  make(map[string]*typeQueue),
+		changed: make(chan struct{}),
+		limit:   limit,
+		now:     now,
+	}
+}
+
+// broadcast wakes everything waiting on e; e.mu must be held
+func (e *executor) broadcast() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// runnable counts the staged tasks a worker could start now; e.mu must be
+// held
+func (e *executor) runnable() int {
+	n := 0
+	for taskType, q := range e.queues {
+		free := len(q.waiting)
+		if limit := e.limit(taskType); limit > 0 && limit-q.running < free {
+			free = limit - q.running
+		}
+		if free > 0 {
+			n += free
+		}
+	}
+	return n
+}
+
+// reserve waits until a worker is idle with nothing it could run, then
+// books the next task for it. It returns false once stop closes, unless
+// draining, or ctx is done.
+func (e *executor) reserve(ctx context.Context, stop <-chan struct{}, draining bool) bool {
+	for {
+		e.mu.Lock()
+		if closed(stop) && !draining {
+			e.mu.Unlock()
+			return false
+		}
+		if e.idle > e.runnable()+e.reserved {
+			e.reserved++
+			e.mu.Unlock()
+			return true
+		}
+		changed := e.changed
+		e.mu.Unlock()
+
+		wake := stop
+		if draining {
+			wake = nil
+		}
+		select {
+		case <-changed:
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// unreserve gives back a booking no task came for
+func (e *executor) unreserve() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reserved--
+	e.broadcast()
+}
+
+// stageResult is what became of a task handed to stage
+type stageResult int
+
+const (
+	staged stageResult = iota
+	// stagedOverLimit tasks found their type with as many tasks waiting
+	// as its concurrency limit lets run
+	stagedOverLimit
+	// stagedClosed tasks arrived after staged tasks were handed back
+	stagedClosed
+)
+
+// stage puts a task a dispatcher booked a worker for in its type's queue
+func (e *executor) stage(t *task.Task) stageResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reserved--
+	defer e.broadcast()
+	if e.closed {
+		return stagedClosed
+	}
+
+	q := e.queues[t.Type]
+	if q == nil {
+		q = &typeQueue{}
+		e.queues[t.Type] = q
+	}
+	if limit := e.limit(t.Type); limit > 0 && len(q.waiting) >= limit {
+		return stagedOverLimit
+	}
+	e.seq++
+	q.waiting = append(q.waiting, stagedTask{task: t, seq: e.seq, at: e.now()})
+	return staged
+}
+
+// take waits for a staged task a worker may start, the most urgent across
+// the types under their limits, oldest first within a priority, and
+// records how long it waited. It returns false once stop closes, or ctx is
+// done. While draining, it keeps handing out staged tasks after stop
+// closes, until the dispatchers are done too.
+func (e *executor) take(ctx context.Context, stop <-chan struct{}, draining func() bool) (*task.Task, bool) {
+	e.mu.Lock()
+	e.idle++
+	e.broadcast()
+	e.mu.Unlock()
+
+	for {
+		stopping := closed(stop)
+		e.mu.Lock()
+		if stopping && !draining() {
+			e.idle--
+			e.mu.Unlock()
+			return nil, false
+		}
+		// The worker stops counting as idle as it takes the task, so no
+		// dispatcher fetches another for it
+		if s, ok := e.next(); ok {
+			e.idle--
+			e.mu.Unlock()
+			metrics.HandlerQueueWait.WithLabelValues(s.task.Type).Observe(e.now().Sub(s.at).Seconds())
+			return s.task, true
+		}
+		if stopping && e.reserved == 0 && e.dispatchers == 0 {
+			e.idle--
+			e.mu.Unlock()
+			return nil, false
+		}
+		changed := e.changed
+		e.mu.Unlock()
+
+		wake := stop
+		if stopping {
+			wake = nil
+		}
+		select {
+		case <-changed:
+		case <-wake:
+		case <-ctx.Done():
+			e.mu.Lock()
+			e.idle--
+			e.mu.Unlock()
+			return nil, false
+		}
+	}
+}
+
+// next removes the task take hands out, if any; e.mu must be held
+func (e *executor) next() (stagedTask, bool) {
+	var best *typeQueue
+	for taskType, q := range e.queues {
+		if len(q.waiting) == 0 {
+			continue
+		}
+		if limit := e.limit(taskType); limit > 0 && q.running >= limit {
+			continue
+		}
+		if best == nil || before(q.waiting[0], best.waiting[0]) {
+			best = q
+		}
+	}
+	if best == nil {
+		return stagedTask{}, false
+	}
+	s := best.waiting[0]
+	best.waiting[0] = stagedTask{}
+	best.waiting = best.waiting[1:]
+	best.running++
+	return s, true
+}
+
+// before orders staged tasks by priority, then by when they were staged
+func before(a, b stagedTask) bool {
+	if a.task.Priority != b.task.Priority {
+		return a.task.Priority > b.task.Priority
+	}
+	return a.seq < b.seq
+}
+
+// done frees the slot of a task a worker has finished with
+func (e *executor) done(t *task.Task) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if q := e.queues[t.Type]; q != nil {
+		q.running--
+		if q.running <= 0 && len(q.waiting) == 0 {
+			delete(e.queues, t.Type)
+		}
+	}
+	e.broadcast()
+}
+
+// dispatcherDone records that a dispatcher has stopped
+func (e *executor) dispatcherDone() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatchers--
+	e.broadcast()
+}
+
+// drain removes every staged task, for handing back at shutdown; tasks
+// staged afterwards are refused
+func (e *executor) drain() []*task.Task {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	var tasks []*task.Task
+	for taskType, q := range e.queues {
+		for _, s := range q.waiting {
+			tasks = append(tasks, s.task)
+		}
+		q.waiting = nil
+		if q.running == 0 {
+			delete(e.queues, taskType)
+		}
+	}
+	e.broadcast()
+	return tasks
+}
+
+// state returns the queue of each type with tasks waiting or running
+func (e *executor) state() map[string]TypeQueueState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queues) == 0 {
+		return nil
+	}
+	state := make(map[string]TypeQueueState, len(e.queues))
+	for taskType, q := range e.queues {
+		state[taskType] = TypeQueueState{
+			Waiting: len(q.waiting),
+			Running: q.running,
+			Limit:   e.limit(taskType),
+		}
+	}
+	return state
+}
+
+// startDispatchers starts n dispatchers
+func (q *Queue) startDispatchers(ctx context.Context, n int) {
+	q.exec.mu.Lock()
+	q.exec.dispatchers += n
+	q.exec.mu.Unlock()
+	for i := 0; i < n; i++ {
+		q.wg.Add(1)
+		go q.dispatcher(ctx)
+	}
+}
+
+// dispatcher moves tasks from the priority channels to the executor, one
+// each time a worker goes idle with nothing to run, until the queue stops.
+// In InProcess mode it goes on until the channels are empty, so the tasks
+// already submitted still run.
+func (q *Queue) dispatcher(ctx context.Context) {
+	defer q.wg.Done()
+	defer q.exec.dispatcherDone()
+
+	for {
+		if !q.exec.reserve(ctx, q.stopChan, q.inProcess) {
+			return
+		}
+		t, ok := q.next(ctx, q.stopChan)
+		if !ok {
+			q.exec.unreserve()
+			return
+		}
+		switch q.exec.stage(t) {
+		case stagedOverLimit:
+			q.holdBack(ctx, t)
+		case stagedClosed:
+			q.handBack(t)
+		}
+	}
+}
+
+// holdBack leaves a task whose type has as many tasks waiting as its
+// concurrency limit lets run for later, as tasks over a rate limit are
+func (q *Queue) holdBack(ctx context.Context, t *task.Task) {
+	defer q.release(t)
+	at := q.clock.Now().Add(overLimitDelay)
+	if t.Untracked {
+		t.ScheduledAt = &at
+		q.pushBack(t)
+		return
+	}
+	if err := q.schedule(ctx, t, at, q.logger); err != nil {
+		q.logger.Debug("skipping task", zap.String("id", t.ID), zap.Error(err))
+		return
+	}
+	q.logger.Debug("task type at its concurrency limit, scheduled",
+		zap.String("id", t.ID),
+		zap.String("type", t.Type),
+	)
+}
+
+// concurrencyLimit returns the concurrency limit of taskType, zero for none
+func (q *Queue) concurrencyLimit(taskType string) int {
+	c, _ := q.TypeConfig(taskType)
+	return c.Concurrency
+}
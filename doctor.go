@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// orphanGrace is how long past its timeout a task may stay processing
+// before Doctor takes its worker to be gone
+const orphanGrace = 10 * time.Minute
+
+// DoctorOptions configures Doctor
+type DoctorOptions struct {
+	// Repair fixes what is found rather than only reporting it
+	Repair bool
+	// LiveInstances lists the IDs of the running processes, such as the
+	// cluster registry's members. A processing task started by an
+	// instance not listed is orphaned. Nil skips the check.
+	LiveInstances []string
+}
+
+// DoctorReport counts the inconsistencies Doctor found
+type DoctorReport struct {
+	storage.IndexReport
+	// IndexesChecked is false when storage has no indexes to check, or is
+	// wrapped, as with payload encryption, so they could not be reached
+	IndexesChecked bool `json:"indexes_checked"`
+	// Orphaned counts processing tasks whose worker is gone
+	Orphaned int `json:"orphaned"`
+	// Repaired is set when what was found has been fixed
+	Repaired bool `json:"repaired"`
+}
+
+// Doctor checks storage for inconsistencies and, with opts.Repair, fixes
+// them: index entries without a task record or for a status the task has
+// left, task records missing from their index, counters that disagree and
+// processing tasks whose worker is gone. A task whose handler extended its
+// lease is orphaned once the lease runs out, and only then. Any other task
+// is orphaned when the instance that started it is not in
+// opts.LiveInstances, or when it has been processing for longer than its
+// timeout plus ten minutes. Orphaned tasks are repaired by moving them to
+// retrying, their lost attempt not counting as a retry.
+func (q *Queue) Doctor(ctx context.Context, opts DoctorOptions) (DoctorReport, error) {
+	report := DoctorReport{Repaired: opts.Repair}
+	if q.indexChecker != nil {
+		indexes, err := q.indexChecker.CheckIndexes(ctx, opts.Repair)
+		report.IndexReport = indexes
+		if err != nil {
+			return report, fmt.Errorf("failed to check indexes: %w", err)
+		}
+		report.IndexesChecked = true
+	}
+
+	var live map[string]bool
+	if opts.LiveInstances != nil {
+		live = make(map[string]bool, len(opts.LiveInstances))
+		for _, id := range opts.LiveInstances {
+			live[id] = true
+		}
+	}
+	now := q.clock.Now()
+	var orphaned []*task.Task
+	err := storage.Each(ctx, q.storage, storage.TaskFilter{Statuses: []task.Status{task.StatusProcessing}}, func(t *task.Task) error {
+		if q.orphaned(t, live, now) {
+			orphaned = append(orphaned, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to read processing tasks: %w", err)
+	}
+	report.Orphaned = len(orphaned)
+
+	if opts.Repair {
+		for _, t := range orphaned {
+			t.AbandonAttempt()
+			t.Status = task.StatusRetrying
+			t.WorkerID = ""
+			t.Instance = ""
+			t.LeaseExpiresAt = nil
+			if err := q.storage.UpdateTask(ctx, t); err != nil {
+				return report, fmt.Errorf("failed to requeue task %s: %w", t.ID, err)
+			}
+			q.logger.Info("requeued orphaned task", zap.String("id", t.ID), zap.String("type", t.Type))
+		}
+	}
+
+	q.logger.Info("checked storage",
+		zap.Bool("repair", opts.Repair),
+		zap.Int("dangling", report.Dangling),
+		zap.Int("stale", report.Stale),
+		zap.Int("unindexed", report.Unindexed),
+		zap.Int("counters", report.Counters),
+		zap.Int("orphaned", report.Orphaned),
+	)
+	return report, nil
+}
+
+// orphaned reports whether processing task t has lost its worker
+func (q *Queue) orphaned(t *task.Task, live map[string]bool, now time.Time) bool {
+	if t.LeaseExpiresAt != nil {
+		return t.LeaseExpired(now)
+	}
+	if live != nil && t.Instance != "" && !live[t.Instance] {
+		return true
+	}
+	return t.StartedAt != nil && now.Sub(*t.StartedAt) > q.timeout(t)+orphanGrace
+}
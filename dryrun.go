@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// Outcomes a dry run can report
+const (
+	OutcomeQueued    = "queued"
+	OutcomeScheduled = "scheduled"
+	OutcomeSpilled   = "spilled"
+	OutcomeShed      = "shed"
+)
+
+// SubmitPlan describes what a submission would do, as worked out by a dry
+// run
+type SubmitPlan struct {
+	// Outcome is OutcomeQueued; OutcomeScheduled when an execution window
+	// holds the task back; OutcomeSpilled when it would go to overflow
+	// storage; or OutcomeShed when a lower priority task would be failed to
+	// make room for it
+	Outcome  string        `json:"outcome"`
+	Priority task.Priority `json:"priority"`
+	Version  int           `json:"version"`
+	// ScheduledAt is when a held task would be released
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	// Ahead counts the pending tasks that would run first
+	Ahead int64 `json:"ahead"`
+	// EstimatedWaitSeconds is Ahead over the rate tasks finished in the
+	// last five minutes. It is left out when nothing finished then.
+	EstimatedWaitSeconds *float64 `json:"estimated_wait_seconds,omitempty"`
+}
+
+// DryRun makes Submit, or SubmitChild, run every check on the task and fill
+// in plan instead of storing it. Submit hooks run as usual, so they must
+// not have side effects. Errors are those the real submission would
+// return.
+func DryRun(plan *SubmitPlan) SubmitOption {
+	return func(o *submitOptions) {
+		o.plan = plan
+	}
+}
+
+// plan fills in p for a dry run of t, which the caller has already checked
+// against submit hooks and execution windows
+func (q *Queue) plan(ctx context.Context, t *task.Task, opens time.Time, held bool, p *SubmitPlan) error {
+	now := q.clock.Now()
+	*p = SubmitPlan{Outcome: OutcomeQueued, Priority: t.Priority, Version: t.Version}
+	if held {
+		wait := opens.Sub(now).Seconds()
+		p.Outcome = OutcomeScheduled
+		p.ScheduledAt = &opens
+		p.EstimatedWaitSeconds = &wait
+		return nil
+	}
+
+	counts, err := q.storage.CountTasksByPriority(ctx, task.StatusPending)
+	if err != nil {
+		return err
+	}
+	if !q.inProcess && q.depth.enabled() {
+		atTotal, _, err := q.room(ctx, t)
+		if errors.Is(err, errNoRoom) {
+			switch {
+			case q.depth.Policy == OverflowSpill:
+				// Spilled tasks wait for room, not for the tasks ahead
+				p.Outcome = OutcomeSpilled
+				return nil
+			case q.depth.Policy == OverflowShed && atTotal && lowerPending(counts, t.Priority):
+				p.Outcome = OutcomeShed
+			default:
+				return errs.ErrQueueFull
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for priority, n := range counts {
+		if priority >= t.Priority {
+			p.Ahead += n
+		}
+	}
+
+	wait, ok, err := q.estimateWait(ctx, p.Ahead, now)
+	if err != nil {
+		return err
+	}
+	if ok {
+		seconds := wait.Seconds()
+		p.EstimatedWaitSeconds = &seconds
+	}
+	return nil
+}
+
+// lowerPending reports whether any task below priority p is pending
+func lowerPending(counts map[task.Priority]int64, p task.Priority) bool {
+	for lower := task.PriorityMin; lower < p; lower++ {
+		if counts[lower] > 0 {
+			return true
+		}
+	}
+	return false
+}
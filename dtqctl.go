@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `usage: dtqctl [-url URL] <command> [flags]
+
+commands:
+  export   write every task record to a file as JSON lines
+  import   restore task records written by export
+  ingest   submit new tasks from a JSON lines file or SQL query, rate limited
+  doctor   find inconsistent task records and indexes; -repair fixes them
+`
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "API server base URL")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		base: strings.TrimRight(*url, "/") + "/api/v1",
+		http: &http.Client{},
+	}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "export":
+		err = c.export(args)
+	case "import":
+		err = c.importTasks(args)
+	case "ingest":
+		err = c.ingest(args)
+	case "doctor":
+		err = c.doctor(args)
+	default:
+		fmt.Fprintf(os.Stderr, "dtqctl: unknown command %q\n\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dtqctl:", err)
+		os.Exit(1)
+	}
+}
+
+// client calls the admin API
+type client struct {
+	base string
+	http *http.Client
+}
+
+// export streams GET /admin/export to a file, or stdout for "-"
+func (c *client) export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", "-", "file to write, or - for stdout")
+	fs.Parse(args)
+
+	resp, err := c.http.Get(c.base + "/admin/export")
+	if err != nil {
+		return fmt.Errorf("failed to start export: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	if *out == "-" {
+		if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+			return fmt.Errorf("export interrupted: %w", err)
+		}
+		return nil
+	}
+
+	// Write next to the target and rename, so a failed export never leaves
+	// a truncated backup under the final name
+	f, err := os.CreateTemp(filepath.Dir(*out), ".dtqctl-export-*")
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return fmt.Errorf("export interrupted after %d bytes: %w", n, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := os.Rename(f.Name(), *out); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d bytes to %s\n", n, *out)
+	return nil
+}
+
+// importTasks sends a file, or stdin for "-", to POST /admin/import
+func (c *client) importTasks(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("i", "-", "file to read, or - for stdin")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	resp, err := c.http.Post(c.base+"/admin/import", "application/x-ndjson", r)
+	if err != nil {
+		return fmt.Errorf("failed to import: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	var result struct {
+		Imported int `json:"imported"`
+		Skipped  int `json:"skipped"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read import result: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d tasks, skipped %d that already exist\n", result.Imported, result.Skipped)
+	return nil
+}
+
+// doctor calls POST /admin/doctor and prints what it found
+func (c *client) doctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "fix what is found")
+	fs.Parse(args)
+
+	resp, err := c.http.Post(fmt.Sprintf("%s/admin/doctor?repair=%t", c.base, *repair), "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to check storage: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	var report struct {
+		Dangling       int  `json:"dangling"`
+		Stale          int  `json:"stale"`
+		Unindexed      int  `json:"unindexed"`
+		Counters       int  `json:"counters"`
+		IndexesChecked bool `json:"indexes_checked"`
+		Orphaned       int  `json:"orphaned"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return fmt.Errorf("failed to read report: %w", err)
+	}
+	verb := "found"
+	if *repair {
+		verb = "repaired"
+	}
+	if report.IndexesChecked {
+		fmt.Printf("index entries without a task record: %d %s\n", report.Dangling, verb)
+		fmt.Printf("index entries for a status the task left: %d %s\n", report.Stale, verb)
+		fmt.Printf("task records missing from their index: %d %s\n", report.Unindexed, verb)
+		fmt.Printf("counters off: %d %s\n", report.Counters, verb)
+	} else {
+		fmt.Println("indexes not checked: storage is wrapped or has none")
+	}
+	fmt.Printf("processing tasks whose worker is gone: %d %s\n", report.Orphaned, verb)
+	return nil
+}
+
+// apiError turns an error response into an error
+func apiError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return fmt.Errorf("server returned %s: %s (%s)", resp.Status, body.Error, body.Code)
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"go.uber.org/zap"
+)
+
+// DuplicateReport totals the duplicate submissions over a window, to find
+// producers that submit the same tasks again and again
+type DuplicateReport struct {
+	// Types and Producers total the duplicates by task type and by
+	// producer
+	Types     map[string]int64 `json:"types"`
+	Producers map[string]int64 `json:"producers"`
+	// Counts breaks them down by both, most frequent first
+	Counts []storage.DuplicateCount `json:"counts"`
+}
+
+// RecordDuplicate counts a submission of taskType by producer that was
+// rejected for repeating a task already submitted. Failing to store the
+// count is logged rather than returned, as the submission already failed.
+func (q *Queue) RecordDuplicate(ctx context.Context, taskType, producer string) {
+	metrics.DuplicateSubmissions.WithLabelValues(taskType, producer).Inc()
+	if err := q.storage.RecordDuplicate(ctx, taskType, producer); err != nil {
+		q.logger.Warn("failed to record duplicate submission",
+			zap.String("type", taskType),
+			zap.String("producer", producer),
+			zap.Error(err),
+		)
+	}
+}
+
+// DuplicateStats returns the duplicate submissions over the last window
+func (q *Queue) DuplicateStats(ctx context.Context, window time.Duration) (*DuplicateReport, error) {
+	now := q.clock.Now()
+	counts, err := q.storage.GetDuplicateStats(ctx, now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DuplicateReport{
+		Types:     make(map[string]int64),
+		Producers: make(map[string]int64),
+		Counts:    counts,
+	}
+	if report.Counts == nil {
+		report.Counts = []storage.DuplicateCount{}
+	}
+	for _, c := range counts {
+		report.Types[c.Type] += c.Count
+		report.Producers[c.Producer] += c.Count
+	}
+	return report, nil
+}
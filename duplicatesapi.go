@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// handleDuplicateStats reports the submissions rejected over a recent
+// window for repeating a task already submitted, by task type and producer
+func (s *Server) handleDuplicateStats(w http.ResponseWriter, r *http.Request) {
+	window, limit, err := parseErrorStatsParams(r)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	report, err := s.queue.DuplicateStats(r.Context(), window)
+	if err != nil {
+		s.logger.Error("failed to get duplicate stats", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	if len(report.Counts) > limit {
+		report.Counts = report.Counts[:limit]
+	}
+
+	s.respondJSON(w, r, http.StatusOK, DuplicateStatsResponse{
+		Window:          window.String(),
+		DuplicateReport: report,
+	})
+}
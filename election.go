@@ -0,0 +1,335 @@
+// Package election elects one leader per role across the cluster, so a
+// background coordinator such as the scheduler runs on exactly one
+// instance at a time. Candidates compete for a lease that the leader keeps
+// renewing; if it stops, another candidate takes over once the lease runs
+// out.
+package election
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Lease is the shared record candidates compete for. Implementations must
+// make each operation atomic across every instance using the same backend.
+type Lease interface {
+	// Acquire takes the lease for role if nobody holds it
+	Acquire(ctx context.Context, role, id string, ttl time.Duration) (bool, error)
+	// Renew extends the lease if id still holds it
+	Renew(ctx context.Context, role, id string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if id holds it
+	Release(ctx context.Context, role, id string) error
+	// Holder returns the ID holding the lease, or "" if nobody does
+	Holder(ctx context.Context, role string) (string, error)
+}
+
+// Config holds elector configuration
+type Config struct {
+	Lease  Lease
+	Logger *zap.Logger
+
+	// Role names what is being led, such as "scheduler". Every candidate
+	// for the same role must use the same name.
+	Role string
+
+	// ID identifies this candidate, defaults to hostname-pid
+	ID string
+
+	// LeaseDuration is how long leadership lasts without renewal, and so
+	// how long a crashed leader's role goes unfilled. Defaults to 15s.
+	LeaseDuration time.Duration
+
+	// RenewInterval is how often the leader renews and followers try to
+	// take over. Defaults to a third of LeaseDuration.
+	RenewInterval time.Duration
+}
+
+// Elector campaigns for one role on behalf of this instance
+type Elector struct {
+	lease  Lease
+	logger *zap.Logger
+	config Config
+
+	// leaseUntil is when this instance's leadership runs out unless it is
+	// renewed, in Unix nanoseconds; zero when not leading
+	leaseUntil atomic.Int64
+
+	mu      sync.Mutex
+	resigns chan struct{}
+}
+
+// New creates an elector. Call Run to start campaigning.
+func New(cfg Config) *Elector {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.ID == "" {
+		host, _ := os.Hostname()
+		cfg.ID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.LeaseDuration == 0 {
+		cfg.LeaseDuration = 15 * time.Second
+	}
+	if cfg.RenewInterval == 0 {
+		cfg.RenewInterval = cfg.LeaseDuration / 3
+	}
+
+	return &Elector{
+		lease: cfg.Lease,
+		logger: cfg.Logger.With(
+			zap.String("role", cfg.Role),
+			zap.String("candidate", cfg.ID),
+		),
+		config:  cfg,
+		resigns: make(chan struct{}, 1),
+	}
+}
+
+// ID returns this candidate's identity
+func (e *Elector) ID() string {
+	return e.config.ID
+}
+
+// Role returns the role this elector campaigns for
+func (e *Elector) Role() string {
+	return e.config.Role
+}
+
+// IsLeader reports whether this instance holds the role. Leadership ends
+// when the lease would have run out, even if renewing failed because the
+// backend was unreachable, so a leader that is cut off steps down before
+// another candidate can take over.
+func (e *Elector) IsLeader() bool {
+	return time.Now().UnixNano() < e.leaseUntil.Load()
+}
+
+// Leader returns the ID of the current leader, or "" if there is none
+func (e *Elector) Leader(ctx context.Context) (string, error) {
+	return e.lease.Holder(ctx, e.config.Role)
+}
+
+// Run campaigns for the role until ctx is done, then resigns. The leader
+// renews its lease every RenewInterval; followers try to take it over.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx)
+
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			e.Resign(releaseCtx)
+			cancel()
+			return
+		case <-e.resigns:
+			// Sit out one interval so another candidate can take over
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign renews the lease when leading, or tries to acquire it
+func (e *Elector) campaign(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start := time.Now()
+	leading := e.leaseUntil.Load() != 0
+
+	var ok bool
+	var err error
+	if leading {
+		ok, err = e.lease.Renew(ctx, e.config.Role, e.config.ID, e.config.LeaseDuration)
+	} else {
+		ok, err = e.lease.Acquire(ctx, e.config.Role, e.config.ID, e.config.LeaseDuration)
+	}
+
+	switch {
+	case err != nil:
+		e.logger.Warn("leader election failed", zap.Error(err))
+		if leading && !e.IsLeader() {
+			e.stepDown("lost", "leadership lost: lease expired while the backend was unreachable")
+		}
+	case ok:
+		// Measured from before the call, so the lease never outlives its
+		// copy in the backend
+		e.leaseUntil.Store(start.Add(e.config.LeaseDuration).UnixNano())
+		if !leading {
+			e.logger.Info("acquired leadership")
+			metrics.LeadershipChanges.WithLabelValues(e.config.Role, "acquired").Inc()
+			metrics.Leader.WithLabelValues(e.config.Role).Set(1)
+		}
+	case leading:
+		e.stepDown("lost", "leadership lost: lease taken over")
+	}
+}
+
+// stepDown clears leadership. Must be called with e.mu held.
+func (e *Elector) stepDown(event, msg string) {
+	e.leaseUntil.Store(0)
+	e.logger.Warn(msg)
+	metrics.LeadershipChanges.WithLabelValues(e.config.Role, event).Inc()
+	metrics.Leader.WithLabelValues(e.config.Role).Set(0)
+}
+
+// Resign gives up leadership, if held, so another candidate can take over
+// without waiting for the lease to run out. A running elector sits out one
+// renew interval before campaigning again.
+func (e *Elector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.leaseUntil.Load() == 0 {
+		return nil
+	}
+	e.leaseUntil.Store(0)
+	e.logger.Info("resigned leadership")
+	metrics.LeadershipChanges.WithLabelValues(e.config.Role, "resigned").Inc()
+	metrics.Leader.WithLabelValues(e.config.Role).Set(0)
+
+	select {
+	case e.resigns <- struct{}{}:
+	default:
+	}
+	if err := e.lease.Release(ctx, e.config.Role, e.config.ID); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// RedisLease keeps leases in Redis, one key per role
+type RedisLease struct {
+	client *redis.Client
+}
+
+// NewRedisLease creates a lease backed by client
+func NewRedisLease(client *redis.Client) *RedisLease {
+	return &RedisLease{client: client}
+}
+
+// leaseKey returns the Redis key holding the leader of role
+func leaseKey(role string) string {
+	return "election:" + role
+}
+
+// renewScript extends the lease only while id still holds it
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes the lease only while id still holds it
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (r *RedisLease) Acquire(ctx context.Context, role, id string, ttl time.Duration) (bool, error) {
+	ok, err := r.client.SetNX(ctx, leaseKey(role), id, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return ok, nil
+}
+
+func (r *RedisLease) Renew(ctx context.Context, role, id string, ttl time.Duration) (bool, error) {
+	n, err := renewScript.Run(ctx, r.client, []string{leaseKey(role)}, id, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lease: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (r *RedisLease) Release(ctx context.Context, role, id string) error {
+	return releaseScript.Run(ctx, r.client, []string{leaseKey(role)}, id).Err()
+}
+
+func (r *RedisLease) Holder(ctx context.Context, role string) (string, error) {
+	id, err := r.client.Get(ctx, leaseKey(role)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get lease holder: %w", err)
+	}
+	return id, nil
+}
+
+// MemoryLease keeps leases in memory, for tests and single-process
+// deployments
+type MemoryLease struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	leases map[string]memoryLease
+}
+
+type memoryLease struct {
+	holder  string
+	expires time.Time
+}
+
+// NewMemoryLease creates an in-memory lease
+func NewMemoryLease() *MemoryLease {
+	return &MemoryLease{now: time.Now, leases: make(map[string]memoryLease)}
+}
+
+// holder returns the live holder of role. Must be called with m.mu held.
+func (m *MemoryLease) holder(role string) string {
+	l, ok := m.leases[role]
+	if !ok || !m.now().Before(l.expires) {
+		return ""
+	}
+	return l.holder
+}
+
+func (m *MemoryLease) Acquire(ctx context.Context, role, id string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holder(role) != "" {
+		return false, nil
+	}
+	m.leases[role] = memoryLease{holder: id, expires: m.now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryLease) Renew(ctx context.Context, role, id string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holder(role) != id {
+		return false, nil
+	}
+	m.leases[role] = memoryLease{holder: id, expires: m.now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryLease) Release(ctx context.Context, role, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holder(role) == id {
+		delete(m.leases, role)
+	}
+	return nil
+}
+
+func (m *MemoryLease) Holder(ctx context.Context, role string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.holder(role), nil
+}
@@ -0,0 +1,101 @@
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestElector(lease Lease, id string) *Elector {
+	return New(Config{
+		Lease:         lease,
+		Logger:        zap.NewNop(),
+		Role:          "scheduler",
+		ID:            id,
+		LeaseDuration: time.Minute,
+	})
+}
+
+func TestElector_SingleLeader(t *testing.T) {
+	ctx := context.Background()
+	lease := NewMemoryLease()
+	a := newTestElector(lease, "a")
+	b := newTestElector(lease, "b")
+
+	a.campaign(ctx)
+	b.campaign(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	leader, err := b.Leader(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", leader)
+
+	// Renewing keeps the leader in place
+	a.campaign(ctx)
+	b.campaign(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// Resigning hands over without waiting for the lease
+	require.NoError(t, a.Resign(ctx))
+	assert.False(t, a.IsLeader())
+	b.campaign(ctx)
+	assert.True(t, b.IsLeader())
+}
+
+func TestElector_LeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	lease := NewMemoryLease()
+	lease.now = func() time.Time { return now }
+
+	a := newTestElector(lease, "a")
+	b := newTestElector(lease, "b")
+	a.campaign(ctx)
+	require.True(t, a.IsLeader())
+
+	// a stops renewing, e.g. it hung or crashed; b takes over once the
+	// lease runs out, and a learns it lost the role on its next renewal
+	now = now.Add(2 * time.Minute)
+	b.campaign(ctx)
+	assert.True(t, b.IsLeader())
+
+	a.campaign(ctx)
+	assert.False(t, a.IsLeader())
+}
+
+func TestElector_Run(t *testing.T) {
+	lease := NewMemoryLease()
+	e := New(Config{
+		Lease:         lease,
+		Logger:        zap.NewNop(),
+		Role:          "janitor",
+		ID:            "a",
+		LeaseDuration: 300 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	// Leadership outlives the lease because Run keeps renewing it
+	require.Eventually(t, e.IsLeader, time.Second, 10*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	assert.True(t, e.IsLeader())
+
+	// Stopping resigns, freeing the role at once
+	cancel()
+	<-done
+	assert.False(t, e.IsLeader())
+	holder, err := lease.Holder(context.Background(), "janitor")
+	require.NoError(t, err)
+	assert.Empty(t, holder)
+}
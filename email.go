@@ -0,0 +1,312 @@
+// Package email sends email as a built-in task handler, through SMTP,
+// Amazon SES or SendGrid, with optional templates. Rejections by the
+// provider are mapped onto the queue's retries: hard bounces fail the task
+// at once, soft bounces and outages are retried.
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"go.uber.org/zap"
+)
+
+// TaskType is the type of the tasks Handler sends
+const TaskType = "send_email"
+
+// Message is one email
+type Message struct {
+	From    string
+	To      []string
+	Cc      []string
+	ReplyTo string
+	Subject string
+	// Text and HTML are the plain and HTML bodies; at least one is set
+	Text string
+	HTML string
+}
+
+// Provider delivers messages
+type Provider interface {
+	// Send delivers msg, returning the provider's message ID. A *Bounce
+	// error means the provider or receiving server rejected it.
+	Send(ctx context.Context, msg *Message) (string, error)
+}
+
+// Bounce is a message rejected by the provider or the receiving server
+type Bounce struct {
+	// Code is the SMTP reply code or HTTP status of the rejection
+	Code    int
+	Message string
+	// Hard is set for rejections a retry cannot fix, such as an unknown
+	// mailbox; others, such as a full mailbox, may succeed later
+	Hard bool
+}
+
+func (b *Bounce) Error() string {
+	kind := "soft"
+	if b.Hard {
+		kind = "hard"
+	}
+	return fmt.Sprintf("email %s bounce (%d): %s", kind, b.Code, b.Message)
+}
+
+// Config holds handler configuration
+type Config struct {
+	Provider Provider
+
+	// From is the sender of payloads that name none
+	From string
+
+	// Store holds the templates payloads name, managed through the API.
+	// Names it does not have fall back to Templates.
+	Store templates.Store
+
+	// Templates renders payloads that name a template
+	Templates *Templates
+}
+
+// Payload is the payload of a TaskType task
+type Payload struct {
+	// To lists the recipients; Recipient is accepted for a single one
+	To        []string `json:"to,omitempty"`
+	Recipient string   `json:"recipient,omitempty"`
+	Cc        []string `json:"cc,omitempty"`
+	From      string   `json:"from,omitempty"`
+	ReplyTo   string   `json:"reply_to,omitempty"`
+
+	// Subject, Body and HTML are sent as given...
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body,omitempty"`
+	HTML    string `json:"html,omitempty"`
+
+	// ...unless Template names one, which is rendered with Data.
+	// TemplateVersion pins a version from the Store, instead of the latest.
+	Template        string                 `json:"template,omitempty"`
+	TemplateVersion int                    `json:"template_version,omitempty"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+}
+
+// Handler sends the email each task describes, saving the provider's
+// message ID as the task's result
+func Handler(cfg Config) func(ctx context.Context, t *task.Task) error {
+	return func(ctx context.Context, t *task.Task) error {
+		logger := task.LoggerFromContext(ctx)
+
+		var p Payload
+		if err := decodePayload(t.Payload, &p); err != nil {
+			return task.Permanent(err)
+		}
+		// Fetch the template first: the store being unreachable is worth a
+		// retry, unlike anything wrong with the payload
+		var stored *templates.Template
+		if p.Template != "" && cfg.Store != nil {
+			var err error
+			stored, err = cfg.Store.Get(ctx, p.Template, p.TemplateVersion)
+			if err != nil && !errors.Is(err, errs.ErrTemplateNotFound) {
+				return err
+			}
+		}
+		msg, err := cfg.message(p, stored)
+		if err != nil {
+			// The payload will not get any better on a retry
+			return task.Permanent(err)
+		}
+
+		id, err := cfg.Provider.Send(ctx, msg)
+		var bounce *Bounce
+		if errors.As(err, &bounce) {
+			logger.Warn("email bounced",
+				zap.Int("code", bounce.Code),
+				zap.Bool("hard", bounce.Hard),
+				zap.String("reason", bounce.Message),
+			)
+			if bounce.Hard {
+				return task.Permanent(err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		logger.Info("email sent", zap.String("message_id", id), zap.Int("recipients", len(msg.To)+len(msg.Cc)))
+		return t.SetResult(map[string]interface{}{"message_id": id})
+	}
+}
+
+// message builds the message p describes, from stored if p's template was
+// found in the Store, and checks its addresses
+func (cfg Config) message(p Payload, stored *templates.Template) (*Message, error) {
+	msg := &Message{
+		From:    p.From,
+		To:      p.To,
+		Cc:      p.Cc,
+		ReplyTo: p.ReplyTo,
+		Subject: p.Subject,
+		Text:    p.Body,
+		HTML:    p.HTML,
+	}
+	if p.Recipient != "" {
+		msg.To = append(msg.To, p.Recipient)
+	}
+	if msg.From == "" {
+		msg.From = cfg.From
+	}
+
+	switch {
+	case stored != nil:
+		rendered, err := stored.Render(p.Data)
+		if err != nil {
+			return nil, err
+		}
+		msg.Subject, msg.Text, msg.HTML = rendered.Subject, rendered.Text, rendered.HTML
+	case p.Template != "" && p.TemplateVersion > 0:
+		return nil, fmt.Errorf("email template %q has no version %d", p.Template, p.TemplateVersion)
+	case p.Template != "":
+		if cfg.Templates == nil {
+			return nil, fmt.Errorf("email template %q requested but no templates are loaded", p.Template)
+		}
+		if err := cfg.Templates.Render(p.Template, p.Data, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case len(msg.To) == 0:
+		return nil, errors.New("email needs at least one recipient")
+	case msg.From == "":
+		return nil, errors.New("email needs a sender")
+	case msg.Text == "" && msg.HTML == "":
+		return nil, errors.New("email needs a body")
+	}
+	addresses := append([]string{msg.From}, append(msg.To, msg.Cc...)...)
+	if msg.ReplyTo != "" {
+		addresses = append(addresses, msg.ReplyTo)
+	}
+	for _, addr := range addresses {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid email address %q", addr)
+		}
+	}
+	return msg, nil
+}
+
+// Templates renders named messages. Each template is a set of files in one
+// directory: <name>.subject.tmpl and at least one of <name>.text.tmpl and
+// <name>.html.tmpl, written with Go templates. HTML templates escape the
+// data they insert.
+type Templates struct {
+	subjects map[string]*texttemplate.Template
+	texts    map[string]*texttemplate.Template
+	htmls    map[string]*htmltemplate.Template
+}
+
+// LoadTemplates parses every template in dir
+func LoadTemplates(dir string) (*Templates, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &Templates{
+		subjects: make(map[string]*texttemplate.Template),
+		texts:    make(map[string]*texttemplate.Template),
+		htmls:    make(map[string]*htmltemplate.Template),
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read email template: %w", err)
+		}
+		base := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		name, part := base, ""
+		if i := strings.LastIndexByte(base, '.'); i >= 0 {
+			name, part = base[:i], base[i+1:]
+		}
+
+		switch part {
+		case "subject":
+			tmpl.subjects[name], err = texttemplate.New(base).Option("missingkey=error").Parse(string(data))
+		case "text":
+			tmpl.texts[name], err = texttemplate.New(base).Option("missingkey=error").Parse(string(data))
+		case "html":
+			tmpl.htmls[name], err = htmltemplate.New(base).Option("missingkey=error").Parse(string(data))
+		default:
+			return nil, fmt.Errorf("email template %s must end in .subject.tmpl, .text.tmpl or .html.tmpl", filepath.Base(path))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid email template %s: %w", filepath.Base(path), err)
+		}
+	}
+
+	for name := range tmpl.subjects {
+		if tmpl.texts[name] == nil && tmpl.htmls[name] == nil {
+			return nil, fmt.Errorf("email template %s has no text or html body", name)
+		}
+	}
+	for name := range tmpl.texts {
+		if tmpl.subjects[name] == nil {
+			return nil, fmt.Errorf("email template %s has no subject", name)
+		}
+	}
+	for name := range tmpl.htmls {
+		if tmpl.subjects[name] == nil {
+			return nil, fmt.Errorf("email template %s has no subject", name)
+		}
+	}
+	return tmpl, nil
+}
+
+// Render fills in msg's subject and bodies from the named template
+func (t *Templates) Render(name string, data map[string]interface{}, msg *Message) error {
+	subject, ok := t.subjects[name]
+	if !ok {
+		return fmt.Errorf("unknown email template %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := subject.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render email template %s: %w", name, err)
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	if text, ok := t.texts[name]; ok {
+		buf.Reset()
+		if err := text.Execute(&buf, data); err != nil {
+			return fmt.Errorf("failed to render email template %s: %w", name, err)
+		}
+		msg.Text = buf.String()
+	}
+	if html, ok := t.htmls[name]; ok {
+		buf.Reset()
+		if err := html.Execute(&buf, data); err != nil {
+			return fmt.Errorf("failed to render email template %s: %w", name, err)
+		}
+		msg.HTML = buf.String()
+	}
+	return nil
+}
+
+// decodePayload decodes a task payload into v
+func decodePayload(payload map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(payload)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid email payload: %w", err)
+	}
+	return nil
+}
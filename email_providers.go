@@ -0,0 +1,426 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/sigv4"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// SMTPConfig holds SMTPProvider configuration
+type SMTPConfig struct {
+	// Addr is the server's host:port
+	Addr     string
+	Username string
+	Password string
+
+	// Timeout bounds each delivery, defaults to 30s
+	Timeout time.Duration
+}
+
+// SMTPProvider delivers messages to an SMTP relay, upgrading to TLS when
+// the server offers STARTTLS
+type SMTPProvider struct {
+	config SMTPConfig
+	host   string
+}
+
+// NewSMTPProvider creates an SMTP provider
+func NewSMTPProvider(cfg SMTPConfig) (*SMTPProvider, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", cfg.Addr, err)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &SMTPProvider{config: cfg, host: host}, nil
+}
+
+// Send delivers msg. Permanent (5xx) replies are hard bounces and
+// transient (4xx) replies soft ones.
+func (p *SMTPProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.config.Addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		conn.Close()
+		return "", smtpError(err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: p.host}); err != nil {
+			return "", fmt.Errorf("SMTP STARTTLS failed: %w", err)
+		}
+	}
+	if p.config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", p.config.Username, p.config.Password, p.host)); err != nil {
+			return "", fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	id, data, err := buildMIME(msg, p.host)
+	if err != nil {
+		return "", err
+	}
+	if err := c.Mail(addressOf(msg.From)); err != nil {
+		return "", smtpError(err)
+	}
+	for _, rcpt := range append(append([]string{}, msg.To...), msg.Cc...) {
+		if err := c.Rcpt(addressOf(rcpt)); err != nil {
+			return "", smtpError(err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return "", smtpError(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", smtpError(err)
+	}
+	if err := w.Close(); err != nil {
+		return "", smtpError(err)
+	}
+	c.Quit()
+	return id, nil
+}
+
+// smtpError turns a rejection reply into a Bounce
+func smtpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 400 {
+		return &Bounce{Code: reply.Code, Message: reply.Msg, Hard: reply.Code >= 500}
+	}
+	return fmt.Errorf("SMTP delivery failed: %w", err)
+}
+
+// addressOf returns the bare address of "Name <addr>"
+func addressOf(s string) string {
+	if i := strings.LastIndexByte(s, '<'); i >= 0 {
+		return strings.TrimSuffix(s[i+1:], ">")
+	}
+	return s
+}
+
+// buildMIME encodes msg as a MIME message with a new Message-ID
+func buildMIME(msg *Message, host string) (string, []byte, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	id := fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), host)
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", msg.From)
+	header("To", strings.Join(msg.To, ", "))
+	if len(msg.Cc) > 0 {
+		header("Cc", strings.Join(msg.Cc, ", "))
+	}
+	if msg.ReplyTo != "" {
+		header("Reply-To", msg.ReplyTo)
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+
+	var parts []mimePart
+	if msg.Text != "" {
+		parts = append(parts, mimePart{contentType: "text/plain; charset=utf-8", body: msg.Text})
+	}
+	if msg.HTML != "" {
+		parts = append(parts, mimePart{contentType: "text/html; charset=utf-8", body: msg.HTML})
+	}
+
+	if len(parts) == 1 {
+		header("Content-Type", parts[0].contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, parts[0].body); err != nil {
+			return "", nil, err
+		}
+		return id, buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return id, buf.Bytes(), nil
+}
+
+// mimePart is one body of a message
+type mimePart struct {
+	contentType string
+	body        string
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// SESConfig holds SESProvider configuration
+type SESConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Endpoint defaults to https://email.<Region>.amazonaws.com
+	Endpoint string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// SESProvider sends through the Amazon SES v2 API
+type SESProvider struct {
+	config SESConfig
+	signer sigv4.Signer
+}
+
+// NewSESProvider creates an SES provider
+func NewSESProvider(cfg SESConfig) (*SESProvider, error) {
+	if cfg.Region == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("SES needs a region and credentials")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://email.%s.amazonaws.com", cfg.Region)
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &SESProvider{
+		config: cfg,
+		signer: sigv4.Signer{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Region:          cfg.Region,
+			Service:         "ses",
+		},
+	}, nil
+}
+
+// Send delivers msg. Rejected messages are hard bounces, and throttled
+// sends are retried after a second or more.
+func (p *SESProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	type content struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	body := map[string]*content{}
+	if msg.Text != "" {
+		body["Text"] = &content{Data: msg.Text, Charset: "UTF-8"}
+	}
+	if msg.HTML != "" {
+		body["Html"] = &content{Data: msg.HTML, Charset: "UTF-8"}
+	}
+	req := map[string]interface{}{
+		"FromEmailAddress": msg.From,
+		"Destination": map[string][]string{
+			"ToAddresses": msg.To,
+			"CcAddresses": msg.Cc,
+		},
+		"Content": map[string]interface{}{
+			"Simple": map[string]interface{}{
+				"Subject": content{Data: msg.Subject, Charset: "UTF-8"},
+				"Body":    body,
+			},
+		},
+	}
+	if msg.ReplyTo != "" {
+		req["ReplyToAddresses"] = []string{msg.ReplyTo}
+	}
+
+	var resp struct {
+		MessageID string `json:"MessageId"`
+	}
+	status, err := p.post(ctx, req, &resp)
+	switch {
+	case err == nil:
+		return resp.MessageID, nil
+	case status == http.StatusTooManyRequests:
+		return "", task.RetryAfter(err, time.Second)
+	case status == http.StatusBadRequest:
+		return "", &Bounce{Code: status, Message: err.Error(), Hard: true}
+	}
+	return "", err
+}
+
+// post sends req to SES, decoding the reply into resp
+func (p *SESProvider) post(ctx context.Context, req, resp interface{}) (int, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(p.config.Endpoint, "/")+"/v2/email/outbound-emails", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	p.signer.Sign(httpReq, sigv4.HashPayload(data))
+
+	httpResp, err := p.config.Client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("SES request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(httpResp.Body, 4096)).Decode(&apiErr)
+		return httpResp.StatusCode, fmt.Errorf("SES %s: %s %s", httpResp.Status,
+			httpResp.Header.Get("X-Amzn-ErrorType"), apiErr.Message)
+	}
+	return httpResp.StatusCode, json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// SendGridConfig holds SendGridProvider configuration
+type SendGridConfig struct {
+	APIKey string
+
+	// Endpoint defaults to https://api.sendgrid.com
+	Endpoint string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// SendGridProvider sends through the SendGrid v3 mail API
+type SendGridProvider struct {
+	config SendGridConfig
+}
+
+// NewSendGridProvider creates a SendGrid provider
+func NewSendGridProvider(cfg SendGridConfig) (*SendGridProvider, error) {
+	if cfg.APIKey == "" {
+		return nil, errors.New("SendGrid needs an API key")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://api.sendgrid.com"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &SendGridProvider{config: cfg}, nil
+}
+
+// Send delivers msg. Rejected requests are hard bounces, and rate limited
+// sends are retried once the limit resets.
+func (p *SendGridProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	type address struct {
+		Email string `json:"email"`
+	}
+	addresses := func(list []string) []address {
+		out := make([]address, len(list))
+		for i, a := range list {
+			out[i] = address{Email: addressOf(a)}
+		}
+		return out
+	}
+	personalization := map[string]interface{}{"to": addresses(msg.To)}
+	if len(msg.Cc) > 0 {
+		personalization["cc"] = addresses(msg.Cc)
+	}
+	var content []map[string]string
+	if msg.Text != "" {
+		content = append(content, map[string]string{"type": "text/plain", "value": msg.Text})
+	}
+	if msg.HTML != "" {
+		content = append(content, map[string]string{"type": "text/html", "value": msg.HTML})
+	}
+	req := map[string]interface{}{
+		"personalizations": []interface{}{personalization},
+		"from":             address{Email: addressOf(msg.From)},
+		"subject":          msg.Subject,
+		"content":          content,
+	}
+	if msg.ReplyTo != "" {
+		req["reply_to"] = address{Email: addressOf(msg.ReplyTo)}
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(p.config.Endpoint, "/")+"/v3/mail/send", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+
+	resp, err := p.config.Client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("SendGrid request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		return resp.Header.Get("X-Message-Id"), nil
+	}
+
+	reason, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err = fmt.Errorf("SendGrid %s: %s", resp.Status, bytes.TrimSpace(reason))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		wait := time.Minute
+		if reset, perr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); perr == nil {
+			wait = time.Until(time.Unix(reset, 0))
+		}
+		return "", task.RetryAfter(err, wait)
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return "", &Bounce{Code: resp.StatusCode, Message: err.Error(), Hard: true}
+	}
+	return "", err
+}
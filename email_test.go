@@ -0,0 +1,246 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+)
+
+// fakeProvider records messages and fails with err
+type fakeProvider struct {
+	sent []*Message
+	err  error
+}
+
+func (p *fakeProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	p.sent = append(p.sent, msg)
+	return "msg-1", nil
+}
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"welcome.subject.tmpl": "Welcome, {{.name}}",
+		"welcome.text.tmpl":    "Hi {{.name}}, thanks for joining.",
+		"welcome.html.tmpl":    "<p>Hi {{.name}}</p>",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600))
+	}
+	templates, err := LoadTemplates(dir)
+	require.NoError(t, err)
+
+	provider := &fakeProvider{}
+	handler := Handler(Config{Provider: provider, From: "Queue <noreply@example.com>", Templates: templates})
+	ctx := context.Background()
+
+	tk := task.NewTask(TaskType, task.PriorityHigh, map[string]interface{}{
+		"recipient": "ada@example.com",
+		"template":  "welcome",
+		"data":      map[string]interface{}{"name": "<Ada>"},
+	})
+	require.NoError(t, handler(ctx, tk))
+	require.Len(t, provider.sent, 1)
+	msg := provider.sent[0]
+	assert.Equal(t, []string{"ada@example.com"}, msg.To)
+	assert.Equal(t, "Queue <noreply@example.com>", msg.From)
+	assert.Equal(t, "Welcome, <Ada>", msg.Subject)
+	assert.Equal(t, "Hi <Ada>, thanks for joining.", msg.Text)
+	assert.Equal(t, "<p>Hi &lt;Ada&gt;</p>", msg.HTML)
+	assert.Equal(t, "msg-1", tk.Result["message_id"])
+
+	// Bad payloads and hard bounces fail for good; soft bounces retry
+	for _, payload := range []map[string]interface{}{
+		{"subject": "Hi", "body": "no recipient"},
+		{"recipient": "not an address", "subject": "Hi", "body": "x"},
+		{"recipient": "ada@example.com", "template": "welcome", "data": map[string]interface{}{}},
+		{"recipient": "ada@example.com", "template": "unknown"},
+	} {
+		err := handler(ctx, task.NewTask(TaskType, task.PriorityHigh, payload))
+		assert.True(t, task.IsPermanent(err), "%v: %v", payload, err)
+	}
+	plain := map[string]interface{}{"to": []string{"ada@example.com"}, "subject": "Hi", "body": "x"}
+	provider.err = &Bounce{Code: 550, Message: "no such user", Hard: true}
+	assert.True(t, task.IsPermanent(handler(ctx, task.NewTask(TaskType, task.PriorityHigh, plain))))
+	provider.err = &Bounce{Code: 452, Message: "mailbox full"}
+	err = handler(ctx, task.NewTask(TaskType, task.PriorityHigh, plain))
+	require.Error(t, err)
+	assert.False(t, task.IsPermanent(err))
+}
+
+func TestHandler_StoredTemplates(t *testing.T) {
+	ctx := context.Background()
+	store := templates.NewMemoryStore()
+	require.NoError(t, store.Put(ctx, &templates.Template{Name: "digest", Subject: "Digest v1", Text: "{{.count}} new"}))
+	require.NoError(t, store.Put(ctx, &templates.Template{Name: "digest", Subject: "Digest v2", Text: "{{.count}} updates"}))
+
+	provider := &fakeProvider{}
+	handler := Handler(Config{Provider: provider, From: "noreply@example.com", Store: store})
+	send := func(payload map[string]interface{}) error {
+		payload["to"] = []string{"ada@example.com"}
+		payload["data"] = map[string]interface{}{"count": 3}
+		return handler(ctx, task.NewTask(TaskType, task.PriorityHigh, payload))
+	}
+
+	require.NoError(t, send(map[string]interface{}{"template": "digest"}))
+	require.NoError(t, send(map[string]interface{}{"template": "digest", "template_version": 1}))
+	require.Len(t, provider.sent, 2)
+	assert.Equal(t, "Digest v2", provider.sent[0].Subject)
+	assert.Equal(t, "3 updates", provider.sent[0].Text)
+	assert.Equal(t, "Digest v1", provider.sent[1].Subject)
+
+	assert.True(t, task.IsPermanent(send(map[string]interface{}{"template": "digest", "template_version": 9})))
+	assert.True(t, task.IsPermanent(send(map[string]interface{}{"template": "unknown"})))
+}
+
+func TestLoadTemplates_Incomplete(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "reset.text.tmpl"), []byte("Reset"), 0o600))
+	_, err := LoadTemplates(dir)
+	assert.ErrorContains(t, err, "has no subject")
+}
+
+// fakeSMTP serves one SMTP session, rejecting rcpt "gone@example.com", and
+// returns what was sent with DATA
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 fake")
+			case strings.Contains(cmd, "GONE@EXAMPLE.COM"):
+				reply("550 5.1.1 no such user")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				var body strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					body.WriteString(l)
+				}
+				data <- body.String()
+				reply("250 queued")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), data
+}
+
+func TestSMTPProvider(t *testing.T) {
+	addr, data := fakeSMTP(t)
+	provider, err := NewSMTPProvider(SMTPConfig{Addr: addr, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+
+	msg := &Message{From: "noreply@example.com", To: []string{"ada@example.com"}, Subject: "Grüße", Text: "hello", HTML: "<b>hello</b>"}
+	id, err := provider.Send(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Contains(t, id, "@127.0.0.1>")
+	sent := <-data
+	assert.Contains(t, sent, "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=")
+	assert.Contains(t, sent, "Message-ID: "+id)
+	assert.Contains(t, sent, "multipart/alternative")
+
+	addr, _ = fakeSMTP(t)
+	provider, err = NewSMTPProvider(SMTPConfig{Addr: addr, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	msg.To = []string{"gone@example.com"}
+	_, err = provider.Send(context.Background(), msg)
+	var bounce *Bounce
+	require.True(t, errors.As(err, &bounce), "%v", err)
+	assert.Equal(t, 550, bounce.Code)
+	assert.True(t, bounce.Hard)
+}
+
+func TestSendGridProvider(t *testing.T) {
+	status := http.StatusAccepted
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/mail/send", r.URL.Path)
+		assert.Equal(t, "Bearer sg-key", r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("X-Message-Id", "sg-1")
+		w.Header().Set("X-RateLimit-Reset", "0")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	provider, err := NewSendGridProvider(SendGridConfig{APIKey: "sg-key", Endpoint: server.URL})
+	require.NoError(t, err)
+	msg := &Message{From: "Queue <noreply@example.com>", To: []string{"ada@example.com"}, Subject: "Hi", Text: "hello"}
+
+	id, err := provider.Send(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, "sg-1", id)
+	assert.Equal(t, map[string]interface{}{"email": "noreply@example.com"}, got["from"])
+
+	status = http.StatusTooManyRequests
+	_, err = provider.Send(context.Background(), msg)
+	_, ok := task.RetryDelay(err)
+	assert.True(t, ok, "%v", err)
+
+	status = http.StatusBadRequest
+	_, err = provider.Send(context.Background(), msg)
+	var bounce *Bounce
+	require.True(t, errors.As(err, &bounce))
+	assert.True(t, bounce.Hard)
+}
+
+func TestSESProvider(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/email/outbound-emails", r.URL.Path)
+		assert.Regexp(t, `^AWS4-HMAC-SHA256 Credential=key/\d{8}/eu-west-1/ses/aws4_request, `, r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"MessageId": "ses-1"}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewSESProvider(SESConfig{Region: "eu-west-1", AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: server.URL})
+	require.NoError(t, err)
+	id, err := provider.Send(context.Background(), &Message{From: "noreply@example.com", To: []string{"ada@example.com"}, Subject: "Hi", HTML: "<b>hello</b>"})
+	require.NoError(t, err)
+	assert.Equal(t, "ses-1", id)
+	assert.Equal(t, "noreply@example.com", got["FromEmailAddress"])
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// PayloadCipher encrypts and decrypts task payloads. Sealed values must
+// identify the key they were sealed with, so keys can be rotated while
+// older tasks are still stored.
+type PayloadCipher interface {
+	// Seal encrypts plaintext, binding it to aad
+	Seal(plaintext, aad []byte) (string, error)
+	// Open decrypts a value returned by Seal with the same aad
+	Open(sealed string, aad []byte) ([]byte, error)
+}
+
+// Keyring is a PayloadCipher using AES-GCM with named keys. New payloads
+// are sealed with the primary key; any key in the ring can open them.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from 16, 24 or 32 byte AES keys by ID.
+// primary must be one of them.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+
+	k := &Keyring{primary: primary, aeads: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("key ID %q must be non-empty and must not contain ':'", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring parses "id:base64key,id:base64key". The first key is the
+// primary; the others are kept to open payloads sealed before rotation.
+func ParseKeyring(spec string) (*Keyring, error) {
+	var primary string
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("key entry must be id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+	if primary == "" {
+		return nil, fmt.Errorf("keyring is empty")
+	}
+	return NewKeyring(primary, keys)
+}
+
+// Seal encrypts plaintext with the primary key as "keyID:base64(nonce|ciphertext)"
+func (k *Keyring) Seal(plaintext, aad []byte) (string, error) {
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, aad)
+	return k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal with any key in the ring
+func (k *Keyring) Open(sealed string, aad []byte) ([]byte, error) {
+	id, encoded, ok := strings.Cut(sealed, ":")
+	if !ok {
+		return nil, fmt.Errorf("sealed payload has no key ID")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown payload key %q", id)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed payload is malformed")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload with key %q: %w", id, err)
+	}
+	return plaintext, nil
+}
+
+// EncryptedStorage encrypts task payloads and checkpoints before they
+// reach the wrapped storage and decrypts them on the way back. Only those
+// are encrypted; type, labels and other fields stay readable for indexing.
+type EncryptedStorage struct {
+	Storage
+	cipher PayloadCipher
+	logger *zap.Logger
+}
+
+// NewEncryptedStorage wraps store so payloads are stored encrypted with c
+func NewEncryptedStorage(store Storage, c PayloadCipher) *EncryptedStorage {
+	return &EncryptedStorage{Storage: store, cipher: c, logger: zap.NewNop()}
+}
+
+// SetLogger sets where tasks left out of reads, as their payloads do not
+// decrypt, are reported
+func (e *EncryptedStorage) SetLogger(logger *zap.Logger) {
+	e.logger = logger
+}
+
+// checkpointAAD binds a checkpoint's ciphertext to its task, apart from
+// the payload's
+func checkpointAAD(id string) []byte {
+	return []byte(id + "/checkpoint")
+}
+
+// seal returns a copy of t with its payload and checkpoint replaced by
+// ciphertext. The task ID is bound to the ciphertext, so payloads cannot
+// be swapped between tasks.
+func (e *EncryptedStorage) seal(t *task.Task) (*task.Task, error) {
+	plaintext, err := json.Marshal(t.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize payload: %w", err)
+	}
+	sealed, err := e.cipher.Seal(plaintext, []byte(t.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+
+	c := *t
+	c.Payload = nil
+	c.EncryptedPayload = sealed
+	if t.LastCheckpoint != nil && t.LastCheckpoint.Data != nil {
+		cp := *t.LastCheckpoint
+		if cp.EncryptedData, err = e.cipher.Seal(cp.Data, checkpointAAD(t.ID)); err != nil {
+			return nil, fmt.Errorf("failed to encrypt checkpoint: %w", err)
+		}
+		cp.Data = nil
+		c.LastCheckpoint = &cp
+	}
+	return &c, nil
+}
+
+// open decrypts t's payload and checkpoint in place
+func (e *EncryptedStorage) open(t *task.Task) error {
+	if t == nil {
+		return nil
+	}
+	// Either is left as it is if stored before encryption was turned on
+	if t.EncryptedPayload != "" {
+		plaintext, err := e.cipher.Open(t.EncryptedPayload, []byte(t.ID))
+		if err != nil {
+			return fmt.Errorf("task %s: %w", t.ID, err)
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			return fmt.Errorf("task %s: failed to parse payload: %w", t.ID, err)
+		}
+		t.Payload = payload
+		t.EncryptedPayload = ""
+	}
+	if t.LastCheckpoint != nil && t.LastCheckpoint.EncryptedData != "" {
+		data, err := e.cipher.Open(t.LastCheckpoint.EncryptedData, checkpointAAD(t.ID))
+		if err != nil {
+			return fmt.Errorf("task %s: checkpoint: %w", t.ID, err)
+		}
+		cp := *t.LastCheckpoint
+		cp.Data = data
+		cp.EncryptedData = ""
+		t.LastCheckpoint = &cp
+	}
+	return nil
+}
+
+// openAll decrypts every task in tasks, leaving out those whose payloads
+// do not decrypt, such as ones sealed with a retired key, as tasks that
+// cannot be loaded are left out of reads from storage, so one such task
+// does not fail every poll
+func (e *EncryptedStorage) openAll(tasks []*task.Task, err error) ([]*task.Task, error) {
+	if err != nil {
+		return nil, err
+	}
+	opened := tasks[:0]
+	for _, t := range tasks {
+		if err := e.open(t); err != nil {
+			e.logger.Warn("skipping task whose payload does not decrypt", zap.Error(err))
+			continue
+		}
+		opened = append(opened, t)
+	}
+	return opened, nil
+}
+
+// Unwrap returns the storage e encrypts payloads for
+func (e *EncryptedStorage) Unwrap() Storage {
+	return e.Storage
+}
+
+// encryptedUntracked seals the payloads of the untracked tasks pushed to
+// the queue it wraps and opens those popped
+type encryptedUntracked struct {
+	UntrackedQueue
+	e *EncryptedStorage
+}
+
+func (u *encryptedUntracked) PushUntracked(ctx context.Context, t *task.Task) error {
+	sealed, err := u.e.seal(t)
+	if err != nil {
+		return err
+	}
+	return u.UntrackedQueue.PushUntracked(ctx, sealed)
+}
+
+func (u *encryptedUntracked) PopUntracked(ctx context.Context, limit int) ([]*task.Task, error) {
+	return u.e.openAll(u.UntrackedQueue.PopUntracked(ctx, limit))
+}
+
+// encryptedShards opens the tasks read from the shards it wraps
+type encryptedShards struct {
+	ShardReader
+	e *EncryptedStorage
+}
+
+func (r *encryptedShards) GetTasksByShards(ctx context.Context, status task.Status, shards []int, limit int) ([]*task.Task, error) {
+	return r.e.openAll(r.ShardReader.GetTasksByShards(ctx, status, shards, limit))
+}
+
+func (e *EncryptedStorage) SaveTask(ctx context.Context, t *task.Task) error {
+	sealed, err := e.seal(t)
+	if err != nil {
+		return err
+	}
+	return e.Storage.SaveTask(ctx, sealed)
+}
+
+// UpdateTask re-encrypts the payload, so updates move tasks onto the
+// current primary key
+func (e *EncryptedStorage) UpdateTask(ctx context.Context, t *task.Task) error {
+	sealed, err := e.seal(t)
+	if err != nil {
+		return err
+	}
+	return e.Storage.UpdateTask(ctx, sealed)
+}
+
+func (e *EncryptedStorage) GetTask(ctx context.Context, id string) (*task.Task, error) {
+	t, err := e.Storage.GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.open(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (e *EncryptedStorage) GetTasks(ctx context.Context, ids []string) ([]*task.Task, error) {
+	return e.openAll(GetTasks(ctx, e.Storage, ids))
+}
+
+func (e *EncryptedStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	return e.openAll(e.Storage.GetTasksByStatus(ctx, status, limit))
+}
+
+func (e *EncryptedStorage) GetTasksByLabels(ctx context.Context, status task.Status, labels map[string]string, limit int) ([]*task.Task, error) {
+	return e.openAll(e.Storage.GetTasksByLabels(ctx, status, labels, limit))
+}
+
+func (e *EncryptedStorage) GetTasksByPriority(ctx context.Context, status task.Status, priority task.Priority, limit int) ([]*task.Task, error) {
+	return e.openAll(e.Storage.GetTasksByPriority(ctx, status, priority, limit))
+}
+
+func (e *EncryptedStorage) GetTasksPage(ctx context.Context, status task.Status, labels map[string]string, cursor string, limit int) ([]*task.Task, string, error) {
+	tasks, next, err := e.Storage.GetTasksPage(ctx, status, labels, cursor, limit)
+	tasks, err = e.openAll(tasks, err)
+	return tasks, next, err
+}
+
+func (e *EncryptedStorage) ScanTasks(ctx context.Context, filter TaskFilter, cursor string, limit int) ([]*task.Task, string, error) {
+	tasks, next, err := e.Storage.ScanTasks(ctx, filter, cursor, limit)
+	tasks, err = e.openAll(tasks, err)
+	return tasks, next, err
+}
+
+func (e *EncryptedStorage) GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	return e.openAll(e.Storage.GetDueTasks(ctx, now, limit))
+}
+
+func (e *EncryptedStorage) GetOverdueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	return e.openAll(e.Storage.GetOverdueTasks(ctx, now, limit))
+}
+
+func (e *EncryptedStorage) OldestTask(ctx context.Context, status task.Status) (*task.Task, error) {
+	t, err := e.Storage.OldestTask(ctx, status)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.open(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+)
+
+// apiVersion identifies the response contract a route group follows
+type apiVersion int
+
+const (
+	// apiV1 writes bare, per-handler response bodies
+	apiV1 apiVersion = 1
+	// apiV2 wraps every response in an Envelope
+	apiV2 apiVersion = 2
+)
+
+type versionKey struct{}
+
+// withVersion tags requests in a route group with the API version they target
+func withVersion(v apiVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), versionKey{}, v)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// versionFrom returns the API version of a request, defaulting to v1 for
+// unversioned routes such as /health
+func versionFrom(ctx context.Context) apiVersion {
+	if v, ok := ctx.Value(versionKey{}).(apiVersion); ok {
+		return v
+	}
+	return apiV1
+}
+
+// Envelope is the response body shape used by /api/v2 and later
+type Envelope struct {
+	Data       interface{}    `json:"data,omitempty"`
+	Error      *ErrorResponse `json:"error,omitempty"`
+	Pagination *Pagination    `json:"pagination,omitempty"`
+	RequestID  string         `json:"request_id,omitempty"`
+}
+
+// Pagination describes how to fetch the next page of a list response
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor turns the storage position of a page's last task into an
+// opaque pagination cursor
+func encodeCursor(position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("k:" + position))
+}
+
+// decodeCursor parses a cursor produced by encodeCursor back into the
+// storage position to resume after
+func decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "k:") || len(raw) == len("k:") {
+		return "", errs.Invalidf("invalid cursor")
+	}
+	return strings.TrimPrefix(string(raw), "k:"), nil
+}
@@ -0,0 +1,245 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code is a stable, machine-readable error identifier returned by the API
+type Code string
+
+const (
+	CodeTaskNotFound         Code = "task_not_found"
+	CodeTemplateNotFound     Code = "template_not_found"
+	CodeScheduleNotFound     Code = "schedule_not_found"
+	CodeTaskTemplateNotFound Code = "task_template_not_found"
+	CodeWorkflowNotFound     Code = "workflow_not_found"
+	CodeDefinitionNotFound   Code = "workflow_definition_not_found"
+	CodeLeaseNotFound        Code = "lease_not_found"
+	CodeInvalidTransition    Code = "invalid_transition"
+	CodeTaskExists           Code = "task_exists"
+	CodeTaskNotPending       Code = "task_not_pending"
+	CodeInvalidSignature     Code = "invalid_signature"
+	CodeStorageUnavailable   Code = "storage_unavailable"
+	CodeInvalidRequest       Code = "invalid_request"
+	CodeTaskRejected         Code = "task_rejected"
+	CodePayloadTooLarge      Code = "payload_too_large"
+	CodeRateLimited          Code = "rate_limited"
+	CodeTimeout              Code = "timeout"
+	CodeQueueStopped         Code = "queue_stopped"
+	CodeQueueFull            Code = "queue_full"
+	CodeInternal             Code = "internal_error"
+)
+
+// Error is a typed error carrying an error code and the HTTP status it maps to
+type Error struct {
+	Code    Code
+	Status  int
+	Message string
+
+	// Err is the underlying cause, if any
+	Err error
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any typed error with the same code, so errors built with
+// Invalidf still satisfy errors.Is(err, ErrInvalidRequest)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	// ErrTaskNotFound is returned when a task does not exist in storage
+	ErrTaskNotFound = &Error{
+		Code:    CodeTaskNotFound,
+		Status:  http.StatusNotFound,
+		Message: "task not found",
+	}
+
+	// ErrTemplateNotFound is returned when a template, or the requested
+	// version of it, does not exist
+	ErrTemplateNotFound = &Error{
+		Code:    CodeTemplateNotFound,
+		Status:  http.StatusNotFound,
+		Message: "template not found",
+	}
+
+	// ErrScheduleNotFound is returned when a recurring task schedule does
+	// not exist
+	ErrScheduleNotFound = &Error{
+		Code:    CodeScheduleNotFound,
+		Status:  http.StatusNotFound,
+		Message: "schedule not found",
+	}
+
+	// ErrTaskTemplateNotFound is returned when no task template has the
+	// requested name
+	ErrTaskTemplateNotFound = &Error{
+		Code:    CodeTaskTemplateNotFound,
+		Status:  http.StatusNotFound,
+		Message: "task template not found",
+	}
+
+	// ErrWorkflowNotFound is returned when a workflow does not exist
+	ErrWorkflowNotFound = &Error{
+		Code:    CodeWorkflowNotFound,
+		Status:  http.StatusNotFound,
+		Message: "workflow not found",
+	}
+
+	// ErrDefinitionNotFound is returned when no workflow definition has
+	// the requested name
+	ErrDefinitionNotFound = &Error{
+		Code:    CodeDefinitionNotFound,
+		Status:  http.StatusNotFound,
+		Message: "workflow definition not found",
+	}
+
+	// ErrLeaseNotFound is returned when a remote worker reports on a lease
+	// that has ended, such as because it expired or its task was cancelled
+	ErrLeaseNotFound = &Error{
+		Code:    CodeLeaseNotFound,
+		Status:  http.StatusNotFound,
+		Message: "lease not found",
+	}
+
+	// ErrInvalidTransition is returned when a task is moved to a status
+	// that is not reachable from its current one
+	ErrInvalidTransition = &Error{
+		Code:    CodeInvalidTransition,
+		Status:  http.StatusConflict,
+		Message: "invalid status transition",
+	}
+
+	// ErrTaskExists is returned when a task is submitted with the ID of
+	// one that already exists
+	ErrTaskExists = &Error{
+		Code:    CodeTaskExists,
+		Status:  http.StatusConflict,
+		Message: "task already exists",
+	}
+
+	// ErrInvalidSignature is returned when a request's signature is missing
+	// or does not verify
+	ErrInvalidSignature = &Error{
+		Code:    CodeInvalidSignature,
+		Status:  http.StatusUnauthorized,
+		Message: "invalid request signature",
+	}
+
+	// ErrStorageUnavailable is returned when the storage backend cannot be reached
+	ErrStorageUnavailable = &Error{
+		Code:    CodeStorageUnavailable,
+		Status:  http.StatusServiceUnavailable,
+		Message: "storage unavailable",
+	}
+
+	// ErrTaskNotPending is returned when an operation on waiting tasks is
+	// applied to a task that is no longer waiting
+	ErrTaskNotPending = &Error{
+		Code:    CodeTaskNotPending,
+		Status:  http.StatusConflict,
+		Message: "task is not pending",
+	}
+
+	// ErrInvalidRequest is returned when a request fails validation
+	ErrInvalidRequest = &Error{
+		Code:    CodeInvalidRequest,
+		Status:  http.StatusBadRequest,
+		Message: "invalid request",
+	}
+
+	// ErrPayloadTooLarge is returned when a request body exceeds the size limit
+	ErrPayloadTooLarge = &Error{
+		Code:    CodePayloadTooLarge,
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: "request body too large",
+	}
+
+	// ErrRateLimited is returned when a client exceeds its request rate
+	ErrRateLimited = &Error{
+		Code:    CodeRateLimited,
+		Status:  http.StatusTooManyRequests,
+		Message: "rate limit exceeded",
+	}
+
+	// ErrTimeout is returned when a request runs past its deadline
+	ErrTimeout = &Error{
+		Code:    CodeTimeout,
+		Status:  http.StatusGatewayTimeout,
+		Message: "request timed out",
+	}
+
+	// ErrQueueStopped is returned when a task is submitted to a queue that
+	// has stopped and cannot hand it to a worker
+	ErrQueueStopped = &Error{
+		Code:    CodeQueueStopped,
+		Status:  http.StatusServiceUnavailable,
+		Message: "queue stopped",
+	}
+
+	// ErrQueueFull is returned when a task is submitted to a queue that is
+	// at its depth limit
+	ErrQueueFull = &Error{
+		Code:    CodeQueueFull,
+		Status:  http.StatusServiceUnavailable,
+		Message: "queue full",
+	}
+
+	// ErrInternal is the fallback for errors outside the taxonomy
+	ErrInternal = &Error{
+		Code:    CodeInternal,
+		Status:  http.StatusInternalServerError,
+		Message: "internal error",
+	}
+)
+
+// From returns the typed error in err's chain, or ErrInternal if there is none.
+// A deadline anywhere in the chain wins over other codes, since a storage
+// call cut short by the request timeout is not an outage.
+func From(err error) *Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal
+}
+
+// Invalidf returns an invalid request error with a specific message
+func Invalidf(format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    CodeInvalidRequest,
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Rejected wraps err, a submit hook's reason for refusing a task, as a
+// task_rejected error. Typed errors are returned unchanged.
+func Rejected(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{
+		Code:    CodeTaskRejected,
+		Status:  http.StatusUnprocessableEntity,
+		Message: err.Error(),
+		Err:     err,
+	}
+}
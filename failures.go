@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// errNoHandler is wrapped by the error of a task whose type has no handler
+var errNoHandler = errors.New("no handler for task type")
+
+// categorize returns why an attempt failed with err
+func categorize(err error) task.ErrorCategory {
+	switch {
+	case errors.Is(err, errHandlerPanicked):
+		return task.CategoryPanic
+	case errors.Is(err, context.DeadlineExceeded):
+		return task.CategoryTimeout
+	case errors.Is(err, errNoHandler):
+		return task.CategoryNoHandler
+	case errors.Is(err, task.ErrInvalidPayload):
+		return task.CategoryInvalidPayload
+	}
+	return task.CategoryHandler
+}
+
+// recordFailure records the category of a failed attempt of t on the task,
+// where storage counts it by error signature, and in the metrics
+func (q *Queue) recordFailure(t *task.Task, err error) {
+	t.ErrorCategory = categorize(err)
+	metrics.TaskFailures.WithLabelValues(t.Type, string(t.ErrorCategory)).Inc()
+}
+
+// TopErrors returns the most frequent error signatures of failed attempts
+// over the last window, at most limit for each task type, most frequent
+// first
+func (q *Queue) TopErrors(ctx context.Context, window time.Duration, limit int) (map[string][]storage.ErrorCount, error) {
+	now := q.clock.Now()
+	counts, err := q.storage.GetErrorStats(ctx, now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+
+	byType := make(map[string][]storage.ErrorCount)
+	for _, c := range counts {
+		byType[c.Type] = append(byType[c.Type], c)
+	}
+	for taskType, list := range byType {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Signature < list[j].Signature
+		})
+		if limit > 0 && len(list) > limit {
+			list = list[:limit]
+		}
+		byType[taskType] = list
+	}
+	return byType, nil
+}
@@ -0,0 +1,81 @@
+package queue
+
+import "github.com/yourusername/distributed-task-queue/internal/task"
+
+// fairness interleaves the tasks the poller offers across their
+// task.FairnessKey, so one key with a large backlog cannot take every
+// prefetch slot while tasks of other keys wait behind it
+type fairness struct {
+	// window is the least number of pending tasks each poll reads; zero
+	// turns fairness off
+	window int
+	// turn rotates which key goes first, so the key at the head of storage
+	// does not lead every poll
+	turn int
+}
+
+// enabled reports whether polled tasks are interleaved
+func (f *fairness) enabled() bool {
+	return f.window > 0
+}
+
+// batch returns how many tasks a poll of batch tasks should read, enough
+// to see the other keys behind one key's backlog
+func (f *fairness) batch(batch int) int {
+	if f.window > batch {
+		return f.window
+	}
+	return batch
+}
+
+// order returns tasks, which storage lists in priority order, with the
+// tasks of each priority class taken round-robin across their keys.
+// Classes keep their order, as do the tasks of each key. Tasks without a
+// key share one.
+func (f *fairness) order(tasks []*task.Task) []*task.Task {
+	if !f.enabled() || len(tasks) < 2 {
+		return tasks
+	}
+	f.turn++
+
+	ordered := make([]*task.Task, 0, len(tasks))
+	for start := 0; start < len(tasks); {
+		class := tasks[start].Priority.Class()
+		end := start
+		for end < len(tasks) && tasks[end].Priority.Class() == class {
+			end++
+		}
+		ordered = append(ordered, f.interleave(tasks[start:end])...)
+		start = end
+	}
+	return ordered
+}
+
+// interleave takes one task of each key in turn, starting from the key
+// whose turn it is
+func (f *fairness) interleave(tasks []*task.Task) []*task.Task {
+	var keys []string
+	byKey := make(map[string][]*task.Task)
+	for _, t := range tasks {
+		if _, ok := byKey[t.FairnessKey]; !ok {
+			keys = append(keys, t.FairnessKey)
+		}
+		byKey[t.FairnessKey] = append(byKey[t.FairnessKey], t)
+	}
+	if len(keys) < 2 {
+		return tasks
+	}
+
+	first := f.turn % len(keys)
+	keys = append(keys[first:], keys[:first]...)
+	out := make([]*task.Task, 0, len(tasks))
+	for len(out) < len(tasks) {
+		for _, key := range keys {
+			if queued := byKey[key]; len(queued) > 0 {
+				out = append(out, queued[0])
+				byKey[key] = queued[1:]
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// HealthCheck reports whether a handler can run tasks right now, such as
+// whether it can reach its SMTP server
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecker is implemented by Handlers that check their own health.
+// Register adds the check for them.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// RegisterHealthCheck checks the health of taskType's handler every
+// HandlerHealthInterval once the queue starts. While the check fails this
+// process takes no tasks of the type, leaving them to healthy workers,
+// rather than failing them one by one.
+func (q *Queue) RegisterHealthCheck(taskType string, check HealthCheck) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.healthChecks[taskType] = check
+}
+
+// UnhealthyHandlers returns the error of each task type whose handler's
+// health check is failing
+func (q *Queue) UnhealthyHandlers() map[string]string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	unhealthy := make(map[string]string, len(q.unhealthy))
+	for taskType, msg := range q.unhealthy {
+		unhealthy[taskType] = msg
+	}
+	return unhealthy
+}
+
+// checkHandlers runs every health check once, recording which handlers
+// are unhealthy
+func (q *Queue) checkHandlers(ctx context.Context) {
+	q.mu.RLock()
+	types := make([]string, 0, len(q.healthChecks))
+	for taskType := range q.healthChecks {
+		types = append(types, taskType)
+	}
+	q.mu.RUnlock()
+	sort.Strings(types)
+
+	for _, taskType := range types {
+		q.mu.RLock()
+		check := q.healthChecks[taskType]
+		ok, tried := q.initialized[taskType]
+		q.mu.RUnlock()
+		if tried && !ok {
+			// Held back until Init succeeds anyway
+			continue
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, q.handlerHealthInterval)
+		err := check(checkCtx)
+		cancel()
+		q.setHandlerHealth(taskType, err)
+	}
+}
+
+// setHandlerHealth records the outcome of taskType's health check
+func (q *Queue) setHandlerHealth(taskType string, err error) {
+	q.mu.Lock()
+	_, wasUnhealthy := q.unhealthy[taskType]
+	if err != nil {
+		q.unhealthy[taskType] = err.Error()
+	} else {
+		delete(q.unhealthy, taskType)
+	}
+	q.mu.Unlock()
+
+	logger := q.logger.With(zap.String("type", taskType))
+	switch {
+	case err != nil && !wasUnhealthy:
+		metrics.HandlerHealthy.WithLabelValues(taskType).Set(0)
+		logger.Warn("handler unhealthy, pausing its tasks", zap.Error(err))
+	case err == nil && wasUnhealthy:
+		metrics.HandlerHealthy.WithLabelValues(taskType).Set(1)
+		logger.Info("handler healthy again, resuming its tasks")
+	case err == nil:
+		metrics.HandlerHealthy.WithLabelValues(taskType).Set(1)
+	}
+}
+
+// healthChecker runs the handlers' health checks every
+// handlerHealthInterval until the queue stops
+func (q *Queue) healthChecker(ctx context.Context) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.handlerHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.checkHandlers(ctx)
+		}
+	}
+}
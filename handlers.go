@@ -0,0 +1,128 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Handler is a task handler with setup and teardown, for handlers that
+// hold resources such as connection pools or loaded models. Init runs once
+// as the queue starts, before any task of the type; Close runs once the
+// queue has stopped, if Init succeeded.
+type Handler interface {
+	Handle(ctx context.Context, t *task.Task) error
+	Init(ctx context.Context) error
+	Close()
+}
+
+// Register registers h for taskType, like RegisterHandler, and runs its
+// Init as the queue starts, or straight away if the queue already has.
+// If h is also a HealthChecker, its check is registered too.
+//
+// Until Init succeeds, tasks of the type are held back like those of a
+// disabled type, and Init is tried again every DisabledTypeDelay.
+func (q *Queue) Register(taskType string, h Handler) {
+	q.RegisterHandler(taskType, h.Handle)
+	q.mu.Lock()
+	q.lifecycles[taskType] = h
+	q.mu.Unlock()
+	if checker, ok := h.(HealthChecker); ok {
+		q.RegisterHealthCheck(taskType, checker.HealthCheck)
+	}
+
+	q.workersMu.Lock()
+	ctx := q.workerCtx
+	q.workersMu.Unlock()
+	if ctx != nil {
+		q.initHandler(ctx, taskType, h)
+	}
+}
+
+// initHandlers runs the Init of every Handler registered, before the
+// workers start
+func (q *Queue) initHandlers(ctx context.Context) {
+	q.mu.RLock()
+	handlers := make(map[string]Handler, len(q.lifecycles))
+	for taskType, h := range q.lifecycles {
+		handlers[taskType] = h
+	}
+	q.mu.RUnlock()
+
+	for taskType, h := range handlers {
+		q.initHandler(ctx, taskType, h)
+	}
+}
+
+// initHandler runs h's Init, and keeps retrying it in the background
+// while it fails
+func (q *Queue) initHandler(ctx context.Context, taskType string, h Handler) {
+	logger := q.logger.With(zap.String("type", taskType))
+	err := h.Init(ctx)
+	q.setInitialized(taskType, err == nil)
+	if err == nil {
+		logger.Info("handler initialized")
+		return
+	}
+	logger.Error("handler failed to initialize, holding its tasks back", zap.Error(err))
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(q.disabledTypeDelay)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-q.stopChan:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := h.Init(ctx); err != nil {
+				logger.Warn("handler failed to initialize again", zap.Error(err))
+				continue
+			}
+			q.setInitialized(taskType, true)
+			logger.Info("handler initialized")
+			return
+		}
+	}()
+}
+
+// setInitialized records whether the Init of taskType's Handler succeeded
+func (q *Queue) setInitialized(taskType string, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.initialized[taskType] = ok
+}
+
+// handlerReady reports whether taskType's handler can take tasks: it is
+// not a Handler whose Init failed, nor failing its health check
+func (q *Queue) handlerReady(taskType string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if _, failing := q.unhealthy[taskType]; failing {
+		return false
+	}
+	ok, tried := q.initialized[taskType]
+	return ok || !tried
+}
+
+// closeHandlers runs the Close of every Handler whose Init succeeded
+func (q *Queue) closeHandlers() {
+	q.mu.RLock()
+	var closing []Handler
+	for taskType, h := range q.lifecycles {
+		if q.initialized[taskType] {
+			closing = append(closing, h)
+		}
+	}
+	q.mu.RUnlock()
+
+	for _, h := range closing {
+		h.Close()
+	}
+}
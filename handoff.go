@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// handoffNotifyTimeout bounds telling the other workers about tasks handed
+// back at shutdown
+const handoffNotifyTimeout = 5 * time.Second
+
+// Peers wakes the pollers of the other worker processes, so tasks one of
+// them hands back at shutdown are picked up straight away rather than at
+// the next poll. A *cluster.RedisPeers fits.
+type Peers interface {
+	// Notify tells the other workers tasks are waiting in storage
+	Notify(ctx context.Context) error
+	// Listen calls wake each time another worker notifies, until ctx is
+	// done
+	Listen(ctx context.Context, wake func())
+}
+
+// listenPeers tops up the prefetched tasks whenever another worker hands
+// tasks back, until the queue stops
+func (q *Queue) listenPeers(ctx context.Context) {
+	defer q.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	q.peers.Listen(ctx, q.refill)
+}
+
+// handoff gives back the prefetched tasks no worker has started, once the
+// queue is stopping: those on the channels and those dispatched to a
+// type's queue. They are still pending in storage, so they only need
+// forgetting here, except untracked tasks, which go back on their list;
+// the other workers are told to poll for them.
+func (q *Queue) handoff() {
+	if q.inProcess {
+		return
+	}
+
+	q.bufferedMu.Lock()
+	var handed int
+	var untracked []*task.Task
+	for _, class := range task.Classes {
+	drain:
+		for {
+			select {
+			case t := <-q.taskChannels[class]:
+				delete(q.buffered, t.ID)
+				if t.Untracked {
+					// The list held its only copy
+					untracked = append(untracked, t)
+				}
+				handed++
+			default:
+				break drain
+			}
+		}
+		q.observeChannel(class)
+	}
+	metrics.TasksPrefetched.Set(float64(len(q.buffered)))
+	q.bufferedMu.Unlock()
+	for _, t := range untracked {
+		q.pushBack(t)
+	}
+	for _, t := range q.exec.drain() {
+		q.handBack(t)
+		handed++
+	}
+
+	if handed == 0 {
+		return
+	}
+	metrics.TasksHandedOff.Add(float64(handed))
+	q.logger.Info("prefetched tasks handed back", zap.Int("tasks", handed))
+	if q.peers == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handoffNotifyTimeout)
+	defer cancel()
+	if err := q.peers.Notify(ctx); err != nil {
+		q.logger.Warn("failed to notify peers of handed back tasks", zap.Error(err))
+	}
+}
+
+// handBack gives back a task a dispatcher took but no worker started, as
+// handoff does
+func (q *Queue) handBack(t *task.Task) {
+	if t.Untracked {
+		q.pushBack(t)
+	}
+	q.release(t)
+}
@@ -0,0 +1,128 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// storageHealth pauses dispatch while storage is failing. After threshold
+// consecutive failures the poller backs off, doubling the wait between
+// attempts up to maxBackoff, and workers stop taking tasks. The first
+// success resumes both.
+type storageHealth struct {
+	logger     *zap.Logger
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	failures int
+	paused   bool
+	backoff  time.Duration
+	retryAt  time.Time
+	// resumed is closed when a pause ends
+	resumed chan struct{}
+}
+
+func newStorageHealth(logger *zap.Logger, threshold int, minBackoff, maxBackoff time.Duration) *storageHealth {
+	metrics.StorageAvailable.Set(1)
+	return &storageHealth{
+		logger:     logger,
+		threshold:  threshold,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// failure records a failed storage call at now
+func (h *storageHealth) failure(err error, now time.Time) {
+	if code := errs.From(err).Code; code != errs.CodeInternal && code != errs.CodeStorageUnavailable && code != errs.CodeTimeout {
+		// The backend answered; the request was just refused
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures++
+	switch {
+	case h.paused:
+		h.backoff *= 2
+		if h.backoff > h.maxBackoff {
+			h.backoff = h.maxBackoff
+		}
+	case h.failures >= h.threshold:
+		h.paused = true
+		h.backoff = h.minBackoff
+		h.resumed = make(chan struct{})
+		metrics.StorageAvailable.Set(0)
+		h.logger.Error("storage unavailable, pausing dispatch",
+			zap.Int("failures", h.failures),
+			zap.Error(err),
+		)
+	default:
+		return
+	}
+	h.retryAt = now.Add(h.backoff)
+}
+
+// success records a storage call that worked, ending any pause
+func (h *storageHealth) success() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures = 0
+	if !h.paused {
+		return
+	}
+	h.paused = false
+	close(h.resumed)
+	metrics.StorageAvailable.Set(1)
+	h.logger.Info("storage recovered, resuming dispatch")
+}
+
+// shouldPoll reports whether the poller may call storage at now: always
+// when healthy, and once per backoff while paused
+func (h *storageHealth) shouldPoll(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.paused || !now.Before(h.retryAt)
+}
+
+// isPaused reports whether dispatch is paused
+func (h *storageHealth) isPaused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paused
+}
+
+// wait blocks while dispatch is paused. It returns false if ctx or stop
+// ends first.
+func (h *storageHealth) wait(ctx context.Context, stop <-chan struct{}) bool {
+	h.mu.Lock()
+	paused, resumed := h.paused, h.resumed
+	h.mu.Unlock()
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// DispatchPaused reports whether workers have stopped taking tasks because
+// storage is unreachable
+func (q *Queue) DispatchPaused() bool {
+	return q.health.isPaused()
+}
@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// SubmitHook inspects, and may amend, a task before it is stored. Returning
+// an error refuses the task.
+type SubmitHook func(ctx context.Context, t *task.Task) error
+
+// OnSubmit adds hook to the chain every submission runs through, including
+// child tasks and tasks submitted over the API. Hooks run in the order they
+// were added, before the task is signed or stored; the first error stops
+// the chain and Submit returns it as task_rejected (422) unless it is
+// already a typed error.
+func (q *Queue) OnSubmit(hook SubmitHook) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.submitHooks = append(q.submitHooks, hook)
+}
+
+// runSubmitHooks runs the submit hooks over t
+func (q *Queue) runSubmitHooks(ctx context.Context, t *task.Task) error {
+	q.mu.RLock()
+	hooks := q.submitHooks
+	q.mu.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(ctx, t); err != nil {
+			q.logger.Info("task rejected by submit hook",
+				zap.String("id", t.ID),
+				zap.String("type", t.Type),
+				zap.Error(err),
+			)
+			return errs.Rejected(err)
+		}
+	}
+	return nil
+}
+
+// TaskCallback is told about a task that reached a point in its lifecycle.
+// It runs on the worker that processed the task, after the new state is
+// stored, and must not modify t.
+type TaskCallback func(ctx context.Context, t *task.Task)
+
+// lifecycle holds the registered lifecycle callbacks
+type lifecycle struct {
+	complete []TaskCallback
+	failure  []TaskCallback
+	retry    []TaskCallback
+	slow     []TaskCallback
+	finish   []TaskCallback
+}
+
+// OnComplete registers fn to run each time a task completes
+func (q *Queue) OnComplete(fn TaskCallback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lifecycle.complete = append(q.lifecycle.complete, fn)
+}
+
+// OnFailure registers fn to run each time a task fails for good, once its
+// retries are used up or when it cannot run at all. t.Error holds the
+// reason.
+func (q *Queue) OnFailure(fn TaskCallback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lifecycle.failure = append(q.lifecycle.failure, fn)
+}
+
+// OnRetry registers fn to run each time a failed attempt is scheduled to
+// be retried. t.LastError() returns the attempt's error and t.ScheduledAt
+// when the retry is due.
+func (q *Queue) OnRetry(fn TaskCallback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lifecycle.retry = append(q.lifecycle.retry, fn)
+}
+
+// OnFinish registers fn to run each time a task reaches a terminal status:
+// completed, failed, expired or cancelled. It runs after the status's own
+// callback, on whichever process finished the task.
+func (q *Queue) OnFinish(fn TaskCallback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lifecycle.finish = append(q.lifecycle.finish, fn)
+}
+
+// notify runs the callbacks chosen by pick over t. A panicking callback is
+// logged and does not stop the others.
+func (q *Queue) notify(ctx context.Context, pick func(lifecycle) []TaskCallback, t *task.Task, logger *zap.Logger) {
+	q.mu.RLock()
+	callbacks := pick(q.lifecycle)
+	q.mu.RUnlock()
+
+	for _, fn := range callbacks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("lifecycle callback panicked", zap.Any("panic", r), zap.Stack("stack"))
+				}
+			}()
+			fn(ctx, t)
+		}()
+	}
+}
+
+func onComplete(l lifecycle) []TaskCallback { return l.complete }
+func onFailure(l lifecycle) []TaskCallback  { return l.failure }
+func onRetry(l lifecycle) []TaskCallback    { return l.retry }
+func onFinish(l lifecycle) []TaskCallback   { return l.finish }
@@ -0,0 +1,259 @@
+// Package idempotency lets handlers perform a side effect, such as charging
+// a card or sending a message, once per key even though the queue delivers
+// tasks at least once. Once records the keys whose effects were performed,
+// so a retried or re-delivered task skips them.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNoStore is returned by Once outside a handler, where the queue
+	// has not set a store
+	ErrNoStore = errors.New("no idempotency store is configured")
+
+	// ErrInProgress is returned by Once while another attempt is
+	// performing the same key's effect. It is not permanent, so the task
+	// retries and, by then, finds the effect done or abandoned.
+	ErrInProgress = errors.New("side effect is already in progress")
+)
+
+const (
+	// DefaultLease is how long a claim on a key lasts when the handler's
+	// context has no deadline
+	DefaultLease = 10 * time.Minute
+	// DefaultRetention is how long performed keys are remembered
+	DefaultRetention = 7 * 24 * time.Hour
+
+	// leaseSlack outlives the handler's deadline, so a claim does not
+	// lapse while its effect is still being recorded
+	leaseSlack = time.Minute
+)
+
+// State is what a store knows of a key
+type State int
+
+const (
+	// StateClaimed means the caller now holds the key and should perform
+	// its effect
+	StateClaimed State = iota
+	// StateDone means the effect was already performed
+	StateDone
+	// StateRunning means another caller holds the key
+	StateRunning
+)
+
+// Store records the keys whose effects were performed
+type Store interface {
+	// Begin claims key for token until lease passes, unless it is done or
+	// claimed already
+	Begin(ctx context.Context, key, token string, lease time.Duration) (State, error)
+	// Finish records key as done
+	Finish(ctx context.Context, key string) error
+	// Abandon releases key, if token still holds it, so the effect can be
+	// tried again
+	Abandon(ctx context.Context, key, token string) error
+}
+
+type storeKey struct{}
+
+// WithStore returns a context carrying the store Once records keys in
+func WithStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, store)
+}
+
+// StoreFromContext returns the store set by the queue, if any
+func StoreFromContext(ctx context.Context) (Store, bool) {
+	store, ok := ctx.Value(storeKey{}).(Store)
+	return store, ok
+}
+
+// Once runs fn unless the effect named key was already performed. Keys are
+// shared across tasks, so include what makes the effect unique, such as
+// "charge:" plus an order ID, or the task ID for effects of one task.
+//
+// When fn fails, key is released and the error returned, so a retry tries
+// again. An attempt that dies while fn runs holds key until its lease,
+// the handler's deadline plus a minute, passes; attempts meanwhile get
+// ErrInProgress.
+func Once(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	store, ok := StoreFromContext(ctx)
+	if !ok {
+		return ErrNoStore
+	}
+	if key == "" {
+		return errors.New("idempotency key must not be empty")
+	}
+	logger := task.LoggerFromContext(ctx).With(zap.String("idempotency_key", key))
+
+	lease := DefaultLease
+	if deadline, ok := ctx.Deadline(); ok {
+		lease = time.Until(deadline) + leaseSlack
+	}
+	token := uuid.NewString()
+	state, err := store.Begin(ctx, key, token, lease)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case StateDone:
+		logger.Info("side effect already performed, skipping")
+		return nil
+	case StateRunning:
+		return fmt.Errorf("%w: %s", ErrInProgress, key)
+	}
+
+	if err := fn(ctx); err != nil {
+		// Release the key even when fn failed because ctx ended
+		if abandonErr := store.Abandon(context.WithoutCancel(ctx), key, token); abandonErr != nil {
+			logger.Warn("failed to release idempotency key", zap.Error(abandonErr))
+		}
+		return err
+	}
+	if err := store.Finish(context.WithoutCancel(ctx), key); err != nil {
+		// The effect happened; failing the task now would only repeat it
+		// once the lease passes
+		logger.Error("side effect performed but not recorded", zap.Error(err))
+	}
+	return nil
+}
+
+// RedisStore keeps keys in Redis, as idempotency:<key>
+type RedisStore struct {
+	client *redis.Client
+	// Retention is how long performed keys are remembered, defaults to
+	// DefaultRetention
+	Retention time.Duration
+}
+
+// NewRedisStore creates a store on client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, Retention: DefaultRetention}
+}
+
+// redisKey returns the Redis key recording key
+func redisKey(key string) string {
+	return "idempotency:" + key
+}
+
+// doneValue marks a performed key; claimed keys hold their token
+const doneValue = "done"
+
+// beginScript claims a key nobody holds, reporting what holds it otherwise
+var beginScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if not v then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 0
+end
+if v == "done" then
+	return 1
+end
+return 2`)
+
+// abandonScript deletes a key only while token still holds it
+var abandonScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (r *RedisStore) Begin(ctx context.Context, key, token string, lease time.Duration) (State, error) {
+	n, err := beginScript.Run(ctx, r.client, []string{redisKey(key)}, token, lease.Milliseconds()).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	return State(n), nil
+}
+
+func (r *RedisStore) Finish(ctx context.Context, key string) error {
+	if err := r.client.Set(ctx, redisKey(key), doneValue, r.Retention).Err(); err != nil {
+		return fmt.Errorf("failed to record idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) Abandon(ctx context.Context, key, token string) error {
+	if err := abandonScript.Run(ctx, r.client, []string{redisKey(key)}, token).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// MemoryStore keeps keys in memory, for tests and single-process
+// deployments
+type MemoryStore struct {
+	// Retention is how long performed keys are remembered, defaults to
+	// DefaultRetention
+	Retention time.Duration
+
+	mu   sync.Mutex
+	keys map[string]memoryEntry
+}
+
+// maxMemoryKeys is how many keys a MemoryStore holds before it drops the
+// expired ones
+const maxMemoryKeys = 10000
+
+type memoryEntry struct {
+	token   string
+	done    bool
+	expires time.Time
+}
+
+// NewMemoryStore creates an in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{Retention: DefaultRetention, keys: make(map[string]memoryEntry)}
+}
+
+func (m *MemoryStore) Begin(ctx context.Context, key, token string, lease time.Duration) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if e, ok := m.keys[key]; ok && now.Before(e.expires) {
+		if e.done {
+			return StateDone, nil
+		}
+		return StateRunning, nil
+	}
+	if len(m.keys) >= maxMemoryKeys {
+		m.sweep(now)
+	}
+	m.keys[key] = memoryEntry{token: token, expires: now.Add(lease)}
+	return StateClaimed, nil
+}
+
+// sweep drops expired keys
+func (m *MemoryStore) sweep(now time.Time) {
+	for key, e := range m.keys {
+		if !now.Before(e.expires) {
+			delete(m.keys, key)
+		}
+	}
+}
+
+func (m *MemoryStore) Finish(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key] = memoryEntry{done: true, expires: time.Now().Add(m.Retention)}
+	return nil
+}
+
+func (m *MemoryStore) Abandon(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.keys[key]; ok && !e.done && e.token == token {
+		delete(m.keys, key)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnce(t *testing.T) {
+	ctx := WithStore(context.Background(), NewMemoryStore())
+	performed := 0
+	charge := func(ctx context.Context) error {
+		performed++
+		return nil
+	}
+
+	require.NoError(t, Once(ctx, "charge:order-1", charge))
+	// A re-delivered task skips the effect
+	require.NoError(t, Once(ctx, "charge:order-1", charge))
+	assert.Equal(t, 1, performed)
+
+	require.NoError(t, Once(ctx, "charge:order-2", charge))
+	assert.Equal(t, 2, performed)
+}
+
+func TestOnce_Failure(t *testing.T) {
+	ctx := WithStore(context.Background(), NewMemoryStore())
+	attempts := 0
+	flaky := func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("card network unavailable")
+		}
+		return nil
+	}
+
+	// A failed effect is released, so the retry performs it
+	require.Error(t, Once(ctx, "charge:order-1", flaky))
+	require.NoError(t, Once(ctx, "charge:order-1", flaky))
+	assert.Equal(t, 2, attempts)
+}
+
+func TestOnce_InProgress(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := WithStore(context.Background(), store)
+
+	// An attempt that died mid-effect holds the key until its lease passes
+	state, err := store.Begin(ctx, "charge:order-1", "dead-worker", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, StateClaimed, state)
+
+	ran := false
+	err = Once(ctx, "charge:order-1", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrInProgress)
+	assert.False(t, ran)
+
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, Once(ctx, "charge:order-1", func(ctx context.Context) error {
+		ran = true
+		return nil
+	}))
+	assert.True(t, ran)
+}
+
+func TestOnce_NoStore(t *testing.T) {
+	err := Once(context.Background(), "charge:order-1", func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrNoStore)
+}
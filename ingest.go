@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxIngestLine bounds one task's JSON, well over the API's payload limit
+const maxIngestLine = 4 << 20
+
+// ingestProgressEvery is how often ingest reports its progress
+const ingestProgressEvery = 2 * time.Second
+
+// ingestAttempts is how many times a batch is sent while the server is
+// rate limiting or full
+const ingestAttempts = 6
+
+// ingest submits tasks read as JSON lines, from a file or a SQL query, in
+// batches at a steady rate, for moving a backlog over from another queue
+func (c *client) ingest(args []string) error {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	file := fs.String("file", "", "JSON lines file of tasks to submit, or - for stdin")
+	query := fs.String("sql", "", "PostgreSQL query whose rows are the tasks to submit, run with psql")
+	dsn := fs.String("dsn", os.Getenv("DATABASE_URL"), "PostgreSQL connection string for -sql")
+	rate := fs.Float64("rate", 100, "tasks submitted per second, 0 for no limit")
+	batch := fs.Int("batch", 100, "tasks per request, at most 500")
+	skip := fs.Int("skip", 0, "tasks to skip from the start, to resume an ingest")
+	fs.Parse(args)
+
+	if (*file == "") == (*query == "") {
+		return errors.New("ingest needs one of -file or -sql")
+	}
+	if *batch < 1 || *batch > 500 {
+		return fmt.Errorf("batch must be between 1 and 500, got %d", *batch)
+	}
+	if *rate < 0 {
+		return fmt.Errorf("rate must not be negative, got %g", *rate)
+	}
+
+	var r io.Reader
+	switch {
+	case *file == "-":
+		r = os.Stdin
+	case *file != "":
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	default:
+		rows, wait, err := queryRows(*dsn, *query)
+		if err != nil {
+			return err
+		}
+		defer wait()
+		r = rows
+	}
+
+	in := &ingester{client: c, rate: *rate, batch: *batch, start: time.Now(), line: *skip}
+	in.lastReport = in.start
+	err := in.run(r, *skip)
+	in.report(true)
+	if err != nil {
+		return fmt.Errorf("%w; resume with -skip %d", err, in.line)
+	}
+	return nil
+}
+
+// queryRows runs query through psql, returning each row as a JSON object
+// on its own line. Columns are named after submission fields, such as
+// type, payload, priority and id.
+func queryRows(dsn, query string) (io.Reader, func(), error) {
+	if dsn == "" {
+		return nil, nil, errors.New("-sql needs -dsn or DATABASE_URL")
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	cmd := exec.Command("psql", dsn, "-X", "-q", "-A", "-t", "-v", "ON_ERROR_STOP=1",
+		"-c", "SELECT row_to_json(q) FROM ("+query+") q")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to run psql: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to run psql: %w", err)
+	}
+	return out, func() { cmd.Wait() }, nil
+}
+
+// ingester submits tasks in batches, pacing them to rate per second
+type ingester struct {
+	client *client
+	rate   float64
+	batch  int
+	start  time.Time
+
+	// line is the last line handled: submitted, found to exist already,
+	// or blank
+	line       int
+	submitted  int
+	existing   int
+	lastReport time.Time
+}
+
+// pendingTask is a task read but not yet submitted
+type pendingTask struct {
+	line int
+	json json.RawMessage
+}
+
+// run reads tasks from r, skipping the first skip lines, and submits the
+// rest
+func (in *ingester) run(r io.Reader, skip int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxIngestLine)
+
+	var pending []pendingTask
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line <= skip {
+			continue
+		}
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			if len(pending) == 0 {
+				in.line = line
+			}
+			continue
+		}
+		if !json.Valid(text) {
+			if err := in.flush(pending); err != nil {
+				return err
+			}
+			return fmt.Errorf("line %d is not valid JSON", line)
+		}
+		pending = append(pending, pendingTask{line: line, json: append(json.RawMessage(nil), text...)})
+		if len(pending) == in.batch {
+			if err := in.flush(pending); err != nil {
+				return err
+			}
+			pending = pending[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if flushErr := in.flush(pending); flushErr != nil {
+			return flushErr
+		}
+		return fmt.Errorf("failed to read tasks after line %d: %w", line, err)
+	}
+	if err := in.flush(pending); err != nil {
+		return err
+	}
+	in.line = line
+	return nil
+}
+
+// flush submits tasks as one batch. If some already exist, such as from an
+// interrupted run, it submits them one by one instead, skipping those.
+func (in *ingester) flush(tasks []pendingTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	in.pace(len(tasks))
+
+	batch := make([]json.RawMessage, len(tasks))
+	for i, t := range tasks {
+		batch[i] = t.json
+	}
+	err := in.send(batch)
+	var apiErr *ingestError
+	switch {
+	case err == nil:
+		in.submitted += len(tasks)
+		in.line = tasks[len(tasks)-1].line
+	case errors.As(err, &apiErr) && apiErr.Code == "task_exists":
+		for _, t := range tasks {
+			err := in.send([]json.RawMessage{t.json})
+			switch {
+			case err == nil:
+				in.submitted++
+			case errors.As(err, &apiErr) && apiErr.Code == "task_exists":
+				in.existing++
+			default:
+				return fmt.Errorf("line %d: %w", t.line, err)
+			}
+			in.line = t.line
+		}
+	default:
+		return fmt.Errorf("lines %d-%d: %w", tasks[0].line, tasks[len(tasks)-1].line, err)
+	}
+	in.report(false)
+	return nil
+}
+
+// send submits a batch, waiting and trying again while the server is
+// rate limiting or shedding load
+func (in *ingester) send(batch []json.RawMessage) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := in.client.submitBatch(batch)
+		var apiErr *ingestError
+		if !errors.As(err, &apiErr) || (apiErr.Code != "rate_limited" && apiErr.Code != "queue_full") || attempt == ingestAttempts {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "ingesting: %s, waiting %s\n", apiErr.Code, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// pace waits until n more tasks fit the rate since the start
+func (in *ingester) pace(n int) {
+	if in.rate == 0 {
+		return
+	}
+	due := in.start.Add(time.Duration(float64(in.submitted+in.existing+n) / in.rate * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// report prints progress every ingestProgressEvery, or now if final
+func (in *ingester) report(final bool) {
+	now := time.Now()
+	if !final && now.Sub(in.lastReport) < ingestProgressEvery {
+		return
+	}
+	in.lastReport = now
+	elapsed := now.Sub(in.start)
+	perSecond := 0.0
+	if elapsed > 0 {
+		perSecond = float64(in.submitted+in.existing) / elapsed.Seconds()
+	}
+	verb := "ingesting:"
+	if final {
+		verb = "ingested"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d tasks submitted, %d already existed, %.0f/s over %s\n",
+		verb, in.submitted, in.existing, perSecond, elapsed.Round(time.Second))
+}
+
+// ingestError is an error response to an ingest request
+type ingestError struct {
+	Status  string
+	Message string `json:"error"`
+	Code    string `json:"code"`
+}
+
+func (e *ingestError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %s", e.Status)
+	}
+	return fmt.Sprintf("server returned %s: %s (%s)", e.Status, e.Message, e.Code)
+}
+
+// submitBatch sends tasks to POST /tasks/batch
+func (c *client) submitBatch(tasks []json.RawMessage) error {
+	body, err := json.Marshal(map[string][]json.RawMessage{"tasks": tasks})
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	resp, err := c.http.Post(c.base+"/tasks/batch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to submit batch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+
+	apiErr := &ingestError{Status: resp.Status}
+	json.NewDecoder(resp.Body).Decode(apiErr)
+	return apiErr
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/api"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// setupIngest returns a client of an API server over an in-memory queue
+func setupIngest(t *testing.T) (*client, *queue.Queue) {
+	logger, _ := zap.NewDevelopment()
+	q := queue.NewQueue(queue.Config{
+		Storage: storage.NewMemoryStorage(),
+		Logger:  logger,
+	})
+	ts := httptest.NewServer(api.NewServer(api.Config{Queue: q, Logger: logger}))
+	t.Cleanup(ts.Close)
+	return &client{base: ts.URL + "/api/v1", http: ts.Client()}, q
+}
+
+// writeLines writes lines to a file in a temporary directory
+func writeLines(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "tasks.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+	return path
+}
+
+func taskLine(id, taskType string) string {
+	return fmt.Sprintf(`{"id":%q,"type":%q,"payload":{"n":1}}`, id, taskType)
+}
+
+func TestIngest_File(t *testing.T) {
+	c, q := setupIngest(t)
+	ctx := context.Background()
+
+	var ids []string
+	var lines []string
+	for i := 0; i < 5; i++ {
+		id := uuid.New().String()
+		ids = append(ids, id)
+		lines = append(lines, taskLine(id, "import_row"))
+		if i == 2 {
+			lines = append(lines, "")
+		}
+	}
+
+	require.NoError(t, c.ingest([]string{"-file", writeLines(t, lines...), "-rate", "0", "-batch", "2"}))
+
+	for _, id := range ids {
+		tk, err := q.GetTask(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "import_row", tk.Type)
+		assert.Equal(t, task.StatusPending, tk.Status)
+	}
+	pending, _, err := q.ListTasks(ctx, task.StatusPending, nil, "", 100)
+	require.NoError(t, err)
+	assert.Len(t, pending, 5)
+}
+
+func TestIngest_SkipsExistingTasks(t *testing.T) {
+	c, q := setupIngest(t)
+	ctx := context.Background()
+
+	var ids []string
+	var lines []string
+	for i := 0; i < 4; i++ {
+		id := uuid.New().String()
+		ids = append(ids, id)
+		lines = append(lines, taskLine(id, "import_row"))
+	}
+	path := writeLines(t, lines...)
+
+	// A run interrupted after the first two tasks is started again
+	require.NoError(t, c.ingest([]string{"-file", writeLines(t, lines[:2]...), "-rate", "0"}))
+	require.NoError(t, c.ingest([]string{"-file", path, "-rate", "0", "-batch", "4"}))
+
+	pending, _, err := q.ListTasks(ctx, task.StatusPending, nil, "", 100)
+	require.NoError(t, err)
+	assert.Len(t, pending, 4)
+	for _, id := range ids {
+		_, err := q.GetTask(ctx, id)
+		assert.NoError(t, err, id)
+	}
+}
+
+func TestIngest_StopsAtInvalidLine(t *testing.T) {
+	c, q := setupIngest(t)
+	ctx := context.Background()
+
+	first, last := uuid.New().String(), uuid.New().String()
+	path := writeLines(t, taskLine(first, "import_row"), `{"type":`, taskLine(last, "import_row"))
+
+	err := c.ingest([]string{"-file", path, "-rate", "0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2 is not valid JSON")
+	assert.Contains(t, err.Error(), "resume with -skip 1")
+
+	// The task before the bad line went in; the one after waits for the
+	// resumed run
+	_, err = q.GetTask(ctx, first)
+	assert.NoError(t, err)
+	_, err = q.GetTask(ctx, last)
+	assert.Error(t, err)
+
+	fixed := writeLines(t, taskLine(first, "import_row"), taskLine(uuid.New().String(), "import_row"), taskLine(last, "import_row"))
+	require.NoError(t, c.ingest([]string{"-file", fixed, "-rate", "0", "-skip", "2"}))
+	_, err = q.GetTask(ctx, last)
+	assert.NoError(t, err)
+	pending, _, err := q.ListTasks(ctx, task.StatusPending, nil, "", 100)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// PriorityInheritance decides how a child task's priority relates to its
+// parent's. Retries are the same task, so they always keep their priority.
+type PriorityInheritance int
+
+const (
+	// InheritAtLeastParent raises children to their parent's priority but
+	// keeps a higher priority set on the child. This is the default, so
+	// urgent work stays urgent through everything it spawns.
+	InheritAtLeastParent PriorityInheritance = iota
+	// InheritExact gives children exactly their parent's priority
+	InheritExact
+	// InheritNone keeps the priority set on the child
+	InheritNone
+)
+
+// submitOptions holds per-submission settings
+type submitOptions struct {
+	priority *task.Priority
+	plan     *SubmitPlan
+}
+
+// newSubmitOptions applies opts
+func newSubmitOptions(opts []SubmitOption) submitOptions {
+	if len(opts) == 0 {
+		// Most submissions have none; o below escapes to the heap
+		return submitOptions{}
+	}
+	var o submitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SubmitOption customizes a single submission
+type SubmitOption func(*submitOptions)
+
+// WithPriorityOverride sets the child's priority exactly, bypassing the
+// queue's inheritance rule
+func WithPriorityOverride(p task.Priority) SubmitOption {
+	return func(o *submitOptions) {
+		o.priority = &p
+	}
+}
+
+// SubmitChild submits a task spawned while processing parent. The child is
+// linked to the parent, shares its correlation ID and source and, unless
+// it has its own, fairness key, inherits any labels it does not set itself, and gets
+// a priority from the inheritance rule.
+func (q *Queue) SubmitChild(ctx context.Context, parent, child *task.Task, opts ...SubmitOption) error {
+	o := newSubmitOptions(opts)
+
+	child.ParentID = parent.ID
+	if child.CorrelationID == "" {
+		child.CorrelationID = parent.CorrelationID
+	}
+	if child.Source == "" {
+		child.Source = parent.Source
+	}
+	if child.FairnessKey == "" {
+		child.FairnessKey = parent.FairnessKey
+	}
+	for k, v := range parent.Labels {
+		if _, ok := child.Labels[k]; ok {
+			continue
+		}
+		if child.Labels == nil {
+			child.Labels = make(map[string]string, len(parent.Labels))
+		}
+		child.Labels[k] = v
+	}
+	child.Priority = q.childPriority(parent.Priority, child.Priority, o)
+
+	return q.Submit(ctx, child, opts...)
+}
+
+// childPriority applies the inheritance rule to a child's requested priority
+func (q *Queue) childPriority(parent, requested task.Priority, o submitOptions) task.Priority {
+	if o.priority != nil {
+		return *o.priority
+	}
+	switch q.inheritance {
+	case InheritExact:
+		return parent
+	case InheritNone:
+		return requested
+	default:
+		if parent > requested {
+			return parent
+		}
+		return requested
+	}
+}
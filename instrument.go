@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// channelGauges holds the channel_occupancy series of each priority class
+var channelGauges = func() map[task.Priority]prometheus.Gauge {
+	gauges := make(map[task.Priority]prometheus.Gauge, len(classNames))
+	for class, name := range classNames {
+		gauges[class] = metrics.ChannelOccupancy.WithLabelValues(name)
+	}
+	return gauges
+}()
+
+// observeChannel records how many tasks wait on the channel of class
+func (q *Queue) observeChannel(class task.Priority) {
+	channelGauges[class].Set(float64(len(q.taskChannels[class])))
+}
+
+// timedStorage records how long the storage operations the queue runs
+// take, so slow storage can be told apart from slow polling or handlers.
+// Operations it does not time go straight to the storage it wraps.
+type timedStorage struct {
+	storage.Storage
+	save, update, get, getMany, delete, poll, due, overdue prometheus.Observer
+}
+
+func newTimedStorage(s storage.Storage) *timedStorage {
+	op := metrics.StorageLatency.WithLabelValues
+	return &timedStorage{
+		Storage: s,
+		save:    op("save"),
+		update:  op("update"),
+		get:     op("get"),
+		getMany: op("get_many"),
+		delete:  op("delete"),
+		poll:    op("poll"),
+		due:     op("due"),
+		overdue: op("overdue"),
+	}
+}
+
+// since observes the time since start on o
+func since(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+func (s *timedStorage) SaveTask(ctx context.Context, t *task.Task) error {
+	defer since(s.save, time.Now())
+	return s.Storage.SaveTask(ctx, t)
+}
+
+func (s *timedStorage) UpdateTask(ctx context.Context, t *task.Task) error {
+	defer since(s.update, time.Now())
+	return s.Storage.UpdateTask(ctx, t)
+}
+
+func (s *timedStorage) GetTask(ctx context.Context, id string) (*task.Task, error) {
+	defer since(s.get, time.Now())
+	return s.Storage.GetTask(ctx, id)
+}
+
+// GetTasks reads ids in one call if the wrapped storage can, as
+// storage.GetTasks would from it
+func (s *timedStorage) GetTasks(ctx context.Context, ids []string) ([]*task.Task, error) {
+	defer since(s.getMany, time.Now())
+	return storage.GetTasks(ctx, s.Storage, ids)
+}
+
+func (s *timedStorage) DeleteTask(ctx context.Context, id string) error {
+	defer since(s.delete, time.Now())
+	return s.Storage.DeleteTask(ctx, id)
+}
+
+func (s *timedStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	defer since(s.poll, time.Now())
+	return s.Storage.GetTasksByStatus(ctx, status, limit)
+}
+
+func (s *timedStorage) GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	defer since(s.due, time.Now())
+	return s.Storage.GetDueTasks(ctx, now, limit)
+}
+
+func (s *timedStorage) GetOverdueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	defer since(s.overdue, time.Now())
+	return s.Storage.GetOverdueTasks(ctx, now, limit)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"go.uber.org/zap"
+)
+
+// handleLease leases a task of the types a remote worker runs, waiting up
+// to the request's wait for one. It responds 204 if none came.
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req LeaseRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	wait, err := req.Validate()
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	lease, err := s.queue.Lease(r.Context(), req.WorkerID, req.Types, wait)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if lease == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, lease)
+}
+
+// handleLeaseProgress records a remote worker's progress and extends its
+// lease
+func (s *Server) handleLeaseProgress(w http.ResponseWriter, r *http.Request) {
+	var p queue.Progress
+	if err := decodeJSON(r, &p); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	lease, err := s.queue.ReportProgress(chi.URLParam(r, "id"), p)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, lease)
+}
+
+// handleAckLease completes the task of a lease
+func (s *Server) handleAckLease(w http.ResponseWriter, r *http.Request) {
+	var req AckRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+	}
+	id := chi.URLParam(r, "id")
+	if err := s.queue.Ack(id, req.Result); err != nil {
+		s.logger.Warn("failed to ack lease", zap.String("lease_id", id), zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleNackLease fails the attempt of a lease, which retries or fails its
+// task
+func (s *Server) handleNackLease(w http.ResponseWriter, r *http.Request) {
+	var req NackRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	nack, err := req.Nack()
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := s.queue.Nack(id, nack); err != nil {
+		s.logger.Warn("failed to nack lease", zap.String("lease_id", id), zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
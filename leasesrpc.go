@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/workerpb"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// workerDisconnected fails the attempts of leases still held by a remote
+// worker whose stream ended
+const workerDisconnected = "remote worker disconnected"
+
+// WorkerService serves the gRPC worker protocol in worker.proto. Remote
+// workers lease tasks, report progress and ack or nack them over one
+// stream, as they do through the lease endpoints.
+type WorkerService struct {
+	workerpb.UnimplementedWorkerServer
+	queue  *queue.Queue
+	logger *zap.Logger
+}
+
+// NewWorkerService creates the worker protocol service for the remote
+// types of q
+func NewWorkerService(q *queue.Queue, logger *zap.Logger) *WorkerService {
+	return &WorkerService{queue: q, logger: logger}
+}
+
+// Work runs one remote worker's session until it closes the stream
+func (s *WorkerService) Work(stream workerpb.Worker_WorkServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	w := &workSession{
+		service: s,
+		stream:  stream,
+		ctx:     ctx,
+		leases:  make(map[string]bool),
+	}
+	defer w.close(cancel)
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.handle(msg)
+	}
+}
+
+// workSession is one remote worker's stream
+type workSession struct {
+	service *WorkerService
+	stream  workerpb.Worker_WorkServer
+	// ctx ends the session's lease requests once the stream ends
+	ctx context.Context
+	// pending counts the lease requests still waiting for a task
+	pending sync.WaitGroup
+
+	// sendMu serialises sends, which the stream does not allow at once
+	sendMu sync.Mutex
+
+	// leases are the IDs of the leases the worker holds
+	mu     sync.Mutex
+	leases map[string]bool
+}
+
+// handle answers one message from the worker
+func (w *workSession) handle(msg *workerpb.WorkerMessage) {
+	q := w.service.queue
+	switch m := msg.Message.(type) {
+	case *workerpb.WorkerMessage_Lease:
+		req := LeaseRequest{WorkerID: m.Lease.WorkerId, Types: m.Lease.Types}
+		if _, err := req.Validate(); err != nil {
+			w.sendErr("", err)
+			return
+		}
+		w.pending.Add(1)
+		go w.lease(req)
+
+	case *workerpb.WorkerMessage_Progress:
+		id := m.Progress.LeaseId
+		lease, err := q.ReportProgress(id, queue.Progress{Percent: m.Progress.Percent, Message: m.Progress.Message})
+		if err != nil {
+			w.sendErr(id, err)
+			return
+		}
+		w.send(&workerpb.BrokerMessage{Message: &workerpb.BrokerMessage_Extended{Extended: &workerpb.Lease{
+			LeaseId:   lease.ID,
+			ExpiresAt: timestamppb.New(lease.Expires),
+		}}})
+
+	case *workerpb.WorkerMessage_Ack:
+		id := m.Ack.LeaseId
+		var result map[string]interface{}
+		if m.Ack.Result != nil {
+			result = m.Ack.Result.AsMap()
+		}
+		w.ended(id, q.Ack(id, result))
+
+	case *workerpb.WorkerMessage_Nack:
+		id := m.Nack.LeaseId
+		nack := queue.Nack{Error: m.Nack.Error, Permanent: m.Nack.Permanent}
+		if m.Nack.RetryAfter != nil {
+			nack.RetryAfter = m.Nack.RetryAfter.AsDuration()
+			if nack.RetryAfter < 0 {
+				w.sendErr(id, errs.Invalidf("invalid retry_after %s", nack.RetryAfter))
+				return
+			}
+		}
+		w.ended(id, q.Nack(id, nack))
+
+	default:
+		w.sendErr("", errs.Invalidf("message must be one of lease, progress, ack or nack"))
+	}
+}
+
+// lease waits for a task for req and sends it to the worker
+func (w *workSession) lease(req LeaseRequest) {
+	defer w.pending.Done()
+	q := w.service.queue
+	for {
+		lease, err := q.Lease(w.ctx, req.WorkerID, req.Types, maxLongPollWait)
+		if err != nil {
+			// The stream ended
+			return
+		}
+		if lease == nil {
+			select {
+			case <-q.Stopping():
+				return
+			default:
+				continue
+			}
+		}
+
+		msg, err := leasedMessage(lease)
+		if err != nil {
+			// No remote worker could run it
+			w.service.logger.Error("failed to send leased task", zap.String("id", lease.Task.ID), zap.Error(err))
+			q.Nack(lease.ID, queue.Nack{Error: err.Error(), Permanent: true})
+			return
+		}
+
+		// Held before it is sent, so it is nacked if the worker is gone
+		w.mu.Lock()
+		w.leases[lease.ID] = true
+		w.mu.Unlock()
+		w.send(msg)
+		return
+	}
+}
+
+// ended answers an ack or nack of lease id, which failed with err if not
+// nil, and forgets the lease unless the worker may try again
+func (w *workSession) ended(id string, err error) {
+	if err == nil || errors.Is(err, errs.ErrLeaseNotFound) {
+		w.mu.Lock()
+		delete(w.leases, id)
+		w.mu.Unlock()
+	}
+	if err != nil {
+		w.service.logger.Warn("failed to end lease", zap.String("lease_id", id), zap.Error(err))
+		w.sendErr(id, err)
+		return
+	}
+	w.send(&workerpb.BrokerMessage{Message: &workerpb.BrokerMessage_Ended{Ended: &workerpb.LeaseEnded{LeaseId: id}}})
+}
+
+// send sends msg to the worker. A failed send ends the stream, so the
+// error is left to Recv.
+func (w *workSession) send(msg *workerpb.BrokerMessage) {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	w.stream.Send(msg)
+}
+
+// sendErr reports err to the worker with its error code
+func (w *workSession) sendErr(id string, err error) {
+	e := errs.From(err)
+	w.send(&workerpb.BrokerMessage{Message: &workerpb.BrokerMessage_Error{Error: &workerpb.Error{
+		LeaseId: id,
+		Code:    string(e.Code),
+		Message: e.Message,
+	}}})
+}
+
+// close stops the session's lease requests and nacks the leases the
+// worker still holds, so their tasks retry without waiting for the leases
+// to expire
+func (w *workSession) close(cancel context.CancelFunc) {
+	cancel()
+	w.pending.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id := range w.leases {
+		if err := w.service.queue.Nack(id, queue.Nack{Error: workerDisconnected}); err != nil && !errors.Is(err, errs.ErrLeaseNotFound) {
+			w.service.logger.Warn("failed to nack lease", zap.String("lease_id", id), zap.Error(err))
+		}
+	}
+}
+
+// leasedMessage converts lease for the worker protocol
+func leasedMessage(lease *queue.Lease) (*workerpb.BrokerMessage, error) {
+	t := lease.Task
+	payload, err := structpb.NewStruct(t.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload cannot be sent to a remote worker: %w", err)
+	}
+	pt := &workerpb.Task{
+		Id:            t.ID,
+		Type:          t.Type,
+		Payload:       payload,
+		Priority:      int32(t.Priority),
+		Attempt:       int32(t.Attempt()),
+		MaxAttempts:   int32(t.MaxRetries + 1),
+		CorrelationId: t.CorrelationID,
+		Labels:        t.Labels,
+	}
+	if t.Deadline != nil {
+		pt.Deadline = timestamppb.New(*t.Deadline)
+	}
+	return &workerpb.BrokerMessage{Message: &workerpb.BrokerMessage_Leased{Leased: &workerpb.Lease{
+		LeaseId:   lease.ID,
+		Task:      pt,
+		ExpiresAt: timestamppb.New(lease.Expires),
+	}}}, nil
+}
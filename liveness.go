@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// stallSlack is how much longer than its interval a poller round may take,
+// for storage calls that are slow to fail
+const stallSlack = 10 * time.Second
+
+// pollerLiveness records when the poller last went round its loop
+type pollerLiveness struct {
+	mu       sync.Mutex
+	last     time.Time
+	interval time.Duration
+}
+
+func (l *pollerLiveness) beat(now time.Time, interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last = now
+	l.interval = interval
+}
+
+// Alive reports whether the poller started by Start is still going round
+// its loop, having done so within three of its intervals. An outage of
+// storage does not count against it. Before Start it reports true.
+func (q *Queue) Alive() bool {
+	q.liveness.mu.Lock()
+	defer q.liveness.mu.Unlock()
+	if q.liveness.last.IsZero() {
+		return true
+	}
+	return q.clock.Now().Sub(q.liveness.last) <= 3*q.liveness.interval+stallSlack
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// mixEntry is a task type and its share of the submitted load
+type mixEntry struct {
+	taskType string
+	weight   int
+}
+
+// submitted is a task the generator created, and how long the API took
+type submitted struct {
+	id      string
+	latency time.Duration
+}
+
+// taskState is the part of a task the generator reads back
+type taskState struct {
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "API server base URL")
+	rate := flag.Float64("rate", 50, "tasks submitted per second")
+	duration := flag.Duration("duration", 30*time.Second, "how long to submit for")
+	mixFlag := flag.String("mix", "send_email=3,process_image=1,call_webhook=1", "task types and weights, type=weight,...")
+	priority := flag.Int("priority", -1, "priority for every task (0-100), or -1 for a random priority")
+	payloadBytes := flag.Int("payload-bytes", 64, "size of the filler field in each payload")
+	concurrency := flag.Int("concurrency", 32, "maximum requests in flight")
+	wait := flag.Duration("wait", time.Minute, "how long to wait for submitted tasks to finish (0 skips)")
+	flag.Parse()
+
+	mix, err := parseMix(*mixFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(2)
+	}
+	if *rate <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "loadgen: -rate and -concurrency must be positive")
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	gen := &generator{
+		base:     strings.TrimRight(*url, "/") + "/api/v1",
+		client:   &http.Client{Timeout: 30 * time.Second},
+		mix:      mix,
+		priority: *priority,
+		filler:   strings.Repeat("x", *payloadBytes),
+		runID:    uuid.New().String()[:8],
+		errors:   make(map[string]int),
+	}
+
+	fmt.Printf("run %s: %.0f tasks/s for %s against %s\n", gen.runID, *rate, *duration, gen.base)
+	start := time.Now()
+	tasks := gen.submitAll(ctx, *rate, *duration, *concurrency)
+	elapsed := time.Since(start)
+
+	gen.reportSubmit(tasks, elapsed)
+	if *wait > 0 && len(tasks) > 0 {
+		states := gen.awaitAll(ctx, tasks, *wait, *concurrency)
+		reportProcessing(states)
+	}
+}
+
+// parseMix parses "type=weight,..." into mix entries
+func parseMix(s string) ([]mixEntry, error) {
+	var mix []mixEntry
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		taskType, weight, found := strings.Cut(part, "=")
+		w := 1
+		if found {
+			var err error
+			if w, err = strconv.Atoi(weight); err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight in mix entry %q", part)
+			}
+		}
+		mix = append(mix, mixEntry{taskType: taskType, weight: w})
+	}
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("mix is empty")
+	}
+	return mix, nil
+}
+
+// generator submits tasks and tracks what happened to them
+type generator struct {
+	base     string
+	client   *http.Client
+	mix      []mixEntry
+	priority int
+	filler   string
+	runID    string
+
+	mu     sync.Mutex
+	errors map[string]int
+	rand   *rand.Rand
+}
+
+// pick chooses a task type by weight and a priority
+func (g *generator) pick() (string, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.rand == nil {
+		g.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	total := 0
+	for _, e := range g.mix {
+		total += e.weight
+	}
+	n := g.rand.Intn(total)
+	taskType := g.mix[len(g.mix)-1].taskType
+	for _, e := range g.mix {
+		if n < e.weight {
+			taskType = e.taskType
+			break
+		}
+		n -= e.weight
+	}
+
+	priority := g.priority
+	if priority < 0 {
+		priority = g.rand.Intn(101)
+	}
+	return taskType, priority
+}
+
+// recordError counts a failed request by its API error code
+func (g *generator) recordError(code string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.errors[code]++
+}
+
+// submitAll submits tasks at rate until duration passes or ctx is done
+func (g *generator) submitAll(ctx context.Context, rate float64, duration time.Duration, concurrency int) []submitted {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	var (
+		mu    sync.Mutex
+		tasks []submitted
+		wg    sync.WaitGroup
+	)
+	slots := make(chan struct{}, concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return tasks
+		case <-ticker.C:
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			// Every slot is busy, so the server is not keeping up
+			g.recordError("client_saturated")
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if s, ok := g.submit(); ok {
+				mu.Lock()
+				tasks = append(tasks, s)
+				mu.Unlock()
+			}
+		}()
+	}
+}
+
+// submit sends one task and returns its ID
+func (g *generator) submit() (submitted, bool) {
+	taskType, priority := g.pick()
+	body, _ := json.Marshal(map[string]interface{}{
+		"type":     taskType,
+		"priority": priority,
+		"payload":  map[string]interface{}{"filler": g.filler},
+		"labels":   map[string]string{"loadgen_run": g.runID},
+	})
+
+	start := time.Now()
+	resp, err := g.client.Post(g.base+"/tasks", "application/json", bytes.NewReader(body))
+	latency := time.Since(start)
+	if err != nil {
+		g.recordError("request_failed")
+		return submitted{}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		var e struct {
+			Code string `json:"code"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Code == "" {
+			e.Code = strconv.Itoa(resp.StatusCode)
+		}
+		g.recordError(e.Code)
+		return submitted{}, false
+	}
+
+	var out struct {
+		TaskID string `json:"task_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		g.recordError("bad_response")
+		return submitted{}, false
+	}
+	return submitted{id: out.TaskID, latency: latency}, true
+}
+
+// awaitAll polls every task until all are finished or wait passes
+func (g *generator) awaitAll(ctx context.Context, tasks []submitted, wait time.Duration, concurrency int) []taskState {
+	fmt.Printf("waiting up to %s for %d tasks to finish...\n", wait, len(tasks))
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	states := make([]taskState, len(tasks))
+	for {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, concurrency)
+		for i := range tasks {
+			if finished(states[i].Status) {
+				continue
+			}
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if s, ok := g.fetch(ctx, tasks[i].id); ok {
+					states[i] = s
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		done := true
+		for _, s := range states {
+			if !finished(s.Status) {
+				done = false
+				break
+			}
+		}
+		if done {
+			return states
+		}
+
+		select {
+		case <-ctx.Done():
+			return states
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// fetch loads the current state of a task
+func (g *generator) fetch(ctx context.Context, id string) (taskState, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.base+"/tasks/"+id, nil)
+	if err != nil {
+		return taskState{}, false
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return taskState{}, false
+	}
+	defer resp.Body.Close()
+
+	var s taskState
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&s) != nil {
+		return taskState{}, false
+	}
+	return s, true
+}
+
+// finished reports whether a task status is final
+func finished(status string) bool {
+	return status == "completed" || status == "failed" || status == "expired"
+}
+
+// reportSubmit prints achieved submit throughput, latency and errors
+func (g *generator) reportSubmit(tasks []submitted, elapsed time.Duration) {
+	latencies := make([]time.Duration, len(tasks))
+	for i, s := range tasks {
+		latencies[i] = s.latency
+	}
+
+	failed := 0
+	for _, n := range g.errors {
+		failed += n
+	}
+	attempted := len(tasks) + failed
+
+	fmt.Println()
+	fmt.Println("submit")
+	fmt.Printf("  submitted     %d of %d (%.1f/s)\n", len(tasks), attempted, float64(len(tasks))/elapsed.Seconds())
+	fmt.Printf("  latency       %s\n", percentiles(latencies))
+	if attempted > 0 {
+		fmt.Printf("  error rate    %.2f%%\n", 100*float64(failed)/float64(attempted))
+	}
+	codes := make([]string, 0, len(g.errors))
+	for code := range g.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("    %-20s %d\n", code, g.errors[code])
+	}
+}
+
+// reportProcessing prints how submitted tasks were processed
+func reportProcessing(states []taskState) {
+	counts := make(map[string]int)
+	var waits, totals []time.Duration
+	var first, last time.Time
+	for _, s := range states {
+		status := s.Status
+		if status == "" {
+			status = "unknown"
+		}
+		counts[status]++
+
+		if s.StartedAt != nil {
+			waits = append(waits, s.StartedAt.Sub(s.CreatedAt))
+		}
+		if s.CompletedAt != nil && finished(s.Status) {
+			totals = append(totals, s.CompletedAt.Sub(s.CreatedAt))
+			if first.IsZero() || s.CreatedAt.Before(first) {
+				first = s.CreatedAt
+			}
+			if s.CompletedAt.After(last) {
+				last = *s.CompletedAt
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("processing")
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Printf("  %-13s %d\n", status, counts[status])
+	}
+	if span := last.Sub(first); span > 0 {
+		fmt.Printf("  throughput    %.1f/s\n", float64(len(totals))/span.Seconds())
+	}
+	fmt.Printf("  wait          %s\n", percentiles(waits))
+	fmt.Printf("  end to end    %s\n", percentiles(totals))
+	if n := len(states); n > 0 {
+		fmt.Printf("  failure rate  %.2f%%\n", 100*float64(counts["failed"]+counts["expired"])/float64(n))
+	}
+}
+
+// percentiles formats the p50, p95 and p99 of durations
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	at := func(p float64) time.Duration {
+		return durations[int(p*float64(len(durations)-1))].Round(time.Millisecond)
+	}
+	return fmt.Sprintf("p50 %s  p95 %s  p99 %s", at(0.50), at(0.95), at(0.99))
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// captureLogs returns a logger that also records every entry, down to
+// debug, in a buffer kept with t. It returns a nil buffer when capture is
+// off.
+func (q *Queue) captureLogs(logger *zap.Logger, t *task.Task, workerID string) (*zap.Logger, *logBuffer) {
+	if q.taskLogBytes <= 0 {
+		return logger, nil
+	}
+
+	buf := &logBuffer{limit: q.taskLogBytes}
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	capture := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), buf, zapcore.DebugLevel).With([]zapcore.Field{
+		zap.Int("attempt", t.Attempt()),
+		zap.String("worker", workerID),
+	})
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, capture)
+	})), buf
+}
+
+// saveLogs appends the logs captured during an attempt to t's logs
+func (q *Queue) saveLogs(ctx context.Context, t *task.Task, buf *logBuffer, logger *zap.Logger) {
+	if buf == nil {
+		return
+	}
+	logs := buf.bytes()
+	if len(logs) == 0 {
+		return
+	}
+	if err := q.storage.AppendTaskLogs(ctx, t.ID, logs, q.taskLogBytes); err != nil {
+		logger.Warn("failed to save task logs", zap.Error(err))
+	}
+}
+
+// TaskLogs returns the logs captured while the task with id ran, as JSON
+// lines, oldest first
+func (q *Queue) TaskLogs(ctx context.Context, id string) ([]byte, error) {
+	return q.storage.GetTaskLogs(ctx, id)
+}
+
+// logBuffer is a zapcore.WriteSyncer holding about the last limit bytes
+// written to it
+type logBuffer struct {
+	mu    sync.Mutex
+	data  []byte
+	limit int
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	// Trim in chunks rather than on every line
+	if len(b.data) > 2*b.limit {
+		b.data = append(b.data[:0], storage.TrimLogs(b.data, b.limit)...)
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) Sync() error {
+	return nil
+}
+
+func (b *logBuffer) bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return storage.TrimLogs(b.data, b.limit)
+}
@@ -0,0 +1,77 @@
+// Package logging builds the zap loggers used by the server and workers
+package logging
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log formats
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Config holds logger configuration
+type Config struct {
+	// Level is the level logged at first, defaults to info
+	Level zapcore.Level
+
+	// Format is FormatJSON, the default, or FormatConsole for people
+	// reading logs in a terminal
+	Format string
+
+	// SampleInitial and SampleThereafter thin out busy info and debug logs,
+	// such as the per-task ones: each second, the first SampleInitial
+	// entries with the same message are logged, then every
+	// SampleThereafter-th, or none when it is zero. Warnings and errors are
+	// always logged. Zero SampleInitial turns sampling off.
+	SampleInitial    int
+	SampleThereafter int
+
+	// Output defaults to stderr
+	Output zapcore.WriteSyncer
+}
+
+// New builds a logger. The level it returns can be changed while the
+// logger is in use.
+func New(cfg Config) (*zap.Logger, zap.AtomicLevel, error) {
+	level := zap.NewAtomicLevelAt(cfg.Level)
+	if cfg.Output == nil {
+		cfg.Output = zapcore.Lock(os.Stderr)
+	}
+
+	var encoder zapcore.Encoder
+	switch cfg.Format {
+	case "", FormatJSON:
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	case FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	default:
+		return nil, level, fmt.Errorf("unknown log format %q, want %s or %s", cfg.Format, FormatJSON, FormatConsole)
+	}
+	if cfg.SampleInitial < 0 || cfg.SampleThereafter < 0 {
+		return nil, level, fmt.Errorf("log sampling must not be negative")
+	}
+
+	core := zapcore.NewCore(encoder, cfg.Output, level)
+	if cfg.SampleInitial > 0 {
+		low := zapcore.NewCore(encoder, cfg.Output, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l < zapcore.WarnLevel && level.Enabled(l)
+		}))
+		high := zapcore.NewCore(encoder.Clone(), cfg.Output, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l >= zapcore.WarnLevel && level.Enabled(l)
+		}))
+		core = zapcore.NewTee(
+			zapcore.NewSamplerWithOptions(low, time.Second, cfg.SampleInitial, cfg.SampleThereafter),
+			high,
+		)
+	}
+
+	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+	return logger, level, nil
+}
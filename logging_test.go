@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew_Sampling(t *testing.T) {
+	var out bytes.Buffer
+	logger, _, err := New(Config{SampleInitial: 2, SampleThereafter: 5, Output: zapcore.AddSync(&out)})
+	require.NoError(t, err)
+
+	for i := 0; i < 12; i++ {
+		logger.Info("task completed")
+		logger.Warn("task failed")
+	}
+	assert.Equal(t, 4, strings.Count(out.String(), "task completed"), "first 2, then every 5th")
+	assert.Equal(t, 12, strings.Count(out.String(), "task failed"))
+}
+
+func TestNew_Level(t *testing.T) {
+	var out bytes.Buffer
+	logger, level, err := New(Config{Format: FormatConsole, Output: zapcore.AddSync(&out)})
+	require.NoError(t, err)
+
+	logger.Debug("hidden")
+	level.SetLevel(zapcore.DebugLevel)
+	logger.Debug("shown")
+	assert.NotContains(t, out.String(), "hidden")
+	assert.Contains(t, out.String(), "shown")
+	assert.NotContains(t, out.String(), "{", "console format")
+
+	_, _, err = New(Config{Format: "xml"})
+	assert.ErrorContains(t, err, "unknown log format")
+}
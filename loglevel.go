@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// handleGetLogLevel reports the level the server logs at
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if s.config.LogLevel == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "the log level is not adjustable")
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, LogLevel{Level: s.config.LogLevel.Level().String()})
+}
+
+// handleSetLogLevel changes the level the server logs at, until it
+// restarts
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if s.config.LogLevel == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "the log level is not adjustable")
+		return
+	}
+
+	var req LogLevel
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	level, err := zapcore.ParseLevel(req.Level)
+	if err != nil || level < zapcore.DebugLevel || level > zapcore.ErrorLevel {
+		s.respondErr(w, r, errs.Invalidf("level must be one of debug, info, warn or error"))
+		return
+	}
+
+	from := s.config.LogLevel.Level()
+	s.config.LogLevel.SetLevel(level)
+	s.logger.Warn("log level changed", zap.Stringer("from", from), zap.Stringer("to", level))
+	s.respondJSON(w, r, http.StatusOK, LogLevel{Level: level.String()})
+}
@@ -1,74 +1,573 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/yourusername/distributed-task-queue/api"
+	"github.com/yourusername/distributed-task-queue/internal/alerting"
+	"github.com/yourusername/distributed-task-queue/internal/artifact"
+	"github.com/yourusername/distributed-task-queue/internal/cluster"
+	"github.com/yourusername/distributed-task-queue/internal/election"
+	"github.com/yourusername/distributed-task-queue/internal/email"
+	"github.com/yourusername/distributed-task-queue/internal/idempotency"
+	"github.com/yourusername/distributed-task-queue/internal/logging"
+	"github.com/yourusername/distributed-task-queue/internal/notify"
 	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/reload"
+	"github.com/yourusername/distributed-task-queue/internal/replication"
+	"github.com/yourusername/distributed-task-queue/internal/reporting"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/service"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
+	"github.com/yourusername/distributed-task-queue/internal/sinks"
 	"github.com/yourusername/distributed-task-queue/internal/storage"
 	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"github.com/yourusername/distributed-task-queue/internal/workerpb"
+	"github.com/yourusername/distributed-task-queue/internal/workflow"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	// Initialize logger
-	logger, err := zap.NewProduction()
+	// Initialize logger; its level can be changed by a config reload
+	logger, logLevel, err := newLogger()
 	if err != nil {
 		panic(fmt.Sprintf("failed to create logger: %v", err))
 	}
 	defer logger.Sync()
 
+	// One binary runs every role, so each deployment shares the image and
+	// settings and only picks what it runs
+	roleFlag := flag.String("role", getEnv("ROLE", defaultRoles), "roles to run: api, worker, scheduler or all, comma-separated")
+	flag.Parse()
+	run, err := parseRoles(*roleFlag)
+	if err != nil {
+		logger.Fatal("invalid role", zap.Error(err))
+	}
+
 	// Get configuration from environment
 	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
 	redisPassword := getEnv("REDIS_PASSWORD", "")
 	workerID := getEnv("WORKER_ID", "worker-1")
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		logger.Fatal("invalid SHUTDOWN_TIMEOUT", zap.Error(err))
+	}
+	windows, err := queue.ParseWindows(getEnv("EXECUTION_WINDOWS", ""))
+	if err != nil {
+		logger.Fatal("invalid EXECUTION_WINDOWS", zap.Error(err))
+	}
+	idGenerator, err := task.ParseIDGenerator(getEnv("TASK_ID_FORMAT", "uuid"))
+	if err != nil {
+		logger.Fatal("invalid TASK_ID_FORMAT", zap.Error(err))
+	}
+	task.SetIDGenerator(idGenerator)
+	pollInterval, err := time.ParseDuration(getEnv("POLL_INTERVAL", "1s"))
+	if err != nil {
+		logger.Fatal("invalid POLL_INTERVAL", zap.Error(err))
+	}
+	adaptivePolling, err := strconv.ParseBool(getEnv("ADAPTIVE_POLLING", "false"))
+	if err != nil {
+		logger.Fatal("invalid ADAPTIVE_POLLING", zap.Error(err))
+	}
+	clockSource := getEnv("CLOCK_SOURCE", "local")
+	if clockSource != "local" && clockSource != "redis" {
+		logger.Fatal("invalid CLOCK_SOURCE", zap.String("clock_source", clockSource))
+	}
+	partitionPolling, err := strconv.ParseBool(getEnv("PARTITION_POLLING", "false"))
+	if err != nil {
+		logger.Fatal("invalid PARTITION_POLLING", zap.Error(err))
+	}
+	prefetch, err := strconv.Atoi(getEnv("PREFETCH", "0"))
+	if err != nil {
+		logger.Fatal("invalid PREFETCH", zap.Error(err))
+	}
+	dispatchers, err := strconv.Atoi(getEnv("DISPATCHERS", "0"))
+	if err != nil {
+		logger.Fatal("invalid DISPATCHERS", zap.Error(err))
+	}
+	fairnessWindow, err := strconv.Atoi(getEnv("FAIRNESS_WINDOW", "0"))
+	if err != nil {
+		logger.Fatal("invalid FAIRNESS_WINDOW", zap.Error(err))
+	}
+	resourceTags, err := queue.ParseResourceTags(getEnv("WORKER_TAGS", ""))
+	if err != nil {
+		logger.Fatal("invalid WORKER_TAGS", zap.Error(err))
+	}
+	leaseTimeout, err := time.ParseDuration(getEnv("LEASE_TIMEOUT", "30s"))
+	if err != nil || leaseTimeout <= 0 {
+		logger.Fatal("invalid LEASE_TIMEOUT", zap.String("lease_timeout", getEnv("LEASE_TIMEOUT", "30s")))
+	}
+	maxPending, err := strconv.ParseInt(getEnv("MAX_PENDING", "0"), 10, 64)
+	if err != nil {
+		logger.Fatal("invalid MAX_PENDING", zap.Error(err))
+	}
+	queueLimits, err := queue.ParseQueueLimits(getEnv("MAX_PENDING_PER_QUEUE", ""))
+	if err != nil {
+		logger.Fatal("invalid MAX_PENDING_PER_QUEUE", zap.Error(err))
+	}
+	overflowPolicy, err := queue.ParseOverflowPolicy(getEnv("OVERFLOW_POLICY", "reject"))
+	if err != nil {
+		logger.Fatal("invalid OVERFLOW_POLICY", zap.Error(err))
+	}
+	retryBudgetRatio, err := strconv.ParseFloat(getEnv("RETRY_BUDGET_RATIO", "0"), 64)
+	if err != nil {
+		logger.Fatal("invalid RETRY_BUDGET_RATIO", zap.Error(err))
+	}
+	retryBudgetAction, err := queue.ParseBudgetAction(getEnv("RETRY_BUDGET_ACTION", "delay"))
+	if err != nil {
+		logger.Fatal("invalid RETRY_BUDGET_ACTION", zap.Error(err))
+	}
+	memoryLimit, err := strconv.Atoi(getEnv("WORKER_MEMORY_LIMIT_MB", "0"))
+	if err != nil {
+		logger.Fatal("invalid WORKER_MEMORY_LIMIT_MB", zap.Error(err))
+	}
+	weightBudget, err := strconv.Atoi(getEnv("WORKER_WEIGHT_BUDGET", "0"))
+	if err != nil {
+		logger.Fatal("invalid WORKER_WEIGHT_BUDGET", zap.Error(err))
+	}
+	leakGrace, err := time.ParseDuration(getEnv("HANDLER_LEAK_GRACE", "10s"))
+	if err != nil {
+		logger.Fatal("invalid HANDLER_LEAK_GRACE", zap.Error(err))
+	}
+	slowFactor, err := strconv.ParseFloat(getEnv("SLOW_TASK_FACTOR", "0"), 64)
+	if err != nil {
+		logger.Fatal("invalid SLOW_TASK_FACTOR", zap.Error(err))
+	}
+	var typeConfigs map[string]queue.TypeConfig
+	typesPath := getEnv("TASK_TYPES_FILE", "")
+	if typesPath != "" {
+		typeConfigs, err = queue.LoadTypeConfigs(typesPath)
+		if err != nil {
+			logger.Fatal("invalid TASK_TYPES_FILE", zap.Error(err))
+		}
+	}
+	statusShards, err := strconv.Atoi(getEnv("STATUS_SHARDS", "0"))
+	if err != nil {
+		logger.Fatal("invalid STATUS_SHARDS", zap.Error(err))
+	}
+	numWorkers, err := strconv.Atoi(getEnv("WORKERS", "12"))
+	if err != nil || numWorkers < 1 {
+		logger.Fatal("invalid WORKERS", zap.String("workers", getEnv("WORKERS", "12")))
+	}
+	artifacts, err := newArtifactStore()
+	if err != nil {
+		logger.Fatal("invalid artifact store settings", zap.Error(err))
+	}
+	workerConfigPath := getEnv("WORKER_CONFIG_FILE", "")
+	if workerConfigPath != "" {
+		wc, err := loadWorkerConfig(workerConfigPath)
+		if err != nil {
+			logger.Fatal("invalid WORKER_CONFIG_FILE", zap.Error(err))
+		}
+		if wc.Workers > 0 {
+			numWorkers = wc.Workers
+		}
+		if wc.LogLevel != nil {
+			logLevel.SetLevel(*wc.LogLevel)
+		}
+	}
 
-	logger.Info("starting worker", zap.String("worker_id", workerID))
+	logger.Info("starting", zap.String("worker_id", workerID), zap.Stringer("roles", run))
+
+	// Report start and stop to systemd or the Windows service control
+	// manager, when one started the process, and stop on SIGTERM
+	svc, err := service.Start(service.Config{
+		Name:        getEnv("SERVICE_NAME", "dtq-worker"),
+		Logger:      logger,
+		StopTimeout: shutdownTimeout,
+	})
+	if err != nil {
+		logger.Fatal("failed to start service control", zap.Error(err))
+	}
 
 	// Initialize storage
-	store, err := storage.NewRedisStorage(redisAddr, redisPassword, 0)
+	redisStore, err := storage.NewRedisStorage(redisAddr, redisPassword, 0)
 	if err != nil {
 		logger.Fatal("failed to initialize storage", zap.Error(err))
 	}
-	defer store.Close()
+	defer redisStore.Close()
+	if err := redisStore.SetStatusShards(context.Background(), statusShards); err != nil {
+		logger.Fatal("failed to shard status indexes", zap.Error(err))
+	}
+	if err := redisStore.UpgradePriorities(context.Background()); err != nil {
+		logger.Fatal("failed to upgrade task priorities", zap.Error(err))
+	}
+
+	// Tell the time by Redis when asked to, so deadlines, retries and
+	// schedules fall due at the same moment on every node
+	var clock queue.Clock
+	var now func() time.Time
+	var redisClock *storage.RedisClock
+	if clockSource == "redis" {
+		redisClock = storage.NewRedisClock(redisStore.Client())
+		if err := redisClock.Sync(context.Background()); err != nil {
+			logger.Fatal("failed to read Redis time", zap.Error(err))
+		}
+		logger.Info("telling time by Redis", zap.Duration("offset", redisClock.Offset()))
+		clock, now = redisClock, redisClock.Now
+	}
+
+	// Mirror every task to a standby cluster when one is configured
+	var store storage.Storage = redisStore
+	if addr := getEnv("REPLICA_REDIS_ADDR", ""); addr != "" {
+		replicaStore, err := storage.NewRedisStorage(addr, getEnv("REPLICA_REDIS_PASSWORD", redisPassword), 0)
+		if err != nil {
+			logger.Fatal("failed to initialize replica storage", zap.Error(err))
+		}
+		defer replicaStore.Close()
+		replicated := replication.New(redisStore, replication.Config{Secondary: replicaStore, Logger: logger})
+		defer replicated.Close()
+		store = replicated
+	}
+
+	// Encrypt payloads at rest when keys are configured
+	var keyring *storage.Keyring
+	if spec := getEnv("PAYLOAD_KEYS", ""); spec != "" {
+		keyring, err = storage.ParseKeyring(spec)
+		if err != nil {
+			logger.Fatal("invalid PAYLOAD_KEYS", zap.Error(err))
+		}
+		encrypted := storage.NewEncryptedStorage(store, keyring)
+		encrypted.SetLogger(logger)
+		store = encrypted
+	}
+
+	// Sign tasks and alert webhooks, and refuse tasks without a valid
+	// signature, when keys are configured
+	var signingKeys *signing.Keys
+	if spec := getEnv("SIGNING_KEYS", ""); spec != "" {
+		signingKeys, err = signing.ParseKeys(spec)
+		if err != nil {
+			logger.Fatal("invalid SIGNING_KEYS", zap.Error(err))
+		}
+	}
+
+	// Spill tasks over MAX_PENDING to a second Redis when asked to
+	depthLimits := queue.DepthLimits{Total: maxPending, PerQueue: queueLimits, Policy: overflowPolicy}
+	if overflowPolicy == queue.OverflowSpill {
+		overflowStore, err := storage.NewRedisStorage(getEnv("OVERFLOW_REDIS_ADDR", redisAddr), redisPassword, 1)
+		if err != nil {
+			logger.Fatal("failed to initialize overflow storage", zap.Error(err))
+		}
+		defer overflowStore.Close()
+		depthLimits.Overflow = overflowStore
+		if keyring != nil {
+			encrypted := storage.NewEncryptedStorage(overflowStore, keyring)
+			encrypted.SetLogger(logger)
+			depthLimits.Overflow = encrypted
+		}
+	}
+
+	// Only the elected scheduler releases due tasks and expires overdue ones
+	scheduler := election.New(election.Config{
+		Lease:  election.NewRedisLease(redisStore.Client()),
+		Logger: logger,
+		Role:   "scheduler",
+		ID:     workerID,
+	})
 
 	// Initialize queue
 	q := queue.NewQueue(queue.Config{
-		Storage:      store,
-		Logger:       logger,
-		PollInterval: 1 * time.Second,
-		TaskTimeout:  5 * time.Minute,
+		Storage:          store,
+		Logger:           logger,
+		PollInterval:     pollInterval,
+		TaskTimeout:      5 * time.Minute,
+		ExecutionWindows: windows,
+		Types:            typeConfigs,
+		SigningKeys:      signingKeys,
+		Scheduler:        scheduler,
+		DepthLimits:      depthLimits,
+		RetryBudget:      queue.RetryBudget{Ratio: retryBudgetRatio, Action: retryBudgetAction},
+		MemoryLimitMB:    memoryLimit,
+		WeightBudget:     weightBudget,
+		LeakGrace:        leakGrace,
+		SlowTaskFactor:   slowFactor,
+		Peers:            cluster.NewRedisPeers(redisStore.Client(), workerID),
+		Prefetch:         prefetch,
+		Dispatchers:      dispatchers,
+		FairnessWindow:   fairnessWindow,
+		ResourceTags:     resourceTags,
+		LeaseTimeout:     leaseTimeout,
+		AdaptivePolling:  adaptivePolling,
+		Artifacts:        artifacts,
+		Idempotency:      idempotency.NewRedisStore(redisStore.Client()),
+		Clock:            clock,
+		InstanceID:       workerID,
 	})
 
-	// Register task handlers
-	registerWorkerHandlers(q, logger)
-
-	// Start queue workers
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if redisClock != nil {
+		go redisClock.Run(ctx, 0, logger)
+	}
+
+	registry := cluster.NewRedisRegistry(redisStore.Client())
+
+	// Tell producers how their tasks ended, from whichever role finished
+	// them: workers, or the API when a task is cancelled
+	notify.Attach(q, logger)
+
+	// Forward completed tasks' results to the sinks their types name
+	sinks.Attach(q, logger)
+
+	// Move workflows on as their steps' tasks finish, likewise from any
+	// role
+	workflows := workflow.NewEngine(workflow.Config{
+		Store:  workflow.NewRedisStore(redisStore.Client()),
+		Queue:  q,
+		Logger: logger,
+		Leader: scheduler,
+		Now:    now,
+	})
+	q.OnFinish(workflows.OnFinish)
+
+	if run[roleWorker] {
+		// Register task handlers
+		registerWorkerHandlers(q)
+
+		// Hand the types run by workers in other languages to them through
+		// the lease endpoints and the worker stream
+		for _, taskType := range strings.Split(getEnv("REMOTE_TYPES", ""), ",") {
+			if taskType = strings.TrimSpace(taskType); taskType != "" {
+				q.RegisterRemote(taskType)
+			}
+		}
+		q.RegisterHandler(reporting.TaskType, reporting.Handler(store))
+		q.RegisterHandler(notify.TaskType, notify.Handler(notify.Config{
+			SigningKeys: signingKeys,
+			Publisher:   notify.NewRedisPublisher(redisStore.Client()),
+			Templates:   templates.NewRedisStore(redisStore.Client()),
+		}))
+
+		// Without RESULT_SINKS_FILE every forward fails as naming an
+		// unknown sink
+		resultSinks := map[string]sinks.Sink{}
+		if path := getEnv("RESULT_SINKS_FILE", ""); path != "" {
+			resultSinks, err = sinks.Load(path, sinks.Config{SigningKeys: signingKeys})
+			if err != nil {
+				logger.Fatal("invalid RESULT_SINKS_FILE", zap.Error(err))
+			}
+		}
+		q.RegisterHandler(sinks.TaskType, sinks.Handler(resultSinks))
+
+		// Send real email, instead of the simulation, once a provider is set
+		emailConfig, err := newEmailConfig()
+		if err != nil {
+			logger.Fatal("invalid email settings", zap.Error(err))
+		}
+		if emailConfig.Provider != nil {
+			emailConfig.Store = templates.NewRedisStore(redisStore.Client())
+			q.RegisterHandler(email.TaskType, email.Handler(emailConfig))
+		}
 
-	numWorkers := 3 // Number of concurrent workers
-	q.Start(ctx, numWorkers)
-	defer q.Stop()
+		// Hold back types disabled across the cluster from the first task
+		// on; the heartbeat keeps the list current after that
+		disabled, err := registry.DisabledTypes(ctx)
+		if err != nil {
+			logger.Fatal("failed to read disabled task types", zap.Error(err))
+		}
+		q.SetDisabledTypes(disabled)
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+		// Start queue workers
+		q.Start(ctx, numWorkers)
 
-	logger.Info("shutting down worker...")
-	logger.Info("worker stopped")
+		// Re-read type defaults and worker settings on SIGHUP or when their
+		// files change, without stopping the tasks running meanwhile
+		reloader := reload.NewWatcher(reload.Config{
+			Paths:  []string{typesPath, workerConfigPath},
+			Logger: logger,
+			Apply: func() error {
+				return reloadConfig(q, logLevel, typesPath, workerConfigPath)
+			},
+		})
+		go reloader.Run(ctx)
+	}
+
+	electionCtx, stopElection := context.WithCancel(ctx)
+	var leaders []cluster.Leadership
+	if run[roleScheduler] {
+		go scheduler.Run(electionCtx)
+		leaders = append(leaders, scheduler)
+
+		// Workers release due tasks as they poll; without them the
+		// scheduler does it on its own
+		if !run[roleWorker] {
+			go q.Maintain(ctx)
+		}
+
+		// The elected scheduler also submits the runs of recurring tasks
+		recurring := schedule.NewScheduler(schedule.Config{
+			Store:  schedule.NewRedisStore(redisStore.Client()),
+			Queue:  q,
+			Logger: logger,
+			Leader: scheduler,
+			Now:    now,
+		})
+		go recurring.Run(ctx)
+
+		// It also times out and escalates the manual approvals of workflows
+		go workflows.Run(ctx)
+
+		// Watch failure thresholds when any alert destination is configured
+		if monitor := newAlertMonitor(store, signingKeys, logger); monitor != nil {
+			go monitor.Run(ctx)
+		}
+	}
+
+	// Report this worker or scheduler in the cluster registry until it has
+	// stopped; the API server reports itself
+	var heartbeat *cluster.Heartbeat
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	heartbeatDone := make(chan struct{})
+	if run[roleWorker] || run[roleScheduler] {
+		kind := cluster.KindScheduler
+		if run[roleWorker] {
+			kind = cluster.KindWorker
+		}
+		heartbeatConfig := cluster.HeartbeatConfig{
+			Registry: registry,
+			Logger:   logger,
+			ID:       workerID,
+			Kind:     kind,
+			Types:    q.Types,
+			Versions: q.Versions,
+			Tags:     q.ResourceTags,
+			Leaders:  leaders,
+			Disabled: q.SetDisabledTypes,
+		}
+		// Split the status index shards between the workers' pollers
+		if run[roleWorker] && partitionPolling {
+			heartbeatConfig.Partition = q.SetPollPartition
+		}
+		heartbeat = cluster.NewHeartbeat(heartbeatConfig)
+		go func() {
+			heartbeat.Run(heartbeatCtx)
+			close(heartbeatDone)
+		}()
+	} else {
+		close(heartbeatDone)
+	}
+
+	// Serve the API on PORT
+	var server *api.Server
+	if run[roleAPI] {
+		server = api.NewServer(api.Config{
+			Queue:         q,
+			Logger:        logger,
+			SigningKeys:   signingKeys,
+			Cluster:       registry,
+			LogLevel:      &logLevel,
+			Artifacts:     artifacts,
+			Templates:     templates.NewRedisStore(redisStore.Client()),
+			TaskTemplates: schedule.NewRedisTemplateStore(redisStore.Client()),
+			Schedules:     schedule.NewRedisStore(redisStore.Client()),
+			Workflows:     workflows,
+		})
+		go func() {
+			if err := server.Start(":" + getEnv("PORT", "8080")); err != nil {
+				logger.Fatal("API server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	// Serve the worker protocol to remote workers on GRPC_PORT, when asked
+	// to
+	var grpcServer *grpc.Server
+	if port := getEnv("GRPC_PORT", ""); port != "" && run[roleWorker] {
+		lis, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			logger.Fatal("failed to listen for remote workers", zap.Error(err))
+		}
+		grpcServer = grpc.NewServer()
+		workerpb.RegisterWorkerServer(grpcServer, api.NewWorkerService(q, logger))
+		go func() {
+			logger.Info("worker stream listening", zap.String("port", port))
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Fatal("worker stream failed", zap.Error(err))
+			}
+		}()
+	}
+
+	// Serve pprof and the queue's internals on their own address, apart
+	// from the API, when asked to
+	if addr := getEnv("DEBUG_ADDR", ""); addr != "" {
+		go func() {
+			logger.Info("debug endpoints listening", zap.String("addr", addr))
+			if err := http.ListenAndServe(addr, api.DebugHandler(q)); err != nil {
+				logger.Error("debug endpoints failed", zap.Error(err))
+			}
+		}()
+	}
+
+	// Ping the systemd watchdog while the poller keeps going round, then
+	// wait to be stopped
+	svc.Ready()
+	go svc.Watchdog(ctx, q.Alive)
+	<-svc.Done()
+
+	logger.Info("shutting down...")
+	svc.Stopping()
+
+	// Hand the scheduler role to another worker straight away, and tell
+	// the cluster this worker is on its way out
+	stopElection()
+	if heartbeat != nil {
+		if err := heartbeat.SetDraining(context.Background()); err != nil {
+			logger.Warn("failed to report draining", zap.Error(err))
+		}
+	}
+
+	// Drain the API, hand prefetched tasks to the other workers and let
+	// running tasks finish, then cancel whatever is still going
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+
+	if server != nil {
+		// Shutting the server down stops the queue too
+		err = server.Shutdown(shutdownCtx)
+	} else {
+		err = q.Shutdown(shutdownCtx)
+	}
+	if err != nil {
+		logger.Warn("tasks still running at shutdown, cancelling", zap.Error(err))
+		cancel()
+		q.Stop()
+	}
+
+	// Remote workers keep their streams while the queue drains, to end the
+	// tasks they run
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+
+	stopHeartbeat()
+	<-heartbeatDone
+
+	logger.Info("stopped")
+	svc.Stopped()
 }
 
-// registerWorkerHandlers registers task handlers for this worker
-func registerWorkerHandlers(q *queue.Queue, logger *zap.Logger) {
+// registerWorkerHandlers registers task handlers for this worker. Handlers
+// log through the task logger, which already identifies the task and attempt.
+func registerWorkerHandlers(q *queue.Queue) {
 	// Email handler
 	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
-		logger.Info("sending email", zap.String("task_id", t.ID))
+		logger := task.LoggerFromContext(ctx)
+		logger.Info("sending email")
 		
 		// Simulate work
 		time.Sleep(2 * time.Second)
@@ -85,7 +584,8 @@ func registerWorkerHandlers(q *queue.Queue, logger *zap.Logger) {
 
 	// Image processing handler
 	q.RegisterHandler("process_image", func(ctx context.Context, t *task.Task) error {
-		logger.Info("processing image", zap.String("task_id", t.ID))
+		logger := task.LoggerFromContext(ctx)
+		logger.Info("processing image")
 		
 		// Simulate work
 		time.Sleep(5 * time.Second)
@@ -97,19 +597,32 @@ func registerWorkerHandlers(q *queue.Queue, logger *zap.Logger) {
 
 	// Data export handler
 	q.RegisterHandler("export_data", func(ctx context.Context, t *task.Task) error {
-		logger.Info("exporting data", zap.String("task_id", t.ID))
+		logger := task.LoggerFromContext(ctx)
+		logger.Info("exporting data")
 		
 		// Simulate work
 		time.Sleep(10 * time.Second)
 		
 		format, _ := t.Payload["format"].(string)
+		if format == "" {
+			format = "csv"
+		}
+
+		// Hand the export to the caller through the artifact store
+		export := []byte("id,status\n1,ok\n")
+		name := "export." + format
+		err := artifact.Attach(ctx, t, name, bytes.NewReader(export), int64(len(export)), "text/"+format)
+		if err != nil && !errors.Is(err, artifact.ErrNoStore) {
+			return err
+		}
 		logger.Info("data exported", zap.String("format", format))
 		return nil
 	})
 
 	// Webhook handler
 	q.RegisterHandler("call_webhook", func(ctx context.Context, t *task.Task) error {
-		logger.Info("calling webhook", zap.String("task_id", t.ID))
+		logger := task.LoggerFromContext(ctx)
+		logger.Info("calling webhook")
 		
 		// Simulate work
 		time.Sleep(3 * time.Second)
@@ -121,7 +634,8 @@ func registerWorkerHandlers(q *queue.Queue, logger *zap.Logger) {
 
 	// Batch processing handler
 	q.RegisterHandler("batch_process", func(ctx context.Context, t *task.Task) error {
-		logger.Info("batch processing", zap.String("task_id", t.ID))
+		logger := task.LoggerFromContext(ctx)
+		logger.Info("batch processing")
 		
 		// Simulate work
 		time.Sleep(15 * time.Second)
@@ -132,6 +646,189 @@ func registerWorkerHandlers(q *queue.Queue, logger *zap.Logger) {
 	})
 }
 
+// newAlertMonitor builds an alert monitor from ALERT_* environment variables,
+// or returns nil when no notifier is configured
+func newAlertMonitor(store storage.Storage, signingKeys *signing.Keys, logger *zap.Logger) *alerting.Monitor {
+	var notifiers []alerting.Notifier
+	if url := getEnv("ALERT_SLACK_WEBHOOK", ""); url != "" {
+		notifiers = append(notifiers, &alerting.SlackNotifier{WebhookURL: url})
+	}
+	if key := getEnv("ALERT_PAGERDUTY_ROUTING_KEY", ""); key != "" {
+		notifiers = append(notifiers, &alerting.PagerDutyNotifier{RoutingKey: key})
+	}
+	if url := getEnv("ALERT_WEBHOOK_URL", ""); url != "" {
+		notifiers = append(notifiers, &alerting.WebhookNotifier{URL: url, Signer: signingKeys})
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	failureRate, err := strconv.ParseFloat(getEnv("ALERT_FAILURE_RATE", "0.25"), 64)
+	if err != nil {
+		logger.Fatal("invalid ALERT_FAILURE_RATE", zap.Error(err))
+	}
+	deadLetterGrowth, err := strconv.ParseInt(getEnv("ALERT_DEAD_LETTER_GROWTH", "50"), 10, 64)
+	if err != nil {
+		logger.Fatal("invalid ALERT_DEAD_LETTER_GROWTH", zap.Error(err))
+	}
+	oldestPending, err := time.ParseDuration(getEnv("ALERT_OLDEST_PENDING", "15m"))
+	if err != nil {
+		logger.Fatal("invalid ALERT_OLDEST_PENDING", zap.Error(err))
+	}
+	poisonTasks, err := strconv.ParseBool(getEnv("ALERT_POISON_TASKS", "true"))
+	if err != nil {
+		logger.Fatal("invalid ALERT_POISON_TASKS", zap.Error(err))
+	}
+
+	return alerting.NewMonitor(alerting.Config{
+		Storage: store,
+		Logger:  logger,
+		Thresholds: alerting.Thresholds{
+			FailureRate:      failureRate,
+			MinFinished:      20,
+			DeadLetterGrowth: deadLetterGrowth,
+			OldestPending:    oldestPending,
+			PoisonTasks:      poisonTasks,
+		},
+		Notifiers: notifiers,
+	})
+}
+
+// newLogger builds the logger from LOG_LEVEL, LOG_FORMAT and the
+// LOG_SAMPLE_* settings
+func newLogger() (*zap.Logger, zap.AtomicLevel, error) {
+	var cfg logging.Config
+	if err := cfg.Level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return nil, zap.AtomicLevel{}, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	cfg.Format = getEnv("LOG_FORMAT", logging.FormatJSON)
+
+	var err error
+	if cfg.SampleInitial, err = strconv.Atoi(getEnv("LOG_SAMPLE_INITIAL", "100")); err != nil {
+		return nil, zap.AtomicLevel{}, fmt.Errorf("invalid LOG_SAMPLE_INITIAL: %w", err)
+	}
+	if cfg.SampleThereafter, err = strconv.Atoi(getEnv("LOG_SAMPLE_THEREAFTER", "100")); err != nil {
+		return nil, zap.AtomicLevel{}, fmt.Errorf("invalid LOG_SAMPLE_THEREAFTER: %w", err)
+	}
+	return logging.New(cfg)
+}
+
+// newArtifactStore returns the store handlers attach files to: a bucket
+// with ARTIFACT_BUCKET, a directory with ARTIFACT_DIR, or nil for none
+func newArtifactStore() (artifact.Store, error) {
+	if bucket := getEnv("ARTIFACT_BUCKET", ""); bucket != "" {
+		pathStyle, err := strconv.ParseBool(getEnv("ARTIFACT_PATH_STYLE", "false"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARTIFACT_PATH_STYLE: %w", err)
+		}
+		return artifact.NewS3Store(artifact.S3Config{
+			Bucket:          bucket,
+			AccessKeyID:     getEnv("ARTIFACT_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretAccessKey: getEnv("ARTIFACT_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			Region:          getEnv("ARTIFACT_REGION", ""),
+			Endpoint:        getEnv("ARTIFACT_ENDPOINT", ""),
+			PathStyle:       pathStyle,
+		})
+	}
+	if dir := getEnv("ARTIFACT_DIR", ""); dir != "" {
+		return artifact.NewDirStore(dir)
+	}
+	return nil, nil
+}
+
+// newEmailConfig configures the email handler from EMAIL_PROVIDER, one of
+// smtp, ses or sendgrid, and the settings of that provider. The provider
+// is nil when EMAIL_PROVIDER is unset.
+func newEmailConfig() (email.Config, error) {
+	cfg := email.Config{From: getEnv("EMAIL_FROM", "")}
+	if dir := getEnv("EMAIL_TEMPLATES_DIR", ""); dir != "" {
+		templates, err := email.LoadTemplates(dir)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Templates = templates
+	}
+
+	var err error
+	switch provider := getEnv("EMAIL_PROVIDER", ""); provider {
+	case "":
+	case "smtp":
+		cfg.Provider, err = email.NewSMTPProvider(email.SMTPConfig{
+			Addr:     getEnv("SMTP_ADDR", "localhost:587"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+		})
+	case "ses":
+		cfg.Provider, err = email.NewSESProvider(email.SESConfig{
+			Region:          getEnv("SES_REGION", os.Getenv("AWS_REGION")),
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	case "sendgrid":
+		cfg.Provider, err = email.NewSendGridProvider(email.SendGridConfig{APIKey: getEnv("SENDGRID_API_KEY", "")})
+	default:
+		err = fmt.Errorf("unknown EMAIL_PROVIDER %q, want smtp, ses or sendgrid", provider)
+	}
+	return cfg, err
+}
+
+// workerConfig holds the settings read from WORKER_CONFIG_FILE, such as
+//
+//	{"workers": 8, "log_level": "debug"}
+//
+// Set fields override WORKERS and LOG_LEVEL, and are re-read on reload.
+type workerConfig struct {
+	Workers  int            `json:"workers,omitempty"`
+	LogLevel *zapcore.Level `json:"log_level,omitempty"`
+}
+
+func loadWorkerConfig(path string) (workerConfig, error) {
+	var wc workerConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return wc, fmt.Errorf("failed to read worker config: %w", err)
+	}
+	if err := json.Unmarshal(data, &wc); err != nil {
+		return wc, fmt.Errorf("invalid worker config in %s: %w", path, err)
+	}
+	if wc.Workers < 0 {
+		return wc, fmt.Errorf("invalid worker config in %s: workers must be positive", path)
+	}
+	return wc, nil
+}
+
+// reloadConfig re-reads both config files and applies them to the running
+// worker. Nothing is applied unless both files are valid.
+func reloadConfig(q *queue.Queue, logLevel zap.AtomicLevel, typesPath, workerConfigPath string) error {
+	var typeConfigs map[string]queue.TypeConfig
+	if typesPath != "" {
+		var err error
+		if typeConfigs, err = queue.LoadTypeConfigs(typesPath); err != nil {
+			return err
+		}
+	}
+	var wc workerConfig
+	if workerConfigPath != "" {
+		var err error
+		if wc, err = loadWorkerConfig(workerConfigPath); err != nil {
+			return err
+		}
+	}
+
+	if typesPath != "" {
+		if err := q.SetTypeConfigs(typeConfigs); err != nil {
+			return err
+		}
+	}
+	if wc.LogLevel != nil {
+		logLevel.SetLevel(*wc.LogLevel)
+	}
+	if wc.Workers > 0 {
+		return q.SetWorkers(wc.Workers)
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
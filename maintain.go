@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Maintain releases due scheduled tasks, expires overdue ones and refills
+// from overflow storage every PollInterval while this instance leads
+// Config.Scheduler, until ctx is done or the queue stops. The poller
+// started by Start does the same, so only processes running no workers
+// need it.
+func (q *Queue) Maintain(ctx context.Context) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !q.health.shouldPoll(q.clock.Now()) || q.health.isPaused() {
+				continue
+			}
+			if q.scheduler != nil && !q.scheduler.IsLeader() {
+				continue
+			}
+			q.expireOverdueTasks(ctx)
+			q.promoteDueTasks(ctx)
+			q.refillFromOverflow(ctx)
+			q.observeQueues(ctx)
+		}
+	}
+}
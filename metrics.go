@@ -15,6 +15,16 @@ var (
 		[]string{"type", "priority"},
 	)
 
+	// TasksSubmittedBySource tracks total tasks submitted, by who
+	// submitted them
+	TasksSubmittedBySource = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tasks_submitted_by_source_total",
+			Help: "Total number of tasks submitted, by task type and source",
+		},
+		[]string{"type", "source"},
+	)
+
 	// TasksProcessed tracks total tasks processed
 	TasksProcessed = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -43,6 +53,16 @@ var (
 		[]string{"priority"},
 	)
 
+	// QueueDepth tracks the tasks of each named queue by status, as read
+	// from storage by the scheduler leader
+	QueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_depth",
+			Help: "Current number of tasks in each queue by status",
+		},
+		[]string{"queue", "status"},
+	)
+
 	// WorkersActive tracks active workers
 	WorkersActive = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -51,6 +71,25 @@ var (
 		},
 	)
 
+	// TasksByLabel tracks tasks by the values of allowlisted task labels,
+	// with event one of submitted, completed, failed or expired
+	TasksByLabel = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tasks_by_label_total",
+			Help: "Total number of tasks by task label",
+		},
+		[]string{"label", "value", "event"},
+	)
+
+	// SLAMissed tracks tasks that expired before completing by their deadline
+	SLAMissed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sla_missed_total",
+			Help: "Total number of tasks that missed their deadline",
+		},
+		[]string{"type"},
+	)
+
 	// TaskRetries tracks task retry counts
 	TaskRetries = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -59,4 +98,278 @@ var (
 		},
 		[]string{"type"},
 	)
+
+	// TaskReschedules counts attempts whose handler asked to run later
+	// with task.Reschedule
+	TaskReschedules = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "task_reschedules_total",
+			Help: "Total number of tasks rescheduled by their handlers",
+		},
+		[]string{"type"},
+	)
+
+	// RetryBudgetExceeded tracks retries over the retry budget, by the
+	// action taken, delay or fail
+	RetryBudgetExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "retry_budget_exceeded_total",
+			Help: "Total number of retries over the retry budget, by task type and action",
+		},
+		[]string{"type", "action"},
+	)
+
+	// TaskFailures tracks failed task attempts, by why they failed
+	TaskFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "task_failures_total",
+			Help: "Total number of failed task attempts, by category",
+		},
+		[]string{"type", "category"},
+	)
+
+	// DuplicateSubmissions tracks submissions rejected for repeating a task
+	// already submitted, by who submitted them
+	DuplicateSubmissions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "duplicate_submissions_total",
+			Help: "Total number of duplicate task submissions, by task type and producer",
+		},
+		[]string{"type", "producer"},
+	)
+
+	// TasksHandedOff tracks prefetched tasks given back at shutdown
+	TasksHandedOff = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tasks_handed_off_total",
+			Help: "Total number of prefetched tasks handed back to other workers at shutdown",
+		},
+	)
+
+	// CPUTimeExceeded tracks handlers cancelled for using more CPU time
+	// than their type allows
+	CPUTimeExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cpu_time_exceeded_total",
+			Help: "Total number of handlers cancelled for exceeding their cpu time",
+		},
+		[]string{"type"},
+	)
+
+	// LeakedHandlers tracks handlers still running well after their
+	// context was cancelled
+	LeakedHandlers = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leaked_handlers_total",
+			Help: "Total number of handlers that kept running after their context was cancelled",
+		},
+		[]string{"type"},
+	)
+
+	// LeakedHandlersRunning tracks leaked handlers that have not returned yet
+	LeakedHandlersRunning = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "leaked_handlers_running",
+			Help: "Number of handlers still running after their context was cancelled",
+		},
+	)
+
+	// SlowTasks tracks attempts that ran far longer than their type's p95
+	SlowTasks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slow_tasks_total",
+			Help: "Total number of attempts that ran longer than the slow task factor times their type's p95",
+		},
+		[]string{"type"},
+	)
+
+	// SlowTasksRunning tracks slow attempts whose handler has not returned yet
+	SlowTasksRunning = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "slow_tasks_running",
+			Help: "Number of attempts running longer than the slow task factor times their type's p95",
+		},
+	)
+
+	// MemoryReserved tracks the memory hints of running tasks
+	MemoryReserved = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "memory_reserved_mb",
+			Help: "Memory in MiB reserved by running tasks, going by their type's memory hint",
+		},
+	)
+
+	// WeightReserved tracks the weight of running tasks
+	WeightReserved = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "weight_reserved",
+			Help: "Weight of the running tasks, out of the worker process's weight budget",
+		},
+	)
+
+	// PoisonTasks tracks tasks quarantined as poison
+	PoisonTasks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "poison_tasks_total",
+			Help: "Total number of tasks quarantined because their attempts kept panicking or timing out",
+		},
+		[]string{"type"},
+	)
+
+	// TasksReplicated tracks writes mirrored to a secondary cluster, by
+	// result: ok, conflict (the replica's copy won), dropped or error
+	TasksReplicated = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tasks_replicated_total",
+			Help: "Total number of task writes mirrored to the secondary cluster",
+		},
+		[]string{"result"},
+	)
+
+	// ReplicationBacklog tracks writes waiting to be mirrored
+	ReplicationBacklog = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "replication_backlog",
+			Help: "Number of task writes waiting to be mirrored",
+		},
+	)
+
+	// PollInterval tracks the poller's current interval, which changes
+	// with adaptive polling
+	PollInterval = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "poll_interval_seconds",
+			Help: "Current interval between storage polls",
+		},
+	)
+
+	// StorageAvailable is 0 while dispatch is paused because storage is
+	// unreachable
+	StorageAvailable = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "storage_available",
+			Help: "1 while storage is reachable, 0 while dispatch is paused",
+		},
+	)
+
+	// HandlerHealthy is 0 while a handler's health check fails and its
+	// tasks are paused
+	HandlerHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "handler_healthy",
+			Help: "1 while the task type's handler passes its health check, 0 while its tasks are paused",
+		},
+		[]string{"type"},
+	)
+
+	// TasksPrefetched tracks tasks pulled from storage that wait in this
+	// process for a worker
+	TasksPrefetched = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tasks_prefetched",
+			Help: "Number of tasks prefetched from storage awaiting a worker",
+		},
+	)
+
+	// ChannelOccupancy tracks the prefetched tasks waiting on each
+	// priority class's channel, by class
+	ChannelOccupancy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "channel_occupancy",
+			Help: "Number of tasks waiting on each priority class's channel",
+		},
+		[]string{"priority"},
+	)
+
+	// DispatchLag tracks how long prefetched tasks wait on their channel,
+	// from the poll that fetched them until a dispatcher takes them off
+	DispatchLag = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "dispatch_lag_seconds",
+			Help:    "Time from a poll prefetching a task to its dispatch",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+		},
+	)
+
+	// StorageLatency tracks the storage operations the queue runs, with
+	// operation one of save, update, get, get_many, delete, poll, due or
+	// overdue
+	StorageLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "storage_operation_seconds",
+			Help:    "Duration of storage operations",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+		},
+		[]string{"operation"},
+	)
+
+	// HandlerQueueWait tracks how long dispatched tasks wait for a worker,
+	// or for room under their type's concurrency limit, by type
+	HandlerQueueWait = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "handler_queue_wait_seconds",
+			Help:    "Time dispatched tasks wait for a worker to start them",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+		},
+		[]string{"type"},
+	)
+
+	// TasksOverflowed tracks submissions that hit a queue depth limit, by
+	// queue and the overflow policy applied
+	TasksOverflowed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tasks_overflowed_total",
+			Help: "Total number of submissions over a queue depth limit",
+		},
+		[]string{"queue", "priority", "policy"},
+	)
+
+	// LeadershipChanges tracks leadership changes seen by this instance,
+	// with event one of acquired, lost or resigned
+	LeadershipChanges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leadership_changes_total",
+			Help: "Total number of leadership changes by role",
+		},
+		[]string{"role", "event"},
+	)
+
+	// ConfigReloads tracks runtime configuration reloads, with result one
+	// of applied or failed
+	ConfigReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Total number of configuration reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	// ScheduledRuns tracks runs of recurring task schedules, with result
+	// one of submitted, skipped, backfilled or failed
+	ScheduledRuns = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scheduled_runs_total",
+			Help: "Total number of recurring task runs by schedule and result",
+		},
+		[]string{"schedule", "result"},
+	)
+
+	// WorkflowsFinished tracks workflows reaching completed, failed or
+	// cancelled
+	WorkflowsFinished = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "workflows_finished_total",
+			Help: "Total number of workflows finished",
+		},
+		[]string{"status"},
+	)
+
+	// Leader is 1 for each role this instance currently leads
+	Leader = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "leader",
+			Help: "Whether this instance is the leader of a role",
+		},
+		[]string{"role"},
+	)
 )
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// PayloadMigrator upgrades a task payload by one schema version
+type PayloadMigrator func(payload map[string]interface{}) (map[string]interface{}, error)
+
+// RegisterMigration registers migrate to upgrade payloads of taskType from
+// version from to from+1. The current version of a type is one past its
+// newest migration, or 1 without any. New tasks are submitted at the
+// current version, and older ones are upgraded step by step when a worker
+// picks them up, before the handler sees them.
+func (q *Queue) RegisterMigration(taskType string, from int, migrate PayloadMigrator) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.migrations[taskType] == nil {
+		q.migrations[taskType] = make(map[int]PayloadMigrator)
+	}
+	q.migrations[taskType][from] = migrate
+	q.logger.Info("registered payload migration",
+		zap.String("type", taskType),
+		zap.Int("from", from),
+		zap.Int("to", from+1),
+	)
+}
+
+// CurrentVersion returns the payload version handlers of taskType expect
+func (q *Queue) CurrentVersion(taskType string) int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.currentVersion(taskType)
+}
+
+// Versions returns the payload version this queue's handlers expect, for
+// every task type with a handler. Workers advertise it so producers and
+// operators can tell which versions the whole cluster understands.
+func (q *Queue) Versions() map[string]int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	versions := make(map[string]int, len(q.handlers))
+	for taskType := range q.handlers {
+		versions[taskType] = q.currentVersion(taskType)
+	}
+	return versions
+}
+
+func (q *Queue) currentVersion(taskType string) int {
+	current := 1
+	for from := range q.migrations[taskType] {
+		if from+1 > current {
+			current = from + 1
+		}
+	}
+	return current
+}
+
+// payloadVersion returns the version of t's payload; tasks stored before
+// versioning existed are version 1
+func payloadVersion(t *task.Task) int {
+	if t.Version < 1 {
+		return 1
+	}
+	return t.Version
+}
+
+// migrate upgrades t's payload to the current version of its type
+func (q *Queue) migrate(t *task.Task) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	current := q.currentVersion(t.Type)
+	for v := payloadVersion(t); v < current; v++ {
+		migrator, ok := q.migrations[t.Type][v]
+		if !ok {
+			return fmt.Errorf("no payload migration from version %d", v)
+		}
+		payload, err := migrator(t.Payload)
+		if err != nil {
+			return fmt.Errorf("payload migration from version %d failed: %w", v, err)
+		}
+		t.Payload = payload
+		t.Version = v + 1
+	}
+	return nil
+}
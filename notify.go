@@ -0,0 +1,322 @@
+// Package notify tells producers how the tasks they submitted ended. A
+// task submitted with a notification is followed, once it reaches a
+// terminal status, by a task delivering the outcome to each channel it
+// named: a webhook, an event bus topic or an email address. Delivery runs
+// as ordinary tasks, so it is retried like any other work and never holds
+// up the worker that finished the producer's task.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/email"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"go.uber.org/zap"
+)
+
+// TaskType is the type of the tasks that deliver webhook and topic
+// notifications. Email notifications are sent as email.TaskType tasks.
+const TaskType = "notify_producer"
+
+const (
+	channelWebhook = "webhook"
+	channelTopic   = "topic"
+)
+
+// Event is the outcome of a task, as producers are told it
+type Event struct {
+	TaskID        string                 `json:"task_id"`
+	Type          string                 `json:"type"`
+	Status        task.Status            `json:"status"`
+	Priority      task.Priority          `json:"priority"`
+	Error         string                 `json:"error,omitempty"`
+	ErrorCategory task.ErrorCategory     `json:"error_category,omitempty"`
+	Result        map[string]interface{} `json:"result,omitempty"`
+	Labels        map[string]string      `json:"labels,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Attempts      int                    `json:"attempts"`
+	FinishedAt    time.Time              `json:"finished_at"`
+}
+
+// NewEvent describes how t ended
+func NewEvent(t *task.Task) Event {
+	e := Event{
+		TaskID:        t.ID,
+		Type:          t.Type,
+		Status:        t.Status,
+		Priority:      t.Priority,
+		Error:         t.Error,
+		ErrorCategory: t.ErrorCategory,
+		Result:        t.Result,
+		Labels:        t.Labels,
+		CorrelationID: t.CorrelationID,
+		Attempts:      len(t.Attempts),
+		FinishedAt:    time.Now().UTC(),
+	}
+	if t.CompletedAt != nil {
+		e.FinishedAt = t.CompletedAt.UTC()
+	}
+	return e
+}
+
+// data returns e as the map templates are rendered with, keyed as in its
+// JSON
+func (e Event) data() (map[string]interface{}, error) {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	return data, nil
+}
+
+// Payload is the payload of a TaskType task
+type Payload struct {
+	// Channel is "webhook" or "topic", and Target the URL or topic name
+	Channel  string `json:"channel"`
+	Target   string `json:"target"`
+	Template string `json:"template,omitempty"`
+	Event    Event  `json:"event"`
+}
+
+// Queue is the part of *queue.Queue notifications are submitted through
+type Queue interface {
+	OnFinish(fn queue.TaskCallback)
+	NewTask(taskType string, payload map[string]interface{}) *task.Task
+	Submit(ctx context.Context, t *task.Task, opts ...queue.SubmitOption) error
+}
+
+// Attach makes q submit the notifications each finished task asked for.
+// They keep the task's priority and correlation ID, so an urgent task's
+// producer hears about it as soon as other urgent work would run.
+func Attach(q Queue, logger *zap.Logger) {
+	q.OnFinish(func(ctx context.Context, t *task.Task) {
+		n := t.Notify
+		if n == nil || t.Type == TaskType || !n.Wants(t.Status) {
+			return
+		}
+		event := NewEvent(t)
+		for _, out := range deliveries(q, n, event) {
+			out.Priority = t.Priority
+			out.CorrelationID = t.CorrelationID
+			if err := q.Submit(ctx, out); err != nil {
+				logger.Error("failed to submit producer notification",
+					zap.String("id", t.ID),
+					zap.String("type", out.Type),
+					zap.Error(err),
+				)
+			}
+		}
+	})
+}
+
+// deliveries builds a task for each channel n names
+func deliveries(q Queue, n *task.Notification, event Event) []*task.Task {
+	var out []*task.Task
+	for _, ch := range []struct{ channel, target string }{
+		{channelWebhook, n.Webhook},
+		{channelTopic, n.Topic},
+	} {
+		if ch.target == "" {
+			continue
+		}
+		out = append(out, q.NewTask(TaskType, map[string]interface{}{
+			"channel":  ch.channel,
+			"target":   ch.target,
+			"template": n.Template,
+			"event":    event,
+		}))
+	}
+	if n.Email != "" {
+		data, _ := event.data()
+		payload := map[string]interface{}{"to": []string{n.Email}, "data": data}
+		if n.Template != "" {
+			payload["template"] = n.Template
+		} else {
+			payload["subject"] = fmt.Sprintf("Task %s %s", event.TaskID, event.Status)
+			payload["body"] = defaultText(event)
+		}
+		out = append(out, q.NewTask(email.TaskType, payload))
+	}
+	return out
+}
+
+// defaultText is the message sent without a template
+func defaultText(e Event) string {
+	text := fmt.Sprintf("Task %s of type %s finished as %s after %d attempt(s).", e.TaskID, e.Type, e.Status, e.Attempts)
+	if e.Error != "" {
+		text += "\n\nError: " + e.Error
+	}
+	return text
+}
+
+// Publisher publishes events on the event bus
+type Publisher interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+}
+
+// RedisPublisher publishes each topic as the Redis stream events:<topic>,
+// trimmed to about MaxLen entries
+type RedisPublisher struct {
+	client *redis.Client
+	// MaxLen bounds each stream, defaults to 10000
+	MaxLen int64
+}
+
+// NewRedisPublisher creates a publisher on client
+func NewRedisPublisher(client *redis.Client) *RedisPublisher {
+	return &RedisPublisher{client: client, MaxLen: 10000}
+}
+
+// StreamKey returns the Redis stream topic is published on
+func StreamKey(topic string) string {
+	return "events:" + topic
+}
+
+func (p *RedisPublisher) Publish(ctx context.Context, topic string, data []byte) error {
+	err := p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: StreamKey(topic),
+		MaxLen: p.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": data},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Config holds handler configuration
+type Config struct {
+	// Client sends webhooks, defaults to one with a 10s timeout
+	Client *http.Client
+	// SigningKeys, if set, sign webhook bodies, as alert webhooks are
+	SigningKeys *signing.Keys
+
+	// Publisher publishes topic notifications; without one they fail
+	Publisher Publisher
+
+	// Templates holds the templates notifications name
+	Templates templates.Store
+}
+
+// Handler delivers the webhook and topic notifications each task
+// describes. Without a template the body is the Event as JSON; with one,
+// it is the template's text rendered with the Event.
+func Handler(cfg Config) func(ctx context.Context, t *task.Task) error {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return func(ctx context.Context, t *task.Task) error {
+		var p Payload
+		data, err := json.Marshal(t.Payload)
+		if err == nil {
+			err = json.Unmarshal(data, &p)
+		}
+		if err != nil {
+			return task.InvalidPayload(err)
+		}
+		body, contentType, err := cfg.body(ctx, p)
+		if err != nil {
+			return err
+		}
+
+		switch p.Channel {
+		case channelWebhook:
+			err = cfg.post(ctx, p.Target, contentType, body)
+		case channelTopic:
+			if cfg.Publisher == nil {
+				return task.Permanent(errors.New("no event bus is configured"))
+			}
+			err = cfg.Publisher.Publish(ctx, p.Target, body)
+		default:
+			return task.Permanent(fmt.Errorf("unknown notification channel %q", p.Channel))
+		}
+		if err != nil {
+			return err
+		}
+		task.LoggerFromContext(ctx).Info("producer notified",
+			zap.String("channel", p.Channel),
+			zap.String("task_id", p.Event.TaskID),
+			zap.String("status", string(p.Event.Status)),
+		)
+		return nil
+	}
+}
+
+// body returns what p delivers and its content type
+func (cfg Config) body(ctx context.Context, p Payload) ([]byte, string, error) {
+	if p.Template == "" {
+		data, err := json.Marshal(p.Event)
+		if err != nil {
+			return nil, "", task.Permanent(fmt.Errorf("failed to encode event: %w", err))
+		}
+		return data, "application/json", nil
+	}
+
+	if cfg.Templates == nil {
+		return nil, "", task.Permanent(fmt.Errorf("notification template %q requested but no templates are stored", p.Template))
+	}
+	tmpl, err := cfg.Templates.Get(ctx, p.Template, 0)
+	if errors.Is(err, errs.ErrTemplateNotFound) {
+		return nil, "", task.Permanent(err)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := p.Event.data()
+	if err != nil {
+		return nil, "", task.Permanent(err)
+	}
+	rendered, err := tmpl.Render(data)
+	if err != nil {
+		return nil, "", task.Permanent(err)
+	}
+	body := []byte(rendered.Text)
+	if json.Valid(body) {
+		return body, "application/json", nil
+	}
+	return body, "text/plain; charset=utf-8", nil
+}
+
+// post sends body to url. Client errors other than 429 will not change on
+// a retry.
+func (cfg Config) post(ctx context.Context, url, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return task.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cfg.SigningKeys != nil {
+		cfg.SigningKeys.SignRequest(req, body)
+	}
+
+	resp, err := cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("notification webhook returned %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return task.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/email"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/queuetest"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"go.uber.org/zap"
+)
+
+type fakePublisher struct {
+	mu     sync.Mutex
+	topics []string
+	events [][]byte
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, topic string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topics = append(p.topics, topic)
+	p.events = append(p.events, data)
+	return nil
+}
+
+func TestNotify_TerminalStatuses(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		webhooks []Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		mu.Lock()
+		webhooks = append(webhooks, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	h := queuetest.New(t, queue.Config{})
+	Attach(h.Queue, zap.NewNop())
+	bus := &fakePublisher{}
+	h.Handle(TaskType, Handler(Config{Publisher: bus}))
+	var emails []map[string]interface{}
+	h.Handle(email.TaskType, func(ctx context.Context, t *task.Task) error {
+		emails = append(emails, t.Payload)
+		return nil
+	})
+	h.Handle("work", func(ctx context.Context, t *task.Task) error {
+		if t.Payload["fail"] == true {
+			return task.Permanent(errors.New("upstream rejected the order"))
+		}
+		return nil
+	})
+
+	failing := task.NewTask("work", task.PriorityHigh, map[string]interface{}{"fail": true})
+	failing.CorrelationID = "order-42"
+	failing.Notify = &task.Notification{Webhook: srv.URL, Topic: "orders", Email: "producer@example.com"}
+	h.Submit(failing)
+
+	// Only failures were asked for, so a completion says nothing
+	quiet := task.NewTask("work", task.PriorityLow, nil)
+	quiet.Notify = &task.Notification{Webhook: srv.URL, On: []task.Status{task.StatusFailed}}
+	h.Submit(quiet)
+
+	h.Submit(task.NewTask("work", task.PriorityLow, nil))
+	h.ProcessAll()
+
+	h.AssertStatus(failing.ID, task.StatusFailed)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, failing.ID, webhooks[0].TaskID)
+	assert.Equal(t, task.StatusFailed, webhooks[0].Status)
+	assert.Equal(t, "order-42", webhooks[0].CorrelationID)
+	assert.Contains(t, webhooks[0].Error, "upstream rejected the order")
+
+	require.Equal(t, []string{"orders"}, bus.topics)
+	var published Event
+	require.NoError(t, json.Unmarshal(bus.events[0], &published))
+	assert.Equal(t, failing.ID, published.TaskID)
+
+	require.Len(t, emails, 1)
+	assert.Equal(t, "Task "+failing.ID+" failed", emails[0]["subject"])
+}
+
+func TestNotify_Template(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		assert.Equal(t, "text/plain; charset=utf-8", r.Header.Get("Content-Type"))
+	}))
+	defer srv.Close()
+
+	store := templates.NewMemoryStore()
+	require.NoError(t, store.Put(context.Background(), &templates.Template{
+		Name: "order-done",
+		Text: "order {{.task_id}} is {{.status}}",
+	}))
+
+	h := queuetest.New(t, queue.Config{})
+	Attach(h.Queue, zap.NewNop())
+	h.Handle(TaskType, Handler(Config{Templates: store}))
+	h.Handle("work", func(ctx context.Context, t *task.Task) error { return nil })
+
+	tk := task.NewTask("work", task.PriorityLow, nil)
+	tk.Notify = &task.Notification{Webhook: srv.URL, Template: "order-done"}
+	h.Submit(tk)
+	h.ProcessAll()
+
+	assert.Equal(t, "order "+tk.ID+" is completed", body)
+}
+
+func TestNotify_WebhookRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	h := queuetest.New(t, queue.Config{})
+	h.Handle(TaskType, Handler(Config{}))
+
+	tk := task.NewTask(TaskType, task.PriorityLow, map[string]interface{}{
+		"channel": "webhook",
+		"target":  srv.URL,
+		"event":   Event{TaskID: "t1", Status: task.StatusCompleted},
+	})
+	h.Submit(tk)
+	h.ProcessAll()
+
+	// A client error will not change on a retry
+	h.AssertTransitions(tk.ID, task.StatusPending, task.StatusProcessing, task.StatusFailed)
+}
@@ -0,0 +1,934 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/reporting"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"github.com/yourusername/distributed-task-queue/internal/workflow"
+)
+
+// enumValues lists the allowed values for enum-like types in the spec
+var enumValues = map[reflect.Type][]interface{}{
+	reflect.TypeOf(task.Status("")): statusValues(),
+}
+
+// statusValues lists every task status for the spec
+func statusValues() []interface{} {
+	values := make([]interface{}, len(task.Statuses))
+	for i, status := range task.Statuses {
+		values[i] = status
+	}
+	return values
+}
+
+// specSchemas are the named component schemas, generated from the structs
+// the handlers actually encode and decode
+var specSchemas = map[string]interface{}{
+	"Task":                   task.Task{},
+	"TaskResponse":           TaskResponse{},
+	"SubmitTaskRequest":      SubmitTaskRequest{},
+	"SubmitTaskResponse":     SubmitTaskResponse{},
+	"SubmitBatchRequest":     SubmitBatchRequest{},
+	"SubmitBatchResponse":    SubmitBatchResponse{},
+	"TimeSeriesResponse":     TimeSeriesResponse{},
+	"ErrorStatsResponse":     ErrorStatsResponse{},
+	"DuplicateStatsResponse": DuplicateStatsResponse{},
+	"AttemptsResponse":       AttemptsResponse{},
+	"ScalingResponse":        ScalingResponse{},
+	"ListTasksResponse":      ListTasksResponse{},
+	"ErrorResponse":          ErrorResponse{},
+	"HealthResponse":         HealthResponse{},
+	"ClusterResponse":        ClusterResponse{},
+	"TypesResponse":          TypesResponse{},
+	"TypeInfo":               TypeInfo{},
+	"LogLevel":               LogLevel{},
+	"ArtifactsResponse":      ArtifactsResponse{},
+	"Template":               templates.Template{},
+	"TemplateRequest":        TemplateRequest{},
+	"TemplatesResponse":      TemplatesResponse{},
+	"RenderTemplateRequest":  RenderTemplateRequest{},
+	"RenderedTemplate":       templates.Rendered{},
+	"Schedule":               schedule.Schedule{},
+	"ScheduleRequest":        ScheduleRequest{},
+	"SchedulesResponse":      SchedulesResponse{},
+	"TaskTemplate":           schedule.TaskTemplate{},
+	"NamedTemplate":          schedule.NamedTemplate{},
+	"TaskTemplatesResponse":  TaskTemplatesResponse{},
+	"BackfillRequest":        BackfillRequest{},
+	"BackfillResponse":       BackfillResponse{},
+	"ImportResult":           queue.ImportResult{},
+	"DoctorReport":           queue.DoctorReport{},
+	"WorkflowRequest":        WorkflowRequest{},
+	"Workflow":               workflow.Workflow{},
+	"WorkflowsResponse":      WorkflowsResponse{},
+	"ApprovalRequest":        ApprovalRequest{},
+	"DefinitionRequest":      DefinitionRequest{},
+	"Definition":             workflow.Definition{},
+	"DefinitionsResponse":    DefinitionsResponse{},
+	"WorkflowGraph":          workflow.Graph{},
+	"DryRunResponse":         DryRunResponse{},
+	"ReportRequest":          reporting.Request{},
+	"Report":                 reporting.Report{},
+	"TaskStatusRequest":      TaskStatusRequest{},
+	"TaskStatusResponse":     TaskStatusResponse{},
+	"LeaseRequest":           LeaseRequest{},
+	"Lease":                  queue.Lease{},
+	"Progress":               queue.Progress{},
+	"AckRequest":             AckRequest{},
+	"NackRequest":            NackRequest{},
+	"Admission":              queue.Admission{},
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document describing the API
+func buildOpenAPISpec() ([]byte, error) {
+	schemas := make(map[string]interface{}, len(specSchemas)+1)
+	for name, v := range specSchemas {
+		schemas[name] = schemaFor(reflect.TypeOf(v))
+	}
+	// Stats is a map in Go, so its fixed keys are spelled out here
+	statsProperties := map[string]interface{}{
+		"by_type": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "integer"}},
+		},
+		"by_queue": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "integer"}},
+		},
+		"throughput": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaFor(reflect.TypeOf(queue.Throughput{})),
+		},
+		"avg_wait_seconds": map[string]interface{}{"type": "number"},
+	}
+	for _, status := range task.Statuses {
+		statsProperties[string(status)] = map[string]interface{}{"type": "integer"}
+	}
+	schemas["Stats"] = map[string]interface{}{
+		"type":       "object",
+		"properties": statsProperties,
+	}
+
+	errorResponses := map[string]interface{}{
+		"400": responseRef("Invalid request", "ErrorResponse"),
+		"503": responseRef("Storage unavailable", "ErrorResponse"),
+		"504": responseRef("Request timed out", "ErrorResponse"),
+		"500": responseRef("Internal error", "ErrorResponse"),
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Distributed Task Queue API",
+			"version": "1.0.0",
+			"description": "Describes the v1 response shapes. The same endpoints are served under " +
+				"/api/v2, where every body is wrapped in {data, error, pagination, request_id}.",
+		},
+		"paths": map[string]interface{}{
+			"/api/v1/tasks": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Submit a task",
+					"parameters": []interface{}{
+						queryParam("dry_run", map[string]interface{}{"type": "boolean"}),
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("SubmitTaskRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("Dry run: what would happen to the task, which was not stored", "DryRunResponse"),
+						"201": responseRef("Task accepted", "SubmitTaskResponse"),
+						"401": responseRef("Request signature missing or invalid", "ErrorResponse"),
+						"409": responseRef("A task with this ID already exists", "ErrorResponse"),
+						"422": responseRef("A submit hook refused the task", "ErrorResponse"),
+					}),
+				},
+				"get": map[string]interface{}{
+					"summary": "List tasks",
+					"parameters": []interface{}{
+						queryParam("status", schemaFor(reflect.TypeOf(task.Status("")))),
+						queryParam("limit", map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 100}),
+						queryParam("cursor", map[string]interface{}{"type": "string"}),
+						queryParam("labels", map[string]interface{}{"type": "string", "example": "team=payments,env=prod"}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("Tasks matching the filter", "ListTasksResponse"),
+					}),
+				},
+			},
+			"/api/v1/tasks/batch": map[string]interface{}{
+				"post": operation("Submit a batch of tasks", map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("SubmitBatchRequest"),
+				}, merge(errorResponses, map[string]interface{}{
+					"201": responseRef("Tasks accepted", "SubmitBatchResponse"),
+					"401": responseRef("Request signature missing or invalid", "ErrorResponse"),
+					"409": responseRef("A task with one of these IDs already exists", "ErrorResponse"),
+					"422": responseRef("A submit hook refused one of the tasks", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/tasks/status": map[string]interface{}{
+				"post": operation("Get the status of up to 500 tasks, optionally waiting for one to change", map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("TaskStatusRequest"),
+				}, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("The tasks' statuses, in the order requested", "TaskStatusResponse"),
+				})),
+			},
+			"/api/v1/tasks/{id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "Get a task",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The task, with its place in the queue while pending", "TaskResponse"),
+						"404": responseRef("Task not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/tasks/{id}/boost": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Move a pending task to critical priority, ahead of the queue",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The boosted task", "Task"),
+						"404": responseRef("Task not found", "ErrorResponse"),
+						"409": responseRef("Task is not pending", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/stats": map[string]interface{}{
+				"get": operation("Get queue statistics", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Task counts, throughput and wait time", "Stats"),
+				})),
+			},
+			"/api/v1/stats/timeseries": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get throughput and latency over time",
+					"parameters": []interface{}{
+						queryParam("window", map[string]interface{}{"type": "string", "example": "24h"}),
+						queryParam("step", map[string]interface{}{"type": "string", "example": "15m"}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("One point per step, oldest first", "TimeSeriesResponse"),
+					}),
+				},
+			},
+			"/api/v1/admission": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the load shedding state and suggested delay of each priority class, for producers to throttle themselves by",
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("Admission state by priority class, most urgent first", "Admission"),
+					}),
+				},
+			},
+			"/api/v1/scaling": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the backlog of a queue or task type, for autoscalers such as KEDA",
+					"parameters": []interface{}{
+						queryParam("queue", map[string]interface{}{"type": "string"}),
+						queryParam("type", map[string]interface{}{"type": "string"}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("Pending and processing tasks; value is their sum", "ScalingResponse"),
+					}),
+				},
+			},
+			"/api/v1/stats/errors": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the most frequent errors of failed attempts, by task type",
+					"parameters": []interface{}{
+						queryParam("window", map[string]interface{}{"type": "string", "example": "1h"}),
+						queryParam("limit", map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 100}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("Error signatures by task type, most frequent first", "ErrorStatsResponse"),
+					}),
+				},
+			},
+			"/api/v1/stats/duplicates": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the submissions rejected for repeating a task, by task type and producer",
+					"parameters": []interface{}{
+						queryParam("window", map[string]interface{}{"type": "string", "example": "1h"}),
+						queryParam("limit", map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 100}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("Duplicate counts, most frequent first", "DuplicateStatsResponse"),
+					}),
+				},
+			},
+			"/api/v1/tasks/{id}/logs": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "Get what a task's handler logged, oldest first",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "One log entry per line; empty before the task has run",
+							"content": map[string]interface{}{
+								"application/x-ndjson": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+							},
+						},
+						"404": responseRef("Task not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/tasks/{id}/attempts": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "List a task's attempts, with the failures on each worker",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The task's attempts, oldest first", "AttemptsResponse"),
+						"404": responseRef("Task not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/tasks/{id}/artifacts": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "List the files attached to a task, with download links",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The task's artifacts", "ArtifactsResponse"),
+						"404": responseRef("Task not found, or no artifact store is configured", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/cluster": map[string]interface{}{
+				"get": operation("List the API servers and workers in the cluster", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Live members and election leaders", "ClusterResponse"),
+					"404": responseRef("No cluster registry is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/types": map[string]interface{}{
+				"get": operation("List task types, their defaults and the workers handling them", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Task types, sorted", "TypesResponse"),
+					"404": responseRef("No cluster registry is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/types/{type}/enable": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Let workers run a task type again",
+					"parameters": []interface{}{pathParam("type")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The task type", "TypeInfo"),
+						"404": responseRef("No cluster registry is configured", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/types/{type}/disable": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Hold a task type's tasks back on every worker",
+					"parameters": []interface{}{pathParam("type")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The task type", "TypeInfo"),
+						"404": responseRef("No cluster registry is configured", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/reports": map[string]interface{}{
+				"post": operation("Generate a report of tasks created in a time range", map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("ReportRequest"),
+				}, merge(errorResponses, map[string]interface{}{
+					"202": responseRef("Report queued; fetch it by task ID", "SubmitTaskResponse"),
+				})),
+			},
+			"/api/v1/reports/{id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Download a report",
+					"parameters": []interface{}{
+						pathParam("id"),
+						queryParam("format", map[string]interface{}{"type": "string", "enum": []interface{}{"json", "csv"}}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The report",
+							"content": merge(jsonContent("Report"), map[string]interface{}{
+								"text/csv": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+							}),
+						},
+						"202": responseRef("Report not generated yet", "SubmitTaskResponse"),
+						"404": responseRef("Report not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/admin/export": map[string]interface{}{
+				"get": operation("Export every task record", nil, merge(errorResponses, map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "One task per line",
+						"content":     ndjsonContent("Task"),
+					},
+				})),
+			},
+			"/api/v1/admin/import": map[string]interface{}{
+				"post": operation("Import task records written by an export", map[string]interface{}{
+					"required": true,
+					"content":  ndjsonContent("Task"),
+				}, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Records imported, and skipped because the task exists", "ImportResult"),
+					"413": responseRef("Import too large", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/admin/doctor": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Check storage for inconsistencies, repairing them if asked to",
+					"parameters": []interface{}{
+						queryParam("repair", map[string]interface{}{"type": "boolean"}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("What was found, and repaired", "DoctorReport"),
+					}),
+				},
+			},
+			"/api/v1/admin/log-level": map[string]interface{}{
+				"get": operation("Get the server's log level", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("The log level", "LogLevel"),
+					"404": responseRef("The log level is not adjustable", "ErrorResponse"),
+				})),
+				"put": operation("Change the server's log level", map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("LogLevel"),
+				}, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("The new log level", "LogLevel"),
+					"404": responseRef("The log level is not adjustable", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/templates": map[string]interface{}{
+				"get": operation("List the latest version of every template", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Templates, by name", "TemplatesResponse"),
+					"404": responseRef("No template store is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/templates/{name}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the latest version of a template, or the one given",
+					"parameters": []interface{}{
+						pathParam("name"),
+						queryParam("version", map[string]interface{}{"type": "integer", "minimum": 1}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The template", "Template"),
+						"404": responseRef("Template not found", "ErrorResponse"),
+					}),
+				},
+				"put": map[string]interface{}{
+					"summary":    "Save a new version of a template",
+					"parameters": []interface{}{pathParam("name")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("TemplateRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"201": responseRef("The new version", "Template"),
+						"404": responseRef("No template store is configured", "ErrorResponse"),
+					}),
+				},
+				"delete": map[string]interface{}{
+					"summary":    "Delete every version of a template",
+					"parameters": []interface{}{pathParam("name")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Template deleted"},
+						"404": responseRef("Template not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/templates/{name}/versions": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "List every version of a template, oldest first",
+					"parameters": []interface{}{pathParam("name")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The template's versions", "TemplatesResponse"),
+						"404": responseRef("Template not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/templates/{name}/render": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Preview a template filled in with sample data",
+					"parameters": []interface{}{pathParam("name")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("RenderTemplateRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The rendered template", "RenderedTemplate"),
+						"404": responseRef("Template not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/task-templates": map[string]interface{}{
+				"get": operation("List task templates, by name", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Task templates", "TaskTemplatesResponse"),
+					"404": responseRef("No task template store is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/task-templates/{name}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "Get a task template",
+					"parameters": []interface{}{pathParam("name")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The task template", "NamedTemplate"),
+						"404": responseRef("Task template not found", "ErrorResponse"),
+					}),
+				},
+				"put": map[string]interface{}{
+					"summary":    "Create or replace a task template, which submissions name with template",
+					"parameters": []interface{}{pathParam("name")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("TaskTemplate"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The replaced task template", "NamedTemplate"),
+						"201": responseRef("The new task template", "NamedTemplate"),
+						"404": responseRef("No task template store is configured", "ErrorResponse"),
+					}),
+				},
+				"delete": map[string]interface{}{
+					"summary":    "Delete a task template",
+					"parameters": []interface{}{pathParam("name")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Task template deleted"},
+						"404": responseRef("Task template not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/schedules": map[string]interface{}{
+				"get": operation("List recurring task schedules", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Schedules, oldest first", "SchedulesResponse"),
+					"404": responseRef("No schedule store is configured", "ErrorResponse"),
+				})),
+				"post": operation("Create a recurring task schedule", map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("ScheduleRequest"),
+				}, merge(errorResponses, map[string]interface{}{
+					"201": responseRef("The schedule, with its next run", "Schedule"),
+					"404": responseRef("No schedule store is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/schedules/{id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "Get a schedule",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The schedule", "Schedule"),
+						"404": responseRef("Schedule not found", "ErrorResponse"),
+					}),
+				},
+				"put": map[string]interface{}{
+					"summary":    "Replace a schedule's definition",
+					"parameters": []interface{}{pathParam("id")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("ScheduleRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The schedule, with its next run", "Schedule"),
+						"404": responseRef("Schedule not found", "ErrorResponse"),
+					}),
+				},
+				"delete": map[string]interface{}{
+					"summary":    "Delete a schedule",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Schedule deleted"},
+						"404": responseRef("Schedule not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/schedules/{id}/pause": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Stop a schedule submitting runs",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The schedule", "Schedule"),
+						"404": responseRef("Schedule not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/schedules/{id}/resume": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Resume a paused schedule from its next run",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The schedule, with its next run", "Schedule"),
+						"404": responseRef("Schedule not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/schedules/{id}/backfill": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Submit the runs a schedule missed in a time range",
+					"parameters": []interface{}{pathParam("id")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("BackfillRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The missed runs and the tasks submitted for them", "BackfillResponse"),
+						"404": responseRef("Schedule not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/leases": map[string]interface{}{
+				"post": operation("Lease a task to a remote worker, waiting for one if asked", map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("LeaseRequest"),
+				}, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("The lease and its task", "Lease"),
+					"204": map[string]interface{}{"description": "No task came within the wait"},
+				})),
+			},
+			"/api/v1/leases/{id}/progress": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Report progress on a leased task, extending the lease",
+					"parameters": []interface{}{pathParam("id")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("Progress"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The lease, with its new expiry", "Lease"),
+						"404": responseRef("The lease has ended; give up on the task", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/leases/{id}/ack": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Complete a leased task",
+					"parameters": []interface{}{pathParam("id")},
+					"requestBody": map[string]interface{}{
+						"content": jsonContent("AckRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Task completed"},
+						"404": responseRef("The lease has ended", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/leases/{id}/nack": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Fail the attempt of a leased task, which retries or fails it",
+					"parameters": []interface{}{pathParam("id")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("NackRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Attempt failed"},
+						"404": responseRef("The lease has ended", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/workflows": map[string]interface{}{
+				"get": operation("List workflows", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Workflows, oldest first", "WorkflowsResponse"),
+					"404": responseRef("No workflow engine is configured", "ErrorResponse"),
+				})),
+				"post": operation("Start a workflow, from steps or a named definition", map[string]interface{}{
+					"required": true,
+					"content": merge(jsonContent("WorkflowRequest"), map[string]interface{}{
+						"application/yaml": map[string]interface{}{
+							"schema": map[string]interface{}{"$ref": "#/components/schemas/WorkflowRequest"},
+						},
+					}),
+				}, merge(errorResponses, map[string]interface{}{
+					"201": responseRef("The workflow, with its first steps running", "Workflow"),
+					"404": responseRef("No workflow engine is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/workflows/{id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "Get a workflow and the progress of its steps",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The workflow", "Workflow"),
+						"404": responseRef("Workflow not found", "ErrorResponse"),
+					}),
+				},
+				"delete": map[string]interface{}{
+					"summary":    "Delete a workflow",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Workflow deleted"},
+						"404": responseRef("Workflow not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/workflows/{id}/graph": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get a workflow's DAG with its steps' live statuses and durations",
+					"parameters": []interface{}{
+						pathParam("id"),
+						queryParam("format", map[string]interface{}{"type": "string", "enum": []interface{}{"json", "dot"}}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The graph",
+							"content": merge(jsonContent("WorkflowGraph"), map[string]interface{}{
+								"text/vnd.graphviz": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+							}),
+						},
+						"404": responseRef("Workflow not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/workflows/{id}/cancel": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Cancel a running workflow and its running tasks",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The workflow", "Workflow"),
+						"404": responseRef("Workflow not found", "ErrorResponse"),
+						"409": responseRef("Workflow is not running", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/workflows/{id}/resume": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Run a failed or cancelled workflow again from the steps that did not complete",
+					"parameters": []interface{}{pathParam("id")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The workflow", "Workflow"),
+						"404": responseRef("Workflow not found", "ErrorResponse"),
+						"409": responseRef("Workflow is not failed or cancelled", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/workflow-definitions": map[string]interface{}{
+				"get": operation("List workflow definitions", nil, merge(errorResponses, map[string]interface{}{
+					"200": responseRef("Workflow definitions, by name", "DefinitionsResponse"),
+					"404": responseRef("No workflow engine is configured", "ErrorResponse"),
+				})),
+			},
+			"/api/v1/workflow-definitions/{name}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "Get a workflow definition",
+					"parameters": []interface{}{pathParam("name")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The workflow definition", "Definition"),
+						"404": responseRef("Workflow definition not found", "ErrorResponse"),
+					}),
+				},
+				"put": map[string]interface{}{
+					"summary":    "Create or replace a workflow definition",
+					"parameters": []interface{}{pathParam("name")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": merge(jsonContent("DefinitionRequest"), map[string]interface{}{
+							"application/yaml": map[string]interface{}{
+								"schema": map[string]interface{}{"$ref": "#/components/schemas/DefinitionRequest"},
+							},
+						}),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The workflow definition", "Definition"),
+					}),
+				},
+				"delete": map[string]interface{}{
+					"summary":    "Delete a workflow definition",
+					"parameters": []interface{}{pathParam("name")},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"204": map[string]interface{}{"description": "Workflow definition deleted"},
+						"404": responseRef("Workflow definition not found", "ErrorResponse"),
+					}),
+				},
+			},
+			"/api/v1/workflows/{id}/steps/{step}/approve": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":    "Approve or reject a waiting manual approval step",
+					"parameters": []interface{}{pathParam("id"), pathParam("step")},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("ApprovalRequest"),
+					},
+					"responses": merge(errorResponses, map[string]interface{}{
+						"200": responseRef("The workflow, moved on past the step", "Workflow"),
+						"404": responseRef("Workflow not found", "ErrorResponse"),
+						"409": responseRef("Step is not waiting for approval", "ErrorResponse"),
+					}),
+				},
+			},
+			"/health": map[string]interface{}{
+				"get": operation("Health check", nil, map[string]interface{}{
+					"200": responseRef("Service is healthy", "HealthResponse"),
+				}),
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+
+	return json.Marshal(spec)
+}
+
+// schemaFor derives a JSON schema from a Go type using its json tags
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		return schemaFor(t.Elem())
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]interface{}{"type": "integer", "description": "nanoseconds"}
+	}
+	if t == reflect.TypeOf(queue.TypeConfig{}) || t == reflect.TypeOf(queue.RetryPolicy{}) || t == reflect.TypeOf(schedule.Schedule{}) {
+		// These write their durations as strings such as "30s"
+		schema := kindSchema(t)
+		properties := schema["properties"].(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); field.Type == reflect.TypeOf(time.Duration(0)) {
+				name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+				properties[name] = map[string]interface{}{"type": "string", "example": "30s"}
+			}
+		}
+		return schema
+	}
+	if t == reflect.TypeOf(task.Priority(0)) {
+		return map[string]interface{}{
+			"type":        "integer",
+			"minimum":     task.PriorityMin,
+			"maximum":     task.PriorityMax,
+			"description": "higher runs first; requests may also use low (0), medium (33), high (66) or critical (100), and 1-3 are read as medium, high and critical as they were before the range",
+		}
+	}
+
+	schema := kindSchema(t)
+	if values, ok := enumValues[t]; ok {
+		schema["enum"] = values
+	}
+	return schema
+}
+
+// kindSchema maps a type's underlying kind onto a JSON schema
+func kindSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		schema := map[string]interface{}{"type": "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			schema["additionalProperties"] = schemaFor(t.Elem())
+		}
+		return schema
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous && name == "" {
+				// Embedded structs are flattened into the parent, as
+				// encoding/json does
+				embedded := schemaFor(field.Type)
+				for k, v := range embedded["properties"].(map[string]interface{}) {
+					properties[k] = v
+				}
+				if req, ok := embedded["required"].([]string); ok {
+					required = append(required, req...)
+				}
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaFor(field.Type)
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func operation(summary string, body interface{}, responses map[string]interface{}) map[string]interface{} {
+	op := map[string]interface{}{
+		"summary":   summary,
+		"responses": responses,
+	}
+	if body != nil {
+		op["requestBody"] = body
+	}
+	return op
+}
+
+func jsonContent(schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schema},
+		},
+	}
+}
+
+// ndjsonContent describes a stream of schema values, one JSON document per
+// line
+func ndjsonContent(schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"application/x-ndjson": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schema},
+		},
+	}
+}
+
+func responseRef(description, schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     jsonContent(schema),
+	}
+}
+
+func pathParam(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}
+}
+
+func queryParam(name string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"in":     "query",
+		"schema": schema,
+	}
+}
+
+func merge(maps ...map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, m := range maps {
+		for k, v := range m {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// OverflowPolicy decides what Submit does with a task that would take the
+// queue past a depth limit
+type OverflowPolicy string
+
+const (
+	// OverflowReject fails the submission with errs.ErrQueueFull
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowShed fails the newest pending task of the lowest priority
+	// below the new task's to make room. With nothing lower to shed, or at
+	// a per-priority limit, the submission is rejected.
+	OverflowShed OverflowPolicy = "shed"
+	// OverflowSpill stores the task in DepthLimits.Overflow, from where
+	// the scheduler moves it back once there is room
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// ParseOverflowPolicy parses "reject", "shed" or "spill"
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowReject, OverflowShed, OverflowSpill:
+		return p, nil
+	case "":
+		return OverflowReject, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q (want reject, shed or spill)", s)
+	}
+}
+
+// DepthLimits caps how many tasks may wait in the pending status, so a
+// runaway producer cannot fill the storage backend. Limits are checked
+// before each submission; concurrent submitters can overshoot them
+// slightly.
+type DepthLimits struct {
+	// Total caps pending tasks across all priorities. Zero means no cap.
+	Total int64
+	// PerPriority caps pending tasks of single priorities
+	PerPriority map[task.Priority]int64
+	// PerQueue caps pending tasks of the types declared in single queues,
+	// by TypeConfig.Queue, with DefaultQueue for types that name none.
+	// Like PerPriority limits, they are never made room for by shedding.
+	PerQueue map[string]int64
+	// Policy decides what happens at a limit, defaults to OverflowReject
+	Policy OverflowPolicy
+	// Overflow stores spilled tasks, and is required by OverflowSpill
+	Overflow storage.Storage
+}
+
+// enabled reports whether any limit is set
+func (d DepthLimits) enabled() bool {
+	return d.Total > 0 || len(d.PerPriority) > 0 || len(d.PerQueue) > 0
+}
+
+// errNoRoom is returned by room when a task does not fit
+var errNoRoom = errors.New("no room")
+
+// room checks whether task t fits under the limits. It returns whether
+// the total limit, rather than a per-priority or per-queue one, is the one
+// in the way, and the pending counts by priority it checked.
+func (q *Queue) room(ctx context.Context, t *task.Task) (bool, map[task.Priority]int64, error) {
+	counts, err := q.storage.CountTasksByPriority(ctx, task.StatusPending)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to check queue depth: %w", err)
+	}
+
+	if limit, ok := q.depth.PerPriority[t.Priority]; ok && counts[t.Priority] >= limit {
+		return false, counts, errNoRoom
+	}
+	if name := q.queueOf(t.Type); q.depth.PerQueue[name] > 0 {
+		types, err := q.storage.CountTasksByType(ctx, task.StatusPending)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to check queue depth: %w", err)
+		}
+		if q.byQueue(types)[name] >= q.depth.PerQueue[name] {
+			return false, counts, errNoRoom
+		}
+	}
+	if q.depth.Total > 0 {
+		var total int64
+		for _, n := range counts {
+			total += n
+		}
+		if total >= q.depth.Total {
+			return true, counts, errNoRoom
+		}
+	}
+	return false, counts, nil
+}
+
+// admit applies the depth limits to a task about to be queued. It returns
+// true if the task was spilled to overflow storage instead.
+func (q *Queue) admit(ctx context.Context, t *task.Task) (bool, error) {
+	atTotal, counts, err := q.room(ctx, t)
+	if !errors.Is(err, errNoRoom) {
+		return false, err
+	}
+
+	priority := t.Priority.Label()
+	metrics.TasksOverflowed.WithLabelValues(q.queueOf(t.Type), priority, string(q.depth.Policy)).Inc()
+	logger := q.logger.With(
+		zap.String("id", t.ID),
+		zap.String("type", t.Type),
+		zap.String("queue", q.queueOf(t.Type)),
+		zap.Int("priority", int(t.Priority)),
+	)
+
+	switch q.depth.Policy {
+	case OverflowSpill:
+		if err := q.depth.Overflow.SaveTask(ctx, t); err != nil {
+			return false, fmt.Errorf("failed to spill task: %w", err)
+		}
+		logger.Info("queue full, task spilled to overflow storage")
+		return true, nil
+
+	case OverflowShed:
+		if atTotal {
+			shed, err := q.shed(ctx, t.Priority, counts)
+			if err != nil {
+				return false, err
+			}
+			if shed {
+				return false, nil
+			}
+		}
+	}
+
+	logger.Warn("queue full, task rejected")
+	return false, errs.ErrQueueFull
+}
+
+// shed fails the newest pending task of the lowest priority below p,
+// reporting whether there was one
+func (q *Queue) shed(ctx context.Context, p task.Priority, counts map[task.Priority]int64) (bool, error) {
+	for lower := task.PriorityMin; lower < p; lower++ {
+		if counts[lower] == 0 {
+			continue
+		}
+		victims, err := q.storage.GetTasksByPriority(ctx, task.StatusPending, lower, 1)
+		if err != nil {
+			return false, fmt.Errorf("failed to find a task to shed: %w", err)
+		}
+		if len(victims) == 0 {
+			continue
+		}
+
+		victim := victims[0]
+		victim.MarkFailedAt(fmt.Errorf("shed: queue full, dropped for higher priority work"), q.clock.Now())
+		if err := q.storage.UpdateTask(ctx, victim); err != nil {
+			return false, fmt.Errorf("failed to shed task: %w", err)
+		}
+		metrics.QueueSize.WithLabelValues(victim.Priority.Label()).Dec()
+		metrics.TasksProcessed.WithLabelValues(victim.Type, "shed").Inc()
+		q.observeLabels(victim, "failed")
+		q.logger.Warn("queue full, shed lower priority task",
+			zap.String("id", victim.ID),
+			zap.String("type", victim.Type),
+			zap.Int("priority", int(victim.Priority)),
+		)
+		q.notify(ctx, onFailure, victim, q.logger)
+		q.notify(ctx, onFinish, victim, q.logger)
+		return true, nil
+	}
+	return false, nil
+}
+
+// refillFromOverflow moves spilled tasks back, oldest first, while there
+// is room for them
+func (q *Queue) refillFromOverflow(ctx context.Context) {
+	if q.depth.Policy != OverflowSpill {
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		t, err := q.depth.Overflow.OldestTask(ctx, task.StatusPending)
+		if err != nil {
+			q.logger.Error("failed to poll overflow storage", zap.Error(err))
+			return
+		}
+		if t == nil {
+			return
+		}
+		if _, _, err := q.room(ctx, t); err != nil {
+			if !errors.Is(err, errNoRoom) {
+				q.logger.Error("failed to refill from overflow storage", zap.Error(err))
+			}
+			return
+		}
+
+		if err := q.storage.SaveTask(ctx, t); err != nil {
+			q.logger.Error("failed to move spilled task back", zap.String("id", t.ID), zap.Error(err))
+			return
+		}
+		if err := q.depth.Overflow.DeleteTask(ctx, t.ID); err != nil {
+			q.logger.Error("failed to remove spilled task", zap.String("id", t.ID), zap.Error(err))
+			return
+		}
+		if err := q.enqueue(ctx, t); err != nil {
+			q.logger.Error("failed to enqueue spilled task", zap.String("id", t.ID), zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// pollPartition is this process's share of the status index shards: the
+// shards whose number modulo count is index
+type pollPartition struct {
+	index, count int
+}
+
+// SetPollPartition makes the poller read only this process's share of the
+// pending and retrying index shards, so pollers on count processes each
+// read their own shards rather than all racing for the same tasks. Pass
+// this process's position among the polling processes and their number;
+// a count below 2 polls every shard again. It has no effect, and says so
+// in a warning, unless Storage, or the storage it wraps, is a
+// storage.ShardReader with more than one shard.
+//
+// When its own shards are empty, the poller reads every shard, so idle
+// processes help with a backlog elsewhere.
+func (q *Queue) SetPollPartition(index, count int) {
+	p := pollPartition{index: index, count: count}
+	if count < 2 || index < 0 || index >= count {
+		p = pollPartition{}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if p != q.partition {
+		q.logger.Info("poll partition changed", zap.Int("index", p.index), zap.Int("count", p.count))
+		if p.count >= 2 && (q.shardReader == nil || q.shardReader.StatusShards() < 2) {
+			q.logger.Warn("poll partition has no effect: storage has no status index shards to split; every process polls every task",
+				zap.Int("index", p.index),
+				zap.Int("count", p.count),
+			)
+		}
+	}
+	q.partition = p
+}
+
+// ownShards returns the status index shards this process polls, or nil to
+// poll them all
+func (q *Queue) ownShards() []int {
+	if q.shardReader == nil {
+		return nil
+	}
+	q.mu.RLock()
+	p := q.partition
+	q.mu.RUnlock()
+	shards := q.shardReader.StatusShards()
+	if p.count < 2 || shards < 2 {
+		return nil
+	}
+
+	var own []int
+	for s := p.index; s < shards; s += p.count {
+		own = append(own, s)
+	}
+	if own == nil {
+		// More processes than shards: share one
+		own = []int{p.index % shards}
+	}
+	return own
+}
+
+// pollStatus reads up to limit tasks with status for the poller, from
+// this process's shards first
+func (q *Queue) pollStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	shards := q.ownShards()
+	if shards == nil {
+		return q.storage.GetTasksByStatus(ctx, status, limit)
+	}
+	start := time.Now()
+	tasks, err := q.shardReader.GetTasksByShards(ctx, status, shards, limit)
+	metrics.StorageLatency.WithLabelValues("poll").Observe(time.Since(start).Seconds())
+	if err != nil || len(tasks) > 0 {
+		return tasks, err
+	}
+	return q.storage.GetTasksByStatus(ctx, status, limit)
+}
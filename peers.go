@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// wakeChannel is the Redis pub/sub channel workers wake each other on
+const wakeChannel = "cluster:workers:wake"
+
+// RedisPeers wakes the pollers of other worker processes over Redis
+// pub/sub. Notifications are not stored: a worker not listening at the
+// time finds the tasks at its next poll anyway.
+type RedisPeers struct {
+	client *redis.Client
+	id     string
+}
+
+// NewRedisPeers creates peers on client for the worker id, whose own
+// notifications it does not hear
+func NewRedisPeers(client *redis.Client, id string) *RedisPeers {
+	return &RedisPeers{client: client, id: id}
+}
+
+// Notify tells the other workers tasks are waiting in storage
+func (p *RedisPeers) Notify(ctx context.Context) error {
+	if err := p.client.Publish(ctx, wakeChannel, p.id).Err(); err != nil {
+		return fmt.Errorf("failed to notify peers: %w", err)
+	}
+	return nil
+}
+
+// Listen calls wake each time another worker notifies, until ctx is done
+func (p *RedisPeers) Listen(ctx context.Context, wake func()) {
+	sub := p.client.Subscribe(ctx, wakeChannel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			if msg.Payload != p.id {
+				wake()
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// errHandlerPanicked is wrapped by the error of an attempt whose handler
+// panicked
+var errHandlerPanicked = errors.New("handler panicked")
+
+// strike records how an attempt of t ended, reporting whether t has now
+// panicked or timed out on enough attempts in a row to be poison
+func (q *Queue) strike(t *task.Task, err error) bool {
+	if q.poisonThreshold <= 0 {
+		return false
+	}
+	if !errors.Is(err, errHandlerPanicked) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Strikes = 0
+		return false
+	}
+	t.Strikes++
+	return t.Strikes >= q.poisonThreshold
+}
+
+// quarantine fails a poison task for good, whatever retries it has left,
+// labelled so it can be found among the failed tasks
+func (q *Queue) quarantine(ctx context.Context, t *task.Task, err error, logger *zap.Logger) {
+	if t.Labels == nil {
+		t.Labels = make(map[string]string)
+	}
+	t.Labels[task.PoisonLabel] = "true"
+	metrics.PoisonTasks.WithLabelValues(t.Type).Inc()
+	logger.Error("poison task quarantined", zap.Int("strikes", t.Strikes), zap.Error(err))
+	q.fail(ctx, t, fmt.Errorf("quarantined as poison after %d attempts panicked or timed out: %w", t.Strikes, err), logger)
+}
@@ -0,0 +1,35 @@
+package queue
+
+import "time"
+
+// polling holds the poller's batch sizes and adaptive interval bounds
+type polling struct {
+	batchSize      int
+	retryBatchSize int
+
+	adaptive    bool
+	minInterval time.Duration
+	maxInterval time.Duration
+}
+
+// next returns the poll interval to use after a poll that fetched fetched
+// pending tasks at interval. A full batch suggests more are waiting, so the
+// poller speeds up; an empty one lets it back off.
+func (p polling) next(interval time.Duration, fetched int) time.Duration {
+	if !p.adaptive {
+		return interval
+	}
+	switch {
+	case fetched >= p.batchSize:
+		interval /= 2
+		if interval < p.minInterval {
+			interval = p.minInterval
+		}
+	case fetched == 0:
+		interval *= 2
+		if interval > p.maxInterval {
+			interval = p.maxInterval
+		}
+	}
+	return interval
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// rateWindow is how far back finish rates used for wait estimates look
+const rateWindow = 5 * time.Minute
+
+// Position is where a pending task stands in the queue
+type Position struct {
+	// Ahead counts the pending tasks that will be dispatched first
+	Ahead int64
+	// EstimatedStart is when the task should start, from Ahead and the
+	// rate tasks finished over the last five minutes. It is zero when none
+	// finished then.
+	EstimatedStart time.Time
+}
+
+// Position returns where the pending task id stands in the queue. It
+// returns errs.ErrTaskNotFound once the task is no longer pending.
+func (q *Queue) Position(ctx context.Context, id string) (Position, error) {
+	ahead, err := q.storage.RankTask(ctx, task.StatusPending, id)
+	if err != nil {
+		return Position{}, err
+	}
+
+	now := q.clock.Now()
+	pos := Position{Ahead: ahead}
+	if wait, ok, err := q.estimateWait(ctx, ahead, now); err != nil {
+		return Position{}, err
+	} else if ok {
+		pos.EstimatedStart = now.Add(wait)
+	}
+	return pos, nil
+}
+
+// estimateWait returns how long it takes to get through ahead tasks at the
+// rate tasks finished over the last rateWindow, or false if none did
+func (q *Queue) estimateWait(ctx context.Context, ahead int64, now time.Time) (time.Duration, bool, error) {
+	minutes, err := q.storage.GetMinuteStats(ctx, now.Add(-rateWindow), now)
+	if err != nil {
+		return 0, false, err
+	}
+	var finished int64
+	for _, m := range minutes {
+		finished += m.Completed + m.Failed
+	}
+	if finished == 0 {
+		return 0, false, nil
+	}
+	perTask := rateWindow / time.Duration(finished)
+	return time.Duration(ahead) * perTask, true, nil
+}
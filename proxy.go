@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls cross-origin access for browser clients
+type CORSConfig struct {
+	// AllowedOrigins lists origins allowed to call the API. Use "*" for any
+	// origin, or a leading wildcard such as "https://*.example.com" for
+	// subdomains. Empty disables CORS.
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, POST, PUT, PATCH, DELETE and OPTIONS
+	AllowedMethods []string
+	// AllowedHeaders defaults to echoing the headers the browser asks for
+	AllowedHeaders []string
+	// AllowCredentials allows cookies and Authorization headers
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// exposedHeaders are response headers browser clients may read
+const exposedHeaders = "X-Request-ID, X-Correlation-ID, Retry-After, X-Admission-State, X-Suggested-Delay"
+
+// allows reports whether origin matches the configured origins
+func (c CORSConfig) allows(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		switch {
+		case allowed == "*" || allowed == origin:
+			return true
+		case strings.Contains(allowed, "://*."):
+			scheme, suffix, _ := strings.Cut(allowed, "://*")
+			if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cors answers preflight requests and adds CORS headers for allowed origins
+func (s *Server) cors(cfg CORSConfig) func(http.Handler) http.Handler {
+	methods := "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	if len(cfg.AllowedMethods) > 0 {
+		methods = strings.Join(cfg.AllowedMethods, ", ")
+	}
+	wildcard := len(cfg.AllowedOrigins) == 1 && cfg.AllowedOrigins[0] == "*" && !cfg.AllowCredentials
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+
+			if !cfg.allows(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if wildcard {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if len(cfg.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+type prefixKey struct{}
+
+// forwardedHeaders applies X-Forwarded-Proto, X-Forwarded-Host and
+// X-Forwarded-Prefix set by a trusted reverse proxy, so the request looks
+// the way the client addressed it. X-Forwarded-For and X-Real-IP are
+// handled by RealIP, which is installed along with it.
+func forwardedHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+		if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+			r.Host = host
+		}
+		if prefix := firstValue(r.Header.Get("X-Forwarded-Prefix")); prefix != "" {
+			prefix = "/" + strings.Trim(prefix, "/")
+			r = r.WithContext(context.WithValue(r.Context(), prefixKey{}, prefix))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// firstValue returns the first entry of a comma separated header value,
+// which is the one added by the proxy closest to the client
+func firstValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(first)
+}
+
+// externalPath returns the path clients use to reach path on this server,
+// including any prefix stripped by a proxy and the configured base path
+func (s *Server) externalPath(r *http.Request, path string) string {
+	prefix, _ := r.Context().Value(prefixKey{}).(string)
+	return prefix + s.config.BasePath + path
+}
+
+// normalizeBasePath returns p with a single leading slash and no trailing one
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
@@ -2,11 +2,17 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/yourusername/distributed-task-queue/internal/artifact"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/idempotency"
 	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
 	"github.com/yourusername/distributed-task-queue/internal/storage"
 	"github.com/yourusername/distributed-task-queue/internal/task"
 	"go.uber.org/zap"
@@ -18,11 +24,177 @@ type Queue struct {
 	logger   *zap.Logger
 	handlers map[string]TaskHandler
 	mu       sync.RWMutex
+
+	// lifecycles holds the handlers registered with Register, and
+	// initialized whether each one's Init succeeded, both guarded by mu
+	lifecycles  map[string]Handler
+	initialized map[string]bool
+	closeOnce   sync.Once
+
+	// healthChecks check handlers every handlerHealthInterval, and
+	// unhealthy holds the error of each failing one, both guarded by mu
+	healthChecks          map[string]HealthCheck
+	unhealthy             map[string]string
+	handlerHealthInterval time.Duration
+
+	// migrations upgrade payloads, by task type and version upgraded from
+	migrations map[string]map[int]PayloadMigrator
 	
-	// Channels for task distribution
+	// Channels for task distribution, one per priority class
 	taskChannels map[task.Priority]chan *task.Task
 	stopChan     chan struct{}
+	stopOnce     sync.Once
 	wg           sync.WaitGroup
+
+	// workers holds a quit channel per running worker and workerCtx the
+	// context passed to Start, so SetWorkers can resize the pool; both
+	// guarded by workersMu
+	workersMu    sync.Mutex
+	workers      []chan struct{}
+	workerCtx    context.Context
+	nextWorkerID int
+	autoPrefetch bool
+
+	// metricLabels are the task label keys exported as metrics
+	metricLabels []string
+	taskTimeout  time.Duration
+	inheritance  PriorityInheritance
+	windows      map[string]Window
+	// types holds per-type defaults and limiters their rate limits, both
+	// guarded by mu
+	types        map[string]TypeConfig
+	limiters     map[string]*typeLimiter
+	onExpired    func(ctx context.Context, t *task.Task)
+	clock        Clock
+	pollInterval time.Duration
+	inProcess    bool
+
+	// signingKeys sign submitted tasks and verify them before they run
+	signingKeys *signing.Keys
+
+	// scheduler decides whether this instance expires and releases tasks
+	scheduler Leadership
+
+	// unsupportedVersionDelay holds back tasks too new for this worker
+	unsupportedVersionDelay time.Duration
+
+	// disabled holds the task types workers hold back, guarded by mu;
+	// disabledTypeDelay is how long each time
+	disabled          map[string]bool
+	disabledTypeDelay time.Duration
+
+	// taskLogBytes caps the handler logs kept with each task
+	taskLogBytes int
+
+	// cancelCheckInterval is how often running tasks are checked for
+	// having been cancelled
+	cancelCheckInterval time.Duration
+
+	// artifacts stores the files handlers attach with artifact.Attach
+	artifacts artifact.Store
+
+	// idempotency records the side effects handlers perform with
+	// idempotency.Once
+	idempotency idempotency.Store
+
+	// untracked holds fire-and-forget tasks, when storage supports them
+	untracked storage.UntrackedQueue
+
+	// depth caps how many tasks may be pending
+	depth DepthLimits
+	// admission caches the counts producers' admission states come from
+	admission admissionCache
+
+	// retryBudget caps retries at a share of new tasks
+	retryBudget RetryBudget
+
+	// poisonThreshold is how many attempts in a row may panic or time out
+	// before a task is quarantined; zero turns detection off
+	poisonThreshold int
+
+	// memory admits tasks by their type's memory hint; nil without a limit
+	memory *budgetGuard
+
+	// weight admits tasks by their weight; nil without a budget
+	weight *budgetGuard
+
+	// leakGrace is how long a handler may keep running after its context
+	// is cancelled before it is reported as leaked; zero turns it off
+	leakGrace time.Duration
+
+	// slowFactor times a type's p95, over the runs kept in runTimes, is how
+	// long an attempt runs before it is reported as slow; zero turns it off
+	slowFactor  float64
+	slowMinRuns int
+	runTimes    runTimes
+
+	// submitHooks vet tasks before they are stored, guarded by mu
+	submitHooks []SubmitHook
+
+	// lifecycle holds the OnComplete, OnFailure and OnRetry callbacks,
+	// guarded by mu
+	lifecycle lifecycle
+
+	// batchers collect tasks for batch handlers, by task type, guarded by mu
+	batchers map[string]*batcher
+
+	// prefetch caps the tasks waiting on the channels for a worker;
+	// buffered holds when each was put there, by ID, claimed the IDs of
+	// tasks workers took but may not have marked started yet, and wake
+	// asks the poller for more
+	prefetch   int
+	bufferedMu sync.Mutex
+	buffered   map[string]time.Time
+	claimed    map[string]struct{}
+	wake       chan struct{}
+
+	// peers wakes other workers for the tasks handed back at shutdown
+	peers Peers
+
+	// health pauses dispatch while storage is unreachable
+	health *storageHealth
+
+	// polling sizes the poller's batches and adapts its interval
+	polling polling
+
+	// fairness interleaves polled tasks across their fairness keys
+	fairness fairness
+
+	// resourceTags are what this process offers tasks that require tags
+	resourceTags map[string]string
+
+	// instanceID names this process on the tasks it starts
+	instanceID string
+
+	// shardReader reads single status index shards, if storage is sharded;
+	// partition is the share of them this process polls, guarded by mu
+	shardReader storage.ShardReader
+	partition   pollPartition
+
+	// indexChecker finds and repairs storage indexes out of step with the
+	// task records, if storage can fall out of step
+	indexChecker storage.IndexChecker
+
+	// liveness tells a stalled poller from an idle one
+	liveness pollerLiveness
+
+	// activity records what each worker is running, for DebugState
+	activity activity
+
+	// submitted caches the metrics Submit updates
+	submitted submitCounters
+
+	// exec hands the tasks dispatchers take off the channels to workers,
+	// through a queue per task type; dispatchers is how many dispatchers
+	// Start runs
+	exec        *executor
+	dispatchers int
+
+	// remote hands the tasks of types registered with RegisterRemote to
+	// remote workers, which lose a lease after leaseTimeout without a
+	// progress report
+	remote       *remoteBroker
+	leaseTimeout time.Duration
 }
 
 // TaskHandler is a function that processes a task
@@ -35,8 +207,207 @@ type Config struct {
 	MaxWorkers      int
 	PollInterval    time.Duration
 	TaskTimeout     time.Duration
+
+	// MetricLabels lists task label keys, such as "team", whose values are
+	// exported in the tasks_by_label_total metric. Other labels are left
+	// out to keep metric cardinality bounded.
+	MetricLabels []string
+
+	// PriorityInheritance decides the priority of tasks submitted with
+	// SubmitChild. The default raises children to their parent's priority.
+	PriorityInheritance PriorityInheritance
+
+	// Types declares defaults per task type; see TypeConfig. Validate
+	// them, or load them with LoadTypeConfigs.
+	Types map[string]TypeConfig
+
+	// ExecutionWindows limits task types to daily UTC windows. Tasks
+	// arriving outside their window wait in the scheduled status.
+	ExecutionWindows map[string]Window
+
+	// OnExpired, if set, is called after a task misses its deadline
+	OnExpired func(ctx context.Context, t *task.Task)
+
+	// InProcess runs the queue as a plain prioritized worker pool: Submit
+	// hands tasks straight to workers and nothing is stored, so Storage is
+	// ignored and queued tasks are lost if the process exits.
+	InProcess bool
+
+	// BufferSize is how many tasks per priority class may wait for a worker in
+	// InProcess mode before Submit blocks. Zero makes Submit wait until a
+	// worker takes the task.
+	BufferSize int
+
+	// Clock decides when deadlines pass, windows open and retries are due.
+	// Defaults to the system clock; tests can supply a fake one.
+	Clock Clock
+
+	// SigningKeys, if set, signs every submitted task and fails tasks whose
+	// signature does not verify before their handler runs, so a task
+	// written into storage by anything without the keys is never executed.
+	// Producers and workers must share the keys.
+	SigningKeys *signing.Keys
+
+	// Scheduler, if set, limits expiring overdue tasks and releasing due
+	// scheduled ones to the instance it reports as leader, so that work
+	// happens once across the cluster rather than on every poller. An
+	// *election.Elector for the "scheduler" role fits.
+	Scheduler Leadership
+
+	// UnsupportedVersionDelay is how long a task with a payload version
+	// newer than this worker understands waits before it is offered again,
+	// giving an upgraded worker the chance to take it during a rolling
+	// deploy. Defaults to 30s.
+	UnsupportedVersionDelay time.Duration
+
+	// DisabledTypeDelay is how long a task of a type disabled with
+	// SetDisabledTypes waits before it is offered again. Defaults to 30s.
+	DisabledTypeDelay time.Duration
+
+	// Artifacts, if set, is where handlers' artifact.Attach calls upload
+	// files, such as exports, for callers to download
+	Artifacts artifact.Store
+
+	// Idempotency records the side effects handlers perform with
+	// idempotency.Once. Share one across workers, such as an
+	// idempotency.RedisStore; defaults to an idempotency.MemoryStore, which
+	// only remembers this process's effects.
+	Idempotency idempotency.Store
+
+	// TaskLogBytes is how much of what handlers log through
+	// task.LoggerFromContext is kept with each task, across its attempts,
+	// for TaskLogs. Defaults to 16 KiB, or off in InProcess mode; negative
+	// turns capture off.
+	TaskLogBytes int
+
+	// CancelCheckInterval is how often a running task is checked for
+	// having been cancelled, when its handler's context is cancelled.
+	// Defaults to 5s, or off in InProcess mode; negative turns it off.
+	CancelCheckInterval time.Duration
+
+	// DepthLimits caps the number of pending tasks, overall or per
+	// priority, and decides what happens to submissions over the cap.
+	// Ignored in InProcess mode, where BufferSize bounds the queue.
+	DepthLimits DepthLimits
+
+	// RetryBudget caps retries across the cluster at a share of new tasks
+	// submitted. Off unless its Ratio is set; ignored in InProcess mode.
+	RetryBudget RetryBudget
+
+	// PoisonThreshold is how many attempts in a row may panic or time out
+	// before the task is quarantined as poison: failed for good, whatever
+	// retries it has left, and labelled task.PoisonLabel=true. Defaults to
+	// 3; negative turns detection off.
+	PoisonThreshold int
+
+	// InstanceID, if set, names this process on the tasks it starts, as
+	// task.Task.Instance, so Doctor can tell which ones it was running
+	// when it died. Use the ID the process heartbeats with.
+	InstanceID string
+
+	// Peers, if set, wakes the other workers when this one stops, so the
+	// prefetched tasks it hands back are picked up straight away
+	Peers Peers
+
+	// MemoryLimitMB caps the memory, in MiB, the running tasks of this
+	// process may use, going by their types' TypeConfig.MemoryMB hints.
+	// Workers wait for memory to free up before starting a task over it.
+	// Zero means no limit.
+	MemoryLimitMB int
+
+	// WeightBudget caps the summed weight of the tasks running in this
+	// process, going by their task.Task.Weight, else their type's
+	// TypeConfig.Weight, else 1. Workers wait for running tasks to finish
+	// before starting one over it, so a few heavy tasks never run side by
+	// side while light ones still fill the gaps. Zero means no budget.
+	WeightBudget int
+
+	// LeakGrace is how long a handler may keep running after its context
+	// is cancelled, such as by its timeout, before it is reported as
+	// leaked. Defaults to 10s; negative turns detection off.
+	LeakGrace time.Duration
+
+	// SlowTaskFactor reports attempts still running after this many times
+	// the p95 run time of their type's latest completed attempts in this
+	// process, such as 3, well before their timeout. Zero turns it off.
+	SlowTaskFactor float64
+	// SlowTaskMinRuns is how many completed attempts of a type are needed
+	// before its attempts are checked, defaults to 20
+	SlowTaskMinRuns int
+
+	// Dispatchers is how many goroutines take tasks off the priority
+	// channels for the workers, defaults to 2. Workers only run tasks, so
+	// how many tasks are fetched at once does not grow with them.
+	Dispatchers int
+
+	// Prefetch is how many tasks, across all priorities, this process
+	// pulls from storage ahead of its workers. Tasks beyond it stay in
+	// storage for other workers. Defaults to the number of workers passed
+	// to Start. Ignored in InProcess mode.
+	Prefetch int
+
+	// OutageThreshold is how many storage calls in a row must fail before
+	// the queue pauses dispatch, defaults to 3. While paused, workers take
+	// no tasks and the poller retries storage with exponential backoff,
+	// starting at PollInterval, until a call succeeds.
+	OutageThreshold int
+
+	// OutageMaxBackoff caps the wait between storage retries while
+	// paused, defaults to 30s
+	OutageMaxBackoff time.Duration
+
+	// PollBatchSize is how many pending tasks each poll fetches, defaults
+	// to 50. RetryPollBatchSize is the same for retrying tasks, defaults
+	// to 20.
+	PollBatchSize      int
+	RetryPollBatchSize int
+
+	// AdaptivePolling halves the poll interval, down to MinPollInterval,
+	// each time a poll comes back full, and doubles it, up to
+	// MaxPollInterval, each time one comes back empty. The interval starts
+	// at PollInterval; the bounds default to a tenth and ten times it.
+	AdaptivePolling bool
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+
+	// FairnessWindow turns on fairness between task.FairnessKey values,
+	// such as customer IDs: each poll reads at least this many pending
+	// tasks and offers those of each priority class to workers round-robin
+	// across their keys, so one key's backlog cannot keep the others
+	// waiting. Zero offers tasks in plain priority order.
+	FairnessWindow int
+
+	// ResourceTags are what this process advertises to tasks that require
+	// tags, such as gpu=true or region=eu. The poller leaves tasks whose
+	// task.Task.Requires are not all among them in storage for workers
+	// that have them. Ignored in InProcess mode.
+	ResourceTags map[string]string
+
+	// HandlerHealthInterval is how often handlers' health checks run; see
+	// RegisterHealthCheck. Defaults to 15s; negative turns them off.
+	HandlerHealthInterval time.Duration
+
+	// LeaseTimeout is how long a remote worker keeps a task it leased
+	// without reporting progress before the attempt fails and the task is
+	// retried; see RegisterRemote. Defaults to 30s.
+	LeaseTimeout time.Duration
+}
+
+// Leadership reports whether this instance leads a cluster-wide role
+type Leadership interface {
+	IsLeader() bool
 }
 
+// Clock tells the queue the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
 // NewQueue creates a new task queue
 func NewQueue(cfg Config) *Queue {
 	if cfg.Logger == nil {
@@ -48,18 +419,166 @@ func NewQueue(cfg Config) *Queue {
 	if cfg.TaskTimeout == 0 {
 		cfg.TaskTimeout = 5 * time.Minute
 	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	if cfg.UnsupportedVersionDelay == 0 {
+		cfg.UnsupportedVersionDelay = 30 * time.Second
+	}
+	if cfg.DisabledTypeDelay == 0 {
+		cfg.DisabledTypeDelay = 30 * time.Second
+	}
+	if cfg.Idempotency == nil {
+		cfg.Idempotency = idempotency.NewMemoryStore()
+	}
+	if cfg.TaskLogBytes == 0 && !cfg.InProcess {
+		// In-process tasks are not stored, so neither are their logs
+		cfg.TaskLogBytes = 16 * 1024
+	}
+	if cfg.CancelCheckInterval == 0 && !cfg.InProcess {
+		cfg.CancelCheckInterval = 5 * time.Second
+	}
+	if cfg.PollBatchSize == 0 {
+		cfg.PollBatchSize = 50
+	}
+	if cfg.RetryPollBatchSize == 0 {
+		cfg.RetryPollBatchSize = 20
+	}
+	if cfg.MinPollInterval == 0 {
+		cfg.MinPollInterval = cfg.PollInterval / 10
+	}
+	if cfg.MaxPollInterval == 0 {
+		cfg.MaxPollInterval = cfg.PollInterval * 10
+	}
+	if cfg.OutageThreshold == 0 {
+		cfg.OutageThreshold = 3
+	}
+	if cfg.OutageMaxBackoff == 0 {
+		cfg.OutageMaxBackoff = 30 * time.Second
+	}
+	if cfg.DepthLimits.Policy == "" {
+		cfg.DepthLimits.Policy = OverflowReject
+	}
+	if cfg.DepthLimits.Policy == OverflowSpill && cfg.DepthLimits.Overflow == nil {
+		cfg.Logger.Error("spill overflow policy needs overflow storage, rejecting instead")
+		cfg.DepthLimits.Policy = OverflowReject
+	}
+	if cfg.PoisonThreshold == 0 {
+		cfg.PoisonThreshold = 3
+	}
+	if cfg.LeakGrace == 0 {
+		cfg.LeakGrace = 10 * time.Second
+	}
+	if cfg.HandlerHealthInterval == 0 {
+		cfg.HandlerHealthInterval = 15 * time.Second
+	}
+	if cfg.LeaseTimeout == 0 {
+		cfg.LeaseTimeout = 30 * time.Second
+	}
+	if cfg.SlowTaskMinRuns == 0 {
+		cfg.SlowTaskMinRuns = 20
+	}
+	if cfg.RetryBudget.MinRetries == 0 {
+		cfg.RetryBudget.MinRetries = 10
+	}
+	if cfg.RetryBudget.Window < time.Minute {
+		cfg.RetryBudget.Window = 5 * time.Minute
+	}
+	if cfg.RetryBudget.Action == "" {
+		cfg.RetryBudget.Action = BudgetDelay
+	}
+	if cfg.RetryBudget.Delay == 0 {
+		cfg.RetryBudget.Delay = cfg.RetryBudget.Window
+	}
+	buffer := 100
+	if cfg.InProcess {
+		cfg.Storage = discardStorage{}
+		buffer = cfg.BufferSize
+	}
 
 	q := &Queue{
-		storage:  cfg.Storage,
-		logger:   cfg.Logger,
-		handlers: make(map[string]TaskHandler),
-		taskChannels: map[task.Priority]chan *task.Task{
-			task.PriorityCritical: make(chan *task.Task, 100),
-			task.PriorityHigh:     make(chan *task.Task, 100),
-			task.PriorityMedium:   make(chan *task.Task, 100),
-			task.PriorityLow:      make(chan *task.Task, 100),
+		storage:      cfg.Storage,
+		logger:       cfg.Logger,
+		handlers:     make(map[string]TaskHandler),
+		lifecycles:   make(map[string]Handler),
+		initialized:  make(map[string]bool),
+		healthChecks: make(map[string]HealthCheck),
+		unhealthy:    make(map[string]string),
+		migrations:   make(map[string]map[int]PayloadMigrator),
+		batchers:     make(map[string]*batcher),
+		buffered:     make(map[string]time.Time),
+		claimed:      make(map[string]struct{}),
+		wake:         make(chan struct{}, 1),
+		taskChannels: make(map[task.Priority]chan *task.Task, len(task.Classes)),
+		stopChan:     make(chan struct{}),
+		metricLabels: cfg.MetricLabels,
+		taskTimeout:  cfg.TaskTimeout,
+		inheritance:  cfg.PriorityInheritance,
+		windows:      cfg.ExecutionWindows,
+		types:        make(map[string]TypeConfig, len(cfg.Types)),
+		limiters:     make(map[string]*typeLimiter),
+		onExpired:    cfg.OnExpired,
+		clock:        cfg.Clock,
+		pollInterval: cfg.PollInterval,
+		inProcess:    cfg.InProcess,
+		signingKeys:  cfg.SigningKeys,
+		scheduler:    cfg.Scheduler,
+
+		unsupportedVersionDelay: cfg.UnsupportedVersionDelay,
+		disabled:                make(map[string]bool),
+		disabledTypeDelay:       cfg.DisabledTypeDelay,
+		taskLogBytes:            cfg.TaskLogBytes,
+		cancelCheckInterval:     cfg.CancelCheckInterval,
+		artifacts:               cfg.Artifacts,
+		idempotency:             cfg.Idempotency,
+		depth:                   cfg.DepthLimits,
+		retryBudget:             cfg.RetryBudget,
+		poisonThreshold:         cfg.PoisonThreshold,
+		memory:                  newBudgetGuard(cfg.MemoryLimitMB, metrics.MemoryReserved),
+		weight:                  newBudgetGuard(cfg.WeightBudget, metrics.WeightReserved),
+		leakGrace:               cfg.LeakGrace,
+		slowFactor:              cfg.SlowTaskFactor,
+		slowMinRuns:             cfg.SlowTaskMinRuns,
+		peers:                   cfg.Peers,
+		prefetch:                cfg.Prefetch,
+		health:                  newStorageHealth(cfg.Logger, cfg.OutageThreshold, cfg.PollInterval, cfg.OutageMaxBackoff),
+		polling: polling{
+			batchSize:      cfg.PollBatchSize,
+			retryBatchSize: cfg.RetryPollBatchSize,
+			adaptive:       cfg.AdaptivePolling,
+			minInterval:    cfg.MinPollInterval,
+			maxInterval:    cfg.MaxPollInterval,
 		},
-		stopChan: make(chan struct{}),
+		fairness: fairness{window: cfg.FairnessWindow},
+
+		handlerHealthInterval: cfg.HandlerHealthInterval,
+		remote:                newRemoteBroker(),
+		leaseTimeout:          cfg.LeaseTimeout,
+		dispatchers:           cfg.Dispatchers,
+	}
+	if q.dispatchers <= 0 {
+		q.dispatchers = defaultDispatchers
+	}
+	q.exec = newExecutor(q.concurrencyLimit, q.clock.Now)
+	for _, class := range task.Classes {
+		q.taskChannels[class] = make(chan *task.Task, buffer)
+	}
+	for taskType, c := range cfg.Types {
+		q.types[taskType] = c
+	}
+	q.untracked, _ = storage.AsUntrackedQueue(cfg.Storage)
+	q.shardReader, _ = storage.AsShardReader(cfg.Storage)
+	q.indexChecker, _ = storage.AsIndexChecker(cfg.Storage)
+	for taskType, c := range cfg.Types {
+		q.checkFireAndForget(taskType, c)
+	}
+	q.instanceID = cfg.InstanceID
+	if !cfg.InProcess {
+		q.storage = newTimedStorage(cfg.Storage)
+	}
+	q.resourceTags = make(map[string]string, len(cfg.ResourceTags))
+	for k, v := range cfg.ResourceTags {
+		q.resourceTags[k] = v
 	}
 
 	return q
@@ -73,219 +592,1047 @@ func (q *Queue) RegisterHandler(taskType string, handler TaskHandler) {
 	q.logger.Info("registered task handler", zap.String("type", taskType))
 }
 
+// Types returns the task types with a registered handler, sorted
+func (q *Queue) Types() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	types := make([]string, 0, len(q.handlers))
+	for t := range q.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
 // Submit adds a new task to the queue
-func (q *Queue) Submit(ctx context.Context, t *task.Task) error {
-	if err := q.storage.SaveTask(ctx, t); err != nil {
-		return fmt.Errorf("failed to save task: %w", err)
+func (q *Queue) Submit(ctx context.Context, t *task.Task, opts ...SubmitOption) error {
+	o := newSubmitOptions(opts)
+	if t.Source == "" {
+		t.Source = callerSource()
+	}
+	q.applyTypeConfig(t)
+	// Priorities of 1 to 3 read back from storage as the old presets
+	t.Priority = t.Priority.Upgrade()
+	if t.Version == 0 {
+		t.Version = q.CurrentVersion(t.Type)
+	}
+	if err := q.runSubmitHooks(ctx, t); err != nil {
+		return err
+	}
+	if q.signingKeys != nil && o.plan == nil {
+		if err := q.signingKeys.SignTask(t); err != nil {
+			return fmt.Errorf("failed to sign task: %w", err)
+		}
 	}
 
-	metrics.TasksSubmitted.WithLabelValues(t.Type, fmt.Sprintf("%d", t.Priority)).Inc()
-	metrics.QueueSize.WithLabelValues(fmt.Sprintf("%d", t.Priority)).Inc()
+	if o.plan == nil && q.fireAndForget(t) {
+		return q.submitUntracked(ctx, t)
+	}
 
-	q.logger.Info("task submitted",
-		zap.String("id", t.ID),
-		zap.String("type", t.Type),
-		zap.Int("priority", int(t.Priority)),
-	)
+	opens, held := q.outsideWindow(t, q.clock.Now())
+	if o.plan != nil {
+		return q.plan(ctx, t, opens, held, o.plan)
+	}
+	if held {
+		t.MarkScheduled(opens)
+	}
 
-	// Try to send to channel (non-blocking)
-	select {
-	case q.taskChannels[t.Priority] <- t:
-	default:
-		// Channel full, will be picked up by polling
+	spilled := false
+	if !held && !q.inProcess && q.depth.enabled() {
+		var err error
+		if spilled, err = q.admit(ctx, t); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if !spilled {
+		if err := q.storage.SaveTask(ctx, t); err != nil {
+			return fmt.Errorf("failed to save task: %w", err)
+		}
+	}
+
+	series := q.submitted.get(t)
+	series.submitted.Inc()
+	series.bySource.Inc()
+	series.queued.Inc()
+	q.observeLabels(t, "submitted")
+
+	// Checked first so that, with info logs off, no fields are built
+	if ce := q.logger.Check(zap.InfoLevel, "task submitted"); ce != nil {
+		ce.Write(
+			zap.String("id", t.ID),
+			zap.String("type", t.Type),
+			zap.Int("priority", int(t.Priority)),
+			zap.String("correlation_id", t.CorrelationID),
+			zap.String("source", t.Source),
+		)
+	}
+
+	if held {
+		if q.inProcess {
+			return q.schedule(ctx, t, opens, q.logger)
+		}
+		return nil
+	}
+	if spilled {
+		return nil
+	}
+
+	return q.enqueue(ctx, t)
 }
 
-// GetTask retrieves a task by ID
+// GetTask retrieves a task by ID, including tasks spilled to overflow
+// storage
 func (q *Queue) GetTask(ctx context.Context, id string) (*task.Task, error) {
-	return q.storage.GetTask(ctx, id)
+	t, err := q.storage.GetTask(ctx, id)
+	if errors.Is(err, errs.ErrTaskNotFound) && q.depth.Overflow != nil {
+		if spilled, spillErr := q.depth.Overflow.GetTask(ctx, id); spillErr == nil {
+			return spilled, nil
+		}
+	}
+	return t, err
 }
 
-// Start begins processing tasks
+// GetTasks retrieves the tasks of ids that exist, by ID, including tasks
+// spilled to overflow storage
+func (q *Queue) GetTasks(ctx context.Context, ids []string) (map[string]*task.Task, error) {
+	tasks, err := storage.GetTasks(ctx, q.storage, ids)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*task.Task, len(tasks))
+	for _, t := range tasks {
+		found[t.ID] = t
+	}
+	if q.depth.Overflow == nil || len(found) == len(ids) {
+		return found, nil
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if spilled, err := storage.GetTasks(ctx, q.depth.Overflow, missing); err == nil {
+		for _, t := range spilled {
+			found[t.ID] = t
+		}
+	}
+	return found, nil
+}
+
+// observeLabels counts event for each allowlisted label the task carries
+func (q *Queue) observeLabels(t *task.Task, event string) {
+	for _, key := range q.metricLabels {
+		if value, ok := t.Labels[key]; ok {
+			metrics.TasksByLabel.WithLabelValues(key, value, event).Inc()
+		}
+	}
+}
+
+// ListTasks returns a page of tasks with the given status and labels,
+// resuming after the position cursor names, and the cursor of the next
+// page, empty once no more tasks remain
+func (q *Queue) ListTasks(ctx context.Context, status task.Status, labels map[string]string, cursor string, limit int) ([]*task.Task, string, error) {
+	tasks, next, err := q.storage.GetTasksPage(ctx, status, labels, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if tasks == nil {
+		tasks = []*task.Task{}
+	}
+	return tasks, next, nil
+}
+
+// Start begins processing tasks with numWorkers workers in all. They form
+// one pool, shared by every priority and type, that the dispatchers feed.
 func (q *Queue) Start(ctx context.Context, numWorkers int) {
 	q.logger.Info("starting queue", zap.Int("workers", numWorkers))
 
-	// Start workers for each priority level
-	for priority := range q.taskChannels {
-		for i := 0; i < numWorkers; i++ {
-			q.wg.Add(1)
-			go q.worker(ctx, priority, i)
-		}
+	// Set up handlers that hold resources before any task reaches them
+	q.initHandlers(ctx)
+
+	// Check handlers' health before any task reaches them, then keep
+	// checking
+	if q.handlerHealthInterval > 0 {
+		q.checkHandlers(ctx)
+		q.wg.Add(1)
+		go q.healthChecker(ctx)
+	}
+
+	// Start workers, and the dispatchers that hand them tasks of every
+	// priority level
+	q.workersMu.Lock()
+	q.workerCtx = ctx
+	for i := 0; i < numWorkers; i++ {
+		q.startWorker()
 	}
+	q.workersMu.Unlock()
+	q.startDispatchers(ctx, q.dispatchers)
 
 	// Start poller to refill channels from storage
-	q.wg.Add(1)
-	go q.poller(ctx)
+	if !q.inProcess {
+		q.bufferedMu.Lock()
+		if q.prefetch == 0 {
+			q.prefetch = numWorkers
+			q.autoPrefetch = true
+		}
+		q.bufferedMu.Unlock()
+
+		q.wg.Add(1)
+		go q.poller(ctx)
+		q.refill()
+
+		if q.peers != nil {
+			q.wg.Add(1)
+			go q.listenPeers(ctx)
+		}
+	}
 }
 
-// Stop gracefully stops the queue
+// Stop gracefully stops the queue. Workers finish the tasks they are
+// running but start no more; tasks prefetched from storage are handed back
+// to the other workers. Handlers registered with Register are closed last.
 func (q *Queue) Stop() {
-	q.logger.Info("stopping queue")
-	close(q.stopChan)
+	q.stopOnce.Do(func() {
+		q.logger.Info("stopping queue")
+		close(q.stopChan)
+		q.handoff()
+	})
 	q.wg.Wait()
+	q.closeOnce.Do(q.closeHandlers)
 	q.logger.Info("queue stopped")
 }
 
-// worker processes tasks from a priority channel
-func (q *Queue) worker(ctx context.Context, priority task.Priority, workerID int) {
+// Shutdown stops the queue, waiting for in-flight tasks to finish until ctx
+// is done. Tasks still running after that are left to the caller, which
+// should cancel the context passed to Start.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("queue shutdown: %w", ctx.Err())
+	}
+}
+
+// SetWorkers grows or shrinks the running workers to n. New workers start
+// straight away; workers let go finish the task they are running first,
+// so nothing in flight is lost. Unless Config.Prefetch was set, the
+// prefetch follows the worker count.
+func (q *Queue) SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", n)
+	}
+
+	q.workersMu.Lock()
+	defer q.workersMu.Unlock()
+	if q.workerCtx == nil {
+		return errors.New("queue not started")
+	}
+	if closed(q.stopChan) {
+		return errors.New("queue stopped")
+	}
+	if n == len(q.workers) {
+		return nil
+	}
+
+	q.logger.Info("resizing workers",
+		zap.Int("from", len(q.workers)),
+		zap.Int("to", n))
+	for len(q.workers) < n {
+		q.startWorker()
+	}
+	for _, quit := range q.workers[n:] {
+		close(quit)
+	}
+	q.workers = q.workers[:n]
+
+	q.bufferedMu.Lock()
+	if q.autoPrefetch {
+		q.prefetch = n
+	}
+	q.bufferedMu.Unlock()
+	q.refill()
+	return nil
+}
+
+// Workers returns how many workers are running
+func (q *Queue) Workers() int {
+	q.workersMu.Lock()
+	defer q.workersMu.Unlock()
+	return len(q.workers)
+}
+
+// startWorker starts one more worker; workersMu must be held
+func (q *Queue) startWorker() {
+	quit := make(chan struct{})
+	q.workers = append(q.workers, quit)
+	q.wg.Add(1)
+	go q.worker(q.workerCtx, q.nextWorkerID, quit)
+	q.nextWorkerID++
+}
+
+// worker runs the tasks dispatchers hand it until the queue stops or quit
+// is closed
+func (q *Queue) worker(ctx context.Context, workerID int, quit chan struct{}) {
 	defer q.wg.Done()
 
-	workerName := fmt.Sprintf("worker-%d-%d", priority, workerID)
+	workerName := fmt.Sprintf("worker-%d", workerID)
 	q.logger.Info("worker started", zap.String("worker", workerName))
 	metrics.WorkersActive.Inc()
 	defer metrics.WorkersActive.Dec()
 
-	for {
+	// stop closes when either the queue stops or this worker is let go
+	stop := make(chan struct{})
+	go func() {
 		select {
 		case <-q.stopChan:
-			q.logger.Info("worker stopping", zap.String("worker", workerName))
+		case <-quit:
+		}
+		close(stop)
+	}()
+
+	for {
+		// Hold off while storage is down, rather than run tasks whose
+		// outcome cannot be stored
+		var t *task.Task
+		ok := !closed(quit) && q.health.wait(ctx, stop)
+		if ok {
+			t, ok = q.exec.take(ctx, stop, func() bool {
+				// In InProcess mode the tasks already submitted still run
+				return q.inProcess && !closed(quit)
+			})
+		}
+		if !ok && closed(quit) {
+			// Let go by SetWorkers; the others carry on with the batches
+			q.logger.Info("worker released", zap.String("worker", workerName))
 			return
-		case <-ctx.Done():
+		}
+		if !ok {
+			q.logger.Info("worker stopping", zap.String("worker", workerName))
+			// Run partly filled batches now rather than after MaxWait
+			q.flushBatches(ctx)
 			return
-		case t := <-q.taskChannels[priority]:
-			q.processTask(ctx, t, workerName)
 		}
+		q.activity.start(workerName, t, q.clock.Now())
+		q.processTask(ctx, t, workerName)
+		q.activity.done(workerName)
+		q.exec.done(t)
+		q.release(t)
+	}
+}
+
+// withStores gives handlers running under ctx the idempotency store and
+// the artifact store, if any
+func (q *Queue) withStores(ctx context.Context) context.Context {
+	ctx = idempotency.WithStore(ctx, q.idempotency)
+	if q.artifacts == nil {
+		return ctx
+	}
+	return artifact.WithStore(ctx, q.artifacts)
+}
+
+// saveRunning stores the running task t after its handler changed it, such
+// as by saving a checkpoint. A task cancelled meanwhile cannot be written
+// back, which the handler sees as an error.
+func (q *Queue) saveRunning(ctx context.Context, t *task.Task) error {
+	return q.storage.UpdateTask(ctx, t)
+}
+
+// closed reports whether ch has been closed
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// next blocks until a task is available for a dispatcher, always taking
+// from the most urgent non-empty channel first, whichever worker will run
+// it. It returns false once stop closes.
+// Within a class, tasks leave in the order they were buffered; with
+// storage that is the poller's exact priority order.
+func (q *Queue) next(ctx context.Context, stop <-chan struct{}) (*task.Task, bool) {
+	if !q.inProcess && closed(stop) {
+		// Prefetched tasks are handed back rather than run
+		return nil, false
+	}
+	if t, ok := q.ready(); ok {
+		return t, true
+	}
+
+	var t *task.Task
+	select {
+	case <-stop:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	case t = <-q.taskChannels[task.PriorityCritical]:
+	case t = <-q.taskChannels[task.PriorityHigh]:
+	case t = <-q.taskChannels[task.PriorityMedium]:
+	case t = <-q.taskChannels[task.PriorityLow]:
 	}
+	q.taken(t)
+	return t, true
 }
 
 // processTask executes a single task
 func (q *Queue) processTask(ctx context.Context, t *task.Task, workerID string) {
-	startTime := time.Now()
-	
-	q.logger.Info("processing task",
+	startTime := q.clock.Now()
+
+	// Everything logged about this task, by the queue or its handler,
+	// carries the task's identity, attempt and correlation ID
+	logger := q.logger.With(
 		zap.String("id", t.ID),
 		zap.String("type", t.Type),
+		zap.Int("attempt", t.Attempt()),
 		zap.String("worker", workerID),
 	)
+	if t.CorrelationID != "" {
+		logger = logger.With(zap.String("correlation_id", t.CorrelationID))
+	}
+
+	if t.Untracked {
+		q.processUntracked(ctx, t, workerID, logger)
+		return
+	}
+
+	logger.Info("processing task")
+
+	if t.Overdue(startTime) {
+		q.expire(ctx, t, logger)
+		return
+	}
+
+	// A task can reach a worker after its window closed, e.g. a retry or
+	// a backlog built up in the window; hold it until the window reopens
+	if opens, ok := q.outsideWindow(t, startTime); ok {
+		if err := q.schedule(ctx, t, opens, logger); err != nil {
+			logger.Debug("skipping task", zap.Error(err))
+			return
+		}
+		logger.Info("task outside execution window, scheduled", zap.Time("scheduled_at", opens))
+		return
+	}
+
+	// A newer producer may have submitted a payload this worker does not
+	// understand yet; leave it for an upgraded worker
+	if current := q.CurrentVersion(t.Type); payloadVersion(t) > current {
+		retryAt := startTime.Add(q.unsupportedVersionDelay)
+		if err := q.schedule(ctx, t, retryAt, logger); err != nil {
+			logger.Debug("skipping task", zap.Error(err))
+			return
+		}
+		logger.Warn("task payload version is newer than this worker supports, scheduled",
+			zap.Int("version", t.Version),
+			zap.Int("supported", current),
+			zap.Time("scheduled_at", retryAt),
+		)
+		return
+	}
+
+	// Hold disabled types back until they are enabled again
+	if !q.TypeEnabled(t.Type) {
+		retryAt := startTime.Add(q.disabledTypeDelay)
+		if err := q.schedule(ctx, t, retryAt, logger); err != nil {
+			logger.Debug("skipping task", zap.Error(err))
+			return
+		}
+		logger.Debug("task type disabled, scheduled", zap.Time("scheduled_at", retryAt))
+		return
+	}
+
+	// Hold tasks back from a handler that could not set itself up or is
+	// unhealthy, such as one prefetched before its health check failed
+	if !q.handlerReady(t.Type) {
+		retryAt := startTime.Add(q.disabledTypeDelay)
+		if err := q.schedule(ctx, t, retryAt, logger); err != nil {
+			logger.Debug("skipping task", zap.Error(err))
+			return
+		}
+		logger.Debug("task handler not ready, scheduled", zap.Time("scheduled_at", retryAt))
+		return
+	}
+
+	// Leave tasks over their type's rate limit for when there is room
+	if wait := q.throttle(t, startTime); wait > 0 {
+		if err := q.schedule(ctx, t, startTime.Add(wait), logger); err != nil {
+			logger.Debug("skipping task", zap.Error(err))
+			return
+		}
+		logger.Debug("task type over its rate limit, scheduled", zap.Duration("wait", wait))
+		return
+	}
 
 	// Mark task as started
-	t.MarkStarted(workerID)
+	t.MarkStartedAt(workerID, q.clock.Now())
+	t.Instance = q.instanceID
 	if err := q.storage.UpdateTask(ctx, t); err != nil {
-		q.logger.Error("failed to update task status", zap.Error(err))
+		if errors.Is(err, errs.ErrInvalidTransition) {
+			// Another worker already moved this task on (e.g. a duplicate
+			// delivery from the poller), so there is nothing left to do
+			logger.Debug("skipping task", zap.Error(err))
+			return
+		}
+		// The task stays pending in storage and is polled again once
+		// storage is back
+		logger.Error("failed to update task status", zap.Error(err))
+		q.health.failure(err, q.clock.Now())
+		return
+	}
+	q.health.success()
+
+	// A task that fails verification did not come from a producer holding
+	// the keys, or was altered in storage; it is never retried
+	if q.signingKeys != nil {
+		if err := q.signingKeys.VerifyTask(t); err != nil {
+			logger.Error("task signature rejected", zap.Error(err))
+			err = fmt.Errorf("task signature rejected: %w: %w", task.ErrInvalidPayload, err)
+			q.recordFailure(t, err)
+			q.fail(ctx, t, err, logger)
+			return
+		}
 	}
 
 	// Get handler
 	q.mu.RLock()
 	handler, exists := q.handlers[t.Type]
+	b := q.batchers[t.Type]
 	q.mu.RUnlock()
 
 	if !exists {
-		q.logger.Error("no handler for task type", zap.String("type", t.Type))
-		t.MarkFailed(fmt.Errorf("no handler for task type: %s", t.Type))
-		q.storage.UpdateTask(ctx, t)
-		metrics.TasksProcessed.WithLabelValues(t.Type, "failed").Inc()
+		logger.Error("no handler for task type")
+		err := fmt.Errorf("%w: %s", errNoHandler, t.Type)
+		q.recordFailure(t, err)
+		q.fail(ctx, t, err, logger)
+		return
+	}
+
+	// Upgrade old payloads; the new payload is stored with the outcome
+	if err := q.migrate(t); err != nil {
+		logger.Error("failed to migrate task payload", zap.Error(err))
+		err = fmt.Errorf("%w: %w", task.ErrInvalidPayload, err)
+		q.recordFailure(t, err)
+		q.fail(ctx, t, err, logger)
+		return
+	}
+	if q.signingKeys != nil {
+		// Re-sign the migrated payload so a retry still verifies
+		if err := q.signingKeys.SignTask(t); err != nil {
+			logger.Error("failed to sign migrated task", zap.Error(err))
+		}
+	}
+
+	// Batch handlers run once enough tasks of the type have gathered
+	if b != nil {
+		if batch := b.add(ctx, batchItem{task: t, logger: logger, started: startTime}); batch != nil {
+			q.runBatch(ctx, b, batch)
+		}
 		return
 	}
 
-	// Execute with timeout
-	taskCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	// Wait for room in the weight budget and for the memory the type's
+	// hint asks for; the attempt's timeout starts once it has both
+	releaseWeight, err := q.reserveWeight(ctx, t, logger)
+	if err != nil {
+		q.finish(ctx, t, err, q.clock.Now().Sub(startTime), logger)
+		return
+	}
+	defer releaseWeight()
+	release, err := q.reserveMemory(ctx, t, logger)
+	if err != nil {
+		q.finish(ctx, t, err, q.clock.Now().Sub(startTime), logger)
+		return
+	}
+	defer release()
+	if q.memory != nil || q.weight != nil {
+		startTime = q.clock.Now()
+	}
+
+	// Execute with timeout, cut short by the task's deadline if sooner
+	deadline := startTime.Add(q.timeout(t))
+	if t.Deadline != nil && t.Deadline.Before(deadline) {
+		deadline = *t.Deadline
+	}
+	taskCtx, cancel := context.WithTimeout(ctx, deadline.Sub(startTime))
 	defer cancel()
+	// Keep what the handler logs with the task, so a failure can be
+	// looked into without searching every worker's logs
+	handlerLogger, captured := q.captureLogs(logger, t, workerID)
+	taskCtx = task.WithLogger(taskCtx, handlerLogger)
+	taskCtx = q.withStores(taskCtx)
+	taskCtx = task.WithSaver(taskCtx, q.saveRunning)
+	taskCtx = task.WithClock(taskCtx, q.clock.Now)
+	taskCtx = task.WithMeta(taskCtx, task.Meta{
+		TaskID:        t.ID,
+		Type:          t.Type,
+		CorrelationID: t.CorrelationID,
+		WorkerID:      workerID,
+		Attempt:       t.Attempt(),
+		MaxAttempts:   t.MaxRetries + 1,
+		Deadline:      deadline,
+	})
+
+	taskCtx, cancelled := q.watchCancel(taskCtx, t)
+	taskCtx, stopCPU := q.watchCPU(taskCtx, t, logger)
+	handlerReturned := q.watchLeak(taskCtx, t, logger)
+	stopSlow := q.watchSlow(taskCtx, t, deadline.Sub(startTime), logger)
 
-	err := handler(taskCtx, t)
-	duration := time.Since(startTime)
+	err = runHandler(taskCtx, handler, t, handlerLogger)
+	stopSlow()
+	handlerReturned()
+	stopCPU()
+	err = cpuCause(taskCtx, err)
+	q.saveLogs(ctx, t, captured, logger)
+	if cancelled() {
+		// The task was finished for good by Cancel; its outcome is moot
+		metrics.QueueSize.WithLabelValues(t.Priority.Label()).Dec()
+		logger.Info("running task cancelled", zap.Duration("duration", q.clock.Now().Sub(startTime)))
+		return
+	}
+	q.finish(ctx, t, err, q.clock.Now().Sub(startTime), logger)
+}
 
+// finish records the outcome of running t: it completes, retries, fails or
+// expires the task
+func (q *Queue) finish(ctx context.Context, t *task.Task, err error, duration time.Duration, logger *zap.Logger) {
 	// Update metrics
 	metrics.TaskDuration.WithLabelValues(t.Type).Observe(duration.Seconds())
-	metrics.QueueSize.WithLabelValues(fmt.Sprintf("%d", t.Priority)).Dec()
+	metrics.QueueSize.WithLabelValues(t.Priority.Label()).Dec()
+
+	// A handler asking to run later has not failed
+	if at, ok := task.RescheduleTime(err, q.clock.Now()); ok {
+		q.reschedule(ctx, t, at, logger)
+		return
+	}
 
 	if err != nil {
-		q.logger.Error("task failed",
-			zap.String("id", t.ID),
+		logger.Error("task failed",
 			zap.Error(err),
 			zap.Duration("duration", duration),
 		)
+		q.recordFailure(t, err)
 
-		if t.CanRetry() {
-			t.MarkRetrying()
+		if t.Overdue(q.clock.Now()) {
+			// A retry would only run later still
+			t.RecordError(err)
+			q.expire(ctx, t, logger)
+		} else if q.strike(t, err) {
+			q.quarantine(ctx, t, err, logger)
+		} else if t.CanRetry() && !task.IsPermanent(err) {
+			budgetDelay, ok := q.spendRetry(ctx, t, logger)
+			if !ok {
+				q.fail(ctx, t, fmt.Errorf("retry budget exhausted: %w", err), logger)
+				return
+			}
+			t.RecordError(err)
+			t.MarkRetryingAt(q.clock.Now())
 			q.storage.UpdateTask(ctx, t)
 			metrics.TaskRetries.WithLabelValues(t.Type).Inc()
 
-			// Re-submit with exponential backoff
-			backoff := time.Duration(t.RetryCount*t.RetryCount) * time.Second
-			time.Sleep(backoff)
-			q.taskChannels[t.Priority] <- t
+			// Hold the retry back by its type's retry policy, or longer if
+			// the handler asked or the retry budget is spent. The poller
+			// releases it when due, so the worker moves on meanwhile.
+			delay := q.retryDelay(t)
+			if asked, ok := task.RetryDelay(err); ok && asked > delay {
+				delay = asked
+			}
+			if budgetDelay > delay {
+				delay = budgetDelay
+			}
+			q.schedule(ctx, t, q.clock.Now().Add(delay), logger)
+			q.notify(ctx, onRetry, t, logger)
 		} else {
-			t.MarkFailed(err)
-			q.storage.UpdateTask(ctx, t)
-			metrics.TasksProcessed.WithLabelValues(t.Type, "failed").Inc()
+			q.fail(ctx, t, err, logger)
 		}
 	} else {
-		t.MarkCompleted()
+		q.runTimes.record(t.Type, duration)
+		t.MarkCompletedAt(q.clock.Now())
 		q.storage.UpdateTask(ctx, t)
 		metrics.TasksProcessed.WithLabelValues(t.Type, "completed").Inc()
+		q.observeLabels(t, "completed")
 		
-		q.logger.Info("task completed",
-			zap.String("id", t.ID),
+		logger.Info("task completed",
 			zap.Duration("duration", duration),
 		)
+		q.notify(ctx, onComplete, t, logger)
+		q.notify(ctx, onFinish, t, logger)
 	}
 }
 
+// reschedule holds t until at, as its handler asked, without counting a
+// retry. A task that would run past its deadline expires instead.
+func (q *Queue) reschedule(ctx context.Context, t *task.Task, at time.Time, logger *zap.Logger) {
+	if t.Deadline != nil && at.After(*t.Deadline) {
+		q.expire(ctx, t, logger)
+		return
+	}
+	t.MarkRescheduledAt(q.clock.Now())
+	if err := q.schedule(ctx, t, at, logger); err != nil {
+		logger.Error("failed to reschedule task", zap.Error(err))
+		return
+	}
+	metrics.TaskReschedules.WithLabelValues(t.Type).Inc()
+	logger.Info("task rescheduled by its handler", zap.Time("scheduled_at", at))
+}
+
+// fail marks a task as failed for good and reports it
+func (q *Queue) fail(ctx context.Context, t *task.Task, err error, logger *zap.Logger) {
+	t.MarkFailedAt(err, q.clock.Now())
+	q.storage.UpdateTask(ctx, t)
+	metrics.TasksProcessed.WithLabelValues(t.Type, "failed").Inc()
+	q.observeLabels(t, "failed")
+	q.notify(ctx, onFailure, t, logger)
+	q.notify(ctx, onFinish, t, logger)
+}
+
+// ProcessOne releases due scheduled tasks, expires overdue ones, then runs
+// the next pending task to completion on the calling goroutine. It returns
+// the task it ran, or nil if none was pending. It lets tests and callers
+// without workers drive the queue step by step.
+func (q *Queue) ProcessOne(ctx context.Context) (*task.Task, error) {
+	if q.inProcess {
+		t, ok := q.ready()
+		if !ok {
+			return nil, nil
+		}
+		q.processTask(ctx, t, "sync")
+		q.flushBatches(ctx)
+		return t, nil
+	}
+
+	q.expireOverdueTasks(ctx)
+	q.promoteDueTasks(ctx)
+
+	tasks, err := q.nextPending(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending task: %w", err)
+	}
+	if len(tasks) == 0 && q.untracked != nil {
+		if tasks, err = q.untracked.PopUntracked(ctx, 1); err != nil {
+			return nil, fmt.Errorf("failed to get untracked task: %w", err)
+		}
+		if len(tasks) > 0 && tasks[0].ScheduledAt != nil && tasks[0].ScheduledAt.After(q.clock.Now()) {
+			// A retry not due yet
+			q.pushBack(tasks[0])
+			return nil, nil
+		}
+	}
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+
+	q.processTask(ctx, tasks[0], "sync")
+	q.flushBatches(ctx)
+	return tasks[0], nil
+}
+
+// runHandler calls handler, turning a panic into an error so one bad task
+// fails or retries like any other instead of taking down the worker
+func runHandler(ctx context.Context, handler TaskHandler, t *task.Task, logger *zap.Logger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("handler panicked", zap.Any("panic", r), zap.Stack("stack"))
+			err = fmt.Errorf("%w: %v", errHandlerPanicked, r)
+		}
+	}()
+	return handler(ctx, t)
+}
+
 // poller continuously checks storage for pending tasks
 func (q *Queue) poller(ctx context.Context) {
 	defer q.wg.Done()
 
-	ticker := time.NewTicker(1 * time.Second)
+	interval := q.pollInterval
+	metrics.PollInterval.Set(interval.Seconds())
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
+		q.liveness.beat(q.clock.Now(), interval)
 		select {
 		case <-q.stopChan:
 			return
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			q.pollPendingTasks(ctx)
+			if !q.health.shouldPoll(q.clock.Now()) {
+				continue
+			}
+			if !q.health.isPaused() && (q.scheduler == nil || q.scheduler.IsLeader()) {
+				q.expireOverdueTasks(ctx)
+				q.promoteDueTasks(ctx)
+				q.refillFromOverflow(ctx)
+				q.observeQueues(ctx)
+			}
+			fetched := q.pollPendingTasks(ctx)
+			if next := q.polling.next(interval, fetched); next != interval {
+				interval = next
+				ticker.Reset(interval)
+				metrics.PollInterval.Set(interval.Seconds())
+			}
+		case <-q.wake:
+			// A worker took a prefetched task; top the channels up
+			if q.health.shouldPoll(q.clock.Now()) {
+				q.pollPendingTasks(ctx)
+			}
 		}
 	}
 }
 
-// pollPendingTasks retrieves pending tasks from storage
-func (q *Queue) pollPendingTasks(ctx context.Context) {
-	tasks, err := q.storage.GetTasksByStatus(ctx, task.StatusPending, 50)
+// outsideWindow reports whether t's type has an execution window that is
+// closed at now, and when it next opens
+func (q *Queue) outsideWindow(t *task.Task, now time.Time) (time.Time, bool) {
+	w, ok := q.windows[t.Type]
+	if !ok || w.Contains(now) {
+		return time.Time{}, false
+	}
+	return w.Next(now), true
+}
+
+// expire marks a task that missed its deadline and reports it
+func (q *Queue) expire(ctx context.Context, t *task.Task, logger *zap.Logger) {
+	t.MarkExpiredAt(q.clock.Now())
+	if err := q.storage.UpdateTask(ctx, t); err != nil {
+		logger.Error("failed to expire task", zap.Error(err))
+		return
+	}
+
+	metrics.SLAMissed.WithLabelValues(t.Type).Inc()
+	metrics.TasksProcessed.WithLabelValues(t.Type, "expired").Inc()
+	q.observeLabels(t, "expired")
+	logger.Warn("task missed its deadline", zap.Time("deadline", *t.Deadline))
+
+	if q.onExpired != nil {
+		q.onExpired(ctx, t)
+	}
+	q.notify(ctx, onFinish, t, logger)
+}
+
+// expireOverdueTasks expires waiting tasks whose deadline has passed.
+// Running tasks are left to their worker, whose handler context ends at
+// the deadline.
+func (q *Queue) expireOverdueTasks(ctx context.Context) {
+	tasks, err := q.storage.GetOverdueTasks(ctx, q.clock.Now(), 100)
 	if err != nil {
-		q.logger.Error("failed to poll tasks", zap.Error(err))
+		q.logger.Error("failed to poll overdue tasks", zap.Error(err))
 		return
 	}
 
 	for _, t := range tasks {
-		select {
-		case q.taskChannels[t.Priority] <- t:
-		default:
-			// Channel full, will be picked up in next poll
+		if t.Status == task.StatusProcessing {
+			continue
 		}
+		q.expire(ctx, t, q.logger.With(zap.String("id", t.ID), zap.String("type", t.Type)))
+	}
+}
+
+// promoteDueTasks moves scheduled tasks that are due back to pending
+func (q *Queue) promoteDueTasks(ctx context.Context) {
+	tasks, err := q.storage.GetDueTasks(ctx, q.clock.Now(), 100)
+	if err != nil {
+		q.logger.Error("failed to poll scheduled tasks", zap.Error(err))
+		return
 	}
 
+	for _, t := range tasks {
+		t.Status = task.StatusPending
+		if err := q.storage.UpdateTask(ctx, t); err != nil {
+			q.logger.Error("failed to release scheduled task", zap.String("id", t.ID), zap.Error(err))
+			continue
+		}
+		q.enqueue(ctx, t)
+	}
+}
+
+// pollPendingTasks retrieves pending tasks from storage, returning how
+// many it fetched
+func (q *Queue) pollPendingTasks(ctx context.Context) int {
+	tasks, err := q.pollStatus(ctx, task.StatusPending, q.fairness.batch(q.polling.batchSize))
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("failed to poll tasks", zap.Error(err))
+			q.health.failure(err, q.clock.Now())
+		}
+		return 0
+	}
+	q.health.success()
+
+	for _, t := range q.fairness.order(tasks) {
+		if !q.offer(t) {
+			// Prefetch full, will be picked up in next poll
+			break
+		}
+	}
+	fetched := len(tasks) + q.pollUntracked(ctx)
+
 	// Also check for retrying tasks
-	retryingTasks, err := q.storage.GetTasksByStatus(ctx, task.StatusRetrying, 20)
+	retryingTasks, err := q.pollStatus(ctx, task.StatusRetrying, q.fairness.batch(q.polling.retryBatchSize))
 	if err == nil {
-		for _, t := range retryingTasks {
-			select {
-			case q.taskChannels[t.Priority] <- t:
-			default:
+		for _, t := range q.fairness.order(retryingTasks) {
+			if !q.offer(t) {
+				break
 			}
 		}
 	}
+	return fetched
+}
+
+// statsWindows are the periods queue throughput is reported over
+var statsWindows = []struct {
+	name   string
+	period time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// Throughput counts tasks finished within a window
+type Throughput struct {
+	Completed int64   `json:"completed"`
+	Failed    int64   `json:"failed"`
+	PerSecond float64 `json:"per_second"`
 }
 
-// GetStats returns queue statistics
+// GetStats returns queue statistics: task counts per status at the top
+// level, plus "by_type" and "by_queue" counts, fire-and-forget tasks waiting
+// ("untracked"), "throughput" per window and the average time
+// tasks waited before starting over the last hour ("avg_wait_seconds").
+// Everything comes from counters kept in storage, not from loading tasks.
 func (q *Queue) GetStats(ctx context.Context) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 
-	for status := range map[task.Status]bool{
-		task.StatusPending:    true,
-		task.StatusProcessing: true,
-		task.StatusCompleted:  true,
-		task.StatusFailed:     true,
-	} {
-		tasks, err := q.storage.GetTasksByStatus(ctx, status, 1000)
+	counts, err := q.storage.CountTasksByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range task.Statuses {
+		stats[string(status)] = int(counts[status])
+	}
+
+	byType := make(map[string]map[task.Status]int64)
+	for _, status := range task.Statuses {
+		types, err := q.storage.CountTasksByType(ctx, status)
 		if err != nil {
 			return nil, err
 		}
-		stats[string(status)] = len(tasks)
+		for taskType, n := range types {
+			if byType[taskType] == nil {
+				byType[taskType] = make(map[task.Status]int64)
+			}
+			byType[taskType][status] = n
+		}
 	}
+	stats["by_type"] = byType
+
+	byQueue := make(map[string]map[task.Status]int64)
+	for taskType, counts := range byType {
+		name := q.queueOf(taskType)
+		if byQueue[name] == nil {
+			byQueue[name] = make(map[task.Status]int64)
+		}
+		for status, n := range counts {
+			byQueue[name][status] += n
+		}
+	}
+	stats["by_queue"] = byQueue
+
+	if q.untracked != nil {
+		untracked, err := q.untracked.CountUntracked(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stats["untracked"] = untracked
+	}
+
+	now := q.clock.Now()
+	minutes, err := q.storage.GetMinuteStats(ctx, now.Add(-time.Hour+time.Minute), now)
+	if err != nil {
+		return nil, err
+	}
+
+	throughput := make(map[string]Throughput, len(statsWindows))
+	for _, window := range statsWindows {
+		since := now.Truncate(time.Minute).Add(-window.period + time.Minute)
+		var tp Throughput
+		for _, m := range minutes {
+			if m.Minute.Before(since) {
+				continue
+			}
+			tp.Completed += m.Completed
+			tp.Failed += m.Failed
+		}
+		tp.PerSecond = float64(tp.Completed+tp.Failed) / window.period.Seconds()
+		throughput[window.name] = tp
+	}
+	stats["throughput"] = throughput
+
+	var started, waitMs int64
+	for _, m := range minutes {
+		started += m.Started
+		waitMs += m.WaitMs
+	}
+	avgWait := 0.0
+	if started > 0 {
+		avgWait = float64(waitMs) / float64(started) / 1000
+	}
+	stats["avg_wait_seconds"] = avgWait
 
 	return stats, nil
 }
+
+// TimePoint is one step of a throughput and latency time series
+type TimePoint struct {
+	Time      time.Time `json:"time"`
+	Completed int64     `json:"completed"`
+	Failed    int64     `json:"failed"`
+	P50Ms     int64     `json:"p50_ms"`
+	P95Ms     int64     `json:"p95_ms"`
+}
+
+// TimeSeries returns completed and failed counts and run time percentiles
+// for the last window, one point per step, oldest first. Percentiles are
+// the upper bound of the latency bucket they fall in.
+func (q *Queue) TimeSeries(ctx context.Context, window, step time.Duration) ([]TimePoint, error) {
+	if step < time.Minute {
+		step = time.Minute
+	}
+	step = step.Truncate(time.Minute)
+
+	now := q.clock.Now()
+	to := now.Truncate(time.Minute)
+	from := to.Add(-window + time.Minute)
+	minutes, err := q.storage.GetMinuteStats(ctx, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []TimePoint
+	for i := 0; i < len(minutes); {
+		start := minutes[i].Minute
+		agg := storage.MinuteStats{Minute: start}
+		for ; i < len(minutes) && minutes[i].Minute.Before(start.Add(step)); i++ {
+			agg.Merge(minutes[i])
+		}
+		points = append(points, TimePoint{
+			Time:      start,
+			Completed: agg.Completed,
+			Failed:    agg.Failed,
+			P50Ms:     storage.LatencyPercentile(agg.Latency, 0.5).Milliseconds(),
+			P95Ms:     storage.LatencyPercentile(agg.Latency, 0.95).Milliseconds(),
+		})
+	}
+	return points, nil
+}
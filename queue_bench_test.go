@@ -2,7 +2,10 @@ package queue
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/yourusername/distributed-task-queue/internal/storage"
 	"github.com/yourusername/distributed-task-queue/internal/task"
@@ -29,37 +32,162 @@ func BenchmarkQueue_Submit(b *testing.B) {
 	}
 }
 
-func BenchmarkQueue_ProcessTask(b *testing.B) {
-	store := storage.NewMemoryStorage()
-	logger, _ := zap.NewDevelopment()
-	
-	q := NewQueue(Config{
-		Storage: store,
-		Logger:  logger,
-	})
+// submitAllocBudget is how many allocations Submit may make per task, on
+// top of storage's own. Raise it only with a reason: every allocation on
+// this path is paid once per task.
+//
+// Storage's own are not measured here, as discardStorage keeps nothing.
+// RedisStorage.SaveTask makes 43 per task with go-redis v8.11.5, for a
+// task without a payload:
+//   - 27 in go-redis: the pipeline (4), running it (3), and the SET,
+//     EVALSHA, HINCRBY and EXPIRE commands boxing their arguments (20)
+//   - 14 building keys and script arguments: the status shard, age index
+//     and counter keys (7), the index script's arguments (5) and the
+//     per-minute stats key (2)
+//   - 2 for the JSON encoder and the record's key
+//
+// Encoding a payload adds a few more per key, for map iteration and
+// boxing. Tasks are not pooled: the caller builds each one and still
+// holds it after Submit.
+const submitAllocBudget = 0
+
+// submitHotPath submits b.N tasks made beforehand to storage that keeps
+// nothing, so only the queue's own work is measured
+func submitHotPath(source string) func(b *testing.B) {
+	return func(b *testing.B) {
+		q := NewQueue(Config{Storage: discardStorage{}, Logger: zap.NewNop()})
+		ctx := context.Background()
+		tasks := make([]*task.Task, b.N)
+		for i := range tasks {
+			tasks[i] = task.NewTask("benchmark_task", task.PriorityMedium, nil)
+			tasks[i].Source = source
+		}
 
-	q.RegisterHandler("benchmark_task", func(ctx context.Context, t *task.Task) error {
-		return nil
-	})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for _, t := range tasks {
+			if err := q.Submit(ctx, t); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "submits/s")
+		allocs := testing.AllocsPerRun(100, func() {
+			tasks[0].Source = source
+			q.Submit(ctx, tasks[0])
+		})
+		if allocs > submitAllocBudget {
+			b.Fatalf("Submit made %.0f allocations per task, over the budget of %d", allocs, submitAllocBudget)
+		}
+	}
+}
 
+// BenchmarkQueue_SubmitHotPath measures Submit alone, for tasks that name
+// their source and for those attributed to their caller
+func BenchmarkQueue_SubmitHotPath(b *testing.B) {
+	b.Run("source", submitHotPath("api"))
+	b.Run("caller", submitHotPath(""))
+}
+
+func TestQueue_SubmitAllocations(t *testing.T) {
+	q := NewQueue(Config{Storage: discardStorage{}, Logger: zap.NewNop()})
+	ctx := context.Background()
+	tk := task.NewTask("benchmark_task", task.PriorityMedium, nil)
+
+	// An empty source is filled in from the caller
+	for _, source := range []string{"api", ""} {
+		allocs := testing.AllocsPerRun(100, func() {
+			tk.Source = source
+			if err := q.Submit(ctx, tk); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > submitAllocBudget {
+			t.Errorf("Submit made %.0f allocations per task with source %q, over the budget of %d", allocs, source, submitAllocBudget)
+		}
+	}
+}
+
+// BenchmarkQueue_ProcessTask measures how fast workers drain a backlog of
+// b.N tasks that were submitted before they started
+func BenchmarkQueue_ProcessTask(b *testing.B) {
+	q, done := newBenchQueue(b, Config{
+		Storage:      storage.NewMemoryStorage(),
+		PollInterval: 10 * time.Millisecond,
+	})
 	ctx := context.Background()
 
-	// Pre-populate with tasks
-	tasks := make([]*task.Task, b.N)
 	for i := 0; i < b.N; i++ {
-		t := task.NewTask("benchmark_task", task.PriorityMedium, map[string]interface{}{
+		q.Submit(ctx, task.NewTask("benchmark_task", task.PriorityMedium, map[string]interface{}{
 			"index": i,
-		})
-		tasks[i] = t
-		q.Submit(ctx, t)
+		}))
 	}
 
+	b.ResetTimer()
 	q.Start(ctx, 4)
 	defer q.Stop()
+	done.wait(b)
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "tasks/s")
+}
 
-	b.ResetTimer()
-	// Workers will process tasks in background
-	// This measures throughput
+// BenchmarkQueue_EndToEnd measures submitting and processing together,
+// from the first Submit until the last task completes
+func BenchmarkQueue_EndToEnd(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"memory", Config{Storage: storage.NewMemoryStorage(), PollInterval: 10 * time.Millisecond}},
+		{"in_process", Config{InProcess: true, BufferSize: 1000}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			q, done := newBenchQueue(b, bc.cfg)
+			ctx := context.Background()
+			q.Start(ctx, 4)
+			defer q.Stop()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					q.Submit(ctx, task.NewTask("benchmark_task", task.PriorityMedium, nil))
+				}
+			})
+			done.wait(b)
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "tasks/s")
+		})
+	}
+}
+
+// benchDone counts distinct tasks a benchmark handler has finished
+type benchDone struct {
+	seen  sync.Map
+	count atomic.Int64
+	want  int64
+	ch    chan struct{}
+}
+
+func (d *benchDone) wait(b *testing.B) {
+	select {
+	case <-d.ch:
+	case <-time.After(time.Minute):
+		b.Fatalf("processed %d of %d tasks", d.count.Load(), d.want)
+	}
+}
+
+// newBenchQueue creates a quiet queue whose handler reports when all b.N
+// tasks have run; redeliveries of a task are only counted once
+func newBenchQueue(b *testing.B, cfg Config) (*Queue, *benchDone) {
+	cfg.Logger = zap.NewNop()
+	q := NewQueue(cfg)
+
+	done := &benchDone{want: int64(b.N), ch: make(chan struct{})}
+	q.RegisterHandler("benchmark_task", func(ctx context.Context, t *task.Task) error {
+		if _, dup := done.seen.LoadOrStore(t.ID, true); !dup && done.count.Add(1) == done.want {
+			close(done.ch)
+		}
+		return nil
+	})
+	return q, done
 }
 
 func BenchmarkTask_Serialization(b *testing.B) {
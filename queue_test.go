@@ -1,16 +1,34 @@
 package queue
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
 	"github.com/yourusername/distributed-task-queue/internal/storage"
 	"github.com/yourusername/distributed-task-queue/internal/task"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestQueue_Submit(t *testing.T) {
@@ -260,3 +278,3189 @@ func TestTask_Retries(t *testing.T) {
 	assert.Equal(t, 3, testTask.RetryCount)
 	assert.False(t, testTask.CanRetry())
 }
+
+func TestTask_Transitions(t *testing.T) {
+	assert.True(t, task.StatusPending.CanTransitionTo(task.StatusProcessing))
+	assert.True(t, task.StatusProcessing.CanTransitionTo(task.StatusRetrying))
+	assert.True(t, task.StatusRetrying.CanTransitionTo(task.StatusProcessing))
+	assert.True(t, task.StatusCompleted.CanTransitionTo(task.StatusCompleted))
+
+	assert.False(t, task.StatusPending.CanTransitionTo(task.StatusCompleted))
+	assert.False(t, task.StatusCompleted.CanTransitionTo(task.StatusProcessing))
+	assert.False(t, task.StatusFailed.CanTransitionTo(task.StatusRetrying))
+	assert.False(t, task.StatusProcessing.CanTransitionTo(task.StatusProcessing))
+}
+
+func TestQueue_DuplicateDelivery(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	ctx := context.Background()
+
+	var runs int32
+	q.RegisterHandler("test_task", func(ctx context.Context, t *task.Task) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	testTask := task.NewTask("test_task", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, testTask))
+
+	// The poller hands the same pending task to two workers; the second
+	// to start it finds it running already
+	first, err := store.GetTask(ctx, testTask.ID)
+	require.NoError(t, err)
+	second, err := store.GetTask(ctx, testTask.ID)
+	require.NoError(t, err)
+
+	first.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, first))
+	q.processTask(ctx, second, "worker-2")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&runs))
+
+	// The attempt running it can still update it
+	first.LastCheckpoint = &task.Checkpoint{Data: json.RawMessage(`{"page":2}`)}
+	require.NoError(t, store.UpdateTask(ctx, first))
+	stored, err := store.GetTask(ctx, testTask.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "worker-1", stored.WorkerID)
+}
+
+func TestStorage_UpdateTask_InvalidTransition(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	testTask := task.NewTask("test_task", task.PriorityHigh, nil)
+	require.NoError(t, store.SaveTask(ctx, testTask))
+
+	testTask.MarkCompleted()
+	err := store.UpdateTask(ctx, testTask)
+	assert.ErrorIs(t, err, errs.ErrInvalidTransition)
+
+	_, err = store.GetTask(ctx, "nonexistent-id")
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+}
+
+func TestMemoryStorage_ScanTasks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	save := func(taskType string) *task.Task {
+		tk := task.NewTask(taskType, task.PriorityMedium, nil)
+		require.NoError(t, store.SaveTask(ctx, tk))
+		return tk
+	}
+	for i := 0; i < 4; i++ {
+		save("send_email")
+	}
+	save("resize")
+	save("resize")
+	done := save("resize")
+	done.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, done))
+	done.MarkCompleted()
+	require.NoError(t, store.UpdateTask(ctx, done))
+
+	// scan pages through filter two tasks at a time, calling between with
+	// the cursor after each page
+	scan := func(filter storage.TaskFilter, between func(cursor string)) (map[string]int, int) {
+		seen := make(map[string]int)
+		pages := 0
+		cursor := ""
+		for {
+			page, next, err := store.ScanTasks(ctx, filter, cursor, 2)
+			require.NoError(t, err)
+			pages++
+			for _, tk := range page {
+				assert.True(t, filter.Matches(tk))
+				seen[tk.ID]++
+			}
+			if next == "" {
+				return seen, pages
+			}
+			if between != nil {
+				between(next)
+			}
+			cursor = next
+		}
+	}
+
+	seen, pages := scan(storage.TaskFilter{}, nil)
+	assert.Len(t, seen, 7)
+	assert.Equal(t, 4, pages)
+	for id, n := range seen {
+		assert.Equal(t, 1, n, id)
+	}
+
+	seen, _ = scan(storage.TaskFilter{Statuses: []task.Status{task.StatusPending}, Type: "resize"}, nil)
+	assert.Len(t, seen, 2)
+	assert.NotContains(t, seen, done.ID)
+
+	// A page that holds the last matching tasks ends the scan itself,
+	// without an empty page after it
+	seen, pages = scan(storage.TaskFilter{Statuses: []task.Status{task.StatusCompleted}}, nil)
+	assert.Equal(t, map[string]int{done.ID: 1}, seen)
+	assert.Equal(t, 1, pages)
+	seen, pages = scan(storage.TaskFilter{Type: "resize"}, nil)
+	assert.Len(t, seen, 3)
+	assert.Equal(t, 2, pages)
+
+	// Tasks deleted ahead of the cursor are not returned; tasks added
+	// during the scan are returned once if their ID sorts after it. Every
+	// other task is still returned exactly once.
+	var deleted, added string
+	var addedAfter bool
+	seen, _ = scan(storage.TaskFilter{Type: "send_email"}, func(cursor string) {
+		if deleted != "" {
+			return
+		}
+		tasks, _, err := store.ScanTasks(ctx, storage.TaskFilter{Type: "send_email"}, cursor, 100)
+		require.NoError(t, err)
+		require.NotEmpty(t, tasks)
+		deleted = tasks[len(tasks)-1].ID
+		require.NoError(t, store.DeleteTask(ctx, deleted))
+
+		tk := save("send_email")
+		added, addedAfter = tk.ID, tk.ID > cursor
+	})
+	assert.NotContains(t, seen, deleted)
+	if addedAfter {
+		assert.Equal(t, 1, seen[added])
+	} else {
+		assert.NotContains(t, seen, added)
+	}
+	for id, n := range seen {
+		assert.Equal(t, 1, n, id)
+	}
+	expected := 3
+	if addedAfter {
+		expected++
+	}
+	assert.Len(t, seen, expected)
+}
+
+// newRedisStorage returns Redis storage on an in-memory server that lives
+// as long as the test
+func newRedisStorage(t *testing.T) *storage.RedisStorage {
+	server := miniredis.RunT(t)
+	store, err := storage.NewRedisStorage(server.Addr(), "", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRedisStorage_UpdateTask(t *testing.T) {
+	store := newRedisStorage(t)
+	ctx := context.Background()
+
+	testTask := task.NewTask("test_task", task.PriorityHigh, nil)
+	testTask.MarkScheduled(time.Now().Add(-time.Second))
+	require.NoError(t, store.SaveTask(ctx, testTask))
+
+	// The old indexes are left, and the transition counted, with the write
+	testTask.Status = task.StatusPending
+	require.NoError(t, store.UpdateTask(ctx, testTask))
+	due, err := store.GetDueTasks(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	testTask.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, testTask))
+	testTask.MarkCompleted()
+	require.NoError(t, store.UpdateTask(ctx, testTask))
+
+	for status, want := range map[task.Status]int{
+		task.StatusScheduled: 0, task.StatusPending: 0, task.StatusProcessing: 0, task.StatusCompleted: 1,
+	} {
+		tasks, err := store.GetTasksByStatus(ctx, status, 10)
+		require.NoError(t, err)
+		assert.Len(t, tasks, want, status)
+	}
+	stats, err := store.GetMinuteStats(ctx, time.Now(), time.Now())
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, int64(1), stats[0].Started)
+	assert.Equal(t, int64(1), stats[0].Completed)
+
+	// A rejected update writes nothing
+	testTask.MarkStarted("worker-2")
+	assert.ErrorIs(t, store.UpdateTask(ctx, testTask), errs.ErrInvalidTransition)
+	stored, err := store.GetTask(ctx, testTask.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, stored.Status)
+	counts, err := store.CountTasksByStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counts[task.StatusProcessing])
+	assert.Equal(t, int64(1), counts[task.StatusCompleted])
+}
+
+func TestRedisStorage_CountsFollowIndexes(t *testing.T) {
+	store := newRedisStorage(t)
+	ctx := context.Background()
+
+	save := func(taskType string) *task.Task {
+		tk := task.NewTask(taskType, task.PriorityHigh, nil)
+		require.NoError(t, store.SaveTask(ctx, tk))
+		return tk
+	}
+	update := func(tk *task.Task, mark func()) {
+		mark()
+		require.NoError(t, store.UpdateTask(ctx, tk))
+	}
+	start := func(tk *task.Task) func() { return func() { tk.MarkStarted("worker-1") } }
+
+	completed := save("send_email")
+	update(completed, start(completed))
+	update(completed, completed.MarkCompleted)
+
+	failed := save("send_email")
+	update(failed, start(failed))
+	update(failed, failed.MarkRetrying)
+	update(failed, start(failed))
+	update(failed, func() { failed.MarkFailed(errors.New("boom")) })
+
+	scheduled := save("resize")
+	update(scheduled, func() { scheduled.MarkScheduled(time.Now().Add(time.Hour)) })
+	update(scheduled, func() { scheduled.Status = task.StatusPending })
+	update(scheduled, scheduled.MarkCancelled)
+
+	deleted := save("resize")
+	update(deleted, start(deleted))
+	require.NoError(t, store.DeleteTask(ctx, deleted.ID))
+
+	save("resize")
+	running := save("send_email")
+	update(running, start(running))
+
+	counts, err := store.CountTasksByStatus(ctx)
+	require.NoError(t, err)
+	for _, status := range task.Statuses {
+		indexed, err := store.GetTasksByStatus(ctx, status, 100)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(indexed)), counts[status], status)
+
+		byType, err := store.CountTasksByType(ctx, status)
+		require.NoError(t, err)
+		var total int64
+		for _, n := range byType {
+			total += n
+		}
+		assert.Equal(t, int64(len(indexed)), total, status)
+	}
+	assert.Equal(t, int64(1), counts[task.StatusPending])
+	assert.Equal(t, int64(1), counts[task.StatusProcessing])
+	assert.Equal(t, int64(1), counts[task.StatusCompleted])
+	assert.Equal(t, int64(1), counts[task.StatusFailed])
+	assert.Equal(t, int64(1), counts[task.StatusCancelled])
+}
+
+func TestRedisStorage_StatusShards(t *testing.T) {
+	store := newRedisStorage(t)
+	ctx := context.Background()
+	require.NoError(t, store.SetStatusShards(ctx, 4))
+
+	priorities := []task.Priority{task.PriorityLow, task.PriorityHigh, task.PriorityMedium}
+	base := time.Now().Truncate(time.Second).Add(-time.Hour)
+	var saved []*task.Task
+	for i := 0; i < 12; i++ {
+		tk := task.NewTask("test_task", priorities[i%len(priorities)], nil)
+		tk.CreatedAt = base.Add(time.Duration(i) * time.Second)
+		require.NoError(t, store.SaveTask(ctx, tk))
+		saved = append(saved, tk)
+	}
+	// Highest priority first, newest first within a priority
+	sort.Slice(saved, func(i, j int) bool {
+		if saved[i].Priority != saved[j].Priority {
+			return saved[i].Priority > saved[j].Priority
+		}
+		return saved[i].CreatedAt.After(saved[j].CreatedAt)
+	})
+	expected := make([]string, len(saved))
+	for i, tk := range saved {
+		expected[i] = tk.ID
+	}
+
+	logger, _ := zap.NewDevelopment()
+	q := NewQueue(Config{Storage: store, Logger: logger})
+	assertIndexed := func(shards int) {
+		assert.Equal(t, shards, store.StatusShards())
+
+		used := 0
+		total := 0
+		for shard := 0; shard < shards; shard++ {
+			tasks, err := store.GetTasksByShards(ctx, task.StatusPending, []int{shard}, 100)
+			require.NoError(t, err)
+			if len(tasks) > 0 {
+				used++
+			}
+			total += len(tasks)
+		}
+		assert.Equal(t, len(expected), total)
+		if shards > 1 {
+			assert.Greater(t, used, 1, "tasks should spread across shards")
+		}
+
+		listed, _, err := q.ListTasks(ctx, task.StatusPending, nil, "", 100)
+		require.NoError(t, err)
+		ids := make([]string, len(listed))
+		for i, tk := range listed {
+			ids[i] = tk.ID
+		}
+		assert.Equal(t, expected, ids)
+
+		counts, err := store.CountTasksByStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(expected)), counts[task.StatusPending])
+	}
+
+	assertIndexed(4)
+	require.NoError(t, store.SetStatusShards(ctx, 3))
+	assertIndexed(3)
+	require.NoError(t, store.SetStatusShards(ctx, 1))
+	assertIndexed(1)
+}
+
+func TestRedisStorage_GetTasksPage(t *testing.T) {
+	store := newRedisStorage(t)
+	ctx := context.Background()
+	require.NoError(t, store.SetStatusShards(ctx, 3))
+
+	// Tasks created in the same second share a score, so pages must break
+	// ties by ID across shards
+	created := time.Now().Truncate(time.Second)
+	for i := 0; i < 9; i++ {
+		priority := task.PriorityMedium
+		if i%4 == 0 {
+			priority = task.PriorityHigh
+		}
+		tk := task.NewTask("test_task", priority, nil)
+		tk.CreatedAt = created
+		if i%2 == 0 {
+			tk.Labels = map[string]string{"team": "a"}
+		}
+		require.NoError(t, store.SaveTask(ctx, tk))
+	}
+
+	pageAll := func(labels map[string]string, afterFirst func([]*task.Task)) []string {
+		var ids []string
+		cursor := ""
+		for {
+			page, next, err := store.GetTasksPage(ctx, task.StatusPending, labels, cursor, 2)
+			require.NoError(t, err)
+			for _, tk := range page {
+				ids = append(ids, tk.ID)
+			}
+			if cursor == "" && afterFirst != nil {
+				afterFirst(page)
+			}
+			if next == "" {
+				return ids
+			}
+			cursor = next
+		}
+	}
+	idsOf := func(tasks []*task.Task) []string {
+		ids := make([]string, len(tasks))
+		for i, tk := range tasks {
+			ids[i] = tk.ID
+		}
+		return ids
+	}
+
+	all, err := store.GetTasksByStatus(ctx, task.StatusPending, 100)
+	require.NoError(t, err)
+	require.Len(t, all, 9)
+	assert.Equal(t, idsOf(all), pageAll(nil, nil))
+
+	labelled, err := store.GetTasksByLabels(ctx, task.StatusPending, map[string]string{"team": "a"}, 100)
+	require.NoError(t, err)
+	require.Len(t, labelled, 5)
+	assert.Equal(t, idsOf(labelled), pageAll(map[string]string{"team": "a"}, nil))
+
+	// Deleting a task already listed leaves the later pages where they were
+	var deleted string
+	rest := pageAll(nil, func(page []*task.Task) {
+		deleted = page[0].ID
+		require.NoError(t, store.DeleteTask(ctx, deleted))
+	})
+	assert.Equal(t, idsOf(all), rest)
+
+	_, _, err = store.GetTasksPage(ctx, task.StatusPending, nil, "not-a-position", 2)
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+}
+
+func TestQueue_GetStats_Aggregates(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	logger, _ := zap.NewDevelopment()
+	q := NewQueue(Config{Storage: store, Logger: logger})
+	ctx := context.Background()
+
+	for _, taskType := range []string{"email", "email", "export"} {
+		require.NoError(t, q.Submit(ctx, task.NewTask(taskType, task.PriorityMedium, nil)))
+	}
+
+	// Run one email task through to completion after waiting two seconds
+	done := task.NewTask("email", task.PriorityHigh, nil)
+	done.CreatedAt = time.Now().Add(-2 * time.Second)
+	require.NoError(t, store.SaveTask(ctx, done))
+	done.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, done))
+	done.MarkCompleted()
+	require.NoError(t, store.UpdateTask(ctx, done))
+
+	stats, err := q.GetStats(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, stats["pending"])
+	assert.Equal(t, 1, stats["completed"])
+
+	byType := stats["by_type"].(map[string]map[task.Status]int64)
+	assert.Equal(t, int64(2), byType["email"][task.StatusPending])
+	assert.Equal(t, int64(1), byType["email"][task.StatusCompleted])
+	assert.Equal(t, int64(1), byType["export"][task.StatusPending])
+
+	throughput := stats["throughput"].(map[string]Throughput)
+	assert.Equal(t, int64(1), throughput["1m"].Completed)
+	assert.Equal(t, int64(1), throughput["1h"].Completed)
+
+	assert.InDelta(t, 2.0, stats["avg_wait_seconds"], 0.5)
+}
+
+func TestQueue_TimeSeries(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	logger, _ := zap.NewDevelopment()
+	q := NewQueue(Config{Storage: store, Logger: logger})
+	ctx := context.Background()
+
+	// Nine fast tasks and one slow one finish this minute
+	for i := 0; i < 10; i++ {
+		tk := task.NewTask("test_task", task.PriorityMedium, nil)
+		require.NoError(t, store.SaveTask(ctx, tk))
+		tk.MarkStarted("worker-1")
+		require.NoError(t, store.UpdateTask(ctx, tk))
+		tk.MarkCompleted()
+		if i == 9 {
+			slow := tk.StartedAt.Add(-20 * time.Second)
+			tk.StartedAt = &slow
+		}
+		require.NoError(t, store.UpdateTask(ctx, tk))
+	}
+
+	points, err := q.TimeSeries(ctx, time.Hour, 5*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, points, 12)
+
+	last := points[len(points)-1]
+	assert.Equal(t, int64(10), last.Completed)
+	assert.Equal(t, int64(10), last.P50Ms)
+	assert.Equal(t, int64(30000), last.P95Ms)
+	assert.Equal(t, int64(0), points[0].Completed)
+}
+
+func TestQueue_HandlerLoggerCarriesCorrelationID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	q := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.New(core)})
+
+	done := make(chan struct{})
+	q.RegisterHandler("test_task", func(ctx context.Context, tk *task.Task) error {
+		task.LoggerFromContext(ctx).Info("handling")
+		close(done)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	tk := task.NewTask("test_task", task.PriorityMedium, nil)
+	tk.CorrelationID = "checkout-42"
+	require.NoError(t, q.Submit(ctx, tk))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not processed")
+	}
+
+	entries := logs.FilterMessage("handling").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "checkout-42", entries[0].ContextMap()["correlation_id"])
+	assert.Equal(t, tk.ID, entries[0].ContextMap()["id"])
+}
+
+func TestQueue_HandlerMeta(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	q := NewQueue(Config{
+		Storage:     storage.NewMemoryStorage(),
+		Logger:      logger,
+		TaskTimeout: time.Minute,
+	})
+
+	metas := make(chan task.Meta, 2)
+	q.RegisterHandler("test_task", func(ctx context.Context, tk *task.Task) error {
+		meta, ok := task.MetaFromContext(ctx)
+		require.True(t, ok)
+		metas <- meta
+		if meta.Attempt == 1 {
+			return errors.New("first attempt fails")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	tk := task.NewTask("test_task", task.PriorityMedium, nil)
+	tk.MaxRetries = 1
+	require.NoError(t, q.Submit(ctx, tk))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		select {
+		case meta := <-metas:
+			assert.Equal(t, attempt, meta.Attempt)
+			assert.Equal(t, 2, meta.MaxAttempts)
+			assert.Equal(t, attempt == 2, meta.LastAttempt())
+			assert.Equal(t, tk.ID, meta.TaskID)
+			assert.Equal(t, "worker-0", meta.WorkerID)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), meta.Deadline, 5*time.Second)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("attempt %d did not run", attempt)
+		}
+	}
+}
+
+func TestTask_AttemptAndLastError(t *testing.T) {
+	testTask := task.NewTask("test_task", task.PriorityMedium, nil)
+	assert.Equal(t, 1, testTask.Attempt())
+	assert.NoError(t, testTask.LastError())
+
+	testTask.MarkStarted("worker-1")
+	testTask.RecordError(errors.New("upstream timeout"))
+	testTask.MarkRetrying()
+	assert.Equal(t, 2, testTask.Attempt())
+	assert.EqualError(t, testTask.LastError(), "upstream timeout")
+
+	// The error survives a round trip through storage
+	data, err := testTask.ToJSON()
+	require.NoError(t, err)
+	restored, err := task.FromJSON(data)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored.Attempt())
+	assert.EqualError(t, restored.LastError(), "upstream timeout")
+}
+
+func TestQueue_SubmitChild_PriorityInheritance(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	parent := task.NewTask("parent", task.PriorityCritical, nil)
+	parent.CorrelationID = "checkout-42"
+	parent.Source = "service:checkout"
+	parent.Labels = map[string]string{"team": "payments"}
+
+	tests := []struct {
+		name        string
+		inheritance PriorityInheritance
+		requested   task.Priority
+		opts        []SubmitOption
+		want        task.Priority
+	}{
+		{"at least parent raises", InheritAtLeastParent, task.PriorityLow, nil, task.PriorityCritical},
+		{"exact", InheritExact, task.PriorityLow, nil, task.PriorityCritical},
+		{"none", InheritNone, task.PriorityLow, nil, task.PriorityLow},
+		{"override", InheritAtLeastParent, task.PriorityLow, []SubmitOption{WithPriorityOverride(task.PriorityMedium)}, task.PriorityMedium},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			q := NewQueue(Config{Storage: store, Logger: logger, PriorityInheritance: tt.inheritance})
+
+			child := task.NewTask("child", tt.requested, nil)
+			require.NoError(t, q.SubmitChild(ctx, parent, child, tt.opts...))
+
+			stored, err := store.GetTask(ctx, child.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, stored.Priority)
+			assert.Equal(t, parent.ID, stored.ParentID)
+			assert.Equal(t, "checkout-42", stored.CorrelationID)
+			assert.Equal(t, "service:checkout", stored.Source)
+			assert.Equal(t, "payments", stored.Labels["team"])
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	w, err := ParseWindow("01:00-05:00")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(day.Add(2*time.Hour)))
+	assert.False(t, w.Contains(day.Add(5*time.Hour)))
+	assert.Equal(t, day.Add(25*time.Hour), w.Next(day.Add(6*time.Hour)))
+	assert.Equal(t, day.Add(time.Hour), w.Next(day.Add(30*time.Minute)))
+
+	// Windows may wrap past midnight
+	overnight, err := ParseWindow("22:00-02:00")
+	require.NoError(t, err)
+	assert.True(t, overnight.Contains(day.Add(23*time.Hour)))
+	assert.True(t, overnight.Contains(day.Add(time.Hour)))
+	assert.False(t, overnight.Contains(day.Add(12*time.Hour)))
+	assert.Equal(t, "22:00-02:00", overnight.String())
+
+	_, err = ParseWindows("batch_process=01:00")
+	assert.Error(t, err)
+}
+
+func TestQueue_ExecutionWindow(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	// A window that closed a minute ago and reopens in a day
+	now := time.Now().UTC()
+	closed := Window{Start: sinceMidnight(now.Add(-2 * time.Hour)), End: sinceMidnight(now.Add(-time.Minute))}
+	q := NewQueue(Config{
+		Storage:          store,
+		Logger:           logger,
+		ExecutionWindows: map[string]Window{"batch_process": closed},
+	})
+
+	tk := task.NewTask("batch_process", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+
+	stored, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, stored.Status)
+	require.NotNil(t, stored.ScheduledAt)
+	assert.True(t, stored.ScheduledAt.After(now))
+
+	// Other types are not held back
+	other := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, other))
+	stored, err = store.GetTask(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, stored.Status)
+
+	// Once due, scheduled tasks go back to pending
+	due := task.NewTask("send_email", task.PriorityLow, nil)
+	due.MarkScheduled(now.Add(-time.Second))
+	require.NoError(t, store.SaveTask(ctx, due))
+	q.promoteDueTasks(ctx)
+	stored, err = store.GetTask(ctx, due.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, stored.Status)
+}
+
+func TestQueue_Deadline(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	expired := make(chan string, 2)
+	q := NewQueue(Config{
+		Storage: store,
+		Logger:  logger,
+		OnExpired: func(ctx context.Context, tk *task.Task) {
+			expired <- tk.ID
+		},
+	})
+
+	// A waiting task past its deadline is expired by the sweep
+	late := task.NewTask("send_email", task.PriorityHigh, nil)
+	deadline := time.Now().Add(-time.Second)
+	late.Deadline = &deadline
+	require.NoError(t, store.SaveTask(ctx, late))
+
+	q.expireOverdueTasks(ctx)
+
+	stored, err := store.GetTask(ctx, late.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusExpired, stored.Status)
+	assert.True(t, stored.Status.Terminal())
+	assert.Equal(t, late.ID, <-expired)
+
+	// A running handler is cancelled at the deadline and the task expires
+	// instead of being retried
+	q.RegisterHandler("slow", func(ctx context.Context, tk *task.Task) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	slow := task.NewTask("slow", task.PriorityHigh, nil)
+	deadline = time.Now().Add(100 * time.Millisecond)
+	slow.Deadline = &deadline
+	require.NoError(t, store.SaveTask(ctx, slow))
+
+	q.processTask(ctx, slow, "worker-1")
+
+	stored, err = store.GetTask(ctx, slow.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusExpired, stored.Status)
+	assert.Equal(t, 0, stored.RetryCount)
+	assert.Equal(t, slow.ID, <-expired)
+}
+
+func TestQueue_HandlerPanicFailsTask(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{
+		Storage: store,
+		Logger:  zap.NewNop(),
+	})
+	q.RegisterHandler("panics", func(ctx context.Context, t *task.Task) error {
+		panic("boom")
+	})
+
+	ctx := context.Background()
+	tk := task.NewTask("panics", task.PriorityHigh, nil)
+	tk.MaxRetries = 0
+	require.NoError(t, store.SaveTask(ctx, tk))
+
+	require.NotPanics(t, func() { q.processTask(ctx, tk, "worker-1") })
+
+	retrieved, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, retrieved.Status)
+	assert.Contains(t, retrieved.Error, "handler panicked: boom")
+}
+
+func TestQueue_InProcess(t *testing.T) {
+	q := NewQueue(Config{
+		Logger:     zap.NewNop(),
+		InProcess:  true,
+		BufferSize: 10,
+	})
+
+	var mu sync.Mutex
+	var calls []string
+	q.RegisterHandler("work", func(ctx context.Context, t *task.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, t.Payload["name"].(string))
+		if t.Payload["name"] == "flaky" && t.Attempt() == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		priority task.Priority
+	}{{"low", task.PriorityLow}, {"flaky", task.PriorityMedium}, {"critical", task.PriorityCritical}} {
+		tk := task.NewTask("work", tc.priority, map[string]interface{}{"name": tc.name})
+		require.NoError(t, q.Submit(ctx, tk))
+	}
+
+	// Nothing is stored
+	_, err := q.GetTask(ctx, "anything")
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+
+	// Synchronous processing takes the highest priority first
+	processed, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "critical", processed.Payload["name"])
+
+	// The failed attempt is retried from a timer after its backoff
+	q.Start(ctx, 1)
+	defer q.Stop()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(calls) == 4
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"critical", "flaky", "low", "flaky"}, calls)
+}
+
+func TestQueue_InProcessBufferFull(t *testing.T) {
+	q := NewQueue(Config{
+		Logger:     zap.NewNop(),
+		InProcess:  true,
+		BufferSize: 1,
+	})
+
+	ctx := context.Background()
+	require.NoError(t, q.Submit(ctx, task.NewTask("work", task.PriorityHigh, nil)))
+
+	// With the buffer full and no workers, Submit waits for room
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := q.Submit(timeoutCtx, task.NewTask("work", task.PriorityHigh, nil))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	q.Stop()
+	err = q.Submit(ctx, task.NewTask("work", task.PriorityHigh, nil))
+	assert.ErrorIs(t, err, errs.ErrQueueStopped)
+}
+
+func TestTask_IDGenerators(t *testing.T) {
+	for _, name := range []string{"uuidv7", "ulid"} {
+		gen, err := task.ParseIDGenerator(name)
+		require.NoError(t, err)
+
+		// Sortable IDs order by creation time across milliseconds
+		first := gen()
+		time.Sleep(2 * time.Millisecond)
+		second := gen()
+		assert.Less(t, first, second, name)
+		assert.NotEqual(t, gen(), gen(), name)
+	}
+
+	ulid := task.NewULID()
+	assert.Len(t, ulid, 26)
+	assert.Regexp(t, `^[0-9A-HJKMNP-TV-Z]{26}$`, ulid)
+
+	_, err := task.ParseIDGenerator("snowflake")
+	assert.Error(t, err)
+
+	task.SetIDGenerator(func() string { return "fixed" })
+	defer task.SetIDGenerator(nil)
+	assert.Equal(t, "fixed", task.NewTask("t", task.PriorityLow, nil).ID)
+}
+
+func TestQueue_PayloadMigration(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	ctx := context.Background()
+
+	var seen []map[string]interface{}
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		seen = append(seen, t.Payload)
+		return nil
+	})
+
+	// v1 had "to", v2 renamed it to "recipient", v3 made it a list
+	q.RegisterMigration("send_email", 1, func(p map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"recipient": p["to"]}, nil
+	})
+	q.RegisterMigration("send_email", 2, func(p map[string]interface{}) (map[string]interface{}, error) {
+		r, ok := p["recipient"].(string)
+		if !ok {
+			return nil, errors.New("recipient is not a string")
+		}
+		return map[string]interface{}{"recipients": []interface{}{r}}, nil
+	})
+	assert.Equal(t, 3, q.CurrentVersion("send_email"))
+	assert.Equal(t, 1, q.CurrentVersion("other"))
+
+	// Queued before the schema changed
+	old := task.NewTask("send_email", task.PriorityMedium, map[string]interface{}{"to": "a@example.com"})
+	old.Version = 1
+	require.NoError(t, q.Submit(ctx, old))
+
+	current := task.NewTask("send_email", task.PriorityLow, map[string]interface{}{"recipients": []interface{}{"b@example.com"}})
+	require.NoError(t, q.Submit(ctx, current))
+	assert.Equal(t, 3, current.Version)
+
+	broken := task.NewTask("send_email", task.PriorityLow, map[string]interface{}{"recipient": 42})
+	broken.Version = 2
+	require.NoError(t, q.Submit(ctx, broken))
+
+	for i := 0; i < 3; i++ {
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, seen, 2)
+	assert.Equal(t, []interface{}{"a@example.com"}, seen[0]["recipients"])
+	assert.Equal(t, []interface{}{"b@example.com"}, seen[1]["recipients"])
+
+	migrated, err := store.GetTask(ctx, old.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, migrated.Version)
+	assert.Equal(t, task.StatusCompleted, migrated.Status)
+
+	failed, err := store.GetTask(ctx, broken.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, failed.Status)
+	assert.Contains(t, failed.Error, "payload migration from version 2 failed")
+}
+
+func TestQueue_PayloadVersionTooNew(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	ctx := context.Background()
+
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		t.Payload["ran"] = true
+		return nil
+	})
+
+	future := task.NewTask("send_email", task.PriorityMedium, map[string]interface{}{})
+	future.Version = 2
+	require.NoError(t, q.Submit(ctx, future))
+
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	held, err := store.GetTask(ctx, future.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, held.Status)
+	assert.Nil(t, held.Payload["ran"])
+}
+
+func TestQueue_RollingUpgrade(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	// Mid-deploy, one worker still runs the old code and one the new
+	var ranBy []string
+	handler := func(name string) TaskHandler {
+		return func(ctx context.Context, t *task.Task) error {
+			ranBy = append(ranBy, name)
+			return nil
+		}
+	}
+	oldWorker := NewQueue(Config{Storage: store, Logger: zap.NewNop(), UnsupportedVersionDelay: time.Millisecond})
+	oldWorker.RegisterHandler("send_email", handler("old"))
+	newWorker := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	newWorker.RegisterHandler("send_email", handler("new"))
+	newWorker.RegisterMigration("send_email", 1, func(p map[string]interface{}) (map[string]interface{}, error) {
+		return p, nil
+	})
+
+	assert.Equal(t, map[string]int{"send_email": 1}, oldWorker.Versions())
+	assert.Equal(t, map[string]int{"send_email": 2}, newWorker.Versions())
+
+	// An upgraded producer submits at version 2; the old worker refuses it
+	tk := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{})
+	require.NoError(t, newWorker.Submit(ctx, tk))
+	_, err := oldWorker.ProcessOne(ctx)
+	require.NoError(t, err)
+	held, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, held.Status)
+
+	// Once it is due again the new worker takes it
+	time.Sleep(5 * time.Millisecond)
+	_, err = newWorker.ProcessOne(ctx)
+	require.NoError(t, err)
+	done, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, done.Status)
+	assert.Equal(t, []string{"new"}, ranBy)
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	ctx := context.Background()
+
+	inner := storage.NewMemoryStorage()
+	before, err := storage.NewKeyring("k1", map[string][]byte{"k1": oldKey})
+	require.NoError(t, err)
+	store := storage.NewEncryptedStorage(inner, before)
+
+	tk := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "user@example.com"})
+	require.NoError(t, store.SaveTask(ctx, tk))
+	assert.Equal(t, "user@example.com", tk.Payload["to"], "caller's task is left alone")
+
+	// The wrapped storage never sees the plaintext
+	raw, err := inner.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Nil(t, raw.Payload)
+	assert.True(t, strings.HasPrefix(raw.EncryptedPayload, "k1:"))
+	assert.NotContains(t, raw.EncryptedPayload, "example.com")
+
+	// After rotation old payloads still open, and updates move to the new key
+	after, err := storage.ParseKeyring("k2:" + base64.StdEncoding.EncodeToString(newKey) +
+		",k1:" + base64.StdEncoding.EncodeToString(oldKey))
+	require.NoError(t, err)
+	store = storage.NewEncryptedStorage(inner, after)
+
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	var seen interface{}
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		seen = t.Payload["to"]
+		return nil
+	})
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", seen)
+
+	raw, err = inner.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, raw.Status)
+	assert.True(t, strings.HasPrefix(raw.EncryptedPayload, "k2:"))
+
+	// A payload copied onto another task does not decrypt
+	other := task.NewTask("send_email", task.PriorityHigh, nil)
+	other.EncryptedPayload = raw.EncryptedPayload
+	require.NoError(t, inner.SaveTask(ctx, other))
+	_, err = store.GetTask(ctx, other.ID)
+	assert.Error(t, err)
+
+	// Keys must be valid AES keys
+	_, err = storage.NewKeyring("k2", map[string][]byte{"k2": []byte("short")})
+	assert.Error(t, err)
+}
+
+func TestEncryptedStorage_Checkpoint(t *testing.T) {
+	ctx := context.Background()
+	keys, err := storage.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	inner := storage.NewMemoryStorage()
+	store := storage.NewEncryptedStorage(inner, keys)
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+
+	q.RegisterHandler("import_rows", func(ctx context.Context, tk *task.Task) error {
+		return tk.SaveCheckpoint(ctx, map[string]string{"cursor": "user@example.com"})
+	})
+	tk := task.NewTask("import_rows", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	// The wrapped storage never sees the checkpoint's plaintext
+	raw, err := inner.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	require.NotNil(t, raw.LastCheckpoint)
+	assert.Nil(t, raw.LastCheckpoint.Data)
+	assert.True(t, strings.HasPrefix(raw.LastCheckpoint.EncryptedData, "k1:"))
+	assert.NotContains(t, raw.LastCheckpoint.EncryptedData, "example.com")
+
+	got, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"cursor":"user@example.com"}`, string(got.Checkpoint()))
+	assert.Empty(t, got.LastCheckpoint.EncryptedData)
+}
+
+func TestEncryptedStorage_UndecryptableTask(t *testing.T) {
+	ctx := context.Background()
+	keys, err := storage.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	retired, err := storage.NewKeyring("k0", map[string][]byte{"k0": bytes.Repeat([]byte{3}, 32)})
+	require.NoError(t, err)
+
+	inner := storage.NewMemoryStorage()
+	core, logs := observer.New(zap.WarnLevel)
+	store := storage.NewEncryptedStorage(inner, keys)
+	store.SetLogger(zap.New(core))
+
+	// One task was sealed with a key no longer in the ring
+	lost := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "lost"})
+	require.NoError(t, storage.NewEncryptedStorage(inner, retired).SaveTask(ctx, lost))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, store.SaveTask(ctx, task.NewTask("send_email", task.PriorityLow, map[string]interface{}{"to": "ok"})))
+	}
+
+	tasks, err := store.GetTasksByStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	for _, tk := range tasks {
+		assert.Equal(t, "ok", tk.Payload["to"])
+	}
+	require.Equal(t, 1, logs.FilterMessage("skipping task whose payload does not decrypt").Len())
+	_, err = store.GetTask(ctx, lost.ID)
+	assert.Error(t, err)
+
+	// Workers go on polling past it and run the rest
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond})
+	var ran atomic.Int32
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		ran.Add(1)
+		return nil
+	})
+	q.Start(ctx, 1)
+	defer q.Stop()
+	require.Eventually(t, func() bool { return ran.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, q.DispatchPaused())
+}
+
+func TestQueue_SignedTasks(t *testing.T) {
+	keys, err := signing.NewKeys("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), SigningKeys: keys})
+	var ran []string
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		ran = append(ran, t.ID)
+		return nil
+	})
+
+	signed := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "a@example.com"})
+	require.NoError(t, q.Submit(ctx, signed))
+	assert.True(t, strings.HasPrefix(signed.Signature, "k1:"))
+
+	// Written straight into storage, bypassing a producer with the keys
+	forged := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "b@example.com"})
+	forged.CreatedAt = signed.CreatedAt.Add(time.Second)
+	require.NoError(t, store.SaveTask(ctx, forged))
+
+	// A signed payload altered in storage
+	tampered := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "c@example.com"})
+	tampered.CreatedAt = signed.CreatedAt.Add(2 * time.Second)
+	require.NoError(t, q.Submit(ctx, tampered))
+	tampered.Payload["to"] = "evil@example.com"
+	require.NoError(t, store.UpdateTask(ctx, tampered))
+
+	for i := 0; i < 3; i++ {
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{signed.ID}, ran)
+
+	for _, id := range []string{forged.ID, tampered.ID} {
+		got, err := q.GetTask(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, task.StatusFailed, got.Status)
+		assert.Contains(t, got.Error, "signature")
+		assert.Equal(t, 0, got.RetryCount, "rejected tasks are not retried")
+	}
+}
+
+type fixedLeadership bool
+
+func (l fixedLeadership) IsLeader() bool { return bool(l) }
+
+func TestQueue_SchedulerLeadership(t *testing.T) {
+	for _, leader := range []bool{false, true} {
+		store := storage.NewMemoryStorage()
+		q := NewQueue(Config{
+			Storage:      store,
+			Logger:       zap.NewNop(),
+			PollInterval: 10 * time.Millisecond,
+			Scheduler:    fixedLeadership(leader),
+		})
+
+		tk := task.NewTask("report", task.PriorityLow, nil)
+		tk.MarkScheduled(time.Now().Add(-time.Second))
+		require.NoError(t, store.SaveTask(context.Background(), tk))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		q.Start(ctx, 0)
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		q.Stop()
+
+		got, err := store.GetTask(context.Background(), tk.ID)
+		require.NoError(t, err)
+		if leader {
+			assert.Equal(t, task.StatusPending, got.Status, "the leader releases due tasks")
+		} else {
+			assert.Equal(t, task.StatusScheduled, got.Status, "followers leave due tasks alone")
+		}
+	}
+}
+
+func TestQueue_DepthLimitReject(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), DepthLimits: DepthLimits{
+		Total:       3,
+		PerPriority: map[task.Priority]int64{task.PriorityLow: 1},
+	}})
+
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil)))
+	err := q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil))
+	assert.ErrorIs(t, err, errs.ErrQueueFull)
+
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+	err = q.Submit(ctx, task.NewTask("send_email", task.PriorityCritical, nil))
+	assert.ErrorIs(t, err, errs.ErrQueueFull)
+
+	counts, err := store.CountTasksByPriority(ctx, task.StatusPending)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counts[task.PriorityLow])
+	assert.Equal(t, int64(2), counts[task.PriorityHigh])
+}
+
+func TestQueue_DepthLimitShed(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), DepthLimits: DepthLimits{
+		Total:  2,
+		Policy: OverflowShed,
+	}})
+
+	older := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, older))
+	time.Sleep(time.Millisecond)
+	newer := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, newer))
+
+	// Nothing is lower than low, so another low task is rejected
+	err := q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil))
+	assert.ErrorIs(t, err, errs.ErrQueueFull)
+
+	// A high task sheds the newest low one
+	urgent := task.NewTask("send_email", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, urgent))
+
+	shed, err := store.GetTask(ctx, newer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, shed.Status)
+	assert.Contains(t, shed.Error, "shed")
+
+	kept, err := store.GetTask(ctx, older.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, kept.Status)
+}
+
+func TestQueue_Admission(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), DepthLimits: DepthLimits{
+		Total:       10,
+		PerPriority: map[task.Priority]int64{task.PriorityLow: 2},
+		Policy:      OverflowShed,
+	}})
+
+	a, err := q.Admission(ctx)
+	require.NoError(t, err)
+	require.Len(t, a.Priorities, len(task.Classes))
+	for _, p := range a.Priorities {
+		assert.Equal(t, AdmissionOpen, p.State, p.Priority)
+		assert.Zero(t, p.SuggestedDelayMs)
+	}
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil)))
+	}
+	for i := 0; i < 6; i++ {
+		require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+	}
+	q.admission.counts = nil
+
+	critical, err := q.AdmissionFor(ctx, task.PriorityCritical)
+	require.NoError(t, err)
+	assert.Equal(t, AdmissionThrottle, critical.State)
+	assert.Equal(t, int64(8), critical.Pending)
+	assert.Equal(t, int64(10), critical.Limit)
+	// Nothing has finished, so the drain rate is unknown
+	assert.Equal(t, admissionMaxDelay, critical.SuggestedDelay())
+
+	low, err := q.AdmissionFor(ctx, task.PriorityLow)
+	require.NoError(t, err)
+	assert.Equal(t, AdmissionReject, low.State)
+	assert.Equal(t, int64(2), low.Limit)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+	}
+	q.admission.counts = nil
+
+	// At the total limit, critical tasks shed low ones
+	critical, err = q.AdmissionFor(ctx, task.PriorityCritical)
+	require.NoError(t, err)
+	assert.Equal(t, AdmissionShed, critical.State)
+	err = q.Submit(ctx, task.NewTask("send_email", task.PriorityCritical, nil))
+	assert.NoError(t, err)
+}
+
+func TestQueue_DepthLimitSpill(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	overflow := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), DepthLimits: DepthLimits{
+		Total:    1,
+		Policy:   OverflowSpill,
+		Overflow: overflow,
+	}})
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error { return nil })
+
+	first := task.NewTask("send_email", task.PriorityMedium, nil)
+	require.NoError(t, q.Submit(ctx, first))
+	spilled := task.NewTask("send_email", task.PriorityMedium, nil)
+	require.NoError(t, q.Submit(ctx, spilled))
+
+	// The spilled task is only in overflow storage, but still visible
+	_, err := store.GetTask(ctx, spilled.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+	got, err := q.GetTask(ctx, spilled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, got.Status)
+
+	// No room yet, so it stays put
+	q.refillFromOverflow(ctx)
+	_, err = store.GetTask(ctx, spilled.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+
+	// Once the first task runs it moves back and runs too
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	q.refillFromOverflow(ctx)
+	_, err = overflow.GetTask(ctx, spilled.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	done, err := store.GetTask(ctx, spilled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, done.Status)
+}
+
+func TestQueue_SubmitHooks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+
+	errNoTeam := errors.New("team label is required")
+	var order []string
+	q.OnSubmit(func(ctx context.Context, t *task.Task) error {
+		order = append(order, "labels")
+		if t.Labels["team"] == "" {
+			return errNoTeam
+		}
+		return nil
+	})
+	q.OnSubmit(func(ctx context.Context, t *task.Task) error {
+		order = append(order, "defaults")
+		if t.MaxRetries > 1 {
+			t.MaxRetries = 1
+		}
+		return nil
+	})
+
+	// The first failing hook stops the chain and nothing is stored
+	rejected := task.NewTask("send_email", task.PriorityHigh, nil)
+	err := q.Submit(ctx, rejected)
+	assert.ErrorIs(t, err, errNoTeam)
+	assert.Equal(t, errs.CodeTaskRejected, errs.From(err).Code)
+	assert.Equal(t, []string{"labels"}, order)
+	_, err = store.GetTask(ctx, rejected.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+
+	// Hooks may amend the task before it is stored
+	accepted := task.NewTask("send_email", task.PriorityHigh, nil)
+	accepted.Labels = map[string]string{"team": "billing"}
+	require.NoError(t, q.Submit(ctx, accepted))
+	stored, err := store.GetTask(ctx, accepted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.MaxRetries)
+
+	// Child tasks go through the same chain, after inheriting labels
+	child := task.NewTask("send_email", task.PriorityHigh, nil)
+	child.MaxRetries = 5
+	require.NoError(t, q.SubmitChild(ctx, stored, child))
+	storedChild, err := store.GetTask(ctx, child.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, storedChild.MaxRetries)
+}
+
+func TestQueue_BatchHandler(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), TaskTimeout: time.Minute})
+
+	var mu sync.Mutex
+	var sizes []int
+	q.RegisterBatchHandler("index_docs", func(ctx context.Context, tasks []*task.Task) []error {
+		mu.Lock()
+		sizes = append(sizes, len(tasks))
+		mu.Unlock()
+
+		results := make([]error, len(tasks))
+		for i, t := range tasks {
+			if t.Payload["doc"] == "bad" {
+				results[i] = errors.New("rejected by index")
+			}
+		}
+		return results
+	}, BatchOptions{MaxSize: 3, MaxWait: 50 * time.Millisecond})
+	assert.Equal(t, []string{"index_docs"}, q.Types())
+
+	var submitted []*task.Task
+	for _, doc := range []string{"a", "b", "bad", "c", "d"} {
+		tk := task.NewTask("index_docs", task.PriorityMedium, map[string]interface{}{"doc": doc})
+		tk.MaxRetries = 0
+		require.NoError(t, q.Submit(ctx, tk))
+		submitted = append(submitted, tk)
+	}
+
+	// One worker fills a batch of three, and the last two run after MaxWait
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sizes) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []int{3, 2}, sizes)
+	mu.Unlock()
+
+	for _, tk := range submitted {
+		got, err := store.GetTask(ctx, tk.ID)
+		require.NoError(t, err)
+		if tk.Payload["doc"] == "bad" {
+			assert.Equal(t, task.StatusFailed, got.Status)
+			assert.Equal(t, "rejected by index", got.Error)
+		} else {
+			assert.Equal(t, task.StatusCompleted, got.Status)
+		}
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	errBoom := errors.New("boom")
+	assert.Equal(t, []error{nil, nil}, batchErrors(nil, 2))
+	assert.Equal(t, []error{errBoom, errBoom}, batchErrors([]error{errBoom}, 2))
+	assert.Equal(t, []error{nil, errBoom}, batchErrors([]error{nil, errBoom}, 2))
+	assert.ErrorContains(t, batchErrors([]error{nil, nil}, 3)[2], "returned 2 results for 3 tasks")
+}
+
+func TestQueue_Prefetch(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	busy := NewQueue(Config{Storage: store, Logger: zap.NewNop(), Prefetch: 2, PollInterval: 10 * time.Millisecond})
+	busy.RegisterHandler("slow", func(ctx context.Context, t *task.Task) error {
+		<-release
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, busy.Submit(ctx, task.NewTask("slow", task.PriorityMedium, nil)))
+	}
+
+	busy.Start(ctx, 1)
+	defer func() {
+		close(release)
+		busy.Stop()
+	}()
+
+	// One task runs and two wait; the rest stay in storage
+	require.Eventually(t, func() bool {
+		counts, err := store.CountTasksByStatus(ctx)
+		return err == nil && counts[task.StatusProcessing] == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	busy.bufferedMu.Lock()
+	assert.Len(t, busy.buffered, 2)
+	busy.bufferedMu.Unlock()
+
+	// An idle worker elsewhere can take what the busy one left behind
+	idle := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	idle.RegisterHandler("slow", func(ctx context.Context, t *task.Task) error { return nil })
+	ran, err := idle.ProcessOne(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, ran)
+	assert.Equal(t, task.StatusCompleted, ran.Status)
+}
+
+func TestPolling_AdaptiveInterval(t *testing.T) {
+	p := polling{batchSize: 50, adaptive: true, minInterval: 100 * time.Millisecond, maxInterval: 4 * time.Second}
+
+	// Full batches speed the poller up, down to the minimum
+	assert.Equal(t, 500*time.Millisecond, p.next(time.Second, 50))
+	assert.Equal(t, 100*time.Millisecond, p.next(150*time.Millisecond, 50))
+
+	// Empty polls slow it down, up to the maximum
+	assert.Equal(t, 2*time.Second, p.next(time.Second, 0))
+	assert.Equal(t, 4*time.Second, p.next(3*time.Second, 0))
+
+	// Partial batches leave it alone, as does turning adaptation off
+	assert.Equal(t, time.Second, p.next(time.Second, 10))
+	p.adaptive = false
+	assert.Equal(t, time.Second, p.next(time.Second, 0))
+}
+
+func TestQueue_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+
+	pending := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "a@example.com"})
+	require.NoError(t, src.Submit(ctx, pending))
+	running := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, src.Submit(ctx, running))
+	running.MarkStarted("worker-1")
+	require.NoError(t, src.storage.UpdateTask(ctx, running))
+
+	var buf bytes.Buffer
+	n, err := src.Export(ctx, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	dst := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	result, err := dst.Import(ctx, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Imported: 2}, result)
+
+	got, err := dst.GetTask(ctx, pending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "a@example.com", got.Payload["to"])
+
+	// Its worker is gone, so the running task is restored as pending
+	got, err = dst.GetTask(ctx, running.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, got.Status)
+	assert.Empty(t, got.WorkerID)
+
+	// Running the import again skips what is already there
+	result, err = dst.Import(ctx, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Skipped: 2}, result)
+
+	_, err = dst.Import(ctx, strings.NewReader(`{"id": "x"}`))
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+
+	// In process mode nothing polls storage, so imported pending tasks,
+	// the running one among them, are queued directly
+	inProcess := NewQueue(Config{Logger: zap.NewNop(), InProcess: true, BufferSize: 10})
+	defer inProcess.Stop()
+	result, err = inProcess.Import(ctx, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Imported: 2}, result)
+
+	var ran []string
+	inProcess.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		ran = append(ran, t.ID)
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		processed, err := inProcess.ProcessOne(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, processed)
+	}
+	assert.Equal(t, []string{pending.ID, running.ID}, ran)
+	processed, err := inProcess.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, processed)
+}
+
+func TestQueue_Boost(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+
+	stuck := task.NewTask("export_data", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, stuck))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityCritical, nil)))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityCritical, nil)))
+
+	boosted, err := q.Boost(ctx, stuck.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.PriorityCritical, boosted.Priority)
+	assert.NotNil(t, boosted.BoostedAt)
+
+	var ran []string
+	q.RegisterHandler("export_data", func(ctx context.Context, t *task.Task) error {
+		ran = append(ran, t.Type)
+		return nil
+	})
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		ran = append(ran, t.Type)
+		return nil
+	})
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"export_data"}, ran)
+
+	_, err = q.Boost(ctx, stuck.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotPending)
+	_, err = q.Boost(ctx, "missing")
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+}
+
+func TestQueue_FineGrainedPriority(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+
+	var ran []task.Priority
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		ran = append(ran, t.Priority)
+		return nil
+	})
+
+	// All but the last share the medium class
+	for _, p := range []task.Priority{40, 50, task.PriorityMedium, 45, task.PriorityHigh + 1} {
+		require.NoError(t, q.Submit(ctx, task.NewTask("send_email", p, nil)))
+	}
+	for i := 0; i < 5; i++ {
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []task.Priority{67, 50, 45, 40, 33}, ran)
+}
+
+func TestQueue_LegacyPriorities(t *testing.T) {
+	// Tasks stored when there were four priorities read back as presets
+	for stored, want := range map[string]task.Priority{
+		`{"id": "t", "priority": 0}`: task.PriorityLow,
+		`{"id": "t", "priority": 1}`: task.PriorityMedium,
+		`{"id": "t", "priority": 2}`: task.PriorityHigh,
+		`{"id": "t", "priority": 3}`: task.PriorityCritical,
+		`{"id": "t", "priority": 4}`: 4,
+	} {
+		tk, err := task.FromJSON([]byte(stored))
+		require.NoError(t, err)
+		assert.Equal(t, want, tk.Priority, stored)
+	}
+	p, err := task.ParsePriority("2")
+	require.NoError(t, err)
+	assert.Equal(t, task.PriorityHigh, p)
+
+	// Submitted at an old number, a task is stored as it will read back
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	old := task.NewTask("send_email", 3, nil)
+	require.NoError(t, q.Submit(context.Background(), old))
+	got, err := store.GetTask(context.Background(), old.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.PriorityCritical, got.Priority)
+}
+
+func TestQueue_DepthLimitShedFineGrained(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), DepthLimits: DepthLimits{
+		Total:  2,
+		Policy: OverflowShed,
+	}})
+
+	low := task.NewTask("send_email", 10, nil)
+	lower := task.NewTask("send_email", 5, nil)
+	require.NoError(t, q.Submit(ctx, low))
+	require.NoError(t, q.Submit(ctx, lower))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", 20, nil)))
+
+	shed, err := store.GetTask(ctx, lower.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, shed.Status)
+	kept, err := store.GetTask(ctx, low.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, kept.Status)
+
+	// Nothing below 5 to shed
+	err = q.Submit(ctx, task.NewTask("send_email", 5, nil))
+	assert.ErrorIs(t, err, errs.ErrQueueFull)
+}
+
+func TestQueue_DryRun(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	closed := Window{Start: sinceMidnight(now.Add(-2 * time.Hour)), End: sinceMidnight(now.Add(-time.Minute))}
+	q := NewQueue(Config{
+		Storage:          store,
+		Logger:           zap.NewNop(),
+		ExecutionWindows: map[string]Window{"batch_process": closed},
+		DepthLimits:      DepthLimits{PerPriority: map[task.Priority]int64{task.PriorityLow: 1}},
+	})
+	q.RegisterMigration("send_email", 1, func(p map[string]interface{}) (map[string]interface{}, error) { return p, nil })
+
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil)))
+
+	var plan SubmitPlan
+	tk := task.NewTask("send_email", task.PriorityMedium, nil)
+	require.NoError(t, q.Submit(ctx, tk, DryRun(&plan)))
+	assert.Equal(t, SubmitPlan{Outcome: OutcomeQueued, Priority: task.PriorityMedium, Version: 2, Ahead: 2}, plan)
+	_, err := store.GetTask(ctx, tk.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+
+	// Held for its window
+	require.NoError(t, q.Submit(ctx, task.NewTask("batch_process", task.PriorityLow, nil), DryRun(&plan)))
+	assert.Equal(t, OutcomeScheduled, plan.Outcome)
+	require.NotNil(t, plan.ScheduledAt)
+	assert.True(t, plan.ScheduledAt.After(now))
+
+	// At a depth limit, the dry run fails as the submission would
+	err = q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil), DryRun(&plan))
+	assert.ErrorIs(t, err, errs.ErrQueueFull)
+}
+
+func TestQueue_Position(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error { return nil })
+
+	done := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, done))
+
+	// Nothing has finished yet, so there is no estimate
+	pos, err := q.Position(ctx, done.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pos.Ahead)
+	assert.True(t, pos.EstimatedStart.IsZero())
+
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil)))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	_, err = q.Position(ctx, done.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+
+	low := task.NewTask("send_email", task.PriorityLow, nil)
+	low.CreatedAt = time.Now().Add(-time.Minute)
+	require.NoError(t, q.Submit(ctx, low))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil)))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityHigh, nil)))
+
+	// Two tasks finished in the last five minutes: one every 150s
+	pos, err = q.Position(ctx, low.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pos.Ahead)
+	assert.WithinDuration(t, time.Now().Add(300*time.Second), pos.EstimatedStart, 5*time.Second)
+}
+
+func TestQueue_TypeConfig(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	high, retries := task.PriorityHigh, 7
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), Types: map[string]TypeConfig{
+		"send_email": {
+			Priority:   &high,
+			MaxRetries: &retries,
+			Timeout:    time.Second,
+			Retry:      &RetryPolicy{Initial: time.Minute, Multiplier: 2, Max: 3 * time.Minute},
+			Queue:      "email",
+		},
+	}})
+
+	tk := q.NewTask("send_email", nil)
+	assert.Equal(t, task.PriorityHigh, tk.Priority)
+	assert.Equal(t, 7, tk.MaxRetries)
+	require.NoError(t, q.Submit(ctx, tk))
+	assert.Equal(t, "email", tk.Labels[QueueLabel])
+
+	own := q.NewTask("send_email", nil)
+	own.Labels = map[string]string{QueueLabel: "bulk"}
+	require.NoError(t, q.Submit(ctx, own))
+	assert.Equal(t, "bulk", own.Labels[QueueLabel])
+
+	other := q.NewTask("resize", nil)
+	assert.Equal(t, task.PriorityLow, other.Priority)
+	assert.Equal(t, 3, other.MaxRetries)
+
+	var budget time.Duration
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		deadline, _ := ctx.Deadline()
+		budget = time.Until(deadline)
+		return errors.New("smtp down")
+	})
+	before := time.Now()
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, budget, time.Second)
+
+	// Newest first, so own ran
+	retried, err := store.GetTask(ctx, own.ID)
+	require.NoError(t, err)
+	require.Equal(t, task.StatusScheduled, retried.Status)
+	assert.WithinDuration(t, before.Add(time.Minute), *retried.ScheduledAt, 5*time.Second)
+
+	policy := RetryPolicy{Initial: time.Minute, Multiplier: 2, Max: 3 * time.Minute}
+	assert.Equal(t, 2*time.Minute, policy.Delay(2))
+	assert.Equal(t, 3*time.Minute, policy.Delay(5))
+	assert.Equal(t, time.Minute, RetryPolicy{Initial: time.Minute}.Delay(4))
+
+	assert.Error(t, q.SetTypeConfig("send_email", TypeConfig{RateLimit: -1}))
+	assert.Error(t, q.SetTypeConfig("send_email", TypeConfig{Retry: &RetryPolicy{Initial: time.Second, Multiplier: 0.5}}))
+}
+
+func TestQueue_TypeRateLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	require.NoError(t, q.SetTypeConfig("call_webhook", TypeConfig{RateLimit: 1}))
+
+	ran := 0
+	q.RegisterHandler("call_webhook", func(ctx context.Context, t *task.Task) error {
+		ran++
+		return nil
+	})
+	first := task.NewTask("call_webhook", task.PriorityLow, nil)
+	second := task.NewTask("call_webhook", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, first))
+	require.NoError(t, q.Submit(ctx, second))
+
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ran)
+
+	held, err := store.GetTask(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, held.Status)
+	assert.WithinDuration(t, time.Now().Add(time.Second), *held.ScheduledAt, time.Second)
+}
+
+func TestQueue_TypeConcurrency(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond})
+	require.NoError(t, q.SetTypeConfig("export", TypeConfig{Concurrency: 1}))
+
+	var exports, resizes atomic.Int32
+	proceed := make(chan struct{})
+	q.RegisterHandler("export", func(ctx context.Context, t *task.Task) error {
+		exports.Add(1)
+		defer exports.Add(-1)
+		<-proceed
+		return nil
+	})
+	q.RegisterHandler("resize", func(ctx context.Context, t *task.Task) error {
+		resizes.Add(1)
+		defer resizes.Add(-1)
+		<-proceed
+		return nil
+	})
+	var tasks []*task.Task
+	for _, taskType := range []string{"export", "export", "export", "resize", "resize"} {
+		tk := task.NewTask(taskType, task.PriorityLow, nil)
+		require.NoError(t, q.Submit(ctx, tk))
+		tasks = append(tasks, tk)
+	}
+
+	q.Start(ctx, 3)
+	defer q.Stop()
+
+	// The exports over the limit leave the other workers to the resizes
+	require.Eventually(t, func() bool { return exports.Load() == 1 && resizes.Load() == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), exports.Load())
+	assert.Equal(t, 1, q.DebugState().Workers.Types["export"].Running)
+
+	for range tasks {
+		proceed <- struct{}{}
+		assert.LessOrEqual(t, exports.Load(), int32(1))
+	}
+	require.Eventually(t, func() bool {
+		for _, tk := range tasks {
+			got, err := store.GetTask(ctx, tk.ID)
+			if err != nil || got.Status != task.StatusCompleted {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Error(t, q.SetTypeConfig("export", TypeConfig{Concurrency: -1}))
+}
+
+func TestLoadTypeConfigs(t *testing.T) {
+	path := t.TempDir() + "/types.json"
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"send_email": {"priority": "high", "max_retries": 0, "timeout": "30s", "retry": {"initial": "2s", "multiplier": 3, "max": "1m"}, "queue": "email"},
+		"resize": {"priority": 80, "rate_limit": 2.5}
+	}`), 0o600))
+
+	configs, err := LoadTypeConfigs(path)
+	require.NoError(t, err)
+	email := configs["send_email"]
+	assert.Equal(t, task.PriorityHigh, *email.Priority)
+	assert.Equal(t, 0, *email.MaxRetries)
+	assert.Equal(t, 30*time.Second, email.Timeout)
+	assert.Equal(t, &RetryPolicy{Initial: 2 * time.Second, Multiplier: 3, Max: time.Minute}, email.Retry)
+	assert.Equal(t, task.Priority(80), *configs["resize"].Priority)
+	assert.Equal(t, 2.5, configs["resize"].RateLimit)
+
+	data, err := json.Marshal(email)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"priority": 66, "max_retries": 0, "timeout": "30s", "retry": {"initial": "2s", "multiplier": 3, "max": "1m0s"}, "queue": "email"}`, string(data))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"resize": {"timeout": "soon"}}`), 0o600))
+	_, err = LoadTypeConfigs(path)
+	assert.ErrorContains(t, err, "invalid timeout")
+	require.NoError(t, os.WriteFile(path, []byte(`{"resize": {"priority": 200}}`), 0o600))
+	_, err = LoadTypeConfigs(path)
+	assert.ErrorContains(t, err, "priority must be between")
+}
+
+func TestQueue_DisabledTypes(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), DisabledTypeDelay: time.Millisecond})
+
+	ran := 0
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		ran++
+		return nil
+	})
+	tk := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+
+	q.SetDisabledTypes([]string{"send_email"})
+	assert.False(t, q.TypeEnabled("send_email"))
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, ran)
+	held, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, held.Status)
+
+	q.SetDisabledTypes(nil)
+	time.Sleep(5 * time.Millisecond)
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ran)
+}
+
+func TestQueue_SetTypeConfigs(t *testing.T) {
+	q := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	require.NoError(t, q.SetTypeConfig("send_email", TypeConfig{Timeout: time.Second}))
+
+	// An invalid config leaves every type as it was
+	err := q.SetTypeConfigs(map[string]TypeConfig{
+		"resize":       {RateLimit: 2},
+		"call_webhook": {RateLimit: -1},
+	})
+	assert.ErrorContains(t, err, "rate_limit must not be negative")
+	assert.Equal(t, map[string]TypeConfig{"send_email": {Timeout: time.Second}}, q.TypeConfigs())
+
+	require.NoError(t, q.SetTypeConfigs(map[string]TypeConfig{"resize": {RateLimit: 2}}))
+	assert.Equal(t, map[string]TypeConfig{"resize": {RateLimit: 2}}, q.TypeConfigs())
+}
+
+func TestQueue_SetWorkers(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond})
+	assert.Error(t, q.SetWorkers(2), "not started")
+
+	var running atomic.Int32
+	proceed := make(chan struct{})
+	q.RegisterHandler("resize", func(ctx context.Context, t *task.Task) error {
+		running.Add(1)
+		defer running.Add(-1)
+		<-proceed
+		return nil
+	})
+	submit := func(n int) []*task.Task {
+		tasks := make([]*task.Task, n)
+		for i := range tasks {
+			tasks[i] = task.NewTask("resize", task.PriorityLow, nil)
+			require.NoError(t, q.Submit(ctx, tasks[i]))
+		}
+		return tasks
+	}
+	completed := func(tasks []*task.Task) bool {
+		for _, tk := range tasks {
+			got, err := store.GetTask(ctx, tk.ID)
+			if err != nil || got.Status != task.StatusCompleted {
+				return false
+			}
+		}
+		return true
+	}
+
+	q.Start(ctx, 1)
+	defer q.Stop()
+	first := submit(3)
+	require.Eventually(t, func() bool { return running.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, q.SetWorkers(3))
+	assert.Equal(t, 3, q.Workers())
+	require.Eventually(t, func() bool { return running.Load() == 3 }, time.Second, 10*time.Millisecond)
+
+	// Shrinking lets the running tasks finish
+	require.NoError(t, q.SetWorkers(1))
+	assert.Equal(t, 1, q.Workers())
+	for range first {
+		proceed <- struct{}{}
+	}
+	require.Eventually(t, func() bool { return completed(first) }, time.Second, 10*time.Millisecond)
+
+	// and leaves one worker running tasks after that
+	second := submit(2)
+	require.Eventually(t, func() bool { return running.Load() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), running.Load())
+	for range second {
+		proceed <- struct{}{}
+	}
+	require.Eventually(t, func() bool { return completed(second) }, time.Second, 10*time.Millisecond)
+
+	assert.Error(t, q.SetWorkers(0))
+}
+
+func TestQueue_TaskLogs(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), TaskLogBytes: 400})
+	require.NoError(t, q.SetTypeConfig("call_webhook", TypeConfig{Retry: &RetryPolicy{Initial: time.Millisecond}}))
+
+	q.RegisterHandler("call_webhook", func(ctx context.Context, tk *task.Task) error {
+		logger := task.LoggerFromContext(ctx)
+		logger.Debug("calling webhook", zap.String("url", "https://example.com/hook"))
+		if tk.RetryCount == 0 {
+			return errors.New("connection refused")
+		}
+		logger.Info("webhook answered", zap.Int("status", 200))
+		return nil
+	})
+	tk := task.NewTask("call_webhook", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	logs, err := q.TaskLogs(ctx, tk.ID)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(logs)), "\n")
+	require.Len(t, lines, 3, string(logs))
+	var first, last map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &last))
+	assert.Equal(t, "calling webhook", first["msg"])
+	assert.Equal(t, "debug", first["level"])
+	assert.Equal(t, float64(1), first["attempt"])
+	assert.Equal(t, "sync", first["worker"])
+	assert.Equal(t, "webhook answered", last["msg"])
+	assert.Equal(t, float64(2), last["attempt"])
+
+	// Only the most recent lines are kept
+	for i := 0; i < 3; i++ {
+		q.saveLogs(ctx, tk, &logBuffer{data: []byte(strings.Repeat("x", 150) + "\n"), limit: 400}, zap.NewNop())
+	}
+	logs, err = q.TaskLogs(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(strings.Repeat("x", 150)+"\n", 2), string(logs))
+}
+
+func TestQueue_PermanentAndRetryAfter(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		if t.Payload["recipient"] == "gone@example.com" {
+			return task.Permanent(errors.New("mailbox does not exist"))
+		}
+		return task.RetryAfter(errors.New("rate limited"), time.Hour)
+	})
+	gone := task.NewTask("send_email", task.PriorityLow, map[string]interface{}{"recipient": "gone@example.com"})
+	require.NoError(t, q.Submit(ctx, gone))
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	got, err := store.GetTask(ctx, gone.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, got.Status, "no retries for permanent failures")
+	assert.Equal(t, 0, got.RetryCount)
+
+	limited := task.NewTask("send_email", task.PriorityLow, map[string]interface{}{"recipient": "ada@example.com"})
+	require.NoError(t, q.Submit(ctx, limited))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	got, err = store.GetTask(ctx, limited.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, got.Status)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *got.ScheduledAt, time.Minute)
+}
+
+func TestQueue_Reschedule(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+
+	var runs atomic.Int32
+	q.RegisterHandler("await_export", func(ctx context.Context, t *task.Task) error {
+		if t.Payload["later"] == true {
+			return task.Reschedule(time.Hour)
+		}
+		if runs.Add(1) == 1 {
+			// Due at once, so the next poll runs it again
+			return task.RescheduleAt(time.Now())
+		}
+		return nil
+	})
+
+	// Rescheduling uses no retry, even for a task that has none
+	ready := task.NewTask("await_export", task.PriorityLow, nil)
+	ready.MaxRetries = 0
+	require.NoError(t, q.Submit(ctx, ready))
+	for i := 0; i < 2; i++ {
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+	got, err := store.GetTask(ctx, ready.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, got.Status)
+	assert.Equal(t, 0, got.RetryCount)
+	require.Len(t, got.Attempts, 2)
+	assert.Equal(t, task.OutcomeRescheduled, got.Attempts[0].Outcome)
+	assert.Empty(t, got.Attempts[0].Error)
+	assert.Equal(t, 1, got.Attempts[1].Number)
+
+	later := task.NewTask("await_export", task.PriorityLow, map[string]interface{}{"later": true})
+	require.NoError(t, q.Submit(ctx, later))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	got, err = store.GetTask(ctx, later.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusScheduled, got.Status)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *got.ScheduledAt, time.Minute)
+
+	// A task that would run past its deadline expires instead
+	late := task.NewTask("await_export", task.PriorityLow, map[string]interface{}{"later": true})
+	deadline := time.Now().Add(time.Minute)
+	late.Deadline = &deadline
+	require.NoError(t, q.Submit(ctx, late))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	got, err = store.GetTask(ctx, late.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusExpired, got.Status)
+}
+
+func TestQueue_Checkpoint(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+
+	type progress struct {
+		Next int `json:"next"`
+	}
+	var processed []int
+	q.RegisterHandler("import_rows", func(ctx context.Context, tk *task.Task) error {
+		var p progress
+		_, err := tk.LoadCheckpoint(&p)
+		require.NoError(t, err)
+		for i := p.Next; i < 6; i++ {
+			if i == 3 && tk.Attempt() == 1 {
+				// The checkpoint is already stored when the attempt dies
+				stored, err := store.GetTask(ctx, tk.ID)
+				require.NoError(t, err)
+				assert.JSONEq(t, `{"next":3}`, string(stored.Checkpoint()))
+				return errors.New("worker lost its connection")
+			}
+			processed = append(processed, i)
+			require.NoError(t, tk.SaveCheckpoint(ctx, progress{Next: i + 1}))
+		}
+		return nil
+	})
+
+	imp := task.NewTask("import_rows", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, imp))
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	// Release the retry at once
+	got, err := store.GetTask(ctx, imp.ID)
+	require.NoError(t, err)
+	now := time.Now()
+	got.ScheduledAt = &now
+	require.NoError(t, store.UpdateTask(ctx, got))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	got, err = store.GetTask(ctx, imp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, got.Status)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, processed, "the retry resumes from the checkpoint")
+	assert.Equal(t, 2, got.LastCheckpoint.Attempt)
+
+	big := make([]byte, task.MaxCheckpointSize)
+	assert.ErrorIs(t, task.NewTask("x", task.PriorityLow, nil).SaveCheckpoint(ctx, big), task.ErrCheckpointTooLarge)
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), CancelCheckInterval: 10 * time.Millisecond})
+
+	var runs atomic.Int32
+	started := make(chan struct{}, 1)
+	q.RegisterHandler("long", func(ctx context.Context, t *task.Task) error {
+		runs.Add(1)
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// A waiting task never runs
+	waiting := task.NewTask("long", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, waiting))
+	cancelled, err := q.Cancel(ctx, waiting.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCancelled, cancelled.Status)
+	ran, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, ran)
+	assert.Equal(t, int32(0), runs.Load())
+
+	// A running task's handler is stopped, and its failure not retried
+	running := task.NewTask("long", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, running))
+	done := make(chan struct{})
+	go func() {
+		q.ProcessOne(ctx)
+		close(done)
+	}()
+	<-started
+	_, err = q.Cancel(ctx, running.ID)
+	require.NoError(t, err)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled handler kept running")
+	}
+
+	got, err := store.GetTask(ctx, running.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCancelled, got.Status)
+	assert.Equal(t, 0, got.RetryCount)
+
+	_, err = q.Cancel(ctx, running.ID)
+	assert.True(t, errors.Is(err, errs.ErrInvalidTransition))
+}
+
+func TestQueue_RetryBudget(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	budget := RetryBudget{Ratio: 0.5, MinRetries: 1, Delay: time.Hour}
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), RetryBudget: budget})
+	q.RegisterHandler("flaky", func(ctx context.Context, t *task.Task) error {
+		return errors.New("downstream unavailable")
+	})
+
+	var tasks []*task.Task
+	for i := 0; i < 4; i++ {
+		tk := task.NewTask("flaky", task.PriorityLow, nil)
+		require.NoError(t, q.Submit(ctx, tk))
+		tasks = append(tasks, tk)
+	}
+
+	// Four new tasks allow two retries
+	for i := 0; i < 3; i++ {
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+	var soon, held int
+	var left *task.Task
+	for _, tk := range tasks {
+		got, err := store.GetTask(ctx, tk.ID)
+		require.NoError(t, err)
+		switch {
+		case got.Status == task.StatusPending:
+			left = got
+		case got.ScheduledAt.After(time.Now().Add(50 * time.Minute)):
+			held++
+		default:
+			soon++
+		}
+	}
+	require.NotNil(t, left)
+	assert.Equal(t, 2, soon)
+	assert.Equal(t, 1, held, "the retry over the budget waits for its delay")
+
+	budget.Action = BudgetFail
+	failing := NewQueue(Config{Storage: store, Logger: zap.NewNop(), RetryBudget: budget})
+	failing.RegisterHandler("flaky", func(ctx context.Context, t *task.Task) error {
+		return errors.New("downstream unavailable")
+	})
+	_, err := failing.ProcessOne(ctx)
+	require.NoError(t, err)
+	got, err := store.GetTask(ctx, left.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, got.Status)
+	assert.Contains(t, got.Error, "retry budget exhausted")
+
+	_, err = ParseBudgetAction("drop")
+	assert.Error(t, err)
+}
+
+func TestQueue_PoisonTasks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	retry := &RetryPolicy{Initial: time.Millisecond}
+	require.NoError(t, q.SetTypeConfig("crash", TypeConfig{Retry: retry}))
+	require.NoError(t, q.SetTypeConfig("hang", TypeConfig{Retry: retry, Timeout: 10 * time.Millisecond}))
+	q.RegisterHandler("crash", func(ctx context.Context, t *task.Task) error {
+		panic("nil map")
+	})
+	q.RegisterHandler("hang", func(ctx context.Context, t *task.Task) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	crash := task.NewTask("crash", task.PriorityLow, nil)
+	crash.MaxRetries = 10
+	hang := task.NewTask("hang", task.PriorityLow, nil)
+	hang.MaxRetries = 10
+	require.NoError(t, q.Submit(ctx, crash))
+	require.NoError(t, q.Submit(ctx, hang))
+
+	for i := 0; i < 20; i++ {
+		time.Sleep(5 * time.Millisecond)
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+
+	for _, tk := range []*task.Task{crash, hang} {
+		got, err := store.GetTask(ctx, tk.ID)
+		require.NoError(t, err)
+		assert.Equal(t, task.StatusFailed, got.Status, tk.Type)
+		assert.Equal(t, "true", got.Labels[task.PoisonLabel], tk.Type)
+		assert.Equal(t, 3, got.Strikes, tk.Type)
+		assert.Equal(t, 2, got.RetryCount, "quarantined with retries left")
+		assert.Contains(t, got.Error, "quarantined as poison")
+	}
+
+	poisoned, _, err := q.ListTasks(ctx, task.StatusFailed, map[string]string{task.PoisonLabel: "true"}, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, poisoned, 2)
+}
+
+func TestQueue_ErrorCategories(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PoisonThreshold: -1})
+	q.RegisterHandler("fetch", func(ctx context.Context, tk *task.Task) error {
+		return fmt.Errorf("GET /users/%v: status 503", tk.Payload["user"])
+	})
+	q.RegisterHandler("parse", func(ctx context.Context, tk *task.Task) error {
+		return task.InvalidPayload(errors.New("missing field: email"))
+	})
+	q.RegisterHandler("crash", func(ctx context.Context, tk *task.Task) error {
+		panic("nil map")
+	})
+
+	var tasks []*task.Task
+	for i, taskType := range []string{"fetch", "fetch", "fetch", "parse", "crash", "unknown"} {
+		tk := task.NewTask(taskType, task.PriorityLow, map[string]interface{}{"user": i})
+		tk.MaxRetries = 0
+		require.NoError(t, q.Submit(ctx, tk))
+		tasks = append(tasks, tk)
+	}
+	for range tasks {
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+	}
+
+	want := []task.ErrorCategory{
+		task.CategoryHandler, task.CategoryHandler, task.CategoryHandler,
+		task.CategoryInvalidPayload, task.CategoryPanic, task.CategoryNoHandler,
+	}
+	for i, tk := range tasks {
+		got, err := store.GetTask(ctx, tk.ID)
+		require.NoError(t, err)
+		assert.Equal(t, task.StatusFailed, got.Status, tk.Type)
+		assert.Equal(t, want[i], got.ErrorCategory, tk.Type)
+	}
+
+	top, err := q.TopErrors(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Len(t, top["fetch"], 1, "failures differing only in numbers share a signature")
+	assert.Equal(t, "GET /users/<n>: status <n>", top["fetch"][0].Signature)
+	assert.Equal(t, int64(3), top["fetch"][0].Count)
+	assert.Equal(t, task.CategoryInvalidPayload, top["parse"][0].Category)
+	assert.Equal(t, task.CategoryNoHandler, top["unknown"][0].Category)
+}
+
+func TestQueue_SandboxLimits(t *testing.T) {
+	ctx := context.Background()
+
+	// Memory hints: two 60 MiB tasks do not fit a 100 MiB limit together
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), MemoryLimitMB: 100, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, q.SetTypeConfig("big", TypeConfig{MemoryMB: 60}))
+	var running, most atomic.Int32
+	q.RegisterHandler("big", func(ctx context.Context, t *task.Task) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	var big []*task.Task
+	for i := 0; i < 4; i++ {
+		tk := task.NewTask("big", task.PriorityLow, nil)
+		require.NoError(t, q.Submit(ctx, tk))
+		big = append(big, tk)
+	}
+	q.Start(ctx, 3)
+	require.Eventually(t, func() bool {
+		for _, tk := range big {
+			if got, err := store.GetTask(ctx, tk.ID); err != nil || got.Status != task.StatusCompleted {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	q.Stop()
+	assert.Equal(t, int32(1), most.Load(), "one big task at a time")
+
+	// Weights: a heavy task leaves room for light ones but not another heavy
+	store = storage.NewMemoryStorage()
+	q = NewQueue(Config{Storage: store, Logger: zap.NewNop(), WeightBudget: 6, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, q.SetTypeConfig("batch_process", TypeConfig{Weight: 4}))
+	var heavy, heaviest atomic.Int32
+	q.RegisterHandler("batch_process", func(ctx context.Context, t *task.Task) error {
+		n := heavy.Add(1)
+		defer heavy.Add(-1)
+		for m := heaviest.Load(); n > m && !heaviest.CompareAndSwap(m, n); m = heaviest.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	q.RegisterHandler("light", func(ctx context.Context, t *task.Task) error { return nil })
+	var weighed []*task.Task
+	for i := 0; i < 3; i++ {
+		tk := task.NewTask("batch_process", task.PriorityLow, nil)
+		require.NoError(t, q.Submit(ctx, tk))
+		weighed = append(weighed, tk)
+	}
+	light := task.NewTask("light", task.PriorityLow, nil)
+	light.Weight = 2
+	require.NoError(t, q.Submit(ctx, light))
+	weighed = append(weighed, light)
+	assert.Equal(t, 2, q.weightOf(light))
+	q.Start(ctx, 4)
+	require.Eventually(t, func() bool {
+		for _, tk := range weighed {
+			if got, err := store.GetTask(ctx, tk.ID); err != nil || got.Status != task.StatusCompleted {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	q.Stop()
+	assert.Equal(t, int32(1), heaviest.Load(), "one heavy task at a time")
+
+	// CPU time: a handler spinning past its limit is cancelled as a timeout
+	if runtime.GOOS == "linux" {
+		store = storage.NewMemoryStorage()
+		q = NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+		require.NoError(t, q.SetTypeConfig("spin", TypeConfig{CPUTime: 100 * time.Millisecond}))
+		q.RegisterHandler("spin", func(ctx context.Context, t *task.Task) error {
+			for ctx.Err() == nil {
+			}
+			return ctx.Err()
+		})
+		spin := task.NewTask("spin", task.PriorityLow, nil)
+		spin.MaxRetries = 0
+		require.NoError(t, q.Submit(ctx, spin))
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+		got, err := store.GetTask(ctx, spin.ID)
+		require.NoError(t, err)
+		assert.Equal(t, task.StatusFailed, got.Status)
+		assert.Contains(t, got.Error, "cpu time limit exceeded")
+		assert.Equal(t, task.CategoryTimeout, got.ErrorCategory)
+	}
+
+	// Leaks: a handler ignoring its timeout is reported, then finishes
+	core, logs := observer.New(zap.WarnLevel)
+	store = storage.NewMemoryStorage()
+	q = NewQueue(Config{Storage: store, Logger: zap.New(core), LeakGrace: 10 * time.Millisecond})
+	require.NoError(t, q.SetTypeConfig("stubborn", TypeConfig{Timeout: 10 * time.Millisecond}))
+	q.RegisterHandler("stubborn", func(ctx context.Context, t *task.Task) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, q.Submit(ctx, task.NewTask("stubborn", task.PriorityLow, nil)))
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, logs.FilterMessage("handler ignores cancellation and is still running").Len())
+	assert.Equal(t, 1, logs.FilterMessage("leaked handler returned").Len())
+}
+
+// testPeers passes notifications over a channel shared by the test's queues
+type testPeers struct {
+	wake   chan struct{}
+	listen bool
+}
+
+func (p *testPeers) Notify(ctx context.Context) error {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *testPeers) Listen(ctx context.Context, wake func()) {
+	if !p.listen {
+		<-ctx.Done()
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+			wake()
+		}
+	}
+}
+
+func TestQueue_ShutdownHandoff(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	wake := make(chan struct{}, 1)
+
+	// The peer polls only when woken
+	var peerRan atomic.Int32
+	peer := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: time.Hour, Peers: &testPeers{wake: wake, listen: true}})
+	peer.RegisterHandler("work", func(ctx context.Context, t *task.Task) error {
+		peerRan.Add(1)
+		return nil
+	})
+	peer.Start(ctx, 2)
+	defer peer.Stop()
+	// Its first poll finds nothing, rather than racing the submissions
+	require.Eventually(t, func() bool { return len(peer.wake) == 0 }, time.Second, time.Millisecond)
+
+	running := make(chan struct{})
+	unblock := make(chan struct{})
+	var once sync.Once
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), Prefetch: 5, Peers: &testPeers{wake: wake}})
+	q.RegisterHandler("work", func(ctx context.Context, t *task.Task) error {
+		once.Do(func() { close(running) })
+		<-unblock
+		return nil
+	})
+	for i := 0; i < 4; i++ {
+		require.NoError(t, q.Submit(ctx, task.NewTask("work", task.PriorityLow, nil)))
+	}
+	q.Start(ctx, 1)
+	<-running
+	require.Eventually(t, func() bool {
+		q.bufferedMu.Lock()
+		defer q.bufferedMu.Unlock()
+		return len(q.buffered) == 3
+	}, time.Second, 5*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+
+	// The prefetched tasks run on the peer while the running one finishes
+	require.Eventually(t, func() bool { return peerRan.Load() == 3 }, 2*time.Second, 5*time.Millisecond)
+	close(unblock)
+	<-stopped
+
+	// The peer records the last of them once its handler returns
+	require.Eventually(t, func() bool {
+		completed, _, err := q.ListTasks(ctx, task.StatusCompleted, nil, "", 10)
+		return err == nil && len(completed) == 4
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestQueue_SlowTasks(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.WarnLevel)
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.New(core), SlowTaskFactor: 3, SlowTaskMinRuns: 5})
+
+	var delay atomic.Int64
+	delay.Store(int64(5 * time.Millisecond))
+	q.RegisterHandler("fetch", func(ctx context.Context, t *task.Task) error {
+		time.Sleep(time.Duration(delay.Load()))
+		return nil
+	})
+	var slow []string
+	var mu sync.Mutex
+	q.OnSlow(func(ctx context.Context, t *task.Task) {
+		mu.Lock()
+		defer mu.Unlock()
+		slow = append(slow, t.ID)
+	})
+
+	run := func() *task.Task {
+		tk := task.NewTask("fetch", task.PriorityLow, nil)
+		require.NoError(t, q.Submit(ctx, tk))
+		_, err := q.ProcessOne(ctx)
+		require.NoError(t, err)
+		return tk
+	}
+
+	// Nothing is slow until there is a history to compare with
+	delay.Store(int64(200 * time.Millisecond))
+	run()
+	delay.Store(int64(5 * time.Millisecond))
+	for i := 0; i < 25; i++ {
+		run()
+	}
+	mu.Lock()
+	assert.Empty(t, slow)
+	mu.Unlock()
+
+	delay.Store(int64(200 * time.Millisecond))
+	tk := run()
+	mu.Lock()
+	assert.Equal(t, []string{tk.ID}, slow)
+	mu.Unlock()
+	assert.Equal(t, 1, logs.FilterMessage("task running far longer than usual").Len())
+
+	got, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, got.Status, "a slow task is only reported")
+}
+
+func TestQueue_FireAndForget(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Types: map[string]TypeConfig{
+		"page_view": {FireAndForget: true, Retry: &RetryPolicy{Initial: 20 * time.Millisecond}},
+	}})
+
+	calls := 0
+	q.RegisterHandler("page_view", func(ctx context.Context, t *task.Task) error {
+		calls++
+		if calls == 1 {
+			return errors.New("collector unavailable")
+		}
+		return nil
+	})
+	var completed []string
+	q.OnComplete(func(ctx context.Context, t *task.Task) {
+		completed = append(completed, t.ID)
+	})
+
+	tk := task.NewTask("page_view", task.PriorityLow, map[string]interface{}{"path": "/"})
+	require.NoError(t, q.Submit(ctx, tk))
+
+	// Nothing is recorded, only pushed onto the untracked list
+	_, err := store.GetTask(ctx, tk.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+	stats, err := q.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats["pending"])
+	assert.Equal(t, int64(1), stats["untracked"])
+
+	// The failed attempt goes back on the list until its retry is due
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	ran, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, ran)
+	assert.Equal(t, 1, calls)
+
+	time.Sleep(30 * time.Millisecond)
+	ran, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, ran)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []string{tk.ID}, completed)
+
+	n, err := store.CountUntracked(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	_, err = store.GetTask(ctx, tk.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+}
+
+func TestQueue_FireAndForgetEncrypted(t *testing.T) {
+	ctx := context.Background()
+	keys, err := storage.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	inner := storage.NewMemoryStorage()
+	types := map[string]TypeConfig{"page_view": {FireAndForget: true}}
+	q := NewQueue(Config{Storage: storage.NewEncryptedStorage(inner, keys), Types: types})
+
+	var path interface{}
+	q.RegisterHandler("page_view", func(ctx context.Context, t *task.Task) error {
+		path = t.Payload["path"]
+		return nil
+	})
+	tk := task.NewTask("page_view", task.PriorityLow, map[string]interface{}{"path": "/pricing"})
+	require.NoError(t, q.Submit(ctx, tk))
+
+	// Untracked through the encryption, with the payload sealed on the list
+	_, err = inner.GetTask(ctx, tk.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+	pushed, err := inner.PopUntracked(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, pushed, 1)
+	assert.Nil(t, pushed[0].Payload)
+	assert.NotEmpty(t, pushed[0].EncryptedPayload)
+	require.NoError(t, inner.PushUntracked(ctx, pushed[0]))
+
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "/pricing", path)
+
+	// Storage hiding the untracked list behind it gets a warning
+	core, logs := observer.New(zap.WarnLevel)
+	hidden := struct{ storage.Storage }{storage.NewMemoryStorage()}
+	NewQueue(Config{Storage: hidden, Logger: zap.New(core), Types: types})
+	assert.Equal(t, 1, logs.FilterField(zap.String("type", "page_view")).Len())
+}
+
+// shardedStorage pretends MemoryStorage has four status index shards, each
+// task's shard being the first byte of its ID modulo four
+type shardedStorage struct {
+	*storage.MemoryStorage
+	read [][]int
+}
+
+func (s *shardedStorage) StatusShards() int { return 4 }
+
+func (s *shardedStorage) GetTasksByShards(ctx context.Context, status task.Status, shards []int, limit int) ([]*task.Task, error) {
+	s.read = append(s.read, shards)
+	all, err := s.GetTasksByStatus(ctx, status, 1000)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*task.Task
+	for _, t := range all {
+		for _, shard := range shards {
+			if int(t.ID[0])%4 == shard && len(tasks) < limit {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+func TestQueue_PollPartition(t *testing.T) {
+	store := &shardedStorage{MemoryStorage: storage.NewMemoryStorage()}
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	ctx := context.Background()
+
+	assert.Nil(t, q.ownShards())
+	q.SetPollPartition(0, 3)
+	assert.Equal(t, []int{0, 3}, q.ownShards())
+	q.SetPollPartition(1, 3)
+	assert.Equal(t, []int{1}, q.ownShards())
+	// More processes than shards share them
+	q.SetPollPartition(5, 6)
+	assert.Equal(t, []int{1}, q.ownShards())
+
+	mine := task.NewTask("work", task.PriorityLow, nil)
+	mine.ID = "a" // 'a' is 97, shard 1
+	theirs := task.NewTask("work", task.PriorityHigh, nil)
+	theirs.ID = "b"
+	require.NoError(t, q.Submit(ctx, mine))
+	require.NoError(t, q.Submit(ctx, theirs))
+
+	// Only this process's shard is read while it has tasks
+	q.SetPollPartition(1, 3)
+	tasks, err := q.pollStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "a", tasks[0].ID)
+
+	// Once it is empty, every shard is read
+	require.NoError(t, store.DeleteTask(ctx, "a"))
+	tasks, err = q.pollStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "b", tasks[0].ID)
+	assert.Equal(t, [][]int{{1}, {1}}, store.read)
+
+	q.SetPollPartition(0, 1)
+	assert.Nil(t, q.ownShards())
+}
+
+func TestQueue_PollPartitionEncrypted(t *testing.T) {
+	ctx := context.Background()
+	keys, err := storage.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	store := &shardedStorage{MemoryStorage: storage.NewMemoryStorage()}
+	q := NewQueue(Config{Storage: storage.NewEncryptedStorage(store, keys), Logger: zap.NewNop()})
+
+	// The shards are found through the encryption, and tasks read from
+	// them are decrypted
+	q.SetPollPartition(1, 3)
+	assert.Equal(t, []int{1}, q.ownShards())
+	mine := task.NewTask("work", task.PriorityLow, map[string]interface{}{"n": "1"})
+	mine.ID = "a"
+	require.NoError(t, q.Submit(ctx, mine))
+	tasks, err := q.pollStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "1", tasks[0].Payload["n"])
+	assert.Equal(t, [][]int{{1}}, store.read)
+
+	// Without shards, setting a partition warns that it does nothing
+	core, logs := observer.New(zap.WarnLevel)
+	unsharded := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.New(core)})
+	unsharded.SetPollPartition(0, 3)
+	assert.Nil(t, unsharded.ownShards())
+	assert.Equal(t, 1, logs.Len())
+}
+
+func TestQueue_Doctor(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), TaskTimeout: time.Minute})
+	ctx := context.Background()
+
+	started := func(instance string, ago time.Duration) *task.Task {
+		tk := task.NewTask("work", task.PriorityMedium, nil)
+		require.NoError(t, store.SaveTask(ctx, tk))
+		tk.MarkStartedAt("worker-0", time.Now().Add(-ago))
+		tk.Instance = instance
+		require.NoError(t, store.UpdateTask(ctx, tk))
+		return tk
+	}
+	running := started("node-a", time.Second)
+	crashed := started("node-b", time.Second)
+	stuck := started("", time.Hour)
+
+	opts := DoctorOptions{LiveInstances: []string{"node-a"}}
+	report, err := q.Doctor(ctx, opts)
+	require.NoError(t, err)
+	assert.False(t, report.IndexesChecked)
+	assert.Equal(t, 2, report.Orphaned)
+	got, _ := store.GetTask(ctx, crashed.ID)
+	assert.Equal(t, task.StatusProcessing, got.Status)
+
+	opts.Repair = true
+	report, err = q.Doctor(ctx, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Orphaned)
+	for _, tk := range []*task.Task{crashed, stuck} {
+		got, _ := store.GetTask(ctx, tk.ID)
+		assert.Equal(t, task.StatusRetrying, got.Status)
+		assert.Zero(t, got.RetryCount)
+		assert.Equal(t, task.OutcomeLost, got.Attempts[len(got.Attempts)-1].Outcome)
+	}
+	got, _ = store.GetTask(ctx, running.ID)
+	assert.Equal(t, task.StatusProcessing, got.Status)
+}
+
+func TestQueue_DoctorLeases(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), TaskTimeout: time.Minute})
+	ctx := context.Background()
+
+	// A handler's lease extension is stored while it runs
+	q.RegisterHandler("export", func(ctx context.Context, tk *task.Task) error {
+		require.NoError(t, tk.ExtendLease(ctx, 5*time.Minute))
+		stored, err := store.GetTask(ctx, tk.ID)
+		require.NoError(t, err)
+		require.NotNil(t, stored.LeaseExpiresAt)
+		assert.WithinDuration(t, time.Now().Add(5*time.Minute), *stored.LeaseExpiresAt, time.Minute)
+		return nil
+	})
+	require.NoError(t, q.Submit(ctx, task.NewTask("export", task.PriorityLow, nil)))
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	leased := func(instance string, lease time.Duration) *task.Task {
+		tk := task.NewTask("export", task.PriorityMedium, nil)
+		require.NoError(t, store.SaveTask(ctx, tk))
+		tk.MarkStartedAt("worker-0", time.Now().Add(-time.Hour))
+		tk.Instance = instance
+		expires := time.Now().Add(lease)
+		tk.LeaseExpiresAt = &expires
+		require.NoError(t, store.UpdateTask(ctx, tk))
+		return tk
+	}
+	// Long past its timeout, and its instance missed a heartbeat, but its
+	// handler is still extending its lease
+	long := leased("node-b", 5*time.Minute)
+	// Its instance is live, but the lease ran out
+	hung := leased("node-a", -time.Second)
+
+	report, err := q.Doctor(ctx, DoctorOptions{LiveInstances: []string{"node-a"}, Repair: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Orphaned)
+	got, _ := store.GetTask(ctx, long.ID)
+	assert.Equal(t, task.StatusProcessing, got.Status)
+	got, _ = store.GetTask(ctx, hung.ID)
+	assert.Equal(t, task.StatusRetrying, got.Status)
+	assert.Nil(t, got.LeaseExpiresAt)
+}
+
+// sampleCount returns how many observations histogram h has had
+func sampleCount(t *testing.T, h prometheus.Observer) uint64 {
+	var m dto.Metric
+	require.NoError(t, h.(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestQueue_PipelineMetrics(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond, Prefetch: 2})
+	proceed := make(chan struct{})
+	q.RegisterHandler("pipeline_metrics", func(ctx context.Context, t *task.Task) error {
+		<-proceed
+		return nil
+	})
+
+	lag := sampleCount(t, metrics.DispatchLag)
+	wait := sampleCount(t, metrics.HandlerQueueWait.WithLabelValues("pipeline_metrics"))
+	saves := sampleCount(t, metrics.StorageLatency.WithLabelValues("save"))
+	polls := sampleCount(t, metrics.StorageLatency.WithLabelValues("poll"))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.Submit(ctx, task.NewTask("pipeline_metrics", task.PriorityHigh, nil)))
+	}
+	assert.Equal(t, saves+3, sampleCount(t, metrics.StorageLatency.WithLabelValues("save")))
+
+	// One task runs and the prefetch holds the other two on the high channel
+	q.Start(ctx, 1)
+	defer q.Stop()
+	occupancy := metrics.ChannelOccupancy.WithLabelValues("high")
+	require.Eventually(t, func() bool { return testutil.ToFloat64(occupancy) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Greater(t, sampleCount(t, metrics.StorageLatency.WithLabelValues("poll")), polls)
+
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	require.Eventually(t, func() bool { return testutil.ToFloat64(occupancy) == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, lag+3, sampleCount(t, metrics.DispatchLag))
+	assert.Equal(t, wait+3, sampleCount(t, metrics.HandlerQueueWait.WithLabelValues("pipeline_metrics")))
+}
+
+func TestQueue_PerQueueLimitsAndStats(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{
+		Storage:     store,
+		Logger:      zap.NewNop(),
+		Types:       map[string]TypeConfig{"send_email": {Queue: "email"}, "send_sms": {Queue: "email"}},
+		DepthLimits: DepthLimits{PerQueue: map[string]int64{"email": 2, DefaultQueue: 1}},
+	})
+
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityLow, nil)))
+	require.NoError(t, q.Submit(ctx, task.NewTask("send_sms", task.PriorityLow, nil)))
+	assert.ErrorIs(t, q.Submit(ctx, task.NewTask("send_email", task.PriorityCritical, nil)), errs.ErrQueueFull)
+
+	require.NoError(t, q.Submit(ctx, task.NewTask("export", task.PriorityLow, nil)))
+	assert.ErrorIs(t, q.Submit(ctx, task.NewTask("resize", task.PriorityLow, nil)), errs.ErrQueueFull)
+
+	stats, err := q.GetStats(ctx)
+	require.NoError(t, err)
+	byQueue := stats["by_queue"].(map[string]map[task.Status]int64)
+	assert.Equal(t, int64(2), byQueue["email"][task.StatusPending])
+	assert.Equal(t, int64(1), byQueue[DefaultQueue][task.StatusPending])
+
+	backlog, err := q.Backlog(ctx, DefaultQueue, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"export"}, backlog.Types)
+	assert.Equal(t, int64(1), backlog.Pending)
+}
+
+func TestFairness_Order(t *testing.T) {
+	newTask := func(key string, priority task.Priority) *task.Task {
+		tk := task.NewTask("export_data", priority, nil)
+		tk.FairnessKey = key
+		return tk
+	}
+	keys := func(tasks []*task.Task) []string {
+		var out []string
+		for _, tk := range tasks {
+			out = append(out, tk.FairnessKey)
+		}
+		return out
+	}
+
+	// One customer's backlog sits ahead of two others in storage order
+	polled := []*task.Task{
+		newTask("vip", task.PriorityHigh),
+		newTask("a", task.PriorityMedium),
+		newTask("a", task.PriorityMedium),
+		newTask("a", task.PriorityMedium),
+		newTask("b", task.PriorityMedium),
+		newTask("c", task.PriorityMedium),
+	}
+
+	// Off, tasks keep their order
+	off := fairness{}
+	assert.Equal(t, polled, off.order(polled))
+
+	// On, each class is shared round-robin, the lead rotating each poll
+	f := fairness{window: 100}
+	assert.Equal(t, []string{"vip", "b", "c", "a", "a", "a"}, keys(f.order(polled)))
+	assert.Equal(t, []string{"vip", "c", "a", "b", "a", "a"}, keys(f.order(polled)))
+	assert.Equal(t, []string{"vip", "a", "b", "c", "a", "a"}, keys(f.order(polled)))
+
+	// The window widens small polls only
+	assert.Equal(t, 100, f.batch(50))
+	assert.Equal(t, 200, f.batch(200))
+}
+
+func TestQueue_ResourceTags(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	cpu := NewQueue(Config{Storage: store, Logger: zap.NewNop()})
+	gpu := NewQueue(Config{Storage: store, Logger: zap.NewNop(), ResourceTags: map[string]string{"gpu": "true", "region": "eu"}})
+	for _, q := range []*Queue{cpu, gpu} {
+		q.RegisterHandler("train", func(ctx context.Context, t *task.Task) error { return nil })
+		q.RegisterHandler("resize", func(ctx context.Context, t *task.Task) error { return nil })
+	}
+
+	train := task.NewTask("train", task.PriorityHigh, nil)
+	train.Requires = map[string]string{"gpu": "true"}
+	require.NoError(t, cpu.Submit(ctx, train))
+	resize := task.NewTask("resize", task.PriorityLow, nil)
+	require.NoError(t, cpu.Submit(ctx, resize))
+
+	// The CPU worker looks past the GPU task to the one it can run
+	ran, err := cpu.ProcessOne(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, ran)
+	assert.Equal(t, resize.ID, ran.ID)
+	ran, err = cpu.ProcessOne(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, ran)
+
+	// The GPU worker takes it
+	ran, err = gpu.ProcessOne(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, ran)
+	assert.Equal(t, train.ID, ran.ID)
+	assert.Equal(t, task.StatusCompleted, ran.Status)
+
+	// Tags must match in value too
+	train.Requires = map[string]string{"region": "us"}
+	assert.False(t, gpu.CanRun(train))
+
+	tags, err := ParseResourceTags("gpu=true, region=eu")
+	require.NoError(t, err)
+	assert.Equal(t, gpu.ResourceTags(), tags)
+	_, err = ParseResourceTags("gpu")
+	assert.Error(t, err)
+}
+
+// lifecycleHandler counts the calls to its lifecycle methods, failing Init
+// while failInit is set
+type lifecycleHandler struct {
+	failInit            atomic.Bool
+	inits, runs, closes atomic.Int32
+}
+
+func (h *lifecycleHandler) Init(ctx context.Context) error {
+	h.inits.Add(1)
+	if h.failInit.Load() {
+		return errors.New("smtp server unreachable")
+	}
+	return nil
+}
+
+func (h *lifecycleHandler) Handle(ctx context.Context, t *task.Task) error {
+	h.runs.Add(1)
+	return nil
+}
+
+func (h *lifecycleHandler) Close() { h.closes.Add(1) }
+
+func TestQueue_HandlerLifecycle(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond, DisabledTypeDelay: 20 * time.Millisecond})
+
+	h := &lifecycleHandler{}
+	h.failInit.Store(true)
+	q.Register("send_email", h)
+	assert.Equal(t, int32(0), h.inits.Load(), "Init waits for Start")
+
+	tk := task.NewTask("send_email", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+	q.Start(ctx, 1)
+
+	// Held back while Init fails, then run once it succeeds
+	require.Eventually(t, func() bool { return h.inits.Load() >= 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(0), h.runs.Load())
+	h.failInit.Store(false)
+	require.Eventually(t, func() bool {
+		got, err := store.GetTask(ctx, tk.ID)
+		return err == nil && got.Status == task.StatusCompleted
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int32(0), h.closes.Load())
+	q.Stop()
+	q.Stop()
+	assert.Equal(t, int32(1), h.closes.Load(), "closed once, after stopping")
+}
+
+func TestQueue_HandlerHealthCheck(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond, HandlerHealthInterval: 10 * time.Millisecond})
+
+	var smtpDown atomic.Bool
+	smtpDown.Store(true)
+	var sent atomic.Int32
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error {
+		sent.Add(1)
+		return nil
+	})
+	q.RegisterHealthCheck("send_email", func(ctx context.Context) error {
+		if smtpDown.Load() {
+			return errors.New("smtp server unreachable")
+		}
+		return nil
+	})
+	q.RegisterHandler("resize", func(ctx context.Context, t *task.Task) error { return nil })
+
+	email := task.NewTask("send_email", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, email))
+	resize := task.NewTask("resize", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, resize))
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	// Other types carry on while the email handler is unhealthy, and its
+	// tasks wait pending rather than fail
+	require.Eventually(t, func() bool {
+		got, err := store.GetTask(ctx, resize.ID)
+		return err == nil && got.Status == task.StatusCompleted
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{"send_email": "smtp server unreachable"}, q.UnhealthyHandlers())
+	assert.Equal(t, int32(0), sent.Load())
+	got, err := store.GetTask(ctx, email.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, got.Status)
+
+	smtpDown.Store(false)
+	require.Eventually(t, func() bool {
+		got, err := store.GetTask(ctx, email.ID)
+		return err == nil && got.Status == task.StatusCompleted
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, q.UnhealthyHandlers())
+}
+
+func TestQueue_RemoteWorkers(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	q := NewQueue(Config{Storage: store, Logger: zap.NewNop(), PollInterval: 10 * time.Millisecond, LeaseTimeout: 100 * time.Millisecond})
+	q.RegisterRemote("resize")
+
+	tk := task.NewTask("resize", task.PriorityHigh, map[string]interface{}{"url": "a.png"})
+	tk.MaxRetries = 1
+	require.NoError(t, q.Submit(ctx, tk))
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	// A worker that goes quiet loses its lease, and the task is retried
+	lease, err := q.Lease(ctx, "py-1", []string{"export", "resize"}, time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	assert.Equal(t, tk.ID, lease.Task.ID)
+	require.Eventually(t, func() bool {
+		got, err := store.GetTask(ctx, tk.ID)
+		return err == nil && got.RetryCount == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = q.ReportProgress(lease.ID, Progress{Percent: 10})
+	assert.ErrorIs(t, err, errs.ErrLeaseNotFound)
+
+	// The retry is leased again, kept alive by progress and acked
+	lease, err = q.Lease(ctx, "py-2", []string{"resize"}, 2*time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err := q.ReportProgress(lease.ID, Progress{Percent: float64(i * 30)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, q.Ack(lease.ID, map[string]interface{}{"width": 100.0}))
+	assert.ErrorIs(t, q.Nack(lease.ID, Nack{Error: "late"}), errs.ErrLeaseNotFound, "one outcome per lease")
+
+	require.Eventually(t, func() bool {
+		got, err := store.GetTask(ctx, tk.ID)
+		return err == nil && got.Status == task.StatusCompleted
+	}, time.Second, 10*time.Millisecond)
+	got, err := store.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.RetryCount)
+	assert.Equal(t, map[string]interface{}{"width": 100.0}, got.Result)
+
+	// Nothing offered: the lease request waits out its wait
+	lease, err = q.Lease(ctx, "py-1", []string{"resize"}, 20*time.Millisecond)
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// DefaultQueue is the queue of task types that do not name one with
+// TypeConfig.Queue
+const DefaultQueue = "default"
+
+// depthStatuses are the statuses reported by the queue_depth metric
+var depthStatuses = []task.Status{task.StatusPending, task.StatusProcessing, task.StatusRetrying, task.StatusScheduled}
+
+// queueOf returns the queue taskType is declared in
+func (q *Queue) queueOf(taskType string) string {
+	if c, ok := q.TypeConfig(taskType); ok && c.Queue != "" {
+		return c.Queue
+	}
+	return DefaultQueue
+}
+
+// byQueue sums counts by task type into counts by queue
+func (q *Queue) byQueue(counts map[string]int64) map[string]int64 {
+	queues := make(map[string]int64)
+	for taskType, n := range counts {
+		queues[q.queueOf(taskType)] += n
+	}
+	return queues
+}
+
+// observeQueues sets the queue_depth metric from the status counters
+func (q *Queue) observeQueues(ctx context.Context) {
+	depths := make(map[task.Status]map[string]int64, len(depthStatuses))
+	for _, status := range depthStatuses {
+		counts, err := q.storage.CountTasksByType(ctx, status)
+		if err != nil {
+			q.logger.Warn("failed to count tasks by queue", zap.Error(err))
+			return
+		}
+		depths[status] = q.byQueue(counts)
+	}
+
+	// Start over so queues no longer declared stop being reported
+	metrics.QueueDepth.Reset()
+	for status, queues := range depths {
+		for name, n := range queues {
+			metrics.QueueDepth.WithLabelValues(name, string(status)).Set(float64(n))
+		}
+	}
+}
+
+// ParseQueueLimits parses per-queue depth limits written as
+// "email=1000,bulk=50000"
+func ParseQueueLimits(s string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid queue limit %q (want queue=limit)", part)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit for queue %q: %q", name, value)
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
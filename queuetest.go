@@ -0,0 +1,206 @@
+// Package queuetest drives a queue deterministically in tests: a fake
+// clock, synchronous processing and assertions on the statuses a task
+// moved through, so tests never wait on workers or sleep for backoff.
+package queuetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap/zaptest"
+)
+
+// maxSteps bounds ProcessAll so a task that keeps rescheduling itself
+// fails the test instead of hanging it
+const maxSteps = 10000
+
+// Clock is a fake queue.Clock that only moves when told to
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock stopped at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Harness wraps a queue run synchronously on a fake clock
+type Harness struct {
+	Queue   *queue.Queue
+	Storage storage.Storage
+	Clock   *Clock
+
+	tb       testing.TB
+	recorder *recorder
+}
+
+// New creates a harness around a queue built from cfg. Storage defaults to
+// in-memory storage, Clock to a fake clock at the current time and Logger
+// to one that writes to the test log. Workers are never started; call
+// ProcessOne or ProcessAll to run tasks.
+func New(tb testing.TB, cfg queue.Config) *Harness {
+	tb.Helper()
+
+	if cfg.Storage == nil {
+		cfg.Storage = storage.NewMemoryStorage()
+	}
+	clock, ok := cfg.Clock.(*Clock)
+	if !ok {
+		require.Nil(tb, cfg.Clock, "queuetest: Config.Clock must be a *queuetest.Clock")
+		clock = NewClock(time.Now())
+		cfg.Clock = clock
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zaptest.NewLogger(tb)
+	}
+
+	rec := &recorder{Storage: cfg.Storage, history: make(map[string][]task.Status)}
+	cfg.Storage = rec
+
+	return &Harness{
+		Queue:    queue.NewQueue(cfg),
+		Storage:  rec,
+		Clock:    clock,
+		tb:       tb,
+		recorder: rec,
+	}
+}
+
+// Handle registers handler for taskType
+func (h *Harness) Handle(taskType string, handler queue.TaskHandler) {
+	h.Queue.RegisterHandler(taskType, handler)
+}
+
+// Submit submits t, failing the test on error
+func (h *Harness) Submit(t *task.Task) *task.Task {
+	h.tb.Helper()
+	require.NoError(h.tb, h.Queue.Submit(context.Background(), t))
+	return t
+}
+
+// ProcessOne runs the next ready task and returns it, or nil if nothing is
+// ready at the current clock time
+func (h *Harness) ProcessOne() *task.Task {
+	h.tb.Helper()
+	t, err := h.Queue.ProcessOne(context.Background())
+	require.NoError(h.tb, err)
+	return t
+}
+
+// ProcessAll runs tasks until none are ready and returns how many ran.
+// Tasks waiting for a later time stay put until the clock is advanced.
+func (h *Harness) ProcessAll() int {
+	h.tb.Helper()
+	for n := 0; n < maxSteps; n++ {
+		if h.ProcessOne() == nil {
+			return n
+		}
+	}
+	require.FailNow(h.tb, "queuetest: tasks still ready after maximum steps", "%d", maxSteps)
+	return maxSteps
+}
+
+// Advance moves the clock forward by d
+func (h *Harness) Advance(d time.Duration) {
+	h.Clock.Advance(d)
+}
+
+// Task loads the stored task with id, failing the test if it is missing
+func (h *Harness) Task(id string) *task.Task {
+	h.tb.Helper()
+	t, err := h.Storage.GetTask(context.Background(), id)
+	require.NoError(h.tb, err)
+	return t
+}
+
+// Transitions returns every status the task with id has been stored with,
+// in order
+func (h *Harness) Transitions(id string) []task.Status {
+	return h.recorder.transitions(id)
+}
+
+// AssertStatus checks the stored status of the task with id
+func (h *Harness) AssertStatus(id string, want task.Status) bool {
+	h.tb.Helper()
+	return assert.Equal(h.tb, want, h.Task(id).Status, "status of task %s", id)
+}
+
+// AssertTransitions checks the task with id moved through exactly want
+func (h *Harness) AssertTransitions(id string, want ...task.Status) bool {
+	h.tb.Helper()
+	return assert.Equal(h.tb, want, h.Transitions(id), "transitions of task %s", id)
+}
+
+// recorder keeps the history of statuses each task is stored with
+type recorder struct {
+	storage.Storage
+
+	mu      sync.Mutex
+	history map[string][]task.Status
+}
+
+// Unwrap returns the storage whose writes are recorded
+func (r *recorder) Unwrap() storage.Storage {
+	return r.Storage
+}
+
+func (r *recorder) SaveTask(ctx context.Context, t *task.Task) error {
+	if err := r.Storage.SaveTask(ctx, t); err != nil {
+		return err
+	}
+	r.record(t)
+	return nil
+}
+
+func (r *recorder) UpdateTask(ctx context.Context, t *task.Task) error {
+	if err := r.Storage.UpdateTask(ctx, t); err != nil {
+		return err
+	}
+	r.record(t)
+	return nil
+}
+
+// record appends t's status unless it is unchanged
+func (r *recorder) record(t *task.Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.history[t.ID]
+	if len(h) == 0 || h[len(h)-1] != t.Status {
+		r.history[t.ID] = append(h, t.Status)
+	}
+}
+
+func (r *recorder) transitions(id string) []task.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]task.Status(nil), r.history[id]...)
+}
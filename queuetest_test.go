@@ -0,0 +1,182 @@
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+func TestHarness_RetryWithBackoff(t *testing.T) {
+	h := New(t, queue.Config{})
+
+	calls := 0
+	h.Handle("flaky", func(ctx context.Context, t *task.Task) error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+
+	tk := task.NewTask("flaky", task.PriorityHigh, nil)
+	tk.MaxRetries = 3
+	h.Submit(tk)
+
+	require.NotNil(t, h.ProcessOne())
+	h.AssertStatus(tk.ID, task.StatusScheduled)
+
+	// The first retry waits one second, the second four
+	assert.Nil(t, h.ProcessOne())
+	h.Advance(time.Second)
+	require.NotNil(t, h.ProcessOne())
+
+	h.Advance(3 * time.Second)
+	assert.Zero(t, h.ProcessAll())
+	h.Advance(time.Second)
+	assert.Equal(t, 1, h.ProcessAll())
+
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, h.Task(tk.ID).RetryCount)
+	h.AssertTransitions(tk.ID,
+		task.StatusPending, task.StatusProcessing, task.StatusRetrying, task.StatusScheduled,
+		task.StatusPending, task.StatusProcessing, task.StatusRetrying, task.StatusScheduled,
+		task.StatusPending, task.StatusProcessing, task.StatusCompleted,
+	)
+}
+
+func TestHarness_PriorityAndDeadline(t *testing.T) {
+	h := New(t, queue.Config{})
+
+	var order []string
+	h.Handle("work", func(ctx context.Context, t *task.Task) error {
+		order = append(order, t.Payload["name"].(string))
+		return nil
+	})
+
+	low := task.NewTask("work", task.PriorityLow, map[string]interface{}{"name": "low"})
+	critical := task.NewTask("work", task.PriorityCritical, map[string]interface{}{"name": "critical"})
+	late := task.NewTask("work", task.PriorityHigh, map[string]interface{}{"name": "late"})
+	deadline := h.Clock.Now().Add(time.Minute)
+	late.Deadline = &deadline
+
+	h.Submit(low)
+	h.Submit(critical)
+	h.Submit(late)
+
+	// The deadline passes before any worker gets to the task
+	h.Advance(2 * time.Minute)
+	assert.Equal(t, 2, h.ProcessAll())
+
+	assert.Equal(t, []string{"critical", "low"}, order)
+	h.AssertTransitions(late.ID, task.StatusPending, task.StatusExpired)
+	h.AssertTransitions(low.ID, task.StatusPending, task.StatusProcessing, task.StatusCompleted)
+}
+
+func TestHarness_ExecutionWindow(t *testing.T) {
+	window, err := queue.ParseWindow("01:00-02:00")
+	require.NoError(t, err)
+
+	clock := NewClock(time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC))
+	h := New(t, queue.Config{
+		Clock:            clock,
+		ExecutionWindows: map[string]queue.Window{"nightly": window},
+	})
+	h.Handle("nightly", func(ctx context.Context, t *task.Task) error { return nil })
+
+	tk := h.Submit(task.NewTask("nightly", task.PriorityMedium, nil))
+	assert.Zero(t, h.ProcessAll())
+
+	h.Advance(2 * time.Hour)
+	assert.Equal(t, 1, h.ProcessAll())
+	h.AssertTransitions(tk.ID, task.StatusScheduled, task.StatusPending, task.StatusProcessing, task.StatusCompleted)
+}
+
+func TestHarness_ClockStampsTasks(t *testing.T) {
+	start := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	h := New(t, queue.Config{Clock: NewClock(start)})
+	h.Handle("work", func(ctx context.Context, t *task.Task) error { return nil })
+
+	tk := h.Submit(task.NewTask("work", task.PriorityMedium, nil))
+	h.Advance(time.Minute)
+	h.ProcessAll()
+
+	// Start and finish times come from the queue's clock, not the node's
+	done := h.Task(tk.ID)
+	require.NotNil(t, done.StartedAt)
+	require.NotNil(t, done.CompletedAt)
+	assert.Equal(t, start.Add(time.Minute), done.StartedAt.UTC())
+	assert.Equal(t, start.Add(time.Minute), done.CompletedAt.UTC())
+}
+
+func TestHarness_HandlerTimesByClock(t *testing.T) {
+	start := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	h := New(t, queue.Config{Clock: NewClock(start)})
+
+	var before, after queue.DoctorReport
+	h.Handle("export", func(ctx context.Context, tk *task.Task) error {
+		require.NoError(t, tk.ExtendLease(ctx, 5*time.Minute))
+		lease := h.Task(tk.ID).LeaseExpiresAt
+		require.NotNil(t, lease)
+		assert.Equal(t, start.Add(5*time.Minute), lease.UTC())
+
+		require.NoError(t, tk.SaveCheckpoint(ctx, 1))
+		assert.Equal(t, start, h.Task(tk.ID).LastCheckpoint.SavedAt.UTC())
+
+		// Doctor judges the lease by the same clock
+		var err error
+		before, err = h.Queue.Doctor(ctx, queue.DoctorOptions{})
+		require.NoError(t, err)
+		h.Advance(6 * time.Minute)
+		after, err = h.Queue.Doctor(ctx, queue.DoctorOptions{})
+		require.NoError(t, err)
+		return nil
+	})
+
+	h.Submit(task.NewTask("export", task.PriorityMedium, nil))
+	require.NotNil(t, h.ProcessOne())
+	assert.Zero(t, before.Orphaned)
+	assert.Equal(t, 1, after.Orphaned)
+}
+
+func TestHarness_LifecycleCallbacks(t *testing.T) {
+	h := New(t, queue.Config{})
+
+	var events []string
+	record := func(event string) queue.TaskCallback {
+		return func(ctx context.Context, t *task.Task) {
+			events = append(events, event+":"+t.Payload["name"].(string))
+		}
+	}
+	h.Queue.OnComplete(record("complete"))
+	h.Queue.OnRetry(record("retry"))
+	h.Queue.OnFailure(record("failure"))
+	h.Queue.OnFailure(func(ctx context.Context, t *task.Task) {
+		panic("a broken callback must not stop the worker")
+	})
+
+	h.Handle("work", func(ctx context.Context, t *task.Task) error {
+		if t.Payload["name"] == "bad" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	good := task.NewTask("work", task.PriorityHigh, map[string]interface{}{"name": "good"})
+	bad := task.NewTask("work", task.PriorityMedium, map[string]interface{}{"name": "bad"})
+	bad.MaxRetries = 1
+	h.Submit(good)
+	h.Submit(bad)
+
+	assert.Equal(t, 2, h.ProcessAll())
+	h.Advance(time.Second)
+	assert.Equal(t, 1, h.ProcessAll())
+
+	assert.Equal(t, []string{"complete:good", "retry:bad", "failure:bad"}, events)
+	h.AssertStatus(bad.ID, task.StatusFailed)
+}
@@ -0,0 +1,131 @@
+// Package reload re-applies configuration files while a process runs, so
+// settings can change without a restart.
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Config holds watcher configuration
+type Config struct {
+	// Paths are the files watched for changes; empty paths are skipped
+	Paths []string
+
+	// Apply re-reads the configuration and applies it. It should change
+	// nothing when it returns an error, leaving the running settings as
+	// they were.
+	Apply func() error
+
+	Logger *zap.Logger
+
+	// Interval is how often the files are checked for changes, defaults
+	// to 5s
+	Interval time.Duration
+}
+
+// Watcher calls Apply on SIGHUP, or when a watched file's modification
+// time or size changes
+type Watcher struct {
+	config Config
+	logger *zap.Logger
+	stats  map[string]fileStat
+}
+
+// fileStat is what a change to a file is detected by
+type fileStat struct {
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+// NewWatcher creates a watcher. Call Run to start it.
+func NewWatcher(cfg Config) *Watcher {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 5 * time.Second
+	}
+
+	w := &Watcher{
+		config: cfg,
+		logger: cfg.Logger,
+		stats:  make(map[string]fileStat),
+	}
+	for _, path := range cfg.Paths {
+		if path != "" {
+			w.stats[path] = stat(path)
+		}
+	}
+	return w
+}
+
+// Run watches until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.logger.Info("reloading configuration", zap.String("reason", "SIGHUP"))
+			w.Reload()
+		case <-ticker.C:
+			if path, ok := w.changed(); ok {
+				w.logger.Info("reloading configuration",
+					zap.String("reason", "file changed"),
+					zap.String("path", path))
+				w.Reload()
+			}
+		}
+	}
+}
+
+// Reload calls Apply, logging whether it worked
+func (w *Watcher) Reload() error {
+	if err := w.config.Apply(); err != nil {
+		metrics.ConfigReloads.WithLabelValues("failed").Inc()
+		w.logger.Error("configuration reload failed, keeping current settings", zap.Error(err))
+		return err
+	}
+	metrics.ConfigReloads.WithLabelValues("applied").Inc()
+	w.logger.Info("configuration reloaded")
+	return nil
+}
+
+// changed records the current state of the watched files, returning the
+// first one that changed since the last check
+func (w *Watcher) changed() (string, bool) {
+	var changed string
+	for path, before := range w.stats {
+		now := stat(path)
+		if now != before {
+			w.stats[path] = now
+			if changed == "" {
+				changed = path
+			}
+		}
+	}
+	return changed, changed != ""
+}
+
+func stat(path string) fileStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStat{missing: true}
+	}
+	return fileStat{modTime: info.ModTime(), size: info.Size()}
+}
@@ -0,0 +1,57 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWatcher_FileChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "types.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{}`), 0o644))
+
+	var applied atomic.Int32
+	w := NewWatcher(Config{
+		Paths:    []string{path, ""},
+		Apply:    func() error { applied.Add(1); return nil },
+		Logger:   zap.NewNop(),
+		Interval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// Nothing changed yet
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), applied.Load())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"send_email": {}}`), 0o644))
+	require.Eventually(t, func() bool { return applied.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// A single change applies once
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), applied.Load())
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	fail := errors.New("invalid config")
+	var err error
+	w := NewWatcher(Config{
+		Apply:  func() error { return err },
+		Logger: zap.NewNop(),
+	})
+
+	assert.NoError(t, w.Reload())
+
+	err = fail
+	assert.ErrorIs(t, w.Reload(), fail)
+}
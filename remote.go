@@ -0,0 +1,294 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// errLeaseExpired fails an attempt whose remote worker stopped reporting
+// progress before it acked or nacked
+var errLeaseExpired = errors.New("remote worker lease expired")
+
+// Lease is a task handed to a remote worker. The worker keeps it by
+// reporting progress before Expires, and ends it with an ack or nack.
+type Lease struct {
+	ID       string     `json:"lease_id"`
+	WorkerID string     `json:"worker_id"`
+	Task     *task.Task `json:"task"`
+	Expires  time.Time  `json:"expires_at"`
+}
+
+// Progress is what a remote worker reports while it runs a leased task
+type Progress struct {
+	// Percent is how far along the task is, from 0 to 100
+	Percent float64 `json:"percent,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// Nack is how a remote worker reports that a leased task failed
+type Nack struct {
+	Error string
+	// Permanent fails the task at once rather than retrying it, like
+	// task.Permanent
+	Permanent bool
+	// RetryAfter holds the retry back at least this long, like
+	// task.RetryAfter
+	RetryAfter time.Duration
+}
+
+// err returns the handler error n stands for
+func (n Nack) err() error {
+	err := errors.New(n.Error)
+	if n.Error == "" {
+		err = errors.New("remote worker reported failure")
+	}
+	if n.Permanent {
+		return task.Permanent(err)
+	}
+	if n.RetryAfter > 0 {
+		return task.RetryAfter(err, n.RetryAfter)
+	}
+	return err
+}
+
+// remoteLease is the queue's side of a Lease
+type remoteLease struct {
+	task     *task.Task
+	id       string
+	workerID string
+	expires  time.Time
+
+	// progress carries progress reports to the attempt, done its outcome
+	progress chan Progress
+	done     chan remoteOutcome
+}
+
+// remoteOutcome is how a remote worker ended a lease: with a result, or
+// with the error the attempt fails with
+type remoteOutcome struct {
+	result map[string]interface{}
+	err    error
+}
+
+// remoteBroker hands the tasks of remote types to remote workers. Each
+// attempt waits in offered until a worker leases it, then in leased until
+// the worker acks or nacks it or the lease expires.
+type remoteBroker struct {
+	mu      sync.Mutex
+	offered map[string][]*remoteLease
+	leased  map[string]*remoteLease
+	// waiting closes when a task is offered, waking workers waiting for a
+	// lease
+	waiting chan struct{}
+}
+
+func newRemoteBroker() *remoteBroker {
+	return &remoteBroker{
+		offered: make(map[string][]*remoteLease),
+		leased:  make(map[string]*remoteLease),
+		waiting: make(chan struct{}),
+	}
+}
+
+// RegisterRemote registers taskType as run by remote workers, which lease
+// its tasks with Lease and report back with ReportProgress, Ack and Nack.
+// Each attempt holds a worker of this queue while it waits for a lease and
+// while the lease runs, so timeouts, retries, rate limits and everything
+// else apply as they do to a local handler.
+func (q *Queue) RegisterRemote(taskType string) {
+	q.RegisterHandler(taskType, q.remoteHandler(taskType))
+}
+
+// remoteHandler offers each task to remote workers and waits for one to
+// finish it
+func (q *Queue) remoteHandler(taskType string) TaskHandler {
+	return func(ctx context.Context, t *task.Task) error {
+		l := &remoteLease{
+			task:     t,
+			progress: make(chan Progress, 1),
+			done:     make(chan remoteOutcome, 1),
+		}
+		q.remote.offer(taskType, l)
+		defer q.remote.drop(taskType, l)
+
+		logger := task.LoggerFromContext(ctx)
+		ticker := time.NewTicker(q.leaseTimeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case out := <-l.done:
+				if out.result != nil {
+					t.Result = out.result
+				}
+				return out.err
+			case p := <-l.progress:
+				logger.Info("remote worker progress", zap.Float64("percent", p.Percent), zap.String("message", p.Message))
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				if q.remote.expired(l, q.clock.Now()) {
+					return errLeaseExpired
+				}
+			}
+		}
+	}
+}
+
+// lease returns the Lease l stands for; the broker's lock must be held
+func (l *remoteLease) lease() *Lease {
+	return &Lease{ID: l.id, WorkerID: l.workerID, Task: l.task, Expires: l.expires}
+}
+
+// offer makes l available to remote workers of taskType
+func (b *remoteBroker) offer(taskType string, l *remoteLease) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.offered[taskType] = append(b.offered[taskType], l)
+	close(b.waiting)
+	b.waiting = make(chan struct{})
+}
+
+// drop forgets l once its attempt is over, whether or not it was leased
+func (b *remoteBroker) drop(taskType string, l *remoteLease) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.leased, l.id)
+	offered := b.offered[taskType]
+	for i, o := range offered {
+		if o == l {
+			b.offered[taskType] = append(offered[:i:i], offered[i+1:]...)
+			break
+		}
+	}
+}
+
+// take leases the first task offered of any of types to workerID, if any,
+// or returns a channel that closes when a task is next offered
+func (b *remoteBroker) take(types []string, workerID string, expires time.Time) (*Lease, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, taskType := range types {
+		offered := b.offered[taskType]
+		if len(offered) == 0 {
+			continue
+		}
+		l := offered[0]
+		b.offered[taskType] = offered[1:]
+		l.id = uuid.NewString()
+		l.workerID = workerID
+		l.expires = expires
+		b.leased[l.id] = l
+		return l.lease(), nil
+	}
+	return nil, b.waiting
+}
+
+// extend keeps lease id for another timeout from now, unless it has
+// already expired, and passes p on to its attempt
+func (b *remoteBroker) extend(id string, now time.Time, timeout time.Duration, p Progress) (*Lease, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.leased[id]
+	if !ok || now.After(l.expires) {
+		return nil, errs.ErrLeaseNotFound
+	}
+	l.expires = now.Add(timeout)
+	select {
+	case l.progress <- p:
+	default:
+		// The attempt has yet to log the last report; this one extends
+		// the lease all the same
+	}
+	return l.lease(), nil
+}
+
+// end removes lease id, returning it, so only one outcome is reported
+func (b *remoteBroker) end(id string) (*remoteLease, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.leased[id]
+	if !ok {
+		return nil, errs.ErrLeaseNotFound
+	}
+	delete(b.leased, id)
+	return l, nil
+}
+
+// expired reports whether l was leased and has not been extended in time
+func (b *remoteBroker) expired(l *remoteLease, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return l.id != "" && now.After(l.expires)
+}
+
+// Lease waits up to wait for a task of one of types, the first type
+// listed taking precedence, and leases it to the remote worker workerID
+// for the lease timeout. It returns nil if none came.
+func (q *Queue) Lease(ctx context.Context, workerID string, types []string, wait time.Duration) (*Lease, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		l, waiting := q.remote.take(types, workerID, q.clock.Now().Add(q.leaseTimeout))
+		if l != nil {
+			q.logger.Info("task leased to remote worker",
+				zap.String("id", l.Task.ID),
+				zap.String("type", l.Task.Type),
+				zap.String("remote_worker", workerID),
+			)
+			return l, nil
+		}
+		select {
+		case <-waiting:
+		case <-timer.C:
+			return nil, nil
+		case <-q.stopChan:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to lease task: %w", ctx.Err())
+		}
+	}
+}
+
+// Stopping returns a channel that is closed once the queue starts to
+// stop, after which Lease hands out no more tasks
+func (q *Queue) Stopping() <-chan struct{} {
+	return q.stopChan
+}
+
+// ReportProgress records a remote worker's progress on lease id in its
+// task's logs and extends the lease. It returns errs.ErrLeaseNotFound
+// once the lease has ended, such as when the task was cancelled or timed
+// out, telling the worker to give up on it.
+func (q *Queue) ReportProgress(id string, p Progress) (*Lease, error) {
+	return q.remote.extend(id, q.clock.Now(), q.leaseTimeout, p)
+}
+
+// Ack completes the task of lease id, with result as its result if not
+// nil
+func (q *Queue) Ack(id string, result map[string]interface{}) error {
+	l, err := q.remote.end(id)
+	if err != nil {
+		return err
+	}
+	l.done <- remoteOutcome{result: result}
+	return nil
+}
+
+// Nack fails the attempt of lease id, which retries or fails the task as a
+// local handler's error would
+func (q *Queue) Nack(id string, n Nack) error {
+	l, err := q.remote.end(id)
+	if err != nil {
+		return err
+	}
+	l.done <- remoteOutcome{err: n.err()}
+	return nil
+}
@@ -0,0 +1,243 @@
+// Package replication mirrors task records to a storage backend in another
+// cluster, so task processing can fail over to another region when the
+// primary one is lost. Mirroring is asynchronous: the primary never waits
+// for, or fails because of, the secondary.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Config holds replication configuration
+type Config struct {
+	// Secondary receives a copy of every task written to the primary
+	Secondary storage.Storage
+	Logger    *zap.Logger
+
+	// Buffer is how many writes may wait to be mirrored, defaults to 10000.
+	// Writes beyond it are dropped and counted rather than slowing the
+	// primary down.
+	Buffer int
+
+	// Timeout bounds each write to the secondary, defaults to 5s
+	Timeout time.Duration
+}
+
+// Storage is a storage.Storage that writes to its primary as usual and
+// mirrors every saved, updated or deleted task to the secondary in the
+// background
+type Storage struct {
+	storage.Storage
+	secondary storage.Storage
+	logger    *zap.Logger
+	timeout   time.Duration
+
+	writes chan write
+	done   chan struct{}
+
+	// mu guards closed, so no write is queued after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+// write is one mirrored change; a nil task deletes id
+type write struct {
+	id   string
+	task *task.Task
+}
+
+// New wraps primary so its writes are mirrored to cfg.Secondary
+func New(primary storage.Storage, cfg Config) *Storage {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.Buffer == 0 {
+		cfg.Buffer = 10000
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	s := &Storage{
+		Storage:   primary,
+		secondary: cfg.Secondary,
+		logger:    cfg.Logger,
+		timeout:   cfg.Timeout,
+		writes:    make(chan write, cfg.Buffer),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Storage) SaveTask(ctx context.Context, t *task.Task) error {
+	if err := s.Storage.SaveTask(ctx, t); err != nil {
+		return err
+	}
+	s.mirror(write{id: t.ID, task: t})
+	return nil
+}
+
+func (s *Storage) UpdateTask(ctx context.Context, t *task.Task) error {
+	if err := s.Storage.UpdateTask(ctx, t); err != nil {
+		return err
+	}
+	s.mirror(write{id: t.ID, task: t})
+	return nil
+}
+
+func (s *Storage) DeleteTask(ctx context.Context, id string) error {
+	if err := s.Storage.DeleteTask(ctx, id); err != nil {
+		return err
+	}
+	s.mirror(write{id: id})
+	return nil
+}
+
+// GetTasks reads from the primary, like every read
+func (s *Storage) GetTasks(ctx context.Context, ids []string) ([]*task.Task, error) {
+	return storage.GetTasks(ctx, s.Storage, ids)
+}
+
+// Unwrap returns the primary, whose untracked tasks, shards and indexes
+// are not mirrored
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+// Close mirrors the writes still waiting, then closes the primary. The
+// secondary is left open for its owner to close.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.writes)
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	return s.Storage.Close()
+}
+
+// mirror queues w, copying the task so later changes by the caller are not
+// mirrored early
+func (s *Storage) mirror(w write) {
+	if w.task != nil {
+		data, err := w.task.ToJSON()
+		if err != nil {
+			s.logger.Error("failed to copy task for replication", zap.String("id", w.id), zap.Error(err))
+			return
+		}
+		if w.task, err = task.FromJSON(data); err != nil {
+			s.logger.Error("failed to copy task for replication", zap.String("id", w.id), zap.Error(err))
+			return
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.logger.Warn("replication closed, dropping write", zap.String("id", w.id))
+		return
+	}
+
+	select {
+	case s.writes <- w:
+		metrics.ReplicationBacklog.Set(float64(len(s.writes)))
+	default:
+		metrics.TasksReplicated.WithLabelValues("dropped").Inc()
+		s.logger.Warn("replication backlog full, dropping write", zap.String("id", w.id))
+	}
+}
+
+// run applies queued writes to the secondary, in order, until Close
+func (s *Storage) run() {
+	defer close(s.done)
+	for w := range s.writes {
+		metrics.ReplicationBacklog.Set(float64(len(s.writes)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		result, err := s.apply(ctx, w)
+		cancel()
+		if err != nil {
+			metrics.TasksReplicated.WithLabelValues("error").Inc()
+			s.logger.Error("failed to replicate task", zap.String("id", w.id), zap.Error(err))
+			continue
+		}
+		metrics.TasksReplicated.WithLabelValues(result).Inc()
+	}
+}
+
+// apply makes the secondary's copy of the task match w, unless the
+// secondary's copy wins under Wins. It returns "ok" or "conflict".
+func (s *Storage) apply(ctx context.Context, w write) (string, error) {
+	if w.task == nil {
+		err := s.secondary.DeleteTask(ctx, w.id)
+		if err != nil && !errors.Is(err, errs.ErrTaskNotFound) {
+			return "", fmt.Errorf("failed to delete replica: %w", err)
+		}
+		return "ok", nil
+	}
+
+	existing, err := s.secondary.GetTask(ctx, w.id)
+	if errors.Is(err, errs.ErrTaskNotFound) {
+		if err := s.secondary.SaveTask(ctx, w.task); err != nil {
+			return "", fmt.Errorf("failed to save replica: %w", err)
+		}
+		return "ok", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get replica: %w", err)
+	}
+
+	if !Wins(w.task, existing) {
+		s.logger.Info("kept replica over primary write",
+			zap.String("id", w.id),
+			zap.String("primary_status", string(w.task.Status)),
+			zap.String("replica_status", string(existing.Status)),
+		)
+		return "conflict", nil
+	}
+
+	if w.task.CanReplace(existing) {
+		if err := s.secondary.UpdateTask(ctx, w.task); err != nil {
+			return "", fmt.Errorf("failed to update replica: %w", err)
+		}
+		return "ok", nil
+	}
+
+	// Dropped writes can leave the replica states behind; replace it
+	if err := s.secondary.DeleteTask(ctx, w.id); err != nil && !errors.Is(err, errs.ErrTaskNotFound) {
+		return "", fmt.Errorf("failed to replace replica: %w", err)
+	}
+	if err := s.secondary.SaveTask(ctx, w.task); err != nil {
+		return "", fmt.Errorf("failed to replace replica: %w", err)
+	}
+	return "ok", nil
+}
+
+// Wins reports whether incoming should replace existing, another copy of
+// the same task. A finished task is never reopened, so a region that ran a
+// task during a failover keeps its outcome when stale state arrives from
+// the other. Between two outcomes, completion beats failure or expiry, so a
+// task that succeeded anywhere counts as done; otherwise the copy already
+// there is kept.
+func Wins(incoming, existing *task.Task) bool {
+	if !existing.Status.Terminal() {
+		return true
+	}
+	if !incoming.Status.Terminal() {
+		return false
+	}
+	return incoming.Status == task.StatusCompleted && existing.Status != task.StatusCompleted
+}
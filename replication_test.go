@@ -0,0 +1,102 @@
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+func TestStorage_Mirrors(t *testing.T) {
+	ctx := context.Background()
+	secondary := storage.NewMemoryStorage()
+	store := New(storage.NewMemoryStorage(), Config{Secondary: secondary, Logger: zap.NewNop()})
+
+	tk := task.NewTask("send_email", task.PriorityHigh, map[string]interface{}{"to": "a@example.com"})
+	require.NoError(t, store.SaveTask(ctx, tk))
+	tk.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, tk))
+	tk.MarkCompleted()
+	require.NoError(t, store.UpdateTask(ctx, tk))
+
+	gone := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, store.SaveTask(ctx, gone))
+	require.NoError(t, store.DeleteTask(ctx, gone.ID))
+
+	// Close waits for the backlog to drain
+	require.NoError(t, store.Close())
+
+	replica, err := secondary.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, replica.Status)
+	assert.Equal(t, "a@example.com", replica.Payload["to"])
+
+	_, err = secondary.GetTask(ctx, gone.ID)
+	assert.ErrorIs(t, err, errs.ErrTaskNotFound)
+}
+
+func TestStorage_ReplicaFinishedFirst(t *testing.T) {
+	ctx := context.Background()
+	secondary := storage.NewMemoryStorage()
+	store := New(storage.NewMemoryStorage(), Config{Secondary: secondary, Logger: zap.NewNop()})
+
+	// During a failover the secondary region ran the task to completion
+	tk := task.NewTask("send_email", task.PriorityHigh, nil)
+	require.NoError(t, store.SaveTask(ctx, tk))
+	require.Eventually(t, func() bool {
+		_, err := secondary.GetTask(ctx, tk.ID)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+
+	finished, err := secondary.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	finished.MarkStarted("dr-worker")
+	require.NoError(t, secondary.UpdateTask(ctx, finished))
+	finished.MarkCompleted()
+	require.NoError(t, secondary.UpdateTask(ctx, finished))
+
+	// The recovered primary's stale progress does not reopen it
+	tk.MarkStarted("worker-1")
+	require.NoError(t, store.UpdateTask(ctx, tk))
+	tk.MarkFailed(assert.AnError)
+	require.NoError(t, store.UpdateTask(ctx, tk))
+
+	require.NoError(t, store.Close())
+
+	replica, err := secondary.GetTask(ctx, tk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCompleted, replica.Status)
+}
+
+func TestStorage_UnwrapsToPrimary(t *testing.T) {
+	primary := storage.NewMemoryStorage()
+	store := New(primary, Config{Secondary: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	defer store.Close()
+
+	// Optional interfaces of the primary are found through the mirror
+	u, ok := storage.AsUntrackedQueue(store)
+	require.True(t, ok)
+	require.NoError(t, u.PushUntracked(context.Background(), task.NewTask("page_view", task.PriorityLow, nil)))
+	n, err := primary.CountUntracked(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestWins(t *testing.T) {
+	at := func(s task.Status) *task.Task {
+		return &task.Task{Status: s}
+	}
+
+	assert.True(t, Wins(at(task.StatusProcessing), at(task.StatusPending)))
+	assert.True(t, Wins(at(task.StatusCompleted), at(task.StatusProcessing)))
+	assert.False(t, Wins(at(task.StatusPending), at(task.StatusCompleted)))
+	assert.True(t, Wins(at(task.StatusCompleted), at(task.StatusFailed)))
+	assert.False(t, Wins(at(task.StatusFailed), at(task.StatusCompleted)))
+	assert.False(t, Wins(at(task.StatusExpired), at(task.StatusFailed)))
+}
@@ -0,0 +1,224 @@
+// Package reporting summarizes the tasks in storage over a time range, for
+// periodic ops reports. Reports are generated by a worker, as a task of
+// type TaskType, so reading every task record never ties up an API server.
+package reporting
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// TaskType is the type of the tasks that generate reports
+const TaskType = "generate_report"
+
+// Request describes a report
+type Request struct {
+	// From and To bound the creation time of the tasks covered, From
+	// inclusive and To exclusive
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// TopFailures is how many of the most common failure reasons to list,
+	// defaults to 10
+	TopFailures int `json:"top_failures,omitempty"`
+}
+
+// Validate checks the request and fills in defaults
+func (r *Request) Validate() error {
+	if r.From.IsZero() || r.To.IsZero() {
+		return errs.Invalidf("from and to are required")
+	}
+	if !r.To.After(r.From) {
+		return errs.Invalidf("to must be after from")
+	}
+	if r.TopFailures < 0 || r.TopFailures > 100 {
+		return errs.Invalidf("top_failures must be between 0 and 100")
+	}
+	if r.TopFailures == 0 {
+		r.TopFailures = 10
+	}
+	return nil
+}
+
+// Row counts the tasks of one type created on one day that now have one
+// status
+type Row struct {
+	// Day is the UTC creation date, as YYYY-MM-DD
+	Day    string      `json:"day"`
+	Type   string      `json:"type"`
+	Status task.Status `json:"status"`
+	Count  int         `json:"count"`
+}
+
+// FailureReason counts failed and expired tasks by their last error
+type FailureReason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// Report summarizes the tasks created in a time range
+type Report struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// GeneratedAt is when storage was read. Tasks are counted once, at the
+	// newest status seen, and tasks created after GeneratedAt are left
+	// out, so the totals agree with each other however busy the queue was.
+	GeneratedAt time.Time       `json:"generated_at"`
+	Total       int             `json:"total"`
+	Counts      []Row           `json:"counts"`
+	TopFailures []FailureReason `json:"top_failures"`
+}
+
+// Generate builds the report req describes from the task records in store.
+// It only sees records the backend still holds; Redis keeps them for 24
+// hours.
+func Generate(ctx context.Context, store storage.Storage, req Request, now time.Time) (*Report, error) {
+	to := req.To
+	if to.After(now) {
+		to = now
+	}
+
+	// Tasks are scanned a page at a time, so a task that moves on between
+	// pages can turn up twice; keep the copy read last. Report tasks
+	// themselves are left out.
+	tasks := make(map[string]*task.Task)
+	err := storage.Each(ctx, store, storage.TaskFilter{}, func(t *task.Task) error {
+		if t.Type != TaskType && !t.CreatedAt.Before(req.From) && t.CreatedAt.Before(to) {
+			tasks[t.ID] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+
+	type rowKey struct {
+		day, taskType string
+		status        task.Status
+	}
+	rows := make(map[rowKey]int)
+	reasons := make(map[string]int)
+	for _, t := range tasks {
+		rows[rowKey{t.CreatedAt.UTC().Format("2006-01-02"), t.Type, t.Status}]++
+		if t.Status == task.StatusFailed || t.Status == task.StatusExpired {
+			reason := t.Error
+			if reason == "" {
+				reason = "unknown"
+			}
+			reasons[reason]++
+		}
+	}
+
+	report := &Report{
+		From:        req.From,
+		To:          req.To,
+		GeneratedAt: now,
+		Total:       len(tasks),
+		Counts:      make([]Row, 0, len(rows)),
+		TopFailures: make([]FailureReason, 0, len(reasons)),
+	}
+	for k, n := range rows {
+		report.Counts = append(report.Counts, Row{Day: k.day, Type: k.taskType, Status: k.status, Count: n})
+	}
+	sort.Slice(report.Counts, func(i, j int) bool {
+		a, b := report.Counts[i], report.Counts[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Status < b.Status
+	})
+
+	for reason, n := range reasons {
+		report.TopFailures = append(report.TopFailures, FailureReason{Reason: reason, Count: n})
+	}
+	sort.Slice(report.TopFailures, func(i, j int) bool {
+		a, b := report.TopFailures[i], report.TopFailures[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Reason < b.Reason
+	})
+	if len(report.TopFailures) > req.TopFailures {
+		report.TopFailures = report.TopFailures[:req.TopFailures]
+	}
+	return report, nil
+}
+
+// WriteCSV writes the report as one CSV table. Rows with section "count"
+// fill day, type, status and count; rows with section "failure" fill
+// reason and count.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"section", "day", "type", "status", "reason", "count"})
+	for _, row := range r.Counts {
+		cw.Write([]string{"count", row.Day, row.Type, string(row.Status), "", strconv.Itoa(row.Count)})
+	}
+	for _, f := range r.TopFailures {
+		cw.Write([]string{"failure", "", "", "", f.Reason, strconv.Itoa(f.Count)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// NewTask returns a task that generates the report req describes
+func NewTask(req Request) (*task.Task, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report request: %w", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to encode report request: %w", err)
+	}
+	return task.NewTask(TaskType, task.PriorityLow, payload), nil
+}
+
+// Handler generates reports from store, saving each as its task's result
+func Handler(store storage.Storage) func(ctx context.Context, t *task.Task) error {
+	return func(ctx context.Context, t *task.Task) error {
+		var req Request
+		data, err := json.Marshal(t.Payload)
+		if err == nil {
+			err = json.Unmarshal(data, &req)
+		}
+		if err == nil {
+			err = req.Validate()
+		}
+		if err != nil {
+			return fmt.Errorf("invalid report request: %w", err)
+		}
+
+		report, err := Generate(ctx, store, req, time.Now())
+		if err != nil {
+			return err
+		}
+		task.LoggerFromContext(ctx).Info("report generated",
+			zap.Time("from", req.From),
+			zap.Time("to", req.To),
+			zap.Int("tasks", report.Total),
+		)
+		return t.SetResult(report)
+	}
+}
+
+// FromTask returns the report a completed TaskType task produced
+func FromTask(t *task.Task) (*Report, error) {
+	var report Report
+	if err := t.DecodeResult(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
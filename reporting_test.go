@@ -0,0 +1,79 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+func TestGenerate(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	day1 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	save := func(taskType string, created time.Time, status task.Status, reason string) {
+		tk := task.NewTask(taskType, task.PriorityMedium, nil)
+		tk.CreatedAt = created
+		tk.Status = status
+		tk.Error = reason
+		require.NoError(t, store.SaveTask(ctx, tk))
+	}
+	save("send_email", day1, task.StatusCompleted, "")
+	save("send_email", day1, task.StatusCompleted, "")
+	save("send_email", day1, task.StatusFailed, "smtp: connection refused")
+	save("send_email", day2, task.StatusFailed, "smtp: connection refused")
+	save("call_webhook", day2, task.StatusExpired, "deadline exceeded")
+	save("call_webhook", day2, task.StatusPending, "")
+	// Outside the range, and report tasks themselves, are left out
+	save("send_email", day1.Add(-48*time.Hour), task.StatusFailed, "too old")
+	save(TaskType, day2, task.StatusProcessing, "")
+
+	req := Request{From: day1.Add(-time.Hour), To: day2.Add(time.Hour), TopFailures: 1}
+	require.NoError(t, req.Validate())
+	report, err := Generate(ctx, store, req, day2.Add(2*time.Hour))
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, report.Total)
+	assert.Equal(t, []Row{
+		{Day: "2026-03-02", Type: "send_email", Status: task.StatusCompleted, Count: 2},
+		{Day: "2026-03-02", Type: "send_email", Status: task.StatusFailed, Count: 1},
+		{Day: "2026-03-03", Type: "call_webhook", Status: task.StatusExpired, Count: 1},
+		{Day: "2026-03-03", Type: "call_webhook", Status: task.StatusPending, Count: 1},
+		{Day: "2026-03-03", Type: "send_email", Status: task.StatusFailed, Count: 1},
+	}, report.Counts)
+	assert.Equal(t, []FailureReason{{Reason: "smtp: connection refused", Count: 2}}, report.TopFailures)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteCSV(&buf))
+	assert.Equal(t, "section,day,type,status,reason,count\n"+
+		"count,2026-03-02,send_email,completed,,2\n"+
+		"count,2026-03-02,send_email,failed,,1\n"+
+		"count,2026-03-03,call_webhook,expired,,1\n"+
+		"count,2026-03-03,call_webhook,pending,,1\n"+
+		"count,2026-03-03,send_email,failed,,1\n"+
+		"failure,,,,smtp: connection refused,2\n", buf.String())
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SaveTask(ctx, task.NewTask("send_email", task.PriorityMedium, nil)))
+
+	tk, err := NewTask(Request{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, Handler(store)(ctx, tk))
+
+	report, err := FromTask(tk)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Total)
+
+	tk.Payload = map[string]interface{}{"from": "yesterday"}
+	assert.Error(t, Handler(store)(ctx, tk))
+}
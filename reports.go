@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/reporting"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// handleCreateReport queues a report for a worker to generate
+func (s *Server) handleCreateReport(w http.ResponseWriter, r *http.Request) {
+	var req reporting.Request
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	t, err := reporting.NewTask(req)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	t.CorrelationID = requestCorrelationID(r)
+	t.Source = requestSource(r)
+	if err := s.queue.Submit(r.Context(), t); err != nil {
+		s.logger.Error("failed to submit report", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	s.respondJSON(w, r, http.StatusAccepted, SubmitTaskResponse{
+		TaskID:        t.ID,
+		Status:        "submitted",
+		CorrelationID: t.CorrelationID,
+	})
+}
+
+// handleGetReport downloads a generated report as JSON, or as CSV with
+// ?format=csv. Until the report is ready it answers 202 with the status of
+// the task generating it.
+func (s *Server) handleGetReport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		s.respondErr(w, r, errs.Invalidf("format must be json or csv"))
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	t, err := s.queue.GetTask(r.Context(), id)
+	if err == nil && t.Type != reporting.TaskType {
+		err = fmt.Errorf("%w: %s", errs.ErrTaskNotFound, id)
+	}
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if t.Status != task.StatusCompleted {
+		s.respondJSON(w, r, http.StatusAccepted, SubmitTaskResponse{TaskID: t.ID, Status: string(t.Status)})
+		return
+	}
+
+	report, err := reporting.FromTask(t)
+	if err != nil {
+		s.logger.Error("failed to read report", zap.String("id", id), zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="report-%s.csv"`, id))
+		report.WriteCSV(w)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="report-%s.json"`, id))
+	s.respondJSON(w, r, http.StatusOK, report)
+}
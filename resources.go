@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// ParseResourceTags parses the resource tags a worker advertises, written
+// as "gpu=true,region=eu"
+func ParseResourceTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid resource tag %q (want key=value)", part)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// ResourceTags returns the resource tags this process advertises
+func (q *Queue) ResourceTags() map[string]string {
+	tags := make(map[string]string, len(q.resourceTags))
+	for k, v := range q.resourceTags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// CanRun reports whether this process advertises every resource tag t
+// requires
+func (q *Queue) CanRun(t *task.Task) bool {
+	return Satisfies(q.resourceTags, t.Requires)
+}
+
+// Satisfies reports whether tags carry every tag in requires, with the
+// same value
+func Satisfies(tags, requires map[string]string) bool {
+	for k, v := range requires {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// nextPending returns the most urgent pending task this process can run,
+// if any. It looks past the first only when that one requires tags this
+// process lacks.
+func (q *Queue) nextPending(ctx context.Context) ([]*task.Task, error) {
+	tasks, err := q.storage.GetTasksByStatus(ctx, task.StatusPending, 1)
+	if err != nil || len(tasks) == 0 || q.CanRun(tasks[0]) {
+		return tasks, err
+	}
+	if tasks, err = q.storage.GetTasksByStatus(ctx, task.StatusPending, q.polling.batchSize); err != nil {
+		return nil, err
+	}
+	for _, t := range tasks {
+		if q.CanRun(t) {
+			return []*task.Task{t}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// BudgetAction decides what happens to a retry over the RetryBudget
+type BudgetAction string
+
+const (
+	// BudgetDelay holds the retry back by RetryBudget.Delay. This is the
+	// default.
+	BudgetDelay BudgetAction = "delay"
+	// BudgetFail fails the task for good instead of retrying it
+	BudgetFail BudgetAction = "fail"
+)
+
+// ParseBudgetAction parses "delay" or "fail"
+func ParseBudgetAction(s string) (BudgetAction, error) {
+	switch a := BudgetAction(s); a {
+	case BudgetDelay, BudgetFail:
+		return a, nil
+	case "":
+		return BudgetDelay, nil
+	default:
+		return "", fmt.Errorf("unknown retry budget action %q (want delay or fail)", s)
+	}
+}
+
+// RetryBudget caps retries at a share of the new tasks submitted, so a
+// systemic failure does not turn into a retry storm that crowds out new
+// work. Both are counted from the storage's per-minute stats, which every
+// worker sharing the storage sees, so the budget holds across the cluster.
+// Workers check it concurrently and can overshoot it slightly.
+type RetryBudget struct {
+	// Ratio is how many retries are allowed per new task, such as 0.2 for
+	// retries of at most 20% of new task volume. Zero turns the budget off.
+	Ratio float64
+	// MinRetries are allowed per Window whatever the volume, so a quiet
+	// queue can still retry. Defaults to 10.
+	MinRetries int64
+	// Window is how far back retries and new tasks are counted, in whole
+	// minutes. Defaults to 5m.
+	Window time.Duration
+	// Action decides what happens to retries over the budget, defaults to
+	// BudgetDelay
+	Action BudgetAction
+	// Delay is the least a retry over the budget waits under BudgetDelay,
+	// defaults to Window
+	Delay time.Duration
+}
+
+func (b RetryBudget) enabled() bool {
+	return b.Ratio > 0
+}
+
+// spendRetry checks the retry budget before t is retried. It returns how
+// long the retry must at least wait, or false if t is to fail instead.
+func (q *Queue) spendRetry(ctx context.Context, t *task.Task, logger *zap.Logger) (time.Duration, bool) {
+	b := q.retryBudget
+	if !b.enabled() || q.inProcess {
+		return 0, true
+	}
+
+	now := q.clock.Now()
+	minutes, err := q.storage.GetMinuteStats(ctx, now.Add(-b.Window+time.Minute), now)
+	if err != nil {
+		// Without the counts, retrying as usual beats failing tasks
+		logger.Warn("failed to check the retry budget", zap.Error(err))
+		return 0, true
+	}
+	var submitted, retried int64
+	for _, m := range minutes {
+		submitted += m.Submitted
+		retried += m.Retried
+	}
+	allowed := int64(math.Max(float64(b.MinRetries), b.Ratio*float64(submitted)))
+	if retried < allowed {
+		return 0, true
+	}
+
+	metrics.RetryBudgetExceeded.WithLabelValues(t.Type, string(b.Action)).Inc()
+	logger.Warn("retry budget exhausted",
+		zap.Int64("retries", retried),
+		zap.Int64("submitted", submitted),
+		zap.String("action", string(b.Action)),
+	)
+	if b.Action == BudgetFail {
+		return 0, false
+	}
+	return b.Delay, true
+}
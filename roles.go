@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// role is a part of the system a process runs. One binary runs any of
+// them, so every role shares the same image and configuration.
+type role string
+
+const (
+	// roleAPI serves the HTTP API
+	roleAPI role = "api"
+	// roleWorker runs tasks
+	roleWorker role = "worker"
+	// roleScheduler campaigns to be the elected scheduler, which releases
+	// due tasks, expires overdue ones, submits recurring tasks and sends
+	// alerts
+	roleScheduler role = "scheduler"
+)
+
+// defaultRoles is what a process runs without --role or ROLE: a worker
+// that can also be elected scheduler
+const defaultRoles = "worker,scheduler"
+
+// roles is the set of roles a process runs
+type roles map[role]bool
+
+// parseRoles reads a comma-separated list of api, worker and scheduler,
+// or all for every role
+func parseRoles(s string) (roles, error) {
+	run := make(roles)
+	for _, name := range strings.Split(s, ",") {
+		switch r := role(strings.TrimSpace(name)); r {
+		case "all":
+			run[roleAPI], run[roleWorker], run[roleScheduler] = true, true, true
+		case roleAPI, roleWorker, roleScheduler:
+			run[r] = true
+		default:
+			return nil, fmt.Errorf("unknown role %q, want api, worker, scheduler or all", name)
+		}
+	}
+	return run, nil
+}
+
+// String lists the roles in a fixed order, for logs
+func (run roles) String() string {
+	var names []string
+	for _, r := range []role{roleAPI, roleWorker, roleScheduler} {
+		if run[r] {
+			names = append(names, string(r))
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// errCPUTimeExceeded is the cause of a handler's context being cancelled
+// for using more CPU time than its type allows. It counts as a timeout.
+var errCPUTimeExceeded = fmt.Errorf("cpu time limit exceeded: %w", context.DeadlineExceeded)
+
+// cpuCheckInterval is how often a running handler's CPU time is checked
+const cpuCheckInterval = 50 * time.Millisecond
+
+// budgetGuard admits tasks only while the shares of the budget the
+// running ones reserved, such as their memory hints, fit its limit
+type budgetGuard struct {
+	mu       sync.Mutex
+	limit    int
+	reserved int
+	// gauge reports what is reserved
+	gauge prometheus.Gauge
+	// freed is closed and replaced each time a share is released
+	freed chan struct{}
+}
+
+func newBudgetGuard(limit int, gauge prometheus.Gauge) *budgetGuard {
+	if limit <= 0 {
+		return nil
+	}
+	return &budgetGuard{limit: limit, gauge: gauge, freed: make(chan struct{})}
+}
+
+// acquire waits until n more fits the limit, or ctx is done. A share over
+// the limit is capped to it, so the task runs once nothing else is.
+func (g *budgetGuard) acquire(ctx context.Context, n int) (func(), error) {
+	if g == nil || n <= 0 {
+		return func() {}, nil
+	}
+	if n > g.limit {
+		n = g.limit
+	}
+	for {
+		g.mu.Lock()
+		if g.reserved+n <= g.limit {
+			g.reserved += n
+			g.gauge.Set(float64(g.reserved))
+			g.mu.Unlock()
+			return func() { g.release(n) }, nil
+		}
+		freed := g.freed
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+func (g *budgetGuard) release(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reserved -= n
+	g.gauge.Set(float64(g.reserved))
+	close(g.freed)
+	g.freed = make(chan struct{})
+}
+
+// reserveMemory waits until the memory hint of t's type fits the worker
+// process's memory limit
+func (q *Queue) reserveMemory(ctx context.Context, t *task.Task, logger *zap.Logger) (func(), error) {
+	c, _ := q.TypeConfig(t.Type)
+	if q.memory == nil || c.MemoryMB <= 0 {
+		return func() {}, nil
+	}
+	waitStart := q.clock.Now()
+	release, err := q.memory.acquire(ctx, c.MemoryMB)
+	if waited := q.clock.Now().Sub(waitStart); waited > time.Second {
+		logger.Info("task waited for memory", zap.Int("memory_mb", c.MemoryMB), zap.Duration("waited", waited))
+	}
+	return release, err
+}
+
+// reserveWeight waits until t's weight fits the worker process's weight
+// budget
+func (q *Queue) reserveWeight(ctx context.Context, t *task.Task, logger *zap.Logger) (func(), error) {
+	if q.weight == nil {
+		return func() {}, nil
+	}
+	weight := q.weightOf(t)
+	waitStart := q.clock.Now()
+	release, err := q.weight.acquire(ctx, weight)
+	if waited := q.clock.Now().Sub(waitStart); waited > time.Second {
+		logger.Info("task waited for weight budget", zap.Int("weight", weight), zap.Duration("waited", waited))
+	}
+	return release, err
+}
+
+// weightOf returns t's own weight, else its type's, else 1
+func (q *Queue) weightOf(t *task.Task) int {
+	if t.Weight > 0 {
+		return t.Weight
+	}
+	if c, ok := q.TypeConfig(t.Type); ok && c.Weight > 0 {
+		return c.Weight
+	}
+	return 1
+}
+
+// watchCPU cancels ctx once the handler running on the calling goroutine
+// has used more CPU time than t's type allows. The goroutine is locked to
+// its OS thread until stop is called, so the thread's CPU time is the
+// handler's. Handlers' own goroutines are not counted. It does nothing on
+// platforms without per-thread CPU times.
+func (q *Queue) watchCPU(ctx context.Context, t *task.Task, logger *zap.Logger) (context.Context, func()) {
+	c, _ := q.TypeConfig(t.Type)
+	if c.CPUTime <= 0 {
+		return ctx, func() {}
+	}
+	thread, ok := lockThread()
+	if !ok {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		start := thread.cpuTime()
+		ticker := time.NewTicker(cpuCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if used := thread.cpuTime() - start; used > c.CPUTime {
+					metrics.CPUTimeExceeded.WithLabelValues(t.Type).Inc()
+					logger.Warn("handler exceeded its cpu time", zap.Duration("cpu_time", used), zap.Duration("limit", c.CPUTime))
+					cancel(errCPUTimeExceeded)
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+		thread.unlock()
+	}
+}
+
+// cpuCause returns errCPUTimeExceeded in place of err if the CPU watchdog
+// cancelled ctx
+func cpuCause(ctx context.Context, err error) error {
+	if err != nil && context.Cause(ctx) == errCPUTimeExceeded {
+		return fmt.Errorf("%w: %w", errCPUTimeExceeded, err)
+	}
+	return err
+}
+
+// watchLeak reports a handler that keeps running more than q.leakGrace
+// after its context was cancelled, as it ignores cancellation and holds
+// its worker. Call the returned func once the handler returns.
+func (q *Queue) watchLeak(ctx context.Context, t *task.Task, logger *zap.Logger) func() {
+	if q.leakGrace <= 0 {
+		return func() {}
+	}
+	returned := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-returned:
+			return
+		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(q.leakGrace)
+		defer timer.Stop()
+		select {
+		case <-returned:
+			return
+		case <-timer.C:
+		}
+
+		cancelledAt := q.clock.Now()
+		metrics.LeakedHandlers.WithLabelValues(t.Type).Inc()
+		metrics.LeakedHandlersRunning.Inc()
+		q.activity.leak(1)
+		logger.Warn("handler ignores cancellation and is still running", zap.Duration("grace", q.leakGrace), zap.NamedError("cause", context.Cause(ctx)))
+		<-returned
+		metrics.LeakedHandlersRunning.Dec()
+		q.activity.leak(-1)
+		logger.Warn("leaked handler returned", zap.Duration("after", q.clock.Now().Sub(cancelledAt)))
+	}()
+
+	return func() {
+		close(returned)
+		<-stopped
+	}
+}
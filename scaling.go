@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"go.uber.org/zap"
+)
+
+// handleScaling reports the backlog of a queue or task type in the shape
+// KEDA's metrics-api scaler reads, so workers can scale on waiting tasks,
+// down to zero, rather than on CPU
+func (s *Server) handleScaling(w http.ResponseWriter, r *http.Request) {
+	queueName := r.URL.Query().Get("queue")
+	taskType := r.URL.Query().Get("type")
+	if queueName != "" && taskType != "" {
+		s.respondError(w, r, http.StatusBadRequest, errs.CodeInvalidRequest, "give queue or type, not both")
+		return
+	}
+	if len(taskType) > maxTaskTypeLength {
+		s.respondErr(w, r, errs.Invalidf("task type must be at most %d characters", maxTaskTypeLength))
+		return
+	}
+
+	backlog, err := s.queue.Backlog(r.Context(), queueName, taskType)
+	if err != nil {
+		s.logger.Error("failed to count backlog", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	s.respondJSON(w, r, http.StatusOK, ScalingResponse{
+		Queue:   queueName,
+		Type:    taskType,
+		Backlog: backlog,
+		Value:   backlog.Pending + backlog.Processing,
+	})
+}
@@ -0,0 +1,368 @@
+// Package schedule runs recurring tasks. Schedules pair a cron spec with a
+// template of the task to submit; they are kept in storage and managed
+// through the API, and only the elected scheduler submits their runs.
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+	// Schedules name IANA timezones, which hosts and containers without a
+	// zoneinfo database would otherwise fail to load
+	_ "time/tzdata"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// Label is the task label recording which schedule submitted a task
+const Label = "schedule"
+
+const (
+	schedulesKey = "schedules"
+	dateLayout   = "2006-01-02"
+	// maxSkippedRuns bounds the search for a run outside the skipped days
+	maxSkippedRuns = 1000
+)
+
+// Overlap decides what a run does when the schedule's previous run has not
+// finished
+type Overlap string
+
+const (
+	// OverlapAllow submits the run anyway. This is the default.
+	OverlapAllow Overlap = "allow"
+	// OverlapSkip skips the run, so at most one runs at a time
+	OverlapSkip Overlap = "skip"
+	// OverlapCancel cancels the previous run and submits the new one
+	OverlapCancel Overlap = "cancel"
+)
+
+// Schedule submits a task from Task each time Spec comes round
+type Schedule struct {
+	ID string `json:"id"`
+	// Name describes the schedule for people
+	Name string `json:"name,omitempty"`
+	// Spec is a cron expression; see ParseSpec
+	Spec string       `json:"spec"`
+	Task TaskTemplate `json:"task"`
+
+	// Timezone is the IANA zone Spec and the skipped days are matched in,
+	// such as "America/New_York"; empty means UTC
+	Timezone string `json:"timezone,omitempty"`
+	// SkipWeekends skips runs falling on a Saturday or Sunday
+	SkipWeekends bool `json:"skip_weekends,omitempty"`
+	// SkipDates skips runs falling on these dates, written 2006-01-02,
+	// such as holidays
+	SkipDates []string `json:"skip_dates,omitempty"`
+
+	// Jitter delays each run by a random amount up to it, so schedules
+	// sharing a spec do not all submit at once
+	Jitter time.Duration `json:"jitter,omitempty"`
+
+	// Overlap applies when a run comes round before the previous one has
+	// finished; empty means OverlapAllow
+	Overlap Overlap `json:"overlap,omitempty"`
+
+	// Paused schedules keep their definition but submit nothing
+	Paused bool `json:"paused"`
+
+	// NextRun is when the schedule next submits, jitter included
+	NextRun    time.Time  `json:"next_run"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastTaskID string     `json:"last_task_id,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TaskTemplate describes the task each run submits. Priority and
+// MaxRetries fall back to the type's defaults.
+type TaskTemplate struct {
+	Type       string                 `json:"type"`
+	Priority   *task.Priority         `json:"priority,omitempty"`
+	Payload    map[string]interface{} `json:"payload,omitempty"`
+	Labels     map[string]string      `json:"labels,omitempty"`
+	MaxRetries *int                   `json:"max_retries,omitempty"`
+}
+
+// MarshalJSON writes Jitter as a string such as "30s"
+func (s Schedule) MarshalJSON() ([]byte, error) {
+	type plain Schedule
+	out := struct {
+		plain
+		Jitter string `json:"jitter,omitempty"`
+	}{plain: plain(s)}
+	if s.Jitter > 0 {
+		out.Jitter = s.Jitter.String()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads Jitter written as a string such as "30s"
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	type plain Schedule
+	in := struct {
+		*plain
+		Jitter string `json:"jitter,omitempty"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	s.Jitter = 0
+	if in.Jitter != "" {
+		jitter, err := time.ParseDuration(in.Jitter)
+		if err != nil {
+			return fmt.Errorf("invalid jitter %q", in.Jitter)
+		}
+		s.Jitter = jitter
+	}
+	return nil
+}
+
+// Validate checks the schedule's spec and task template
+func (s *Schedule) Validate() error {
+	if _, err := ParseSpec(s.Spec); err != nil {
+		return errs.Invalidf("%s", err.Error())
+	}
+	switch {
+	case s.Task.Type == "":
+		return errs.Invalidf("task type is required")
+	case s.Task.Priority != nil && !s.Task.Priority.Valid():
+		return errs.Invalidf("priority must be between %d and %d", task.PriorityMin, task.PriorityMax)
+	case s.Task.MaxRetries != nil && (*s.Task.MaxRetries < 0 || *s.Task.MaxRetries > 100):
+		return errs.Invalidf("max_retries must be between 0 and 100")
+	case s.Jitter < 0:
+		return errs.Invalidf("jitter must not be negative")
+	case s.Overlap != "" && s.Overlap != OverlapAllow && s.Overlap != OverlapSkip && s.Overlap != OverlapCancel:
+		return errs.Invalidf("overlap must be allow, skip or cancel")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return errs.Invalidf("unknown timezone %q", s.Timezone)
+	}
+	for _, date := range s.SkipDates {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return errs.Invalidf("skip date %q must be written 2006-01-02", date)
+		}
+	}
+	return nil
+}
+
+// Next returns the first run after after, in the schedule's timezone,
+// passing over skipped days. Jitter is not included.
+func (s *Schedule) Next(after time.Time) (time.Time, error) {
+	spec, err := ParseSpec(s.Spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+
+	next := after.In(loc)
+	for i := 0; i < maxSkippedRuns; i++ {
+		next = spec.Next(next)
+		if next.IsZero() {
+			break
+		}
+		if !s.skips(next) {
+			return next, nil
+		}
+	}
+	return time.Time{}, errs.Invalidf("schedule %q never runs", s.Spec)
+}
+
+// skips reports whether a run at t, in the schedule's timezone, falls on
+// a skipped day
+func (s *Schedule) skips(t time.Time) bool {
+	if s.SkipWeekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return true
+	}
+	date := t.Format(dateLayout)
+	for _, skip := range s.SkipDates {
+		if skip == date {
+			return true
+		}
+	}
+	return false
+}
+
+// Reschedule sets NextRun to the first run after now. Runs missed while
+// the schedule was paused or its scheduler was down are skipped.
+func (s *Schedule) Reschedule(now time.Time) error {
+	next, err := s.Next(now)
+	if err != nil {
+		return err
+	}
+	next = next.UTC()
+	if s.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+	}
+	s.NextRun = next
+	return nil
+}
+
+// NewSchedule returns a schedule with a new ID, due at its first run
+// after now
+func NewSchedule(now time.Time, s Schedule) (*Schedule, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	s.ID = uuid.NewString()
+	s.CreatedAt = now.UTC()
+	s.UpdatedAt = s.CreatedAt
+	s.LastRun = nil
+	s.LastTaskID = ""
+	if err := s.Reschedule(now); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Store keeps schedules
+type Store interface {
+	// Put creates or replaces a schedule
+	Put(ctx context.Context, s *Schedule) error
+	// Get returns a schedule, or errs.ErrScheduleNotFound
+	Get(ctx context.Context, id string) (*Schedule, error)
+	// List returns every schedule, oldest first
+	List(ctx context.Context) ([]*Schedule, error)
+	// Delete removes a schedule, or returns errs.ErrScheduleNotFound
+	Delete(ctx context.Context, id string) error
+}
+
+func notFound(id string) error {
+	return fmt.Errorf("%w: %s", errs.ErrScheduleNotFound, id)
+}
+
+func sortSchedules(list []*Schedule) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// RedisStore keeps schedules in one Redis hash, by ID
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a schedule store on client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (r *RedisStore) Put(ctx context.Context, s *Schedule) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+	if err := r.client.HSet(ctx, schedulesKey, s.ID, data).Err(); err != nil {
+		return fmt.Errorf("failed to save schedule: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) Get(ctx context.Context, id string) (*Schedule, error) {
+	data, err := r.client.HGet(ctx, schedulesKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, notFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+func (r *RedisStore) List(ctx context.Context) ([]*Schedule, error) {
+	values, err := r.client.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	list := make([]*Schedule, 0, len(values))
+	for id, data := range values {
+		var s Schedule
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return nil, fmt.Errorf("failed to parse schedule %s: %w", id, err)
+		}
+		list = append(list, &s)
+	}
+	sortSchedules(list)
+	return list, nil
+}
+
+func (r *RedisStore) Delete(ctx context.Context, id string) error {
+	n, err := r.client.HDel(ctx, schedulesKey, id).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	if n == 0 {
+		return notFound(id)
+	}
+	return nil
+}
+
+// MemoryStore keeps schedules in memory, for tests and single-process
+// deployments
+type MemoryStore struct {
+	mu        sync.Mutex
+	schedules map[string]Schedule
+}
+
+// NewMemoryStore creates an in-memory schedule store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{schedules: make(map[string]Schedule)}
+}
+
+func (m *MemoryStore) Put(ctx context.Context, s *Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schedules[s.ID] = *s
+	return nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.schedules[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	return &s, nil
+}
+
+func (m *MemoryStore) List(ctx context.Context) ([]*Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*Schedule, 0, len(m.schedules))
+	for _, s := range m.schedules {
+		s := s
+		list = append(list, &s)
+	}
+	sortSchedules(list)
+	return list, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.schedules[id]; !ok {
+		return notFound(id)
+	}
+	delete(m.schedules, id)
+	return nil
+}
@@ -0,0 +1,265 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+func TestSpec_Next(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 7, 30, 0, time.UTC) // a Wednesday
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 15th or any Monday
+		{"0 0 15 * 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+		{"5,10-12/2 8 * * *", time.Date(2024, 2, 1, 8, 5, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", from.Add(90 * time.Second)},
+	} {
+		spec, err := ParseSpec(tc.spec)
+		require.NoError(t, err, tc.spec)
+		assert.Equal(t, tc.want, spec.Next(from), tc.spec)
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 1ms", "@sometimes"} {
+		_, err := ParseSpec(bad)
+		assert.Error(t, err, bad)
+	}
+
+	never, err := ParseSpec("0 0 30 feb *")
+	require.NoError(t, err)
+	assert.True(t, never.Next(from).IsZero())
+}
+
+func TestSchedule_JSON(t *testing.T) {
+	var s Schedule
+	require.NoError(t, json.Unmarshal([]byte(`{"spec": "@daily", "jitter": "5m", "task": {"type": "cleanup", "priority": "high"}}`), &s))
+	assert.Equal(t, 5*time.Minute, s.Jitter)
+	assert.Equal(t, task.PriorityHigh, *s.Task.Priority)
+	require.NoError(t, s.Validate())
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"jitter":"5m0s"`)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"jitter": "soon"}`), &s))
+	assert.Error(t, (&Schedule{Spec: "@daily"}).Validate(), "a task type is required")
+}
+
+func TestSchedule_Timezone(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, ny)
+	}
+
+	s := &Schedule{Spec: "0 9 * * *", Timezone: "America/New_York", Task: TaskTemplate{Type: "report"}}
+	require.NoError(t, s.Validate())
+	friday := at(2024, 3, 8, 10, 0)
+	next, err := s.Next(friday)
+	require.NoError(t, err)
+	assert.True(t, at(2024, 3, 9, 9, 0).Equal(next), next)
+	require.NoError(t, s.Reschedule(friday))
+	assert.Equal(t, time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC), s.NextRun)
+
+	// Monday is after the clocks go forward, so 9am is an hour earlier in UTC
+	s.SkipWeekends = true
+	require.NoError(t, s.Reschedule(friday))
+	assert.Equal(t, time.Date(2024, 3, 11, 13, 0, 0, 0, time.UTC), s.NextRun)
+
+	s.SkipDates = []string{"2024-03-11"}
+	require.NoError(t, s.Reschedule(friday))
+	assert.Equal(t, time.Date(2024, 3, 12, 13, 0, 0, 0, time.UTC), s.NextRun)
+
+	// 2:30 does not happen the day the clocks go forward
+	s = &Schedule{Spec: "30 2 * * *", Timezone: "America/New_York"}
+	next, err = s.Next(at(2024, 3, 9, 12, 0))
+	require.NoError(t, err)
+	assert.True(t, at(2024, 3, 11, 2, 30).Equal(next), next)
+
+	// 1:30 happens twice the day they go back, but runs once, unless it is
+	// run hourly
+	s.Spec = "30 1 * * *"
+	first, err := s.Next(at(2024, 11, 3, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), first.UTC())
+	next, err = s.Next(first)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 11, 4, 6, 30, 0, 0, time.UTC), next.UTC())
+	s.Spec = "30 * * * *"
+	next, err = s.Next(first)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC), next.UTC())
+
+	s = &Schedule{Spec: "0 9 * * sat", SkipWeekends: true, Task: TaskTemplate{Type: "report"}}
+	_, err = s.Next(friday)
+	assert.Error(t, err, "every run is skipped")
+	assert.Error(t, (&Schedule{Spec: "@daily", Timezone: "Mars/Olympus", Task: TaskTemplate{Type: "report"}}).Validate())
+	assert.Error(t, (&Schedule{Spec: "@daily", SkipDates: []string{"25/12/2024"}, Task: TaskTemplate{Type: "report"}}).Validate())
+}
+
+func TestSchedule_Backfill(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	sched, err := NewSchedule(now, Schedule{Spec: "0 * * * *", Task: TaskTemplate{Type: "cleanup"}})
+	require.NoError(t, err)
+
+	// The scheduler was down from 06:30 to 10:00
+	runs, more, err := sched.Runs(now.Add(-3*time.Hour-30*time.Minute), now, 0)
+	require.NoError(t, err)
+	assert.False(t, more)
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 1, 31, 7, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC),
+	}, runs)
+
+	capped, more, err := sched.Runs(now.Add(-3*time.Hour-30*time.Minute), now, 2)
+	require.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, runs[:2], capped)
+
+	_, _, err = sched.Runs(now, now, 0)
+	assert.Error(t, err)
+
+	submitted, err := Backfill(ctx, q, sched, capped)
+	require.NoError(t, err)
+	require.Len(t, submitted, 2)
+	pending, err := store.GetTasksByStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	scheduledFor := []string{pending[0].Labels[RunLabel], pending[1].Labels[RunLabel]}
+	assert.ElementsMatch(t, []string{"20240131T070000Z", "20240131T080000Z"}, scheduledFor)
+	assert.Equal(t, sched.ID, pending[0].Labels[Label])
+}
+
+func TestScheduler_RunDue(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+	schedules := NewMemoryStore()
+
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	scheduler := NewScheduler(Config{Store: schedules, Queue: q, Logger: zap.NewNop(), Now: func() time.Time { return now }})
+
+	sched, err := NewSchedule(now, Schedule{
+		Spec: "*/10 * * * *",
+		Task: TaskTemplate{Type: "cleanup", Payload: map[string]interface{}{"older_than": "7d"}, Labels: map[string]string{"team": "ops"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(10*time.Minute), sched.NextRun)
+	require.NoError(t, schedules.Put(ctx, sched))
+	paused, err := NewSchedule(now, Schedule{Spec: "* * * * *", Task: TaskTemplate{Type: "cleanup"}, Paused: true})
+	require.NoError(t, err)
+	require.NoError(t, schedules.Put(ctx, paused))
+
+	// Nothing is due yet
+	require.NoError(t, scheduler.RunDue(ctx))
+	pending, err := store.GetTasksByStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	now = now.Add(10*time.Minute + time.Second)
+	require.NoError(t, scheduler.RunDue(ctx))
+	pending, err = store.GetTasksByStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	run := pending[0]
+	assert.Equal(t, "cleanup", run.Type)
+	assert.Equal(t, "7d", run.Payload["older_than"])
+	assert.Equal(t, map[string]string{"team": "ops", Label: sched.ID}, run.Labels)
+
+	sched, err = schedules.Get(ctx, sched.ID)
+	require.NoError(t, err)
+	assert.Equal(t, run.ID, sched.LastTaskID)
+	assert.Equal(t, now, *sched.LastRun)
+	assert.Equal(t, time.Date(2024, 1, 31, 10, 20, 0, 0, time.UTC), sched.NextRun)
+
+	// Checking again before the next run submits nothing more
+	require.NoError(t, scheduler.RunDue(ctx))
+	pending, err = store.GetTasksByStatus(ctx, task.StatusPending, 10)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
+
+func TestScheduler_Overlap(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+	schedules := NewMemoryStore()
+
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	scheduler := NewScheduler(Config{Store: schedules, Queue: q, Logger: zap.NewNop(), Now: func() time.Time { return now }})
+
+	newSchedule := func(overlap Overlap) *Schedule {
+		sched, err := NewSchedule(now, Schedule{Spec: "@every 1m", Task: TaskTemplate{Type: "nightly"}, Overlap: overlap})
+		require.NoError(t, err)
+		require.NoError(t, schedules.Put(ctx, sched))
+		return sched
+	}
+	skip := newSchedule(OverlapSkip)
+	cancel := newSchedule(OverlapCancel)
+	allow := newSchedule(OverlapAllow)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, scheduler.RunDue(ctx))
+	first := map[string]string{}
+	for _, sched := range []*Schedule{skip, cancel, allow} {
+		got, err := schedules.Get(ctx, sched.ID)
+		require.NoError(t, err)
+		first[sched.ID] = got.LastTaskID
+	}
+
+	// The first runs are still pending when the next ones come round
+	now = now.Add(time.Minute)
+	require.NoError(t, scheduler.RunDue(ctx))
+
+	got, err := schedules.Get(ctx, skip.ID)
+	require.NoError(t, err)
+	assert.Equal(t, first[skip.ID], got.LastTaskID, "skipped while the previous run is pending")
+	assert.Equal(t, now.Add(time.Minute), got.NextRun)
+
+	got, err = schedules.Get(ctx, cancel.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, first[cancel.ID], got.LastTaskID)
+	prev, err := store.GetTask(ctx, first[cancel.ID])
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCancelled, prev.Status)
+
+	got, err = schedules.Get(ctx, allow.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, first[allow.ID], got.LastTaskID)
+	prev, err = store.GetTask(ctx, first[allow.ID])
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusPending, prev.Status)
+
+	// Once the previous run finishes, the skipping schedule runs again
+	_, err = q.Cancel(ctx, first[skip.ID])
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	require.NoError(t, scheduler.RunDue(ctx))
+	got, err = schedules.Get(ctx, skip.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, first[skip.ID], got.LastTaskID)
+}
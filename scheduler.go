@@ -0,0 +1,220 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Queue is the part of *queue.Queue the scheduler submits runs through
+type Queue interface {
+	NewTask(taskType string, payload map[string]interface{}) *task.Task
+	Submit(ctx context.Context, t *task.Task, opts ...queue.SubmitOption) error
+	GetTask(ctx context.Context, id string) (*task.Task, error)
+	Cancel(ctx context.Context, id string) (*task.Task, error)
+}
+
+// Leadership reports whether this instance is the elected scheduler
+type Leadership interface {
+	IsLeader() bool
+}
+
+// Config holds scheduler configuration
+type Config struct {
+	Store  Store
+	Queue  Queue
+	Logger *zap.Logger
+
+	// Leader, if set, limits submitting runs to the instance leading it.
+	// An *election.Elector for the "scheduler" role fits.
+	Leader Leadership
+
+	// Interval is how often due schedules are checked, defaults to 1s
+	Interval time.Duration
+
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+// Scheduler submits the runs of due schedules
+type Scheduler struct {
+	config Config
+	logger *zap.Logger
+}
+
+// NewScheduler creates a scheduler
+func NewScheduler(cfg Config) *Scheduler {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Scheduler{config: cfg, logger: cfg.Logger}
+}
+
+// Run checks for due schedules every Interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.config.Leader != nil && !s.config.Leader.IsLeader() {
+				continue
+			}
+			if err := s.RunDue(ctx); err != nil {
+				s.logger.Error("failed to run schedules", zap.Error(err))
+			}
+		}
+	}
+}
+
+// RunDue submits a run of every schedule that is due. A run that fails to
+// submit is tried again on the next check.
+func (s *Scheduler) RunDue(ctx context.Context) error {
+	schedules, err := s.config.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.config.Now()
+	for _, sched := range schedules {
+		if sched.Paused || sched.NextRun.After(now) {
+			continue
+		}
+		s.run(ctx, sched, now)
+	}
+	return nil
+}
+
+// run submits one run of sched, unless its overlap policy skips it, and
+// schedules the next
+func (s *Scheduler) run(ctx context.Context, sched *Schedule, now time.Time) {
+	logger := s.logger.With(zap.String("schedule", sched.ID), zap.String("type", sched.Task.Type))
+
+	submit, err := s.overlap(ctx, sched, logger)
+	if err != nil {
+		metrics.ScheduledRuns.WithLabelValues(sched.ID, "failed").Inc()
+		logger.Error("failed to check the previous scheduled run", zap.Error(err))
+		return
+	}
+
+	var t *task.Task
+	if submit {
+		t = newTask(s.config.Queue, sched)
+		if err := s.config.Queue.Submit(ctx, t); err != nil {
+			metrics.ScheduledRuns.WithLabelValues(sched.ID, "failed").Inc()
+			logger.Error("failed to submit scheduled task", zap.Error(err))
+			return
+		}
+		metrics.ScheduledRuns.WithLabelValues(sched.ID, "submitted").Inc()
+		logger.Info("scheduled task submitted", zap.String("id", t.ID), zap.Time("due", sched.NextRun))
+	} else {
+		metrics.ScheduledRuns.WithLabelValues(sched.ID, "skipped").Inc()
+		logger.Info("scheduled run skipped, the previous run has not finished", zap.String("previous", sched.LastTaskID))
+	}
+
+	// Re-read the schedule, so a pause or edit made through the API while
+	// the run was submitted is not overwritten
+	current, err := s.config.Store.Get(ctx, sched.ID)
+	if err != nil {
+		logger.Error("failed to reschedule", zap.Error(err))
+		return
+	}
+	if t != nil {
+		current.LastRun = &now
+		current.LastTaskID = t.ID
+	}
+	if sameTimes(current, sched) {
+		if err := current.Reschedule(now); err != nil {
+			logger.Error("failed to reschedule", zap.Error(err))
+			return
+		}
+	}
+	if err := s.config.Store.Put(ctx, current); err != nil {
+		logger.Error("failed to reschedule", zap.Error(err))
+	}
+}
+
+// sameTimes reports whether a and b run at the same times, so an edit
+// that changed them, and already rescheduled, is kept
+func sameTimes(a, b *Schedule) bool {
+	if a.Spec != b.Spec || a.Jitter != b.Jitter || a.Timezone != b.Timezone ||
+		a.SkipWeekends != b.SkipWeekends || len(a.SkipDates) != len(b.SkipDates) {
+		return false
+	}
+	for i := range a.SkipDates {
+		if a.SkipDates[i] != b.SkipDates[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// overlap applies sched's overlap policy to its previous run, reporting
+// whether to submit this one
+func (s *Scheduler) overlap(ctx context.Context, sched *Schedule, logger *zap.Logger) (bool, error) {
+	if sched.Overlap == "" || sched.Overlap == OverlapAllow || sched.LastTaskID == "" {
+		return true, nil
+	}
+	prev, err := s.config.Queue.GetTask(ctx, sched.LastTaskID)
+	if errors.Is(err, errs.ErrTaskNotFound) {
+		// Cleaned up, so long finished
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if prev.Status.Terminal() {
+		return true, nil
+	}
+
+	if sched.Overlap == OverlapSkip {
+		return false, nil
+	}
+	_, err = s.config.Queue.Cancel(ctx, prev.ID)
+	if err != nil && !errors.Is(err, errs.ErrInvalidTransition) {
+		return false, err
+	}
+	if err == nil {
+		logger.Info("previous scheduled run cancelled", zap.String("previous", prev.ID), zap.String("status", string(prev.Status)))
+	}
+	return true, nil
+}
+
+// newTask builds a run's task from sched's template, labelled with the
+// schedule's ID
+func newTask(q Queue, sched *Schedule) *task.Task {
+	tmpl := sched.Task
+	payload := make(map[string]interface{}, len(tmpl.Payload))
+	for k, v := range tmpl.Payload {
+		payload[k] = v
+	}
+
+	t := q.NewTask(tmpl.Type, payload)
+	if tmpl.Priority != nil {
+		t.Priority = *tmpl.Priority
+	}
+	if tmpl.MaxRetries != nil {
+		t.MaxRetries = *tmpl.MaxRetries
+	}
+	t.Labels = make(map[string]string, len(tmpl.Labels)+1)
+	for k, v := range tmpl.Labels {
+		t.Labels[k] = v
+	}
+	t.Labels[Label] = sched.ID
+	return t
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"go.uber.org/zap"
+)
+
+// scheduleStore returns the configured schedule store, or responds 404
+// and returns nil
+func (s *Server) scheduleStore(w http.ResponseWriter, r *http.Request) schedule.Store {
+	if s.config.Schedules == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no schedule store is configured")
+	}
+	return s.config.Schedules
+}
+
+// handleListSchedules lists every recurring task schedule
+func (s *Server) handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	list, err := store.List(r.Context())
+	if err != nil {
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, SchedulesResponse{Schedules: list})
+}
+
+// handleCreateSchedule creates a recurring task schedule, due at the first
+// time its spec matches
+func (s *Server) handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	var req ScheduleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	def, err := req.Schedule()
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	sched, err := schedule.NewSchedule(time.Now(), def)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	if err := store.Put(r.Context(), sched); err != nil {
+		s.logger.Error("failed to save schedule", zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.logger.Info("schedule created", zap.String("schedule", sched.ID), zap.String("spec", sched.Spec))
+	s.respondJSON(w, r, http.StatusCreated, sched)
+}
+
+// handleGetSchedule returns a schedule and when it next runs
+func (s *Server) handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	sched, err := store.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, scheduleStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, sched)
+}
+
+// handleUpdateSchedule replaces a schedule's definition, keeping its ID
+// and run history, and reschedules its next run
+func (s *Server) handleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	var req ScheduleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	def, err := req.Schedule()
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if err := def.Validate(); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	s.updateSchedule(w, r, store, func(sched *schedule.Schedule) {
+		sched.Name = def.Name
+		sched.Spec = def.Spec
+		sched.Task = def.Task
+		sched.Timezone = def.Timezone
+		sched.SkipWeekends = def.SkipWeekends
+		sched.SkipDates = def.SkipDates
+		sched.Jitter = def.Jitter
+		sched.Overlap = def.Overlap
+		sched.Paused = def.Paused
+	})
+}
+
+// handlePauseSchedule stops a schedule submitting runs
+func (s *Server) handlePauseSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	s.updateSchedule(w, r, store, func(sched *schedule.Schedule) { sched.Paused = true })
+}
+
+// handleResumeSchedule lets a paused schedule submit runs again, from its
+// next run after now; runs missed while paused are skipped
+func (s *Server) handleResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	s.updateSchedule(w, r, store, func(sched *schedule.Schedule) { sched.Paused = false })
+}
+
+// updateSchedule applies change to the schedule in the URL, reschedules
+// it and responds with the result
+func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request, store schedule.Store, change func(*schedule.Schedule)) {
+	sched, err := store.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, scheduleStoreErr(err))
+		return
+	}
+	change(sched)
+	now := time.Now()
+	sched.UpdatedAt = now.UTC()
+	if err := sched.Reschedule(now); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	if err := store.Put(r.Context(), sched); err != nil {
+		s.logger.Error("failed to save schedule", zap.String("schedule", sched.ID), zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.logger.Info("schedule updated", zap.String("schedule", sched.ID), zap.Bool("paused", sched.Paused))
+	s.respondJSON(w, r, http.StatusOK, sched)
+}
+
+// handleDeleteSchedule deletes a schedule. Tasks it already submitted are
+// left alone.
+func (s *Server) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := store.Delete(r.Context(), id); err != nil {
+		s.respondErr(w, r, scheduleStoreErr(err))
+		return
+	}
+	s.logger.Info("schedule deleted", zap.String("schedule", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBackfillSchedule submits the runs of a schedule missed in a time
+// range, such as while the scheduler was down, which are otherwise skipped
+func (s *Server) handleBackfillSchedule(w http.ResponseWriter, r *http.Request) {
+	store := s.scheduleStore(w, r)
+	if store == nil {
+		return
+	}
+	var req BackfillRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	sched, err := store.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, scheduleStoreErr(err))
+		return
+	}
+
+	runs, more, err := sched.Runs(req.From, req.To, req.Limit)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	resp := BackfillResponse{Runs: runs, TaskIDs: []string{}, Truncated: more, DryRun: req.DryRun}
+	if resp.Runs == nil {
+		resp.Runs = []time.Time{}
+	}
+	if req.DryRun {
+		s.respondJSON(w, r, http.StatusOK, resp)
+		return
+	}
+
+	submitted, err := schedule.Backfill(r.Context(), s.queue, sched, runs)
+	for _, t := range submitted {
+		resp.TaskIDs = append(resp.TaskIDs, t.ID)
+	}
+	if err != nil {
+		// Runs already submitted stay queued; the log lists them, so a retry
+		// can start after the last
+		s.logger.Error("schedule backfill failed", zap.String("schedule", sched.ID),
+			zap.Int("submitted", len(submitted)), zap.Strings("task_ids", resp.TaskIDs), zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	s.logger.Info("schedule backfilled", zap.String("schedule", sched.ID),
+		zap.Time("from", req.From), zap.Time("to", req.To), zap.Int("runs", len(runs)), zap.Bool("truncated", more))
+	s.respondJSON(w, r, http.StatusOK, resp)
+}
+
+// scheduleStoreErr passes ErrScheduleNotFound through and reports anything
+// else as the store being unavailable
+func scheduleStoreErr(err error) error {
+	if errors.Is(err, errs.ErrScheduleNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err)
+}
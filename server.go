@@ -1,15 +1,28 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yourusername/distributed-task-queue/internal/artifact"
+	"github.com/yourusername/distributed-task-queue/internal/cluster"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
 	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
 	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"github.com/yourusername/distributed-task-queue/internal/workflow"
 	"go.uber.org/zap"
 )
 
@@ -18,41 +31,375 @@ type Server struct {
 	queue  *queue.Queue
 	logger *zap.Logger
 	router *chi.Mux
+	config Config
+
+	openAPISpec []byte
+
+	httpServer *http.Server
+	draining   atomic.Bool
+
+	// heartbeat registers the server in the cluster while it serves
+	heartbeat     *cluster.Heartbeat
+	heartbeatCtx  context.Context
+	stopHeartbeat context.CancelFunc
+}
+
+// Config holds API server configuration
+type Config struct {
+	Queue  *queue.Queue
+	Logger *zap.Logger
+
+	// MaxPayloadBytes caps the serialized size of a submitted task payload
+	MaxPayloadBytes int
+
+	// MaxBodyBytes caps the size of any request body other than an import
+	MaxBodyBytes int64
+
+	// MaxImportBytes caps the size of a POST /admin/import body, defaults
+	// to 1 GiB
+	MaxImportBytes int64
+
+	// RateLimit is the number of API requests per second allowed for each
+	// client: the one named with WithClientID, else the IP address. Zero
+	// disables limiting.
+	RateLimit float64
+	// RateBurst is how many requests a client may make at once
+	RateBurst int
+
+	// CORS configures cross-origin access for browser dashboards
+	CORS CORSConfig
+
+	// TrustProxyHeaders applies X-Forwarded-For, X-Real-IP,
+	// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix from the
+	// reverse proxy in front of the server. Only enable it when every
+	// request arrives through that proxy, as clients could otherwise claim
+	// any address, and so any rate limit bucket.
+	TrustProxyHeaders bool
+
+	// BasePath mounts every route under a prefix such as "/queue", for
+	// ingresses that forward the path unchanged
+	BasePath string
+
+	// Timeouts bounds how long API handlers may run
+	Timeouts TimeoutConfig
+
+	// DrainDelay is how long Shutdown keeps serving after /health starts
+	// reporting 503, giving load balancers time to stop sending traffic
+	DrainDelay time.Duration
+
+	// SigningKeys verifies the signing.Header on task submissions. A
+	// signature that is present must verify; see RequireSignedRequests.
+	SigningKeys *signing.Keys
+	// RequireSignedRequests rejects unsigned submissions with a 401
+	RequireSignedRequests bool
+	// SignatureMaxSkew is how old or far in the future a signature's
+	// timestamp may be, defaults to signing.DefaultMaxSkew
+	SignatureMaxSkew time.Duration
+
+	// Cluster, if set, is the registry GET /cluster reports, and the server
+	// registers itself in it while serving
+	Cluster cluster.Registry
+	// InstanceID identifies this server in the cluster, defaults to
+	// hostname-pid
+	InstanceID string
+
+	// LogLevel, if set, is the level of Logger, which GET and PUT
+	// /api/v1/admin/log-level read and change
+	LogLevel *zap.AtomicLevel
+
+	// Artifacts, if set, is the store workers attach task artifacts to,
+	// which GET /api/v1/tasks/{id}/artifacts links to
+	Artifacts artifact.Store
+	// ArtifactURLExpiry is how long artifact download links work,
+	// defaults to 15m
+	ArtifactURLExpiry time.Duration
+
+	// Templates, if set, is the store /api/v1/templates manages, which
+	// notification handlers render tasks from
+	Templates templates.Store
+
+	// TaskTemplates, if set, is the store /api/v1/task-templates manages,
+	// which submissions name with "template"
+	TaskTemplates schedule.TemplateStore
+
+	// Schedules, if set, is the store /api/v1/schedules manages, whose
+	// recurring tasks the elected scheduler submits
+	Schedules schedule.Store
+
+	// Workflows, if set, runs the workflows /api/v1/workflows manages
+	Workflows *workflow.Engine
+
+	// Debug serves net/http/pprof and GET /debug/queue, for diagnosing
+	// stalled workers; see DebugHandler. They have no authentication, so
+	// only enable it where the API is not exposed.
+	Debug bool
+}
+
+// TimeoutConfig holds per-route request timeouts. The deadline is set on the
+// request context, so it carries through to queue and storage calls.
+// Health, metrics and streaming endpoints have no timeout.
+type TimeoutConfig struct {
+	// Default applies to every API route without its own timeout
+	Default time.Duration
+	// Batch applies to batch submission and schedule backfills, which
+	// write many tasks
+	Batch time.Duration
 }
 
 // NewServer creates a new API server
-func NewServer(q *queue.Queue, logger *zap.Logger) *Server {
+func NewServer(cfg Config) *Server {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.MaxPayloadBytes == 0 {
+		cfg.MaxPayloadBytes = 64 * 1024
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxImportBytes == 0 {
+		cfg.MaxImportBytes = 1 << 30
+	}
+	cfg.BasePath = normalizeBasePath(cfg.BasePath)
+	if cfg.Timeouts.Default == 0 {
+		cfg.Timeouts.Default = 30 * time.Second
+	}
+	if cfg.Timeouts.Batch == 0 {
+		cfg.Timeouts.Batch = 2 * time.Minute
+	}
+	if cfg.SignatureMaxSkew == 0 {
+		cfg.SignatureMaxSkew = signing.DefaultMaxSkew
+	}
+	if cfg.ArtifactURLExpiry == 0 {
+		cfg.ArtifactURLExpiry = 15 * time.Minute
+	}
+
 	s := &Server{
-		queue:  q,
-		logger: logger,
+		queue:  cfg.Queue,
+		logger: cfg.Logger,
 		router: chi.NewRouter(),
+		config: cfg,
+	}
+
+	spec, err := buildOpenAPISpec()
+	if err != nil {
+		s.logger.Error("failed to build OpenAPI spec", zap.Error(err))
 	}
+	s.openAPISpec = spec
+
+	if cfg.Cluster != nil {
+		s.heartbeat = cluster.NewHeartbeat(cluster.HeartbeatConfig{
+			Registry: cfg.Cluster,
+			Logger:   cfg.Logger,
+			ID:       cfg.InstanceID,
+			Kind:     cluster.KindAPI,
+		})
+	}
+	s.heartbeatCtx, s.stopHeartbeat = context.WithCancel(context.Background())
 
 	s.setupRoutes()
+	s.httpServer = &http.Server{
+		Handler:           s.router,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
 	return s
 }
 
+// Start listens on addr and serves the API until Shutdown is called
+func (s *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves the API on ln until Shutdown is called. It returns nil after
+// a graceful shutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	s.logger.Info("API server listening", zap.String("addr", ln.Addr().String()))
+	if s.heartbeat != nil {
+		go s.heartbeat.Run(s.heartbeatCtx)
+	}
+	if err := s.httpServer.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown drains the server: health checks start failing, new connections
+// are refused, in-flight requests finish, and then the queue is stopped so
+// running tasks complete. It gives up once ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	s.stopHeartbeat()
+	s.logger.Info("API server draining")
+
+	if s.config.DrainDelay > 0 {
+		select {
+		case <-time.After(s.config.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	var httpErr, queueErr error
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		httpErr = fmt.Errorf("http shutdown: %w", err)
+	}
+	if s.queue != nil {
+		queueErr = s.queue.Shutdown(ctx)
+	}
+
+	s.logger.Info("API server stopped")
+	return errors.Join(httpErr, queueErr)
+}
+
 // setupRoutes configures the API routes
 func (s *Server) setupRoutes() {
 	s.router.Use(middleware.RequestID)
-	s.router.Use(middleware.RealIP)
+	s.router.Use(correlationID)
+	if s.config.TrustProxyHeaders {
+		s.router.Use(middleware.RealIP)
+		s.router.Use(forwardedHeaders)
+	}
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
-	s.router.Use(middleware.Timeout(60))
-
-	// API routes
-	s.router.Route("/api/v1", func(r chi.Router) {
-		r.Post("/tasks", s.handleSubmitTask)
-		r.Get("/tasks/{id}", s.handleGetTask)
-		r.Get("/tasks", s.handleListTasks)
-		r.Get("/stats", s.handleGetStats)
+	if len(s.config.CORS.AllowedOrigins) > 0 {
+		s.router.Use(s.cors(s.config.CORS))
+	}
+
+	if s.config.BasePath == "" {
+		s.routes(s.router)
+		return
+	}
+	s.router.Route(s.config.BasePath, s.routes)
+}
+
+// routes registers every endpoint on r
+func (s *Server) routes(r chi.Router) {
+	// API routes. Every version serves the same handlers; the version only
+	// decides how responses are shaped, so v1 clients keep working unchanged.
+	var limiter *rateLimiter
+	if s.config.RateLimit > 0 {
+		limiter = newRateLimiter(s.config.RateLimit, s.config.RateBurst)
+	}
+	protect := func(r chi.Router) {
+		if limiter != nil {
+			r.Use(s.rateLimit(limiter))
+		}
+	}
+
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(withVersion(apiV1))
+		protect(r)
+		s.apiRoutes(r)
+		r.Get("/openapi.json", s.handleOpenAPI)
+	})
+	r.Route("/api/v2", func(r chi.Router) {
+		r.Use(withVersion(apiV2))
+		protect(r)
+		s.apiRoutes(r)
 	})
 
 	// Health check
-	s.router.Get("/health", s.handleHealth)
+	r.Get("/health", s.handleHealth)
 
 	// Metrics endpoint
-	s.router.Handle("/metrics", promhttp.Handler())
+	r.Handle("/metrics", promhttp.Handler())
+
+	if s.config.Debug {
+		debugRoutes(r, s.queue)
+	}
+}
+
+// apiRoutes registers the versioned API endpoints
+func (s *Server) apiRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.limitBody(s.config.MaxBodyBytes))
+		r.Group(func(r chi.Router) {
+			r.Use(timeout(s.config.Timeouts.Default))
+			r.With(s.verifySignature).Post("/tasks", s.handleSubmitTask)
+			r.Get("/tasks/{id}", s.handleGetTask)
+			r.Post("/tasks/{id}/boost", s.handleBoostTask)
+			r.Get("/tasks/{id}/logs", s.handleGetTaskLogs)
+			r.Get("/tasks/{id}/artifacts", s.handleListArtifacts)
+			r.Get("/tasks/{id}/attempts", s.handleListAttempts)
+			r.Get("/tasks", s.handleListTasks)
+			r.Get("/stats", s.handleGetStats)
+			r.Get("/stats/timeseries", s.handleTimeSeries)
+			r.Get("/stats/errors", s.handleErrorStats)
+			r.Get("/stats/duplicates", s.handleDuplicateStats)
+			r.Get("/admission", s.handleAdmission)
+			r.Get("/scaling", s.handleScaling)
+			r.Get("/cluster", s.handleCluster)
+			r.Get("/types", s.handleListTypes)
+			r.Post("/types/{type}/enable", s.handleEnableType)
+			r.Post("/types/{type}/disable", s.handleDisableType)
+			r.Post("/reports", s.handleCreateReport)
+			r.Get("/reports/{id}", s.handleGetReport)
+			r.Get("/admin/log-level", s.handleGetLogLevel)
+			r.Put("/admin/log-level", s.handleSetLogLevel)
+			r.Get("/templates", s.handleListTemplates)
+			r.Get("/templates/{name}", s.handleGetTemplate)
+			r.Put("/templates/{name}", s.handlePutTemplate)
+			r.Delete("/templates/{name}", s.handleDeleteTemplate)
+			r.Get("/templates/{name}/versions", s.handleListTemplateVersions)
+			r.Post("/templates/{name}/render", s.handleRenderTemplate)
+			r.Get("/task-templates", s.handleListTaskTemplates)
+			r.Get("/task-templates/{name}", s.handleGetTaskTemplate)
+			r.Put("/task-templates/{name}", s.handlePutTaskTemplate)
+			r.Delete("/task-templates/{name}", s.handleDeleteTaskTemplate)
+			r.Get("/schedules", s.handleListSchedules)
+			r.Post("/schedules", s.handleCreateSchedule)
+			r.Get("/schedules/{id}", s.handleGetSchedule)
+			r.Put("/schedules/{id}", s.handleUpdateSchedule)
+			r.Delete("/schedules/{id}", s.handleDeleteSchedule)
+			r.Post("/schedules/{id}/pause", s.handlePauseSchedule)
+			r.Post("/schedules/{id}/resume", s.handleResumeSchedule)
+			r.Get("/workflows", s.handleListWorkflows)
+			r.Post("/workflows", s.handleCreateWorkflow)
+			r.Get("/workflows/{id}", s.handleGetWorkflow)
+			r.Get("/workflows/{id}/graph", s.handleWorkflowGraph)
+			r.Delete("/workflows/{id}", s.handleDeleteWorkflow)
+			r.Post("/workflows/{id}/cancel", s.handleCancelWorkflow)
+			r.Post("/workflows/{id}/resume", s.handleResumeWorkflow)
+			r.Post("/workflows/{id}/steps/{step}/approve", s.handleApproveWorkflowStep)
+			r.Get("/workflow-definitions", s.handleListDefinitions)
+			r.Get("/workflow-definitions/{name}", s.handleGetDefinition)
+			r.Put("/workflow-definitions/{name}", s.handlePutDefinition)
+			r.Delete("/workflow-definitions/{name}", s.handleDeleteDefinition)
+			r.Post("/leases/{id}/progress", s.handleLeaseProgress)
+			r.Post("/leases/{id}/ack", s.handleAckLease)
+			r.Post("/leases/{id}/nack", s.handleNackLease)
+		})
+		// Remote workers wait for a lease, and clients for a status to
+		// change, up to the wait they ask for
+		r.Post("/leases", s.handleLease)
+		r.Post("/tasks/status", s.handleTaskStatuses)
+		r.With(timeout(s.config.Timeouts.Batch), s.verifySignature).Post("/tasks/batch", s.handleSubmitBatch)
+		r.With(timeout(s.config.Timeouts.Batch)).Post("/schedules/{id}/backfill", s.handleBackfillSchedule)
+	})
+
+	// Backups stream the whole queue, so they run without a timeout
+	r.Get("/admin/export", s.handleExport)
+	r.With(s.limitBody(s.config.MaxImportBytes)).Post("/admin/import", s.handleImport)
+	r.Post("/admin/doctor", s.handleDoctor)
+}
+
+// timeout sets a deadline on the request context. Handlers see it through
+// the errors returned by the queue, which respondErr maps to a 504.
+func timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 // ServeHTTP implements http.Handler
@@ -62,63 +409,341 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleSubmitTask handles task submission
 func (s *Server) handleSubmitTask(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Type       string                 `json:"type"`
-		Priority   int                    `json:"priority"`
-		Payload    map[string]interface{} `json:"payload"`
-		MaxRetries int                    `json:"max_retries,omitempty"`
+	var req SubmitTaskRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.respondError(w, http.StatusBadRequest, "invalid request body")
+	if err := s.applyTemplate(r.Context(), &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if err := req.Validate(s.config.MaxPayloadBytes); err != nil {
+		s.respondErr(w, r, err)
 		return
 	}
 
-	if req.Type == "" {
-		s.respondError(w, http.StatusBadRequest, "task type is required")
+	t := s.newTask(req)
+	t.CorrelationID = requestCorrelationID(r)
+	t.Source = requestSource(r)
+
+	if r.URL.Query().Has("dry_run") {
+		dryRun, err := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if err != nil {
+			s.respondErr(w, r, errs.Invalidf("dry_run must be true or false"))
+			return
+		}
+		if dryRun {
+			s.handleDryRun(w, r, t, req)
+			return
+		}
+	}
+
+	if err := s.submit(r.Context(), t, req); err != nil {
+		if errors.Is(err, errs.ErrTaskExists) {
+			s.queue.RecordDuplicate(r.Context(), t.Type, t.Source)
+		}
+		s.logger.Error("failed to submit task", zap.Error(err))
+		s.setAdmissionHeaders(w, r, t.Priority, errors.Is(err, errs.ErrQueueFull))
+		s.respondErr(w, r, err)
 		return
 	}
+	s.setAdmissionHeaders(w, r, t.Priority, false)
+	if t.CorrelationID != "" {
+		w.Header().Set(correlationHeader, t.CorrelationID)
+	}
+
+	s.respondJSON(w, r, http.StatusCreated, SubmitTaskResponse{
+		TaskID:        t.ID,
+		Status:        "submitted",
+		CorrelationID: t.CorrelationID,
+	})
+}
 
-	priority := task.Priority(req.Priority)
-	if priority < task.PriorityLow || priority > task.PriorityCritical {
-		priority = task.PriorityMedium
+// handleSubmitBatch submits several tasks in one request. Tasks are
+// validated up front; submission stops at the first storage error, leaving
+// earlier tasks in the batch queued.
+func (s *Server) handleSubmitBatch(w http.ResponseWriter, r *http.Request) {
+	var req SubmitBatchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
 	}
 
-	t := task.NewTask(req.Type, priority, req.Payload)
+	for i := range req.Tasks {
+		if err := s.applyTemplate(r.Context(), &req.Tasks[i]); err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+	}
+	if err := req.Validate(s.config.MaxPayloadBytes); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	correlation := requestCorrelationID(r)
+	if correlation == "" {
+		correlation = newCorrelationID()
+	}
+	w.Header().Set(correlationHeader, correlation)
+
+	ids := make([]string, 0, len(req.Tasks))
+	lowest := task.PriorityMax
+	for i, tr := range req.Tasks {
+		t := s.newTask(tr)
+		t.Source = requestSource(r)
+		if tr.ParentID == "" || requestCorrelationID(r) != "" {
+			t.CorrelationID = correlation
+		}
+
+		if err := s.submit(r.Context(), t, tr); err != nil {
+			if errors.Is(err, errs.ErrTaskExists) {
+				s.queue.RecordDuplicate(r.Context(), t.Type, t.Source)
+			}
+			s.logger.Error("failed to submit batch",
+				zap.Int("submitted", len(ids)),
+				zap.Int("total", len(req.Tasks)),
+				zap.Error(err),
+			)
+			s.setAdmissionHeaders(w, r, t.Priority, errors.Is(err, errs.ErrQueueFull))
+			// The tasks before it stay queued; say which, so the caller
+			// can resubmit from the one that failed
+			e := errs.From(err)
+			s.writeError(w, r, e.Status, ErrorResponse{Error: e.Message, Code: e.Code, TaskIDs: ids, FailedIndex: &i})
+			return
+		}
+		ids = append(ids, t.ID)
+		if t.Priority < lowest {
+			lowest = t.Priority
+		}
+	}
+	s.setAdmissionHeaders(w, r, lowest, false)
+
+	s.respondJSON(w, r, http.StatusCreated, SubmitBatchResponse{
+		TaskIDs:       ids,
+		Status:        "submitted",
+		CorrelationID: correlation,
+	})
+}
+
+// newTask builds the task req describes, taking the priority and retries
+// it leaves out from the type's configuration
+func (s *Server) newTask(req SubmitTaskRequest) *task.Task {
+	t := s.queue.NewTask(req.Type, req.Payload)
+	if req.Priority != nil {
+		t.Priority = *req.Priority
+	}
 	if req.MaxRetries > 0 {
 		t.MaxRetries = req.MaxRetries
+	} else if req.templateRetries != nil {
+		t.MaxRetries = *req.templateRetries
 	}
+	t.Labels = req.Labels
+	t.Deadline = req.Deadline
+	t.Version = req.Version
+	t.Notify = req.Notify
+	t.FairnessKey = req.FairnessKey
+	t.Weight = req.Weight
+	t.Requires = req.Requires
+	return t
+}
 
-	if err := s.queue.Submit(r.Context(), t); err != nil {
-		s.logger.Error("failed to submit task", zap.Error(err))
-		s.respondError(w, http.StatusInternalServerError, "failed to submit task")
+// submit queues t, under the caller's ID and as a child of req.ParentID
+// when those are given. Tasks without a correlation ID take their
+// parent's, or a new one.
+func (s *Server) submit(ctx context.Context, t *task.Task, req SubmitTaskRequest, opts ...queue.SubmitOption) error {
+	if req.ID != "" {
+		// Best effort: two requests racing with the same new ID can both pass
+		if _, err := s.queue.GetTask(ctx, req.ID); err == nil {
+			return errs.ErrTaskExists
+		} else if !errors.Is(err, errs.ErrTaskNotFound) {
+			return err
+		}
+		t.ID = req.ID
+	}
+
+	if req.ParentID == "" {
+		if t.CorrelationID == "" {
+			t.CorrelationID = newCorrelationID()
+		}
+		return s.queue.Submit(ctx, t, opts...)
+	}
+
+	parent, err := s.queue.GetTask(ctx, req.ParentID)
+	if err != nil {
+		return err
+	}
+	if req.PriorityOverride {
+		opts = append(opts, queue.WithPriorityOverride(t.Priority))
+	}
+	return s.queue.SubmitChild(ctx, parent, t, opts...)
+}
+
+// handleDryRun runs a submission's checks and reports what would happen to
+// the task, without storing it
+func (s *Server) handleDryRun(w http.ResponseWriter, r *http.Request, t *task.Task, req SubmitTaskRequest) {
+	var resp DryRunResponse
+	if err := s.submit(r.Context(), t, req, queue.DryRun(&resp.Plan)); err != nil {
+		s.respondErr(w, r, err)
 		return
 	}
 
-	s.respondJSON(w, http.StatusCreated, map[string]interface{}{
-		"task_id": t.ID,
-		"status":  "submitted",
-	})
+	if s.config.Cluster != nil {
+		members, err := s.config.Cluster.Members(r.Context())
+		if err != nil {
+			s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+			return
+		}
+		workers := 0
+		for _, m := range members {
+			if m.Kind != cluster.KindWorker || m.Draining || !queue.Satisfies(m.Tags, t.Requires) {
+				continue
+			}
+			for _, taskType := range m.Types {
+				if taskType == t.Type {
+					workers++
+					break
+				}
+			}
+		}
+		resp.Workers = &workers
+		if workers == 0 && len(t.Requires) > 0 {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("no live worker with the required tags handles type %q", t.Type))
+		} else if workers == 0 {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("no live worker handles type %q", t.Type))
+		} else if v := cluster.SupportedVersions(members)[t.Type]; t.Version > v {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("payload version %d is newer than every worker understands (%d)", t.Version, v))
+		}
+	}
+
+	s.respondJSON(w, r, http.StatusOK, resp)
 }
 
 // handleGetTask retrieves a task by ID
 func (s *Server) handleGetTask(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		s.respondError(w, http.StatusBadRequest, "task ID is required")
+		s.respondError(w, r, http.StatusBadRequest, errs.CodeInvalidRequest, "task ID is required")
 		return
 	}
 
 	t, err := s.queue.GetTask(r.Context(), id)
 	if err != nil {
-		s.respondError(w, http.StatusNotFound, "task not found")
+		s.respondErr(w, r, err)
+		return
+	}
+
+	resp := TaskResponse{Task: t}
+	if t.Status == task.StatusPending {
+		pos, err := s.queue.Position(r.Context(), id)
+		switch {
+		case err == nil:
+			position := pos.Ahead + 1
+			resp.Position = &position
+			if !pos.EstimatedStart.IsZero() {
+				resp.EstimatedStartAt = &pos.EstimatedStart
+			}
+		case !errors.Is(err, errs.ErrTaskNotFound):
+			// The task itself was found; leave its position out
+			s.logger.Warn("failed to get queue position", zap.String("id", id), zap.Error(err))
+		}
+	}
+
+	s.respondJSON(w, r, http.StatusOK, resp)
+}
+
+// statusPollInterval is how often a status request that waits for a change
+// reads its tasks again
+const statusPollInterval = 500 * time.Millisecond
+
+// handleTaskStatuses reports the status of many tasks at once. With a wait
+// it responds as soon as one of them changes, or once the wait is over.
+func (s *Server) handleTaskStatuses(w http.ResponseWriter, r *http.Request) {
+	var req TaskStatusRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	wait, err := req.Validate()
+	if err != nil {
+		s.respondErr(w, r, err)
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, t)
+	found, err := s.queue.GetTasks(r.Context(), req.IDs)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	known := make(map[string]task.Status, len(req.IDs))
+	for _, id := range req.IDs {
+		if status, ok := req.Known[id]; ok {
+			known[id] = status
+		} else if t, ok := found[id]; ok {
+			known[id] = t.Status
+		}
+	}
+
+	var changed []string
+	deadline := time.Now().Add(wait)
+	for {
+		changed = changed[:0]
+		for _, id := range req.IDs {
+			if t, ok := found[id]; ok && t.Status != known[id] {
+				changed = append(changed, id)
+			}
+		}
+		if len(changed) > 0 || !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(statusPollInterval):
+		}
+		if found, err = s.queue.GetTasks(r.Context(), req.IDs); err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+	}
+
+	resp := TaskStatusResponse{Tasks: make([]TaskStatus, 0, len(found))}
+	if wait > 0 {
+		resp.Changed = changed
+	}
+	for _, id := range req.IDs {
+		t, ok := found[id]
+		if !ok {
+			resp.Missing = append(resp.Missing, id)
+			continue
+		}
+		resp.Tasks = append(resp.Tasks, TaskStatus{
+			ID:          t.ID,
+			Status:      t.Status,
+			Error:       t.Error,
+			Result:      t.Result,
+			CompletedAt: t.CompletedAt,
+		})
+	}
+	s.respondJSON(w, r, http.StatusOK, resp)
 }
 
-// handleListTasks lists tasks (placeholder for pagination)
+// handleBoostTask moves a pending task to critical priority, ahead of the
+// rest of the queue
+func (s *Server) handleBoostTask(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	t, err := s.queue.Boost(r.Context(), id)
+	if err != nil {
+		s.logger.Warn("failed to boost task", zap.String("id", id), zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	s.respondJSON(w, r, http.StatusOK, t)
+}
+
+// handleListTasks lists tasks with a given status and labels, one page at a time
 func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
 	statusParam := r.URL.Query().Get("status")
 	limitParam := r.URL.Query().Get("limit")
@@ -133,16 +758,47 @@ func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
 	status := task.StatusPending
 	if statusParam != "" {
 		status = task.Status(statusParam)
+		if !status.Valid() {
+			s.respondError(w, r, http.StatusBadRequest, errs.CodeInvalidRequest, "unknown status: "+statusParam)
+			return
+		}
 	}
 
-	// This is a simplified implementation
-	// In production, you'd want proper pagination
-	s.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"tasks":  []task.Task{},
-		"total":  0,
-		"limit":  limit,
-		"status": status,
-	})
+	labels, err := parseLabelSelector(r.URL.Query().Get("labels"))
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	position, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	tasks, next, err := s.queue.ListTasks(r.Context(), status, labels, position, limit)
+	if err != nil {
+		s.logger.Error("failed to list tasks", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	page := Pagination{Limit: limit}
+	if next != "" {
+		page.NextCursor = encodeCursor(next)
+	}
+
+	if versionFrom(r.Context()) == apiV1 {
+		s.respondJSON(w, r, http.StatusOK, ListTasksResponse{
+			Tasks:      tasks,
+			Total:      len(tasks),
+			Limit:      limit,
+			Status:     status,
+			NextCursor: page.NextCursor,
+		})
+		return
+	}
+	s.respondPage(w, r, http.StatusOK, tasks, page)
 }
 
 // handleGetStats returns queue statistics
@@ -150,30 +806,287 @@ func (s *Server) handleGetStats(w http.ResponseWriter, r *http.Request) {
 	stats, err := s.queue.GetStats(r.Context())
 	if err != nil {
 		s.logger.Error("failed to get stats", zap.Error(err))
-		s.respondError(w, http.StatusInternalServerError, "failed to get stats")
+		s.respondErr(w, r, err)
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, stats)
+	s.respondJSON(w, r, http.StatusOK, stats)
 }
 
-// handleHealth returns health status
+// handleTimeSeries returns throughput and latency over a recent window
+func (s *Server) handleTimeSeries(w http.ResponseWriter, r *http.Request) {
+	window, step, err := parseTimeSeriesParams(r)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	points, err := s.queue.TimeSeries(r.Context(), window, step)
+	if err != nil {
+		s.logger.Error("failed to get time series", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	s.respondJSON(w, r, http.StatusOK, TimeSeriesResponse{
+		Window: window.String(),
+		Step:   step.String(),
+		Points: points,
+	})
+}
+
+// handleErrorStats returns the most frequent errors of failed attempts
+// over a recent window, by task type
+func (s *Server) handleErrorStats(w http.ResponseWriter, r *http.Request) {
+	window, limit, err := parseErrorStatsParams(r)
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	types, err := s.queue.TopErrors(r.Context(), window, limit)
+	if err != nil {
+		s.logger.Error("failed to get error stats", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+
+	s.respondJSON(w, r, http.StatusOK, ErrorStatsResponse{
+		Window: window.String(),
+		Types:  types,
+	})
+}
+
+// handleCluster lists the live API servers and workers, and which of them
+// lead each election role
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if s.config.Cluster == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no cluster registry is configured")
+		return
+	}
+
+	members, err := s.config.Cluster.Members(r.Context())
+	if err != nil {
+		s.logger.Error("failed to list cluster members", zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+
+	now := time.Now()
+	resp := ClusterResponse{
+		Members:  make([]ClusterMember, 0, len(members)),
+		Leaders:  make(map[string]string),
+		Versions: cluster.SupportedVersions(members),
+	}
+	for _, m := range members {
+		resp.Members = append(resp.Members, ClusterMember{
+			ID:        m.ID,
+			Kind:      m.Kind,
+			Version:   m.Version,
+			Host:      m.Host,
+			StartedAt: m.StartedAt,
+			LastSeen:  m.LastSeen,
+			Uptime:    now.Sub(m.StartedAt).Round(time.Second).String(),
+			Types:     m.Types,
+			Leads:     m.Leads,
+			Versions:  m.Versions,
+			Tags:      m.Tags,
+			Draining:  m.Draining,
+		})
+		for _, role := range m.Leads {
+			resp.Leaders[role] = m.ID
+		}
+	}
+
+	s.respondJSON(w, r, http.StatusOK, resp)
+}
+
+// handleGetTaskLogs returns what the task's handler logged, as JSON lines
+// oldest first
+func (s *Server) handleGetTaskLogs(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := s.queue.GetTask(r.Context(), id); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	logs, err := s.queue.TaskLogs(r.Context(), id)
+	if err != nil {
+		s.logger.Error("failed to get task logs", zap.String("id", id), zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	if reqID := middleware.GetReqID(r.Context()); reqID != "" {
+		w.Header().Set("X-Request-ID", reqID)
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Write(logs)
+}
+
+// handleExport streams every task record as JSON lines
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	n, err := s.queue.Export(r.Context(), w)
+	if err == nil {
+		return
+	}
+	s.logger.Error("failed to export tasks", zap.Int("exported", n), zap.Error(err))
+	if n == 0 {
+		s.respondErr(w, r, err)
+		return
+	}
+	// The status is already sent; abort so the client sees a truncated
+	// response rather than a short but complete-looking export
+	panic(http.ErrAbortHandler)
+}
+
+// handleImport saves the task records in the request body, as written by
+// handleExport
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	result, err := s.queue.Import(r.Context(), r.Body)
+	if err != nil {
+		s.logger.Error("failed to import tasks",
+			zap.Int("imported", result.Imported),
+			zap.Int("skipped", result.Skipped),
+			zap.Error(err),
+		)
+		s.respondErr(w, r, err)
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, result)
+}
+
+// handleDoctor checks storage for inconsistencies, repairing them with
+// ?repair=true. Processing tasks count as orphaned when the instance that
+// started them has left the cluster registry, or, for tasks whose handler
+// extends its lease, when the lease runs out.
+func (s *Server) handleDoctor(w http.ResponseWriter, r *http.Request) {
+	opts := queue.DoctorOptions{}
+	if r.URL.Query().Has("repair") {
+		repair, err := strconv.ParseBool(r.URL.Query().Get("repair"))
+		if err != nil {
+			s.respondErr(w, r, errs.Invalidf("repair must be true or false"))
+			return
+		}
+		opts.Repair = repair
+	}
+	if s.config.Cluster != nil {
+		members, err := s.config.Cluster.Members(r.Context())
+		if err != nil {
+			s.logger.Error("failed to list cluster members", zap.Error(err))
+			s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+			return
+		}
+		opts.LiveInstances = []string{}
+		for _, m := range members {
+			opts.LiveInstances = append(opts.LiveInstances, m.ID)
+		}
+	}
+
+	report, err := s.queue.Doctor(r.Context(), opts)
+	if err != nil {
+		s.logger.Error("failed to check storage", zap.Error(err))
+		s.respondErr(w, r, err)
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, report)
+}
+
+// handleHealth returns health status, failing while the server drains.
+// Handlers failing their health checks degrade it without failing it, as
+// the process still serves the API and runs every other type.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	s.respondJSON(w, http.StatusOK, map[string]string{
-		"status": "healthy",
+	if s.draining.Load() {
+		s.respondJSON(w, r, http.StatusServiceUnavailable, HealthResponse{
+			Status: "draining",
+		})
+		return
+	}
+	if unhealthy := s.queue.UnhealthyHandlers(); len(unhealthy) > 0 {
+		s.respondJSON(w, r, http.StatusOK, HealthResponse{
+			Status:            "degraded",
+			UnhealthyHandlers: unhealthy,
+		})
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, HealthResponse{
+		Status: "healthy",
 	})
 }
 
-// respondJSON writes a JSON response
-func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}) {
+// handleOpenAPI serves the generated OpenAPI spec
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if s.openAPISpec == nil {
+		s.respondErr(w, r, errs.ErrInternal)
+		return
+	}
+
+	// Point clients at the URL they used to reach us, so generated clients
+	// and API explorers work behind the ingress
+	var spec map[string]json.RawMessage
+	if err := json.Unmarshal(s.openAPISpec, &spec); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	url := s.externalPath(r, "")
+	if r.URL.Scheme != "" {
+		url = r.URL.Scheme + "://" + r.Host + url
+	} else if url == "" {
+		url = "/"
+	}
+	spec["servers"], _ = json.Marshal([]map[string]string{{"url": url}})
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(spec)
+}
+
+// respondJSON writes a JSON response, wrapped in an Envelope for v2 routes
+func (s *Server) respondJSON(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	if versionFrom(r.Context()) >= apiV2 {
+		data = Envelope{Data: data, RequestID: middleware.GetReqID(r.Context())}
+	}
+	s.writeJSON(w, r, status, data)
+}
+
+// respondPage writes a page of a list response in an Envelope
+func (s *Server) respondPage(w http.ResponseWriter, r *http.Request, status int, data interface{}, page Pagination) {
+	s.writeJSON(w, r, status, Envelope{
+		Data:       data,
+		Pagination: &page,
+		RequestID:  middleware.GetReqID(r.Context()),
+	})
 }
 
 // respondError writes an error response
-func (s *Server) respondError(w http.ResponseWriter, status int, message string) {
-	s.respondJSON(w, status, map[string]string{
-		"error": message,
+func (s *Server) respondError(w http.ResponseWriter, r *http.Request, status int, code errs.Code, message string) {
+	s.writeError(w, r, status, ErrorResponse{
+		Error: message,
+		Code:  code,
 	})
 }
+
+// writeError writes body, in an envelope for API v2
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, body ErrorResponse) {
+	if versionFrom(r.Context()) >= apiV2 {
+		s.writeJSON(w, r, status, Envelope{Error: &body, RequestID: middleware.GetReqID(r.Context())})
+		return
+	}
+	s.writeJSON(w, r, status, body)
+}
+
+// respondErr maps err onto its HTTP status and error code and writes it
+func (s *Server) respondErr(w http.ResponseWriter, r *http.Request, err error) {
+	e := errs.From(err)
+	s.respondError(w, r, e.Status, e.Code, e.Message)
+}
+
+// writeJSON encodes body as the response
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		w.Header().Set("X-Request-ID", id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
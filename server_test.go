@@ -4,16 +4,34 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/artifact"
+	"github.com/yourusername/distributed-task-queue/internal/cluster"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
 	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/reporting"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
 	"github.com/yourusername/distributed-task-queue/internal/storage"
 	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"github.com/yourusername/distributed-task-queue/internal/workerpb"
+	"github.com/yourusername/distributed-task-queue/internal/workflow"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func setupTestServer(t *testing.T) (*Server, *queue.Queue) {
@@ -25,7 +43,10 @@ func setupTestServer(t *testing.T) (*Server, *queue.Queue) {
 		Logger:  logger,
 	})
 
-	server := NewServer(q, logger)
+	server := NewServer(Config{
+		Queue:  q,
+		Logger: logger,
+	})
 	return server, q
 }
 
@@ -58,6 +79,80 @@ func TestAPI_SubmitTask(t *testing.T) {
 	assert.Equal(t, "submitted", response["status"])
 }
 
+func TestAPI_SubmitTask_Priority(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	for body, want := range map[string]task.Priority{
+		`{"type": "test_task", "priority": 57}`:     57,
+		`{"type": "test_task", "priority": "high"}`: task.PriorityHigh,
+		`{"type": "test_task", "priority": "LOW"}`:  task.PriorityLow,
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		var response SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		stored, err := q.GetTask(context.Background(), response.TaskID)
+		require.NoError(t, err)
+		assert.Equal(t, want, stored.Priority, body)
+	}
+}
+
+func TestAPI_SubmitTask_TypeDefaults(t *testing.T) {
+	server, q := setupTestServer(t)
+	critical, retries := task.PriorityCritical, 9
+	require.NoError(t, q.SetTypeConfig("page_oncall", queue.TypeConfig{Priority: &critical, MaxRetries: &retries, Queue: "alerts"}))
+
+	submit := func(body string) *task.Task {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		var response SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		stored, err := q.GetTask(context.Background(), response.TaskID)
+		require.NoError(t, err)
+		return stored
+	}
+
+	defaulted := submit(`{"type": "page_oncall"}`)
+	assert.Equal(t, task.PriorityCritical, defaulted.Priority)
+	assert.Equal(t, 9, defaulted.MaxRetries)
+	assert.Equal(t, "alerts", defaulted.Labels[queue.QueueLabel])
+
+	given := submit(`{"type": "page_oncall", "priority": "low", "max_retries": 1}`)
+	assert.Equal(t, task.PriorityLow, given.Priority)
+	assert.Equal(t, 1, given.MaxRetries)
+}
+
+func TestAPI_SubmitTask_LegacyPriority(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	// Clients of the four-level scale keep their meaning; 7 was out of
+	// range there and is a fine-grained priority now
+	for body, want := range map[string]task.Priority{
+		`{"type": "test_task", "priority": 0}`: task.PriorityLow,
+		`{"type": "test_task", "priority": 1}`: task.PriorityMedium,
+		`{"type": "test_task", "priority": 2}`: task.PriorityHigh,
+		`{"type": "test_task", "priority": 3}`: task.PriorityCritical,
+		`{"type": "test_task", "priority": 7}`: 7,
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		var response SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		stored, err := q.GetTask(context.Background(), response.TaskID)
+		require.NoError(t, err)
+		assert.Equal(t, want, stored.Priority, body)
+	}
+}
+
 func TestAPI_SubmitTask_InvalidRequest(t *testing.T) {
 	server, _ := setupTestServer(t)
 
@@ -79,6 +174,72 @@ func TestAPI_SubmitTask_InvalidRequest(t *testing.T) {
 			reqBody:  nil,
 			wantCode: http.StatusBadRequest,
 		},
+		{
+			name: "unknown field",
+			reqBody: map[string]interface{}{
+				"type":    "test_task",
+				"timeout": 30,
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "priority out of range",
+			reqBody: map[string]interface{}{
+				"type":     "test_task",
+				"priority": 101,
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "unknown priority name",
+			reqBody: map[string]interface{}{
+				"type":     "test_task",
+				"priority": "urgent",
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "negative max retries",
+			reqBody: map[string]interface{}{
+				"type":        "test_task",
+				"max_retries": -1,
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "payload too large",
+			reqBody: map[string]interface{}{
+				"type": "test_task",
+				"payload": map[string]interface{}{
+					"blob": strings.Repeat("x", 128*1024),
+				},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "notify without a channel",
+			reqBody: map[string]interface{}{
+				"type":   "test_task",
+				"notify": map[string]interface{}{"on": []string{"failed"}},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "notify on a non-terminal status",
+			reqBody: map[string]interface{}{
+				"type":   "test_task",
+				"notify": map[string]interface{}{"webhook": "https://example.com/hook", "on": []string{"retrying"}},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "fairness key with spaces",
+			reqBody: map[string]interface{}{
+				"type":         "test_task",
+				"fairness_key": "customer 42",
+			},
+			wantCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -136,6 +297,12 @@ func TestAPI_GetTask_NotFound(t *testing.T) {
 	server.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var response map[string]string
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+
+	assert.Equal(t, "task_not_found", response["code"])
 }
 
 func TestAPI_GetStats(t *testing.T) {
@@ -187,3 +354,1811 @@ func TestAPI_Metrics(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "tasks_submitted_total")
 }
+
+func TestAPI_OpenAPISpec(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec map[string]interface{}
+	err := json.NewDecoder(w.Body).Decode(&spec)
+	require.NoError(t, err)
+
+	assert.Equal(t, "3.0.3", spec["openapi"])
+	assert.Contains(t, spec["paths"], "/api/v1/tasks")
+
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	submit := schemas["SubmitTaskRequest"].(map[string]interface{})
+	priority := submit["properties"].(map[string]interface{})["priority"].(map[string]interface{})
+	assert.Equal(t, float64(task.PriorityMin), priority["minimum"])
+	assert.Equal(t, float64(task.PriorityMax), priority["maximum"])
+}
+
+func TestAPI_ListTasks_Pagination(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		testTask := task.NewTask("test_task", task.PriorityMedium, nil)
+		require.NoError(t, q.Submit(ctx, testTask))
+	}
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		req := httptest.NewRequest("GET", "/api/v1/tasks?status=pending&limit=2&cursor="+cursor, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response ListTasksResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		for _, tk := range response.Tasks {
+			seen[tk.ID] = true
+		}
+		cursor = response.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	assert.Len(t, seen, 5)
+	assert.Empty(t, cursor)
+}
+
+func TestAPI_ListTasks_CursorKeepsPosition(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 6; i++ {
+		testTask := task.NewTask("test_task", task.PriorityMedium, nil)
+		require.NoError(t, q.Submit(ctx, testTask))
+		ids = append(ids, testTask.ID)
+	}
+
+	listPage := func(cursor string) ListTasksResponse {
+		req := httptest.NewRequest("GET", "/api/v1/tasks?status=pending&limit=2&cursor="+cursor, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response ListTasksResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+
+	first := listPage("")
+	require.Len(t, first.Tasks, 2)
+	require.NotEmpty(t, first.NextCursor)
+
+	// Leaving the index ahead of the cursor must not shift the pages after
+	// it, and neither must a task submitted since
+	_, err := q.Cancel(ctx, first.Tasks[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, q.Submit(ctx, task.NewTask("test_task", task.PriorityMedium, nil)))
+
+	seen := map[string]int{first.Tasks[0].ID: 1, first.Tasks[1].ID: 1}
+	cursor := first.NextCursor
+	for cursor != "" {
+		response := listPage(cursor)
+		for _, tk := range response.Tasks {
+			seen[tk.ID]++
+		}
+		cursor = response.NextCursor
+	}
+	for _, id := range ids {
+		assert.Equal(t, 1, seen[id], "task %s", id)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks?status=pending&cursor=bzoxMA", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_V2_Envelope(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	ctx := context.Background()
+	testTask := task.NewTask("test_task", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, testTask))
+
+	req := httptest.NewRequest("GET", "/api/v2/tasks/"+testTask.ID, nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data      task.Task `json:"data"`
+		RequestID string    `json:"request_id"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, testTask.ID, response.Data.ID)
+	assert.NotEmpty(t, response.RequestID)
+	assert.Equal(t, response.RequestID, w.Header().Get("X-Request-ID"))
+
+	req = httptest.NewRequest("GET", "/api/v2/tasks/nonexistent-id", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var errResponse Envelope
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResponse))
+	require.NotNil(t, errResponse.Error)
+	assert.Equal(t, "task_not_found", string(errResponse.Error.Code))
+
+	req = httptest.NewRequest("GET", "/api/v2/tasks?limit=1", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	var listResponse Envelope
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listResponse))
+	require.NotNil(t, listResponse.Pagination)
+	assert.Equal(t, 1, listResponse.Pagination.Limit)
+}
+
+func TestAPI_RateLimit(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	q := queue.NewQueue(queue.Config{
+		Storage: storage.NewMemoryStorage(),
+		Logger:  logger,
+	})
+	server := NewServer(Config{
+		Queue:     q,
+		Logger:    logger,
+		RateLimit: 1,
+		RateBurst: 2,
+	})
+
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/api/v1/stats", nil)
+		req.Header.Set("X-API-Key", "producer-a")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests {
+			assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		}
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	// Another client has its own bucket
+	req := httptest.NewRequest("GET", "/api/v1/stats", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// So does one that authentication in front of the server verified
+	req = httptest.NewRequest("GET", "/api/v1/stats", nil)
+	req = req.WithContext(WithClientID(req.Context(), "producer-a"))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPI_RateLimitSpoofedHeaders(t *testing.T) {
+	logger := zap.NewNop()
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: logger})
+	request := func(server *Server, i int) int {
+		req := httptest.NewRequest("GET", "/api/v1/stats", nil)
+		req.Header.Set("X-API-Key", fmt.Sprintf("key-%d", i))
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		req.Header.Set("X-Real-IP", fmt.Sprintf("203.0.113.%d", i))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Headers the client sets itself share the connection's bucket
+	server := NewServer(Config{Queue: q, Logger: logger, RateLimit: 1, RateBurst: 1})
+	assert.Equal(t, http.StatusOK, request(server, 1))
+	assert.Equal(t, http.StatusTooManyRequests, request(server, 2))
+	assert.Equal(t, http.StatusTooManyRequests, request(server, 3))
+
+	// Behind a trusted proxy, the address it reports picks the bucket
+	server = NewServer(Config{Queue: q, Logger: logger, RateLimit: 1, RateBurst: 1, TrustProxyHeaders: true})
+	assert.Equal(t, http.StatusOK, request(server, 1))
+	assert.Equal(t, http.StatusOK, request(server, 2))
+	assert.Equal(t, http.StatusTooManyRequests, request(server, 2))
+}
+
+func TestAPI_BodyTooLarge(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	q := queue.NewQueue(queue.Config{
+		Storage: storage.NewMemoryStorage(),
+		Logger:  logger,
+	})
+	server := NewServer(Config{
+		Queue:        q,
+		Logger:       logger,
+		MaxBodyBytes: 1024,
+	})
+
+	body := `{"type": "test_task", "payload": {"blob": "` + strings.Repeat("x", 2048) + `"}}`
+	req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Without a declared length the limit is enforced while decoding
+	req = httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestAPI_CORS(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	q := queue.NewQueue(queue.Config{
+		Storage: storage.NewMemoryStorage(),
+		Logger:  logger,
+	})
+	server := NewServer(Config{
+		Queue:  q,
+		Logger: logger,
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"https://*.example.com"},
+			MaxAge:         10 * time.Minute,
+		},
+	})
+
+	// Preflight from an allowed origin is answered before routing
+	req := httptest.NewRequest("OPTIONS", "/api/v1/tasks", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://dash.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	// Simple requests get the origin echoed back
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://dash.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// Other origins get no CORS headers
+	req = httptest.NewRequest("OPTIONS", "/api/v1/tasks", nil)
+	req.Header.Set("Origin", "https://evil.test")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestAPI_BasePathAndForwardedHeaders(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	q := queue.NewQueue(queue.Config{
+		Storage: storage.NewMemoryStorage(),
+		Logger:  logger,
+	})
+	server := NewServer(Config{
+		Queue:             q,
+		Logger:            logger,
+		BasePath:          "/queue/",
+		TrustProxyHeaders: true,
+	})
+
+	req := httptest.NewRequest("GET", "/queue/health", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("GET", "/health", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest("GET", "/queue/api/v1/openapi.json", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "ops.example.com")
+	req.Header.Set("X-Forwarded-Prefix", "/internal")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&spec))
+	require.Len(t, spec.Servers, 1)
+	assert.Equal(t, "https://ops.example.com/internal/queue", spec.Servers[0].URL)
+}
+
+func TestAPI_SubmitBatch(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	body := `{"tasks": [{"type": "a", "priority": 1}, {"type": "b", "priority": 3}]}`
+	req := httptest.NewRequest("POST", "/api/v1/tasks/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp SubmitBatchResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.TaskIDs, 2)
+
+	got, err := q.GetTask(context.Background(), resp.TaskIDs[1])
+	require.NoError(t, err)
+	assert.Equal(t, "b", got.Type)
+
+	// One bad task rejects the whole batch
+	body = `{"tasks": [{"type": "a"}, {"type": ""}]}`
+	req = httptest.NewRequest("POST", "/api/v1/tasks/batch", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "tasks[1]")
+}
+
+func TestAPI_SubmitBatch_PartialFailure(t *testing.T) {
+	server, q := setupTestServer(t)
+	ctx := context.Background()
+
+	existing := task.NewTask("b", task.PriorityLow, nil)
+	existing.ID = "order-42"
+	require.NoError(t, q.Submit(ctx, existing))
+
+	// The second task's ID is taken once the first is already queued
+	body := `{"tasks": [{"type": "a"}, {"id": "order-42", "type": "b"}, {"type": "c"}]}`
+	req := httptest.NewRequest("POST", "/api/v1/tasks/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusConflict, w.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, errs.CodeTaskExists, resp.Code)
+	require.NotNil(t, resp.FailedIndex)
+	assert.Equal(t, 1, *resp.FailedIndex)
+	require.Len(t, resp.TaskIDs, 1)
+
+	got, err := q.GetTask(ctx, resp.TaskIDs[0])
+	require.NoError(t, err)
+	assert.Equal(t, "a", got.Type)
+}
+
+// slowStorage blocks writes until the caller's context is done
+type slowStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s slowStorage) SaveTask(ctx context.Context, t *task.Task) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestAPI_Timeout(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	q := queue.NewQueue(queue.Config{
+		Storage: slowStorage{storage.NewMemoryStorage()},
+		Logger:  logger,
+	})
+	server := NewServer(Config{
+		Queue:    q,
+		Logger:   logger,
+		Timeouts: TimeoutConfig{Default: 10 * time.Millisecond},
+	})
+
+	req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"type": "test_task"}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, errs.CodeTimeout, resp.Code)
+}
+
+// blockingStorage holds writes until release is closed
+type blockingStorage struct {
+	*storage.MemoryStorage
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStorage) SaveTask(ctx context.Context, t *task.Task) error {
+	close(s.entered)
+	<-s.release
+	return s.MemoryStorage.SaveTask(ctx, t)
+}
+
+func TestAPI_GracefulShutdown(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := blockingStorage{
+		MemoryStorage: storage.NewMemoryStorage(),
+		entered:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: logger})
+	server := NewServer(Config{Queue: q, Logger: logger})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(ln) }()
+
+	// Start a request that is still in flight when shutdown begins
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/api/v1/tasks", "application/json",
+			strings.NewReader(`{"type": "test_task"}`))
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-store.entered
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	// Health reports draining while the request finishes
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		return w.Code == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+
+	close(store.release)
+
+	assert.Equal(t, http.StatusCreated, <-status)
+	assert.NoError(t, <-shutdownErr)
+	assert.NoError(t, <-serveErr)
+
+	// Stopping the queue again is harmless
+	q.Stop()
+}
+
+func TestAPI_TimeSeries(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/stats/timeseries?window=24h", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp TimeSeriesResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "24m0s", resp.Step)
+	assert.Len(t, resp.Points, 60)
+
+	req = httptest.NewRequest("GET", "/api/v1/stats/timeseries?window=48h", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_ErrorStats(t *testing.T) {
+	server, q := setupTestServer(t)
+	q.RegisterHandler("flaky", func(ctx context.Context, t *task.Task) error {
+		return errors.New("connection refused")
+	})
+	for i := 0; i < 2; i++ {
+		tk := task.NewTask("flaky", task.PriorityLow, nil)
+		tk.MaxRetries = 0
+		require.NoError(t, q.Submit(context.Background(), tk))
+		_, err := q.ProcessOne(context.Background())
+		require.NoError(t, err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/stats/errors?window=1h", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp ErrorStatsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "1h0m0s", resp.Window)
+	require.Len(t, resp.Types["flaky"], 1)
+	assert.Equal(t, "connection refused", resp.Types["flaky"][0].Signature)
+	assert.Equal(t, task.CategoryHandler, resp.Types["flaky"][0].Category)
+	assert.Equal(t, int64(2), resp.Types["flaky"][0].Count)
+
+	for _, query := range []string{"window=48h", "limit=0"} {
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats/errors?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestAPI_DuplicateStats(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	submit := func(apiKey string) int {
+		body := `{"id": "order-1", "type": "send_email", "payload": {}}`
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusCreated, submit("producer-a"))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusConflict, submit("producer-a"))
+	}
+	assert.Equal(t, http.StatusConflict, submit("producer-b"))
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats/duplicates?window=1h", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "producer-a")
+
+	var resp DuplicateStatsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "1h0m0s", resp.Window)
+	assert.Equal(t, map[string]int64{"send_email": 4}, resp.Types)
+	require.Len(t, resp.Counts, 2)
+	assert.Equal(t, int64(3), resp.Counts[0].Count)
+	assert.Regexp(t, `^key:[0-9a-f]{8}$`, resp.Counts[0].Producer)
+	assert.Equal(t, int64(3), resp.Producers[resp.Counts[0].Producer])
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats/duplicates?window=48h", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_Admission(t *testing.T) {
+	logger := zap.NewNop()
+	q := queue.NewQueue(queue.Config{
+		Storage:     storage.NewMemoryStorage(),
+		Logger:      logger,
+		DepthLimits: queue.DepthLimits{Total: 1},
+	})
+	server := NewServer(Config{Queue: q, Logger: logger})
+
+	submit := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"type": "test_task"}`))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := submit()
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "reject", w.Header().Get("X-Admission-State"))
+	assert.Equal(t, "60", w.Header().Get("X-Suggested-Delay"))
+
+	w = submit()
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/admission", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp queue.Admission
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Priorities, len(task.Classes))
+	assert.Equal(t, task.PriorityCritical, resp.Priorities[0].Priority)
+	for _, p := range resp.Priorities {
+		assert.Equal(t, queue.AdmissionReject, p.State)
+		assert.Equal(t, int64(60000), p.SuggestedDelayMs)
+	}
+}
+
+func TestAPI_Scaling(t *testing.T) {
+	server, q := setupTestServer(t)
+	require.NoError(t, q.SetTypeConfig("send_email", queue.TypeConfig{Queue: "email"}))
+	require.NoError(t, q.SetTypeConfig("send_sms", queue.TypeConfig{Queue: "email"}))
+	for _, taskType := range []string{"send_email", "send_email", "send_sms", "export_data"} {
+		require.NoError(t, q.Submit(context.Background(), task.NewTask(taskType, task.PriorityLow, nil)))
+	}
+
+	scaling := func(query string) ScalingResponse {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/scaling"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code, query)
+		var resp ScalingResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	resp := scaling("?queue=email")
+	assert.Equal(t, []string{"send_email", "send_sms"}, resp.Types)
+	assert.Equal(t, int64(3), resp.Pending)
+	assert.Equal(t, int64(3), resp.Value)
+	assert.Equal(t, int64(1), scaling("?type=export_data").Value)
+	assert.Equal(t, int64(4), scaling("").Value)
+	assert.Equal(t, int64(0), scaling("?queue=reports").Value, "an idle queue scales to zero")
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/scaling?queue=email&type=send_email", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_Labels(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	submit := func(labels string) int {
+		body := `{"type": "test_task", "labels": ` + labels + `}`
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusCreated, submit(`{"team": "payments", "env": "prod"}`))
+	require.Equal(t, http.StatusCreated, submit(`{"team": "payments", "env": "staging"}`))
+	require.Equal(t, http.StatusCreated, submit(`{"team": "search", "env": "prod"}`))
+	assert.Equal(t, http.StatusBadRequest, submit(`{"team name": "x"}`))
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks?labels=team=payments,env=prod", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp ListTasksResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Tasks, 1)
+	assert.Equal(t, map[string]string{"team": "payments", "env": "prod"}, resp.Tasks[0].Labels)
+
+	req = httptest.NewRequest("GET", "/api/v1/tasks?labels=team", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_CorrelationID(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	submit := func(header, value string) (*httptest.ResponseRecorder, SubmitTaskResponse) {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"type": "test_task"}`))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var resp SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return w, resp
+	}
+
+	w, resp := submit("X-Correlation-ID", "checkout-42")
+	assert.Equal(t, "checkout-42", w.Header().Get("X-Correlation-ID"))
+	assert.Equal(t, "checkout-42", resp.CorrelationID)
+	stored, err := q.GetTask(context.Background(), resp.TaskID)
+	require.NoError(t, err)
+	assert.Equal(t, "checkout-42", stored.CorrelationID)
+
+	_, resp = submit("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", resp.CorrelationID)
+
+	// Submissions without one get a fresh ID
+	w, resp = submit("", "")
+	assert.NotEmpty(t, resp.CorrelationID)
+	assert.Equal(t, resp.CorrelationID, w.Header().Get("X-Correlation-ID"))
+}
+
+func TestAPI_TaskSource(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	submit := func(headers map[string]string) string {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"type": "test_task"}`))
+		req.RemoteAddr = "10.0.3.7:51234"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var resp SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		stored, err := q.GetTask(context.Background(), resp.TaskID)
+		require.NoError(t, err)
+		return stored.Source
+	}
+
+	keyed := submit(map[string]string{"X-API-Key": "secret", "X-Service-Name": "billing"})
+	assert.Regexp(t, `^key:[0-9a-f]{8}$`, keyed)
+	assert.NotContains(t, keyed, "secret")
+	assert.Equal(t, "service:billing", submit(map[string]string{"X-Service-Name": "billing"}))
+	assert.Equal(t, "ip:10.0.3.7", submit(nil))
+
+	// Tasks submitted in process are attributed to their caller
+	tk := task.NewTask("test_task", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(context.Background(), tk))
+	assert.Equal(t, "caller:api.TestAPI_TaskSource", tk.Source)
+}
+
+func TestAPI_SubmitChild(t *testing.T) {
+	server, q := setupTestServer(t)
+	ctx := context.Background()
+
+	parent := task.NewTask("parent", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, parent))
+
+	submit := func(body string) SubmitTaskResponse {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	resp := submit(`{"type": "child", "priority": 0, "parent_id": "` + parent.ID + `"}`)
+	child, err := q.GetTask(ctx, resp.TaskID)
+	require.NoError(t, err)
+	assert.Equal(t, task.PriorityHigh, child.Priority)
+	assert.Equal(t, parent.ID, child.ParentID)
+
+	resp = submit(`{"type": "child", "priority": 0, "parent_id": "` + parent.ID + `", "priority_override": true}`)
+	child, err = q.GetTask(ctx, resp.TaskID)
+	require.NoError(t, err)
+	assert.Equal(t, task.PriorityLow, child.Priority)
+}
+
+func TestAPI_CallerSuppliedID(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	submit := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := submit("/api/v1/tasks", `{"id": "order-1234:receipt", "type": "send_email", "priority": 1}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"task_id":"order-1234:receipt"`)
+
+	got, err := q.GetTask(context.Background(), "order-1234:receipt")
+	require.NoError(t, err)
+	assert.Equal(t, "send_email", got.Type)
+
+	// Reusing the ID is a conflict, not an overwrite
+	w = submit("/api/v1/tasks", `{"id": "order-1234:receipt", "type": "other", "priority": 1}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeTaskExists))
+
+	w = submit("/api/v1/tasks", `{"id": "no spaces/allowed", "type": "a"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = submit("/api/v1/tasks/batch", `{"tasks": [{"id": "dup", "type": "a"}, {"id": "dup", "type": "b"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "used more than once")
+}
+
+func TestAPI_SignedRequests(t *testing.T) {
+	keys, err := signing.NewKeys("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+	other, err := signing.NewKeys("k1", map[string][]byte{"k1": bytes.Repeat([]byte{8}, 32)})
+	require.NoError(t, err)
+
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), SigningKeys: keys})
+
+	body := `{"type": "send_email", "priority": 1}`
+	submit := func(path, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if signature != "" {
+			req.Header.Set(signing.Header, signature)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	// Signatures are optional until required, but must verify when present
+	assert.Equal(t, http.StatusCreated, submit("/api/v1/tasks", "").Code)
+	assert.Equal(t, http.StatusCreated, submit("/api/v1/tasks", keys.Sign([]byte(body), time.Now())).Code)
+
+	w := submit("/api/v1/tasks", other.Sign([]byte(body), time.Now()))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeInvalidSignature))
+
+	stale := keys.Sign([]byte(body), time.Now().Add(-time.Hour))
+	assert.Equal(t, http.StatusUnauthorized, submit("/api/v1/tasks", stale).Code)
+
+	server.config.RequireSignedRequests = true
+	server = NewServer(server.config)
+	assert.Equal(t, http.StatusUnauthorized, submit("/api/v1/tasks", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, submit("/api/v1/tasks/batch", "").Code)
+	assert.Equal(t, http.StatusCreated, submit("/api/v2/tasks", keys.Sign([]byte(body), time.Now())).Code)
+}
+
+func TestAPI_Cluster(t *testing.T) {
+	ctx := context.Background()
+	registry := cluster.NewMemoryRegistry()
+	require.NoError(t, registry.Register(ctx, cluster.Member{
+		ID:        "worker-1",
+		Kind:      cluster.KindWorker,
+		Version:   "v1.2.0",
+		StartedAt: time.Now().Add(-time.Hour),
+		Types:     []string{"send_email"},
+		Leads:     []string{"scheduler"},
+		Versions:  map[string]int{"send_email": 2},
+	}, time.Minute))
+
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Cluster: registry, InstanceID: "api-1"})
+
+	// Serving registers the server itself
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(ln)
+	defer server.Shutdown(ctx)
+
+	var resp ClusterResponse
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest("GET", "/api/v1/cluster", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		resp = ClusterResponse{}
+		return w.Code == http.StatusOK &&
+			json.NewDecoder(w.Body).Decode(&resp) == nil &&
+			len(resp.Members) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "api-1", resp.Members[0].ID)
+	assert.Equal(t, cluster.KindAPI, resp.Members[0].Kind)
+	assert.Equal(t, "worker-1", resp.Members[1].ID)
+	assert.Equal(t, "v1.2.0", resp.Members[1].Version)
+	assert.Equal(t, "1h0m0s", resp.Members[1].Uptime)
+	assert.Equal(t, []string{"send_email"}, resp.Members[1].Types)
+	assert.Equal(t, map[string]string{"scheduler": "worker-1"}, resp.Leaders)
+	assert.Equal(t, map[string]int{"send_email": 2}, resp.Versions)
+
+	// Without a registry there is nothing to report
+	server, _ = setupTestServer(t)
+	req := httptest.NewRequest("GET", "/api/v1/cluster", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_SubmitHookRejects(t *testing.T) {
+	server, q := setupTestServer(t)
+	q.OnSubmit(func(ctx context.Context, t *task.Task) error {
+		if t.Type != "send_email" {
+			return fmt.Errorf("task type %q is not allowed", t.Type)
+		}
+		return nil
+	})
+
+	submit := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, submit(`{"type": "send_email"}`).Code)
+
+	w := submit(`{"type": "export_data"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, errs.CodeTaskRejected, resp.Code)
+	assert.Equal(t, `task type "export_data" is not allowed`, resp.Error)
+}
+
+func TestAPI_ExportImport(t *testing.T) {
+	server, q := setupTestServer(t)
+	require.NoError(t, q.Submit(context.Background(), task.NewTask("send_email", task.PriorityHigh, nil)))
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/export", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	export := w.Body.String()
+	assert.Equal(t, 1, strings.Count(export, "\n"))
+
+	restored, _ := setupTestServer(t)
+	req = httptest.NewRequest("POST", "/api/v1/admin/import", strings.NewReader(export))
+	w = httptest.NewRecorder()
+	restored.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var result queue.ImportResult
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, queue.ImportResult{Imported: 1}, result)
+
+	req = httptest.NewRequest("POST", "/api/v1/admin/import", strings.NewReader("not json"))
+	w = httptest.NewRecorder()
+	restored.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_Reports(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: logger})
+	server := NewServer(Config{Queue: q, Logger: logger})
+
+	done := task.NewTask("send_email", task.PriorityMedium, nil)
+	done.Status = task.StatusCompleted
+	require.NoError(t, store.SaveTask(context.Background(), done))
+
+	body := fmt.Sprintf(`{"from": %q, "to": %q}`,
+		time.Now().Add(-time.Hour).Format(time.RFC3339), time.Now().Add(time.Hour).Format(time.RFC3339))
+	req := httptest.NewRequest("POST", "/api/v1/reports", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var submitted SubmitTaskResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&submitted))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/reports/"+submitted.TaskID+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusAccepted, get("").Code)
+
+	q.RegisterHandler(reporting.TaskType, reporting.Handler(store))
+	_, err := q.ProcessOne(context.Background())
+	require.NoError(t, err)
+
+	w = get("")
+	require.Equal(t, http.StatusOK, w.Code)
+	var report reporting.Report
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, 1, report.Total)
+
+	w = get("?format=csv")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "count,"+done.CreatedAt.UTC().Format("2006-01-02")+",send_email,completed,,1")
+
+	// Other tasks are not reports
+	req = httptest.NewRequest("GET", "/api/v1/reports/"+done.ID, nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_BoostTask(t *testing.T) {
+	server, q := setupTestServer(t)
+	tk := task.NewTask("export_data", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(context.Background(), tk))
+
+	boost := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/tasks/"+id+"/boost", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := boost(tk.ID)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got task.Task
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, task.PriorityCritical, got.Priority)
+
+	assert.Equal(t, http.StatusNotFound, boost("missing").Code)
+}
+
+func TestAPI_SubmitDryRun(t *testing.T) {
+	server, q := setupTestServer(t)
+
+	req := httptest.NewRequest("POST", "/api/v1/tasks?dry_run=true", strings.NewReader(`{"type": "send_email", "priority": 2}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp DryRunResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, queue.OutcomeQueued, resp.Plan.Outcome)
+	assert.Equal(t, task.PriorityHigh, resp.Plan.Priority)
+	assert.Nil(t, resp.Workers)
+
+	tasks, _, err := q.ListTasks(context.Background(), task.StatusPending, nil, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	req = httptest.NewRequest("POST", "/api/v1/tasks?dry_run=maybe", strings.NewReader(`{"type": "send_email"}`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_GetTaskPosition(t *testing.T) {
+	server, q := setupTestServer(t)
+	ctx := context.Background()
+	first := task.NewTask("send_email", task.PriorityHigh, nil)
+	require.NoError(t, q.Submit(ctx, first))
+	second := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, second))
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks/"+second.ID, nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, second.ID, resp["id"])
+	assert.Equal(t, float64(2), resp["position"])
+	assert.NotContains(t, resp, "estimated_start_at")
+}
+
+func TestAPI_Types(t *testing.T) {
+	ctx := context.Background()
+	registry := cluster.NewMemoryRegistry()
+	for _, m := range []cluster.Member{
+		{ID: "worker-1", Kind: cluster.KindWorker, Types: []string{"export_data", "send_email"}},
+		{ID: "worker-2", Kind: cluster.KindWorker, Types: []string{"send_email"}},
+	} {
+		require.NoError(t, registry.Register(ctx, m, time.Minute))
+	}
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	require.NoError(t, q.SetTypeConfig("page_oncall", queue.TypeConfig{Timeout: 30 * time.Second, Queue: "alerts"}))
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Cluster: registry})
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := do("POST", "/api/v1/types/send_email/disable")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var info TypeInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	assert.Equal(t, TypeInfo{Type: "send_email", Enabled: false, Workers: []string{"worker-1", "worker-2"}}, info)
+	disabled, err := registry.DisabledTypes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"send_email"}, disabled)
+
+	w = do("GET", "/api/v1/types")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"types": [
+		{"type": "export_data", "enabled": true, "workers": ["worker-1"]},
+		{"type": "page_oncall", "enabled": true, "config": {"timeout": "30s", "queue": "alerts"}, "workers": []},
+		{"type": "send_email", "enabled": false, "workers": ["worker-1", "worker-2"]}
+	]}`, w.Body.String())
+
+	w = do("POST", "/api/v1/types/send_email/enable")
+	require.Equal(t, http.StatusOK, w.Code)
+	disabled, err = registry.DisabledTypes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, disabled)
+
+	noCluster, _ := setupTestServer(t)
+	w = httptest.NewRecorder()
+	noCluster.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/types", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_LogLevel(t *testing.T) {
+	server, _ := setupTestServer(t)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/admin/log-level", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	level := zap.NewAtomicLevel()
+	server = NewServer(Config{Queue: server.queue, Logger: zap.NewNop(), LogLevel: &level})
+	do := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, "/api/v1/admin/log-level", strings.NewReader(body)))
+		return w
+	}
+
+	w = do("GET", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"level": "info"}`, w.Body.String())
+
+	w = do("PUT", `{"level": "debug"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"level": "debug"}`, w.Body.String())
+	assert.Equal(t, zap.DebugLevel, level.Level())
+
+	w = do("PUT", `{"level": "loud"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "level must be one of")
+	assert.Equal(t, zap.DebugLevel, level.Level())
+}
+
+func TestAPI_GetTaskLogs(t *testing.T) {
+	server, q := setupTestServer(t)
+	ctx := context.Background()
+	q.RegisterHandler("export_data", func(ctx context.Context, t *task.Task) error {
+		task.LoggerFromContext(ctx).Info("exported rows", zap.Int("rows", 120))
+		return nil
+	})
+	tk := task.NewTask("export_data", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/"+tk.ID+"/logs", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/"+tk.ID+"/logs", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, "exported rows", entry["msg"])
+	assert.Equal(t, float64(120), entry["rows"])
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/missing/logs", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_ListArtifacts(t *testing.T) {
+	store, err := artifact.NewDirStore(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop(), Artifacts: store})
+	q.RegisterHandler("export_data", func(ctx context.Context, t *task.Task) error {
+		return artifact.Attach(ctx, t, "export.csv", strings.NewReader("id\n1\n"), 5, "text/csv")
+	})
+	tk := task.NewTask("export_data", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Artifacts: store})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/"+tk.ID+"/artifacts", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ArtifactsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Artifacts, 1)
+	link := resp.Artifacts[0]
+	assert.Equal(t, "export.csv", link.Name)
+	assert.Equal(t, int64(5), link.Size)
+	assert.Equal(t, "text/csv", link.ContentType)
+	assert.True(t, strings.HasSuffix(link.URL, "/tasks/"+tk.ID+"/export.csv"), link.URL)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), link.ExpiresAt, time.Minute)
+
+	server = NewServer(Config{Queue: q, Logger: zap.NewNop()})
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/"+tk.ID+"/artifacts", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_Templates(t *testing.T) {
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Templates: templates.NewMemoryStore()})
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("PUT", "/api/v1/templates/welcome", `{"subject": "Hi {{.name}}", "text": "Welcome"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = do("PUT", "/api/v1/templates/welcome", `{"subject": "Hello {{.name}}", "text": "Welcome"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var saved templates.Template
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&saved))
+	assert.Equal(t, 2, saved.Version)
+
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/v1/templates/broken", `{"text": "{{.name"}`).Code)
+
+	w = do("GET", "/api/v1/templates/welcome?version=1", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var first templates.Template
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&first))
+	assert.Equal(t, "Hi {{.name}}", first.Subject)
+
+	w = do("GET", "/api/v1/templates/welcome/versions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var versions TemplatesResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&versions))
+	assert.Len(t, versions.Templates, 2)
+
+	w = do("POST", "/api/v1/templates/welcome/render", `{"data": {"name": "Ada"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rendered templates.Rendered
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rendered))
+	assert.Equal(t, "Hello Ada", rendered.Subject)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/templates/welcome/render", `{}`).Code)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/templates/welcome", "").Code)
+	w = do("GET", "/api/v1/templates/welcome", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeTemplateNotFound))
+}
+
+func TestAPI_TaskTemplates(t *testing.T) {
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), TaskTemplates: schedule.NewMemoryTemplateStore()})
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("PUT", "/api/v1/task-templates/welcome_email", `{"type": "send_email", "priority": 66, "max_retries": 0,
+		"payload": {"template": "welcome", "from": "hello@example.com"}, "labels": {"team": "growth"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/v1/task-templates/untyped", `{"payload": {}}`).Code)
+
+	submitted := func(w *httptest.ResponseRecorder) *task.Task {
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp SubmitTaskResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		stored, err := q.GetTask(context.Background(), resp.TaskID)
+		require.NoError(t, err)
+		return stored
+	}
+
+	tk := submitted(do("POST", "/api/v1/tasks", `{"template": "welcome_email", "payload": {"to": "ada@example.com", "from": "ada@example.com"}}`))
+	assert.Equal(t, "send_email", tk.Type)
+	assert.Equal(t, task.PriorityHigh, tk.Priority)
+	assert.Equal(t, 0, tk.MaxRetries)
+	assert.Equal(t, map[string]interface{}{"template": "welcome", "from": "ada@example.com", "to": "ada@example.com"}, tk.Payload)
+	assert.Equal(t, "growth", tk.Labels["team"])
+
+	tk = submitted(do("POST", "/api/v1/tasks", `{"template": "welcome_email", "type": "send_email", "priority": 100, "max_retries": 2}`))
+	assert.Equal(t, task.PriorityCritical, tk.Priority)
+	assert.Equal(t, 2, tk.MaxRetries)
+
+	w = do("POST", "/api/v1/tasks/batch", `{"tasks": [{"template": "welcome_email"}, {"type": "resize_image"}]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/tasks", `{"template": "welcome_email", "type": "send_sms"}`).Code)
+	w = do("POST", "/api/v1/tasks", `{"template": "missing"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeTaskTemplateNotFound))
+
+	w = do("GET", "/api/v1/task-templates", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list TaskTemplatesResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Len(t, list.Templates, 1)
+	assert.Equal(t, "welcome_email", list.Templates[0].Name)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/task-templates/welcome_email", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/task-templates/welcome_email", "").Code)
+}
+
+func TestAPI_Schedules(t *testing.T) {
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Schedules: schedule.NewMemoryStore()})
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("POST", "/api/v1/schedules", `{"name": "nightly cleanup", "spec": "0 2 * * *", "jitter": "5m", "task": {"type": "cleanup", "priority": "high"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created schedule.Schedule
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, 5*time.Minute, created.Jitter)
+	assert.True(t, created.NextRun.After(time.Now()))
+	assert.Equal(t, 2, created.NextRun.UTC().Hour())
+
+	for _, body := range []string{
+		`{"spec": "0 25 * * *", "task": {"type": "cleanup"}}`,
+		`{"spec": "@daily", "task": {}}`,
+		`{"spec": "@daily", "jitter": "soon", "task": {"type": "cleanup"}}`,
+		`{"spec": "@daily", "overlap": "sometimes", "task": {"type": "cleanup"}}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/schedules", body).Code, body)
+	}
+
+	backfill := `{"from": "2024-01-01T00:00:00Z", "to": "2024-01-04T00:00:00Z", "limit": 2}`
+	w = do("POST", "/api/v1/schedules/"+created.ID+"/backfill", backfill)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var filled BackfillResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&filled))
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC),
+	}, filled.Runs)
+	assert.True(t, filled.Truncated)
+	require.Len(t, filled.TaskIDs, 2)
+	run, err := q.GetTask(context.Background(), filled.TaskIDs[1])
+	require.NoError(t, err)
+	assert.Equal(t, "20240102T020000Z", run.Labels[schedule.RunLabel])
+	assert.Equal(t, created.ID, run.Labels[schedule.Label])
+
+	w = do("POST", "/api/v1/schedules/"+created.ID+"/backfill", `{"from": "2024-01-01T00:00:00Z", "to": "2024-01-04T00:00:00Z", "dry_run": true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	filled = BackfillResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&filled))
+	assert.Len(t, filled.Runs, 3)
+	assert.Empty(t, filled.TaskIDs)
+	assert.False(t, filled.Truncated)
+
+	for _, body := range []string{
+		`{}`,
+		`{"from": "2024-01-04T00:00:00Z", "to": "2024-01-01T00:00:00Z"}`,
+		`{"from": "2024-01-01T00:00:00Z", "to": "2999-01-01T00:00:00Z"}`,
+		`{"from": "2024-01-01T00:00:00Z", "limit": 100000}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/schedules/"+created.ID+"/backfill", body).Code, body)
+	}
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/schedules/missing/backfill", backfill).Code)
+
+	w = do("POST", "/api/v1/schedules/"+created.ID+"/pause", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var paused schedule.Schedule
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&paused))
+	assert.True(t, paused.Paused)
+
+	w = do("PUT", "/api/v1/schedules/"+created.ID, `{"spec": "@every 1h", "task": {"type": "cleanup"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated schedule.Schedule
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&updated))
+	assert.Equal(t, created.ID, updated.ID)
+	assert.False(t, updated.Paused)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), updated.NextRun, time.Minute)
+
+	w = do("GET", "/api/v1/schedules", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list SchedulesResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Len(t, list.Schedules, 1)
+	assert.Equal(t, "@every 1h", list.Schedules[0].Spec)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/schedules/"+created.ID, "").Code)
+	w = do("GET", "/api/v1/schedules/"+created.ID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeScheduleNotFound))
+}
+
+func TestAPI_Debug(t *testing.T) {
+	logger := zap.NewNop()
+	q := queue.NewQueue(queue.Config{
+		Storage:      storage.NewMemoryStorage(),
+		Logger:       logger,
+		PollInterval: 10 * time.Millisecond,
+	})
+	release := make(chan struct{})
+	q.RegisterHandler("slow", func(ctx context.Context, t *task.Task) error {
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.Start(ctx, 2)
+	defer q.Stop()
+	defer close(release)
+
+	slow := task.NewTask("slow", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, slow))
+
+	// Off unless asked for
+	server, _ := setupTestServer(t)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/debug/queue", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	server = NewServer(Config{Queue: q, Logger: logger, Debug: true})
+	var state queue.DebugState
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/debug/queue", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&state))
+		return state.Workers.Busy == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, state.Workers.Goroutines)
+	require.Len(t, state.Workers.Running, 1)
+	assert.Equal(t, slow.ID, state.Workers.Running[0].TaskID)
+	assert.Equal(t, 100, state.Channels["low"].Cap)
+	assert.True(t, state.Dispatcher.Started)
+	assert.True(t, state.Dispatcher.Alive)
+	assert.NotNil(t, state.Dispatcher.LastPoll)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine profile")
+}
+
+func TestAPI_Attempts(t *testing.T) {
+	server, q := setupTestServer(t)
+	ctx := context.Background()
+	require.NoError(t, q.SetTypeConfig("flaky", queue.TypeConfig{Retry: &queue.RetryPolicy{Initial: time.Millisecond}}))
+	var calls int
+	q.RegisterHandler("flaky", func(ctx context.Context, t *task.Task) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+
+	tk := task.NewTask("flaky", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, tk))
+	_, err := q.ProcessOne(ctx)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = q.ProcessOne(ctx)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/"+tk.ID+"/attempts", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp AttemptsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+	require.Len(t, resp.Attempts, 2)
+	first, second := resp.Attempts[0], resp.Attempts[1]
+	assert.Equal(t, 1, first.Number)
+	assert.Equal(t, string(task.StatusRetrying), first.Outcome)
+	assert.Equal(t, "connection reset", first.Error)
+	assert.Equal(t, task.CategoryHandler, first.ErrorCategory)
+	require.NotNil(t, first.EndedAt)
+	assert.Equal(t, 2, second.Number)
+	assert.Equal(t, string(task.StatusCompleted), second.Outcome)
+	assert.Empty(t, second.Error)
+	assert.Equal(t, map[string]int{"sync": 1}, resp.Failures)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/tasks/missing/attempts", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_Workflows(t *testing.T) {
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	engine := workflow.NewEngine(workflow.Config{Store: workflow.NewMemoryStore(), Queue: q, Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Workflows: engine})
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/workflows", "application/yaml", `
+name: report
+steps:
+  - name: generate
+    task: {type: generate_report, priority: high}
+  - name: send
+    depends_on: [generate]
+    task: {type: send_email}
+`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created workflow.Workflow
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, workflow.StatusRunning, created.Status)
+	assert.Equal(t, workflow.StepRunning, created.State["generate"].Status)
+
+	for _, body := range []string{
+		`{"steps": []}`,
+		`{"steps": [{"name": "a", "task": {"type": "x"}, "depends_on": ["a"]}]}`,
+		`{"steps": [{"name": "a", "task": {"type": "x"}, "retries": 3}]}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/workflows", "", body).Code, body)
+	}
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/workflows", "text/yaml", "steps: [").Code)
+
+	w = do("POST", "/api/v1/workflows/"+created.ID+"/resume", "", "")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	w = do("GET", "/api/v1/workflows/"+created.ID+"/graph", "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var graph workflow.Graph
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&graph))
+	require.Len(t, graph.Nodes, 2)
+	assert.Equal(t, []workflow.Edge{{From: "generate", To: "send", Trigger: workflow.TriggerSuccess}}, graph.Edges)
+	w = do("GET", "/api/v1/workflows/"+created.ID+"/graph?format=dot", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/vnd.graphviz", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"generate" -> "send";`)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/workflows/"+created.ID+"/graph?format=svg", "", "").Code)
+
+	w = do("POST", "/api/v1/workflows/"+created.ID+"/cancel", "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var cancelled workflow.Workflow
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&cancelled))
+	assert.Equal(t, workflow.StatusCancelled, cancelled.Status)
+
+	w = do("GET", "/api/v1/workflows", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list WorkflowsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Len(t, list.Workflows, 1)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/workflows/"+created.ID, "", "").Code)
+	w = do("GET", "/api/v1/workflows/"+created.ID, "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeWorkflowNotFound))
+}
+
+func TestAPI_WorkflowApproval(t *testing.T) {
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	engine := workflow.NewEngine(workflow.Config{Store: workflow.NewMemoryStore(), Queue: q, Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Workflows: engine})
+
+	wf, err := engine.Start(context.Background(), workflow.Workflow{Steps: []workflow.Step{
+		{Name: "review", Type: workflow.TypeManualApproval},
+		{Name: "send", DependsOn: []string{"review"}, Task: schedule.TaskTemplate{Type: "send_email"}},
+	}})
+	require.NoError(t, err)
+	approve := func(step, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/workflows/"+wf.ID+"/steps/"+step+"/approve", strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, approve("review", `{"decision": "maybe"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, approve("send", `{"decision": "approve"}`).Code)
+
+	w := approve("review", `{"decision": "approve", "by": "jane", "comment": "ship it"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var approved workflow.Workflow
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&approved))
+	assert.Equal(t, workflow.StepCompleted, approved.State["review"].Status)
+	assert.Equal(t, workflow.StepRunning, approved.State["send"].Status)
+
+	assert.Equal(t, http.StatusConflict, approve("review", `{"decision": "reject"}`).Code)
+}
+
+func TestAPI_WorkflowDefinitions(t *testing.T) {
+	q := queue.NewQueue(queue.Config{Storage: storage.NewMemoryStorage(), Logger: zap.NewNop()})
+	engine := workflow.NewEngine(workflow.Config{Store: workflow.NewMemoryStore(), Queue: q, Logger: zap.NewNop()})
+	server := NewServer(Config{Queue: q, Logger: zap.NewNop(), Workflows: engine})
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("PUT", "/api/v1/workflow-definitions/publish", "application/yaml", `
+description: render and send a report
+input: {channel: email}
+steps:
+  - name: send
+    task: {type: send_email}
+`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/v1/workflow-definitions/publish", "", `{"steps": []}`).Code)
+
+	w = do("POST", "/api/v1/workflows", "", `{"definition": "publish", "input": {"account": "acme"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var started workflow.Workflow
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&started))
+	assert.Equal(t, "publish", started.Name)
+	assert.Equal(t, map[string]interface{}{"channel": "email", "account": "acme"}, started.Input)
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/workflows", "", `{"definition": "publish", "steps": [{"name": "a", "task": {"type": "x"}}]}`).Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/workflows", "", `{"definition": "missing"}`).Code)
+
+	w = do("GET", "/api/v1/workflow-definitions", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list DefinitionsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Len(t, list.Definitions, 1)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/workflow-definitions/publish", "", "").Code)
+	w = do("GET", "/api/v1/workflow-definitions/publish", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeDefinitionNotFound))
+}
+
+func TestAPI_Leases(t *testing.T) {
+	server, q := setupTestServer(t)
+	q.RegisterRemote("resize")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, do("/api/v1/leases", `{"worker_id": "py-1", "types": ["resize"]}`).Code)
+	for _, body := range []string{
+		`{"types": ["resize"]}`,
+		`{"worker_id": "py-1", "types": []}`,
+		`{"worker_id": "py-1", "types": ["resize"], "wait": "1h"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do("/api/v1/leases", body).Code, body)
+	}
+
+	tk := task.NewTask("resize", task.PriorityHigh, map[string]interface{}{"url": "a.png"})
+	require.NoError(t, q.Submit(ctx, tk))
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	w := do("/api/v1/leases", `{"worker_id": "py-1", "types": ["resize"], "wait": "5s"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var lease queue.Lease
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&lease))
+	assert.Equal(t, tk.ID, lease.Task.ID)
+	assert.Equal(t, "a.png", lease.Task.Payload["url"])
+
+	w = do("/api/v1/leases/"+lease.ID+"/progress", `{"percent": 50, "message": "halfway"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("/api/v1/leases/"+lease.ID+"/nack", `{"error": "x", "retry_after": "later"}`).Code)
+	assert.Equal(t, http.StatusNoContent, do("/api/v1/leases/"+lease.ID+"/ack", `{"result": {"width": 100}}`).Code)
+
+	w = do("/api/v1/leases/"+lease.ID+"/ack", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(errs.CodeLeaseNotFound))
+
+	require.Eventually(t, func() bool {
+		got, err := q.GetTask(ctx, tk.ID)
+		return err == nil && got.Status == task.StatusCompleted
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestAPI_TaskStatuses(t *testing.T) {
+	server, q := setupTestServer(t)
+	ctx := context.Background()
+
+	do := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/tasks/status", strings.NewReader(body)))
+		return w
+	}
+
+	first := task.NewTask("send_email", task.PriorityHigh, nil)
+	second := task.NewTask("send_email", task.PriorityLow, nil)
+	require.NoError(t, q.Submit(ctx, first))
+	require.NoError(t, q.Submit(ctx, second))
+
+	w := do(fmt.Sprintf(`{"ids": [%q, "missing", %q]}`, second.ID, first.ID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp TaskStatusResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Tasks, 2)
+	assert.Equal(t, second.ID, resp.Tasks[0].ID, "in the order requested")
+	assert.Equal(t, task.StatusPending, resp.Tasks[1].Status)
+	assert.Equal(t, []string{"missing"}, resp.Missing)
+
+	// A wait returns as soon as a task moves on
+	q.RegisterHandler("send_email", func(ctx context.Context, t *task.Task) error { return nil })
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		q.ProcessOne(ctx)
+	}()
+	start := time.Now()
+	w = do(fmt.Sprintf(`{"ids": [%q, %q], "wait": "10s"}`, first.ID, second.ID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	resp = TaskStatusResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{first.ID}, resp.Changed)
+	assert.Equal(t, task.StatusCompleted, resp.Tasks[0].Status)
+
+	// Statuses the client already knows are compared against instead
+	w = do(fmt.Sprintf(`{"ids": [%q], "wait": "10s", "known": {%q: "pending"}}`, first.ID, first.ID))
+	resp = TaskStatusResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{first.ID}, resp.Changed)
+
+	ids := make([]string, 501)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%q", fmt.Sprint(i))
+	}
+	for _, body := range []string{
+		`{"ids": []}`,
+		`{"ids": [` + strings.Join(ids, ",") + `]}`,
+		`{"ids": ["a"], "wait": "2m"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(body).Code)
+	}
+}
+
+func TestWorkerService_Stream(t *testing.T) {
+	_, q := setupTestServer(t)
+	q.RegisterRemote("resize")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	workerpb.RegisterWorkerServer(g, NewWorkerService(q, zap.NewNop()))
+	go g.Serve(lis)
+	defer g.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := workerpb.NewWorkerClient(conn)
+
+	q.Start(ctx, 1)
+	defer q.Stop()
+
+	lease := func(stream workerpb.Worker_WorkClient) *workerpb.Lease {
+		require.NoError(t, stream.Send(&workerpb.WorkerMessage{Message: &workerpb.WorkerMessage_Lease{
+			Lease: &workerpb.LeaseRequest{WorkerId: "py-1", Types: []string{"resize"}},
+		}}))
+		msg, err := stream.Recv()
+		require.NoError(t, err)
+		require.NotNil(t, msg.GetLeased(), msg.String())
+		return msg.GetLeased()
+	}
+
+	// Lease, report progress and ack
+	stream, err := client.Work(ctx)
+	require.NoError(t, err)
+	tk := task.NewTask("resize", task.PriorityHigh, map[string]interface{}{"url": "a.png"})
+	require.NoError(t, q.Submit(ctx, tk))
+
+	l := lease(stream)
+	assert.Equal(t, tk.ID, l.Task.Id)
+	assert.Equal(t, "a.png", l.Task.Payload.AsMap()["url"])
+
+	require.NoError(t, stream.Send(&workerpb.WorkerMessage{Message: &workerpb.WorkerMessage_Progress{
+		Progress: &workerpb.Progress{LeaseId: l.LeaseId, Percent: 50, Message: "halfway"},
+	}}))
+	msg, err := stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, msg.GetExtended(), msg.String())
+	assert.Equal(t, l.LeaseId, msg.GetExtended().LeaseId)
+
+	result, err := structpb.NewStruct(map[string]interface{}{"width": 100})
+	require.NoError(t, err)
+	ack := &workerpb.WorkerMessage{Message: &workerpb.WorkerMessage_Ack{Ack: &workerpb.Ack{LeaseId: l.LeaseId, Result: result}}}
+	require.NoError(t, stream.Send(ack))
+	msg, err = stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, msg.GetEnded(), msg.String())
+
+	require.NoError(t, stream.Send(ack))
+	msg, err = stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, msg.GetError(), msg.String())
+	assert.Equal(t, string(errs.CodeLeaseNotFound), msg.GetError().Code)
+
+	require.Eventually(t, func() bool {
+		got, err := q.GetTask(ctx, tk.ID)
+		return err == nil && got.Status == task.StatusCompleted
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, stream.CloseSend())
+
+	// A lease still held when the stream ends is nacked
+	streamCtx, closeStream := context.WithCancel(ctx)
+	stream, err = client.Work(streamCtx)
+	require.NoError(t, err)
+	tk = task.NewTask("resize", task.PriorityHigh, nil)
+	tk.MaxRetries = 0
+	require.NoError(t, q.Submit(ctx, tk))
+	lease(stream)
+	closeStream()
+
+	require.Eventually(t, func() bool {
+		got, err := q.GetTask(ctx, tk.ID)
+		return err == nil && got.Status == task.StatusFailed && strings.Contains(got.Error, "remote worker disconnected")
+	}, 2*time.Second, 10*time.Millisecond)
+}
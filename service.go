@@ -0,0 +1,185 @@
+// Package service ties a process's lifecycle to the service manager that
+// started it, for deployments on bare VMs. Under systemd it reports
+// readiness, shutdown and watchdog pings through sd_notify; as a Windows
+// service it answers the service control manager's stop requests. Run
+// directly, it only turns SIGINT and SIGTERM into a stop.
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Config holds service configuration
+type Config struct {
+	// Name is the Windows service name, defaults to "dtq-worker"
+	Name   string
+	Logger *zap.Logger
+
+	// StopTimeout is how long stopping may take, which the Windows service
+	// control manager waits before giving up on the process
+	StopTimeout time.Duration
+}
+
+// Service reports a process's lifecycle to its service manager
+type Service struct {
+	config Config
+	logger *zap.Logger
+
+	// socket is systemd's NOTIFY_SOCKET, empty when not started by systemd
+	socket string
+
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// ready, stopping and stopped are closed as the process reaches each
+	// state, for the Windows service handler
+	readyOnce, stoppingOnce, stoppedOnce sync.Once
+	ready, stopping, stopped             chan struct{}
+	// managerDone is closed once the service manager has been told the
+	// process stopped
+	managerDone chan struct{}
+}
+
+// Start begins watching for stop requests: SIGINT and SIGTERM, or a stop
+// from the Windows service control manager when it started the process
+func Start(cfg Config) (*Service, error) {
+	if cfg.Name == "" {
+		cfg.Name = "dtq-worker"
+	}
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+
+	s := &Service{
+		config:      cfg,
+		logger:      cfg.Logger,
+		socket:      os.Getenv("NOTIFY_SOCKET"),
+		done:        make(chan struct{}),
+		ready:       make(chan struct{}),
+		stopping:    make(chan struct{}),
+		stopped:     make(chan struct{}),
+		managerDone: make(chan struct{}),
+	}
+
+	managed, err := s.runManager()
+	if err != nil {
+		return nil, err
+	}
+	if !managed {
+		close(s.managerDone)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		s.stop()
+	}()
+	return s, nil
+}
+
+// Done is closed when the process is asked to stop
+func (s *Service) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Service) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// Ready reports that the process has started and is doing its work
+func (s *Service) Ready() {
+	s.readyOnce.Do(func() { close(s.ready) })
+	s.notify("READY=1\nSTATUS=running")
+}
+
+// Stopping reports that the process has begun shutting down
+func (s *Service) Stopping() {
+	s.stoppingOnce.Do(func() { close(s.stopping) })
+	s.notify("STOPPING=1\nSTATUS=draining")
+}
+
+// Stopped reports that the process has finished shutting down, and waits
+// until the service manager has been told
+func (s *Service) Stopped() {
+	s.stoppedOnce.Do(func() { close(s.stopped) })
+	<-s.managerDone
+}
+
+// Watchdog pings the systemd watchdog at half of WatchdogSec until ctx is
+// done. A tick where alive reports false is skipped, so a process that has
+// stalled is restarted. Without a watchdog configured it returns at once.
+func (s *Service) Watchdog(ctx context.Context, alive func() bool) {
+	interval, ok := watchdogInterval()
+	if !ok || s.socket == "" {
+		return
+	}
+	s.logger.Info("systemd watchdog enabled", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !alive() {
+				s.logger.Warn("process stalled, skipping watchdog ping")
+				continue
+			}
+			s.notify("WATCHDOG=1")
+		}
+	}
+}
+
+// watchdogInterval reads WatchdogSec from WATCHDOG_USEC, when it is meant
+// for this process
+func watchdogInterval() (time.Duration, bool) {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// notify sends state to systemd, if it started the process. Failures are
+// logged, since the process keeps working without them.
+func (s *Service) notify(state string) {
+	if s.socket == "" {
+		return
+	}
+	if err := sdNotify(s.socket, state); err != nil {
+		s.logger.Warn("failed to notify systemd", zap.Error(err))
+	}
+}
+
+// sdNotify writes state to the datagram socket systemd listens on. An
+// address starting with @ is in the abstract namespace.
+func sdNotify(socket, state string) error {
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if socket[0] == '@' {
+		addr.Name = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	return nil
+}
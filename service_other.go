@@ -0,0 +1,9 @@
+//go:build !windows
+
+package service
+
+// runManager reports false, as only Windows has a service control manager
+// to hand the process to
+func (s *Service) runManager() (bool, error) {
+	return false, nil
+}
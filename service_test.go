@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestService_Notify(t *testing.T) {
+	// Unix socket paths are short, so t.TempDir may be too deep
+	dir, err := os.MkdirTemp("", "sd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	read := func() string {
+		buf := make([]byte, 256)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	s, err := Start(Config{Logger: zap.NewNop()})
+	require.NoError(t, err)
+
+	s.Ready()
+	assert.Equal(t, "READY=1\nSTATUS=running", read())
+
+	// Pings stop while the process reports itself stalled
+	var alive atomic.Bool
+	alive.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Watchdog(ctx, alive.Load)
+	assert.Equal(t, "WATCHDOG=1", read())
+
+	alive.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for {
+		// Drop a ping sent before the stall was noticed
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+		if _, err := conn.Read(make([]byte, 256)); err != nil {
+			break
+		}
+	}
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(150*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 256))
+	assert.Error(t, err, "no pings while stalled")
+	cancel()
+
+	s.stop()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after a stop")
+	}
+	s.Stopping()
+	assert.Equal(t, "STOPPING=1\nSTATUS=draining", read())
+	s.Stopped()
+}
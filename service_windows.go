@@ -0,0 +1,74 @@
+//go:build windows
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows/svc"
+)
+
+// runManager hands the process to the service control manager when it
+// started the process, reporting whether it did
+func (s *Service) runManager() (bool, error) {
+	managed, err := svc.IsWindowsService()
+	if err != nil {
+		return false, fmt.Errorf("failed to detect the service manager: %w", err)
+	}
+	if !managed {
+		return false, nil
+	}
+
+	go func() {
+		defer close(s.managerDone)
+		if err := svc.Run(s.config.Name, &handler{s}); err != nil {
+			s.logger.Error("service control failed", zap.Error(err))
+			s.stop()
+		}
+	}()
+	return true, nil
+}
+
+// handler answers the service control manager
+type handler struct {
+	s *Service
+}
+
+// Execute reports the process running once it is ready, and turns a stop
+// or shutdown request into a stop, returning once the process has stopped
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+
+	current := svc.Status{State: svc.StartPending}
+	ready, stopping := h.s.ready, h.s.stopping
+	for {
+		select {
+		case <-ready:
+			ready = nil
+			current = svc.Status{State: svc.Running, Accepts: accepts}
+			status <- current
+		case <-stopping:
+			stopping = nil
+			current = svc.Status{State: svc.StopPending, WaitHint: waitHint(h.s.config.StopTimeout)}
+			status <- current
+		case <-h.s.stopped:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- current
+			case svc.Stop, svc.Shutdown:
+				h.s.stop()
+			}
+		}
+	}
+}
+
+// waitHint is how long, in milliseconds, stopping may take, with time to
+// spare for what follows draining
+func waitHint(timeout time.Duration) uint32 {
+	return uint32((timeout + 5*time.Second).Milliseconds())
+}
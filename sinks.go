@@ -0,0 +1,234 @@
+// Package sinks forwards the results of completed tasks to the systems
+// downstream analytics reads from: a webhook, a Kafka topic, an S3 prefix
+// or a Postgres table. Each task type names the sinks it forwards to in its
+// TypeConfig, and each forward runs as an ordinary task, so it is retried
+// like any other work and never holds up the worker that finished the
+// task.
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/signing"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// TaskType is the type of the tasks that forward a result to one sink
+const TaskType = "forward_result"
+
+// Kinds of sink
+const (
+	KindWebhook  = "webhook"
+	KindKafka    = "kafka"
+	KindS3       = "s3"
+	KindPostgres = "postgres"
+)
+
+// Result is a completed task's result, as sinks receive it
+type Result struct {
+	TaskID        string                 `json:"task_id"`
+	Type          string                 `json:"type"`
+	Result        map[string]interface{} `json:"result,omitempty"`
+	Labels        map[string]string      `json:"labels,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Source        string                 `json:"source,omitempty"`
+	Attempts      int                    `json:"attempts"`
+	CompletedAt   time.Time              `json:"completed_at"`
+}
+
+// NewResult describes the result of t
+func NewResult(t *task.Task) Result {
+	r := Result{
+		TaskID:        t.ID,
+		Type:          t.Type,
+		Result:        t.Result,
+		Labels:        t.Labels,
+		CorrelationID: t.CorrelationID,
+		Source:        t.Source,
+		Attempts:      len(t.Attempts),
+		CompletedAt:   time.Now().UTC(),
+	}
+	if t.CompletedAt != nil {
+		r.CompletedAt = t.CompletedAt.UTC()
+	}
+	return r
+}
+
+// Sink receives results
+type Sink interface {
+	// Forward delivers r. Forwarding the same result again, as a retry
+	// may, should not record it twice where the sink can avoid it.
+	Forward(ctx context.Context, r Result) error
+}
+
+// Payload is the payload of a TaskType task
+type Payload struct {
+	Sink   string `json:"sink"`
+	Result Result `json:"result"`
+}
+
+// Queue is the part of *queue.Queue results are forwarded through
+type Queue interface {
+	OnComplete(fn queue.TaskCallback)
+	TypeConfig(taskType string) (queue.TypeConfig, bool)
+	NewTask(taskType string, payload map[string]interface{}) *task.Task
+	Submit(ctx context.Context, t *task.Task, opts ...queue.SubmitOption) error
+}
+
+// Attach makes q submit a forward to each sink the type of every completed
+// task names. The sinks are read from the type's configuration as each
+// task completes, so they follow reloads. Forwards keep the task's
+// priority and correlation ID.
+func Attach(q Queue, logger *zap.Logger) {
+	q.OnComplete(func(ctx context.Context, t *task.Task) {
+		if t.Type == TaskType {
+			return
+		}
+		cfg, ok := q.TypeConfig(t.Type)
+		if !ok || len(cfg.ResultSinks) == 0 {
+			return
+		}
+		result := NewResult(t)
+		for _, name := range cfg.ResultSinks {
+			out := q.NewTask(TaskType, map[string]interface{}{
+				"sink":   name,
+				"result": result,
+			})
+			out.Priority = t.Priority
+			out.CorrelationID = t.CorrelationID
+			if err := q.Submit(ctx, out); err != nil {
+				logger.Error("failed to submit result forward",
+					zap.String("id", t.ID),
+					zap.String("sink", name),
+					zap.Error(err),
+				)
+			}
+		}
+	})
+}
+
+// Handler forwards the result each task carries to the sink it names.
+// Sinks missing from sinks fail permanently, as they will not appear on a
+// retry.
+func Handler(sinks map[string]Sink) func(ctx context.Context, t *task.Task) error {
+	return func(ctx context.Context, t *task.Task) error {
+		var p Payload
+		data, err := json.Marshal(t.Payload)
+		if err == nil {
+			err = json.Unmarshal(data, &p)
+		}
+		if err != nil {
+			return task.InvalidPayload(err)
+		}
+		sink, ok := sinks[p.Sink]
+		if !ok {
+			return task.Permanent(fmt.Errorf("unknown result sink %q", p.Sink))
+		}
+		if err := sink.Forward(ctx, p.Result); err != nil {
+			return err
+		}
+		task.LoggerFromContext(ctx).Info("result forwarded",
+			zap.String("sink", p.Sink),
+			zap.String("task_id", p.Result.TaskID),
+		)
+		return nil
+	}
+}
+
+// Definition configures one sink. Which fields apply depends on Kind.
+type Definition struct {
+	// Kind is "webhook", "kafka", "s3" or "postgres"
+	Kind string `json:"kind"`
+
+	// URL is the webhook's URL, or the base URL of the Kafka REST Proxy
+	URL string `json:"url,omitempty"`
+	// Topic is the Kafka topic results are produced to
+	Topic string `json:"topic,omitempty"`
+
+	// Bucket and Prefix locate the S3 objects results are written to.
+	// Region, Endpoint and PathStyle are as for artifact.S3Config, and
+	// the credentials default to AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY.
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	PathStyle       bool   `json:"path_style,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+
+	// DSN and Table locate the Postgres table results are inserted into
+	DSN   string `json:"dsn,omitempty"`
+	Table string `json:"table,omitempty"`
+}
+
+// Config holds what the sinks built from definitions share
+type Config struct {
+	// Client sends webhook and Kafka requests, defaults to one with a 10s
+	// timeout
+	Client *http.Client
+	// SigningKeys, if set, sign webhook bodies, as notifications are
+	SigningKeys *signing.Keys
+}
+
+// New builds the sink d describes
+func New(d Definition, cfg Config) (Sink, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	switch d.Kind {
+	case KindWebhook:
+		if d.URL == "" {
+			return nil, fmt.Errorf("webhook sink needs a url")
+		}
+		return &WebhookSink{URL: d.URL, Client: cfg.Client, SigningKeys: cfg.SigningKeys}, nil
+	case KindKafka:
+		if d.URL == "" || d.Topic == "" {
+			return nil, fmt.Errorf("kafka sink needs the url of a REST proxy and a topic")
+		}
+		return &KafkaSink{URL: d.URL, Topic: d.Topic, Client: cfg.Client}, nil
+	case KindS3:
+		return newS3Sink(d)
+	case KindPostgres:
+		return NewPostgresSink(d.DSN, d.Table)
+	}
+	return nil, fmt.Errorf("unknown sink kind %q", d.Kind)
+}
+
+// Load reads sink definitions from a JSON file mapping sink names to
+// Definitions, such as
+//
+//	{"analytics": {"kind": "kafka", "url": "http://kafka-rest:8082", "topic": "task-results"}}
+func Load(path string, cfg Config) (map[string]Sink, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result sinks: %w", err)
+	}
+	var defs map[string]Definition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse result sinks: %w", err)
+	}
+
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sinks := make(map[string]Sink, len(defs))
+	for _, name := range names {
+		sink, err := New(defs[name], cfg)
+		if err != nil {
+			return nil, fmt.Errorf("result sink %s: %w", name, err)
+		}
+		sinks[name] = sink
+	}
+	return sinks, nil
+}
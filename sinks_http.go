@@ -0,0 +1,105 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yourusername/distributed-task-queue/internal/signing"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// WebhookSink posts each result as JSON to URL
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+	// SigningKeys, if set, sign request bodies
+	SigningKeys *signing.Keys
+}
+
+func (s *WebhookSink) Forward(ctx context.Context, r Result) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return task.Permanent(fmt.Errorf("failed to encode result: %w", err))
+	}
+	resp, err := post(ctx, s.Client, s.URL, "application/json", body, s.SigningKeys)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// KafkaSink produces each result to Topic through a Kafka REST Proxy at
+// URL, keyed by task ID so a task's results share a partition
+type KafkaSink struct {
+	URL    string
+	Topic  string
+	Client *http.Client
+}
+
+// kafkaContentType is the REST Proxy's v2 JSON embedded format
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+func (s *KafkaSink) Forward(ctx context.Context, r Result) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": r.TaskID, "value": r}},
+	})
+	if err != nil {
+		return task.Permanent(fmt.Errorf("failed to encode result: %w", err))
+	}
+	endpoint := strings.TrimSuffix(s.URL, "/") + "/topics/" + url.PathEscape(s.Topic)
+	resp, err := post(ctx, s.Client, endpoint, kafkaContentType, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The proxy answers 200 even when a record was not produced, with the
+	// reason in its offset
+	var out struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("failed to read kafka proxy response: %w", err)
+	}
+	for _, o := range out.Offsets {
+		if o.Error != "" {
+			return fmt.Errorf("kafka rejected result for %s: %s", s.Topic, o.Error)
+		}
+	}
+	return nil
+}
+
+// post sends body to url, returning the response when it succeeded.
+// Client errors other than 429 will not change on a retry.
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, keys *signing.Keys) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, task.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", contentType)
+	if keys != nil {
+		keys.SignRequest(req, body)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to forward result: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		err := fmt.Errorf("result sink returned %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, task.Permanent(err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,118 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/artifact"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// S3Sink writes each result as a JSON object under Prefix, at
+// <prefix><type>/<yyyy>/<mm>/<dd>/<task id>.json by completion date, so
+// query engines can prune by type and day
+type S3Sink struct {
+	Store  artifact.Store
+	Prefix string
+}
+
+func newS3Sink(d Definition) (*S3Sink, error) {
+	accessKey, secretKey := d.AccessKeyID, d.SecretAccessKey
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	store, err := artifact.NewS3Store(artifact.S3Config{
+		Bucket:          d.Bucket,
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		Region:          d.Region,
+		Endpoint:        d.Endpoint,
+		PathStyle:       d.PathStyle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{Store: store, Prefix: d.Prefix}, nil
+}
+
+// Key returns where r is written
+func (s *S3Sink) Key(r Result) string {
+	return fmt.Sprintf("%s%s/%s/%s.json", s.Prefix, r.Type, r.CompletedAt.UTC().Format("2006/01/02"), r.TaskID)
+}
+
+func (s *S3Sink) Forward(ctx context.Context, r Result) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return task.Permanent(fmt.Errorf("failed to encode result: %w", err))
+	}
+	if err := s.Store.Put(ctx, s.Key(r), bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+		return fmt.Errorf("failed to write result: %w", err)
+	}
+	return nil
+}
+
+// maxPostgresRecord bounds the results PostgresSink passes to psql, which
+// takes them as a command line argument
+const maxPostgresRecord = 100 << 10
+
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PostgresSink inserts each result into Table through psql, as dtqctl
+// ingest reads rows, so no database driver is needed. The table needs
+// the columns
+//
+//	task_id text PRIMARY KEY, type text, completed_at timestamptz, record jsonb
+//
+// and a result forwarded again is ignored.
+type PostgresSink struct {
+	DSN   string
+	Table string
+}
+
+// NewPostgresSink creates a sink inserting into table of the database at
+// dsn
+func NewPostgresSink(dsn, table string) (*PostgresSink, error) {
+	switch {
+	case dsn == "":
+		return nil, errors.New("postgres sink needs a dsn")
+	case !validTable.MatchString(table):
+		return nil, fmt.Errorf("invalid postgres table %q", table)
+	}
+	return &PostgresSink{DSN: dsn, Table: table}, nil
+}
+
+func (s *PostgresSink) Forward(ctx context.Context, r Result) error {
+	record, err := json.Marshal(r)
+	if err != nil {
+		return task.Permanent(fmt.Errorf("failed to encode result: %w", err))
+	}
+	if len(record) > maxPostgresRecord {
+		return task.Permanent(fmt.Errorf("result of %d bytes is too large for the postgres sink", len(record)))
+	}
+
+	// The values are psql variables, quoted by psql itself
+	cmd := exec.CommandContext(ctx, "psql", s.DSN, "-X", "-q", "-v", "ON_ERROR_STOP=1",
+		"-v", "task_id="+r.TaskID,
+		"-v", "type="+r.Type,
+		"-v", "completed_at="+r.CompletedAt.Format(time.RFC3339Nano),
+		"-v", "record="+string(record),
+	)
+	cmd.Stdin = strings.NewReader("INSERT INTO " + s.Table + " (task_id, type, completed_at, record)" +
+		" VALUES (:'task_id', :'type', :'completed_at', :'record'::jsonb)" +
+		" ON CONFLICT (task_id) DO NOTHING;\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to insert result: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
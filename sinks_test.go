@@ -0,0 +1,158 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/artifact"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/queuetest"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+type fakeSink struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+func (s *fakeSink) Forward(ctx context.Context, r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, r)
+	return nil
+}
+
+func TestSinks_ForwardCompletedResults(t *testing.T) {
+	h := queuetest.New(t, queue.Config{Types: map[string]queue.TypeConfig{
+		"report": {ResultSinks: []string{"lake", "warehouse"}},
+	}})
+	Attach(h.Queue, zap.NewNop())
+	lake, warehouse := &fakeSink{}, &fakeSink{}
+	h.Handle(TaskType, Handler(map[string]Sink{"lake": lake, "warehouse": warehouse}))
+	h.Handle("report", func(ctx context.Context, t *task.Task) error {
+		if t.Payload["fail"] == true {
+			return task.Permanent(assert.AnError)
+		}
+		t.Result = map[string]interface{}{"rows": 42}
+		return nil
+	})
+	h.Handle("other", func(ctx context.Context, t *task.Task) error { return nil })
+
+	done := task.NewTask("report", task.PriorityHigh, nil)
+	done.CorrelationID = "run-7"
+	h.Submit(done)
+	// Failures have no result to forward, and other types name no sinks
+	h.Submit(task.NewTask("report", task.PriorityLow, map[string]interface{}{"fail": true}))
+	h.Submit(task.NewTask("other", task.PriorityLow, nil))
+	h.ProcessAll()
+
+	for _, sink := range []*fakeSink{lake, warehouse} {
+		require.Len(t, sink.results, 1)
+		r := sink.results[0]
+		assert.Equal(t, done.ID, r.TaskID)
+		assert.Equal(t, "report", r.Type)
+		assert.Equal(t, "run-7", r.CorrelationID)
+		assert.EqualValues(t, 42, r.Result["rows"])
+	}
+}
+
+func TestSinks_UnknownSink(t *testing.T) {
+	h := queuetest.New(t, queue.Config{})
+	h.Handle(TaskType, Handler(map[string]Sink{}))
+
+	tk := h.Submit(task.NewTask(TaskType, task.PriorityLow, map[string]interface{}{
+		"sink":   "missing",
+		"result": Result{TaskID: "t1"},
+	}))
+	h.ProcessAll()
+
+	h.AssertTransitions(tk.ID, task.StatusPending, task.StatusProcessing, task.StatusFailed)
+}
+
+func TestSinks_Kafka(t *testing.T) {
+	var (
+		path, contentType string
+		records           []map[string]interface{}
+		reject            bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		var body struct {
+			Records []map[string]interface{} `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		records = body.Records
+		offset := map[string]interface{}{"partition": 0, "offset": 1}
+		if reject {
+			offset["error"] = "leader not available"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"offsets": []interface{}{offset}})
+	}))
+	defer srv.Close()
+
+	sink, err := New(Definition{Kind: KindKafka, URL: srv.URL, Topic: "task-results"}, Config{})
+	require.NoError(t, err)
+	require.NoError(t, sink.Forward(context.Background(), Result{TaskID: "t1", Type: "report"}))
+
+	assert.Equal(t, "/topics/task-results", path)
+	assert.Equal(t, kafkaContentType, contentType)
+	require.Len(t, records, 1)
+	assert.Equal(t, "t1", records[0]["key"])
+
+	reject = true
+	assert.ErrorContains(t, sink.Forward(context.Background(), Result{TaskID: "t2"}), "leader not available")
+}
+
+func TestSinks_WebhookRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	sink, err := New(Definition{Kind: KindWebhook, URL: srv.URL}, Config{})
+	require.NoError(t, err)
+	err = sink.Forward(context.Background(), Result{TaskID: "t1"})
+	// A client error will not change on a retry
+	assert.True(t, task.IsPermanent(err))
+}
+
+func TestSinks_S3Key(t *testing.T) {
+	dir := t.TempDir()
+	store, err := artifact.NewDirStore(dir)
+	require.NoError(t, err)
+	sink := &S3Sink{Store: store, Prefix: "results/"}
+
+	r := Result{TaskID: "t1", Type: "report", CompletedAt: time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)}
+	require.NoError(t, sink.Forward(context.Background(), r))
+
+	data, err := os.ReadFile(filepath.Join(dir, "results/report/2024/03/09/t1.json"))
+	require.NoError(t, err)
+	var got Result
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, "t1", got.TaskID)
+}
+
+func TestSinks_Load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sinks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"hook": {"kind": "webhook", "url": "http://example.com/results"},
+		"db": {"kind": "postgres", "dsn": "postgres://localhost/analytics", "table": "public.task_results"}
+	}`), 0o644))
+	loaded, err := Load(path, Config{})
+	require.NoError(t, err)
+	assert.Len(t, loaded, 2)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"db": {"kind": "postgres", "dsn": "x", "table": "results; DROP TABLE t"}}`), 0o644))
+	_, err = Load(path, Config{})
+	assert.ErrorContains(t, err, "invalid postgres table")
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// slowHistory is how many of a type's latest completed runs its p95 is
+// taken over
+const slowHistory = 200
+
+// runHistory keeps the run times of a type's latest completed attempts
+type runHistory struct {
+	samples []time.Duration
+	next    int
+	// p95 is cached until another run is recorded
+	p95   time.Duration
+	stale bool
+}
+
+// runTimes keeps run histories by task type
+type runTimes struct {
+	mu     sync.Mutex
+	byType map[string]*runHistory
+}
+
+// record adds a completed attempt of taskType that ran for d
+func (r *runTimes) record(taskType string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byType == nil {
+		r.byType = make(map[string]*runHistory)
+	}
+	h, ok := r.byType[taskType]
+	if !ok {
+		h = &runHistory{}
+		r.byType[taskType] = h
+	}
+	if len(h.samples) < slowHistory {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % slowHistory
+	}
+	h.stale = true
+}
+
+// p95 returns the 95th percentile run time of taskType, and false with
+// fewer than min runs recorded
+func (r *runTimes) p95(taskType string, min int) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.byType[taskType]
+	if !ok || len(h.samples) < min {
+		return 0, false
+	}
+	if h.stale {
+		sorted := append([]time.Duration(nil), h.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		h.p95 = sorted[(len(sorted)*95+99)/100-1]
+		h.stale = false
+	}
+	return h.p95, true
+}
+
+// OnSlow registers fn to run when an attempt runs longer than
+// Config.SlowTaskFactor times its type's p95. It runs on its own goroutine
+// while the handler is still running.
+func (q *Queue) OnSlow(fn TaskCallback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lifecycle.slow = append(q.lifecycle.slow, fn)
+}
+
+func onSlow(l lifecycle) []TaskCallback { return l.slow }
+
+// watchSlow reports t's attempt if it runs past q.slowFactor times its
+// type's p95, before its timeout of timeout would. Call the returned func
+// once the handler returns.
+func (q *Queue) watchSlow(ctx context.Context, t *task.Task, timeout time.Duration, logger *zap.Logger) func() {
+	if q.slowFactor <= 0 {
+		return func() {}
+	}
+	p95, ok := q.runTimes.p95(t.Type, q.slowMinRuns)
+	if !ok {
+		return func() {}
+	}
+	threshold := time.Duration(float64(p95) * q.slowFactor)
+	if threshold <= 0 || threshold >= timeout {
+		return func() {}
+	}
+
+	started := q.clock.Now()
+	var mu sync.Mutex
+	var returned, slow bool
+	timer := time.AfterFunc(threshold, func() {
+		mu.Lock()
+		if returned {
+			mu.Unlock()
+			return
+		}
+		slow = true
+		metrics.SlowTasksRunning.Inc()
+		mu.Unlock()
+
+		metrics.SlowTasks.WithLabelValues(t.Type).Inc()
+		logger.Warn("task running far longer than usual",
+			zap.Duration("running", q.clock.Now().Sub(started)),
+			zap.Duration("p95", p95),
+			zap.Duration("timeout", timeout),
+		)
+		q.notify(ctx, onSlow, t, logger)
+	})
+
+	return func() {
+		timer.Stop()
+		mu.Lock()
+		returned = true
+		wasSlow := slow
+		if slow {
+			metrics.SlowTasksRunning.Dec()
+		}
+		mu.Unlock()
+		if wasSlow {
+			logger.Info("slow task returned", zap.Duration("duration", q.clock.Now().Sub(started)))
+		}
+	}
+}
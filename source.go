@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// queuePackage is this package's import path, for telling the callers of
+// Submit from the queue's own frames
+var queuePackage = reflect.TypeOf(Queue{}).PkgPath()
+
+// maxCallerSources bounds how many call stacks callerSource remembers
+const maxCallerSources = 4096
+
+// callerSources caches callerSource by call stack, as walking the frames
+// allocates and a process submits from only a few places
+var callerSources = struct {
+	sync.RWMutex
+	m map[[16]uintptr]string
+}{m: make(map[[16]uintptr]string)}
+
+// callerSource attributes a task submitted in process without a source to
+// the first function outside the queue that led to Submit, such as
+// "caller:billing.(*Invoicer).Run"
+func callerSource() string {
+	var pcs [16]uintptr
+	n := runtime.Callers(2, pcs[:])
+	callerSources.RLock()
+	source, ok := callerSources.m[pcs]
+	callerSources.RUnlock()
+	if ok {
+		return source
+	}
+
+	// A copy, as the frames keep it and would move pcs to the heap
+	stack := pcs
+	source = "caller:queue"
+	frames := runtime.CallersFrames(stack[:n])
+	for {
+		frame, more := frames.Next()
+		fn := frame.Function
+		if fn != "" && !strings.HasPrefix(fn, queuePackage+".") && !strings.HasPrefix(fn, "runtime.") {
+			source = "caller:" + fn[strings.LastIndex(fn, "/")+1:]
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	callerSources.Lock()
+	if len(callerSources.m) < maxCallerSources {
+		callerSources.m[pcs] = source
+	}
+	callerSources.Unlock()
+	return source
+}
@@ -1,12 +1,20 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
 	"github.com/yourusername/distributed-task-queue/internal/task"
 )
 
@@ -17,12 +25,39 @@ type Storage interface {
 	UpdateTask(ctx context.Context, t *task.Task) error
 	DeleteTask(ctx context.Context, id string) error
 	GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error)
+	GetTasksByLabels(ctx context.Context, status task.Status, labels map[string]string, limit int) ([]*task.Task, error)
+	GetTasksByPriority(ctx context.Context, status task.Status, priority task.Priority, limit int) ([]*task.Task, error)
+	// GetTasksPage returns a page of GetTasksByLabels, resuming after the
+	// position cursor names, and the position of the page's last task
+	// when more follow. Tasks that stay put are never skipped or repeated
+	// however the index changes between pages.
+	GetTasksPage(ctx context.Context, status task.Status, labels map[string]string, cursor string, limit int) ([]*task.Task, string, error)
+	// ScanTasks returns a page of the tasks matching filter, starting at
+	// cursor, and the cursor of the next page. The first call passes an
+	// empty cursor; an empty cursor back means the scan is done.
+	ScanTasks(ctx context.Context, filter TaskFilter, cursor string, limit int) ([]*task.Task, string, error)
+	GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error)
+	GetOverdueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error)
+	OldestTask(ctx context.Context, status task.Status) (*task.Task, error)
+	RankTask(ctx context.Context, status task.Status, id string) (int64, error)
+	CountTasksByStatus(ctx context.Context) (map[task.Status]int64, error)
+	CountTasksByType(ctx context.Context, status task.Status) (map[string]int64, error)
+	CountTasksByPriority(ctx context.Context, status task.Status) (map[task.Priority]int64, error)
+	GetMinuteStats(ctx context.Context, from, to time.Time) ([]MinuteStats, error)
+	GetErrorStats(ctx context.Context, from, to time.Time) ([]ErrorCount, error)
+	RecordDuplicate(ctx context.Context, taskType, producer string) error
+	GetDuplicateStats(ctx context.Context, from, to time.Time) ([]DuplicateCount, error)
+	AppendTaskLogs(ctx context.Context, id string, logs []byte, limit int) error
+	GetTaskLogs(ctx context.Context, id string) ([]byte, error)
 	Close() error
 }
 
 // RedisStorage implements Storage using Redis
 type RedisStorage struct {
 	client *redis.Client
+	// shards is how many sorted sets each status index is split into; see
+	// SetStatusShards
+	shards int
 }
 
 // NewRedisStorage creates a new Redis storage backend
@@ -37,94 +72,361 @@ func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		return nil, unavailable("failed to connect to Redis", err)
 	}
 
-	return &RedisStorage{client: client}, nil
+	return &RedisStorage{client: client, shards: 1}, nil
 }
 
-// SaveTask persists a task to Redis
+// Client returns the underlying Redis client, for components such as
+// leader election that share the connection
+func (r *RedisStorage) Client() *redis.Client {
+	return r.client
+}
+
+// unavailable wraps a backend error so callers can match errs.ErrStorageUnavailable
+func unavailable(msg string, err error) error {
+	return fmt.Errorf("%s: %w: %w", msg, errs.ErrStorageUnavailable, err)
+}
+
+// SaveTask persists a new task to Redis, counting it as submitted in the
+// per-minute stats
 func (r *RedisStorage) SaveTask(ctx context.Context, t *task.Task) error {
-	data, err := t.ToJSON()
-	if err != nil {
+	return r.save(ctx, r.client.Pipeline(), false, t, true)
+}
+
+// taskBuffers holds the buffers tasks are encoded into before they are
+// written, so saving a task allocates no buffer of its own. Buffers grown
+// past maxPooledBuffer by a large payload are left to the collector.
+var taskBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+const maxPooledBuffer = 64 << 10
+
+// save writes t and its indexes, for both new and updated tasks, counting
+// new ones as submitted. Everything goes to Redis on pipe in a single
+// round trip. On a transaction's pipe, tx is true: the index script is
+// then sent whole, as a script Redis has not loaded would fail on its own
+// while the rest of the transaction is applied, and the transaction fails
+// with redis.TxFailedErr if a watched key changed.
+func (r *RedisStorage) save(ctx context.Context, pipe redis.Pipeliner, tx bool, t *task.Task, submitted bool) error {
+	buf := taskBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			taskBuffers.Put(buf)
+		}
+	}()
+	if err := json.NewEncoder(buf).Encode(t); err != nil {
 		return fmt.Errorf("failed to serialize task: %w", err)
 	}
+	// Encode ends the JSON with a newline
+	data := buf.Bytes()[:buf.Len()-1]
+
+	pipe.Set(ctx, "task:"+t.ID, data, 24*time.Hour)
+
+	// Add to status index, age index and counters. Outside a transaction,
+	// the script is loaded the first time it is run outside a pipeline,
+	// below.
+	var indexed *redis.Cmd
+	if tx {
+		indexed = indexScript.Eval(ctx, pipe, r.indexKeys(t), r.indexArgs(t)...)
+	} else {
+		indexed = indexScript.EvalSha(ctx, pipe, r.indexKeys(t), r.indexArgs(t)...)
+	}
 
-	key := fmt.Sprintf("task:%s", t.ID)
-	if err := r.client.Set(ctx, key, data, 24*time.Hour).Err(); err != nil {
-		return fmt.Errorf("failed to save task: %w", err)
+	// Scheduled tasks are also indexed by when they become due
+	if t.Status == task.StatusScheduled && t.ScheduledAt != nil {
+		pipe.ZAdd(ctx, scheduleKey, &redis.Z{
+			Score:  float64(t.ScheduledAt.UnixMilli()),
+			Member: t.ID,
+		})
+	}
+
+	// Unfinished tasks with a deadline are indexed by it, for expiry
+	if t.Deadline != nil {
+		if t.Status.Terminal() {
+			pipe.ZRem(ctx, deadlineKey, t.ID)
+		} else {
+			pipe.ZAdd(ctx, deadlineKey, &redis.Z{
+				Score:  float64(t.Deadline.UnixMilli()),
+				Member: t.ID,
+			})
+		}
+	}
+
+	// Labels never change after submission, so indexing them again is a no-op
+	for k, v := range t.Labels {
+		pipe.SAdd(ctx, labelKey(k, v), t.ID)
 	}
 
-	// Add to status index
-	statusKey := fmt.Sprintf("tasks:status:%s", t.Status)
-	score := float64(t.Priority)*1000000 + float64(t.CreatedAt.Unix())
-	if err := r.client.ZAdd(ctx, statusKey, &redis.Z{
-		Score:  score,
-		Member: t.ID,
-	}).Err(); err != nil {
-		return fmt.Errorf("failed to index task: %w", err)
+	if submitted {
+		key := minuteKey(time.Now())
+		pipe.HIncrBy(ctx, key, "submitted", 1)
+		pipe.Expire(ctx, key, statsRetention)
 	}
 
+	cmds, err := pipe.Exec(ctx)
+	if err == nil || err == redis.TxFailedErr {
+		return err
+	}
+	for _, cmd := range cmds {
+		switch {
+		case cmd.Err() == nil:
+		case !tx && cmd == indexed && strings.HasPrefix(cmd.Err().Error(), "NOSCRIPT "):
+			// Redis has not seen the script since it started
+			if err := r.index(ctx, t); err != nil {
+				return err
+			}
+		default:
+			return unavailable("failed to save task", cmd.Err())
+		}
+	}
 	return nil
 }
 
+// priorityBand is the width of each priority's score range in the status
+// indexes. Creation times in Unix seconds stay below it until 2286, so
+// every task of one priority sorts above every task of the next lower one.
+// Scores up to task.PriorityMax bands stay exact as float64.
+const priorityBand = 1e10
+
+// statusScore orders a task in its status index: by priority, then by
+// creation time. Boosted tasks take the top of their priority's band.
+func statusScore(t *task.Task) float64 {
+	if t.BoostedAt != nil {
+		return float64(t.Priority)*priorityBand + priorityBand - 1
+	}
+	return float64(t.Priority)*priorityBand + float64(t.CreatedAt.Unix())
+}
+
+// priorityRange returns the status index score range of priority, as
+// bounds for ZCOUNT and ZRANGEBYSCORE
+func priorityRange(p task.Priority) (min, max string) {
+	lo := float64(p) * priorityBand
+	return strconv.FormatFloat(lo, 'f', 0, 64), "(" + strconv.FormatFloat(lo+priorityBand, 'f', 0, 64)
+}
+
+// UpgradePriorities moves the status index entries of tasks stored at the
+// old four-level priorities, 1 to 3, to the bands of the presets they
+// stood for, keeping their place within the band; the task records read
+// back as the presets already. Tasks are no longer stored at those
+// priorities, so it is safe to run on every start.
+func (r *RedisStorage) UpgradePriorities(ctx context.Context) error {
+	min, _ := priorityRange(1)
+	_, max := priorityRange(3)
+	for _, status := range task.Statuses {
+		for _, key := range r.statusKeys(status) {
+			for {
+				entries, err := r.client.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+					Min: min, Max: max, Count: 1000,
+				}).Result()
+				if err != nil {
+					return unavailable("failed to upgrade task priorities", err)
+				}
+				if len(entries) == 0 {
+					break
+				}
+				pipe := r.client.Pipeline()
+				for _, z := range entries {
+					old := task.Priority(z.Score / priorityBand)
+					z.Score += float64(old.Upgrade()-old) * priorityBand
+					pipe.ZAdd(ctx, key, &redis.Z{Score: z.Score, Member: z.Member})
+				}
+				if _, err := pipe.Exec(ctx); err != nil {
+					return unavailable("failed to upgrade task priorities", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scheduleKey is the Redis sorted set of scheduled task IDs by due time
+const scheduleKey = "tasks:schedule"
+
+// ageKey is the Redis sorted set of task IDs with a status by creation time
+func ageKey(status task.Status) string {
+	return fmt.Sprintf("tasks:age:%s", status)
+}
+
+// deadlineKey is the Redis sorted set of unfinished task IDs by deadline
+const deadlineKey = "tasks:deadlines"
+
+// labelKey is the Redis set of IDs of tasks labelled key=value
+func labelKey(key, value string) string {
+	return fmt.Sprintf("tasks:label:%s=%s", key, value)
+}
+
 // GetTask retrieves a task from Redis
 func (r *RedisStorage) GetTask(ctx context.Context, id string) (*task.Task, error) {
+	return getTask(ctx, r.client, id)
+}
+
+// getTask reads task id through c, the client or a transaction watching
+// the task
+func getTask(ctx context.Context, c redis.Cmdable, id string) (*task.Task, error) {
 	key := fmt.Sprintf("task:%s", id)
-	data, err := r.client.Get(ctx, key).Bytes()
+	data, err := c.Get(ctx, key).Bytes()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("task not found: %s", id)
+		return nil, fmt.Errorf("%w: %s", errs.ErrTaskNotFound, id)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get task: %w", err)
+		return nil, unavailable("failed to get task", err)
 	}
 
 	return task.FromJSON(data)
 }
 
-// UpdateTask updates an existing task
+// maxUpdateAttempts bounds how often UpdateTask checks and writes a task
+// whose record keeps changing under it
+const maxUpdateAttempts = 10
+
+// UpdateTask updates an existing task. The record, the move between status
+// indexes and counters, and the stats of the transition are written in one
+// transaction, and only if the record has not changed since it was
+// checked, so of two workers starting the same task, only the first
+// succeeds.
 func (r *RedisStorage) UpdateTask(ctx context.Context, t *task.Task) error {
-	// Remove from old status index
-	oldTask, err := r.GetTask(ctx, t.ID)
+	key := fmt.Sprintf("task:%s", t.ID)
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			oldTask, err := getTask(ctx, tx, t.ID)
+			if err != nil {
+				return err
+			}
+
+			if !t.CanReplace(oldTask) {
+				return fmt.Errorf("%w: %s -> %s", errs.ErrInvalidTransition, oldTask.Status, t.Status)
+			}
+
+			pipe := tx.TxPipeline()
+			if oldTask.Status != t.Status {
+				// Remove from old status index
+				unindexScript.Eval(ctx, pipe, r.indexKeys(oldTask), unindexArgs(oldTask)...)
+				if oldTask.Status == task.StatusScheduled {
+					pipe.ZRem(ctx, scheduleKey, t.ID)
+				}
+				r.recordError(ctx, pipe, oldTask.Status, t)
+				r.recordTransition(ctx, pipe, oldTask.Status, t)
+			}
+			return r.save(ctx, pipe, true, t, false)
+		}, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		// Written by someone else in the meantime; check again
+	}
+	return fmt.Errorf("task %s kept changing while it was updated: %w", t.ID, redis.TxFailedErr)
+}
+
+// DeleteTask removes a task from Redis. The record, its index entries and
+// its counts go in one transaction, and only if the record has not changed
+// since it was read, so the counters stay in step with the indexes.
+func (r *RedisStorage) DeleteTask(ctx context.Context, id string) error {
+	key := fmt.Sprintf("task:%s", id)
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			t, err := getTask(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+
+			pipe := tx.TxPipeline()
+			unindexScript.Eval(ctx, pipe, r.indexKeys(t), unindexArgs(t)...)
+			pipe.Del(ctx, key, logsKey(id))
+			pipe.ZRem(ctx, scheduleKey, id)
+			pipe.ZRem(ctx, deadlineKey, id)
+			for k, v := range t.Labels {
+				pipe.SRem(ctx, labelKey(k, v), id)
+			}
+			_, err = pipe.Exec(ctx)
+			if err != nil && err != redis.TxFailedErr {
+				return unavailable("failed to delete task", err)
+			}
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		// Written by someone else in the meantime; read it again
+	}
+	return fmt.Errorf("task %s kept changing while it was deleted: %w", id, redis.TxFailedErr)
+}
+
+// GetTasksByStatus retrieves tasks with a specific status
+func (r *RedisStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	// Get task IDs ordered by priority and creation time (descending)
+	ids, err := r.topOfShards(ctx, r.statusKeys(status), limit, func(pipe redis.Pipeliner, key string) *redis.ZSliceCmd {
+		return pipe.ZRevRangeWithScores(ctx, key, 0, int64(limit-1))
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if oldTask.Status != t.Status {
-		oldStatusKey := fmt.Sprintf("tasks:status:%s", oldTask.Status)
-		r.client.ZRem(ctx, oldStatusKey, t.ID)
+	tasks := make([]*task.Task, 0, len(ids))
+	for _, id := range ids {
+		t, err := r.GetTask(ctx, id)
+		if err != nil {
+			continue // Skip tasks that can't be retrieved
+		}
+		tasks = append(tasks, t)
 	}
 
-	// Save updated task
-	return r.SaveTask(ctx, t)
+	return tasks, nil
 }
 
-// DeleteTask removes a task from Redis
-func (r *RedisStorage) DeleteTask(ctx context.Context, id string) error {
-	t, err := r.GetTask(ctx, id)
-	if err != nil {
-		return err
+// GetTasksByLabels retrieves tasks with a status that carry every label in
+// labels, in the same order as GetTasksByStatus
+func (r *RedisStorage) GetTasksByLabels(ctx context.Context, status task.Status, labels map[string]string, limit int) ([]*task.Task, error) {
+	if len(labels) == 0 {
+		return r.GetTasksByStatus(ctx, status, limit)
 	}
 
-	key := fmt.Sprintf("task:%s", id)
-	statusKey := fmt.Sprintf("tasks:status:%s", t.Status)
+	// Intersect each status index shard with the label sets. Set members
+	// score 0, so the result keeps the status index scores and ordering.
+	weights := []float64{1}
+	var labelKeys []string
+	for k, v := range labels {
+		labelKeys = append(labelKeys, labelKey(k, v))
+		weights = append(weights, 0)
+	}
+	query := "tasks:query:" + uuid.New().String()
+
+	ids, err := r.topOfShards(ctx, r.statusKeys(status), limit, func(pipe redis.Pipeliner, key string) *redis.ZSliceCmd {
+		tmpKey := query + ":" + key
+		pipe.ZInterStore(ctx, tmpKey, &redis.ZStore{Keys: append([]string{key}, labelKeys...), Weights: weights})
+		cmd := pipe.ZRevRangeWithScores(ctx, tmpKey, 0, int64(limit-1))
+		pipe.Del(ctx, tmpKey)
+		return cmd
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	pipe := r.client.Pipeline()
-	pipe.Del(ctx, key)
-	pipe.ZRem(ctx, statusKey, id)
-	_, err = pipe.Exec(ctx)
+	tasks := make([]*task.Task, 0, len(ids))
+	for _, id := range ids {
+		t, err := r.GetTask(ctx, id)
+		if err != nil {
+			continue // Skip tasks that can't be retrieved
+		}
+		tasks = append(tasks, t)
+	}
 
-	return err
+	return tasks, nil
 }
 
-// GetTasksByStatus retrieves tasks with a specific status
-func (r *RedisStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
-	statusKey := fmt.Sprintf("tasks:status:%s", status)
-	
-	// Get task IDs ordered by priority and creation time (descending)
-	ids, err := r.client.ZRevRange(ctx, statusKey, 0, int64(limit-1)).Result()
+// GetTasksByPriority retrieves tasks with a status and priority, newest first
+func (r *RedisStorage) GetTasksByPriority(ctx context.Context, status task.Status, priority task.Priority, limit int) ([]*task.Task, error) {
+	min, max := priorityRange(priority)
+	ids, err := r.topOfShards(ctx, r.statusKeys(status), limit, func(pipe redis.Pipeliner, key string) *redis.ZSliceCmd {
+		return pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:   min,
+			Max:   max,
+			Count: int64(limit),
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get task IDs: %w", err)
+		return nil, err
 	}
 
 	tasks := make([]*task.Task, 0, len(ids))
@@ -139,6 +441,118 @@ func (r *RedisStorage) GetTasksByStatus(ctx context.Context, status task.Status,
 	return tasks, nil
 }
 
+// GetDueTasks retrieves scheduled tasks due at or before now, earliest first
+func (r *RedisStorage) GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	ids, err := r.client.ZRangeByScore(ctx, scheduleKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, unavailable("failed to get due tasks", err)
+	}
+
+	tasks := make([]*task.Task, 0, len(ids))
+	for _, id := range ids {
+		t, err := r.GetTask(ctx, id)
+		if err != nil || t.Status != task.StatusScheduled {
+			continue // Skip tasks that expired or moved on
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, nil
+}
+
+// GetOverdueTasks retrieves unfinished tasks whose deadline passed before
+// now, earliest deadline first
+func (r *RedisStorage) GetOverdueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	ids, err := r.client.ZRangeByScore(ctx, deadlineKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, unavailable("failed to get overdue tasks", err)
+	}
+
+	tasks := make([]*task.Task, 0, len(ids))
+	for _, id := range ids {
+		t, err := r.GetTask(ctx, id)
+		if err != nil {
+			// The task itself expired from Redis; drop it from the index
+			r.client.ZRem(ctx, deadlineKey, id)
+			continue
+		}
+		if t.Status.Terminal() {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, nil
+}
+
+// OldestTask returns the earliest created task with a status, or nil if
+// there is none
+func (r *RedisStorage) OldestTask(ctx context.Context, status task.Status) (*task.Task, error) {
+	for {
+		ids, err := r.client.ZRange(ctx, ageKey(status), 0, 0).Result()
+		if err != nil {
+			return nil, unavailable("failed to get oldest task", err)
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+
+		t, err := r.GetTask(ctx, ids[0])
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, errs.ErrTaskNotFound) {
+			return nil, err
+		}
+		// The task itself expired from Redis; drop it and look again
+		r.client.ZRem(ctx, ageKey(status), ids[0])
+	}
+}
+
+// RankTask returns how many tasks with a status sort ahead of id in its
+// status index, or ErrTaskNotFound if id does not have that status. With
+// sharded indexes, tasks in other shards with the same score, submitted
+// in the same second at the same priority, are not counted.
+func (r *RedisStorage) RankTask(ctx context.Context, status task.Status, id string) (int64, error) {
+	statusKey := r.statusKey(status, id)
+	pipe := r.client.Pipeline()
+	rankCmd := pipe.ZRevRank(ctx, statusKey, id)
+	scoreCmd := pipe.ZScore(ctx, statusKey, id)
+	if _, err := pipe.Exec(ctx); err == redis.Nil {
+		return 0, fmt.Errorf("%w: %s", errs.ErrTaskNotFound, id)
+	} else if err != nil {
+		return 0, unavailable("failed to rank task", err)
+	}
+	rank := rankCmd.Val()
+	if r.shards <= 1 {
+		return rank, nil
+	}
+
+	above := "(" + strconv.FormatFloat(scoreCmd.Val(), 'f', -1, 64)
+	pipe = r.client.Pipeline()
+	var counts []*redis.IntCmd
+	for _, key := range r.statusKeys(status) {
+		if key != statusKey {
+			counts = append(counts, pipe.ZCount(ctx, key, above, "+inf"))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, unavailable("failed to rank task", err)
+	}
+	for _, cmd := range counts {
+		rank += cmd.Val()
+	}
+	return rank, nil
+}
+
 // Close closes the Redis connection
 func (r *RedisStorage) Close() error {
 	return r.client.Close()
@@ -146,57 +560,240 @@ func (r *RedisStorage) Close() error {
 
 // MemoryStorage implements Storage using in-memory map (for testing)
 type MemoryStorage struct {
-	tasks map[string]*task.Task
+	mu      sync.RWMutex
+	tasks   map[string]*task.Task
+	minutes map[int64]*MinuteStats
+	errors  map[int64]map[string]int64
+	logs    map[string][]byte
+	// duplicates counts duplicate submissions, by minute and stats field
+	duplicates map[int64]map[string]int64
+	// statusCounts and typeCounts count tasks by status, and by status and
+	// type, as the Redis counters do
+	statusCounts map[task.Status]int64
+	typeCounts   map[task.Status]map[string]int64
+	// untracked holds fire-and-forget tasks, oldest first
+	untracked []*task.Task
 }
 
 // NewMemoryStorage creates a new in-memory storage backend
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		tasks: make(map[string]*task.Task),
+		tasks:   make(map[string]*task.Task),
+		minutes: make(map[int64]*MinuteStats),
+		errors:  make(map[int64]map[string]int64),
+		logs:    make(map[string][]byte),
+
+		duplicates:   make(map[int64]map[string]int64),
+		statusCounts: make(map[task.Status]int64),
+		typeCounts:   make(map[task.Status]map[string]int64),
 	}
 }
 
 func (m *MemoryStorage) SaveTask(ctx context.Context, t *task.Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tasks[t.ID]; !ok {
+		m.count(time.Now(), map[string]int64{"submitted": 1})
+	}
+	m.save(t)
+	return nil
+}
+
+// save stores a copy of t. Must be called with m.mu held.
+func (m *MemoryStorage) save(t *task.Task) {
+	if old, ok := m.tasks[t.ID]; ok {
+		m.tally(old, -1)
+	}
+	m.tasks[t.ID] = cloneTask(t)
+	m.tally(t, 1)
+}
+
+// cloneTask deep copies t, so callers never share a task with the store
+func cloneTask(t *task.Task) *task.Task {
 	data, _ := json.Marshal(t)
 	var taskCopy task.Task
 	json.Unmarshal(data, &taskCopy)
-	m.tasks[t.ID] = &taskCopy
-	return nil
+	return &taskCopy
 }
 
 func (m *MemoryStorage) GetTask(ctx context.Context, id string) (*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	t, ok := m.tasks[id]
 	if !ok {
-		return nil, fmt.Errorf("task not found: %s", id)
+		return nil, fmt.Errorf("%w: %s", errs.ErrTaskNotFound, id)
 	}
-	data, _ := json.Marshal(t)
-	var taskCopy task.Task
-	json.Unmarshal(data, &taskCopy)
-	return &taskCopy, nil
+	return cloneTask(t), nil
 }
 
 func (m *MemoryStorage) UpdateTask(ctx context.Context, t *task.Task) error {
-	return m.SaveTask(ctx, t)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.tasks[t.ID]
+	if !ok {
+		return fmt.Errorf("%w: %s", errs.ErrTaskNotFound, t.ID)
+	}
+	if !t.CanReplace(old) {
+		return fmt.Errorf("%w: %s -> %s", errs.ErrInvalidTransition, old.Status, t.Status)
+	}
+
+	if old.Status != t.Status {
+		if at, counters := transitionCounters(old.Status, t); counters != nil {
+			m.count(at, counters)
+		}
+		m.countError(old.Status, t)
+	}
+
+	m.save(t)
+	return nil
 }
 
 func (m *MemoryStorage) DeleteTask(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tasks[id]; ok {
+		m.tally(t, -1)
+	}
 	delete(m.tasks, id)
+	delete(m.logs, id)
 	return nil
 }
 
 func (m *MemoryStorage) GetTasksByStatus(ctx context.Context, status task.Status, limit int) ([]*task.Task, error) {
+	return m.GetTasksByLabels(ctx, status, nil, limit)
+}
+
+func (m *MemoryStorage) GetTasksByLabels(ctx context.Context, status task.Status, labels map[string]string, limit int) ([]*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	var tasks []*task.Task
 	for _, t := range m.tasks {
-		if t.Status == status {
-			tasks = append(tasks, t)
-			if len(tasks) >= limit {
-				break
-			}
+		if t.Status == status && t.MatchesLabels(labels) {
+			tasks = append(tasks, cloneTask(t))
 		}
 	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return indexOrder(tasks[i], tasks[j])
+	})
+
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+func (m *MemoryStorage) GetTasksByPriority(ctx context.Context, status task.Status, priority task.Priority, limit int) ([]*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tasks []*task.Task
+	for _, t := range m.tasks {
+		if t.Status == status && t.Priority == priority {
+			tasks = append(tasks, cloneTask(t))
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return indexOrder(tasks[i], tasks[j])
+	})
+
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+// indexOrder reports whether a sorts before b in the Redis status index:
+// highest priority first, then boosted tasks, then newest first
+func indexOrder(a, b *task.Task) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if (a.BoostedAt != nil) != (b.BoostedAt != nil) {
+		return a.BoostedAt != nil
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
+
+func (m *MemoryStorage) GetDueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tasks []*task.Task
+	for _, t := range m.tasks {
+		if t.Status == task.StatusScheduled && t.ScheduledAt != nil && !t.ScheduledAt.After(now) {
+			tasks = append(tasks, cloneTask(t))
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].ScheduledAt.Before(*tasks[j].ScheduledAt)
+	})
+
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+func (m *MemoryStorage) GetOverdueTasks(ctx context.Context, now time.Time, limit int) ([]*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tasks []*task.Task
+	for _, t := range m.tasks {
+		if !t.Status.Terminal() && t.Overdue(now) {
+			tasks = append(tasks, cloneTask(t))
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Deadline.Before(*tasks[j].Deadline)
+	})
+
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
 	return tasks, nil
 }
 
+func (m *MemoryStorage) RankTask(ctx context.Context, status task.Status, id string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.tasks[id]
+	if !ok || t.Status != status {
+		return 0, fmt.Errorf("%w: %s", errs.ErrTaskNotFound, id)
+	}
+	var rank int64
+	for _, other := range m.tasks {
+		if other.Status == status && indexOrder(other, t) {
+			rank++
+		}
+	}
+	return rank, nil
+}
+
+func (m *MemoryStorage) OldestTask(ctx context.Context, status task.Status) (*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var oldest *task.Task
+	for _, t := range m.tasks {
+		if t.Status == status && (oldest == nil || t.CreatedAt.Before(oldest.CreatedAt)) {
+			oldest = t
+		}
+	}
+	if oldest == nil {
+		return nil, nil
+	}
+	return cloneTask(oldest), nil
+}
+
 func (m *MemoryStorage) Close() error {
 	return nil
 }
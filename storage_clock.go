@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// DefaultClockSync is how often a RedisClock measures its offset again
+const DefaultClockSync = time.Minute
+
+// RedisClock tells the time by the Redis server's clock rather than this
+// node's, so every process agrees on when deadlines pass, retries and
+// scheduled tasks are due and schedules fire, however far their own clocks
+// drift. It measures its offset from Redis TIME and adds it to the local
+// clock, so Now costs no round trip.
+type RedisClock struct {
+	client *redis.Client
+	// offset is the server's clock minus ours, in nanoseconds
+	offset atomic.Int64
+}
+
+// NewRedisClock creates a clock on client. It reads the local time until
+// Sync has succeeded once.
+func NewRedisClock(client *redis.Client) *RedisClock {
+	return &RedisClock{client: client}
+}
+
+// Now returns the server's current time
+func (c *RedisClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+// Offset returns how far the server's clock is ahead of this node's
+func (c *RedisClock) Offset() time.Duration {
+	return time.Duration(c.offset.Load())
+}
+
+// Sync measures the offset from Redis TIME, taking the server's reading to
+// be from halfway through the round trip
+func (c *RedisClock) Sync(ctx context.Context) error {
+	sent := time.Now()
+	server, err := c.client.Time(ctx).Result()
+	if err != nil {
+		return unavailable("failed to read Redis time", err)
+	}
+	received := time.Now()
+	local := sent.Add(received.Sub(sent) / 2)
+	c.offset.Store(int64(server.Sub(local)))
+	return nil
+}
+
+// Run syncs every interval, DefaultClockSync if zero, until ctx is done.
+// A failed sync keeps the last offset.
+func (c *RedisClock) Run(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	if interval == 0 {
+		interval = DefaultClockSync
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Sync(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("failed to sync clock with Redis", zap.Error(err))
+			}
+		}
+	}
+}
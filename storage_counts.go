@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// countsKey is the Redis hash counting tasks by status. With the per-type
+// hashes, it is kept in step with the status indexes by indexScript and
+// unindexScript, so counts are read without touching the indexes. A task
+// changing status leaves its old index in the transaction that adds it to
+// the new one, and a deleted task leaves its index with its record, so no
+// task is counted twice or after it is gone.
+const countsKey = "tasks:counts"
+
+// indexScript adds a task to its status index shard and, if it was not
+// there already, to the age index and the status and type counters.
+// KEYS: status shard, age index, type counts, status counts.
+// ARGV: id, score, created at in ms, type, status.
+var indexScript = redis.NewScript(`
+if redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+redis.call("HINCRBY", KEYS[3], ARGV[4], 1)
+redis.call("HINCRBY", KEYS[4], ARGV[5], 1)
+return 1`)
+
+// unindexScript removes a task from its status index shard and, if it was
+// there, from the age index and the status and type counters.
+// KEYS: status shard, age index, type counts, status counts.
+// ARGV: id, type, status.
+var unindexScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("HINCRBY", KEYS[3], ARGV[2], -1)
+redis.call("HINCRBY", KEYS[4], ARGV[3], -1)
+return 1`)
+
+// seedCountsScript sets the status counters from the index sizes, unless
+// they are kept already. KEYS: status counts, then the shards of each
+// status. ARGV: pairs of status and its number of shards.
+var seedCountsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local k = 2
+for i = 1, #ARGV, 2 do
+	local n = 0
+	for _ = 1, tonumber(ARGV[i + 1]) do
+		n = n + redis.call("ZCARD", KEYS[k])
+		k = k + 1
+	end
+	redis.call("HSET", KEYS[1], ARGV[i], n)
+end
+return 1`)
+
+// index adds t to the indexes and counters of its status
+func (r *RedisStorage) index(ctx context.Context, t *task.Task) error {
+	if err := indexScript.Run(ctx, r.client, r.indexKeys(t), r.indexArgs(t)...).Err(); err != nil {
+		return unavailable("failed to index task", err)
+	}
+	return nil
+}
+
+// indexKeys and indexArgs are the KEYS and ARGV of indexScript for t;
+// unindexScript takes the same KEYS
+func (r *RedisStorage) indexKeys(t *task.Task) []string {
+	return []string{r.statusKey(t.Status, t.ID), ageKey(t.Status), typeCountKey(t.Status), countsKey}
+}
+
+func (r *RedisStorage) indexArgs(t *task.Task) []interface{} {
+	return []interface{}{t.ID, statusScore(t), t.CreatedAt.UnixMilli(), t.Type, string(t.Status)}
+}
+
+// unindexArgs are the ARGV of unindexScript for t
+func unindexArgs(t *task.Task) []interface{} {
+	return []interface{}{t.ID, t.Type, string(t.Status)}
+}
+
+// seedCounts starts the status counters of indexes written before they
+// were kept
+func (r *RedisStorage) seedCounts(ctx context.Context) error {
+	keys := []string{countsKey}
+	var args []interface{}
+	for _, status := range task.Statuses {
+		shards := r.statusKeys(status)
+		keys = append(keys, shards...)
+		args = append(args, string(status), len(shards))
+	}
+	if err := seedCountsScript.Run(ctx, r.client, keys, args...).Err(); err != nil {
+		return unavailable("failed to seed task counts", err)
+	}
+	return nil
+}
+
+// CountTasksByStatus returns the number of tasks in each status, from the
+// status counters
+func (r *RedisStorage) CountTasksByStatus(ctx context.Context) (map[task.Status]int64, error) {
+	fields, err := r.client.HGetAll(ctx, countsKey).Result()
+	if err != nil {
+		return nil, unavailable("failed to count tasks", err)
+	}
+
+	counts := make(map[task.Status]int64, len(task.Statuses))
+	for _, status := range task.Statuses {
+		n, _ := strconv.ParseInt(fields[string(status)], 10, 64)
+		if n < 0 {
+			n = 0
+		}
+		counts[status] = n
+	}
+	return counts, nil
+}
+
+// tally adds delta to the counters of t's status and type. Must be called
+// with m.mu held.
+func (m *MemoryStorage) tally(t *task.Task, delta int64) {
+	m.statusCounts[t.Status] += delta
+	if m.typeCounts[t.Status] == nil {
+		m.typeCounts[t.Status] = make(map[string]int64)
+	}
+	m.typeCounts[t.Status][t.Type] += delta
+	if m.typeCounts[t.Status][t.Type] == 0 {
+		delete(m.typeCounts[t.Status], t.Type)
+	}
+}
+
+func (m *MemoryStorage) CountTasksByStatus(ctx context.Context) (map[task.Status]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[task.Status]int64, len(task.Statuses))
+	for _, status := range task.Statuses {
+		counts[status] = m.statusCounts[status]
+	}
+	return counts, nil
+}
+
+func (m *MemoryStorage) CountTasksByType(ctx context.Context, status task.Status) (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int64, len(m.typeCounts[status]))
+	for taskType, n := range m.typeCounts[status] {
+		counts[taskType] = n
+	}
+	return counts, nil
+}
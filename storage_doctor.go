@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// IndexReport counts the inconsistencies CheckIndexes found between the
+// task records and their indexes, and repaired if asked to
+type IndexReport struct {
+	// Dangling counts status index entries whose task record is gone,
+	// usually because it expired
+	Dangling int `json:"dangling"`
+	// Stale counts status index entries for a status their task has left
+	Stale int `json:"stale"`
+	// Unindexed counts task records missing from their status index
+	Unindexed int `json:"unindexed"`
+	// Counters counts status and type counters that disagreed with the
+	// entries found
+	Counters int `json:"counters"`
+}
+
+// IndexChecker is implemented by storage whose indexes can fall out of
+// step with its task records, such as after a process died between writes
+type IndexChecker interface {
+	// CheckIndexes scans every task record and index entry, reporting the
+	// inconsistencies and, with repair, fixing them
+	CheckIndexes(ctx context.Context, repair bool) (IndexReport, error)
+}
+
+// checkBatch is how many keys or entries CheckIndexes reads at a time
+const checkBatch = 1000
+
+// CheckIndexes first indexes task records missing from their status
+// index, then walks each status index, dropping entries whose record is
+// gone or has moved to another status, and finally corrects the counters
+// to the entries it found. Tasks that change status while it runs can make
+// the counters off by as many; run it again once the queue is quiet for
+// exact counts.
+func (r *RedisStorage) CheckIndexes(ctx context.Context, repair bool) (IndexReport, error) {
+	var report IndexReport
+	if err := r.checkRecords(ctx, repair, &report); err != nil {
+		return report, err
+	}
+	for _, status := range task.Statuses {
+		found := make(map[string]int64)
+		for _, key := range r.statusKeys(status) {
+			if err := r.checkIndex(ctx, status, key, repair, found, &report); err != nil {
+				return report, err
+			}
+		}
+		if err := r.checkCounters(ctx, status, found, repair, &report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// checkRecords finds task records missing from their status index
+func (r *RedisStorage) checkRecords(ctx context.Context, repair bool, report *IndexReport) error {
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, "task:*", checkBatch).Result()
+		if err != nil {
+			return unavailable("failed to scan task records", err)
+		}
+		var ids []string
+		for _, key := range keys {
+			if !strings.HasPrefix(key, "task:logs:") {
+				ids = append(ids, strings.TrimPrefix(key, "task:"))
+			}
+		}
+		tasks, err := r.getTasks(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			indexed, err := r.indexed(ctx, t.Status, t.ID)
+			if err != nil {
+				return err
+			}
+			if indexed {
+				continue
+			}
+			// The task may be between its index and record writes; look
+			// again before calling it unindexed
+			current, err := r.GetTask(ctx, t.ID)
+			if err != nil {
+				continue
+			}
+			if indexed, err = r.indexed(ctx, current.Status, current.ID); err != nil || indexed {
+				continue
+			}
+			report.Unindexed++
+			if repair {
+				if err := r.index(ctx, current); err != nil {
+					return err
+				}
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// checkIndex walks one status index shard, counting the entries whose
+// record agrees by type in found
+func (r *RedisStorage) checkIndex(ctx context.Context, status task.Status, key string, repair bool, found map[string]int64, report *IndexReport) error {
+	var cursor uint64
+	for {
+		// ZSCAN returns members and scores alternately
+		entries, next, err := r.client.ZScan(ctx, key, cursor, "", checkBatch).Result()
+		if err != nil {
+			return unavailable("failed to scan status index", err)
+		}
+		var ids []string
+		for i := 0; i < len(entries); i += 2 {
+			ids = append(ids, entries[i])
+		}
+		tasks, err := r.getTasks(ctx, ids)
+		if err != nil {
+			return err
+		}
+		records := make(map[string]*task.Task, len(tasks))
+		for _, t := range tasks {
+			records[t.ID] = t
+		}
+
+		for _, id := range ids {
+			t := records[id]
+			if t != nil && t.Status == status {
+				found[t.Type]++
+				continue
+			}
+			// Only entries still there are out of step; the task may have
+			// moved on since the scan read it
+			if indexed, err := r.indexed(ctx, status, id); err != nil || !indexed {
+				continue
+			}
+			if t == nil {
+				report.Dangling++
+			} else {
+				report.Stale++
+			}
+			if !repair {
+				continue
+			}
+			pipe := r.client.Pipeline()
+			pipe.ZRem(ctx, key, id)
+			pipe.ZRem(ctx, ageKey(status), id)
+			if t == nil {
+				pipe.ZRem(ctx, deadlineKey, id)
+			}
+			if status == task.StatusScheduled {
+				pipe.ZRem(ctx, scheduleKey, id)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return unavailable("failed to repair status index", err)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// checkCounters compares the counters of status with the entries found,
+// by type, moving them to match with repair
+func (r *RedisStorage) checkCounters(ctx context.Context, status task.Status, found map[string]int64, repair bool, report *IndexReport) error {
+	recorded, err := r.client.HGetAll(ctx, typeCountKey(status)).Result()
+	if err != nil {
+		return unavailable("failed to read task counters", err)
+	}
+	total, err := r.client.HGet(ctx, countsKey, string(status)).Int64()
+	if err != nil && err != redis.Nil {
+		return unavailable("failed to read task counters", err)
+	}
+
+	deltas := make(map[string]int64)
+	for taskType, n := range found {
+		deltas[taskType] = n
+	}
+	for taskType, value := range recorded {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		deltas[taskType] -= n
+	}
+	var sum int64
+	for _, n := range found {
+		sum += n
+	}
+
+	pipe := r.client.Pipeline()
+	for taskType, delta := range deltas {
+		if delta == 0 {
+			continue
+		}
+		report.Counters++
+		pipe.HIncrBy(ctx, typeCountKey(status), taskType, delta)
+	}
+	if sum != total {
+		report.Counters++
+		pipe.HIncrBy(ctx, countsKey, string(status), sum-total)
+	}
+	if !repair || pipe.Len() == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return unavailable("failed to repair task counters", err)
+	}
+	return nil
+}
+
+// indexed reports whether id has an entry in its shard of status's index
+func (r *RedisStorage) indexed(ctx context.Context, status task.Status, id string) (bool, error) {
+	err := r.client.ZScore(ctx, r.statusKey(status, id), id).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, unavailable(fmt.Sprintf("failed to check index of task %s", id), err)
+	}
+	return true, nil
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DuplicateCount counts the submissions of one task type by one producer
+// that repeated a task already submitted
+type DuplicateCount struct {
+	Type string `json:"type"`
+	// Producer identifies who submitted them, such as a fingerprint of
+	// their API key
+	Producer string `json:"producer"`
+	Count    int64  `json:"count"`
+}
+
+// duplicateField is the stats field counting the duplicates of taskType by
+// producer
+func duplicateField(taskType, producer string) string {
+	return taskType + "\x1f" + producer
+}
+
+// duplicateCounts turns totals by stats field into counts, most frequent
+// first
+func duplicateCounts(totals map[string]int64) []DuplicateCount {
+	counts := make([]DuplicateCount, 0, len(totals))
+	for field, n := range totals {
+		taskType, producer, ok := strings.Cut(field, "\x1f")
+		if ok && n > 0 {
+			counts = append(counts, DuplicateCount{Type: taskType, Producer: producer, Count: n})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Producer < b.Producer
+	})
+	return counts
+}
+
+// duplicatesKey is the Redis hash counting duplicate submissions for the
+// minute containing t
+func duplicatesKey(t time.Time) string {
+	return fmt.Sprintf("stats:duplicates:%d", t.Truncate(time.Minute).Unix())
+}
+
+// RecordDuplicate counts a duplicate submission in its minute's bucket
+func (r *RedisStorage) RecordDuplicate(ctx context.Context, taskType, producer string) error {
+	key := duplicatesKey(time.Now())
+	pipe := r.client.Pipeline()
+	pipe.HIncrBy(ctx, key, duplicateField(taskType, producer), 1)
+	pipe.Expire(ctx, key, statsRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return unavailable("failed to record duplicate submission", err)
+	}
+	return nil
+}
+
+// GetDuplicateStats returns the duplicate submissions from from to to,
+// counted by task type and producer
+func (r *RedisStorage) GetDuplicateStats(ctx context.Context, from, to time.Time) ([]DuplicateCount, error) {
+	from, to = from.Truncate(time.Minute), to.Truncate(time.Minute)
+
+	pipe := r.client.Pipeline()
+	var cmds []*redis.StringStringMapCmd
+	for minute := from; !minute.After(to); minute = minute.Add(time.Minute) {
+		cmds = append(cmds, pipe.HGetAll(ctx, duplicatesKey(minute)))
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, unavailable("failed to get duplicate stats", err)
+	}
+
+	totals := make(map[string]int64)
+	for _, cmd := range cmds {
+		for field, value := range cmd.Val() {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			totals[field] += n
+		}
+	}
+	return duplicateCounts(totals), nil
+}
+
+func (m *MemoryStorage) RecordDuplicate(ctx context.Context, taskType, producer string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	minute := time.Now().Truncate(time.Minute).Unix()
+	if m.duplicates[minute] == nil {
+		m.duplicates[minute] = make(map[string]int64)
+	}
+	m.duplicates[minute][duplicateField(taskType, producer)]++
+	return nil
+}
+
+func (m *MemoryStorage) GetDuplicateStats(ctx context.Context, from, to time.Time) ([]DuplicateCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	totals := make(map[string]int64)
+	for minute := from.Truncate(time.Minute); !minute.After(to); minute = minute.Add(time.Minute) {
+		for field, n := range m.duplicates[minute.Unix()] {
+			totals[field] += n
+		}
+	}
+	return duplicateCounts(totals), nil
+}
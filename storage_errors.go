@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// maxSignatureLength bounds error signatures, so one long message does not
+// bloat the stats
+const maxSignatureLength = 200
+
+// ErrorCount counts the failed attempts of one task type sharing an error
+// signature
+type ErrorCount struct {
+	Type     string             `json:"type"`
+	Category task.ErrorCategory `json:"category"`
+	// Signature is the error message with IDs and numbers replaced, so
+	// failures differing only in those are counted together
+	Signature string `json:"signature"`
+	Count     int64  `json:"count"`
+}
+
+var (
+	uuidPattern   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	numberPattern = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]*[0-9][0-9a-fA-F]*\b`)
+)
+
+// ErrorSignature normalizes an error message, replacing UUIDs with <id>
+// and numbers with <n>
+func ErrorSignature(msg string) string {
+	sig := uuidPattern.ReplaceAllString(msg, "<id>")
+	sig = numberPattern.ReplaceAllString(sig, "<n>")
+	if len(sig) > maxSignatureLength {
+		sig = sig[:maxSignatureLength]
+	}
+	return sig
+}
+
+// errorField is the stats field counting t's error, or "" if t's status
+// change is not a failed attempt
+func errorField(from task.Status, t *task.Task) string {
+	if from == t.Status || t.ErrorCategory == "" || t.Error == "" {
+		return ""
+	}
+	if t.Status != task.StatusRetrying && t.Status != task.StatusFailed {
+		return ""
+	}
+	return strings.Join([]string{t.Type, string(t.ErrorCategory), ErrorSignature(t.Error)}, "\x1f")
+}
+
+// parseErrorField splits a field written by errorField
+func parseErrorField(field string, n int64) (ErrorCount, bool) {
+	parts := strings.SplitN(field, "\x1f", 3)
+	if len(parts) != 3 {
+		return ErrorCount{}, false
+	}
+	return ErrorCount{Type: parts[0], Category: task.ErrorCategory(parts[1]), Signature: parts[2], Count: n}, true
+}
+
+// sortErrorCounts orders counts by type, then most frequent first
+func sortErrorCounts(counts []ErrorCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Signature < b.Signature
+	})
+}
+
+// errorsKey is the Redis hash counting error signatures for the minute
+// containing t
+func errorsKey(t time.Time) string {
+	return fmt.Sprintf("stats:errors:%d", t.Truncate(time.Minute).Unix())
+}
+
+// recordError counts the error of a failed attempt in its minute's
+// bucket, on pipe
+func (r *RedisStorage) recordError(ctx context.Context, pipe redis.Pipeliner, from task.Status, t *task.Task) {
+	field := errorField(from, t)
+	if field == "" {
+		return
+	}
+	key := errorsKey(time.Now())
+	pipe.HIncrBy(ctx, key, field, 1)
+	pipe.Expire(ctx, key, statsRetention)
+}
+
+// GetErrorStats returns the failed attempts from from to to, counted by
+// task type and error signature
+func (r *RedisStorage) GetErrorStats(ctx context.Context, from, to time.Time) ([]ErrorCount, error) {
+	from, to = from.Truncate(time.Minute), to.Truncate(time.Minute)
+
+	pipe := r.client.Pipeline()
+	var cmds []*redis.StringStringMapCmd
+	for minute := from; !minute.After(to); minute = minute.Add(time.Minute) {
+		cmds = append(cmds, pipe.HGetAll(ctx, errorsKey(minute)))
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, unavailable("failed to get error stats", err)
+	}
+
+	totals := make(map[string]int64)
+	for _, cmd := range cmds {
+		for field, value := range cmd.Val() {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			totals[field] += n
+		}
+	}
+	return errorCounts(totals), nil
+}
+
+// errorCounts turns totals by stats field into sorted counts
+func errorCounts(totals map[string]int64) []ErrorCount {
+	counts := make([]ErrorCount, 0, len(totals))
+	for field, n := range totals {
+		if c, ok := parseErrorField(field, n); ok && n > 0 {
+			counts = append(counts, c)
+		}
+	}
+	sortErrorCounts(counts)
+	return counts
+}
+
+// countError counts the error of a failed attempt in its minute's bucket.
+// Must be called with m.mu held.
+func (m *MemoryStorage) countError(from task.Status, t *task.Task) {
+	field := errorField(from, t)
+	if field == "" {
+		return
+	}
+	minute := time.Now().Truncate(time.Minute).Unix()
+	if m.errors[minute] == nil {
+		m.errors[minute] = make(map[string]int64)
+	}
+	m.errors[minute][field]++
+}
+
+func (m *MemoryStorage) GetErrorStats(ctx context.Context, from, to time.Time) ([]ErrorCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	totals := make(map[string]int64)
+	for minute := from.Truncate(time.Minute); !minute.After(to); minute = minute.Add(time.Minute) {
+		for field, n := range m.errors[minute.Unix()] {
+			totals[field] += n
+		}
+	}
+	return errorCounts(totals), nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// logsKey holds the captured logs of a task, as JSON lines
+func logsKey(id string) string {
+	return fmt.Sprintf("task:logs:%s", id)
+}
+
+// TrimLogs keeps the last limit bytes of logs, starting from a whole line
+func TrimLogs(logs []byte, limit int) []byte {
+	if len(logs) <= limit {
+		return logs
+	}
+	logs = logs[len(logs)-limit:]
+	if i := bytes.IndexByte(logs, '\n'); i >= 0 {
+		logs = logs[i+1:]
+	}
+	return logs
+}
+
+// AppendTaskLogs adds log lines to a task's logs, keeping the last limit
+// bytes
+func (r *RedisStorage) AppendTaskLogs(ctx context.Context, id string, logs []byte, limit int) error {
+	key := logsKey(id)
+	size, err := r.client.Append(ctx, key, string(logs)).Result()
+	if err != nil {
+		return unavailable("failed to append task logs", err)
+	}
+	if size <= int64(limit) {
+		return nil
+	}
+
+	// Only the worker running the task appends, so nothing is lost
+	// between reading and rewriting
+	all, err := r.client.Get(ctx, key).Bytes()
+	if err != nil {
+		return unavailable("failed to trim task logs", err)
+	}
+	if err := r.client.Set(ctx, key, TrimLogs(all, limit), 0).Err(); err != nil {
+		return unavailable("failed to trim task logs", err)
+	}
+	return nil
+}
+
+// GetTaskLogs returns a task's logs, or nil if it has none
+func (r *RedisStorage) GetTaskLogs(ctx context.Context, id string) ([]byte, error) {
+	logs, err := r.client.Get(ctx, logsKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, unavailable("failed to get task logs", err)
+	}
+	return logs, nil
+}
+
+func (m *MemoryStorage) AppendTaskLogs(ctx context.Context, id string, logs []byte, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	all := append(m.logs[id], logs...)
+	m.logs[id] = append([]byte(nil), TrimLogs(all, limit)...)
+	return nil
+}
+
+func (m *MemoryStorage) GetTaskLogs(ctx context.Context, id string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]byte(nil), m.logs[id]...), nil
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// indexPosition is where a Redis page resumes: the status index score and
+// ID of the last entry on the page before
+func indexPosition(z redis.Z) string {
+	return strconv.FormatFloat(z.Score, 'f', -1, 64) + ":" + z.Member.(string)
+}
+
+func parseIndexPosition(cursor string) (redis.Z, error) {
+	parts := strings.SplitN(cursor, ":", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		if score, err := strconv.ParseFloat(parts[0], 64); err == nil {
+			return redis.Z{Score: score, Member: parts[1]}, nil
+		}
+	}
+	return redis.Z{}, errs.Invalidf("invalid cursor")
+}
+
+// GetTasksPage reads each status index shard from the cursor's score down
+// with ZREVRANGEBYSCORE, so a page costs about the same however deep it
+// is. Entries sharing the cursor's score, the tasks of one priority
+// created in the same second, are read in full to find those after its
+// ID. With labels, each shard is first intersected with the label sets,
+// as in GetTasksByLabels.
+func (r *RedisStorage) GetTasksPage(ctx context.Context, status task.Status, labels map[string]string, cursor string, limit int) ([]*task.Task, string, error) {
+	var after redis.Z
+	if cursor != "" {
+		var err error
+		if after, err = parseIndexPosition(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	score := strconv.FormatFloat(after.Score, 'f', -1, 64)
+
+	weights := []float64{1}
+	var labelKeys []string
+	for k, v := range labels {
+		labelKeys = append(labelKeys, labelKey(k, v))
+		weights = append(weights, 0)
+	}
+	query := "tasks:query:" + uuid.New().String()
+
+	var cmds []*redis.ZSliceCmd
+	pipe := r.client.Pipeline()
+	for _, key := range r.statusKeys(status) {
+		tmpKey := ""
+		if len(labels) > 0 {
+			tmpKey = query + ":" + key
+			pipe.ZInterStore(ctx, tmpKey, &redis.ZStore{Keys: append([]string{key}, labelKeys...), Weights: weights})
+			key = tmpKey
+		}
+		max := "+inf"
+		if cursor != "" {
+			cmds = append(cmds, pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: score, Max: score}))
+			max = "(" + score
+		}
+		cmds = append(cmds, pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   max,
+			Count: int64(limit + 1),
+		}))
+		if tmpKey != "" {
+			pipe.Del(ctx, tmpKey)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, "", unavailable("failed to get task IDs", err)
+	}
+
+	// Every entry read sorts at or below the cursor's score; of those at
+	// it, only the ones after its ID belong on the page
+	var merged []redis.Z
+	for _, cmd := range cmds {
+		for _, z := range cmd.Val() {
+			if cursor == "" || z.Score < after.Score || z.Member.(string) < after.Member.(string) {
+				merged = append(merged, z)
+			}
+		}
+	}
+	// Redis orders equal scores by member, highest first for reverse
+	// ranges; keep that across shards
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Score != merged[j].Score {
+			return merged[i].Score > merged[j].Score
+		}
+		return merged[i].Member.(string) > merged[j].Member.(string)
+	})
+
+	next := ""
+	if len(merged) > limit {
+		merged = merged[:limit]
+		next = indexPosition(merged[limit-1])
+	}
+	ids := make([]string, len(merged))
+	for i, z := range merged {
+		ids[i] = z.Member.(string)
+	}
+	tasks, err := r.getTasks(ctx, ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return tasks, next, nil
+}
+
+// pageOrder is indexOrder with ties broken by ID, highest first as Redis
+// breaks them, so every task has one place to resume after
+func pageOrder(a, b *task.Task) bool {
+	if indexOrder(a, b) || indexOrder(b, a) {
+		return indexOrder(a, b)
+	}
+	return a.ID > b.ID
+}
+
+// memoryPosition encodes the fields pageOrder compares
+func memoryPosition(t *task.Task) string {
+	boosted := 0
+	if t.BoostedAt != nil {
+		boosted = 1
+	}
+	return fmt.Sprintf("%d:%d:%d:%s", t.Priority, boosted, t.CreatedAt.UnixNano(), t.ID)
+}
+
+// parseMemoryPosition returns a task that sorts where the cursor points
+func parseMemoryPosition(cursor string) (*task.Task, error) {
+	parts := strings.SplitN(cursor, ":", 4)
+	if len(parts) == 4 && parts[3] != "" && (parts[1] == "0" || parts[1] == "1") {
+		priority, err1 := strconv.Atoi(parts[0])
+		created, err2 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 == nil && err2 == nil {
+			t := &task.Task{ID: parts[3], Priority: task.Priority(priority), CreatedAt: time.Unix(0, created)}
+			if parts[1] == "1" {
+				t.BoostedAt = &t.CreatedAt
+			}
+			return t, nil
+		}
+	}
+	return nil, errs.Invalidf("invalid cursor")
+}
+
+func (m *MemoryStorage) GetTasksPage(ctx context.Context, status task.Status, labels map[string]string, cursor string, limit int) ([]*task.Task, string, error) {
+	var after *task.Task
+	if cursor != "" {
+		var err error
+		if after, err = parseMemoryPosition(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tasks []*task.Task
+	for _, t := range m.tasks {
+		if t.Status == status && t.MatchesLabels(labels) && (after == nil || pageOrder(after, t)) {
+			tasks = append(tasks, cloneTask(t))
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return pageOrder(tasks[i], tasks[j])
+	})
+
+	next := ""
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		next = memoryPosition(tasks[limit-1])
+	}
+	return tasks, next, nil
+}
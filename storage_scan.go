@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// DefaultScanLimit is how many tasks Each reads per ScanTasks call
+const DefaultScanLimit = 500
+
+// TaskFilter selects the tasks ScanTasks returns. Empty fields match every
+// task.
+type TaskFilter struct {
+	// Statuses limits the scan to tasks with these statuses
+	Statuses []task.Status
+	Type     string
+	Labels   map[string]string
+}
+
+// Matches reports whether t passes the filter
+func (f TaskFilter) Matches(t *task.Task) bool {
+	if len(f.Statuses) > 0 && !containsStatus(f.Statuses, t.Status) {
+		return false
+	}
+	if f.Type != "" && t.Type != f.Type {
+		return false
+	}
+	return t.MatchesLabels(f.Labels)
+}
+
+// statuses returns the statuses a scan visits, in order
+func (f TaskFilter) statuses() []task.Status {
+	if len(f.Statuses) > 0 {
+		return f.Statuses
+	}
+	return task.Statuses
+}
+
+func containsStatus(statuses []task.Status, status task.Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Each calls fn with every task in store that matches filter, reading
+// DefaultScanLimit at a time, until fn returns an error
+func Each(ctx context.Context, store Storage, filter TaskFilter, fn func(t *task.Task) error) error {
+	cursor := ""
+	for {
+		tasks, next, err := store.ScanTasks(ctx, filter, cursor, DefaultScanLimit)
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// BatchGetter is implemented by storage that reads many task records at
+// once
+type BatchGetter interface {
+	// GetTasks returns the records of ids that exist, skipping the rest
+	GetTasks(ctx context.Context, ids []string) ([]*task.Task, error)
+}
+
+// GetTasks returns the records of ids that exist in store, in one call if
+// it is a BatchGetter and one GetTask per ID otherwise
+func GetTasks(ctx context.Context, store Storage, ids []string) ([]*task.Task, error) {
+	if b, ok := store.(BatchGetter); ok {
+		return b.GetTasks(ctx, ids)
+	}
+	tasks := make([]*task.Task, 0, len(ids))
+	for _, id := range ids {
+		t, err := store.GetTask(ctx, id)
+		if errors.Is(err, errs.ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+// scanCursor is where a Redis scan resumes: the status of the filter, the
+// shard of its index and the ZSCAN cursor within it
+type scanCursor struct {
+	status, shard int
+	offset        uint64
+}
+
+func parseScanCursor(cursor string) (scanCursor, error) {
+	var c scanCursor
+	if cursor == "" {
+		return c, nil
+	}
+	if _, err := fmt.Sscanf(cursor, "%d:%d:%d", &c.status, &c.shard, &c.offset); err != nil || c.status < 0 || c.shard < 0 {
+		return c, errs.Invalidf("invalid cursor %q", cursor)
+	}
+	return c, nil
+}
+
+func (c scanCursor) String() string {
+	return fmt.Sprintf("%d:%d:%d", c.status, c.shard, c.offset)
+}
+
+// ScanTasks walks the status indexes with ZSCAN, so each call reads about
+// limit entries however large the queue is. A page can hold fewer tasks
+// than limit, even none, when the filter skips some; carry on until the
+// returned cursor is empty. Like ZSCAN, a task indexed throughout the scan
+// is returned at least once, while one that changes status meanwhile may
+// be returned twice or not at all.
+func (r *RedisStorage) ScanTasks(ctx context.Context, filter TaskFilter, cursor string, limit int) ([]*task.Task, string, error) {
+	pos, err := parseScanCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	statuses := filter.statuses()
+	var tasks []*task.Task
+	read := 0
+	for pos.status < len(statuses) && read < limit {
+		keys := r.statusKeys(statuses[pos.status])
+		if pos.shard >= len(keys) {
+			pos = scanCursor{status: pos.status + 1}
+			continue
+		}
+
+		// ZSCAN returns members and scores alternately
+		entries, next, err := r.client.ZScan(ctx, keys[pos.shard], pos.offset, "", int64(limit-read)).Result()
+		if err != nil {
+			return nil, "", unavailable("failed to scan tasks", err)
+		}
+		var ids []string
+		for i := 0; i < len(entries); i += 2 {
+			ids = append(ids, entries[i])
+		}
+		found, err := r.getTasks(ctx, ids)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, t := range found {
+			if filter.Matches(t) {
+				tasks = append(tasks, t)
+			}
+		}
+		read += len(ids)
+
+		if next == 0 {
+			pos = scanCursor{status: pos.status, shard: pos.shard + 1}
+		} else {
+			pos.offset = next
+		}
+	}
+
+	if pos.status >= len(statuses) {
+		return tasks, "", nil
+	}
+	return tasks, pos.String(), nil
+}
+
+func (r *RedisStorage) GetTasks(ctx context.Context, ids []string) ([]*task.Task, error) {
+	return r.getTasks(ctx, ids)
+}
+
+// getTasks reads the records of ids in one round trip, skipping those that
+// expired or were deleted
+func (r *RedisStorage) getTasks(ctx context.Context, ids []string) ([]*task.Task, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf("task:%s", id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, unavailable("failed to get tasks", err)
+	}
+
+	tasks := make([]*task.Task, 0, len(values))
+	for _, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		t, err := task.FromJSON([]byte(data))
+		if err != nil {
+			continue // Skip tasks that can't be decoded
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+func (m *MemoryStorage) GetTasks(ctx context.Context, ids []string) ([]*task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tasks := make([]*task.Task, 0, len(ids))
+	for _, id := range ids {
+		if t, ok := m.tasks[id]; ok {
+			tasks = append(tasks, cloneTask(t))
+		}
+	}
+	return tasks, nil
+}
+
+// ScanTasks returns the matching tasks in ID order, the cursor being the
+// last ID returned
+func (m *MemoryStorage) ScanTasks(ctx context.Context, filter TaskFilter, cursor string, limit int) ([]*task.Task, string, error) {
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for id, t := range m.tasks {
+		if id > cursor && filter.Matches(t) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	next := ""
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+	tasks := make([]*task.Task, len(ids))
+	for i, id := range ids {
+		tasks[i] = cloneTask(m.tasks[id])
+	}
+	return tasks, next, nil
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// MaxStatusShards bounds the number of shards each status index is split
+// into
+const MaxStatusShards = 256
+
+// shardsKey records the shard count the status indexes were written with
+const shardsKey = "tasks:status:shards"
+
+// statusShard returns the shard of a status index id belongs in. Tasks are
+// spread by a hash of their ID, so each shard takes an even share of
+// submissions whatever their type.
+func (r *RedisStorage) statusShard(id string) int {
+	if r.shards <= 1 {
+		return 0
+	}
+	return int(crc32.ChecksumIEEE([]byte(id)) % uint32(r.shards))
+}
+
+// statusKey returns the status index shard holding id
+func (r *RedisStorage) statusKey(status task.Status, id string) string {
+	return shardKey(status, r.statusShard(id), r.shards)
+}
+
+// statusKeys returns every shard of the status index
+func (r *RedisStorage) statusKeys(status task.Status) []string {
+	return shardKeys(status, r.shards)
+}
+
+// shardKey names shard of a status index split into shards. An unsharded
+// index keeps the key it had before sharding.
+func shardKey(status task.Status, shard, shards int) string {
+	if shards <= 1 {
+		return fmt.Sprintf("tasks:status:%s", status)
+	}
+	return fmt.Sprintf("tasks:status:%s:%d", status, shard)
+}
+
+func shardKeys(status task.Status, shards int) []string {
+	if shards < 1 {
+		shards = 1
+	}
+	keys := make([]string, shards)
+	for i := range keys {
+		keys[i] = shardKey(status, i, shards)
+	}
+	return keys
+}
+
+// SetStatusShards splits each status index into n sorted sets, so one hot
+// key does not take every submission and poll once the backlog runs to
+// millions of tasks. Reads fan in across the shards. Zero keeps the count
+// the indexes were last written with, so only one process need set it.
+// If the indexes were written with another count, their entries are moved
+// to the new shards first; do that with the other processes stopped, as
+// tasks they index meanwhile can land in the old shards. Status counters
+// missing from Redis are then seeded from the index sizes.
+func (r *RedisStorage) SetStatusShards(ctx context.Context, n int) error {
+	if n < 0 || n > MaxStatusShards {
+		return fmt.Errorf("status shards must be between 0 and %d", MaxStatusShards)
+	}
+	current := 1
+	stored, err := r.client.Get(ctx, shardsKey).Result()
+	if err != nil && err != redis.Nil {
+		return unavailable("failed to read status shards", err)
+	}
+	if stored != "" {
+		if current, err = strconv.Atoi(stored); err != nil || current < 1 {
+			return fmt.Errorf("invalid status shard count %q stored in %s", stored, shardsKey)
+		}
+	}
+
+	if n == 0 {
+		r.shards = current
+		return r.seedCounts(ctx)
+	}
+	r.shards = n
+	if current != n {
+		if err := r.reshard(ctx, current); err != nil {
+			r.shards = current
+			return err
+		}
+	}
+	if err := r.client.Set(ctx, shardsKey, n, 0).Err(); err != nil {
+		return unavailable("failed to record status shards", err)
+	}
+	return r.seedCounts(ctx)
+}
+
+// reshard moves every status index entry from the shards of a count of
+// from to the current ones
+func (r *RedisStorage) reshard(ctx context.Context, from int) error {
+	for _, status := range task.Statuses {
+		for _, key := range shardKeys(status, from) {
+			var cursor uint64
+			for {
+				members, next, err := r.client.ZScan(ctx, key, cursor, "", 1000).Result()
+				if err != nil {
+					return unavailable("failed to reshard status index", err)
+				}
+				pipe := r.client.Pipeline()
+				// ZSCAN returns members and scores alternately
+				for i := 0; i+1 < len(members); i += 2 {
+					score, err := strconv.ParseFloat(members[i+1], 64)
+					if err != nil {
+						continue
+					}
+					target := r.statusKey(status, members[i])
+					if target == key {
+						continue
+					}
+					pipe.ZAdd(ctx, target, &redis.Z{Score: score, Member: members[i]})
+					pipe.ZRem(ctx, key, members[i])
+				}
+				if _, err := pipe.Exec(ctx); err != nil {
+					return unavailable("failed to reshard status index", err)
+				}
+				if cursor = next; cursor == 0 {
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ShardReader is implemented by storage whose status indexes are split
+// into shards, so each poller can read the shards it owns
+type ShardReader interface {
+	// StatusShards returns how many shards each status index has
+	StatusShards() int
+	// GetTasksByShards is GetTasksByStatus over only the given shards
+	GetTasksByShards(ctx context.Context, status task.Status, shards []int, limit int) ([]*task.Task, error)
+}
+
+func (r *RedisStorage) StatusShards() int {
+	return r.shards
+}
+
+func (r *RedisStorage) GetTasksByShards(ctx context.Context, status task.Status, shards []int, limit int) ([]*task.Task, error) {
+	var keys []string
+	for _, shard := range shards {
+		if shard >= 0 && shard < r.shards {
+			keys = append(keys, shardKey(status, shard, r.shards))
+		}
+	}
+	ids, err := r.topOfShards(ctx, keys, limit, func(pipe redis.Pipeliner, key string) *redis.ZSliceCmd {
+		return pipe.ZRevRangeWithScores(ctx, key, 0, int64(limit-1))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.getTasks(ctx, ids)
+}
+
+// topOfShards runs query against each of keys, shards of a status index,
+// and merges the results in index order, highest score first, keeping
+// limit
+func (r *RedisStorage) topOfShards(ctx context.Context, keys []string, limit int, query func(pipe redis.Pipeliner, key string) *redis.ZSliceCmd) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var cmds []*redis.ZSliceCmd
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		cmds = append(cmds, query(pipe, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, unavailable("failed to get task IDs", err)
+	}
+
+	var merged []redis.Z
+	for _, cmd := range cmds {
+		merged = append(merged, cmd.Val()...)
+	}
+	if len(keys) > 1 {
+		// Within a shard, Redis orders equal scores by member, highest
+		// first for reverse ranges; keep that across shards
+		sort.Slice(merged, func(i, j int) bool {
+			if merged[i].Score != merged[j].Score {
+				return merged[i].Score > merged[j].Score
+			}
+			return merged[i].Member.(string) > merged[j].Member.(string)
+		})
+	}
+	if limit >= 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	ids := make([]string, len(merged))
+	for i, z := range merged {
+		ids[i] = z.Member.(string)
+	}
+	return ids, nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// statsRetention is how long per-minute stats buckets are kept
+const statsRetention = 25 * time.Hour
+
+// MinuteStats holds the task counters recorded during one minute
+type MinuteStats struct {
+	Minute    time.Time `json:"minute"`
+	Started   int64     `json:"started"`
+	Completed int64     `json:"completed"`
+	Failed    int64     `json:"failed"`
+	// Submitted counts new tasks saved, Retried tasks failing into the
+	// retrying status
+	Submitted int64 `json:"submitted"`
+	Retried   int64 `json:"retried"`
+	// Poisoned counts tasks failed as poison; see task.PoisonLabel
+	Poisoned int64 `json:"poisoned"`
+	// WaitMs is the total time tasks started this minute spent queued
+	WaitMs int64 `json:"wait_ms"`
+	// Latency counts tasks finished this minute by run time, one entry per
+	// LatencyBuckets bound plus a final overflow entry
+	Latency []int64 `json:"latency,omitempty"`
+}
+
+// LatencyBuckets are the upper bounds of the run time histogram kept per minute
+var LatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// latencyField is the counter name for histogram bucket i
+func latencyField(i int) string {
+	return "lat:" + strconv.Itoa(i)
+}
+
+// add applies counter increments keyed by field name
+func (m *MinuteStats) add(counters map[string]int64) {
+	m.Started += counters["started"]
+	m.Completed += counters["completed"]
+	m.Failed += counters["failed"]
+	m.Submitted += counters["submitted"]
+	m.Retried += counters["retried"]
+	m.Poisoned += counters["poisoned"]
+	m.WaitMs += counters["wait_ms"]
+
+	for field, n := range counters {
+		i, err := strconv.Atoi(strings.TrimPrefix(field, "lat:"))
+		if !strings.HasPrefix(field, "lat:") || err != nil || i < 0 || i > len(LatencyBuckets) {
+			continue
+		}
+		if m.Latency == nil {
+			m.Latency = make([]int64, len(LatencyBuckets)+1)
+		}
+		m.Latency[i] += n
+	}
+}
+
+// Merge adds other's counters to m, keeping m's minute
+func (m *MinuteStats) Merge(other MinuteStats) {
+	m.Started += other.Started
+	m.Completed += other.Completed
+	m.Failed += other.Failed
+	m.Submitted += other.Submitted
+	m.Retried += other.Retried
+	m.Poisoned += other.Poisoned
+	m.WaitMs += other.WaitMs
+	if other.Latency != nil {
+		if m.Latency == nil {
+			m.Latency = make([]int64, len(LatencyBuckets)+1)
+		}
+		for i, n := range other.Latency {
+			m.Latency[i] += n
+		}
+	}
+}
+
+// LatencyPercentile estimates the p-th percentile (0-1) of a latency
+// histogram as the upper bound of the bucket holding that rank. Overflowing
+// tasks report the largest bound. It returns 0 for an empty histogram.
+func LatencyPercentile(counts []int64, p float64) time.Duration {
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range counts {
+		seen += n
+		if seen >= rank {
+			if i >= len(LatencyBuckets) {
+				break
+			}
+			return LatencyBuckets[i]
+		}
+	}
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+// latencyBucket returns the histogram bucket a run time falls into
+func latencyBucket(d time.Duration) int {
+	for i, bound := range LatencyBuckets {
+		if d <= bound {
+			return i
+		}
+	}
+	return len(LatencyBuckets)
+}
+
+// transitionCounters returns the minute a status change happened in and the
+// counters it adds to. Only a task's first start counts towards wait time;
+// retries are started again but were not waiting in the queue since creation.
+func transitionCounters(from task.Status, t *task.Task) (time.Time, map[string]int64) {
+	at := time.Now()
+	switch t.Status {
+	case task.StatusProcessing:
+		if from != task.StatusPending || t.StartedAt == nil {
+			return at, nil
+		}
+		wait := t.StartedAt.Sub(t.CreatedAt)
+		if wait < 0 {
+			wait = 0
+		}
+		return *t.StartedAt, map[string]int64{"started": 1, "wait_ms": wait.Milliseconds()}
+	case task.StatusCompleted, task.StatusFailed:
+		if t.CompletedAt != nil {
+			at = *t.CompletedAt
+		}
+		counters := map[string]int64{string(t.Status): 1}
+		if t.StartedAt != nil {
+			counters[latencyField(latencyBucket(at.Sub(*t.StartedAt)))] = 1
+		}
+		if t.Labels[task.PoisonLabel] == "true" {
+			counters["poisoned"] = 1
+		}
+		return at, counters
+	case task.StatusRetrying:
+		return at, map[string]int64{"retried": 1}
+	}
+	return at, nil
+}
+
+// minuteKey is the Redis hash holding the stats for the minute containing t
+func minuteKey(t time.Time) string {
+	return fmt.Sprintf("stats:minute:%d", t.Truncate(time.Minute).Unix())
+}
+
+// typeCountKey is the Redis hash counting tasks with a status by type
+func typeCountKey(status task.Status) string {
+	return fmt.Sprintf("tasks:types:%s", status)
+}
+
+// recordTransition adds a status change to its per-minute stats bucket,
+// on pipe
+func (r *RedisStorage) recordTransition(ctx context.Context, pipe redis.Pipeliner, from task.Status, t *task.Task) {
+	at, counters := transitionCounters(from, t)
+	if counters == nil {
+		return
+	}
+	key := minuteKey(at)
+	for field, n := range counters {
+		pipe.HIncrBy(ctx, key, field, n)
+	}
+	pipe.Expire(ctx, key, statsRetention)
+}
+
+// CountTasksByType returns the number of tasks with a status, by task type
+func (r *RedisStorage) CountTasksByType(ctx context.Context, status task.Status) (map[string]int64, error) {
+	fields, err := r.client.HGetAll(ctx, typeCountKey(status)).Result()
+	if err != nil {
+		return nil, unavailable("failed to count task types", err)
+	}
+
+	counts := make(map[string]int64, len(fields))
+	for taskType, value := range fields {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		counts[taskType] = n
+	}
+	return counts, nil
+}
+
+// CountTasksByPriority returns the number of tasks with a status, by
+// priority. Priorities without tasks are left out.
+func (r *RedisStorage) CountTasksByPriority(ctx context.Context, status task.Status) (map[task.Priority]int64, error) {
+	pipe := r.client.Pipeline()
+	cmds := make(map[task.Priority][]*redis.IntCmd)
+	for _, key := range r.statusKeys(status) {
+		for p := task.PriorityMin; p <= task.PriorityMax; p++ {
+			min, max := priorityRange(p)
+			cmds[p] = append(cmds[p], pipe.ZCount(ctx, key, min, max))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, unavailable("failed to count task priorities", err)
+	}
+
+	counts := make(map[task.Priority]int64)
+	for p, shards := range cmds {
+		var n int64
+		for _, cmd := range shards {
+			n += cmd.Val()
+		}
+		if n > 0 {
+			counts[p] = n
+		}
+	}
+	return counts, nil
+}
+
+// GetMinuteStats returns one bucket for every minute from from to to
+func (r *RedisStorage) GetMinuteStats(ctx context.Context, from, to time.Time) ([]MinuteStats, error) {
+	from, to = from.Truncate(time.Minute), to.Truncate(time.Minute)
+
+	pipe := r.client.Pipeline()
+	var cmds []*redis.StringStringMapCmd
+	for minute := from; !minute.After(to); minute = minute.Add(time.Minute) {
+		cmds = append(cmds, pipe.HGetAll(ctx, minuteKey(minute)))
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, unavailable("failed to get stats", err)
+	}
+
+	buckets := make([]MinuteStats, len(cmds))
+	for i, cmd := range cmds {
+		buckets[i].Minute = from.Add(time.Duration(i) * time.Minute)
+		counters := make(map[string]int64)
+		for field, value := range cmd.Val() {
+			counters[field], _ = strconv.ParseInt(value, 10, 64)
+		}
+		buckets[i].add(counters)
+	}
+	return buckets, nil
+}
+
+func (m *MemoryStorage) CountTasksByPriority(ctx context.Context, status task.Status) (map[task.Priority]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[task.Priority]int64)
+	for _, t := range m.tasks {
+		if t.Status == status {
+			counts[t.Priority]++
+		}
+	}
+	return counts, nil
+}
+
+// count adds counters to the stats bucket for the minute of at. Must be
+// called with m.mu held.
+func (m *MemoryStorage) count(at time.Time, counters map[string]int64) {
+	minute := at.Truncate(time.Minute)
+	bucket, ok := m.minutes[minute.Unix()]
+	if !ok {
+		bucket = &MinuteStats{Minute: minute}
+		m.minutes[minute.Unix()] = bucket
+	}
+	bucket.add(counters)
+}
+
+func (m *MemoryStorage) GetMinuteStats(ctx context.Context, from, to time.Time) ([]MinuteStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var buckets []MinuteStats
+	for minute := from.Truncate(time.Minute); !minute.After(to); minute = minute.Add(time.Minute) {
+		bucket := MinuteStats{Minute: minute}
+		if recorded, ok := m.minutes[minute.Unix()]; ok {
+			bucket = *recorded
+			bucket.Latency = append([]int64(nil), recorded.Latency...)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// UntrackedQueue is implemented by storage that can hold fire-and-forget
+// tasks. Each is pushed as one serialized entry, with no task record,
+// status index or per-minute stats, and is gone once popped.
+type UntrackedQueue interface {
+	// PushUntracked adds t behind every untracked task
+	PushUntracked(ctx context.Context, t *task.Task) error
+	// PopUntracked removes and returns up to limit of the oldest untracked
+	// tasks
+	PopUntracked(ctx context.Context, limit int) ([]*task.Task, error)
+	// CountUntracked returns how many untracked tasks are waiting
+	CountUntracked(ctx context.Context) (int64, error)
+}
+
+// untrackedKey is the Redis list of fire-and-forget tasks, pushed on the
+// left and popped from the right
+const untrackedKey = "tasks:untracked"
+
+func (r *RedisStorage) PushUntracked(ctx context.Context, t *task.Task) error {
+	data, err := t.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize task: %w", err)
+	}
+	if err := r.client.LPush(ctx, untrackedKey, data).Err(); err != nil {
+		return unavailable("failed to push untracked task", err)
+	}
+	return nil
+}
+
+func (r *RedisStorage) PopUntracked(ctx context.Context, limit int) ([]*task.Task, error) {
+	values, err := r.client.RPopCount(ctx, untrackedKey, limit).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, unavailable("failed to pop untracked tasks", err)
+	}
+	tasks := make([]*task.Task, 0, len(values))
+	for _, data := range values {
+		var t task.Task
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			// Popped already, so there is nothing to put back
+			continue
+		}
+		tasks = append(tasks, &t)
+	}
+	return tasks, nil
+}
+
+func (r *RedisStorage) CountUntracked(ctx context.Context) (int64, error) {
+	n, err := r.client.LLen(ctx, untrackedKey).Result()
+	if err != nil {
+		return 0, unavailable("failed to count untracked tasks", err)
+	}
+	return n, nil
+}
+
+func (m *MemoryStorage) PushUntracked(ctx context.Context, t *task.Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.untracked = append(m.untracked, cloneTask(t))
+	return nil
+}
+
+func (m *MemoryStorage) PopUntracked(ctx context.Context, limit int) ([]*task.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limit > len(m.untracked) {
+		limit = len(m.untracked)
+	}
+	tasks := m.untracked[:limit:limit]
+	m.untracked = m.untracked[limit:]
+	return tasks, nil
+}
+
+func (m *MemoryStorage) CountUntracked(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.untracked)), nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+// Wrapper is implemented by storage that wraps another, such as a mirror
+// to a second cluster, so the optional interfaces of the storage it wraps
+// are still found through it with AsUntrackedQueue, AsShardReader and
+// AsIndexChecker
+type Wrapper interface {
+	// Unwrap returns the storage wrapped
+	Unwrap() Storage
+}
+
+// AsUntrackedQueue returns the UntrackedQueue of s or of the storage it
+// wraps. Through EncryptedStorage, pushed tasks are sealed and popped ones
+// opened, as their records would be.
+func AsUntrackedQueue(s Storage) (UntrackedQueue, bool) {
+	switch s := s.(type) {
+	case UntrackedQueue:
+		return s, true
+	case *EncryptedStorage:
+		u, ok := AsUntrackedQueue(s.Storage)
+		if !ok {
+			return nil, false
+		}
+		return &encryptedUntracked{UntrackedQueue: u, e: s}, true
+	case Wrapper:
+		return AsUntrackedQueue(s.Unwrap())
+	}
+	return nil, false
+}
+
+// AsShardReader returns the ShardReader of s or of the storage it wraps.
+// Through EncryptedStorage, the tasks read are opened.
+func AsShardReader(s Storage) (ShardReader, bool) {
+	switch s := s.(type) {
+	case ShardReader:
+		return s, true
+	case *EncryptedStorage:
+		r, ok := AsShardReader(s.Storage)
+		if !ok {
+			return nil, false
+		}
+		return &encryptedShards{ShardReader: r, e: s}, true
+	case Wrapper:
+		return AsShardReader(s.Unwrap())
+	}
+	return nil, false
+}
+
+// AsIndexChecker returns the IndexChecker of s or of the storage it wraps
+func AsIndexChecker(s Storage) (IndexChecker, bool) {
+	switch s := s.(type) {
+	case IndexChecker:
+		return s, true
+	case Wrapper:
+		return AsIndexChecker(s.Unwrap())
+	}
+	return nil, false
+}
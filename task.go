@@ -2,21 +2,134 @@ package task
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
-// Priority represents task priority levels
+// Priority orders tasks from PriorityMin, the least urgent, to
+// PriorityMax. Tasks of higher priority run first; within one priority,
+// newer tasks run first.
 type Priority int
 
 const (
-	PriorityLow Priority = iota
-	PriorityMedium
-	PriorityHigh
-	PriorityCritical
+	PriorityMin Priority = 0
+	PriorityMax Priority = 100
+)
+
+// Named priority presets. They were once the only priorities, and still
+// divide the range into the classes the dispatcher buffers separately.
+const (
+	PriorityLow      Priority = 0
+	PriorityMedium   Priority = 33
+	PriorityHigh     Priority = 66
+	PriorityCritical Priority = 100
 )
 
+// legacyPriorities holds the presets by the numbers they had, 0 to 3,
+// before priorities ranged up to PriorityMax
+var legacyPriorities = [...]Priority{PriorityLow, PriorityMedium, PriorityHigh, PriorityCritical}
+
+// Upgrade returns the preset p stood for when it is a number of the old
+// four-level scale, and p otherwise. Priorities read from JSON or by
+// ParsePriority are upgraded, so API clients, configuration and tasks
+// stored from before the range keep their meaning; priorities between
+// low and medium therefore start at 4.
+func (p Priority) Upgrade() Priority {
+	if p >= 0 && int(p) < len(legacyPriorities) {
+		return legacyPriorities[p]
+	}
+	return p
+}
+
+// priorityNames maps preset names to their priorities
+var priorityNames = map[string]Priority{
+	"low":      PriorityLow,
+	"medium":   PriorityMedium,
+	"high":     PriorityHigh,
+	"critical": PriorityCritical,
+}
+
+// Classes lists the priority presets from most to least urgent
+var Classes = []Priority{PriorityCritical, PriorityHigh, PriorityMedium, PriorityLow}
+
+// ErrInvalidPriority is returned for priorities that are neither a number
+// nor a preset name
+var ErrInvalidPriority = errors.New("invalid priority")
+
+// ParsePriority parses a preset name or a number between PriorityMin and
+// PriorityMax, upgrading numbers of the old four-level scale
+func ParsePriority(s string) (Priority, error) {
+	if p, ok := priorityNames[strings.ToLower(s)]; ok {
+		return p, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || !Priority(n).Valid() {
+		return 0, fmt.Errorf("%w %q: want %d-%d or low, medium, high or critical", ErrInvalidPriority, s, PriorityMin, PriorityMax)
+	}
+	return Priority(n).Upgrade(), nil
+}
+
+// Valid reports whether p is within PriorityMin and PriorityMax
+func (p Priority) Valid() bool {
+	return p >= PriorityMin && p <= PriorityMax
+}
+
+// Class returns the highest preset at or below p
+func (p Priority) Class() Priority {
+	for _, class := range Classes {
+		if p >= class {
+			return class
+		}
+	}
+	return PriorityLow
+}
+
+// UnmarshalJSON accepts a number or a preset name. Numbers of the old
+// four-level scale are upgraded; others are not range checked here, so
+// stored tasks always load; validate submissions with Valid.
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	// Stored tasks hold numbers, so look for one first rather than build
+	// an error decoding it as a name
+	if len(data) > 0 && (data[0] == '-' || '0' <= data[0] && data[0] <= '9') {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("%w %s: want a number or a preset name", ErrInvalidPriority, data)
+		}
+		*p = Priority(n).Upgrade()
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("%w %s: want a number or a preset name", ErrInvalidPriority, data)
+	}
+	named, ok := priorityNames[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("%w %q: want a number or low, medium, high or critical", ErrInvalidPriority, name)
+	}
+	*p = named
+	return nil
+}
+
+// priorityLabels holds each valid priority in decimal
+var priorityLabels = func() (labels [PriorityMax + 1]string) {
+	for p := range labels {
+		labels[p] = strconv.Itoa(p)
+	}
+	return labels
+}()
+
+// Label returns p in decimal, as metrics label it, without formatting it
+// anew for every task
+func (p Priority) Label() string {
+	if p.Valid() {
+		return priorityLabels[p]
+	}
+	return strconv.Itoa(int(p))
+}
+
 // Status represents the current state of a task
 type Status string
 
@@ -26,28 +139,160 @@ const (
 	StatusCompleted  Status = "completed"
 	StatusFailed     Status = "failed"
 	StatusRetrying   Status = "retrying"
+	StatusScheduled  Status = "scheduled"
+	StatusExpired    Status = "expired"
+	StatusCancelled  Status = "cancelled"
 )
 
+// Statuses lists every known status
+var Statuses = []Status{
+	StatusPending,
+	StatusProcessing,
+	StatusCompleted,
+	StatusFailed,
+	StatusRetrying,
+	StatusScheduled,
+	StatusExpired,
+	StatusCancelled,
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	for _, known := range Statuses {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
+
+// transitions lists the statuses each status may move to
+var transitions = map[Status][]Status{
+	StatusPending:    {StatusProcessing, StatusScheduled, StatusExpired, StatusFailed, StatusCancelled},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusRetrying, StatusScheduled, StatusExpired, StatusCancelled},
+	StatusRetrying:   {StatusProcessing, StatusScheduled, StatusExpired, StatusCancelled},
+	StatusScheduled:  {StatusPending, StatusExpired, StatusCancelled},
+}
+
+// Terminal reports whether a task in status s is finished for good
+func (s Status) Terminal() bool {
+	return len(transitions[s]) == 0
+}
+
+// CanTransitionTo reports whether a task may move from status s to next.
+// Staying in the same status is allowed, for updates to other fields,
+// except in processing: a running task cannot be started again. See
+// CanReplace for updates made by the attempt running it.
+func (s Status) CanTransitionTo(next Status) bool {
+	if s == next {
+		return s != StatusProcessing
+	}
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReplace reports whether t may be stored over stored, the same task
+// as it is in storage. A running task may only be updated by the attempt
+// that started it, so of two deliveries of one task only the first to
+// start it runs it.
+func (t *Task) CanReplace(stored *Task) bool {
+	if stored.Status == StatusProcessing && t.Status == StatusProcessing {
+		return t.WorkerID == stored.WorkerID && t.StartedAt != nil && stored.StartedAt != nil &&
+			t.StartedAt.Equal(*stored.StartedAt)
+	}
+	return stored.Status.CanTransitionTo(t.Status)
+}
+
 // Task represents a unit of work to be executed
 type Task struct {
-	ID          string                 `json:"id"`
-	Type        string                 `json:"type"`
-	Priority    Priority               `json:"priority"`
-	Status      Status                 `json:"status"`
-	Payload     map[string]interface{} `json:"payload"`
-	MaxRetries  int                    `json:"max_retries"`
-	RetryCount  int                    `json:"retry_count"`
-	CreatedAt   time.Time              `json:"created_at"`
-	StartedAt   *time.Time             `json:"started_at,omitempty"`
-	CompletedAt *time.Time             `json:"completed_at,omitempty"`
-	Error       string                 `json:"error,omitempty"`
-	WorkerID    string                 `json:"worker_id,omitempty"`
+	ID            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	Priority      Priority               `json:"priority"`
+	Status        Status                 `json:"status"`
+	Payload       map[string]interface{} `json:"payload"`
+	Version       int                    `json:"version,omitempty"`
+	Labels        map[string]string      `json:"labels,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	ParentID      string                 `json:"parent_id,omitempty"`
+	ScheduledAt   *time.Time             `json:"scheduled_at,omitempty"`
+	Deadline      *time.Time             `json:"deadline,omitempty"`
+	MaxRetries    int                    `json:"max_retries"`
+	RetryCount    int                    `json:"retry_count"`
+	CreatedAt     time.Time              `json:"created_at"`
+	StartedAt     *time.Time             `json:"started_at,omitempty"`
+	CompletedAt   *time.Time             `json:"completed_at,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	ErrorCategory ErrorCategory          `json:"error_category,omitempty"`
+	WorkerID      string                 `json:"worker_id,omitempty"`
+	// Source is who submitted the task: "key:" and a fingerprint of their
+	// API key, "service:" and the name they gave in X-Service-Name, "ip:"
+	// and their address, or "caller:" and the function that called Submit
+	Source string `json:"source,omitempty"`
+	// Instance is the process the latest attempt ran in, if its queue was
+	// given an instance ID
+	Instance string `json:"instance,omitempty"`
+	// Strikes counts the attempts in a row whose handler panicked or
+	// timed out; see PoisonLabel
+	Strikes int `json:"strikes,omitempty"`
+	// BoostedAt is when the task was moved to the front of the queue
+	BoostedAt *time.Time `json:"boosted_at,omitempty"`
+	// Untracked tasks were submitted fire-and-forget and have no record
+	// in storage
+	Untracked bool `json:"untracked,omitempty"`
+	// FairnessKey, such as a customer ID, groups tasks that workers take
+	// turns with other keys' tasks of the same priority class, when the
+	// queue has a fairness window
+	FairnessKey string `json:"fairness_key,omitempty"`
+	// Requires lists resource tags, such as gpu=true, that a worker must
+	// advertise to run the task
+	Requires map[string]string `json:"requires,omitempty"`
+	// Weight is how heavy the task is, overriding its type's weight, for
+	// the worker's weight budget
+	Weight int `json:"weight,omitempty"`
+
+	// Notify, if set, tells the producer when the task finishes
+	Notify *Notification `json:"notify,omitempty"`
+
+	// Attempts lists the task's runs, oldest first, up to the latest 50.
+	// StartedAt and WorkerID describe the latest.
+	Attempts []AttemptRecord `json:"attempts,omitempty"`
+
+	// Result is the handler's output, saved with the task's outcome
+	Result map[string]interface{} `json:"result,omitempty"`
+
+	// Artifacts lists the files the handler attached, which are kept in
+	// an artifact store rather than with the task
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// LastCheckpoint is the progress the handler saved with
+	// SaveCheckpoint, kept across attempts
+	LastCheckpoint *Checkpoint `json:"checkpoint,omitempty"`
+
+	// LeaseExpiresAt is when the running attempt's lease, last extended by
+	// its handler with ExtendLease, runs out. Each attempt starts without
+	// one.
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+
+	// EncryptedPayload replaces Payload while the task is encrypted at rest
+	EncryptedPayload string `json:"encrypted_payload,omitempty"`
+
+	// Signature is an HMAC over the ID, type and payload set by a trusted
+	// producer, so workers can check where the task came from
+	Signature string `json:"signature,omitempty"`
 }
 
+// PoisonLabel marks, with the value "true", a task quarantined because its
+// attempts kept panicking or timing out
+const PoisonLabel = "poison"
+
 // NewTask creates a new task with default values
 func NewTask(taskType string, priority Priority, payload map[string]interface{}) *Task {
 	return &Task{
-		ID:         uuid.New().String(),
+		ID:         newID(),
 		Type:       taskType,
 		Priority:   priority,
 		Status:     StatusPending,
@@ -58,6 +303,16 @@ func NewTask(taskType string, priority Priority, payload map[string]interface{})
 	}
 }
 
+// MatchesLabels reports whether the task carries every label in selector
+func (t *Task) MatchesLabels(selector map[string]string) bool {
+	for k, v := range selector {
+		if t.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 // ToJSON serializes the task to JSON
 func (t *Task) ToJSON() ([]byte, error) {
 	return json.Marshal(t)
@@ -80,33 +335,159 @@ func (t *Task) CanRetry() bool {
 
 // MarkStarted marks a task as started
 func (t *Task) MarkStarted(workerID string) {
-	now := time.Now()
+	t.MarkStartedAt(workerID, time.Now())
+}
+
+// MarkStartedAt marks a task as started at now, by the caller's clock
+func (t *Task) MarkStartedAt(workerID string, now time.Time) {
 	t.Status = StatusProcessing
 	t.StartedAt = &now
 	t.WorkerID = workerID
+	t.LeaseExpiresAt = nil
+	t.startAttempt(workerID, now)
+}
+
+// MarkScheduled holds a task back until at
+func (t *Task) MarkScheduled(at time.Time) {
+	t.Status = StatusScheduled
+	t.ScheduledAt = &at
+}
+
+// Overdue reports whether the task has a deadline that passed before now
+func (t *Task) Overdue(now time.Time) bool {
+	return t.Deadline != nil && now.After(*t.Deadline)
+}
+
+// MarkExpired marks a task that missed its deadline
+func (t *Task) MarkExpired() {
+	t.MarkExpiredAt(time.Now())
+}
+
+// MarkExpiredAt marks a task that missed its deadline, found at now
+func (t *Task) MarkExpiredAt(now time.Time) {
+	t.Status = StatusExpired
+	t.CompletedAt = &now
+	if t.Error == "" {
+		t.Error = "deadline exceeded"
+	}
+	t.endAttempt(string(StatusExpired), now)
+}
+
+// MarkCancelled marks a task as cancelled
+func (t *Task) MarkCancelled() {
+	t.MarkCancelledAt(time.Now())
+}
+
+// MarkCancelledAt marks a task as cancelled at now
+func (t *Task) MarkCancelledAt(now time.Time) {
+	t.Status = StatusCancelled
+	t.CompletedAt = &now
+	t.endAttempt(string(StatusCancelled), now)
 }
 
 // MarkCompleted marks a task as completed
 func (t *Task) MarkCompleted() {
-	now := time.Now()
+	t.MarkCompletedAt(time.Now())
+}
+
+// MarkCompletedAt marks a task as completed at now
+func (t *Task) MarkCompletedAt(now time.Time) {
 	t.Status = StatusCompleted
 	t.CompletedAt = &now
+	t.endAttempt(string(StatusCompleted), now)
 }
 
 // MarkFailed marks a task as failed
 func (t *Task) MarkFailed(err error) {
+	t.MarkFailedAt(err, time.Now())
+}
+
+// MarkFailedAt marks a task as failed at now
+func (t *Task) MarkFailedAt(err error, now time.Time) {
 	t.Status = StatusFailed
 	t.Error = err.Error()
-	now := time.Now()
 	t.CompletedAt = &now
+	t.endAttempt(string(StatusFailed), now)
 }
 
 // MarkRetrying marks a task for retry
 func (t *Task) MarkRetrying() {
+	t.MarkRetryingAt(time.Now())
+}
+
+// MarkRetryingAt marks a task for retry, its attempt having ended at now
+func (t *Task) MarkRetryingAt(now time.Time) {
 	t.Status = StatusRetrying
+	t.endAttempt(string(StatusRetrying), now)
 	t.RetryCount++
 }
 
+// RecordError keeps the error of a failed attempt, for LastError
+func (t *Task) RecordError(err error) {
+	t.Error = err.Error()
+}
+
+// Attempt returns the number of the current or most recent run, starting at 1
+func (t *Task) Attempt() int {
+	return t.RetryCount + 1
+}
+
+// LastError returns the error from the most recent failed attempt, or nil
+// if no attempt has failed yet
+func (t *Task) LastError() error {
+	if t.Error == "" {
+		return nil
+	}
+	return errors.New(t.Error)
+}
+
+// SetResult stores v, which must encode to a JSON object, as the task's
+// result
+func (t *Task) SetResult(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("result must be a JSON object: %w", err)
+	}
+	t.Result = result
+	return nil
+}
+
+// Artifact is a file attached to a task
+type Artifact struct {
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AddArtifact records a, replacing any artifact with the same name
+func (t *Task) AddArtifact(a Artifact) {
+	for i := range t.Artifacts {
+		if t.Artifacts[i].Name == a.Name {
+			t.Artifacts[i] = a
+			return
+		}
+	}
+	t.Artifacts = append(t.Artifacts, a)
+}
+
+// DecodeResult decodes the task's result into v
+func (t *Task) DecodeResult(v interface{}) error {
+	data, err := json.Marshal(t.Result)
+	if err != nil {
+		return fmt.Errorf("failed to decode result: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode result: %w", err)
+	}
+	return nil
+}
+
 // Result represents the result of task execution
 type Result struct {
 	TaskID    string                 `json:"task_id"`
@@ -0,0 +1,70 @@
+package task
+
+import "time"
+
+// maxAttemptRecords caps the attempts kept on a task, dropping the oldest
+const maxAttemptRecords = 50
+
+// OutcomeLost is the outcome of an attempt whose worker went away before
+// it finished, such as a task restored from a backup while it was running
+const OutcomeLost = "lost"
+
+// OutcomeRescheduled is the outcome of an attempt whose handler asked to
+// run later with Reschedule. No retry was used, so the next attempt keeps
+// its number.
+const OutcomeRescheduled = "rescheduled"
+
+// AttemptRecord describes one run of a task
+type AttemptRecord struct {
+	// Number counts attempts from 1, as Attempt does
+	Number    int        `json:"number"`
+	WorkerID  string     `json:"worker_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	// Outcome is the status the attempt left the task in, such as
+	// completed or retrying, or OutcomeLost. It is empty while the attempt
+	// is running.
+	Outcome       string        `json:"outcome,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	ErrorCategory ErrorCategory `json:"error_category,omitempty"`
+}
+
+// startAttempt records a new attempt by workerID starting at now
+func (t *Task) startAttempt(workerID string, now time.Time) {
+	t.Attempts = append(t.Attempts, AttemptRecord{
+		Number:    t.Attempt(),
+		WorkerID:  workerID,
+		StartedAt: now,
+	})
+	if n := len(t.Attempts); n > maxAttemptRecords {
+		t.Attempts = append([]AttemptRecord(nil), t.Attempts[n-maxAttemptRecords:]...)
+	}
+}
+
+// endAttempt closes the running attempt, if any, with outcome at now
+func (t *Task) endAttempt(outcome string, now time.Time) {
+	n := len(t.Attempts)
+	if n == 0 || t.Attempts[n-1].EndedAt != nil {
+		return
+	}
+	a := &t.Attempts[n-1]
+	a.EndedAt = &now
+	a.Outcome = outcome
+	if outcome != string(StatusCompleted) && outcome != OutcomeRescheduled {
+		a.Error = t.Error
+		a.ErrorCategory = t.ErrorCategory
+	}
+}
+
+// MarkRescheduledAt closes the running attempt at now as
+// OutcomeRescheduled, leaving the retry count as it is. Schedule the task
+// for when it asked to run again afterwards.
+func (t *Task) MarkRescheduledAt(now time.Time) {
+	t.endAttempt(OutcomeRescheduled, now)
+}
+
+// AbandonAttempt closes the running attempt as OutcomeLost, for a task
+// taken back from a worker that will not finish it
+func (t *Task) AbandonAttempt() {
+	t.endAttempt(OutcomeLost, time.Now())
+}
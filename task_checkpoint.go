@@ -0,0 +1,72 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxCheckpointSize bounds the encoded checkpoint a handler may save, as
+// it is stored with the task
+const MaxCheckpointSize = 256 << 10
+
+// ErrCheckpointTooLarge is returned by SaveCheckpoint for checkpoints over
+// MaxCheckpointSize
+var ErrCheckpointTooLarge = errors.New("checkpoint too large")
+
+// Checkpoint is the progress a long-running handler saved, for a retry to
+// resume from instead of starting over
+type Checkpoint struct {
+	Data json.RawMessage `json:"data,omitempty"`
+	// EncryptedData replaces Data while the task is encrypted at rest
+	EncryptedData string `json:"encrypted_data,omitempty"`
+	// Attempt is the attempt that saved it
+	Attempt int       `json:"attempt"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// SaveCheckpoint records v, encoded as JSON, as the task's progress and,
+// inside a handler run by the queue, stores it with the task at once, so
+// an attempt retried after a crash or timeout can pick up from it with
+// Checkpoint. Outside the queue it is only kept on t.
+func (t *Task) SaveCheckpoint(ctx context.Context, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if len(data) > MaxCheckpointSize {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrCheckpointTooLarge, len(data), MaxCheckpointSize)
+	}
+
+	previous := t.LastCheckpoint
+	t.LastCheckpoint = &Checkpoint{Data: data, Attempt: t.Attempt(), SavedAt: now(ctx).UTC()}
+	if err := save(ctx, t); err != nil {
+		t.LastCheckpoint = previous
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Checkpoint returns the JSON of the latest checkpoint saved, by this
+// attempt or an earlier one, or nil if there is none
+func (t *Task) Checkpoint() json.RawMessage {
+	if t.LastCheckpoint == nil {
+		return nil
+	}
+	return t.LastCheckpoint.Data
+}
+
+// LoadCheckpoint decodes the latest checkpoint into v, reporting whether
+// there was one
+func (t *Task) LoadCheckpoint(v interface{}) (bool, error) {
+	data := t.Checkpoint()
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	return true, nil
+}
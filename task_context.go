@@ -0,0 +1,95 @@
+package task
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+type metaKey struct{}
+
+type saverKey struct{}
+
+type clockKey struct{}
+
+// Meta describes the attempt a handler is running
+type Meta struct {
+	TaskID        string
+	Type          string
+	CorrelationID string
+	WorkerID      string
+	// Attempt is 1 for the first run and increases with each retry
+	Attempt int
+	// MaxAttempts is the number of runs allowed, including the first
+	MaxAttempts int
+	// Deadline is when the handler's context will be cancelled
+	Deadline time.Time
+}
+
+// LastAttempt reports whether a failure now will not be retried
+func (m Meta) LastAttempt() bool {
+	return m.Attempt >= m.MaxAttempts
+}
+
+// WithLogger returns a context carrying a logger for the task being processed
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the task logger set by the queue, which already
+// carries the task ID, type, attempt, worker ID and correlation ID. It
+// returns a no-op logger outside a handler.
+func LoggerFromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.NewNop()
+}
+
+// WithMeta returns a context carrying the metadata of the running attempt
+func WithMeta(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, metaKey{}, meta)
+}
+
+// MetaFromContext returns the metadata of the running attempt, if any
+func MetaFromContext(ctx context.Context) (Meta, bool) {
+	meta, ok := ctx.Value(metaKey{}).(Meta)
+	return meta, ok
+}
+
+// Saver stores a running task after its handler changed it, such as by
+// saving a checkpoint or extending its lease
+type Saver func(ctx context.Context, t *Task) error
+
+// WithSaver returns a context carrying the function that stores the
+// running task
+func WithSaver(ctx context.Context, save Saver) context.Context {
+	return context.WithValue(ctx, saverKey{}, save)
+}
+
+// save stores t with the context's Saver. Outside a handler run by the
+// queue there is none, and the change is only kept on t.
+func save(ctx context.Context, t *Task) error {
+	if save, ok := ctx.Value(saverKey{}).(Saver); ok {
+		return save(ctx, t)
+	}
+	return nil
+}
+
+// WithClock returns a context carrying the clock of the queue running the
+// task, for the times a handler's changes are stamped with
+func WithClock(ctx context.Context, now func() time.Time) context.Context {
+	return context.WithValue(ctx, clockKey{}, now)
+}
+
+// now reads the context's clock, or the system clock outside a handler run
+// by the queue
+func now(ctx context.Context) time.Time {
+	if now, ok := ctx.Value(clockKey{}).(func() time.Time); ok {
+		return now()
+	}
+	return time.Now()
+}
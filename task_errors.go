@@ -0,0 +1,128 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// permanentError is a handler error retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err, returned by a handler, as a failure retrying cannot
+// fix, such as a rejected email address, so the task fails at once
+// instead of using up its retries
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// retryAfterError is a handler error that asks for a later retry
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// RetryAfter marks err, returned by a handler, as temporary, asking for the
+// retry to wait at least delay, such as when a provider rate limits calls
+func RetryAfter(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryAfterError{err: err, delay: delay}
+}
+
+// RetryDelay returns the wait asked for with RetryAfter
+func RetryDelay(err error) (time.Duration, bool) {
+	var r *retryAfterError
+	if errors.As(err, &r) {
+		return r.delay, true
+	}
+	return 0, false
+}
+
+// rescheduleError asks for the task to run again later, without having
+// failed
+type rescheduleError struct {
+	at    time.Time
+	after time.Duration
+}
+
+func (e *rescheduleError) Error() string {
+	if !e.at.IsZero() {
+		return "rescheduled for " + e.at.UTC().Format(time.RFC3339)
+	}
+	return "rescheduled after " + e.after.String()
+}
+
+// Reschedule, returned by a handler, runs the task again once after has
+// passed, such as when a resource it needs is not ready yet. Unlike
+// RetryAfter it is not a failure: the attempt ends as OutcomeRescheduled
+// and no retry is used up. A task past its deadline by then expires.
+func Reschedule(after time.Duration) error {
+	return &rescheduleError{after: after}
+}
+
+// RescheduleAt is Reschedule for a time rather than a wait
+func RescheduleAt(at time.Time) error {
+	return &rescheduleError{at: at}
+}
+
+// RescheduleTime returns when a handler that returned err asked with
+// Reschedule or RescheduleAt to run again, counting waits from now
+func RescheduleTime(err error, now time.Time) (time.Time, bool) {
+	var r *rescheduleError
+	if !errors.As(err, &r) {
+		return time.Time{}, false
+	}
+	if !r.at.IsZero() {
+		return r.at, true
+	}
+	return now.Add(r.after), true
+}
+
+// ErrorCategory classifies why an attempt of a task failed
+type ErrorCategory string
+
+const (
+	// CategoryTimeout is an attempt that ran past its timeout
+	CategoryTimeout ErrorCategory = "timeout"
+	// CategoryPanic is an attempt whose handler panicked
+	CategoryPanic ErrorCategory = "panic"
+	// CategoryHandler is any other error returned by a handler
+	CategoryHandler ErrorCategory = "handler_error"
+	// CategoryNoHandler is a task of a type no handler is registered for
+	CategoryNoHandler ErrorCategory = "no_handler"
+	// CategoryInvalidPayload is a task whose payload it cannot run with
+	CategoryInvalidPayload ErrorCategory = "invalid_payload"
+)
+
+// ErrInvalidPayload is wrapped by the errors of tasks whose payload they
+// cannot run with, see InvalidPayload
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// InvalidPayload marks err, returned by a handler, as caused by the task's
+// payload, such as a missing field. Retrying cannot fix it, so it is also
+// Permanent.
+func InvalidPayload(err error) error {
+	if err == nil {
+		return nil
+	}
+	return Permanent(fmt.Errorf("%w: %w", ErrInvalidPayload, err))
+}
@@ -0,0 +1,92 @@
+package task
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator returns a new unique task ID
+type IDGenerator func() string
+
+// idGenerator is the generator NewTask uses
+var idGenerator atomic.Value
+
+func init() {
+	idGenerator.Store(IDGenerator(NewUUID))
+}
+
+// SetIDGenerator changes how NewTask assigns IDs. Call it at startup,
+// before tasks are created; nil restores the default random UUIDs.
+func SetIDGenerator(gen IDGenerator) {
+	if gen == nil {
+		gen = NewUUID
+	}
+	idGenerator.Store(gen)
+}
+
+// newID returns an ID from the configured generator
+func newID() string {
+	return idGenerator.Load().(IDGenerator)()
+}
+
+// ParseIDGenerator returns the generator called name: "uuid" (random,
+// the default), "uuidv7" or "ulid" (both sortable by creation time)
+func ParseIDGenerator(name string) (IDGenerator, error) {
+	switch name {
+	case "", "uuid":
+		return NewUUID, nil
+	case "uuidv7":
+		return NewUUIDv7, nil
+	case "ulid":
+		return NewULID, nil
+	default:
+		return nil, fmt.Errorf("unknown ID format %q (want uuid, uuidv7 or ulid)", name)
+	}
+}
+
+// NewUUID returns a random version 4 UUID
+func NewUUID() string {
+	return uuid.New().String()
+}
+
+// NewUUIDv7 returns a version 7 UUID, which starts with the creation time
+// in milliseconds so IDs sort in creation order
+func NewUUIDv7() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// crockford is the base32 alphabet ULIDs are written in
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: 26 characters holding a millisecond timestamp
+// followed by 80 random bits, so IDs sort in creation order
+func NewULID() string {
+	var b [16]byte
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(b[:6], ms[2:])
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(fmt.Sprintf("task: failed to read random bytes: %v", err))
+	}
+
+	// 26 characters of 5 bits cover 130 bits, so the first character
+	// only carries the top 3 bits of the 128
+	out := make([]byte, 26)
+	for i := range out {
+		var v byte
+		for j := 0; j < 5; j++ {
+			bit := i*5 - 2 + j
+			v <<= 1
+			if bit >= 0 {
+				v |= b[bit/8] >> (7 - bit%8) & 1
+			}
+		}
+		out[i] = crockford[v]
+	}
+	return string(out)
+}
@@ -0,0 +1,35 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExtendLease tells the queue the handler is still working on the task and
+// will be for at least d more, storing when the lease runs out with the
+// task. Once a handler has extended its lease, Doctor takes its worker to
+// be gone only after the lease runs out, rather than judging by the task's
+// timeout or its instance's heartbeat. Call it well within d, such as every
+// minute with a five minute lease. It does not lengthen the attempt's
+// timeout.
+func (t *Task) ExtendLease(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return errors.New("lease must be extended by a positive duration")
+	}
+	previous := t.LeaseExpiresAt
+	expires := now(ctx).Add(d)
+	t.LeaseExpiresAt = &expires
+	if err := save(ctx, t); err != nil {
+		t.LeaseExpiresAt = previous
+		return fmt.Errorf("failed to extend lease: %w", err)
+	}
+	return nil
+}
+
+// LeaseExpired reports whether the task's handler extended its lease and
+// then let it run out before now
+func (t *Task) LeaseExpired(now time.Time) bool {
+	return t.LeaseExpiresAt != nil && now.After(*t.LeaseExpiresAt)
+}
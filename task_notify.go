@@ -0,0 +1,70 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+)
+
+// validTopic matches the event bus topics a Notification may name
+var validTopic = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,127}$`)
+
+// Notification asks for the producer to be told when the task finishes,
+// through any of a webhook, an event bus topic and an email address
+type Notification struct {
+	// Webhook is an http or https URL the outcome is POSTed to
+	Webhook string `json:"webhook,omitempty"`
+	// Topic is the event bus topic the outcome is published on
+	Topic string `json:"topic,omitempty"`
+	// Email is the address the outcome is mailed to
+	Email string `json:"email,omitempty"`
+
+	// Template names a stored template rendered with the outcome, instead
+	// of the default message
+	Template string `json:"template,omitempty"`
+
+	// On lists the terminal statuses to notify on; empty means all of them
+	On []Status `json:"on,omitempty"`
+}
+
+// Validate checks n's channels and statuses
+func (n *Notification) Validate() error {
+	if n.Webhook == "" && n.Topic == "" && n.Email == "" {
+		return errors.New("notify needs a webhook, topic or email")
+	}
+	if n.Webhook != "" {
+		u, err := url.Parse(n.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notify webhook %q must be an http or https URL", n.Webhook)
+		}
+	}
+	if n.Topic != "" && !validTopic.MatchString(n.Topic) {
+		return errors.New("notify topic must be 1 to 128 letters, digits, '_', '.', ':' or '-'")
+	}
+	if n.Email != "" {
+		if _, err := mail.ParseAddress(n.Email); err != nil {
+			return fmt.Errorf("notify email %q is not an address", n.Email)
+		}
+	}
+	for _, s := range n.On {
+		if !s.Terminal() || !s.Valid() {
+			return fmt.Errorf("notify on %q must be a terminal status", s)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the producer asked to hear about status
+func (n *Notification) Wants(status Status) bool {
+	if len(n.On) == 0 {
+		return true
+	}
+	for _, s := range n.On {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"go.uber.org/zap"
+)
+
+// handleListArtifacts lists the files attached to a task, each with a
+// download link that expires after ArtifactURLExpiry
+func (s *Server) handleListArtifacts(w http.ResponseWriter, r *http.Request) {
+	if s.config.Artifacts == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no artifact store is configured")
+		return
+	}
+	t, err := s.queue.GetTask(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	expiry := s.config.ArtifactURLExpiry
+	expiresAt := time.Now().Add(expiry).UTC()
+	resp := ArtifactsResponse{Artifacts: make([]ArtifactLink, 0, len(t.Artifacts))}
+	for _, a := range t.Artifacts {
+		url, err := s.config.Artifacts.URL(r.Context(), a.Key, expiry)
+		if err != nil {
+			s.logger.Error("failed to link artifact", zap.String("id", t.ID), zap.String("key", a.Key), zap.Error(err))
+			s.respondErr(w, r, err)
+			return
+		}
+		resp.Artifacts = append(resp.Artifacts, ArtifactLink{
+			Name:        a.Name,
+			Size:        a.Size,
+			ContentType: a.ContentType,
+			CreatedAt:   a.CreatedAt,
+			URL:         url,
+			ExpiresAt:   expiresAt,
+		})
+	}
+	s.respondJSON(w, r, http.StatusOK, resp)
+}
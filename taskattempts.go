@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// handleListAttempts lists a task's runs, and how many failed on each
+// worker, to tell a failing task from a failing node
+func (s *Server) handleListAttempts(w http.ResponseWriter, r *http.Request) {
+	t, err := s.queue.GetTask(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	resp := AttemptsResponse{
+		TaskID:   t.ID,
+		Attempts: t.Attempts,
+		Failures: make(map[string]int),
+	}
+	if resp.Attempts == nil {
+		resp.Attempts = []task.AttemptRecord{}
+	}
+	for _, a := range t.Attempts {
+		if a.Error != "" || a.Outcome == task.OutcomeLost {
+			resp.Failures[a.WorkerID]++
+		}
+	}
+	s.respondJSON(w, r, http.StatusOK, resp)
+}
@@ -0,0 +1,181 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+const taskTemplatesKey = "task_templates"
+
+var validTemplateName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// NamedTemplate is a TaskTemplate producers submit tasks from by name, so
+// the defaults of a kind of task, such as a welcome email, live in one
+// place rather than in every producer
+type NamedTemplate struct {
+	Name string `json:"name"`
+	TaskTemplate
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the template's name and task
+func (t *NamedTemplate) Validate() error {
+	switch {
+	case !validTemplateName.MatchString(t.Name):
+		return errs.Invalidf("template name must be 1 to 128 letters, digits, '_', '.' or '-'")
+	case t.Type == "":
+		return errs.Invalidf("task type is required")
+	case t.Priority != nil && !t.Priority.Valid():
+		return errs.Invalidf("priority must be between %d and %d", task.PriorityMin, task.PriorityMax)
+	case t.MaxRetries != nil && (*t.MaxRetries < 0 || *t.MaxRetries > 100):
+		return errs.Invalidf("max_retries must be between 0 and 100")
+	}
+	return nil
+}
+
+// TemplateStore keeps named task templates
+type TemplateStore interface {
+	// Put creates or replaces a template
+	Put(ctx context.Context, t *NamedTemplate) error
+	// Get returns a template, or errs.ErrTaskTemplateNotFound
+	Get(ctx context.Context, name string) (*NamedTemplate, error)
+	// List returns every template, by name
+	List(ctx context.Context) ([]*NamedTemplate, error)
+	// Delete removes a template, or returns errs.ErrTaskTemplateNotFound
+	Delete(ctx context.Context, name string) error
+}
+
+func templateNotFound(name string) error {
+	return fmt.Errorf("%w: %s", errs.ErrTaskTemplateNotFound, name)
+}
+
+func sortTemplates(list []*NamedTemplate) {
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+}
+
+// RedisTemplateStore keeps task templates in one Redis hash, by name
+type RedisTemplateStore struct {
+	client *redis.Client
+}
+
+// NewRedisTemplateStore creates a task template store on client
+func NewRedisTemplateStore(client *redis.Client) *RedisTemplateStore {
+	return &RedisTemplateStore{client: client}
+}
+
+func (r *RedisTemplateStore) Put(ctx context.Context, t *NamedTemplate) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task template: %w", err)
+	}
+	if err := r.client.HSet(ctx, taskTemplatesKey, t.Name, data).Err(); err != nil {
+		return fmt.Errorf("failed to save task template: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisTemplateStore) Get(ctx context.Context, name string) (*NamedTemplate, error) {
+	data, err := r.client.HGet(ctx, taskTemplatesKey, name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, templateNotFound(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task template: %w", err)
+	}
+	var t NamedTemplate
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse task template %s: %w", name, err)
+	}
+	return &t, nil
+}
+
+func (r *RedisTemplateStore) List(ctx context.Context) ([]*NamedTemplate, error) {
+	values, err := r.client.HGetAll(ctx, taskTemplatesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task templates: %w", err)
+	}
+	list := make([]*NamedTemplate, 0, len(values))
+	for name, data := range values {
+		var t NamedTemplate
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			return nil, fmt.Errorf("failed to parse task template %s: %w", name, err)
+		}
+		list = append(list, &t)
+	}
+	sortTemplates(list)
+	return list, nil
+}
+
+func (r *RedisTemplateStore) Delete(ctx context.Context, name string) error {
+	n, err := r.client.HDel(ctx, taskTemplatesKey, name).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete task template: %w", err)
+	}
+	if n == 0 {
+		return templateNotFound(name)
+	}
+	return nil
+}
+
+// MemoryTemplateStore keeps task templates in memory, for tests and
+// single-process deployments
+type MemoryTemplateStore struct {
+	mu        sync.Mutex
+	templates map[string]NamedTemplate
+}
+
+// NewMemoryTemplateStore creates an in-memory task template store
+func NewMemoryTemplateStore() *MemoryTemplateStore {
+	return &MemoryTemplateStore{templates: make(map[string]NamedTemplate)}
+}
+
+func (m *MemoryTemplateStore) Put(ctx context.Context, t *NamedTemplate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.templates[t.Name] = *t
+	return nil
+}
+
+func (m *MemoryTemplateStore) Get(ctx context.Context, name string) (*NamedTemplate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.templates[name]
+	if !ok {
+		return nil, templateNotFound(name)
+	}
+	return &t, nil
+}
+
+func (m *MemoryTemplateStore) List(ctx context.Context) ([]*NamedTemplate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*NamedTemplate, 0, len(m.templates))
+	for _, t := range m.templates {
+		t := t
+		list = append(list, &t)
+	}
+	sortTemplates(list)
+	return list, nil
+}
+
+func (m *MemoryTemplateStore) Delete(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.templates[name]; !ok {
+		return templateNotFound(name)
+	}
+	delete(m.templates, name)
+	return nil
+}
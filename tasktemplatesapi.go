@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"go.uber.org/zap"
+)
+
+// taskTemplateStore returns the configured task template store, or
+// responds 404 and returns nil
+func (s *Server) taskTemplateStore(w http.ResponseWriter, r *http.Request) schedule.TemplateStore {
+	if s.config.TaskTemplates == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no task template store is configured")
+	}
+	return s.config.TaskTemplates
+}
+
+// handleListTaskTemplates lists every task template, by name
+func (s *Server) handleListTaskTemplates(w http.ResponseWriter, r *http.Request) {
+	store := s.taskTemplateStore(w, r)
+	if store == nil {
+		return
+	}
+	list, err := store.List(r.Context())
+	if err != nil {
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, TaskTemplatesResponse{Templates: list})
+}
+
+// handlePutTaskTemplate creates or replaces a task template. Tasks already
+// submitted from it keep what they were given.
+func (s *Server) handlePutTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.taskTemplateStore(w, r)
+	if store == nil {
+		return
+	}
+	var req schedule.TaskTemplate
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	t := &schedule.NamedTemplate{Name: chi.URLParam(r, "name"), TaskTemplate: req}
+	if err := t.Validate(); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	// The template must make a valid task on its own
+	probe := SubmitTaskRequest{Type: t.Type, Priority: t.Priority, Payload: t.Payload, Labels: t.Labels}
+	if err := probe.Validate(s.config.MaxPayloadBytes); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	now := time.Now().UTC()
+	t.CreatedAt, t.UpdatedAt = now, now
+	existing, err := store.Get(r.Context(), t.Name)
+	switch {
+	case err == nil:
+		status = http.StatusOK
+		t.CreatedAt = existing.CreatedAt
+	case !errors.Is(err, errs.ErrTaskTemplateNotFound):
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+
+	if err := store.Put(r.Context(), t); err != nil {
+		s.logger.Error("failed to save task template", zap.String("name", t.Name), zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.logger.Info("task template saved", zap.String("name", t.Name), zap.String("type", t.Type))
+	s.respondJSON(w, r, status, t)
+}
+
+// handleGetTaskTemplate returns a task template
+func (s *Server) handleGetTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.taskTemplateStore(w, r)
+	if store == nil {
+		return
+	}
+	t, err := store.Get(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		s.respondErr(w, r, taskTemplateStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, t)
+}
+
+// handleDeleteTaskTemplate deletes a task template. Submissions naming it
+// fail from then on.
+func (s *Server) handleDeleteTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.taskTemplateStore(w, r)
+	if store == nil {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if err := store.Delete(r.Context(), name); err != nil {
+		s.respondErr(w, r, taskTemplateStoreErr(err))
+		return
+	}
+	s.logger.Info("task template deleted", zap.String("name", name))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyTemplate fills in what req leaves out from the task template it
+// names, if any: the type, priority and retries, and the payload fields
+// and labels it does not set itself
+func (s *Server) applyTemplate(ctx context.Context, req *SubmitTaskRequest) error {
+	if req.Template == "" {
+		return nil
+	}
+	if s.config.TaskTemplates == nil {
+		return errs.Invalidf("no task template store is configured")
+	}
+	t, err := s.config.TaskTemplates.Get(ctx, req.Template)
+	if err != nil {
+		return taskTemplateStoreErr(err)
+	}
+
+	if req.Type != "" && req.Type != t.Type {
+		return errs.Invalidf("type %q does not match template %s, which submits %q", req.Type, t.Name, t.Type)
+	}
+	req.Type = t.Type
+	if req.Priority == nil {
+		req.Priority = t.Priority
+	}
+	req.templateRetries = t.MaxRetries
+
+	if len(t.Payload) > 0 {
+		payload := make(map[string]interface{}, len(t.Payload)+len(req.Payload))
+		for k, v := range t.Payload {
+			payload[k] = v
+		}
+		for k, v := range req.Payload {
+			payload[k] = v
+		}
+		req.Payload = payload
+	}
+	if len(t.Labels) > 0 {
+		labels := make(map[string]string, len(t.Labels)+len(req.Labels))
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		for k, v := range req.Labels {
+			labels[k] = v
+		}
+		req.Labels = labels
+	}
+	return nil
+}
+
+// taskTemplateStoreErr passes ErrTaskTemplateNotFound through and reports
+// anything else as the store being unavailable
+func taskTemplateStoreErr(err error) error {
+	if errors.Is(err, errs.ErrTaskTemplateNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err)
+}
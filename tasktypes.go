@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/cluster"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"go.uber.org/zap"
+)
+
+// handleListTypes lists the task types workers handle, this server
+// declares defaults for, or the cluster has disabled, sorted
+func (s *Server) handleListTypes(w http.ResponseWriter, r *http.Request) {
+	if s.config.Cluster == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no cluster registry is configured")
+		return
+	}
+
+	types, err := s.typeInfos(r.Context())
+	if err != nil {
+		s.logger.Error("failed to list task types", zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	resp := TypesResponse{Types: make([]TypeInfo, 0, len(types))}
+	for _, info := range types {
+		resp.Types = append(resp.Types, *info)
+	}
+	sort.Slice(resp.Types, func(i, j int) bool { return resp.Types[i].Type < resp.Types[j].Type })
+	s.respondJSON(w, r, http.StatusOK, resp)
+}
+
+// handleEnableType lets workers run a task type again
+func (s *Server) handleEnableType(w http.ResponseWriter, r *http.Request) {
+	s.setTypeDisabled(w, r, false)
+}
+
+// handleDisableType has workers hold a task type's tasks back
+func (s *Server) handleDisableType(w http.ResponseWriter, r *http.Request) {
+	s.setTypeDisabled(w, r, true)
+}
+
+// setTypeDisabled records the switch in the cluster registry, which workers
+// read with each heartbeat, and responds with the type
+func (s *Server) setTypeDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	if s.config.Cluster == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no cluster registry is configured")
+		return
+	}
+	taskType := chi.URLParam(r, "type")
+	if len(taskType) > maxTaskTypeLength {
+		s.respondErr(w, r, errs.Invalidf("task type must be at most %d characters", maxTaskTypeLength))
+		return
+	}
+
+	if err := s.config.Cluster.SetTypeDisabled(r.Context(), taskType, disabled); err != nil {
+		s.logger.Error("failed to switch task type", zap.String("type", taskType), zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.logger.Info("task type switched", zap.String("type", taskType), zap.Bool("disabled", disabled))
+
+	types, err := s.typeInfos(r.Context())
+	if err != nil {
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, types[taskType])
+}
+
+// typeInfos describes every task type known to the cluster, by type
+func (s *Server) typeInfos(ctx context.Context) (map[string]*TypeInfo, error) {
+	members, err := s.config.Cluster.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := s.config.Cluster.DisabledTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]*TypeInfo)
+	get := func(taskType string) *TypeInfo {
+		info, ok := types[taskType]
+		if !ok {
+			info = &TypeInfo{Type: taskType, Enabled: true, Workers: []string{}}
+			types[taskType] = info
+		}
+		return info
+	}
+	for _, m := range members {
+		if m.Kind != cluster.KindWorker {
+			continue
+		}
+		for _, taskType := range m.Types {
+			info := get(taskType)
+			info.Workers = append(info.Workers, m.ID)
+		}
+	}
+	for taskType, c := range s.queue.TypeConfigs() {
+		c := c
+		get(taskType).Config = &c
+	}
+	for _, taskType := range disabled {
+		get(taskType).Enabled = false
+	}
+	return types, nil
+}
@@ -0,0 +1,347 @@
+// Package templates stores the content of notification tasks, such as the
+// subject and body of an email, as named, versioned templates. Tasks name a
+// template instead of carrying the content, so the content can change
+// through the API without redeploying workers.
+package templates
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+)
+
+const (
+	templateNamesKey = "templates"
+	maxVersionRetry  = 10
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// Template is one version of a named template. Subject and Text are Go
+// text templates, HTML is an html/template, which escapes the data it
+// inserts. Executors use the parts they need, such as all three for email
+// or only Text for a chat message.
+type Template struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Rendered is a template filled in with a task's data
+type Rendered struct {
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Validate checks t's name and parses each of its parts
+func (t *Template) Validate() error {
+	if !validName.MatchString(t.Name) {
+		return errs.Invalidf("template name must be 1 to 128 letters, digits, '_', '.' or '-'")
+	}
+	if t.Text == "" && t.HTML == "" {
+		return errs.Invalidf("template %s needs a text or html body", t.Name)
+	}
+	if _, _, _, err := t.parse(); err != nil {
+		return errs.Invalidf("%s", err.Error())
+	}
+	return nil
+}
+
+// Render fills in every part of t with data. Referring to a key data does
+// not have is an error, rather than rendering "<no value>".
+func (t *Template) Render(data map[string]interface{}) (*Rendered, error) {
+	subject, text, html, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Rendered{}
+	var buf bytes.Buffer
+	if subject != nil {
+		if err := subject.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %w", t.Name, err)
+		}
+		out.Subject = strings.TrimSpace(buf.String())
+	}
+	if text != nil {
+		buf.Reset()
+		if err := text.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %w", t.Name, err)
+		}
+		out.Text = buf.String()
+	}
+	if html != nil {
+		buf.Reset()
+		if err := html.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %w", t.Name, err)
+		}
+		out.HTML = buf.String()
+	}
+	return out, nil
+}
+
+// parse parses the parts of t that are set
+func (t *Template) parse() (subject, text *texttemplate.Template, html *htmltemplate.Template, err error) {
+	if t.Subject != "" {
+		if subject, err = texttemplate.New("subject").Option("missingkey=error").Parse(t.Subject); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid subject in template %s: %w", t.Name, err)
+		}
+	}
+	if t.Text != "" {
+		if text, err = texttemplate.New("text").Option("missingkey=error").Parse(t.Text); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid text in template %s: %w", t.Name, err)
+		}
+	}
+	if t.HTML != "" {
+		if html, err = htmltemplate.New("html").Option("missingkey=error").Parse(t.HTML); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid html in template %s: %w", t.Name, err)
+		}
+	}
+	return subject, text, html, nil
+}
+
+// Store keeps every version of each template. Versions count up from 1
+// and are never changed once saved, so a task that names a version
+// renders the same content on every attempt.
+type Store interface {
+	// Put saves t as the next version of its name, setting t.Version and
+	// t.CreatedAt
+	Put(ctx context.Context, t *Template) error
+	// Get returns a version of the named template, or the latest for
+	// version 0. It returns errs.ErrTemplateNotFound if there is none.
+	Get(ctx context.Context, name string, version int) (*Template, error)
+	// Versions returns every version of the named template, oldest first
+	Versions(ctx context.Context, name string) ([]*Template, error)
+	// List returns the latest version of every template, by name
+	List(ctx context.Context) ([]*Template, error)
+	// Delete removes every version of the named template
+	Delete(ctx context.Context, name string) error
+}
+
+func notFound(name string, version int) error {
+	if version > 0 {
+		return fmt.Errorf("%w: %s version %d", errs.ErrTemplateNotFound, name, version)
+	}
+	return fmt.Errorf("%w: %s", errs.ErrTemplateNotFound, name)
+}
+
+// RedisStore keeps templates in Redis, one hash per name mapping each
+// version to its template
+type RedisStore struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewRedisStore creates a template store on client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, now: time.Now}
+}
+
+func templateKey(name string) string {
+	return fmt.Sprintf("template:%s", name)
+}
+
+func (s *RedisStore) Put(ctx context.Context, t *Template) error {
+	key := templateKey(t.Name)
+	t.CreatedAt = s.now().UTC()
+	for i := 0; i < maxVersionRetry; i++ {
+		// Versions are only ever added, so the next one is one past the
+		// count; HSETNX loses cleanly if another writer took it first
+		n, err := s.client.HLen(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("failed to save template: %w", err)
+		}
+		t.Version = int(n) + 1
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Errorf("failed to marshal template: %w", err)
+		}
+		ok, err := s.client.HSetNX(ctx, key, strconv.Itoa(t.Version), data).Result()
+		if err != nil {
+			return fmt.Errorf("failed to save template: %w", err)
+		}
+		if ok {
+			if err := s.client.SAdd(ctx, templateNamesKey, t.Name).Err(); err != nil {
+				return fmt.Errorf("failed to save template: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to save template %s: too many concurrent updates", t.Name)
+}
+
+func (s *RedisStore) Get(ctx context.Context, name string, version int) (*Template, error) {
+	key := templateKey(name)
+	if version == 0 {
+		n, err := s.client.HLen(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get template: %w", err)
+		}
+		if n == 0 {
+			return nil, notFound(name, 0)
+		}
+		version = int(n)
+	}
+
+	data, err := s.client.HGet(ctx, key, strconv.Itoa(version)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, notFound(name, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	var t Template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return &t, nil
+}
+
+func (s *RedisStore) Versions(ctx context.Context, name string) ([]*Template, error) {
+	values, err := s.client.HVals(ctx, templateKey(name)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template versions: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, notFound(name, 0)
+	}
+
+	versions := make([]*Template, 0, len(values))
+	for _, v := range values {
+		var t Template
+		if err := json.Unmarshal([]byte(v), &t); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		versions = append(versions, &t)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]*Template, error) {
+	names, err := s.client.SMembers(ctx, templateNamesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	sort.Strings(names)
+
+	list := make([]*Template, 0, len(names))
+	for _, name := range names {
+		t, err := s.Get(ctx, name, 0)
+		if errors.Is(err, errs.ErrTemplateNotFound) {
+			// Deleted between the two reads
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	n, err := s.client.Del(ctx, templateKey(name)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if err := s.client.SRem(ctx, templateNamesKey, name).Err(); err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if n == 0 {
+		return notFound(name, 0)
+	}
+	return nil
+}
+
+// MemoryStore keeps templates in memory, for tests and single-process
+// deployments
+type MemoryStore struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	templates map[string][]*Template
+}
+
+// NewMemoryStore creates an in-memory template store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, templates: make(map[string][]*Template)}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, t *Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.Version = len(s.templates[t.Name]) + 1
+	t.CreatedAt = s.now().UTC()
+	saved := *t
+	s.templates[t.Name] = append(s.templates[t.Name], &saved)
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, name string, version int) (*Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.templates[name]
+	if version == 0 {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return nil, notFound(name, version)
+	}
+	t := *versions[version-1]
+	return &t, nil
+}
+
+func (s *MemoryStore) Versions(ctx context.Context, name string) ([]*Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.templates[name]) == 0 {
+		return nil, notFound(name, 0)
+	}
+	versions := make([]*Template, len(s.templates[name]))
+	for i, v := range s.templates[name] {
+		t := *v
+		versions[i] = &t
+	}
+	return versions, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]*Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*Template, 0, len(s.templates))
+	for _, versions := range s.templates {
+		t := *versions[len(versions)-1]
+		list = append(list, &t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.templates[name]; !ok {
+		return notFound(name, 0)
+	}
+	delete(s.templates, name)
+	return nil
+}
@@ -0,0 +1,81 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+)
+
+func TestTemplate_Render(t *testing.T) {
+	tmpl := &Template{
+		Name:    "welcome",
+		Subject: " Welcome, {{.name}} \n",
+		Text:    "Hi {{.name}}",
+		HTML:    "<p>Hi {{.name}}</p>",
+	}
+	require.NoError(t, tmpl.Validate())
+
+	out, err := tmpl.Render(map[string]interface{}{"name": "<Ada>"})
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome, <Ada>", out.Subject)
+	assert.Equal(t, "Hi <Ada>", out.Text)
+	assert.Equal(t, "<p>Hi &lt;Ada&gt;</p>", out.HTML)
+
+	_, err = tmpl.Render(map[string]interface{}{})
+	assert.Error(t, err, "missing keys fail rather than render <no value>")
+
+	for _, bad := range []*Template{
+		{Name: "", Text: "x"},
+		{Name: "a/b", Text: "x"},
+		{Name: "empty", Subject: "only a subject"},
+		{Name: "broken", Text: "{{.name"},
+	} {
+		err := bad.Validate()
+		assert.True(t, errors.Is(err, errs.ErrInvalidRequest), "%+v: %v", bad, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	_, err := store.Get(ctx, "welcome", 0)
+	assert.True(t, errors.Is(err, errs.ErrTemplateNotFound))
+
+	v1 := &Template{Name: "welcome", Text: "Hi"}
+	require.NoError(t, store.Put(ctx, v1))
+	v2 := &Template{Name: "welcome", Text: "Hello"}
+	require.NoError(t, store.Put(ctx, v2))
+	require.NoError(t, store.Put(ctx, &Template{Name: "alert", Text: "!"}))
+	assert.Equal(t, 1, v1.Version)
+	assert.Equal(t, 2, v2.Version)
+
+	latest, err := store.Get(ctx, "welcome", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", latest.Text)
+	first, err := store.Get(ctx, "welcome", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", first.Text)
+	_, err = store.Get(ctx, "welcome", 3)
+	assert.True(t, errors.Is(err, errs.ErrTemplateNotFound))
+
+	versions, err := store.Versions(ctx, "welcome")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 1, versions[0].Version)
+
+	list, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "alert", list[0].Name)
+	assert.Equal(t, 2, list[1].Version)
+
+	require.NoError(t, store.Delete(ctx, "welcome"))
+	assert.True(t, errors.Is(store.Delete(ctx, "welcome"), errs.ErrTemplateNotFound))
+	_, err = store.Versions(ctx, "welcome")
+	assert.True(t, errors.Is(err, errs.ErrTemplateNotFound))
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"go.uber.org/zap"
+)
+
+// templateStore returns the configured template store, or responds 404
+// and returns nil
+func (s *Server) templateStore(w http.ResponseWriter, r *http.Request) templates.Store {
+	if s.config.Templates == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no template store is configured")
+	}
+	return s.config.Templates
+}
+
+// handleListTemplates lists the latest version of every template
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	store := s.templateStore(w, r)
+	if store == nil {
+		return
+	}
+	list, err := store.List(r.Context())
+	if err != nil {
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, TemplatesResponse{Templates: list})
+}
+
+// handlePutTemplate saves a new version of a template. Earlier versions
+// are kept for tasks that pin them.
+func (s *Server) handlePutTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.templateStore(w, r)
+	if store == nil {
+		return
+	}
+	var req TemplateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	t := &templates.Template{
+		Name:    chi.URLParam(r, "name"),
+		Subject: req.Subject,
+		Text:    req.Text,
+		HTML:    req.HTML,
+	}
+	if err := t.Validate(); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	if err := store.Put(r.Context(), t); err != nil {
+		s.logger.Error("failed to save template", zap.String("name", t.Name), zap.Error(err))
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.logger.Info("template saved", zap.String("name", t.Name), zap.Int("version", t.Version))
+	s.respondJSON(w, r, http.StatusCreated, t)
+}
+
+// handleGetTemplate returns the latest version of a template, or the one
+// ?version= names
+func (s *Server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.templateStore(w, r)
+	if store == nil {
+		return
+	}
+	version, err := templateVersion(r.URL.Query().Get("version"))
+	if err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	t, err := store.Get(r.Context(), chi.URLParam(r, "name"), version)
+	if err != nil {
+		s.respondErr(w, r, templateStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, t)
+}
+
+// handleListTemplateVersions lists every version of a template, oldest
+// first
+func (s *Server) handleListTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	store := s.templateStore(w, r)
+	if store == nil {
+		return
+	}
+	versions, err := store.Versions(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		s.respondErr(w, r, templateStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, TemplatesResponse{Templates: versions})
+}
+
+// handleDeleteTemplate deletes every version of a template. Tasks that
+// still name it fail without retrying.
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.templateStore(w, r)
+	if store == nil {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if err := store.Delete(r.Context(), name); err != nil {
+		s.respondErr(w, r, templateStoreErr(err))
+		return
+	}
+	s.logger.Info("template deleted", zap.String("name", name))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRenderTemplate previews a template filled in with sample data
+func (s *Server) handleRenderTemplate(w http.ResponseWriter, r *http.Request) {
+	store := s.templateStore(w, r)
+	if store == nil {
+		return
+	}
+	var req RenderTemplateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	if req.Version < 0 {
+		s.respondErr(w, r, errs.Invalidf("version must be positive"))
+		return
+	}
+	t, err := store.Get(r.Context(), chi.URLParam(r, "name"), req.Version)
+	if err != nil {
+		s.respondErr(w, r, templateStoreErr(err))
+		return
+	}
+	rendered, err := t.Render(req.Data)
+	if err != nil {
+		s.respondErr(w, r, errs.Invalidf("%s", err.Error()))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, rendered)
+}
+
+// templateVersion parses a ?version= parameter, 0 when absent
+func templateVersion(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 1 {
+		return 0, errs.Invalidf("version must be a positive integer")
+	}
+	return version, nil
+}
+
+// storeErr passes ErrTemplateNotFound through and reports anything else as
+// the store being unavailable
+func templateStoreErr(err error) error {
+	if errors.Is(err, errs.ErrTemplateNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err)
+}
@@ -0,0 +1,434 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// QueueLabel is the task label that records a type's TypeConfig.Queue
+const QueueLabel = "queue"
+
+// TypeConfig holds the defaults for one task type, declared once instead
+// of by every producer. Zero fields fall back to the queue's defaults.
+type TypeConfig struct {
+	// Priority and MaxRetries apply to tasks submitted without their own
+	Priority   *task.Priority `json:"priority,omitempty"`
+	MaxRetries *int           `json:"max_retries,omitempty"`
+
+	// Timeout bounds each attempt, instead of Config.TaskTimeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Retry spaces out retries, instead of RetryCount² seconds
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	// RateLimit caps how many tasks of the type each worker process
+	// starts per second. Tasks over it are scheduled for when there is
+	// room. Zero means no limit.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+
+	// Concurrency caps how many tasks of the type each worker process runs
+	// at once, however many workers it has. Workers pass over the type's
+	// tasks while it is at the cap and run other types'. Zero means no
+	// limit.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Queue names the queue the type belongs to. It is recorded as the
+	// QueueLabel of tasks that do not set one, so it can be filtered on and
+	// listed in Config.MetricLabels.
+	Queue string `json:"queue,omitempty"`
+
+	// MemoryMB is about how much memory, in MiB, a task of the type uses.
+	// With Config.MemoryLimitMB, workers start tasks only while the hints
+	// of those running fit the limit.
+	MemoryMB int `json:"memory_mb,omitempty"`
+
+	// Weight is how heavy a task of the type is, such as the CPU cores it
+	// keeps busy, for tasks that give none. With Config.WeightBudget,
+	// workers start tasks only while the weights of those running fit the
+	// budget. Defaults to 1.
+	Weight int `json:"weight,omitempty"`
+
+	// CPUTime bounds the CPU time each attempt's handler may use, on
+	// Linux, before its context is cancelled. Unlike Timeout, time spent
+	// waiting on I/O does not count.
+	CPUTime time.Duration `json:"cpu_time,omitempty"`
+
+	// FireAndForget submits tasks of the type without a task record: they
+	// are pushed onto the storage's untracked list in one write and are
+	// never stored, listed, counted by status or cancellable. Their
+	// outcomes only show in metrics, logs and lifecycle callbacks.
+	FireAndForget bool `json:"fire_and_forget,omitempty"`
+
+	// ResultSinks names the result sinks, configured with
+	// RESULT_SINKS_FILE, that the results of completed tasks of the type
+	// are forwarded to
+	ResultSinks []string `json:"result_sinks,omitempty"`
+}
+
+// RetryPolicy waits Initial before the first retry, multiplying the wait
+// by Multiplier for each retry after it, up to Max
+type RetryPolicy struct {
+	Initial time.Duration `json:"initial"`
+	// Multiplier defaults to 1, a fixed delay
+	Multiplier float64       `json:"multiplier,omitempty"`
+	Max        time.Duration `json:"max,omitempty"`
+}
+
+// Delay returns how long to wait before retry number retry, counting from 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	delay := float64(p.Initial) * math.Pow(multiplier, float64(retry-1))
+	if p.Max > 0 && delay > float64(p.Max) {
+		return p.Max
+	}
+	return time.Duration(delay)
+}
+
+// Validate checks the configuration of taskType
+func (c TypeConfig) Validate(taskType string) error {
+	switch {
+	case c.Priority != nil && !c.Priority.Valid():
+		return fmt.Errorf("type %s: priority must be between %d and %d", taskType, task.PriorityMin, task.PriorityMax)
+	case c.MaxRetries != nil && (*c.MaxRetries < 0 || *c.MaxRetries > 100):
+		return fmt.Errorf("type %s: max_retries must be between 0 and 100", taskType)
+	case c.Timeout < 0:
+		return fmt.Errorf("type %s: timeout must not be negative", taskType)
+	case c.RateLimit < 0:
+		return fmt.Errorf("type %s: rate_limit must not be negative", taskType)
+	case c.Concurrency < 0:
+		return fmt.Errorf("type %s: concurrency must not be negative", taskType)
+	case c.MemoryMB < 0:
+		return fmt.Errorf("type %s: memory_mb must not be negative", taskType)
+	case c.Weight < 0:
+		return fmt.Errorf("type %s: weight must not be negative", taskType)
+	case c.CPUTime < 0:
+		return fmt.Errorf("type %s: cpu_time must not be negative", taskType)
+	case c.Retry != nil && (c.Retry.Initial <= 0 || c.Retry.Max < 0):
+		return fmt.Errorf("type %s: retry needs a positive initial delay", taskType)
+	case c.Retry != nil && c.Retry.Multiplier != 0 && c.Retry.Multiplier < 1:
+		return fmt.Errorf("type %s: retry multiplier must be at least 1", taskType)
+	}
+	return nil
+}
+
+// typeConfigJSON is TypeConfig with durations written as strings such as
+// "30s"
+type typeConfigJSON struct {
+	Priority    *task.Priority   `json:"priority,omitempty"`
+	MaxRetries  *int             `json:"max_retries,omitempty"`
+	Timeout     string           `json:"timeout,omitempty"`
+	Retry       *retryPolicyJSON `json:"retry,omitempty"`
+	RateLimit   float64          `json:"rate_limit,omitempty"`
+	Concurrency int              `json:"concurrency,omitempty"`
+	Queue       string           `json:"queue,omitempty"`
+	MemoryMB    int              `json:"memory_mb,omitempty"`
+	Weight      int              `json:"weight,omitempty"`
+	CPUTime     string           `json:"cpu_time,omitempty"`
+
+	FireAndForget bool     `json:"fire_and_forget,omitempty"`
+	ResultSinks   []string `json:"result_sinks,omitempty"`
+}
+
+type retryPolicyJSON struct {
+	Initial    string  `json:"initial"`
+	Multiplier float64 `json:"multiplier,omitempty"`
+	Max        string  `json:"max,omitempty"`
+}
+
+// MarshalJSON writes durations as strings such as "30s"
+func (c TypeConfig) MarshalJSON() ([]byte, error) {
+	out := typeConfigJSON{
+		Priority:    c.Priority,
+		MaxRetries:  c.MaxRetries,
+		Timeout:     formatDuration(c.Timeout),
+		RateLimit:   c.RateLimit,
+		Concurrency: c.Concurrency,
+		Queue:       c.Queue,
+		MemoryMB:    c.MemoryMB,
+		Weight:      c.Weight,
+		CPUTime:     formatDuration(c.CPUTime),
+
+		FireAndForget: c.FireAndForget,
+		ResultSinks:   c.ResultSinks,
+	}
+	if c.Retry != nil {
+		out.Retry = &retryPolicyJSON{
+			Initial:    c.Retry.Initial.String(),
+			Multiplier: c.Retry.Multiplier,
+			Max:        formatDuration(c.Retry.Max),
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads durations written as strings such as "30s"
+func (c *TypeConfig) UnmarshalJSON(data []byte) error {
+	var in typeConfigJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	timeout, err := parseDuration("timeout", in.Timeout)
+	if err != nil {
+		return err
+	}
+	cpuTime, err := parseDuration("cpu_time", in.CPUTime)
+	if err != nil {
+		return err
+	}
+	*c = TypeConfig{
+		Priority:    in.Priority,
+		MaxRetries:  in.MaxRetries,
+		Timeout:     timeout,
+		RateLimit:   in.RateLimit,
+		Concurrency: in.Concurrency,
+		Queue:       in.Queue,
+		MemoryMB:    in.MemoryMB,
+		Weight:      in.Weight,
+		CPUTime:     cpuTime,
+
+		FireAndForget: in.FireAndForget,
+		ResultSinks:   in.ResultSinks,
+	}
+	if in.Retry != nil {
+		c.Retry = &RetryPolicy{Multiplier: in.Retry.Multiplier}
+		if c.Retry.Initial, err = parseDuration("retry initial", in.Retry.Initial); err != nil {
+			return err
+		}
+		if c.Retry.Max, err = parseDuration("retry max", in.Retry.Max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func parseDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", field, s)
+	}
+	return d, nil
+}
+
+// LoadTypeConfigs reads per-type defaults from a JSON file mapping task
+// types to TypeConfigs, such as
+//
+//	{"send_email": {"priority": "high", "timeout": "30s", "queue": "email"}}
+func LoadTypeConfigs(path string) (map[string]TypeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read type configs: %w", err)
+	}
+	var configs map[string]TypeConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("invalid type configs in %s: %w", path, err)
+	}
+	for taskType, c := range configs {
+		if err := c.Validate(taskType); err != nil {
+			return nil, fmt.Errorf("invalid type configs in %s: %w", path, err)
+		}
+	}
+	return configs, nil
+}
+
+// SetTypeConfig declares the defaults for taskType, replacing any before.
+// Tasks already submitted keep the priority and retries they were given.
+func (q *Queue) SetTypeConfig(taskType string, c TypeConfig) error {
+	if err := c.Validate(taskType); err != nil {
+		return err
+	}
+	q.checkFireAndForget(taskType, c)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.types[taskType] = c
+	delete(q.limiters, taskType)
+	return nil
+}
+
+// SetTypeConfigs replaces the defaults of every task type, such as after
+// LoadTypeConfigs re-reads its file. Nothing changes unless all of them
+// are valid. Rate limits start again from a full bucket.
+func (q *Queue) SetTypeConfigs(configs map[string]TypeConfig) error {
+	types := make(map[string]TypeConfig, len(configs))
+	for taskType, c := range configs {
+		if err := c.Validate(taskType); err != nil {
+			return err
+		}
+		types[taskType] = c
+	}
+	for taskType, c := range types {
+		q.checkFireAndForget(taskType, c)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.types = types
+	q.limiters = make(map[string]*typeLimiter)
+	return nil
+}
+
+// TypeConfig returns the defaults declared for taskType
+func (q *Queue) TypeConfig(taskType string) (TypeConfig, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	c, ok := q.types[taskType]
+	return c, ok
+}
+
+// TypeConfigs returns the defaults declared for every task type
+func (q *Queue) TypeConfigs() map[string]TypeConfig {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	configs := make(map[string]TypeConfig, len(q.types))
+	for taskType, c := range q.types {
+		configs[taskType] = c
+	}
+	return configs
+}
+
+// SetDisabledTypes replaces the task types workers hold back. Tasks of a
+// disabled type stay queued, and are scheduled DisabledTypeDelay ahead
+// each time a worker picks one up, until the type is enabled again.
+func (q *Queue) SetDisabledTypes(types []string) {
+	disabled := make(map[string]bool, len(types))
+	for _, t := range types {
+		disabled[t] = true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for t := range disabled {
+		if !q.disabled[t] {
+			q.logger.Info("task type disabled", zap.String("type", t))
+		}
+	}
+	for t := range q.disabled {
+		if !disabled[t] {
+			q.logger.Info("task type enabled", zap.String("type", t))
+		}
+	}
+	q.disabled = disabled
+}
+
+// TypeEnabled reports whether workers run tasks of taskType
+func (q *Queue) TypeEnabled(taskType string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return !q.disabled[taskType]
+}
+
+// NewTask returns a task of taskType with the type's default priority and
+// retries. Producers should prefer it to task.NewTask, so the defaults
+// are declared in one place.
+func (q *Queue) NewTask(taskType string, payload map[string]interface{}) *task.Task {
+	c, _ := q.TypeConfig(taskType)
+	priority := task.PriorityLow
+	if c.Priority != nil {
+		priority = *c.Priority
+	}
+	t := task.NewTask(taskType, priority, payload)
+	if c.MaxRetries != nil {
+		t.MaxRetries = *c.MaxRetries
+	}
+	return t
+}
+
+// applyTypeConfig records the queue of t's type on t
+func (q *Queue) applyTypeConfig(t *task.Task) {
+	c, ok := q.TypeConfig(t.Type)
+	if !ok || c.Queue == "" {
+		return
+	}
+	if _, set := t.Labels[QueueLabel]; set {
+		return
+	}
+	if t.Labels == nil {
+		t.Labels = make(map[string]string)
+	}
+	t.Labels[QueueLabel] = c.Queue
+}
+
+// timeout returns how long one attempt of t may run
+func (q *Queue) timeout(t *task.Task) time.Duration {
+	if c, ok := q.TypeConfig(t.Type); ok && c.Timeout > 0 {
+		return c.Timeout
+	}
+	return q.taskTimeout
+}
+
+// retryDelay returns how long t waits before its next retry
+func (q *Queue) retryDelay(t *task.Task) time.Duration {
+	if c, ok := q.TypeConfig(t.Type); ok && c.Retry != nil {
+		return c.Retry.Delay(t.RetryCount)
+	}
+	return time.Duration(t.RetryCount*t.RetryCount) * time.Second
+}
+
+// throttle takes a start of t's type from the type's rate limit. It
+// returns zero when t may start now, or how long until there is room.
+func (q *Queue) throttle(t *task.Task, now time.Time) time.Duration {
+	c, ok := q.TypeConfig(t.Type)
+	if !ok || c.RateLimit == 0 {
+		return 0
+	}
+
+	q.mu.Lock()
+	l, ok := q.limiters[t.Type]
+	if !ok {
+		l = newTypeLimiter(c.RateLimit, now)
+		q.limiters[t.Type] = l
+	}
+	q.mu.Unlock()
+	return l.take(now)
+}
+
+// typeLimiter is a token bucket holding up to one second of starts
+type typeLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTypeLimiter(rate float64, now time.Time) *typeLimiter {
+	burst := math.Max(1, rate)
+	return &typeLimiter{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take returns zero and uses a token if one is left, or else how long
+// until the next one
+func (l *typeLimiter) take(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed*l.rate)
+		l.last = now
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// fireAndForget reports whether t is submitted without a task record: its
+// type asks for it and the storage can hold untracked tasks
+func (q *Queue) fireAndForget(t *task.Task) bool {
+	if q.untracked == nil {
+		return false
+	}
+	c, _ := q.TypeConfig(t.Type)
+	return c.FireAndForget
+}
+
+// checkFireAndForget warns when taskType asks for fire-and-forget but
+// storage cannot hold untracked tasks, so its tasks get a record after all
+func (q *Queue) checkFireAndForget(taskType string, c TypeConfig) {
+	if c.FireAndForget && q.untracked == nil {
+		q.logger.Warn("storage cannot hold untracked tasks; fire-and-forget tasks are submitted with a task record",
+			zap.String("type", taskType),
+		)
+	}
+}
+
+// submitUntracked pushes t onto the untracked list, skipping the record,
+// indexes and stats a tracked submission writes
+func (q *Queue) submitUntracked(ctx context.Context, t *task.Task) error {
+	t.Untracked = true
+	if err := q.untracked.PushUntracked(ctx, t); err != nil {
+		return fmt.Errorf("failed to push task: %w", err)
+	}
+	metrics.TasksSubmitted.WithLabelValues(t.Type, t.Priority.Label()).Inc()
+	metrics.TasksSubmittedBySource.WithLabelValues(t.Type, t.Source).Inc()
+	metrics.QueueSize.WithLabelValues(t.Priority.Label()).Inc()
+	q.logger.Debug("untracked task submitted", zap.String("id", t.ID), zap.String("type", t.Type))
+	q.refill()
+	return nil
+}
+
+// pollUntracked pops untracked tasks into the prefetch buffer, as far as
+// there is room, returning how many it took. Retries not yet due go back
+// on the list.
+func (q *Queue) pollUntracked(ctx context.Context) int {
+	if q.untracked == nil {
+		return 0
+	}
+	q.bufferedMu.Lock()
+	room := q.prefetch - len(q.buffered)
+	q.bufferedMu.Unlock()
+	if room > q.polling.batchSize {
+		room = q.polling.batchSize
+	}
+	if room <= 0 {
+		return 0
+	}
+
+	tasks, err := q.untracked.PopUntracked(ctx, room)
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("failed to poll untracked tasks", zap.Error(err))
+		}
+		return 0
+	}
+	now := q.clock.Now()
+	offered := 0
+	for _, t := range tasks {
+		if t.ScheduledAt != nil && t.ScheduledAt.After(now) || !q.offer(t) {
+			q.pushBack(t)
+			continue
+		}
+		offered++
+	}
+	return offered
+}
+
+// pushBack returns an untracked task to the list, which is its only copy
+func (q *Queue) pushBack(t *task.Task) {
+	ctx, cancel := context.WithTimeout(context.Background(), handoffNotifyTimeout)
+	defer cancel()
+	if err := q.untracked.PushUntracked(ctx, t); err != nil {
+		q.logger.Error("untracked task lost", zap.String("id", t.ID), zap.String("type", t.Type), zap.Error(err))
+		metrics.QueueSize.WithLabelValues(t.Priority.Label()).Dec()
+	}
+}
+
+// processUntracked runs an untracked task. Nothing is written to storage:
+// a retry or reschedule goes back on the untracked list, and every other
+// outcome only reaches metrics, logs and the lifecycle callbacks.
+func (q *Queue) processUntracked(ctx context.Context, t *task.Task, workerID string, logger *zap.Logger) {
+	startTime := q.clock.Now()
+	metrics.QueueSize.WithLabelValues(t.Priority.Label()).Dec()
+
+	if t.Overdue(startTime) {
+		t.MarkExpiredAt(q.clock.Now())
+		metrics.SLAMissed.WithLabelValues(t.Type).Inc()
+		metrics.TasksProcessed.WithLabelValues(t.Type, "expired").Inc()
+		logger.Warn("task missed its deadline", zap.Time("deadline", *t.Deadline))
+		q.notify(ctx, onFinish, t, logger)
+		return
+	}
+
+	q.mu.RLock()
+	handler, exists := q.handlers[t.Type]
+	q.mu.RUnlock()
+
+	var err error
+	if q.signingKeys != nil {
+		if verifyErr := q.signingKeys.VerifyTask(t); verifyErr != nil {
+			err = fmt.Errorf("task signature rejected: %w: %w", task.ErrInvalidPayload, verifyErr)
+		}
+	}
+	if err == nil && !exists {
+		err = fmt.Errorf("%w: %s", errNoHandler, t.Type)
+	}
+	if err != nil {
+		logger.Error("untracked task failed", zap.Error(err))
+		q.recordFailure(t, err)
+		q.failUntracked(ctx, t, err, logger)
+		return
+	}
+
+	t.MarkStartedAt(workerID, q.clock.Now())
+	deadline := startTime.Add(q.timeout(t))
+	if t.Deadline != nil && t.Deadline.Before(deadline) {
+		deadline = *t.Deadline
+	}
+	taskCtx, cancel := context.WithTimeout(ctx, deadline.Sub(startTime))
+	defer cancel()
+	taskCtx = task.WithLogger(taskCtx, logger)
+	taskCtx = q.withStores(taskCtx)
+	taskCtx = task.WithMeta(taskCtx, task.Meta{
+		TaskID:        t.ID,
+		Type:          t.Type,
+		CorrelationID: t.CorrelationID,
+		WorkerID:      workerID,
+		Attempt:       t.Attempt(),
+		MaxAttempts:   t.MaxRetries + 1,
+		Deadline:      deadline,
+	})
+
+	err = runHandler(taskCtx, handler, t, logger)
+	duration := q.clock.Now().Sub(startTime)
+	metrics.TaskDuration.WithLabelValues(t.Type).Observe(duration.Seconds())
+
+	if at, ok := task.RescheduleTime(err, q.clock.Now()); ok {
+		t.MarkRescheduledAt(q.clock.Now())
+		t.ScheduledAt = &at
+		metrics.TaskReschedules.WithLabelValues(t.Type).Inc()
+		metrics.QueueSize.WithLabelValues(t.Priority.Label()).Inc()
+		logger.Debug("untracked task rescheduled", zap.Time("scheduled_at", at))
+		q.pushBack(t)
+		return
+	}
+
+	switch {
+	case err == nil:
+		q.runTimes.record(t.Type, duration)
+		t.MarkCompletedAt(q.clock.Now())
+		metrics.TasksProcessed.WithLabelValues(t.Type, "completed").Inc()
+		logger.Debug("untracked task completed", zap.Duration("duration", duration))
+		q.notify(ctx, onComplete, t, logger)
+		q.notify(ctx, onFinish, t, logger)
+	case t.CanRetry() && !task.IsPermanent(err):
+		logger.Warn("untracked task failed, retrying", zap.Error(err), zap.Duration("duration", duration))
+		q.recordFailure(t, err)
+		t.RecordError(err)
+		t.MarkRetryingAt(q.clock.Now())
+		retryAt := q.clock.Now().Add(q.retryDelay(t))
+		t.ScheduledAt = &retryAt
+		metrics.TaskRetries.WithLabelValues(t.Type).Inc()
+		metrics.QueueSize.WithLabelValues(t.Priority.Label()).Inc()
+		q.pushBack(t)
+		q.notify(ctx, onRetry, t, logger)
+	default:
+		logger.Error("untracked task failed", zap.Error(err), zap.Duration("duration", duration))
+		q.recordFailure(t, err)
+		q.failUntracked(ctx, t, err, logger)
+	}
+}
+
+// failUntracked fails an untracked task for good and reports it
+func (q *Queue) failUntracked(ctx context.Context, t *task.Task, err error, logger *zap.Logger) {
+	t.MarkFailedAt(err, q.clock.Now())
+	metrics.TasksProcessed.WithLabelValues(t.Type, "failed").Inc()
+	q.notify(ctx, onFailure, t, logger)
+	q.notify(ctx, onFinish, t, logger)
+}
@@ -0,0 +1,779 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/cluster"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"github.com/yourusername/distributed-task-queue/internal/templates"
+	"github.com/yourusername/distributed-task-queue/internal/workflow"
+)
+
+const (
+	// maxTaskTypeLength bounds the task type name
+	maxTaskTypeLength = 128
+
+	// maxRetriesLimit bounds max_retries on submission
+	maxRetriesLimit = 100
+
+	// maxLabels bounds the number of labels on a task
+	maxLabels = 16
+
+	// maxLabelLength bounds label keys and values
+	maxLabelLength = 63
+
+	// maxTaskWeight bounds the weight given on submission
+	maxTaskWeight = 1000
+
+	// maxTaskIDLength bounds caller supplied task IDs
+	maxTaskIDLength = 128
+
+	// maxBatchSize bounds the number of tasks in one batch submission
+	maxBatchSize = 500
+
+	// maxBackfillRuns bounds the runs one schedule backfill submits
+	maxBackfillRuns = 500
+
+	// maxTimeSeriesWindow bounds the history served by the timeseries
+	// endpoint, which storage keeps for a little longer than this
+	maxTimeSeriesWindow = 24 * time.Hour
+)
+
+// SubmitTaskRequest is the body accepted by POST /api/v1/tasks
+type SubmitTaskRequest struct {
+	// ID is an optional caller supplied task ID, which must be unused
+	ID string `json:"id,omitempty"`
+	// Type is required unless Template gives it
+	Type string `json:"type,omitempty"`
+	// Priority and MaxRetries default to the type's configuration
+	Priority *task.Priority         `json:"priority,omitempty"`
+	Payload  map[string]interface{} `json:"payload,omitempty"`
+	// Version is the payload schema version, defaulting to the current one
+	Version    int               `json:"version,omitempty"`
+	MaxRetries int               `json:"max_retries,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// ParentID submits the task as a child of an existing task
+	ParentID string `json:"parent_id,omitempty"`
+	// PriorityOverride uses Priority as given instead of inheriting the
+	// parent's priority
+	PriorityOverride bool `json:"priority_override,omitempty"`
+	// Deadline is when the task stops being worth running
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// Notify tells the producer when the task finishes
+	Notify *task.Notification `json:"notify,omitempty"`
+	// FairnessKey, such as a customer ID, shares workers fairly between
+	// the keys' tasks of equal priority
+	FairnessKey string `json:"fairness_key,omitempty"`
+	// Weight is how heavy the task is, defaulting to its type's weight
+	Weight int `json:"weight,omitempty"`
+	// Requires lists resource tags, such as gpu=true, that a worker must
+	// advertise to run the task
+	Requires map[string]string `json:"requires,omitempty"`
+	// Template names a task template filling in the type, priority,
+	// retries, payload fields and labels the request leaves out
+	Template string `json:"template,omitempty"`
+
+	// templateRetries is the template's max retries, which may be 0
+	// where MaxRetries would mean the type's default
+	templateRetries *int
+}
+
+// SubmitTaskResponse is returned after a task is accepted
+type SubmitTaskResponse struct {
+	TaskID        string `json:"task_id"`
+	Status        string `json:"status"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// TaskResponse is returned by GET /api/v1/tasks/{id}
+type TaskResponse struct {
+	*task.Task
+	// Position is the task's place in the queue while it is pending, 1
+	// being next
+	Position *int64 `json:"position,omitempty"`
+	// EstimatedStartAt is when a pending task should start at the recent
+	// rate of work, if any finished lately
+	EstimatedStartAt *time.Time `json:"estimated_start_at,omitempty"`
+}
+
+// DryRunResponse is returned by POST /api/v1/tasks?dry_run=true
+type DryRunResponse struct {
+	Plan queue.SubmitPlan `json:"plan"`
+	// Workers counts the live workers handling the task type, when the
+	// server has a cluster registry
+	Workers *int `json:"workers,omitempty"`
+	// Warnings lists reasons the task may not run as planned
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SubmitBatchRequest is the body accepted by POST /api/v1/tasks/batch
+type SubmitBatchRequest struct {
+	Tasks []SubmitTaskRequest `json:"tasks"`
+}
+
+// SubmitBatchResponse is returned after a batch is accepted
+type SubmitBatchResponse struct {
+	TaskIDs       []string `json:"task_ids"`
+	Status        string   `json:"status"`
+	CorrelationID string   `json:"correlation_id,omitempty"`
+}
+
+// TaskStatusRequest is the body of POST /api/v1/tasks/status
+type TaskStatusRequest struct {
+	// IDs are the tasks to report, at most 500
+	IDs []string `json:"ids"`
+	// Wait, such as "30s", waits up to a minute for one of the tasks'
+	// statuses to change before responding. Without it the request
+	// responds at once.
+	Wait string `json:"wait,omitempty"`
+	// Known holds the status the client last saw for each task, which a
+	// wait compares against; tasks left out are compared against their
+	// status when the request arrived
+	Known map[string]task.Status `json:"known,omitempty"`
+}
+
+// Validate checks the request and returns how long it waits
+func (r *TaskStatusRequest) Validate() (time.Duration, error) {
+	if len(r.IDs) == 0 {
+		return 0, errs.Invalidf("ids must list at least one task")
+	}
+	if len(r.IDs) > maxBatchSize {
+		return 0, errs.Invalidf("ids must contain at most %d tasks", maxBatchSize)
+	}
+	if r.Wait == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(r.Wait)
+	if err != nil || wait < 0 || wait > maxLongPollWait {
+		return 0, errs.Invalidf("invalid wait %q (want a duration up to %s)", r.Wait, maxLongPollWait)
+	}
+	return wait, nil
+}
+
+// TaskStatus is one task's entry in a TaskStatusResponse
+type TaskStatus struct {
+	ID          string                 `json:"id"`
+	Status      task.Status            `json:"status"`
+	Error       string                 `json:"error,omitempty"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+}
+
+// TaskStatusResponse is returned by POST /api/v1/tasks/status
+type TaskStatusResponse struct {
+	// Tasks are in the order requested
+	Tasks []TaskStatus `json:"tasks"`
+	// Missing lists the IDs of tasks that do not exist, or have expired
+	Missing []string `json:"missing,omitempty"`
+	// Changed lists the IDs of tasks whose status changed during a wait
+	Changed []string `json:"changed,omitempty"`
+}
+
+// ListTasksResponse is the v1 body returned by GET /api/v1/tasks
+type ListTasksResponse struct {
+	Tasks      []*task.Task `json:"tasks"`
+	Total      int          `json:"total"`
+	Limit      int          `json:"limit"`
+	Status     task.Status  `json:"status"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// TimeSeriesResponse is returned by GET /api/v1/stats/timeseries
+type TimeSeriesResponse struct {
+	Window string            `json:"window"`
+	Step   string            `json:"step"`
+	Points []queue.TimePoint `json:"points"`
+}
+
+// ErrorStatsResponse is returned by GET /api/v1/stats/errors
+type ErrorStatsResponse struct {
+	Window string `json:"window"`
+	// Types maps each task type to its most frequent error signatures,
+	// most frequent first
+	Types map[string][]storage.ErrorCount `json:"types"`
+}
+
+// DuplicateStatsResponse is returned by GET /api/v1/stats/duplicates
+type DuplicateStatsResponse struct {
+	Window string `json:"window"`
+	*queue.DuplicateReport
+}
+
+// ScalingResponse is returned by GET /api/v1/scaling
+type ScalingResponse struct {
+	Queue string `json:"queue,omitempty"`
+	Type  string `json:"type,omitempty"`
+	queue.Backlog
+	// Value is the tasks pending or processing, the metric autoscalers
+	// scale on
+	Value int64 `json:"value"`
+}
+
+// ClusterResponse is returned by GET /api/v1/cluster
+type ClusterResponse struct {
+	Members []ClusterMember `json:"members"`
+	// Leaders maps each election role to the member leading it
+	Leaders map[string]string `json:"leaders"`
+	// Versions maps each task type to the newest payload version every
+	// active worker handling it understands
+	Versions map[string]int `json:"versions"`
+}
+
+// ClusterMember is one API server or worker in the cluster
+type ClusterMember struct {
+	ID        string       `json:"id"`
+	Kind      cluster.Kind `json:"kind"`
+	Version   string       `json:"version"`
+	Host      string       `json:"host,omitempty"`
+	StartedAt time.Time    `json:"started_at"`
+	LastSeen  time.Time    `json:"last_seen"`
+	Uptime    string       `json:"uptime"`
+	Types     []string     `json:"types,omitempty"`
+	Leads     []string     `json:"leads,omitempty"`
+
+	// Versions maps each task type the worker handles to the newest
+	// payload version it understands
+	Versions map[string]int `json:"versions,omitempty"`
+	// Tags are the resource tags the worker advertises
+	Tags map[string]string `json:"tags,omitempty"`
+	// Draining is set while the member finishes its work before stopping
+	Draining bool `json:"draining,omitempty"`
+}
+
+// TypesResponse is returned by GET /api/v1/types
+type TypesResponse struct {
+	Types []TypeInfo `json:"types"`
+}
+
+// TypeInfo describes one task type across the cluster
+type TypeInfo struct {
+	Type string `json:"type"`
+	// Enabled is false while workers hold the type's tasks back
+	Enabled bool `json:"enabled"`
+	// Config is the type's declared defaults on this API server
+	Config *queue.TypeConfig `json:"config,omitempty"`
+	// Workers lists the live workers that handle the type
+	Workers []string `json:"workers"`
+}
+
+// AttemptsResponse is returned by GET /api/v1/tasks/{id}/attempts
+type AttemptsResponse struct {
+	TaskID   string               `json:"task_id"`
+	Attempts []task.AttemptRecord `json:"attempts"`
+	// Failures counts the attempts that failed or were lost, by worker
+	Failures map[string]int `json:"failures"`
+}
+
+// ArtifactsResponse is returned by GET /api/v1/tasks/{id}/artifacts
+type ArtifactsResponse struct {
+	Artifacts []ArtifactLink `json:"artifacts"`
+}
+
+// ArtifactLink describes a task artifact and where to download it
+type ArtifactLink struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// URL downloads the artifact without credentials until ExpiresAt
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TemplateRequest is the body of PUT /api/v1/templates/{name}
+type TemplateRequest struct {
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// RenderTemplateRequest is the body of POST /api/v1/templates/{name}/render
+type RenderTemplateRequest struct {
+	// Version defaults to the latest
+	Version int                    `json:"version,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// TemplatesResponse lists templates, or the versions of one
+type TemplatesResponse struct {
+	Templates []*templates.Template `json:"templates"`
+}
+
+// ScheduleRequest is the body of POST /api/v1/schedules and PUT
+// /api/v1/schedules/{id}
+type ScheduleRequest struct {
+	Name string `json:"name,omitempty"`
+	// Spec is a cron expression such as "0 2 * * *" or "@every 10m"
+	Spec string                `json:"spec"`
+	Task schedule.TaskTemplate `json:"task"`
+	// Timezone is an IANA zone such as "America/New_York", defaulting to
+	// UTC
+	Timezone     string   `json:"timezone,omitempty"`
+	SkipWeekends bool     `json:"skip_weekends,omitempty"`
+	SkipDates    []string `json:"skip_dates,omitempty"`
+	// Jitter is a duration such as "30s"
+	Jitter string `json:"jitter,omitempty"`
+	// Overlap is allow (the default), skip or cancel
+	Overlap schedule.Overlap `json:"overlap,omitempty"`
+	Paused  bool             `json:"paused,omitempty"`
+}
+
+// Schedule returns the schedule the request defines
+func (r *ScheduleRequest) Schedule() (schedule.Schedule, error) {
+	s := schedule.Schedule{
+		Name:         r.Name,
+		Spec:         r.Spec,
+		Task:         r.Task,
+		Timezone:     r.Timezone,
+		SkipWeekends: r.SkipWeekends,
+		SkipDates:    r.SkipDates,
+		Overlap:      r.Overlap,
+		Paused:       r.Paused,
+	}
+	if r.Jitter != "" {
+		jitter, err := time.ParseDuration(r.Jitter)
+		if err != nil {
+			return s, errs.Invalidf("invalid jitter %q", r.Jitter)
+		}
+		s.Jitter = jitter
+	}
+	return s, nil
+}
+
+// TaskTemplatesResponse is returned by GET /api/v1/task-templates
+type TaskTemplatesResponse struct {
+	Templates []*schedule.NamedTemplate `json:"templates"`
+}
+
+// SchedulesResponse is returned by GET /api/v1/schedules
+type SchedulesResponse struct {
+	Schedules []*schedule.Schedule `json:"schedules"`
+}
+
+// BackfillRequest is the body of POST /api/v1/schedules/{id}/backfill
+type BackfillRequest struct {
+	// From and To bound the missed runs, which come after From, up to and
+	// including To. To defaults to now and must not be after it.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to,omitempty"`
+	// Limit caps the runs submitted, defaulting to and at most 500
+	Limit int `json:"limit,omitempty"`
+	// DryRun lists the runs without submitting them
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Validate checks the range and limit, defaulting To to now
+func (req *BackfillRequest) Validate(now time.Time) error {
+	if req.To.IsZero() {
+		req.To = now
+	}
+	switch {
+	case req.From.IsZero():
+		return errs.Invalidf("from is required")
+	case req.To.After(now):
+		return errs.Invalidf("to must not be in the future, where runs are not missed yet")
+	case !req.To.After(req.From):
+		return errs.Invalidf("to must be after from")
+	case req.Limit < 0 || req.Limit > maxBackfillRuns:
+		return errs.Invalidf("limit must be between 1 and %d", maxBackfillRuns)
+	}
+	if req.Limit == 0 {
+		req.Limit = maxBackfillRuns
+	}
+	return nil
+}
+
+// BackfillResponse is returned by POST /api/v1/schedules/{id}/backfill
+type BackfillResponse struct {
+	// Runs are the missed runs, oldest first
+	Runs []time.Time `json:"runs"`
+	// TaskIDs are the tasks submitted for them, in the same order; none
+	// for a dry run
+	TaskIDs []string `json:"task_ids"`
+	// Truncated is set when more runs fell in the range than Limit
+	Truncated bool `json:"truncated"`
+	DryRun    bool `json:"dry_run,omitempty"`
+}
+
+// WorkflowRequest is the body of POST /api/v1/workflows, in JSON or, with
+// a YAML content type, YAML
+type WorkflowRequest struct {
+	Name string `json:"name,omitempty"`
+	// Definition starts the named workflow definition, instead of Steps
+	Definition string `json:"definition,omitempty"`
+	// Input is passed to every step as payload fields
+	Input map[string]interface{} `json:"input,omitempty"`
+	Steps []workflow.Step        `json:"steps,omitempty"`
+}
+
+// Workflow returns the workflow the request defines
+func (r *WorkflowRequest) Workflow() workflow.Workflow {
+	return workflow.Workflow{Name: r.Name, Input: r.Input, Steps: r.Steps}
+}
+
+// DefinitionRequest is the body of
+// PUT /api/v1/workflow-definitions/{name}
+type DefinitionRequest struct {
+	Description string `json:"description,omitempty"`
+	// Input holds defaults for the input of the workflows started from it
+	Input map[string]interface{} `json:"input,omitempty"`
+	Steps []workflow.Step        `json:"steps"`
+}
+
+// DefinitionsResponse is returned by GET /api/v1/workflow-definitions
+type DefinitionsResponse struct {
+	Definitions []*workflow.Definition `json:"definitions"`
+}
+
+// ApprovalRequest is the body of
+// POST /api/v1/workflows/{id}/steps/{step}/approve
+type ApprovalRequest struct {
+	// Decision is approve or reject
+	Decision workflow.Decision `json:"decision"`
+	// By and Comment are recorded with the decision
+	By      string `json:"by,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// WorkflowsResponse is returned by GET /api/v1/workflows
+type WorkflowsResponse struct {
+	Workflows []*workflow.Workflow `json:"workflows"`
+}
+
+// maxLongPollWait caps how long a request waits for something to happen,
+// such as a task to lease or a status to change
+const maxLongPollWait = time.Minute
+
+// LeaseRequest is the body of POST /api/v1/leases
+type LeaseRequest struct {
+	// WorkerID names the remote worker in logs
+	WorkerID string `json:"worker_id"`
+	// Types are the task types the worker runs, the first listed taking
+	// precedence
+	Types []string `json:"types"`
+	// Wait is how long to wait for a task, such as "20s", at most a
+	// minute. Without it the request returns at once.
+	Wait string `json:"wait,omitempty"`
+}
+
+// Validate checks the request and returns how long it waits
+func (r *LeaseRequest) Validate() (time.Duration, error) {
+	if r.WorkerID == "" {
+		return 0, errs.Invalidf("worker_id is required")
+	}
+	if len(r.Types) == 0 {
+		return 0, errs.Invalidf("types must list at least one task type")
+	}
+	if r.Wait == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(r.Wait)
+	if err != nil || wait < 0 || wait > maxLongPollWait {
+		return 0, errs.Invalidf("invalid wait %q (want a duration up to %s)", r.Wait, maxLongPollWait)
+	}
+	return wait, nil
+}
+
+// AckRequest is the body of POST /api/v1/leases/{id}/ack
+type AckRequest struct {
+	// Result is saved as the task's result
+	Result map[string]interface{} `json:"result,omitempty"`
+}
+
+// NackRequest is the body of POST /api/v1/leases/{id}/nack
+type NackRequest struct {
+	Error string `json:"error"`
+	// Permanent fails the task at once instead of retrying it
+	Permanent bool `json:"permanent,omitempty"`
+	// RetryAfter is a duration such as "30s" the retry waits at least
+	RetryAfter string `json:"retry_after,omitempty"`
+}
+
+// Nack returns the failure the request reports
+func (r *NackRequest) Nack() (queue.Nack, error) {
+	n := queue.Nack{Error: r.Error, Permanent: r.Permanent}
+	if r.RetryAfter != "" {
+		delay, err := time.ParseDuration(r.RetryAfter)
+		if err != nil || delay < 0 {
+			return n, errs.Invalidf("invalid retry_after %q", r.RetryAfter)
+		}
+		n.RetryAfter = delay
+	}
+	return n, nil
+}
+
+// LogLevel is the body of GET and PUT /api/v1/admin/log-level
+type LogLevel struct {
+	// Level is one of debug, info, warn or error
+	Level string `json:"level"`
+}
+
+// ErrorResponse is returned for every failed request
+type ErrorResponse struct {
+	Error string    `json:"error"`
+	Code  errs.Code `json:"code"`
+	// TaskIDs and FailedIndex are set when a batch stops partway: the
+	// tasks before FailedIndex were queued with TaskIDs, and it and the
+	// ones after it were not
+	TaskIDs     []string `json:"task_ids,omitempty"`
+	FailedIndex *int     `json:"failed_index,omitempty"`
+}
+
+// HealthResponse is returned by the health check
+type HealthResponse struct {
+	Status string `json:"status"`
+	// UnhealthyHandlers holds the error of each task type whose handler
+	// fails its health check in this process, while its tasks are paused
+	UnhealthyHandlers map[string]string `json:"unhealthy_handlers,omitempty"`
+}
+
+// Validate checks the request against the submission rules
+func (req *SubmitTaskRequest) Validate(maxPayloadBytes int) error {
+	if req.ID != "" && !validTaskID(req.ID) {
+		return errs.Invalidf("id must be 1-%d letters, digits or -_.:", maxTaskIDLength)
+	}
+	if req.Type == "" {
+		return errs.Invalidf("task type is required")
+	}
+	if len(req.Type) > maxTaskTypeLength {
+		return errs.Invalidf("task type must be at most %d characters", maxTaskTypeLength)
+	}
+	if req.Priority != nil && !req.Priority.Valid() {
+		return errs.Invalidf("priority must be between %d and %d", task.PriorityMin, task.PriorityMax)
+	}
+	if req.Version < 0 {
+		return errs.Invalidf("version must not be negative")
+	}
+	if req.MaxRetries < 0 || req.MaxRetries > maxRetriesLimit {
+		return errs.Invalidf("max_retries must be between 0 and %d", maxRetriesLimit)
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		return err
+	}
+	if err := validateRequires(req.Requires); err != nil {
+		return err
+	}
+	if req.Weight < 0 || req.Weight > maxTaskWeight {
+		return errs.Invalidf("weight must be between 0 and %d", maxTaskWeight)
+	}
+	if req.FairnessKey != "" && !validLabelText(req.FairnessKey) {
+		return errs.Invalidf("fairness_key must be 1-%d letters, digits or -_./", maxLabelLength)
+	}
+	if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		return errs.Invalidf("deadline must be in the future")
+	}
+	if req.Notify != nil {
+		if err := req.Notify.Validate(); err != nil {
+			return errs.Invalidf("%s", err.Error())
+		}
+	}
+	if maxPayloadBytes > 0 && req.Payload != nil {
+		data, err := json.Marshal(req.Payload)
+		if err != nil {
+			return errs.Invalidf("payload is not serializable")
+		}
+		if len(data) > maxPayloadBytes {
+			return errs.Invalidf("payload exceeds %d bytes", maxPayloadBytes)
+		}
+	}
+	return nil
+}
+
+// Validate checks every task in the batch, reporting the first bad one
+func (req *SubmitBatchRequest) Validate(maxPayloadBytes int) error {
+	if len(req.Tasks) == 0 {
+		return errs.Invalidf("tasks must not be empty")
+	}
+	if len(req.Tasks) > maxBatchSize {
+		return errs.Invalidf("batch must contain at most %d tasks", maxBatchSize)
+	}
+	ids := make(map[string]bool)
+	for i := range req.Tasks {
+		if err := req.Tasks[i].Validate(maxPayloadBytes); err != nil {
+			return errs.Invalidf("tasks[%d]: %s", i, err.Error())
+		}
+		if id := req.Tasks[i].ID; id != "" {
+			if ids[id] {
+				return errs.Invalidf("tasks[%d]: id %q is used more than once", i, id)
+			}
+			ids[id] = true
+		}
+	}
+	return nil
+}
+
+// validTaskID reports whether id is safe to use as a task ID in storage
+// keys and URLs
+func validTaskID(id string) bool {
+	if len(id) > maxTaskIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateLabels checks label keys and values are short and use only
+// letters, digits and "-_./", so they are safe in selectors and metrics
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return errs.Invalidf("at most %d labels are allowed", maxLabels)
+	}
+	for k, v := range labels {
+		if !validLabelText(k) {
+			return errs.Invalidf("label key %q must be 1-%d letters, digits or -_./", k, maxLabelLength)
+		}
+		if !validLabelText(v) {
+			return errs.Invalidf("label %q value must be 1-%d letters, digits or -_./", k, maxLabelLength)
+		}
+	}
+	return nil
+}
+
+// validateRequires checks required resource tags follow the label rules
+func validateRequires(requires map[string]string) error {
+	if len(requires) > maxLabels {
+		return errs.Invalidf("at most %d required tags are allowed", maxLabels)
+	}
+	for k, v := range requires {
+		if !validLabelText(k) {
+			return errs.Invalidf("required tag %q must be 1-%d letters, digits or -_./", k, maxLabelLength)
+		}
+		if !validLabelText(v) {
+			return errs.Invalidf("required tag %q value must be 1-%d letters, digits or -_./", k, maxLabelLength)
+		}
+	}
+	return nil
+}
+
+// validLabelText reports whether s is a valid label key or value
+func validLabelText(s string) bool {
+	if s == "" || len(s) > maxLabelLength {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseLabelSelector parses a selector like "team=payments,env=prod"
+func parseLabelSelector(selector string) (map[string]string, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(selector, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errs.Invalidf("label selector must be key=value pairs separated by commas")
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// parseTimeSeriesParams reads the window and step query parameters. The
+// step defaults to about 60 points across the window.
+func parseTimeSeriesParams(r *http.Request) (window, step time.Duration, err error) {
+	window = time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window < time.Minute || window > maxTimeSeriesWindow {
+			return 0, 0, errs.Invalidf("window must be a duration between 1m and %s", maxTimeSeriesWindow)
+		}
+	}
+	window = window.Truncate(time.Minute)
+
+	step = (window / 60).Truncate(time.Minute)
+	if v := r.URL.Query().Get("step"); v != "" {
+		step, err = time.ParseDuration(v)
+		if err != nil || step < time.Minute || step > window {
+			return 0, 0, errs.Invalidf("step must be a duration between 1m and the window")
+		}
+		step = step.Truncate(time.Minute)
+	}
+	if step < time.Minute {
+		step = time.Minute
+	}
+	return window, step, nil
+}
+
+// parseErrorStatsParams reads the window and limit query parameters of
+// the error stats endpoint. The window defaults to an hour and the limit,
+// per task type, to 10.
+func parseErrorStatsParams(r *http.Request) (window time.Duration, limit int, err error) {
+	window = time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window < time.Minute || window > maxTimeSeriesWindow {
+			return 0, 0, errs.Invalidf("window must be a duration between 1m and %s", maxTimeSeriesWindow)
+		}
+	}
+	limit = 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 100 {
+			return 0, 0, errs.Invalidf("limit must be between 1 and 100")
+		}
+	}
+	return window.Truncate(time.Minute), limit, nil
+}
+
+// decodeJSON strictly decodes a single JSON object from the request body,
+// rejecting unknown fields and trailing data
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var sizeErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &sizeErr):
+			return errs.ErrPayloadTooLarge
+		case errors.Is(err, io.EOF):
+			return errs.Invalidf("request body is empty")
+		case errors.As(err, &syntaxErr):
+			return errs.Invalidf("invalid request body: malformed JSON at offset %d", syntaxErr.Offset)
+		case errors.As(err, &typeErr):
+			return errs.Invalidf("invalid request body: field %q must be %s", typeErr.Field, typeErr.Type)
+		case errors.Is(err, task.ErrInvalidPriority):
+			return errs.Invalidf("invalid request body: %v", err)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return errs.Invalidf("invalid request body: %s", strings.TrimPrefix(err.Error(), "json: "))
+		default:
+			return errs.Invalidf("invalid request body")
+		}
+	}
+
+	if dec.More() {
+		return errs.Invalidf("invalid request body: unexpected data after JSON object")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily period, in UTC, during which a task type may run.
+// A window whose end is before its start wraps past midnight.
+type Window struct {
+	// Start and End are offsets from midnight UTC
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window such as "01:00-05:00"
+func ParseWindow(s string) (Window, error) {
+	startText, endText, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q: want HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(startText)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	end, err := parseClock(endText)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if start == end {
+		return Window{}, fmt.Errorf("invalid window %q: start and end are equal", s)
+	}
+	return Window{Start: start, End: end}, nil
+}
+
+// ParseWindows parses per-type windows such as
+// "batch_process=01:00-05:00,export_data=22:00-02:00"
+func ParseWindows(s string) (map[string]Window, error) {
+	windows := make(map[string]Window)
+	if strings.TrimSpace(s) == "" {
+		return windows, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		taskType, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || taskType == "" {
+			return nil, fmt.Errorf("invalid window entry %q: want type=HH:MM-HH:MM", entry)
+		}
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows[taskType] = w
+	}
+	return windows, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w Window) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Next returns t if it is inside the window, otherwise when the window
+// next opens
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	t = t.UTC()
+	midnight := t.Truncate(24 * time.Hour)
+	open := midnight.Add(w.Start)
+	if open.Before(t) {
+		open = open.Add(24 * time.Hour)
+	}
+	return open
+}
+
+// String formats the window as HH:MM-HH:MM
+func (w Window) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+// sinceMidnight returns how far t is into its UTC day
+func sinceMidnight(t time.Time) time.Duration {
+	t = t.UTC()
+	return t.Sub(t.Truncate(24 * time.Hour))
+}
@@ -0,0 +1,141 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: worker.proto
+
+package workerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Worker_Work_FullMethodName = "/dtq.worker.v1.Worker/Work"
+)
+
+// WorkerClient is the client API for Worker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WorkerClient interface {
+	Work(ctx context.Context, opts ...grpc.CallOption) (Worker_WorkClient, error)
+}
+
+type workerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWorkerClient(cc grpc.ClientConnInterface) WorkerClient {
+	return &workerClient{cc}
+}
+
+func (c *workerClient) Work(ctx context.Context, opts ...grpc.CallOption) (Worker_WorkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Worker_ServiceDesc.Streams[0], Worker_Work_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &workerWorkClient{stream}
+	return x, nil
+}
+
+type Worker_WorkClient interface {
+	Send(*WorkerMessage) error
+	Recv() (*BrokerMessage, error)
+	grpc.ClientStream
+}
+
+type workerWorkClient struct {
+	grpc.ClientStream
+}
+
+func (x *workerWorkClient) Send(m *WorkerMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *workerWorkClient) Recv() (*BrokerMessage, error) {
+	m := new(BrokerMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WorkerServer is the server API for Worker service.
+// All implementations must embed UnimplementedWorkerServer
+// for forward compatibility
+type WorkerServer interface {
+	Work(Worker_WorkServer) error
+	mustEmbedUnimplementedWorkerServer()
+}
+
+// UnimplementedWorkerServer must be embedded to have forward compatible implementations.
+type UnimplementedWorkerServer struct {
+}
+
+func (UnimplementedWorkerServer) Work(Worker_WorkServer) error {
+	return status.Errorf(codes.Unimplemented, "method Work not implemented")
+}
+func (UnimplementedWorkerServer) mustEmbedUnimplementedWorkerServer() {}
+
+// UnsafeWorkerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WorkerServer will
+// result in compilation errors.
+type UnsafeWorkerServer interface {
+	mustEmbedUnimplementedWorkerServer()
+}
+
+func RegisterWorkerServer(s grpc.ServiceRegistrar, srv WorkerServer) {
+	s.RegisterService(&Worker_ServiceDesc, srv)
+}
+
+func _Worker_Work_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WorkerServer).Work(&workerWorkServer{stream})
+}
+
+type Worker_WorkServer interface {
+	Send(*BrokerMessage) error
+	Recv() (*WorkerMessage, error)
+	grpc.ServerStream
+}
+
+type workerWorkServer struct {
+	grpc.ServerStream
+}
+
+func (x *workerWorkServer) Send(m *BrokerMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *workerWorkServer) Recv() (*WorkerMessage, error) {
+	m := new(WorkerMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Worker_ServiceDesc is the grpc.ServiceDesc for Worker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Worker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dtq.worker.v1.Worker",
+	HandlerType: (*WorkerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Work",
+			Handler:       _Worker_Work_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "worker.proto",
+}
@@ -0,0 +1,365 @@
+// Package workflow runs DAGs of tasks. A workflow lists steps, each a task
+// template with the steps it waits for; the engine submits a step's tasks
+// once the steps it depends on have finished, records their outcomes, and
+// finishes the workflow when no step is left to run. Workflows are kept in
+// storage and managed through the API.
+package workflow
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Label is the task label recording which workflow submitted a task
+	Label = "workflow"
+	// StepLabel is the task label recording which step of its workflow a
+	// task runs
+	StepLabel = "workflow_step"
+	// ItemField is the payload field holding a fanned-out task's item
+	ItemField = "item"
+
+	maxSteps  = 100
+	maxFanOut = 1000
+)
+
+// Status is the state of a whole workflow
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	// StatusCompensating workflows have failed and are running their
+	// completed steps' compensation tasks, latest first
+	StatusCompensating Status = "compensating"
+	StatusCompleted    Status = "completed"
+	StatusFailed       Status = "failed"
+	StatusCancelled    Status = "cancelled"
+)
+
+// StepStatus is the state of one step of a workflow
+type StepStatus string
+
+const (
+	// StepPending steps wait for the steps they depend on
+	StepPending StepStatus = "pending"
+	StepRunning StepStatus = "running"
+	// StepWaiting manual approval steps wait for someone to approve or
+	// reject them
+	StepWaiting   StepStatus = "waiting"
+	StepCompleted StepStatus = "completed"
+	StepFailed    StepStatus = "failed"
+	// StepSkipped steps did not run, as their trigger did not fire or
+	// their condition was false
+	StepSkipped   StepStatus = "skipped"
+	StepCancelled StepStatus = "cancelled"
+)
+
+// Trigger decides which outcomes of the steps a step depends on let it run
+type Trigger string
+
+const (
+	// TriggerSuccess runs the step once every step it depends on has
+	// completed. This is the default.
+	TriggerSuccess Trigger = "success"
+	// TriggerFailure runs the step once the steps it depends on have
+	// finished, if any of them failed, such as to clean up or notify
+	TriggerFailure Trigger = "failure"
+	// TriggerAlways runs the step once the steps it depends on have
+	// finished, however they ended
+	TriggerAlways Trigger = "always"
+)
+
+// StepType is what a step does
+type StepType string
+
+const (
+	// TypeTask steps submit a task. This is the default.
+	TypeTask StepType = "task"
+	// TypeManualApproval steps submit nothing: they wait until someone
+	// approves or rejects them, or their approval times out
+	TypeManualApproval StepType = "manual_approval"
+	// TypeWorkflow steps start a child workflow from a named Definition
+	// and finish as it does
+	TypeWorkflow StepType = "workflow"
+)
+
+// Step is one node of a workflow's DAG
+type Step struct {
+	// Name identifies the step within its workflow
+	Name string `json:"name"`
+	// Type is what the step does; empty means TypeTask
+	Type StepType `json:"type,omitempty"`
+	// Task is the task a TypeTask step submits
+	Task schedule.TaskTemplate `json:"task"`
+	// Approval configures a TypeManualApproval step's timeout and
+	// escalation
+	Approval *Approval `json:"approval,omitempty"`
+	// Workflow names the Definition a TypeWorkflow step starts
+	Workflow string `json:"workflow,omitempty"`
+	// Input is added to the input a TypeWorkflow step's child inherits
+	Input map[string]interface{} `json:"input,omitempty"`
+	// Compensate is the task that undoes the step once it has completed,
+	// run if the workflow later fails. Its payload also holds the
+	// workflow's input and the step's result, as "result".
+	Compensate *schedule.TaskTemplate `json:"compensate,omitempty"`
+	// DependsOn names the steps that must finish before this one runs
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Trigger decides which of their outcomes let it run; empty means
+	// TriggerSuccess
+	Trigger Trigger `json:"trigger,omitempty"`
+	// ForEach fans the step out: it names a field of the workflow's input
+	// holding a list, and the step submits one task per element, given in
+	// the payload's "item" field. The step completes once they all have.
+	ForEach string `json:"for_each,omitempty"`
+	// If is a condition checked once the trigger fires, such as
+	// `result.size > 1GB`; the step is skipped when it is false. It may
+	// refer to input, to steps.<name>.status, .result and .error of the
+	// steps it depends on, and, with a single dependency, to that step's
+	// status, result and error directly.
+	If string `json:"if,omitempty"`
+}
+
+// Workflow is a DAG of steps and the progress of its run
+type Workflow struct {
+	ID string `json:"id"`
+	// Name describes the workflow for people
+	Name string `json:"name,omitempty"`
+	// Input is passed to every step, as the payload fields the step's
+	// template does not set
+	Input map[string]interface{} `json:"input,omitempty"`
+	Steps []Step                 `json:"steps"`
+
+	Status Status `json:"status"`
+	// State holds each step's progress, by name
+	State map[string]*StepState `json:"state"`
+
+	// ParentID and ParentStep are the workflow and step that started this
+	// one as a child
+	ParentID   string `json:"parent_id,omitempty"`
+	ParentStep string `json:"parent_step,omitempty"`
+	// CorrelationID is shared by the tasks of the workflow and of its
+	// children; empty means the workflow's ID
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Definitions holds the definitions the workflow's workflow steps, and
+	// theirs in turn, start, as they were when it started
+	Definitions map[string]*Definition `json:"definitions,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// StepState is the progress of one step
+type StepState struct {
+	Status StepStatus `json:"status"`
+	// Tasks lists the tasks the step submitted, one unless it fans out
+	Tasks []StepTask `json:"tasks,omitempty"`
+	// Approval is the progress of a manual approval step
+	Approval *ApprovalState `json:"approval,omitempty"`
+	// Compensation is the outcome of the step's compensation task, once
+	// the workflow's failure started it
+	Compensation *StepTask `json:"compensation,omitempty"`
+	// Child is the workflow a workflow step started
+	Child      *Child     `json:"child,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// StepTask is the outcome of one task of a step, set once it finishes
+type StepTask struct {
+	ID     string                 `json:"id"`
+	Status task.Status            `json:"status"`
+	Result map[string]interface{} `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// Child is the progress of a workflow step's child workflow
+type Child struct {
+	// ID is empty if the child could not be started
+	ID     string `json:"id,omitempty"`
+	Status Status `json:"status"`
+	// Result holds the results of the child's completed steps, by name
+	Result map[string]interface{} `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// correlationID is the correlation ID of the workflow's tasks
+func (w *Workflow) correlationID() string {
+	if w.CorrelationID != "" {
+		return w.CorrelationID
+	}
+	return w.ID
+}
+
+// step returns the step called name
+func (w *Workflow) step(name string) (Step, bool) {
+	for _, s := range w.Steps {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Step{}, false
+}
+
+// Validate checks the workflow's steps and that they form a DAG
+func (w *Workflow) Validate() error {
+	if len(w.Steps) == 0 {
+		return errs.Invalidf("a workflow needs at least one step")
+	}
+	if len(w.Steps) > maxSteps {
+		return errs.Invalidf("a workflow can have at most %d steps", maxSteps)
+	}
+
+	names := make(map[string]bool, len(w.Steps))
+	for _, s := range w.Steps {
+		if s.Name == "" {
+			return errs.Invalidf("every step needs a name")
+		}
+		if names[s.Name] {
+			return errs.Invalidf("step %q is defined twice", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	for _, s := range w.Steps {
+		switch s.Type {
+		case "", TypeTask:
+			if s.Task.Type == "" {
+				return errs.Invalidf("step %q: task type is required", s.Name)
+			}
+		case TypeManualApproval:
+			if s.Task.Type != "" || s.ForEach != "" || s.Compensate != nil {
+				return errs.Invalidf("step %q: a %s step takes no task, for_each or compensate", s.Name, TypeManualApproval)
+			}
+			if err := s.Approval.validate(); err != nil {
+				return errs.Invalidf("step %q: %v", s.Name, err)
+			}
+		case TypeWorkflow:
+			if s.Workflow == "" {
+				return errs.Invalidf("step %q: a %s step needs the name of the workflow to start", s.Name, TypeWorkflow)
+			}
+			if s.Task.Type != "" || s.ForEach != "" {
+				return errs.Invalidf("step %q: a %s step takes no task or for_each", s.Name, TypeWorkflow)
+			}
+		default:
+			return errs.Invalidf("step %q: type must be %s, %s or %s", s.Name, TypeTask, TypeManualApproval, TypeWorkflow)
+		}
+		if s.Approval != nil && s.Type != TypeManualApproval {
+			return errs.Invalidf("step %q: only a %s step takes approval settings", s.Name, TypeManualApproval)
+		}
+		if (s.Workflow != "" || s.Input != nil) && s.Type != TypeWorkflow {
+			return errs.Invalidf("step %q: only a %s step takes workflow and input", s.Name, TypeWorkflow)
+		}
+
+		switch {
+		case s.Task.Priority != nil && !s.Task.Priority.Valid():
+			return errs.Invalidf("step %q: priority must be between %d and %d", s.Name, task.PriorityMin, task.PriorityMax)
+		case s.Task.MaxRetries != nil && (*s.Task.MaxRetries < 0 || *s.Task.MaxRetries > 100):
+			return errs.Invalidf("step %q: max_retries must be between 0 and 100", s.Name)
+		case s.Trigger != "" && s.Trigger != TriggerSuccess && s.Trigger != TriggerFailure && s.Trigger != TriggerAlways:
+			return errs.Invalidf("step %q: trigger must be success, failure or always", s.Name)
+		case s.Trigger != "" && s.Trigger != TriggerSuccess && len(s.DependsOn) == 0:
+			return errs.Invalidf("step %q: trigger %s needs depends_on", s.Name, s.Trigger)
+		}
+		for _, dep := range s.DependsOn {
+			if dep == s.Name || !names[dep] {
+				return errs.Invalidf("step %q depends on unknown step %q", s.Name, dep)
+			}
+		}
+		if s.ForEach != "" {
+			items, ok := w.Input[s.ForEach].([]interface{})
+			if !ok {
+				return errs.Invalidf("step %q: input field %q must be a list", s.Name, s.ForEach)
+			}
+			if len(items) > maxFanOut {
+				return errs.Invalidf("step %q: can fan out to at most %d tasks", s.Name, maxFanOut)
+			}
+		}
+		if c := s.Compensate; c != nil {
+			switch {
+			case c.Type == "":
+				return errs.Invalidf("step %q: compensate needs a task type", s.Name)
+			case c.Priority != nil && !c.Priority.Valid():
+				return errs.Invalidf("step %q: compensate priority must be between %d and %d", s.Name, task.PriorityMin, task.PriorityMax)
+			case c.MaxRetries != nil && (*c.MaxRetries < 0 || *c.MaxRetries > 100):
+				return errs.Invalidf("step %q: compensate max_retries must be between 0 and 100", s.Name)
+			}
+		}
+		if err := checkCondition(s); err != nil {
+			return errs.Invalidf("step %q: invalid condition: %v", s.Name, err)
+		}
+	}
+	return w.checkAcyclic()
+}
+
+// checkAcyclic fails if the steps' dependencies form a cycle
+func (w *Workflow) checkAcyclic() error {
+	waiting := make(map[string]int, len(w.Steps))
+	dependents := make(map[string][]string)
+	for _, s := range w.Steps {
+		waiting[s.Name] = len(s.DependsOn)
+		for _, dep := range s.DependsOn {
+			dependents[dep] = append(dependents[dep], s.Name)
+		}
+	}
+
+	var ready []string
+	for _, s := range w.Steps {
+		if waiting[s.Name] == 0 {
+			ready = append(ready, s.Name)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, next := range dependents[name] {
+			if waiting[next]--; waiting[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if visited < len(w.Steps) {
+		return errs.Invalidf("step dependencies form a cycle")
+	}
+	return nil
+}
+
+// NewWorkflow returns a running workflow with a new ID and every step
+// pending. Start it with Engine.Start.
+func NewWorkflow(now time.Time, w Workflow) (*Workflow, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	w.ID = uuid.NewString()
+	w.Status = StatusRunning
+	w.State = make(map[string]*StepState, len(w.Steps))
+	for _, s := range w.Steps {
+		w.State[s.Name] = &StepState{Status: StepPending}
+	}
+	w.CreatedAt = now.UTC()
+	w.UpdatedAt = w.CreatedAt
+	w.FinishedAt = nil
+	return &w, nil
+}
+
+// YAMLToJSON converts a workflow definition written in YAML, or JSON,
+// which YAML includes, to JSON
+func YAMLToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, errs.Invalidf("invalid YAML: %v", err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, errs.Invalidf("invalid YAML: mapping keys must be strings")
+	}
+	return out, nil
+}
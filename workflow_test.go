@@ -0,0 +1,545 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/storage"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// finishStep moves every task of a workflow step to status and tells the
+// engine, as a worker would
+func finishStep(t *testing.T, e *Engine, store storage.Storage, w *Workflow, step string, status task.Status) *Workflow {
+	t.Helper()
+	ctx := context.Background()
+	w, err := e.Get(ctx, w.ID)
+	require.NoError(t, err)
+	for _, st := range w.State[step].Tasks {
+		tk, err := store.GetTask(ctx, st.ID)
+		require.NoError(t, err)
+		tk.Status = status
+		if status == task.StatusFailed {
+			tk.Error = "boom"
+		}
+		e.OnFinish(ctx, tk)
+	}
+	w, err = e.Get(ctx, w.ID)
+	require.NoError(t, err)
+	return w
+}
+
+func taskTemplate(taskType string) schedule.TaskTemplate {
+	return schedule.TaskTemplate{Type: taskType}
+}
+
+func newTestEngine() (*Engine, storage.Storage) {
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+	return NewEngine(Config{Store: NewMemoryStore(), Queue: q, Logger: zap.NewNop()}), store
+}
+
+func TestEngine_RunsDAG(t *testing.T) {
+	e, store := newTestEngine()
+	ctx := context.Background()
+
+	def, err := YAMLToJSON([]byte(`
+name: nightly export
+input:
+  files: [a.csv, b.csv]
+steps:
+  - name: export
+    task: {type: export_data, max_retries: 1}
+  - name: upload
+    depends_on: [export]
+    for_each: files
+    task: {type: upload, payload: {bucket: reports}}
+  - name: notify_failure
+    depends_on: [export, upload]
+    trigger: failure
+    task: {type: send_email}
+  - name: cleanup
+    depends_on: [upload]
+    trigger: always
+    task: {type: cleanup}
+`))
+	require.NoError(t, err)
+	var w Workflow
+	require.NoError(t, json.Unmarshal(def, &w))
+
+	started, err := e.Start(ctx, w)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, started.Status)
+	assert.Equal(t, StepRunning, started.State["export"].Status)
+	assert.Equal(t, StepPending, started.State["upload"].Status)
+	require.Len(t, started.State["export"].Tasks, 1)
+
+	exported, err := store.GetTask(ctx, started.State["export"].Tasks[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, exported.MaxRetries)
+	assert.Equal(t, started.ID, exported.Labels[Label])
+	assert.Equal(t, "export", exported.Labels[StepLabel])
+	assert.Equal(t, started.ID, exported.CorrelationID)
+
+	w1 := finishStep(t, e, store, started, "export", task.StatusCompleted)
+	assert.Equal(t, StepCompleted, w1.State["export"].Status)
+	require.Len(t, w1.State["upload"].Tasks, 2)
+	upload, err := store.GetTask(ctx, w1.State["upload"].Tasks[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "b.csv", upload.Payload[ItemField])
+	assert.Equal(t, "reports", upload.Payload["bucket"])
+
+	w2 := finishStep(t, e, store, w1, "upload", task.StatusCompleted)
+	assert.Equal(t, StepSkipped, w2.State["notify_failure"].Status)
+	assert.Equal(t, StepRunning, w2.State["cleanup"].Status)
+
+	w3 := finishStep(t, e, store, w2, "cleanup", task.StatusCompleted)
+	assert.Equal(t, StatusCompleted, w3.Status)
+	assert.NotNil(t, w3.FinishedAt)
+}
+
+func TestEngine_ResumeFromFailedStep(t *testing.T) {
+	e, store := newTestEngine()
+	ctx := context.Background()
+
+	started, err := e.Start(ctx, Workflow{Steps: []Step{
+		{Name: "generate", Task: taskTemplate("generate_report")},
+		{Name: "send", DependsOn: []string{"generate"}, Task: taskTemplate("send_email")},
+		{Name: "alert", DependsOn: []string{"send"}, Trigger: TriggerFailure, Task: taskTemplate("page")},
+	}})
+	require.NoError(t, err)
+
+	w := finishStep(t, e, store, started, "generate", task.StatusCompleted)
+	w = finishStep(t, e, store, w, "send", task.StatusFailed)
+	assert.Equal(t, StepFailed, w.State["send"].Status)
+	assert.Equal(t, "boom", w.State["send"].Tasks[0].Error)
+	assert.Equal(t, StepRunning, w.State["alert"].Status)
+	w = finishStep(t, e, store, w, "alert", task.StatusCompleted)
+	assert.Equal(t, StatusFailed, w.Status)
+
+	_, err = e.Cancel(ctx, w.ID)
+	assert.Error(t, err)
+
+	resumed, err := e.Resume(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, resumed.Status)
+	assert.Equal(t, StepCompleted, resumed.State["generate"].Status)
+	assert.Equal(t, StepRunning, resumed.State["send"].Status)
+	assert.NotEqual(t, w.State["send"].Tasks[0].ID, resumed.State["send"].Tasks[0].ID)
+
+	w = finishStep(t, e, store, resumed, "send", task.StatusCompleted)
+	assert.Equal(t, StepCompleted, w.State["alert"].Status, "a completed step keeps its outcome")
+	assert.Equal(t, StatusCompleted, w.Status)
+
+	// A late duplicate of a recorded outcome changes nothing
+	w = finishStep(t, e, store, w, "send", task.StatusFailed)
+	assert.Equal(t, StepCompleted, w.State["send"].Status)
+}
+
+func TestEngine_Cancel(t *testing.T) {
+	e, store := newTestEngine()
+	ctx := context.Background()
+
+	started, err := e.Start(ctx, Workflow{Steps: []Step{
+		{Name: "export", Task: taskTemplate("export_data")},
+		{Name: "upload", DependsOn: []string{"export"}, Task: taskTemplate("upload")},
+	}})
+	require.NoError(t, err)
+
+	cancelled, err := e.Cancel(ctx, started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, cancelled.Status)
+	assert.Equal(t, StepCancelled, cancelled.State["upload"].Status)
+
+	exported, err := store.GetTask(ctx, started.State["export"].Tasks[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusCancelled, exported.Status)
+}
+
+func TestWorkflow_Validate(t *testing.T) {
+	for name, w := range map[string]Workflow{
+		"no steps":       {},
+		"unnamed step":   {Steps: []Step{{Task: taskTemplate("a")}}},
+		"duplicate step": {Steps: []Step{{Name: "a", Task: taskTemplate("a")}, {Name: "a", Task: taskTemplate("a")}}},
+		"no type":        {Steps: []Step{{Name: "a"}}},
+		"unknown dep":    {Steps: []Step{{Name: "a", DependsOn: []string{"b"}, Task: taskTemplate("a")}}},
+		"bad trigger":    {Steps: []Step{{Name: "a", Trigger: "sometimes", Task: taskTemplate("a")}}},
+		"not a list":     {Input: map[string]interface{}{"files": "a.csv"}, Steps: []Step{{Name: "a", ForEach: "files", Task: taskTemplate("a")}}},
+		"cycle": {Steps: []Step{
+			{Name: "a", DependsOn: []string{"c"}, Task: taskTemplate("a")},
+			{Name: "b", DependsOn: []string{"a"}, Task: taskTemplate("b")},
+			{Name: "c", DependsOn: []string{"b"}, Task: taskTemplate("c")},
+		}},
+	} {
+		assert.Error(t, w.Validate(), name)
+	}
+
+	_, err := YAMLToJSON([]byte("steps: [unclosed"))
+	assert.Error(t, err)
+}
+
+func TestEngine_ConditionalSteps(t *testing.T) {
+	e, store := newTestEngine()
+	ctx := context.Background()
+
+	started, err := e.Start(ctx, Workflow{Input: map[string]interface{}{"region": "eu"}, Steps: []Step{
+		{Name: "export", Task: taskTemplate("export_data")},
+		{Name: "compress", DependsOn: []string{"export"}, If: "result.size > 1GB", Task: taskTemplate("compress")},
+		{Name: "upload", DependsOn: []string{"export"}, If: `!(result.size > 1GB) && input.region == "eu"`, Task: taskTemplate("upload")},
+		{Name: "notify_failure", DependsOn: []string{"export"}, Trigger: TriggerAlways, If: `status == "failed"`, Task: taskTemplate("send_email")},
+	}})
+	require.NoError(t, err)
+
+	exported, err := store.GetTask(ctx, started.State["export"].Tasks[0].ID)
+	require.NoError(t, err)
+	exported.Status = task.StatusCompleted
+	exported.Result = map[string]interface{}{"size": float64(512 << 20)}
+	e.OnFinish(ctx, exported)
+
+	w, err := e.Get(ctx, started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StepSkipped, w.State["compress"].Status)
+	assert.Equal(t, StepRunning, w.State["upload"].Status)
+	assert.Equal(t, StepSkipped, w.State["notify_failure"].Status)
+}
+
+func TestCondition(t *testing.T) {
+	env := map[string]interface{}{
+		"input": map[string]interface{}{"files": []interface{}{"a.csv"}, "name": "nightly"},
+		"steps": map[string]interface{}{
+			"export": map[string]interface{}{"status": "completed", "result": map[string]interface{}{"size": 2048, "ok": true}},
+		},
+	}
+	for src, want := range map[string]bool{
+		`steps.export.result.size >= 2KB`:                 true,
+		`steps.export.result.size > 1.5kb && input.files`: true,
+		`steps.export.status == "failed"`:                 false,
+		`steps.export.status != 'failed'`:                 true,
+		`input.files.0 == "a.csv"`:                        true,
+		`input.files.5 == null`:                           true,
+		`input.missing`:                                   false,
+		`!steps.export.result.ok || input.name < "z"`:     true,
+		`input.name > 3`:                                  false,
+		`(1 == 1) && !(2 < 1)`:                            true,
+	} {
+		e, err := parseCondition(src)
+		require.NoError(t, err, src)
+		assert.Equal(t, want, truthy(e.eval(env)), src)
+	}
+
+	for _, src := range []string{"", "a ==", "(a", `"open`, "1XB", "a = b", "a..b"} {
+		_, err := parseCondition(src)
+		assert.Error(t, err, src)
+	}
+
+	for name, s := range map[string]Step{
+		"unknown root":     {Name: "b", DependsOn: []string{"a"}, If: "foo == 1"},
+		"not a dependency": {Name: "b", DependsOn: []string{"a"}, If: `steps.c.status == "failed"`},
+		"ambiguous result": {Name: "c", DependsOn: []string{"a", "b"}, If: "result.size > 1"},
+		"no dependency":    {Name: "a", If: `status == "failed"`},
+	} {
+		assert.Error(t, checkCondition(s), name)
+	}
+	assert.NoError(t, checkCondition(Step{Name: "c", DependsOn: []string{"a", "b"}, If: `steps.a.status == "failed" && input.x`}))
+}
+
+func TestEngine_ManualApproval(t *testing.T) {
+	e, store := newTestEngine()
+	ctx := context.Background()
+
+	started, err := e.Start(ctx, Workflow{Steps: []Step{
+		{Name: "generate", Task: taskTemplate("generate_report")},
+		{Name: "review", Type: TypeManualApproval, DependsOn: []string{"generate"}},
+		{Name: "send", DependsOn: []string{"review"}, Task: taskTemplate("send_email")},
+	}})
+	require.NoError(t, err)
+
+	_, err = e.Approve(ctx, started.ID, "review", DecisionApprove, "ops", "")
+	assert.ErrorIs(t, err, errs.ErrInvalidTransition, "not waiting yet")
+
+	w := finishStep(t, e, store, started, "generate", task.StatusCompleted)
+	assert.Equal(t, StepWaiting, w.State["review"].Status)
+	assert.Equal(t, StepPending, w.State["send"].Status)
+
+	_, err = e.Approve(ctx, w.ID, "send", DecisionApprove, "ops", "")
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+	_, err = e.Approve(ctx, w.ID, "review", "maybe", "ops", "")
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+
+	w, err = e.Approve(ctx, w.ID, "review", DecisionApprove, "jane", "looks good")
+	require.NoError(t, err)
+	assert.Equal(t, StepCompleted, w.State["review"].Status)
+	assert.Equal(t, "jane", w.State["review"].Approval.By)
+	assert.Equal(t, StepRunning, w.State["send"].Status)
+
+	rejected, err := e.Start(ctx, Workflow{Steps: []Step{
+		{Name: "review", Type: TypeManualApproval},
+		{Name: "send", DependsOn: []string{"review"}, Task: taskTemplate("send_email")},
+	}})
+	require.NoError(t, err)
+	rejected, err = e.Approve(ctx, rejected.ID, "review", DecisionReject, "jane", "wrong numbers")
+	require.NoError(t, err)
+	assert.Equal(t, StepFailed, rejected.State["review"].Status)
+	assert.Equal(t, StepSkipped, rejected.State["send"].Status)
+	assert.Equal(t, StatusFailed, rejected.Status)
+}
+
+func TestEngine_ApprovalTimeoutAndEscalation(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+	e := NewEngine(Config{Store: NewMemoryStore(), Queue: q, Logger: zap.NewNop(), Now: func() time.Time { return now }})
+	ctx := context.Background()
+
+	started, err := e.Start(ctx, Workflow{Input: map[string]interface{}{"report": "q1"}, Steps: []Step{
+		{Name: "review", Type: TypeManualApproval, Approval: &Approval{
+			Timeout:       "48h",
+			OnTimeout:     DecisionApprove,
+			EscalateAfter: "24h",
+			Escalate:      &schedule.TaskTemplate{Type: "send_email", Payload: map[string]interface{}{"to": "manager@example.com"}},
+		}},
+		{Name: "send", DependsOn: []string{"review"}, Task: taskTemplate("send_email")},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, StepWaiting, started.State["review"].Status)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, e.CheckApprovals(ctx))
+	w, err := e.Get(ctx, started.ID)
+	require.NoError(t, err)
+	assert.Nil(t, w.State["review"].Approval.EscalatedAt)
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, e.CheckApprovals(ctx))
+	require.NoError(t, e.CheckApprovals(ctx))
+	w, err = e.Get(ctx, started.ID)
+	require.NoError(t, err)
+	require.NotNil(t, w.State["review"].Approval.EscalatedAt)
+	escalation, err := store.GetTask(ctx, w.State["review"].Approval.EscalationTaskID)
+	require.NoError(t, err)
+	assert.Equal(t, "manager@example.com", escalation.Payload["to"])
+	assert.Equal(t, "review", escalation.Payload["step"])
+	assert.Equal(t, "q1", escalation.Payload["report"])
+	counts, err := store.CountTasksByStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counts[task.StatusPending], "escalates once")
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, e.CheckApprovals(ctx))
+	w, err = e.Get(ctx, started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StepCompleted, w.State["review"].Status)
+	assert.True(t, w.State["review"].Approval.TimedOut)
+	assert.Equal(t, StepRunning, w.State["send"].Status)
+
+	for name, a := range map[string]*Approval{
+		"bad timeout":         {Timeout: "soon"},
+		"negative timeout":    {Timeout: "-1h"},
+		"bad decision":        {Timeout: "1h", OnTimeout: "maybe"},
+		"on_timeout alone":    {OnTimeout: DecisionApprove},
+		"escalate_after only": {EscalateAfter: "1h"},
+		"untyped escalation":  {EscalateAfter: "1h", Escalate: &schedule.TaskTemplate{}},
+	} {
+		w := Workflow{Steps: []Step{{Name: "review", Type: TypeManualApproval, Approval: a}}}
+		assert.Error(t, w.Validate(), name)
+	}
+}
+
+func TestEngine_Compensation(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	store := storage.NewMemoryStorage()
+	q := queue.NewQueue(queue.Config{Storage: store, Logger: zap.NewNop()})
+	e := NewEngine(Config{Store: NewMemoryStore(), Queue: q, Logger: zap.NewNop(), Now: func() time.Time { return now }})
+	ctx := context.Background()
+
+	started, err := e.Start(ctx, Workflow{Steps: []Step{
+		{Name: "provision", Task: taskTemplate("provision"), Compensate: &schedule.TaskTemplate{Type: "deprovision"}},
+		{Name: "configure", DependsOn: []string{"provision"}, Task: taskTemplate("configure"), Compensate: &schedule.TaskTemplate{Type: "reset_config"}},
+		{Name: "bill", DependsOn: []string{"configure"}, Task: taskTemplate("bill")},
+	}})
+	require.NoError(t, err)
+
+	provisioned, err := store.GetTask(ctx, started.State["provision"].Tasks[0].ID)
+	require.NoError(t, err)
+	provisioned.Status = task.StatusCompleted
+	provisioned.Result = map[string]interface{}{"instance": "i-123"}
+	e.OnFinish(ctx, provisioned)
+	now = now.Add(time.Minute)
+	w := finishStep(t, e, store, started, "configure", task.StatusCompleted)
+	now = now.Add(time.Minute)
+	w = finishStep(t, e, store, w, "bill", task.StatusFailed)
+
+	// Undo the latest step first, one at a time
+	assert.Equal(t, StatusCompensating, w.Status)
+	assert.Nil(t, w.FinishedAt)
+	require.NotNil(t, w.State["configure"].Compensation)
+	assert.Nil(t, w.State["provision"].Compensation)
+
+	reset, err := store.GetTask(ctx, w.State["configure"].Compensation.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "reset_config", reset.Type)
+	reset.Status = task.StatusFailed
+	e.OnFinish(ctx, reset)
+
+	w, err = e.Get(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.StatusFailed, w.State["configure"].Compensation.Status)
+	require.NotNil(t, w.State["provision"].Compensation, "a failed compensation does not stop the rest")
+	deprovision, err := store.GetTask(ctx, w.State["provision"].Compensation.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"instance": "i-123"}, deprovision.Payload[CompensatedResultField])
+
+	deprovision.Status = task.StatusCompleted
+	e.OnFinish(ctx, deprovision)
+	w, err = e.Get(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, w.Status)
+	assert.NotNil(t, w.FinishedAt)
+
+	// Resuming runs the compensated steps again
+	resumed, err := e.Resume(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StepRunning, resumed.State["provision"].Status)
+	assert.Nil(t, resumed.State["provision"].Compensation)
+}
+
+func TestEngine_SubWorkflow(t *testing.T) {
+	e, store := newTestEngine()
+	ctx := context.Background()
+
+	_, err := e.PutDefinition(ctx, &Definition{
+		Name:  "publish",
+		Input: map[string]interface{}{"channel": "email"},
+		Steps: []Step{
+			{Name: "render", Task: taskTemplate("render")},
+			{Name: "send", DependsOn: []string{"render"}, Task: taskTemplate("send")},
+		},
+	})
+	require.NoError(t, err)
+
+	started, err := e.Start(ctx, Workflow{Input: map[string]interface{}{"account": "acme"}, Steps: []Step{
+		{Name: "generate", Task: taskTemplate("generate_report")},
+		{Name: "publish", Type: TypeWorkflow, Workflow: "publish", Input: map[string]interface{}{"channel": "slack"}, DependsOn: []string{"generate"}},
+		{Name: "archive", DependsOn: []string{"publish"}, If: `result.send.url != null`, Task: taskTemplate("archive")},
+	}})
+	require.NoError(t, err)
+	require.Contains(t, started.Definitions, "publish")
+
+	w := finishStep(t, e, store, started, "generate", task.StatusCompleted)
+	require.NotNil(t, w.State["publish"].Child)
+	assert.Equal(t, StepRunning, w.State["publish"].Status)
+
+	child, err := e.Get(ctx, w.State["publish"].Child.ID)
+	require.NoError(t, err)
+	assert.Equal(t, w.ID, child.ParentID)
+	assert.Equal(t, "publish", child.ParentStep)
+	rendered, err := store.GetTask(ctx, child.State["render"].Tasks[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", rendered.Payload["account"], "the child inherits the parent's input")
+	assert.Equal(t, "slack", rendered.Payload["channel"], "the step's input overrides the definition's")
+	assert.Equal(t, w.ID, rendered.CorrelationID)
+
+	child = finishStep(t, e, store, child, "render", task.StatusCompleted)
+	sent, err := store.GetTask(ctx, child.State["send"].Tasks[0].ID)
+	require.NoError(t, err)
+	sent.Status = task.StatusCompleted
+	sent.Result = map[string]interface{}{"url": "https://example.com/r/1"}
+	e.OnFinish(ctx, sent)
+
+	w, err = e.Get(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StepCompleted, w.State["publish"].Status)
+	assert.Equal(t, StatusCompleted, w.State["publish"].Child.Status)
+	assert.Equal(t, map[string]interface{}{"url": "https://example.com/r/1"}, w.State["publish"].Child.Result["send"])
+	assert.Equal(t, StepRunning, w.State["archive"].Status)
+
+	// Cancelling the parent cancels its running child
+	second, err := e.Start(ctx, Workflow{Steps: []Step{{Name: "publish", Type: TypeWorkflow, Workflow: "publish"}}})
+	require.NoError(t, err)
+	_, err = e.Cancel(ctx, second.ID)
+	require.NoError(t, err)
+	child, err = e.Get(ctx, second.State["publish"].Child.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, child.Status)
+	second, err = e.Get(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StepCancelled, second.State["publish"].Status)
+}
+
+func TestEngine_Definitions(t *testing.T) {
+	e, _ := newTestEngine()
+	ctx := context.Background()
+
+	_, err := e.Start(ctx, Workflow{Steps: []Step{{Name: "a", Type: TypeWorkflow, Workflow: "missing"}}})
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+
+	// Definitions that would start each other are rejected when started
+	for _, d := range []*Definition{
+		{Name: "ping", Steps: []Step{{Name: "a", Type: TypeWorkflow, Workflow: "pong"}}},
+		{Name: "pong", Steps: []Step{{Name: "b", Type: TypeWorkflow, Workflow: "ping"}}},
+	} {
+		_, err := e.PutDefinition(ctx, d)
+		require.NoError(t, err)
+	}
+	_, err = e.StartDefinition(ctx, "ping", nil)
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+
+	_, err = e.PutDefinition(ctx, &Definition{Name: "bad name!", Steps: []Step{{Name: "a", Task: taskTemplate("a")}}})
+	assert.ErrorIs(t, err, errs.ErrInvalidRequest)
+
+	list, err := e.ListDefinitions(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "ping", list[0].Name)
+
+	require.NoError(t, e.DeleteDefinition(ctx, "pong"))
+	_, err = e.GetDefinition(ctx, "pong")
+	assert.ErrorIs(t, err, errs.ErrDefinitionNotFound)
+}
+
+func TestWorkflow_Graph(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	finished := start.Add(90 * time.Second)
+	w := &Workflow{
+		ID:     "wf-1",
+		Name:   "nightly",
+		Status: StatusRunning,
+		Steps: []Step{
+			{Name: "export", Task: taskTemplate("export_data")},
+			{Name: "review", Type: TypeManualApproval, DependsOn: []string{"export"}},
+			{Name: "alert", DependsOn: []string{"export"}, Trigger: TriggerFailure, If: `error != ""`, Task: taskTemplate("page")},
+		},
+		State: map[string]*StepState{
+			"export": {Status: StepCompleted, Tasks: []StepTask{{ID: "t1"}}, StartedAt: &start, FinishedAt: &finished},
+			"review": {Status: StepWaiting, StartedAt: &finished},
+			"alert":  {Status: StepSkipped, FinishedAt: &finished},
+		},
+	}
+
+	g := w.Graph(finished.Add(time.Hour))
+	require.Len(t, g.Nodes, 3)
+	assert.Equal(t, TypeTask, g.Nodes[0].Type)
+	assert.Equal(t, 1, g.Nodes[0].Tasks)
+	assert.Equal(t, 90.0, *g.Nodes[0].DurationSeconds)
+	assert.Equal(t, 3600.0, *g.Nodes[1].DurationSeconds, "a waiting step's duration runs to now")
+	assert.Nil(t, g.Nodes[2].DurationSeconds)
+	assert.Equal(t, []Edge{
+		{From: "export", To: "review", Trigger: TriggerSuccess},
+		{From: "export", To: "alert", Trigger: TriggerFailure, If: `error != ""`},
+	}, g.Edges)
+
+	var dot strings.Builder
+	require.NoError(t, g.WriteDOT(&dot))
+	assert.Contains(t, dot.String(), `"export" [label="export\ncompleted 1m30s", fillcolor=palegreen];`)
+	assert.Contains(t, dot.String(), `"review" [label="review\nwaiting 1h0m0s", fillcolor=khaki, shape=hexagon];`)
+	assert.Contains(t, dot.String(), `"export" -> "alert" [style=dashed, label="failure\nif error != \"\""];`)
+}
@@ -0,0 +1,284 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Decision is the outcome of a manual approval step
+type Decision string
+
+const (
+	// DecisionApprove completes the step
+	DecisionApprove Decision = "approve"
+	// DecisionReject fails the step
+	DecisionReject Decision = "reject"
+)
+
+// Approval configures a manual approval step
+type Approval struct {
+	// Timeout is how long the step waits for a decision, such as "48h",
+	// before OnTimeout decides it; empty waits for as long as it takes
+	Timeout string `json:"timeout,omitempty"`
+	// OnTimeout is the decision taken when Timeout passes; empty means
+	// DecisionReject
+	OnTimeout Decision `json:"on_timeout,omitempty"`
+	// EscalateAfter is how long the step waits, such as "24h", before
+	// submitting Escalate, once, to chase the decision
+	EscalateAfter string `json:"escalate_after,omitempty"`
+	// Escalate is the task submitted after EscalateAfter, such as an email
+	// to a manager. Its payload also holds the workflow's input and the
+	// "workflow" and "step" waiting.
+	Escalate *schedule.TaskTemplate `json:"escalate,omitempty"`
+}
+
+// ApprovalState is the progress of a manual approval step
+type ApprovalState struct {
+	Decision Decision `json:"decision,omitempty"`
+	// By and Comment are given by whoever decided
+	By        string     `json:"by,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	// TimedOut is set when the timeout made the decision
+	TimedOut bool `json:"timed_out,omitempty"`
+
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	// EscalationTaskID is the task Escalate submitted
+	EscalationTaskID string `json:"escalation_task_id,omitempty"`
+}
+
+// validate checks a manual approval step's settings, which may be nil
+func (a *Approval) validate() error {
+	if a == nil {
+		return nil
+	}
+	if _, err := a.timeout(); err != nil {
+		return err
+	}
+	if _, err := a.escalateAfter(); err != nil {
+		return err
+	}
+	switch {
+	case a.OnTimeout != "" && a.OnTimeout != DecisionApprove && a.OnTimeout != DecisionReject:
+		return fmt.Errorf("on_timeout must be %s or %s", DecisionApprove, DecisionReject)
+	case a.OnTimeout != "" && a.Timeout == "":
+		return fmt.Errorf("on_timeout needs a timeout")
+	case (a.EscalateAfter == "") != (a.Escalate == nil):
+		return fmt.Errorf("escalate_after and escalate go together")
+	case a.Escalate != nil && a.Escalate.Type == "":
+		return fmt.Errorf("escalate needs a task type")
+	case a.Escalate != nil && a.Escalate.Priority != nil && !a.Escalate.Priority.Valid():
+		return fmt.Errorf("escalate priority must be between %d and %d", task.PriorityMin, task.PriorityMax)
+	}
+	return nil
+}
+
+// timeout returns the approval's timeout, or 0 if it has none
+func (a *Approval) timeout() (time.Duration, error) {
+	return parseWait("timeout", a.Timeout)
+}
+
+// escalateAfter returns how long the approval waits before escalating, or
+// 0 if it does not escalate
+func (a *Approval) escalateAfter() (time.Duration, error) {
+	return parseWait("escalate_after", a.EscalateAfter)
+}
+
+func parseWait(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as \"24h\", got %q", name, value)
+	}
+	return d, nil
+}
+
+// decide ends a waiting approval step: completed when approved, failed
+// when rejected
+func (s *StepState) decide(d Decision, by, comment string, timedOut bool, now time.Time) {
+	s.Approval.Decision = d
+	s.Approval.By = by
+	s.Approval.Comment = comment
+	s.Approval.DecidedAt = &now
+	s.Approval.TimedOut = timedOut
+	s.Status = StepCompleted
+	if d == DecisionReject {
+		s.Status = StepFailed
+	}
+	s.FinishedAt = &now
+}
+
+// Approve records the decision on a waiting manual approval step and moves
+// the workflow on: an approved step completes and a rejected one fails
+func (e *Engine) Approve(ctx context.Context, id, step string, d Decision, by, comment string) (*Workflow, error) {
+	if d != DecisionApprove && d != DecisionReject {
+		return nil, errs.Invalidf("decision must be %s or %s", DecisionApprove, DecisionReject)
+	}
+
+	var l launch
+	var done bool
+	w, err := e.config.Store.Update(ctx, id, func(w *Workflow) error {
+		l, done = launch{}, false
+		s, ok := w.step(step)
+		if !ok {
+			return errs.Invalidf("workflow %s has no step %q", w.ID, step)
+		}
+		if s.Type != TypeManualApproval {
+			return errs.Invalidf("step %q is not a %s step", step, TypeManualApproval)
+		}
+		state := w.State[step]
+		if state.Status != StepWaiting {
+			return fmt.Errorf("%w: step %q is %s", errs.ErrInvalidTransition, step, state.Status)
+		}
+
+		now := e.config.Now().UTC()
+		state.decide(d, by, comment, false, now)
+		w.UpdatedAt = now
+		l = e.advance(w, now)
+		done = !w.active()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.logger.Info("workflow step decided",
+		zap.String("workflow", id),
+		zap.String("step", step),
+		zap.String("decision", string(d)),
+		zap.String("by", by),
+	)
+	if done {
+		e.finished(ctx, w)
+	}
+
+	if l.empty() {
+		return w, nil
+	}
+	e.submit(ctx, l)
+	return e.config.Store.Get(ctx, w.ID)
+}
+
+// Run times out and escalates approvals every Interval until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if e.config.Leader != nil && !e.config.Leader.IsLeader() {
+				continue
+			}
+			if err := e.CheckApprovals(ctx); err != nil {
+				e.logger.Error("failed to check workflow approvals", zap.Error(err))
+			}
+		}
+	}
+}
+
+// CheckApprovals escalates the waiting approval steps whose escalate_after
+// has passed and decides those whose timeout has
+func (e *Engine) CheckApprovals(ctx context.Context) error {
+	list, err := e.config.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	now := e.config.Now()
+	for _, w := range list {
+		if w.Status != StatusRunning || !w.approvalDue(now) {
+			continue
+		}
+		if err := e.checkApprovals(ctx, w.ID); err != nil && !errors.Is(err, errUnchanged) && !errors.Is(err, errs.ErrWorkflowNotFound) {
+			e.logger.Error("failed to check workflow approvals", zap.String("workflow", w.ID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// approvalDue reports whether any waiting approval step is due to escalate
+// or time out
+func (w *Workflow) approvalDue(now time.Time) bool {
+	for _, s := range w.Steps {
+		if escalate, timeout := w.approvalDeadlines(s, now); escalate || timeout {
+			return true
+		}
+	}
+	return false
+}
+
+// approvalDeadlines reports whether step s is waiting for approval and due
+// to escalate or to time out
+func (w *Workflow) approvalDeadlines(s Step, now time.Time) (escalate, timeout bool) {
+	state := w.State[s.Name]
+	if s.Approval == nil || state.Status != StepWaiting || state.StartedAt == nil {
+		return false, false
+	}
+	waited := now.Sub(*state.StartedAt)
+	if after, _ := s.Approval.escalateAfter(); after > 0 && state.Approval.EscalatedAt == nil {
+		escalate = waited >= after
+	}
+	if limit, _ := s.Approval.timeout(); limit > 0 {
+		timeout = waited >= limit
+	}
+	return escalate, timeout
+}
+
+// checkApprovals escalates and times out the due approval steps of one
+// workflow, and submits the tasks that follows
+func (e *Engine) checkApprovals(ctx context.Context, id string) error {
+	var l launch
+	var done bool
+	w, err := e.config.Store.Update(ctx, id, func(w *Workflow) error {
+		l, done = launch{}, false
+		if w.Status != StatusRunning {
+			return errUnchanged
+		}
+		now := e.config.Now().UTC()
+		changed := false
+		for _, s := range w.Steps {
+			escalate, timeout := w.approvalDeadlines(s, now)
+			state := w.State[s.Name]
+			if escalate {
+				t := e.newTask(w, s.Name, *s.Approval.Escalate, map[string]interface{}{"workflow": w.ID, "step": s.Name})
+				state.Approval.EscalatedAt = &now
+				state.Approval.EscalationTaskID = t.ID
+				l.tasks = append(l.tasks, t)
+				changed = true
+			}
+			if timeout {
+				d := s.Approval.OnTimeout
+				if d == "" {
+					d = DecisionReject
+				}
+				state.decide(d, "", "approval timed out", true, now)
+				changed = true
+			}
+		}
+		if !changed {
+			return errUnchanged
+		}
+		w.UpdatedAt = now
+		l.add(e.advance(w, now))
+		done = !w.active()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if done {
+		e.finished(ctx, w)
+	}
+	e.submit(ctx, l)
+	return nil
+}
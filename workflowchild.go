@@ -0,0 +1,110 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"go.uber.org/zap"
+)
+
+// newChild builds the child workflow step s starts, from its definition
+// as resolved when w started. The child inherits w's input, under the
+// step's input, over the definition's defaults, and w's correlation ID.
+func (w *Workflow) newChild(s Step, now time.Time) (*Workflow, error) {
+	d := w.Definitions[s.Workflow]
+	if d == nil {
+		return nil, fmt.Errorf("workflow definition %q was not resolved when the workflow started", s.Workflow)
+	}
+	child, err := NewWorkflow(now, d.workflow(mergeInput(w.Input, s.Input)))
+	if err != nil {
+		return nil, err
+	}
+	child.ParentID = w.ID
+	child.ParentStep = s.Name
+	child.CorrelationID = w.correlationID()
+	child.Definitions = w.Definitions
+	return child, nil
+}
+
+// startChild stores and starts a child workflow advance built. A child
+// that cannot be stored fails its step.
+func (e *Engine) startChild(ctx context.Context, child *Workflow) {
+	if _, err := e.run(ctx, child); err != nil {
+		e.logger.Error("failed to start child workflow",
+			zap.String("workflow", child.ParentID),
+			zap.String("step", child.ParentStep),
+			zap.Error(err),
+		)
+		failed := *child
+		failed.Status = StatusFailed
+		e.childFinished(ctx, &failed, fmt.Sprintf("failed to start: %v", err))
+	}
+}
+
+// childFinished records the outcome of a child workflow in its parent's
+// step, which ends as the child did, with the results of the child's
+// steps as its result, and moves the parent on
+func (e *Engine) childFinished(ctx context.Context, child *Workflow, reason string) {
+	var l launch
+	var done bool
+	parent, err := e.config.Store.Update(ctx, child.ParentID, func(w *Workflow) error {
+		l, done = launch{}, false
+		state := w.State[child.ParentStep]
+		if state == nil || state.Child == nil || state.Child.ID != child.ID || state.Child.Status != StatusRunning {
+			return errUnchanged
+		}
+
+		now := e.config.Now().UTC()
+		state.Child.Status = child.Status
+		state.Child.Result = child.results()
+		state.Child.Error = reason
+		switch child.Status {
+		case StatusCompleted:
+			state.Status = StepCompleted
+		case StatusCancelled:
+			state.Status = StepCancelled
+		default:
+			state.Status = StepFailed
+		}
+		state.FinishedAt = &now
+		w.UpdatedAt = now
+		active := w.active()
+		l = e.advance(w, now)
+		done = active && !w.active()
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return
+	}
+	if err != nil {
+		logger := e.logger.With(zap.String("workflow", child.ParentID), zap.String("child", child.ID))
+		if errors.Is(err, errs.ErrWorkflowNotFound) {
+			logger.Debug("child workflow finished for a deleted workflow")
+			return
+		}
+		logger.Error("failed to record child workflow", zap.Error(err))
+		return
+	}
+	if done {
+		e.finished(ctx, parent)
+	}
+	e.submit(ctx, l)
+}
+
+// results returns the results of the workflow's completed steps, by name
+func (w *Workflow) results() map[string]interface{} {
+	results := make(map[string]interface{})
+	for _, s := range w.Steps {
+		state := w.State[s.Name]
+		if state.Status != StepCompleted {
+			continue
+		}
+		if result := state.outcome(s.ForEach != "")["result"]; result != nil {
+			results[s.Name] = result
+		}
+	}
+	return results
+}
@@ -0,0 +1,157 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"go.uber.org/zap"
+)
+
+var validDefinitionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// Definition is a named, reusable workflow: started on its own, or as the
+// child of another workflow's workflow step
+type Definition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Input holds defaults for the input of the workflows started from it
+	Input map[string]interface{} `json:"input,omitempty"`
+	Steps []Step                 `json:"steps"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the definition's name and steps
+func (d *Definition) Validate() error {
+	if !validDefinitionName.MatchString(d.Name) {
+		return errs.Invalidf("workflow definition name must be 1-128 letters, digits, '_', '.' or '-', starting with a letter or digit")
+	}
+	w := d.workflow(nil)
+	return w.Validate()
+}
+
+// workflow returns a workflow running the definition's steps, with input
+// over the definition's default input
+func (d *Definition) workflow(input map[string]interface{}) Workflow {
+	return Workflow{Name: d.Name, Input: mergeInput(d.Input, input), Steps: d.Steps}
+}
+
+// mergeInput returns the fields of each input in turn, later ones
+// overriding earlier ones, or nil if there are none
+func mergeInput(inputs ...map[string]interface{}) map[string]interface{} {
+	var merged map[string]interface{}
+	for _, input := range inputs {
+		for k, v := range input {
+			if merged == nil {
+				merged = make(map[string]interface{})
+			}
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// PutDefinition creates or replaces a workflow definition. Workflows
+// already started from it keep the steps they started with.
+func (e *Engine) PutDefinition(ctx context.Context, d *Definition) (*Definition, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	now := e.config.Now().UTC()
+	d.CreatedAt, d.UpdatedAt = now, now
+	existing, err := e.config.Store.GetDefinition(ctx, d.Name)
+	switch {
+	case err == nil:
+		d.CreatedAt = existing.CreatedAt
+	case !errors.Is(err, errs.ErrDefinitionNotFound):
+		return nil, err
+	}
+	if err := e.config.Store.PutDefinition(ctx, d); err != nil {
+		return nil, err
+	}
+	e.logger.Info("workflow definition saved", zap.String("name", d.Name), zap.Int("steps", len(d.Steps)))
+	return d, nil
+}
+
+// GetDefinition returns a workflow definition, or
+// errs.ErrDefinitionNotFound
+func (e *Engine) GetDefinition(ctx context.Context, name string) (*Definition, error) {
+	return e.config.Store.GetDefinition(ctx, name)
+}
+
+// ListDefinitions returns every workflow definition, by name
+func (e *Engine) ListDefinitions(ctx context.Context) ([]*Definition, error) {
+	return e.config.Store.ListDefinitions(ctx)
+}
+
+// DeleteDefinition removes a workflow definition. Workflows already
+// started from it carry on.
+func (e *Engine) DeleteDefinition(ctx context.Context, name string) error {
+	return e.config.Store.DeleteDefinition(ctx, name)
+}
+
+// StartDefinition starts a workflow from the named definition, with input
+// over the definition's default input
+func (e *Engine) StartDefinition(ctx context.Context, name string, input map[string]interface{}) (*Workflow, error) {
+	d, err := e.config.Store.GetDefinition(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.start(ctx, d.workflow(input), name)
+}
+
+// resolve copies the definitions w's workflow steps start, and those their
+// steps start in turn, into w.Definitions, so its children run the
+// definitions as they are now. root names the definition w was started
+// from, if any. A definition that would start itself is rejected.
+func (e *Engine) resolve(ctx context.Context, w *Workflow, root string) error {
+	defs := make(map[string]*Definition)
+	var visit func(path []string, steps []Step) error
+	visit = func(path []string, steps []Step) error {
+		for _, s := range steps {
+			if s.Type != TypeWorkflow {
+				continue
+			}
+			for _, name := range path {
+				if name == s.Workflow {
+					return errs.Invalidf("step %q: workflow definition %q would start itself", s.Name, s.Workflow)
+				}
+			}
+			d, ok := defs[s.Workflow]
+			if !ok {
+				var err error
+				d, err = e.config.Store.GetDefinition(ctx, s.Workflow)
+				if errors.Is(err, errs.ErrDefinitionNotFound) {
+					return errs.Invalidf("step %q: no workflow definition is called %q", s.Name, s.Workflow)
+				}
+				if err != nil {
+					return err
+				}
+				if len(defs) >= maxSteps {
+					return errs.Invalidf("a workflow can start at most %d workflow definitions", maxSteps)
+				}
+				defs[s.Workflow] = d
+			}
+			if err := visit(append(path[:len(path):len(path)], s.Workflow), d.Steps); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var path []string
+	if root != "" {
+		path = []string{root}
+	}
+	if err := visit(path, w.Steps); err != nil {
+		return err
+	}
+	if len(defs) > 0 {
+		w.Definitions = defs
+	}
+	return nil
+}
@@ -0,0 +1,553 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/metrics"
+	"github.com/yourusername/distributed-task-queue/internal/queue"
+	"github.com/yourusername/distributed-task-queue/internal/schedule"
+	"github.com/yourusername/distributed-task-queue/internal/task"
+	"go.uber.org/zap"
+)
+
+// Queue is the part of *queue.Queue the engine submits step tasks through
+type Queue interface {
+	NewTask(taskType string, payload map[string]interface{}) *task.Task
+	Submit(ctx context.Context, t *task.Task, opts ...queue.SubmitOption) error
+	Cancel(ctx context.Context, id string) (*task.Task, error)
+}
+
+// Config holds engine configuration
+type Config struct {
+	Store  Store
+	Queue  Queue
+	Logger *zap.Logger
+
+	// Leader, if set, limits timing out and escalating approvals to the
+	// instance leading it. An *election.Elector for the "scheduler" role
+	// fits.
+	Leader Leadership
+
+	// Interval is how often Run checks approvals, defaults to 10s
+	Interval time.Duration
+
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+// Leadership reports whether this instance is the elected scheduler
+type Leadership interface {
+	IsLeader() bool
+}
+
+// Engine runs workflows. It learns that a step's task finished from
+// OnFinish, which every process running the queue must register with
+// Queue.OnFinish, since whichever process finishes a task moves its
+// workflow on.
+type Engine struct {
+	config Config
+	logger *zap.Logger
+}
+
+// errUnchanged leaves a workflow as it was stored
+var errUnchanged = errors.New("unchanged")
+
+// NewEngine creates a workflow engine
+func NewEngine(cfg Config) *Engine {
+	if cfg.Logger == nil {
+		cfg.Logger, _ = zap.NewProduction()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Engine{config: cfg, logger: cfg.Logger}
+}
+
+// Start stores a new workflow made from def and submits the tasks of the
+// steps that depend on nothing
+func (e *Engine) Start(ctx context.Context, def Workflow) (*Workflow, error) {
+	return e.start(ctx, def, "")
+}
+
+// start starts a workflow made from def, which the definition root made,
+// if not empty
+func (e *Engine) start(ctx context.Context, def Workflow, root string) (*Workflow, error) {
+	w, err := NewWorkflow(e.config.Now(), def)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.resolve(ctx, w, root); err != nil {
+		return nil, err
+	}
+	return e.run(ctx, w)
+}
+
+// run stores a new workflow and submits the tasks of the steps that
+// depend on nothing
+func (e *Engine) run(ctx context.Context, w *Workflow) (*Workflow, error) {
+	l := e.advance(w, e.config.Now())
+	if err := e.config.Store.Put(ctx, w); err != nil {
+		return nil, err
+	}
+	logger := e.logger
+	if w.ParentID != "" {
+		logger = logger.With(zap.String("parent", w.ParentID))
+	}
+	logger.Info("workflow started", zap.String("workflow", w.ID), zap.String("name", w.Name), zap.Int("steps", len(w.Steps)))
+	if !w.active() {
+		e.finished(ctx, w)
+	}
+
+	if l.empty() {
+		return w, nil
+	}
+	e.submit(ctx, l)
+	return e.config.Store.Get(ctx, w.ID)
+}
+
+// Get returns a workflow, or errs.ErrWorkflowNotFound
+func (e *Engine) Get(ctx context.Context, id string) (*Workflow, error) {
+	return e.config.Store.Get(ctx, id)
+}
+
+// List returns every workflow, oldest first
+func (e *Engine) List(ctx context.Context) ([]*Workflow, error) {
+	return e.config.Store.List(ctx)
+}
+
+// Delete removes a workflow. Tasks it already submitted are left alone.
+func (e *Engine) Delete(ctx context.Context, id string) error {
+	return e.config.Store.Delete(ctx, id)
+}
+
+// Cancel stops a running workflow: pending steps are cancelled and so are
+// the tasks and child workflows of running ones
+func (e *Engine) Cancel(ctx context.Context, id string) (*Workflow, error) {
+	var running, children []string
+	w, err := e.config.Store.Update(ctx, id, func(w *Workflow) error {
+		running, children = nil, nil
+		if w.Status != StatusRunning {
+			return fmt.Errorf("%w: workflow %s is %s", errs.ErrInvalidTransition, w.ID, w.Status)
+		}
+		now := e.config.Now().UTC()
+		for _, s := range w.Steps {
+			state := w.State[s.Name]
+			switch state.Status {
+			case StepPending, StepWaiting:
+				state.Status = StepCancelled
+				state.FinishedAt = &now
+			case StepRunning:
+				for _, st := range state.Tasks {
+					if !st.Status.Terminal() {
+						running = append(running, st.ID)
+					}
+				}
+				if state.Child != nil && state.Child.Status == StatusRunning {
+					children = append(children, state.Child.ID)
+				}
+			}
+		}
+		w.Status = StatusCancelled
+		w.UpdatedAt = now
+		w.FinishedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.finished(ctx, w)
+
+	// The tasks' and children's outcomes reach the workflow as usual
+	for _, childID := range children {
+		_, err := e.Cancel(ctx, childID)
+		if err != nil && !errors.Is(err, errs.ErrInvalidTransition) && !errors.Is(err, errs.ErrWorkflowNotFound) {
+			e.logger.Warn("failed to cancel child workflow", zap.String("workflow", id), zap.String("child", childID), zap.Error(err))
+		}
+	}
+	for _, taskID := range running {
+		_, err := e.config.Queue.Cancel(ctx, taskID)
+		if err != nil && !errors.Is(err, errs.ErrInvalidTransition) && !errors.Is(err, errs.ErrTaskNotFound) {
+			e.logger.Warn("failed to cancel workflow task", zap.String("workflow", id), zap.String("id", taskID), zap.Error(err))
+		}
+	}
+	return w, nil
+}
+
+// Resume runs a failed or cancelled workflow again from where it stopped:
+// steps that failed, were skipped, were cancelled or were compensated go
+// back to pending and run once the steps they depend on allow it, while
+// other completed steps keep their outcome
+func (e *Engine) Resume(ctx context.Context, id string) (*Workflow, error) {
+	var l launch
+	w, err := e.config.Store.Update(ctx, id, func(w *Workflow) error {
+		if w.Status != StatusFailed && w.Status != StatusCancelled {
+			return fmt.Errorf("%w: workflow %s is %s", errs.ErrInvalidTransition, w.ID, w.Status)
+		}
+		now := e.config.Now().UTC()
+		for _, state := range w.State {
+			switch {
+			case state.Status == StepFailed, state.Status == StepSkipped, state.Status == StepCancelled, state.Compensation != nil:
+				*state = StepState{Status: StepPending}
+			}
+		}
+		w.Status = StatusRunning
+		w.UpdatedAt = now
+		w.FinishedAt = nil
+		l = e.advance(w, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.logger.Info("workflow resumed", zap.String("workflow", w.ID))
+	if !w.active() {
+		e.finished(ctx, w)
+	}
+
+	if l.empty() {
+		return w, nil
+	}
+	e.submit(ctx, l)
+	return e.config.Store.Get(ctx, w.ID)
+}
+
+// OnFinish records the outcome of a workflow step's task and submits the
+// steps it lets run. It ignores tasks no workflow submitted.
+func (e *Engine) OnFinish(ctx context.Context, t *task.Task) {
+	if t.Labels[Label] == "" {
+		return
+	}
+	if err := e.record(ctx, t); err != nil {
+		logger := e.logger.With(zap.String("workflow", t.Labels[Label]), zap.String("id", t.ID))
+		if errors.Is(err, errs.ErrWorkflowNotFound) {
+			logger.Debug("task finished for a deleted workflow")
+			return
+		}
+		logger.Error("failed to record workflow step", zap.Error(err))
+	}
+}
+
+// record stores the outcome of t, a task of a workflow step, and submits
+// the tasks of the steps that can now run
+func (e *Engine) record(ctx context.Context, t *task.Task) error {
+	var l launch
+	var done bool
+	w, err := e.config.Store.Update(ctx, t.Labels[Label], func(w *Workflow) error {
+		l, done = launch{}, false
+		state := w.State[t.Labels[StepLabel]]
+		if state == nil {
+			return errUnchanged
+		}
+		outcome := StepTask{ID: t.ID, Status: t.Status, Result: t.Result, Error: t.Error}
+		now := e.config.Now().UTC()
+		if c := state.Compensation; c != nil && c.ID == t.ID {
+			if c.Status.Terminal() {
+				return errUnchanged
+			}
+			*c = outcome
+		} else {
+			i := state.taskIndex(t.ID)
+			if i < 0 || state.Tasks[i].Status.Terminal() {
+				return errUnchanged
+			}
+			state.Tasks[i] = outcome
+			state.settle(now)
+		}
+		w.UpdatedAt = now
+		active := w.active()
+		l = e.advance(w, now)
+		done = active && !w.active()
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if done {
+		e.finished(ctx, w)
+	}
+	e.submit(ctx, l)
+	return nil
+}
+
+// launch is what advancing a workflow started, for the caller to submit
+// once the workflow is stored
+type launch struct {
+	tasks    []*task.Task
+	children []*Workflow
+}
+
+// add appends what other started
+func (l *launch) add(other launch) {
+	l.tasks = append(l.tasks, other.tasks...)
+	l.children = append(l.children, other.children...)
+}
+
+func (l launch) empty() bool {
+	return len(l.tasks) == 0 && len(l.children) == 0
+}
+
+// submit submits the tasks of steps advance started and starts their child
+// workflows. A task that cannot be submitted fails its step.
+func (e *Engine) submit(ctx context.Context, l launch) {
+	for _, child := range l.children {
+		e.startChild(ctx, child)
+	}
+	for _, t := range l.tasks {
+		err := e.config.Queue.Submit(ctx, t)
+		if err == nil {
+			continue
+		}
+		e.logger.Error("failed to submit workflow task",
+			zap.String("workflow", t.Labels[Label]),
+			zap.String("step", t.Labels[StepLabel]),
+			zap.Error(err),
+		)
+		failed := *t
+		failed.Status = task.StatusFailed
+		failed.Error = fmt.Sprintf("failed to submit: %v", err)
+		if err := e.record(ctx, &failed); err != nil {
+			e.logger.Error("failed to record workflow step", zap.String("workflow", t.Labels[Label]), zap.Error(err))
+		}
+	}
+}
+
+// advance starts every pending step whose dependencies have finished,
+// skipping those whose trigger does not fire, and finishes the workflow
+// once no step is left to run, compensating first if it failed. It returns
+// the tasks and child workflows of the steps started, for the caller to
+// submit once the workflow is stored.
+func (e *Engine) advance(w *Workflow, now time.Time) launch {
+	now = now.UTC()
+	switch w.Status {
+	case StatusRunning:
+	case StatusCompensating:
+		return launch{tasks: e.compensate(w, now)}
+	default:
+		return launch{}
+	}
+
+	var l launch
+	// A step finishing at once, when skipped or fanned out over nothing,
+	// can let later steps run, so go round until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for _, s := range w.Steps {
+			state := w.State[s.Name]
+			if state.Status != StepPending {
+				continue
+			}
+			run, ready := w.triggered(s)
+			if !ready {
+				continue
+			}
+			changed = true
+			if !run {
+				state.Status = StepSkipped
+				state.FinishedAt = &now
+				continue
+			}
+			if s.Type == TypeManualApproval {
+				state.Status = StepWaiting
+				state.StartedAt = &now
+				state.Approval = &ApprovalState{}
+				continue
+			}
+			if s.Type == TypeWorkflow {
+				state.Status = StepRunning
+				state.StartedAt = &now
+				child, err := w.newChild(s, now)
+				if err != nil {
+					state.Child = &Child{Status: StatusFailed, Error: err.Error()}
+					state.Status = StepFailed
+					state.FinishedAt = &now
+					continue
+				}
+				state.Child = &Child{ID: child.ID, Status: StatusRunning}
+				l.children = append(l.children, child)
+				continue
+			}
+
+			stepTasks := e.newTasks(w, s)
+			state.Status = StepRunning
+			state.StartedAt = &now
+			state.Tasks = make([]StepTask, len(stepTasks))
+			for i, t := range stepTasks {
+				state.Tasks[i] = StepTask{ID: t.ID, Status: t.Status}
+			}
+			state.settle(now)
+			l.tasks = append(l.tasks, stepTasks...)
+		}
+	}
+
+	w.finish(now)
+	if w.Status == StatusCompensating {
+		l.tasks = append(l.tasks, e.compensate(w, now)...)
+	}
+	return l
+}
+
+// newTasks builds the tasks of step s: one, or one per item it fans out
+// over
+func (e *Engine) newTasks(w *Workflow, s Step) []*task.Task {
+	items := []interface{}{nil}
+	if s.ForEach != "" {
+		items, _ = w.Input[s.ForEach].([]interface{})
+	}
+
+	tasks := make([]*task.Task, 0, len(items))
+	for _, item := range items {
+		var extra map[string]interface{}
+		if s.ForEach != "" {
+			extra = map[string]interface{}{ItemField: item}
+		}
+		tasks = append(tasks, e.newTask(w, s.Name, s.Task, extra))
+	}
+	return tasks
+}
+
+// newTask builds a task of step from tmpl. Its payload is the workflow's
+// input, overridden by the template's payload and then by extra.
+func (e *Engine) newTask(w *Workflow, step string, tmpl schedule.TaskTemplate, extra map[string]interface{}) *task.Task {
+	payload := make(map[string]interface{}, len(w.Input)+len(tmpl.Payload)+len(extra))
+	for k, v := range w.Input {
+		payload[k] = v
+	}
+	for k, v := range tmpl.Payload {
+		payload[k] = v
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+
+	t := e.config.Queue.NewTask(tmpl.Type, payload)
+	if tmpl.Priority != nil {
+		t.Priority = *tmpl.Priority
+	}
+	if tmpl.MaxRetries != nil {
+		t.MaxRetries = *tmpl.MaxRetries
+	}
+	t.Labels = make(map[string]string, len(tmpl.Labels)+2)
+	for k, v := range tmpl.Labels {
+		t.Labels[k] = v
+	}
+	t.Labels[Label] = w.ID
+	t.Labels[StepLabel] = step
+	t.CorrelationID = w.correlationID()
+	return t
+}
+
+// finished logs and counts a workflow that has just finished, and moves
+// its parent on if it is a child
+func (e *Engine) finished(ctx context.Context, w *Workflow) {
+	metrics.WorkflowsFinished.WithLabelValues(string(w.Status)).Inc()
+	e.logger.Info("workflow finished", zap.String("workflow", w.ID), zap.String("status", string(w.Status)))
+	if w.ParentID != "" {
+		e.childFinished(ctx, w, "")
+	}
+}
+
+// triggered reports whether the steps s depends on have all finished, and
+// if so whether s's trigger and condition let it run
+func (w *Workflow) triggered(s Step) (run, ready bool) {
+	var completed, failed int
+	for _, dep := range s.DependsOn {
+		switch w.State[dep].Status {
+		case StepCompleted:
+			completed++
+		case StepFailed:
+			failed++
+		case StepPending, StepRunning, StepWaiting:
+			return false, false
+		}
+	}
+
+	switch s.Trigger {
+	case TriggerFailure:
+		run = failed > 0
+	case TriggerAlways:
+		run = true
+	default:
+		run = completed == len(s.DependsOn)
+	}
+	if run && s.If != "" {
+		run = w.evalCondition(s)
+	}
+	return run, true
+}
+
+// active reports whether the workflow has yet to finish
+func (w *Workflow) active() bool {
+	return w.Status == StatusRunning || w.Status == StatusCompensating
+}
+
+// finish ends the workflow once every step has finished: failed if any
+// step failed, even one whose failure a later step handled, cancelled if
+// any was cancelled, and completed otherwise. A failed workflow with steps
+// to compensate starts compensating instead.
+func (w *Workflow) finish(now time.Time) {
+	status := StatusCompleted
+	for _, state := range w.State {
+		switch state.Status {
+		case StepPending, StepRunning, StepWaiting:
+			return
+		case StepFailed:
+			status = StatusFailed
+		case StepCancelled:
+			if status == StatusCompleted {
+				status = StatusCancelled
+			}
+		}
+	}
+	w.UpdatedAt = now
+	if _, ok := w.nextCompensation(); ok && status == StatusFailed {
+		w.Status = StatusCompensating
+		return
+	}
+	w.Status = status
+	w.FinishedAt = &now
+}
+
+// taskIndex returns the position of task id in the step's tasks, or -1
+func (s *StepState) taskIndex(id string) int {
+	for i, t := range s.Tasks {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// settle finishes a running step once all its tasks have: failed if any
+// failed or expired, cancelled if any was cancelled, and completed
+// otherwise
+func (s *StepState) settle(now time.Time) {
+	if s.Status != StepRunning {
+		return
+	}
+	status := StepCompleted
+	for _, t := range s.Tasks {
+		switch t.Status {
+		case task.StatusCompleted:
+		case task.StatusFailed, task.StatusExpired:
+			status = StepFailed
+		case task.StatusCancelled:
+			if status == StepCompleted {
+				status = StepCancelled
+			}
+		default:
+			return
+		}
+	}
+	s.Status = status
+	s.FinishedAt = &now
+}
@@ -0,0 +1,505 @@
+package workflow
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sizeUnits are the suffixes a number in a condition may carry, such as
+// 1GB, in powers of 1024
+var sizeUnits = map[string]float64{
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// expr is a parsed step condition. Evaluating one never fails: a missing
+// field is null, and comparing values of different kinds is false.
+type expr interface {
+	eval(env map[string]interface{}) interface{}
+}
+
+type literal struct{ value interface{} }
+
+// path looks up a dotted name, such as result.size, in the environment
+type path []string
+
+type unary struct {
+	op string
+	x  expr
+}
+
+type binary struct {
+	op   string
+	x, y expr
+}
+
+// parseCondition parses a condition such as
+// `result.size > 1GB && steps.export.status == "completed"`
+func parseCondition(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+// paths returns every name e looks up
+func paths(e expr) []path {
+	switch e := e.(type) {
+	case path:
+		return []path{e}
+	case unary:
+		return paths(e.x)
+	case binary:
+		return append(paths(e.x), paths(e.y)...)
+	}
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokOp tokenKind = iota
+	tokNumber
+	tokString
+	tokName
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+}
+
+// operators lists the operator tokens, longest first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], src[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			text := src[i+1 : i+1+end]
+			tokens = append(tokens, token{kind: tokString, text: text, value: text})
+			i += end + 2
+
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", src[start:i])
+			}
+			unitStart := i
+			for i < len(src) && unicode.IsLetter(rune(src[i])) {
+				i++
+			}
+			if unit := strings.ToUpper(src[unitStart:i]); unit != "" {
+				scale, ok := sizeUnits[unit]
+				if !ok {
+					return nil, fmt.Errorf("unknown unit %q", src[unitStart:i])
+				}
+				n *= scale
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], value: n})
+
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokName, text: src[start:i]})
+
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser over the grammar
+//
+//	or      = and { "||" and }
+//	and     = compare { "&&" compare }
+//	compare = not [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) not ]
+//	not     = "!" not | primary
+//	primary = number | string | "true" | "false" | "null" | name | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// accept consumes the next token if it is one of the operators ops
+func (p *parser) accept(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) or() (expr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return x, nil
+		}
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: "||", x: x, y: y}
+	}
+}
+
+func (p *parser) and() (expr, error) {
+	x, err := p.compare()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return x, nil
+		}
+		y, err := p.compare()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: "&&", x: x, y: y}
+	}
+}
+
+func (p *parser) compare() (expr, error) {
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return x, nil
+	}
+	y, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return binary{op: op, x: x, y: y}, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if _, ok := p.accept("!"); ok {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: "!", x: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+	if _, ok := p.accept("("); ok {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return x, nil
+	}
+
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case tokNumber, tokString:
+		return literal{value: t.value}, nil
+	case tokName:
+		switch t.text {
+		case "true":
+			return literal{value: true}, nil
+		case "false":
+			return literal{value: false}, nil
+		case "null":
+			return literal{value: nil}, nil
+		}
+		parts := strings.Split(t.text, ".")
+		for _, part := range parts {
+			if part == "" {
+				return nil, fmt.Errorf("invalid name %q", t.text)
+			}
+		}
+		return path(parts), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (l literal) eval(map[string]interface{}) interface{} {
+	return l.value
+}
+
+func (p path) eval(env map[string]interface{}) interface{} {
+	var v interface{} = env
+	for _, part := range p {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[part]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func (u unary) eval(env map[string]interface{}) interface{} {
+	return !truthy(u.x.eval(env))
+}
+
+func (b binary) eval(env map[string]interface{}) interface{} {
+	switch b.op {
+	case "&&":
+		return truthy(b.x.eval(env)) && truthy(b.y.eval(env))
+	case "||":
+		return truthy(b.x.eval(env)) || truthy(b.y.eval(env))
+	}
+
+	x, y := b.x.eval(env), b.y.eval(env)
+	switch b.op {
+	case "==":
+		return equal(x, y)
+	case "!=":
+		return !equal(x, y)
+	}
+
+	if xn, ok := number(x); ok {
+		if yn, ok := number(y); ok {
+			return ordered(b.op, compareFloats(xn, yn))
+		}
+		return false
+	}
+	xs, xok := x.(string)
+	ys, yok := y.(string)
+	if xok && yok {
+		return ordered(b.op, strings.Compare(xs, ys))
+	}
+	return false
+}
+
+// ordered applies an ordering operator to the result of a comparison
+func ordered(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// number returns v as a float64 if it is a number of any type
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func equal(x, y interface{}) bool {
+	if xn, ok := number(x); ok {
+		yn, ok := number(y)
+		return ok && xn == yn
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// truthy reports whether v counts as true: false, null, zero, the empty
+// string and empty lists and objects do not
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	if n, ok := number(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+// checkCondition parses s's condition and checks that it only refers to
+// the input and to steps s depends on
+func checkCondition(s Step) error {
+	if s.If == "" {
+		return nil
+	}
+	e, err := parseCondition(s.If)
+	if err != nil {
+		return err
+	}
+	deps := make(map[string]bool, len(s.DependsOn))
+	for _, dep := range s.DependsOn {
+		deps[dep] = true
+	}
+	for _, p := range paths(e) {
+		switch p[0] {
+		case "input":
+		case "steps":
+			if len(p) < 2 || !deps[p[1]] {
+				return fmt.Errorf("%s: steps.<name> must name a step it depends on", strings.Join(p, "."))
+			}
+		case "status", "result", "error":
+			if len(s.DependsOn) != 1 {
+				return fmt.Errorf("%s: only a step with a single dependency can use %s, otherwise use steps.<name>.%s", strings.Join(p, "."), p[0], p[0])
+			}
+		default:
+			return fmt.Errorf("unknown name %q", strings.Join(p, "."))
+		}
+	}
+	return nil
+}
+
+// evalCondition evaluates s's condition against the workflow's input and
+// the outcomes of the steps s depends on. A condition that no longer parses
+// is false.
+func (w *Workflow) evalCondition(s Step) bool {
+	e, err := parseCondition(s.If)
+	if err != nil {
+		return false
+	}
+	steps := make(map[string]interface{}, len(s.DependsOn))
+	for _, dep := range s.DependsOn {
+		d, _ := w.step(dep)
+		steps[dep] = w.State[dep].outcome(d.ForEach != "")
+	}
+	env := map[string]interface{}{
+		"input": w.Input,
+		"steps": steps,
+	}
+	if len(s.DependsOn) == 1 {
+		for k, v := range steps[s.DependsOn[0]].(map[string]interface{}) {
+			env[k] = v
+		}
+	}
+	return truthy(e.eval(env))
+}
+
+// outcome is how a condition sees a finished step: its status, and the
+// result and error of its task, or lists of them if it fanned out. A manual
+// approval step's result is its decision, by and comment, and a workflow
+// step's the results of its child's steps.
+func (s *StepState) outcome(fanOut bool) map[string]interface{} {
+	out := map[string]interface{}{"status": string(s.Status)}
+	if s.Child != nil {
+		out["result"] = s.Child.Result
+		out["error"] = s.Child.Error
+		return out
+	}
+	if s.Approval != nil {
+		out["result"] = map[string]interface{}{
+			"decision": string(s.Approval.Decision),
+			"by":       s.Approval.By,
+			"comment":  s.Approval.Comment,
+		}
+		return out
+	}
+	if !fanOut {
+		if len(s.Tasks) > 0 {
+			if s.Tasks[0].Result != nil {
+				out["result"] = s.Tasks[0].Result
+			}
+			out["error"] = s.Tasks[0].Error
+		}
+		return out
+	}
+	results := make([]interface{}, len(s.Tasks))
+	taskErrors := make([]interface{}, len(s.Tasks))
+	for i, t := range s.Tasks {
+		results[i] = t.Result
+		taskErrors[i] = t.Error
+	}
+	out["result"] = results
+	out["error"] = taskErrors
+	return out
+}
@@ -0,0 +1,167 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Graph is a workflow's DAG with each step's live progress, for rendering
+type Graph struct {
+	Workflow string    `json:"workflow"`
+	Name     string    `json:"name,omitempty"`
+	Status   Status    `json:"status"`
+	Nodes    []Node    `json:"nodes"`
+	Edges    []Edge    `json:"edges"`
+	At       time.Time `json:"at"`
+}
+
+// Node is one step of a Graph
+type Node struct {
+	Step   string     `json:"step"`
+	Type   StepType   `json:"type"`
+	Status StepStatus `json:"status"`
+	// Tasks counts the tasks the step submitted
+	Tasks int `json:"tasks,omitempty"`
+	// Child is the workflow a workflow step started
+	Child      string     `json:"child,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// DurationSeconds is how long the step ran, or has been running or
+	// waiting so far
+	DurationSeconds *float64 `json:"duration_seconds,omitempty"`
+}
+
+// Edge runs from a step to one that depends on it
+type Edge struct {
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Trigger Trigger `json:"trigger"`
+	// If is the dependent step's condition
+	If string `json:"if,omitempty"`
+}
+
+// Graph returns the workflow's DAG as of now
+func (w *Workflow) Graph(now time.Time) Graph {
+	g := Graph{
+		Workflow: w.ID,
+		Name:     w.Name,
+		Status:   w.Status,
+		Nodes:    make([]Node, 0, len(w.Steps)),
+		Edges:    []Edge{},
+		At:       now.UTC(),
+	}
+	for _, s := range w.Steps {
+		state := w.State[s.Name]
+		n := Node{
+			Step:       s.Name,
+			Type:       s.Type,
+			Status:     state.Status,
+			Tasks:      len(state.Tasks),
+			StartedAt:  state.StartedAt,
+			FinishedAt: state.FinishedAt,
+		}
+		if n.Type == "" {
+			n.Type = TypeTask
+		}
+		if state.Child != nil {
+			n.Child = state.Child.ID
+		}
+		if state.StartedAt != nil {
+			end := now
+			if state.FinishedAt != nil {
+				end = *state.FinishedAt
+			}
+			seconds := end.Sub(*state.StartedAt).Seconds()
+			n.DurationSeconds = &seconds
+		}
+		g.Nodes = append(g.Nodes, n)
+
+		trigger := s.Trigger
+		if trigger == "" {
+			trigger = TriggerSuccess
+		}
+		for _, dep := range s.DependsOn {
+			g.Edges = append(g.Edges, Edge{From: dep, To: s.Name, Trigger: trigger, If: s.If})
+		}
+	}
+	return g
+}
+
+// statusColors fill the DOT graph's nodes by step status
+var statusColors = map[StepStatus]string{
+	StepPending:   "white",
+	StepRunning:   "lightblue",
+	StepWaiting:   "khaki",
+	StepCompleted: "palegreen",
+	StepFailed:    "salmon",
+	StepSkipped:   "lightgrey",
+	StepCancelled: "grey",
+}
+
+// WriteDOT writes the graph in Graphviz's DOT language, one box per step
+// filled by its status and labelled with its duration. Edges of failure and
+// always triggers are dashed and labelled, as are conditional ones.
+func (g Graph) WriteDOT(out io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(g.Workflow))
+	fmt.Fprintf(&b, "  label=%s;\n", dotQuote(strings.TrimSpace(g.Name+" ("+string(g.Status)+")")))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\"];\n")
+	for _, n := range g.Nodes {
+		label := n.Step + "\n" + string(n.Status)
+		if n.DurationSeconds != nil {
+			label += " " + time.Duration(*n.DurationSeconds*float64(time.Second)).Round(time.Second).String()
+		}
+		shape := ""
+		switch n.Type {
+		case TypeManualApproval:
+			shape = ", shape=hexagon"
+		case TypeWorkflow:
+			shape = ", peripheries=2"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, fillcolor=%s%s];\n", dotQuote(n.Step), dotQuote(label), statusColors[n.Status], shape)
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		var label []string
+		if e.Trigger != TriggerSuccess {
+			attrs = append(attrs, "style=dashed")
+			label = append(label, string(e.Trigger))
+		}
+		if e.If != "" {
+			label = append(label, "if "+e.If)
+		}
+		if len(label) > 0 {
+			attrs = append(attrs, "label="+dotQuote(strings.Join(label, "\n")))
+		}
+		suffix := ""
+		if len(attrs) > 0 {
+			suffix = " [" + strings.Join(attrs, ", ") + "]"
+		}
+		fmt.Fprintf(&b, "  %s -> %s%s;\n", dotQuote(e.From), dotQuote(e.To), suffix)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// dotQuote quotes s as a DOT string
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// Graph returns a workflow's DAG with its steps' live statuses and
+// durations
+func (e *Engine) Graph(ctx context.Context, id string) (Graph, error) {
+	w, err := e.config.Store.Get(ctx, id)
+	if err != nil {
+		return Graph{}, err
+	}
+	return w.Graph(e.config.Now()), nil
+}
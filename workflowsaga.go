@@ -0,0 +1,56 @@
+package workflow
+
+import (
+	"time"
+
+	"github.com/yourusername/distributed-task-queue/internal/task"
+)
+
+// CompensatedResultField is the payload field holding the result of the
+// step a compensation task undoes
+const CompensatedResultField = "result"
+
+// nextCompensation returns the completed step that finished last among
+// those with a compensation task that has not run yet
+func (w *Workflow) nextCompensation() (Step, bool) {
+	var next Step
+	var nextAt time.Time
+	found := false
+	for _, s := range w.Steps {
+		state := w.State[s.Name]
+		if s.Compensate == nil || state.Status != StepCompleted || state.Compensation != nil || state.FinishedAt == nil {
+			continue
+		}
+		// Steps are in definition order, so of steps finishing together the
+		// later one is undone first
+		if !found || !state.FinishedAt.Before(nextAt) {
+			next, nextAt, found = s, *state.FinishedAt, true
+		}
+	}
+	return next, found
+}
+
+// compensate runs a failed workflow's compensation tasks one at a time,
+// undoing the completed steps latest first, and fails the workflow once
+// they have all finished. A compensation task that fails does not stop the
+// rest. It returns the task to submit, if any.
+func (e *Engine) compensate(w *Workflow, now time.Time) []*task.Task {
+	for _, state := range w.State {
+		if c := state.Compensation; c != nil && !c.Status.Terminal() {
+			return nil
+		}
+	}
+
+	s, ok := w.nextCompensation()
+	if !ok {
+		w.Status = StatusFailed
+		w.UpdatedAt = now
+		w.FinishedAt = &now
+		return nil
+	}
+	state := w.State[s.Name]
+	result := state.outcome(s.ForEach != "")["result"]
+	t := e.newTask(w, s.Name, *s.Compensate, map[string]interface{}{CompensatedResultField: result})
+	state.Compensation = &StepTask{ID: t.ID, Status: t.Status}
+	return []*task.Task{t}
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+	"github.com/yourusername/distributed-task-queue/internal/workflow"
+	"go.uber.org/zap"
+)
+
+// workflowEngine returns the configured workflow engine, or responds 404
+// and returns nil
+func (s *Server) workflowEngine(w http.ResponseWriter, r *http.Request) *workflow.Engine {
+	if s.config.Workflows == nil {
+		s.respondError(w, r, http.StatusNotFound, errs.CodeInvalidRequest, "no workflow engine is configured")
+	}
+	return s.config.Workflows
+}
+
+// handleListWorkflows lists every workflow and its progress
+func (s *Server) handleListWorkflows(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	list, err := engine.List(r.Context())
+	if err != nil {
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, WorkflowsResponse{Workflows: list})
+}
+
+// handleCreateWorkflow starts a workflow from a definition in JSON or YAML,
+// or from a named definition
+func (s *Server) handleCreateWorkflow(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	if err := yamlBody(r); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	var req WorkflowRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	var wf *workflow.Workflow
+	var err error
+	if req.Definition != "" {
+		if len(req.Steps) > 0 {
+			s.respondErr(w, r, errs.Invalidf("give either definition or steps, not both"))
+			return
+		}
+		wf, err = engine.StartDefinition(r.Context(), req.Definition, req.Input)
+	} else {
+		wf, err = engine.Start(r.Context(), req.Workflow())
+	}
+	if err != nil {
+		err = workflowStoreErr(err)
+		if errors.Is(err, errs.ErrStorageUnavailable) {
+			s.logger.Error("failed to start workflow", zap.Error(err))
+		}
+		s.respondErr(w, r, err)
+		return
+	}
+	s.respondJSON(w, r, http.StatusCreated, wf)
+}
+
+// handleGetWorkflow returns a workflow and the progress of its steps
+func (s *Server) handleGetWorkflow(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	wf, err := engine.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, wf)
+}
+
+// handleWorkflowGraph returns a workflow's DAG with its steps' live
+// statuses and durations as JSON, or as Graphviz DOT with ?format=dot
+func (s *Server) handleWorkflowGraph(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		s.respondErr(w, r, errs.Invalidf("format must be json or dot"))
+		return
+	}
+	graph, err := engine.Graph(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		if err := graph.WriteDOT(w); err != nil {
+			s.logger.Debug("failed to write workflow graph", zap.Error(err))
+		}
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, graph)
+}
+
+// handleCancelWorkflow cancels a running workflow and its running tasks
+func (s *Server) handleCancelWorkflow(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	wf, err := engine.Cancel(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, wf)
+}
+
+// handleResumeWorkflow runs a failed or cancelled workflow again from the
+// steps that did not complete
+func (s *Server) handleResumeWorkflow(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	wf, err := engine.Resume(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, wf)
+}
+
+// handleApproveWorkflowStep approves or rejects a waiting manual approval
+// step
+func (s *Server) handleApproveWorkflowStep(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	var req ApprovalRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	wf, err := engine.Approve(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "step"), req.Decision, req.By, req.Comment)
+	if err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, wf)
+}
+
+// handleDeleteWorkflow deletes a workflow. Tasks it already submitted are
+// left alone.
+func (s *Server) handleDeleteWorkflow(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := engine.Delete(r.Context(), id); err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.logger.Info("workflow deleted", zap.String("workflow", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListDefinitions lists every workflow definition
+func (s *Server) handleListDefinitions(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	list, err := engine.ListDefinitions(r.Context())
+	if err != nil {
+		s.respondErr(w, r, fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, DefinitionsResponse{Definitions: list})
+}
+
+// handleGetDefinition returns a workflow definition
+func (s *Server) handleGetDefinition(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	d, err := engine.GetDefinition(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, d)
+}
+
+// handlePutDefinition creates or replaces a workflow definition, in JSON
+// or YAML
+func (s *Server) handlePutDefinition(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	if err := yamlBody(r); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+	var req DefinitionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		s.respondErr(w, r, err)
+		return
+	}
+
+	d, err := engine.PutDefinition(r.Context(), &workflow.Definition{
+		Name:        chi.URLParam(r, "name"),
+		Description: req.Description,
+		Input:       req.Input,
+		Steps:       req.Steps,
+	})
+	if err != nil {
+		err = workflowStoreErr(err)
+		if errors.Is(err, errs.ErrStorageUnavailable) {
+			s.logger.Error("failed to save workflow definition", zap.Error(err))
+		}
+		s.respondErr(w, r, err)
+		return
+	}
+	s.respondJSON(w, r, http.StatusOK, d)
+}
+
+// handleDeleteDefinition deletes a workflow definition. Workflows already
+// started from it carry on.
+func (s *Server) handleDeleteDefinition(w http.ResponseWriter, r *http.Request) {
+	engine := s.workflowEngine(w, r)
+	if engine == nil {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if err := engine.DeleteDefinition(r.Context(), name); err != nil {
+		s.respondErr(w, r, workflowStoreErr(err))
+		return
+	}
+	s.logger.Info("workflow definition deleted", zap.String("name", name))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// yamlBody converts a request body sent with a YAML content type to JSON,
+// for decodeJSON
+func yamlBody(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+	default:
+		return nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var sizeErr *http.MaxBytesError
+		if errors.As(err, &sizeErr) {
+			return errs.ErrPayloadTooLarge
+		}
+		return errs.Invalidf("failed to read request body")
+	}
+	converted, err := workflow.YAMLToJSON(data)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(converted))
+	return nil
+}
+
+// workflowStoreErr passes typed errors, such as ErrWorkflowNotFound or a
+// definition's validation error, through and reports anything else as the
+// store being unavailable
+func workflowStoreErr(err error) error {
+	var typed *errs.Error
+	if errors.As(err, &typed) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err)
+}
@@ -0,0 +1,422 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourusername/distributed-task-queue/internal/errs"
+)
+
+const (
+	workflowsKey   = "workflows"
+	definitionsKey = "workflow_definitions"
+	// maxUpdateAttempts bounds how often Update retries when another
+	// process changed the workflow first
+	maxUpdateAttempts = 20
+)
+
+// Store keeps workflows
+type Store interface {
+	// Put creates or replaces a workflow
+	Put(ctx context.Context, w *Workflow) error
+	// Get returns a workflow, or errs.ErrWorkflowNotFound
+	Get(ctx context.Context, id string) (*Workflow, error)
+	// List returns every workflow, oldest first
+	List(ctx context.Context) ([]*Workflow, error)
+	// Delete removes a workflow, or returns errs.ErrWorkflowNotFound
+	Delete(ctx context.Context, id string) error
+	// Update applies change to a workflow and stores the result, unless
+	// change fails. Concurrent updates of the same workflow do not
+	// overwrite each other; change may be called again with the newer
+	// workflow, so it must not have side effects.
+	Update(ctx context.Context, id string, change func(*Workflow) error) (*Workflow, error)
+
+	// PutDefinition creates or replaces a workflow definition
+	PutDefinition(ctx context.Context, d *Definition) error
+	// GetDefinition returns a workflow definition, or
+	// errs.ErrDefinitionNotFound
+	GetDefinition(ctx context.Context, name string) (*Definition, error)
+	// ListDefinitions returns every workflow definition, by name
+	ListDefinitions(ctx context.Context) ([]*Definition, error)
+	// DeleteDefinition removes a workflow definition, or returns
+	// errs.ErrDefinitionNotFound
+	DeleteDefinition(ctx context.Context, name string) error
+}
+
+func notFound(id string) error {
+	return fmt.Errorf("%w: %s", errs.ErrWorkflowNotFound, id)
+}
+
+func definitionNotFound(name string) error {
+	return fmt.Errorf("%w: %s", errs.ErrDefinitionNotFound, name)
+}
+
+func sortDefinitions(list []*Definition) {
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+}
+
+func sortWorkflows(list []*Workflow) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// workflowKey is the Redis key holding a workflow
+func workflowKey(id string) string {
+	return "workflow:" + id
+}
+
+// definitionKey is the Redis key holding a workflow definition
+func definitionKey(name string) string {
+	return "workflow_definition:" + name
+}
+
+// RedisStore keeps each workflow under its own key, so updates to one are
+// checked with WATCH without contending with the rest, and their IDs in a
+// set. Definitions are kept likewise, by name.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a workflow store on client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (r *RedisStore) Put(ctx context.Context, w *Workflow) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow: %w", err)
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, workflowKey(w.ID), data, 0)
+	pipe.SAdd(ctx, workflowsKey, w.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save workflow: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) Get(ctx context.Context, id string) (*Workflow, error) {
+	return r.get(ctx, r.client, id)
+}
+
+// get reads a workflow with c, a client or a transaction
+func (r *RedisStore) get(ctx context.Context, c redis.Cmdable, id string) (*Workflow, error) {
+	data, err := c.Get(ctx, workflowKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, notFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	var w Workflow
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow %s: %w", id, err)
+	}
+	return &w, nil
+}
+
+func (r *RedisStore) List(ctx context.Context) ([]*Workflow, error) {
+	ids, err := r.client.SMembers(ctx, workflowsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflows: %w", err)
+	}
+	list := make([]*Workflow, 0, len(ids))
+	if len(ids) == 0 {
+		return list, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = workflowKey(id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflows: %w", err)
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var w Workflow
+		if err := json.Unmarshal([]byte(data), &w); err != nil {
+			return nil, fmt.Errorf("failed to parse workflow %s: %w", ids[i], err)
+		}
+		list = append(list, &w)
+	}
+	sortWorkflows(list)
+	return list, nil
+}
+
+func (r *RedisStore) Delete(ctx context.Context, id string) error {
+	pipe := r.client.TxPipeline()
+	del := pipe.Del(ctx, workflowKey(id))
+	pipe.SRem(ctx, workflowsKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete workflow: %w", err)
+	}
+	if del.Val() == 0 {
+		return notFound(id)
+	}
+	return nil
+}
+
+func (r *RedisStore) Update(ctx context.Context, id string, change func(*Workflow) error) (*Workflow, error) {
+	key := workflowKey(id)
+	var updated *Workflow
+	for i := 0; i < maxUpdateAttempts; i++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			w, err := r.get(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := change(w); err != nil {
+				return err
+			}
+			data, err := json.Marshal(w)
+			if err != nil {
+				return fmt.Errorf("failed to marshal workflow: %w", err)
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				return nil
+			})
+			updated = w
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updated, nil
+	}
+	return nil, fmt.Errorf("failed to update workflow %s: too many concurrent updates", id)
+}
+
+func (r *RedisStore) PutDefinition(ctx context.Context, d *Definition) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow definition: %w", err)
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, definitionKey(d.Name), data, 0)
+	pipe.SAdd(ctx, definitionsKey, d.Name)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save workflow definition: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) GetDefinition(ctx context.Context, name string) (*Definition, error) {
+	data, err := r.client.Get(ctx, definitionKey(name)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, definitionNotFound(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow definition: %w", err)
+	}
+	var d Definition
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow definition %s: %w", name, err)
+	}
+	return &d, nil
+}
+
+func (r *RedisStore) ListDefinitions(ctx context.Context) ([]*Definition, error) {
+	names, err := r.client.SMembers(ctx, definitionsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflow definitions: %w", err)
+	}
+	list := make([]*Definition, 0, len(names))
+	if len(names) == 0 {
+		return list, nil
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = definitionKey(name)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflow definitions: %w", err)
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var d Definition
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return nil, fmt.Errorf("failed to parse workflow definition %s: %w", names[i], err)
+		}
+		list = append(list, &d)
+	}
+	sortDefinitions(list)
+	return list, nil
+}
+
+func (r *RedisStore) DeleteDefinition(ctx context.Context, name string) error {
+	pipe := r.client.TxPipeline()
+	del := pipe.Del(ctx, definitionKey(name))
+	pipe.SRem(ctx, definitionsKey, name)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete workflow definition: %w", err)
+	}
+	if del.Val() == 0 {
+		return definitionNotFound(name)
+	}
+	return nil
+}
+
+// MemoryStore keeps workflows in memory, for tests and single-process
+// deployments. They are kept serialized, so callers never share their maps
+// and slices.
+type MemoryStore struct {
+	mu          sync.Mutex
+	workflows   map[string][]byte
+	definitions map[string][]byte
+}
+
+// NewMemoryStore creates an in-memory workflow store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{workflows: make(map[string][]byte), definitions: make(map[string][]byte)}
+}
+
+func (m *MemoryStore) Put(ctx context.Context, w *Workflow) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workflows[w.ID] = data
+	return nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Workflow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(id)
+}
+
+// get decodes a workflow. Must be called with m.mu held.
+func (m *MemoryStore) get(id string) (*Workflow, error) {
+	data, ok := m.workflows[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	var w Workflow
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow %s: %w", id, err)
+	}
+	return &w, nil
+}
+
+func (m *MemoryStore) List(ctx context.Context) ([]*Workflow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*Workflow, 0, len(m.workflows))
+	for id := range m.workflows {
+		w, err := m.get(id)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, w)
+	}
+	sortWorkflows(list)
+	return list, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.workflows[id]; !ok {
+		return notFound(id)
+	}
+	delete(m.workflows, id)
+	return nil
+}
+
+func (m *MemoryStore) Update(ctx context.Context, id string, change func(*Workflow) error) (*Workflow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := change(w); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workflow: %w", err)
+	}
+	m.workflows[id] = data
+	return w, nil
+}
+
+func (m *MemoryStore) PutDefinition(ctx context.Context, d *Definition) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow definition: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.definitions[d.Name] = data
+	return nil
+}
+
+func (m *MemoryStore) GetDefinition(ctx context.Context, name string) (*Definition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getDefinition(name)
+}
+
+// getDefinition decodes a workflow definition. Must be called with m.mu
+// held.
+func (m *MemoryStore) getDefinition(name string) (*Definition, error) {
+	data, ok := m.definitions[name]
+	if !ok {
+		return nil, definitionNotFound(name)
+	}
+	var d Definition
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow definition %s: %w", name, err)
+	}
+	return &d, nil
+}
+
+func (m *MemoryStore) ListDefinitions(ctx context.Context) ([]*Definition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*Definition, 0, len(m.definitions))
+	for name := range m.definitions {
+		d, err := m.getDefinition(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	sortDefinitions(list)
+	return list, nil
+}
+
+func (m *MemoryStore) DeleteDefinition(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.definitions[name]; !ok {
+		return definitionNotFound(name)
+	}
+	delete(m.definitions, name)
+	return nil
+}